@@ -15,27 +15,48 @@ package options
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/authorization/path"
+	"k8s.io/apiserver/pkg/authorization/union"
 	openapinamer "k8s.io/apiserver/pkg/endpoints/openapi"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/metrics-server/pkg/api"
 	generatedopenapi "sigs.k8s.io/metrics-server/pkg/api/generated/openapi"
+	"sigs.k8s.io/metrics-server/pkg/api/rpc"
+	"sigs.k8s.io/metrics-server/pkg/remotewrite"
 	"sigs.k8s.io/metrics-server/pkg/scraper"
 	"sigs.k8s.io/metrics-server/pkg/server"
+	"sigs.k8s.io/metrics-server/pkg/storage"
 	"sigs.k8s.io/metrics-server/pkg/utils"
 	"sigs.k8s.io/metrics-server/pkg/version"
 )
 
+const (
+	cpuPrecisionMilli = "milli"
+	cpuPrecisionNano  = "nano"
+)
+
 type Options struct {
 	// genericoptions.ReccomendedOptions - EtcdOptions
 	SecureServing  *genericoptions.SecureServingOptionsWithLoopback
@@ -43,20 +64,153 @@ type Options struct {
 	Authorization  *genericoptions.DelegatingAuthorizationOptions
 	Features       *genericoptions.FeatureOptions
 
-	Kubeconfig string
+	Kubeconfig      string
+	APIServerCAFile string
 
 	// Only to be used to for testing
 	DisableAuthForTesting bool
 
-	MetricResolution time.Duration
+	MetricResolution        time.Duration
+	ScrapeCycleDeadline     time.Duration
+	ScrapeErrorLogInterval  time.Duration
+	ScrapePhaseOffset       time.Duration
+	ScrapePhaseSeed         string
+	MaxConcurrentScrapes    int
+	MaxContainersPerNode    int
+	MaxPodsPerNode          int
+	RequireBothCPUMemory    bool
+	SynthesizeNodeMetrics   bool
+	MaxUsageOverAllocatable float64
+	DiscardEmptyCycles      bool
+	MinCycleNodeFraction    float64
+	MinCycleSuccessRatio    float64
+	MaxInformerStaleness    time.Duration
+	InformerResyncPeriod    time.Duration
+	InformerBackoffCap      time.Duration
+	MaxKubeletClockSkew     time.Duration
+	MinSampleInterval       time.Duration
+	ReorderSamples          bool
+	TimestampSource         string
+	ScrapeOrder             string
+	MemoryMetric            string
+	DuplicateSeriesPolicy   string
+	ScrapeResources         []string
+	CPUStaleness            time.Duration
+	MemoryStaleness         time.Duration
+	MemoryReport            string
+	NodeUsageSource         string
+	NodeKey                 string
+	PodUIDChange            string
+	EvictOnPodDelete        bool
+	InformerBatchWindow     time.Duration
+	ContainerNameNormalize  string
+	ExcludeSandbox          bool
+	SandboxContainerNames   []string
+	ExcludeContainerNames   []string
+	CPURounding             string
+	MemoryRounding          string
+	MemoryMinDelta          string
+	CPUReportPrecision      string
+	ExcludePodNamespaces    []string
+	MaxSelectorRequirements int
+	APIResponseCacheTTL     time.Duration
+	DiscoveryCacheTTL       time.Duration
+	MinHealthyNodesFraction float64
+	IncludePodQOS           bool
+	IncludePodPriority      bool
+	IncludeRestartCounts    bool
+	IncludeNodePodStats     bool
+	IncludeUsageDeltas      bool
+	FlagUsageOverRequests   bool
+	ExcludeEphemeral        bool
+	PodMetricsEchoLabels    []string
+	PartialPodMetrics       string
+	PodAggregation          string
+	SkipTerminalPhasePods   bool
+	VerifyPodExistence      bool
+	DropOrphanedPodMetrics  bool
+	SuppressCordonedNodes   bool
+	NodeExistenceGrace      time.Duration
+	EnableSelfCheck         bool
+	PerResourceReadiness    bool
+	MonitorAPIService       bool
+	UpdateAPIServiceCA      bool
+
+	FollowerProxyToLeader          bool
+	LeaderElectionLease            string
+	LeaderElectionAdvertiseAddress string
+	FollowerProxyCAFile            string
+	RestartGracePeriod             time.Duration
+	RestartGraceLease              string
+
+	RemoteWriteURL             string
+	RemoteWriteBearerTokenFile string
+	RemoteWriteUsername        string
+	RemoteWritePasswordFile    string
+	RemoteWriteTimeout         time.Duration
+
+	UnauthenticatedLivez  bool
+	AuthenticationTimeout time.Duration
+	AuthorizationTimeout  time.Duration
+	PathPrefix            string
+	RequestFairness       bool
+	MaxGetRequests        int
+	MaxListRequests       int
+	MaxListResponseBytes  int
+
+	MaxRequestsInFlight         int
+	MaxMutatingRequestsInFlight int
+	EnableDebugEndpoints        bool
+	EnableGRPC                  bool
+	EnableSSE                   bool
+	ExposeCumulativeCPU         bool
+	EnableRawPayloadCache       bool
+	DebugDumpScrapes            bool
 
 	KubeletUseNodeStatusPort     bool
 	KubeletPort                  int
 	InsecureKubeletTLS           bool
 	KubeletPreferredAddressTypes []string
+	AddressTypeMappingFile       string
+	NodeAddressFile              string
 	KubeletCAFile                string
+	KubeletCADir                 string
+	KubeletCARotationGrace       time.Duration
 	KubeletClientKeyFile         string
 	KubeletClientCertFile        string
+	KubeletScrapeViaAPIServer    bool
+	DedupNodeAddresses           bool
+	PinNodeAddresses             bool
+	KubeletServingCertSNI        string
+	KubeletFollowRedirects       string
+	KubeletMaxRedirects          int
+	KubeletSuccessStatusCodes    []int
+	AllowPartialNodeScrape       bool
+	KubeletSniffGzip             bool
+	TryAllAddressTypes           bool
+	AddressFallbackOn            []string
+	KubeletDialTimeout           time.Duration
+	KubeletRequestTimeout        time.Duration
+	KubeletParseTimeout          time.Duration
+	KubeletExtraHeaders          map[string]string
+	KubeletBasicAuthUser         string
+	KubeletBasicAuthPasswordFile string
+	KubeletHTTP2                 bool
+	KubeletHTTP2MaxStreams       int
+	ControlPlaneScrapeOverride   map[string]string
+	UseNodeLeaseForLiveness      bool
+	NodeLeaseStaleThreshold      time.Duration
+	SkipTaintedNodes             []string
+	ScrapeNodeSampleFraction     float64
+
+	VirtualKubeletSelector        string
+	VirtualKubeletPort            int
+	VirtualKubeletSummaryPath     string
+	VirtualKubeletBearerTokenFile string
+
+	SecondaryScrapePort      int
+	SecondaryScrapePath      string
+	SecondaryScrapeResources []string
 
 	ShowVersion bool
 
@@ -66,16 +220,146 @@ type Options struct {
 func (o *Options) Flags(cmd *cobra.Command) {
 	flags := cmd.Flags()
 	flags.DurationVar(&o.MetricResolution, "metric-resolution", o.MetricResolution, "The resolution at which metrics-server will retain metrics.")
+	flags.DurationVar(&o.MaxInformerStaleness, "max-informer-staleness", o.MaxInformerStaleness, "The maximum time metrics will be served from the last synced node and pod snapshot after losing connection to the Kubernetes API server. Zero means no limit.")
+	flags.DurationVar(&o.InformerResyncPeriod, "informer-resync-period", o.InformerResyncPeriod, "The period at which the node and pod informers resync their whole cache. Resyncing costs CPU proportional to the cluster size, and metrics-server doesn't need it to pick up changes, so zero disables periodic resync.")
+	flags.DurationVar(&o.InformerBackoffCap, "informer-backoff-cap", o.InformerBackoffCap, "Delay the lists of the node and pod informers after consecutive failures, doubling the delay from 1s up to this cap, on top of the client-go backoff, to damp relists against a flaky API server. Watches and periodic resyncs aren't delayed. Zero disables the delay.")
+	flags.DurationVar(&o.ScrapeCycleDeadline, "scrape-cycle-deadline", o.ScrapeCycleDeadline, "The maximum duration of a scrape cycle, after which outstanding node scrapes are canceled and reported as failed. Must not exceed --metric-resolution. Zero means --metric-resolution.")
+	flags.DurationVar(&o.ScrapeErrorLogInterval, "scrape-error-log-interval", o.ScrapeErrorLogInterval, "Log the scrape errors of each node separately, logging an error unchanged since it was last logged at most once per this interval, with the number of times it repeated. A changed error is logged right away. Zero logs all scrape errors of every cycle.")
+	flags.DurationVar(&o.ScrapePhaseOffset, "scrape-phase-offset", o.ScrapePhaseOffset, "Start scrape cycles this long past a multiple of --metric-resolution since the Unix epoch, e.g. 0s and 30s for two replicas with a 60s resolution, so that replicas don't scrape Kubelets at the same time. Must be less than --metric-resolution. Zero starts the first cycle right away, unless --scrape-phase-seed is set.")
+	flags.StringVar(&o.ScrapePhaseSeed, "scrape-phase-seed", o.ScrapePhaseSeed, "Derive the scrape phase offset from a hash of this value instead of --scrape-phase-offset, e.g. $(POD_NAME) from the downward API, so that replicas of a Deployment get distinct offsets.")
+	flags.IntVar(&o.MaxConcurrentScrapes, "max-concurrent-scrapes", o.MaxConcurrentScrapes, "The maximum number of Kubelets scraped at the same time. Zero means no limit.")
+	flags.IntVar(&o.MaxContainersPerNode, "max-containers-per-node", o.MaxContainersPerNode, "The maximum number of containers accepted in the metrics of a single node. Nodes reporting more are rejected as malformed. Zero means no limit.")
+	flags.IntVar(&o.MaxPodsPerNode, "max-pods-per-node-aggregation", o.MaxPodsPerNode, "The maximum number of pods aggregated from the metrics of a single node. Pods past it are dropped with a warning, so that a node packed with pods doesn't delay the scrape cycle. Zero means no limit.")
+	flags.BoolVar(&o.RequireBothCPUMemory, "require-both-cpu-memory", o.RequireBothCPUMemory, "Skip nodes reporting only one of CPU and memory usage. If false, such nodes are served with the reported resource only, and annotated with the missing one in "+api.MissingResourcesAnnotation+".")
+	flags.BoolVar(&o.SynthesizeNodeMetrics, "synthesize-missing-node-metrics", o.SynthesizeNodeMetrics, "Serve nodes whose Kubelet reports container metrics but no usable node metrics with the summed usage of their containers, leaving out usage outside of containers. Resources missing from any container are left out of the node.")
+	flags.Float64Var(&o.MaxUsageOverAllocatable, "max-usage-over-allocatable", o.MaxUsageOverAllocatable, "Exclude the CPU or memory usage of a node or container exceeding the allocatable of the node by more than this factor, e.g. 10, as bad data. Excluded usage is logged and not served, nodes left without usage are dropped. Zero disables the check.")
+	flags.BoolVar(&o.DiscardEmptyCycles, "discard-empty-cycles", o.DiscardEmptyCycles, "Discard scrape cycles returning metrics for no node, or for less than --min-cycle-node-fraction of the nodes, and keep serving the previous metrics until a cycle is applied, instead of replacing them with the cycle results.")
+	flags.Float64Var(&o.MinCycleNodeFraction, "min-cycle-node-fraction", o.MinCycleNodeFraction, "The fraction of nodes, between 0 and 1, a scrape cycle has to return metrics for to be applied when --discard-empty-cycles is set. Zero means only cycles without any node are discarded.")
+	flags.Float64Var(&o.MinCycleSuccessRatio, "min-cycle-success-ratio", o.MinCycleSuccessRatio, "The fraction of nodes, between 0 and 1, a scrape cycle has to return metrics for to be healthy. Unhealthy cycles are still applied, but fail readiness until a healthy cycle completes. Zero disables the check.")
+	flags.DurationVar(&o.MaxKubeletClockSkew, "max-kubelet-clock-skew", o.MaxKubeletClockSkew, "Reject metrics whose Kubelet timestamp is further than this from the metrics-server clock, in either direction. Zero disables the check.")
+	flags.DurationVar(&o.MinSampleInterval, "min-sample-interval", o.MinSampleInterval, "Ignore metrics of a node or container timestamped less than this after the stored metrics, e.g. when overlapping scrapes return samples over a very short window. The stored metrics are served until a sample is far enough apart. Zero stores all metrics.")
+	flags.BoolVar(&o.ReorderSamples, "reorder-samples", o.ReorderSamples, "Ignore metrics of a node or container timestamped before the stored metrics, e.g. when overlapping scrapes return out of order, so that served metrics never go back in time. The stored metrics are served until a newer sample is scraped.")
+	flags.StringVar(&o.TimestampSource, "timestamp-source", o.TimestampSource, "Where to take metrics timestamps from, one of: series (use the timestamps reported by Kubelet, failing nodes which report none), receive (use the time metrics-server received the metrics).")
+	flags.StringVar(&o.ScrapeOrder, "scrape-order", o.ScrapeOrder, "Order nodes are scraped in within a cycle, one of: listed (in the order of the node informer, each delayed by a random stagger), stale-first (the nodes whose latest successful scrape is the oldest first, so that cycles which can't scrape every node in time spread staleness evenly).")
+	flags.StringVar(&o.MemoryMetric, "memory-metric", o.MemoryMetric, "Memory series reported by Kubelet to serve as memory usage, one of: working-set (memory the Kubelet and kernel account against limits when evicting and OOM killing), rss (resident set size, leaving out page cache).")
+	flags.StringVar(&o.DuplicateSeriesPolicy, "duplicate-series-policy", o.DuplicateSeriesPolicy, "Which series to store for a container reported more than once in a Kubelet summary, one of: first, last, max (highest CPU and highest memory usage among the series). Pods reported more than once are merged.")
+	flags.StringSliceVar(&o.ScrapeResources, "scrape-resources", o.ScrapeResources, "Resources parsed from Kubelet summaries and served, any of: cpu, memory. Series of other resources are skipped while parsing, and nodes, as well as pods under --partial-pod-metrics=flag, are annotated with them in "+api.MissingResourcesAnnotation+".")
+	flags.DurationVar(&o.CPUStaleness, "cpu-staleness-threshold", o.CPUStaleness, "How long before a scrape Kubelet may have sampled the CPU usage of a node or container for it to be served. Staler CPU usage is left out while fresh memory usage is still served, and nodes, as well as pods under --partial-pod-metrics=flag, are annotated with "+api.MissingResourcesAnnotation+". Zero disables the check.")
+	flags.DurationVar(&o.MemoryStaleness, "memory-staleness-threshold", o.MemoryStaleness, "How long before a scrape Kubelet may have sampled the memory usage of a node or container for it to be served. Staler memory usage is left out while fresh CPU usage is still served, and nodes, as well as pods under --partial-pod-metrics=flag, are annotated with "+api.MissingResourcesAnnotation+". Zero disables the check.")
+	flags.StringVar(&o.MemoryReport, "memory-report", o.MemoryReport, "How to report the memory working set, one of: raw (serve usage as reported by Kubelet, including sharp drops), smoothed (serve a moving average over recent scrapes to avoid flapping, e.g. after page cache reclaim).")
+	flags.StringVar(&o.NodeUsageSource, "node-usage-source", o.NodeUsageSource, "Where to take node usage from, one of: kubelet (serve the node usage reported by Kubelet, including system daemons and other processes outside of pods), sum-of-pods (serve the summed usage of the pods reported by the node).")
+	flags.StringVar(&o.NodeKey, "node-key", o.NodeKey, "The identity stored node metrics are matched by across scrapes, e.g. to smooth memory usage, one of: name (the node name), provider-id (the provider ID, so that a node recreated under a new name keeps its history, falling back to the name for nodes without one). Metrics are served by node name either way.")
+	flags.StringVar(&o.PodUIDChange, "pod-uid-change", o.PodUIDChange, "What to do when a pod is reported under the name of a stored pod with a different UID, one of: ignore (the recreated pod inherits the history of the previous one), report (log and count the change), reset (log and count the change, and drop the history of the previous pod, e.g. for memory smoothing and sample spacing). Pods are stored and served by namespace and name either way.")
+	flags.BoolVar(&o.EvictOnPodDelete, "evict-on-pod-delete", o.EvictOnPodDelete, "Evict the stored metrics of a pod as soon as the pod informer reports it deleted, instead of when the next scrape cycle no longer reports it.")
+	flags.DurationVar(&o.InformerBatchWindow, "informer-event-batch-window", o.InformerBatchWindow, "How long after a pod delete event further deletes are collected before their pods are evicted together with --evict-on-pod-delete, so that bursts of deletes evict in one pass. Eviction is delayed by at most this long. Must not exceed --metric-resolution, zero evicts on every event.")
+	flags.BoolVar(&o.ExcludeSandbox, "exclude-sandbox-container", o.ExcludeSandbox, "Leave sandbox containers named after --sandbox-container-names out of stored pod metrics, so that they don't add to pod usage on runtimes reporting them.")
+	flags.StringSliceVar(&o.SandboxContainerNames, "sandbox-container-names", o.SandboxContainerNames, "Names of the sandbox containers left out of pod metrics when --exclude-sandbox-container is set.")
+	flags.StringSliceVar(&o.ExcludeContainerNames, "exclude-container-names", o.ExcludeContainerNames, "Names of containers left out of stored pod metrics in every pod, e.g. a monitoring sidecar, so that they don't add to pod usage. Names are matched exactly, unless they contain glob metacharacters, e.g. istio-*, which are matched as patterns.")
+	flags.StringVar(&o.ContainerNameNormalize, "container-name-normalize-regex", o.ContainerNameNormalize, "Regular expression matching a suffix stripped from container names before they are stored, e.g. -[0-9a-f]{5} for names varying across restarts. Names are kept as they are where stripping would make containers of a pod share a name. Empty disables normalization.")
+	flags.StringVar(&o.CPURounding, "cpu-rounding", o.CPURounding, "Round served CPU usage to the nearest multiple of this quantity (e.g. 5m). Zero disables rounding.")
+	flags.StringVar(&o.MemoryRounding, "memory-rounding", o.MemoryRounding, "Round served memory usage to the nearest multiple of this quantity (e.g. 1Ki). Zero disables rounding.")
+	flags.StringVar(&o.MemoryMinDelta, "memory-min-delta", o.MemoryMinDelta, "Keep serving the previously served memory usage of each node and container until it changes by more than this quantity (e.g. 1Mi), to suppress small fluctuations for consumers detecting changes. CPU usage is served unchanged. Zero serves every change.")
+	flags.StringVar(&o.CPUReportPrecision, "cpu-report-precision", o.CPUReportPrecision, "Precision of served CPU usage, one of: milli, nano. Nano serves the nanocores collected from Kubelet and preserves sub-millicore usage of small workloads, milli rounds usage to the nearest millicore.")
+	flags.StringSliceVar(&o.ExcludePodNamespaces, "exclude-pod-namespaces", o.ExcludePodNamespaces, "Namespaces whose pods are never served through the PodMetrics API. This is defense-in-depth only, RBAC authorization remains the primary access control.")
+	flags.IntVar(&o.MaxSelectorRequirements, "max-selector-requirements", o.MaxSelectorRequirements, "The maximum number of requirements in label selectors listing PodMetrics. Lists with more complex selectors are rejected. Zero means no limit.")
+	flags.DurationVar(&o.APIResponseCacheTTL, "api-response-cache-ttl", o.APIResponseCacheTTL, "How long NodeMetrics and PodMetrics list responses are cached, so repeated identical lists are served without recomputing them. Cached responses are dropped as soon as new metrics are stored. Zero disables caching.")
+	flags.DurationVar(&o.DiscoveryCacheTTL, "discovery-cache-ttl", o.DiscoveryCacheTTL, "How long the discovery documents of the metrics.k8s.io API group, frequently requested by kubectl and controllers, are cached and served with an ETag. Cached documents are dropped as soon as the served API versions change. Zero disables caching.")
+	flags.Float64Var(&o.MinHealthyNodesFraction, "min-healthy-nodes-fraction", o.MinHealthyNodesFraction, "The fraction of nodes, between 0 and 1, which must have metrics from their latest scrape for NodeMetrics and PodMetrics to be served. Requests fail with 503 Service Unavailable while fewer nodes have metrics, e.g. right after startup or during an outage of many Kubelets. Zero disables the check.")
+	flags.BoolVar(&o.IncludePodQOS, "include-pod-qos", o.IncludePodQOS, "Annotate PodMetrics with the QoS class of the pod under "+api.QOSClassAnnotation+", derived from the current pod spec.")
+	flags.BoolVar(&o.IncludePodPriority, "include-pod-priority", o.IncludePodPriority, "Annotate PodMetrics with the priority of the pod under "+api.PriorityAnnotation+", taken from the current pod spec. Pods without a resolved priority are not annotated.")
+	flags.BoolVar(&o.IncludeRestartCounts, "include-restart-counts", o.IncludeRestartCounts, "Annotate PodMetrics with the restart count of each container under "+api.RestartCountsAnnotation+", e.g. app=3,sidecar=0, taken from the current pod status. Pods without container statuses are not annotated.")
+	flags.BoolVar(&o.IncludeNodePodStats, "include-node-pod-stats", o.IncludeNodePodStats, "Annotate NodeMetrics with the number of pods with metrics on the node under "+api.PodCountAnnotation+", and their summed usage under "+api.PodUsageAnnotation+", e.g. cpu=1200m,memory=3Gi, to compare with the node usage, which also includes system daemons.")
+	flags.BoolVar(&o.IncludeUsageDeltas, "include-usage-deltas", o.IncludeUsageDeltas, "Annotate NodeMetrics and PodMetrics with the change of their usage since the previous point served under "+api.UsageDeltaAnnotation+", e.g. cpu=-20m,memory=4Mi. Pods are annotated with the change of the total usage of their containers. The annotation is missing for the first point served, and spans several scrape cycles for objects not served every cycle.")
+	flags.BoolVar(&o.FlagUsageOverRequests, "flag-usage-over-requests", o.FlagUsageOverRequests, "Annotate PodMetrics with the resources, e.g. cpu,memory, the pod uses more of than it requests under "+api.UsageOverRequestsAnnotation+", and more of than its limits under "+api.UsageOverLimitsAnnotation+", taken from the current pod spec. Usage itself is served unchanged.")
+	flags.BoolVar(&o.ExcludeEphemeral, "exclude-ephemeral-containers", o.ExcludeEphemeral, "Leave ephemeral containers of the pod spec, e.g. debug containers added by kubectl debug, out of served PodMetrics, so they don't count toward pod usage.")
+	flags.StringSliceVar(&o.PodMetricsEchoLabels, "podmetrics-echo-labels", o.PodMetricsEchoLabels, "Pod labels copied to the labels of served PodMetrics, e.g. app,team. Other pod labels are never served.")
+	flags.StringVar(&o.PartialPodMetrics, "partial-pod-metrics", o.PartialPodMetrics, "How to serve pods when metrics are missing for some of their containers, one of: sum (serve reported containers), omit (don't serve the pod), flag (serve reported containers and list missing ones in the metrics.k8s.io/missing-containers annotation, and resources missing from some containers in the metrics.k8s.io/missing-resources annotation).")
+	flags.StringVar(&o.PodAggregation, "pod-aggregation", o.PodAggregation, "How the usage of the containers of a pod is rolled up in table output, e.g. kubectl top pod, one of: sum (total usage, as acted on by autoscalers), max (usage of the busiest container, to spot a single hot container). PodMetrics always list the usage of each container.")
+	flags.BoolVar(&o.SkipTerminalPhasePods, "skip-terminal-phase-pods", o.SkipTerminalPhasePods, "Don't store metrics of pods in the Succeeded or Failed phase, even if Kubelet still reports residual metrics for them, e.g. for completed Job pods.")
+	flags.BoolVar(&o.VerifyPodExistence, "verify-pod-existence", o.VerifyPodExistence, "Check served pods against the Kubernetes API server and omit pods which were deleted, but whose deletion the informer hasn't observed yet. Adds a pod list request to the API server for every PodMetrics request.")
+	flags.BoolVar(&o.DropOrphanedPodMetrics, "drop-orphaned-pod-metrics", o.DropOrphanedPodMetrics, "Omit pods assigned to a node which no longer exists in the node informer, e.g. while the informer still lists the pods of a deleted node.")
+	flags.BoolVar(&o.SuppressCordonedNodes, "suppress-metrics-on-cordoned-nodes", o.SuppressCordonedNodes, "Omit pods assigned to a node marked unschedulable in the node informer, e.g. pods about to be evicted by a drain, so that autoscalers don't react to their usage.")
+	flags.DurationVar(&o.NodeExistenceGrace, "node-existence-grace", o.NodeExistenceGrace, "How long NodeMetrics of a node missing from the node informer, e.g. deleted right after it was scraped, are still served. Beyond the grace, counted from the scrape of the node, its metrics aren't served until they expire from storage. Lists only ever include nodes in the informer. Zero serves stored nodes as long as they are stored.")
+	flags.BoolVar(&o.MonitorAPIService, "monitor-apiservice", o.MonitorAPIService, "Periodically check whether the "+server.APIServiceName+" APIService is available and report it in the metrics_server_apiservice_available and metrics_server_apiservice_errors_total metrics. Requires permission to get apiservices.")
+	flags.BoolVar(&o.UpdateAPIServiceCA, "auto-update-apiservice-cabundle", o.UpdateAPIServiceCA, "Periodically set the caBundle of the "+server.APIServiceName+" APIService to the CA of the serving certificate, the last certificate of the --tls-cert-file chain, so that the aggregator keeps trusting metrics-server after the certificate rotates. APIServices with insecureSkipTLSVerify set are left unchanged. Requires permission to get and update apiservices.")
+	flags.BoolVar(&o.EnableSelfCheck, "enable-self-check", o.EnableSelfCheck, "Periodically read node metrics back from storage and report a failing selfcheck readiness check if none are fresh.")
+	flags.BoolVar(&o.PerResourceReadiness, "per-resource-readiness", o.PerResourceReadiness, "Replace the storage-nonempty readiness check with node-metrics-ready and pod-metrics-ready, reporting on /readyz?verbose whether node and pod metrics are stored. Readiness still requires both, but consumers of a single resource can exclude the other with /readyz?exclude.")
+
+	flags.BoolVar(&o.FollowerProxyToLeader, "follower-proxy-to-leader", o.FollowerProxyToLeader, "Elect a leader among replicas with the --leader-election-lease Lease. Only the leader scrapes Kubelets, the other replicas proxy NodeMetrics and PodMetrics requests to it with their own credentials, so all replicas serve identical metrics. Proxied requests fail with 503 Service Unavailable while the leader is unknown or unreachable. Requires permission to get, create and update the Lease, and for replicas to get and list metrics.k8s.io.")
+	flags.StringVar(&o.LeaderElectionLease, "leader-election-lease", o.LeaderElectionLease, "The namespace/name of the Lease electing the leader when --follower-proxy-to-leader is set.")
+	flags.StringVar(&o.LeaderElectionAdvertiseAddress, "leader-election-advertise-address", o.LeaderElectionAdvertiseAddress, "The host:port of the secure port other replicas reach this replica at, e.g. $(POD_IP):4443, recorded in the Lease when leading. Required by --follower-proxy-to-leader.")
+	flags.StringVar(&o.FollowerProxyCAFile, "follower-proxy-ca-file", o.FollowerProxyCAFile, "Path to the CA verifying the serving certificate of the leader when proxying to it. Defaults to the CA of the Kubernetes API server.")
+	flags.DurationVar(&o.RestartGracePeriod, "restart-grace-period", o.RestartGracePeriod, "Record graceful shutdowns in the --restart-grace-lease Lease, and report ready before the first scrape when starting less than this after one, so that the APIService of a single replica stays available across restarts. No metrics are served until the first scrape completes, and crashes aren't recorded. Running several replicas avoids the gap altogether. Requires permission to get, create and update the Lease. Zero disables the grace period.")
+	flags.StringVar(&o.RestartGraceLease, "restart-grace-lease", o.RestartGraceLease, "The namespace/name of the Lease recording graceful shutdowns when --restart-grace-period is set.")
+
+	flags.BoolVar(&o.UnauthenticatedLivez, "unauthenticated-livez", o.UnauthenticatedLivez, "Serve /livez on the secure port without authentication or authorization, e.g. for load balancer health checks which can't present credentials. Only the liveness result is served, all other paths still require authorization.")
+	flags.DurationVar(&o.AuthenticationTimeout, "authentication-timeout", o.AuthenticationTimeout, "The maximum time to authenticate a request, including TokenReviews sent to the Kubernetes API server. Requests exceeding it fail with 503 Service Unavailable. Zero means no bound.")
+	flags.DurationVar(&o.AuthorizationTimeout, "authorization-timeout", o.AuthorizationTimeout, "The maximum time to authorize a request, including SubjectAccessReviews sent to the Kubernetes API server. Requests exceeding it fail with 503 Service Unavailable. Zero means no bound.")
+	flags.StringVar(&o.PathPrefix, "path-prefix", o.PathPrefix, "A path prefix, e.g. /metrics-server, under which all routes of the secure server are also served, for reverse proxies routing a subpath without rewriting it. Routes are still served without the prefix, since the aggregator requests the fixed /apis/metrics.k8s.io paths of the APIService. RBAC rules for non-resource URLs apply to paths without the prefix.")
+	flags.BoolVar(&o.RequestFairness, "enable-request-fairness", o.RequestFairness, "Limit the concurrent gets and lists of the Metrics API separately to --max-concurrent-get-requests and --max-concurrent-list-requests, so that expensive lists of all pods don't delay gets of single pods and nodes, e.g. from the Horizontal Pod Autoscaler. Requests wait for a free slot of their pool for up to 5s, then fail with 429 Too Many Requests.")
+	flags.IntVar(&o.MaxGetRequests, "max-concurrent-get-requests", o.MaxGetRequests, "The maximum number of gets of the Metrics API served concurrently when --enable-request-fairness is set.")
+	flags.IntVar(&o.MaxListRequests, "max-concurrent-list-requests", o.MaxListRequests, "The maximum number of lists of the Metrics API served concurrently when --enable-request-fairness is set.")
+	flags.IntVar(&o.MaxListResponseBytes, "max-list-response-bytes", o.MaxListResponseBytes, "The maximum size in bytes of NodeMetrics and PodMetrics list responses, as sent after compression. Larger lists fail with 413 Request Entity Too Large, directing clients to list a single namespace or use selectors. Zero means no limit.")
+	flags.IntVar(&o.MaxRequestsInFlight, "max-requests-inflight", o.MaxRequestsInFlight, "The maximum number of non-mutating requests served concurrently, further requests fail with 429 Too Many Requests. Long-running requests, such as the event stream, don't count. Zero means no limit.")
+	flags.IntVar(&o.MaxMutatingRequestsInFlight, "max-mutating-requests-inflight", o.MaxMutatingRequestsInFlight, "The maximum number of mutating requests served concurrently, further requests fail with 429 Too Many Requests. Zero means no limit.")
+	flags.BoolVar(&o.EnableDebugEndpoints, "enable-debug-endpoints", o.EnableDebugEndpoints, "Serve debug endpoints under /debug/metrics-server/, including the current storage contents on /debug/metrics-server/storage, the nodes whose latest scrape failed with the running pods missing metrics on /debug/metrics-server/failures, an allocation profile of a scrape cycle run on request on /debug/metrics-server/cycle-profile, the scheme, host, port and outcome of the latest scrape of each node as versioned JSON for tooling on /debug/metrics-server/targets, and with --max-usage-over-allocatable the node allocatable checked by the latest scrapes compared with the current one on /debug/metrics-server/allocatable. Access requires authorization for the non-resource URLs.")
+	flags.BoolVar(&o.EnableGRPC, "enable-grpc", o.EnableGRPC, "Serve the Metrics gRPC service on the secure port. Access requires authorization for the non-resource URLs under /"+rpc.ServiceName+"/.")
+	flags.BoolVar(&o.EnableSSE, "enable-sse", o.EnableSSE, "Serve a Server-Sent Events stream on /events/metrics on the secure port, pushing the NodeMetrics and PodMetrics which changed after each scrape cycle, preceded by all served metrics. Clients which fall behind get all served metrics in a single event instead of the changes they missed. Access requires authorization for the non-resource URL and to list node and pod metrics in all namespaces.")
+	flags.BoolVar(&o.ExposeCumulativeCPU, "expose-cumulative-cpu", o.ExposeCumulativeCPU, "Serve the latest cumulative CPU counter reported by Kubelet for each container, with the time it was sampled, on /extended/cumulative-cpu on the secure port, so that clients can compute CPU rates over windows of their choice. The usage served by the Metrics API is unchanged. Pods are served from the namespace query parameter, or from all namespaces, except those excluded with --exclude-pod-namespaces. Access requires authorization for the non-resource URL and to list pod metrics in the namespace.")
+	flags.BoolVar(&o.EnableRawPayloadCache, "enable-raw-payload-cache", o.EnableRawPayloadCache, "Keep the latest raw summary payload scraped from each node and serve it on /debug/metrics-server/kubelet-summary/<node>. Requires --enable-debug-endpoints.")
+	flags.BoolVar(&o.DebugDumpScrapes, "debug-dump-scrapes", o.DebugDumpScrapes, fmt.Sprintf("Write the usage of every node and container scraped in each cycle to stdout, one line per node and container, when run with -v=%d or higher.", scraper.DumpVerbosity))
+
+	flags.StringVar(&o.RemoteWriteURL, "remote-write-url", o.RemoteWriteURL, "Prometheus remote write endpoint receiving the node and container usage after every scrape, for long-term retention. Samples are sent in the background and retried on failures, without affecting the metrics API.")
+	flags.StringVar(&o.RemoteWriteBearerTokenFile, "remote-write-bearer-token-file", o.RemoteWriteBearerTokenFile, "Path to a file with the bearer token authenticating remote write requests, read for every request.")
+	flags.StringVar(&o.RemoteWriteUsername, "remote-write-username", o.RemoteWriteUsername, "Username authenticating remote write requests with basic auth. Requires --remote-write-password-file.")
+	flags.StringVar(&o.RemoteWritePasswordFile, "remote-write-password-file", o.RemoteWritePasswordFile, "Path to a file with the basic auth password of --remote-write-username, read for every request.")
+	flags.DurationVar(&o.RemoteWriteTimeout, "remote-write-timeout", o.RemoteWriteTimeout, "The maximum duration of each remote write request.")
 
 	flags.BoolVar(&o.InsecureKubeletTLS, "kubelet-insecure-tls", o.InsecureKubeletTLS, "Do not verify CA of serving certificates presented by Kubelets.  For testing purposes only.")
 	flags.BoolVar(&o.DeprecatedCompletelyInsecureKubelet, "deprecated-kubelet-completely-insecure", o.DeprecatedCompletelyInsecureKubelet, "Do not use any encryption, authorization, or authentication when communicating with the Kubelet.")
-	flags.BoolVar(&o.KubeletUseNodeStatusPort, "kubelet-use-node-status-port", o.KubeletUseNodeStatusPort, "Use the port in the node status. Takes precedence over --kubelet-port flag.")
-	flags.IntVar(&o.KubeletPort, "kubelet-port", o.KubeletPort, "The port to use to connect to Kubelets.")
+	flags.BoolVar(&o.KubeletUseNodeStatusPort, "kubelet-use-node-status-port", o.KubeletUseNodeStatusPort, "Use the port in the node status. Takes precedence over --kubelet-port flag for nodes reporting a port.")
+	flags.IntVar(&o.KubeletPort, "kubelet-port", o.KubeletPort, "The port to use to connect to Kubelets. Used for all nodes, unless --kubelet-use-node-status-port is set and the node reports its Kubelet port in its status.")
 	flags.StringVar(&o.Kubeconfig, "kubeconfig", o.Kubeconfig, "The path to the kubeconfig used to connect to the Kubernetes API server and the Kubelets (defaults to in-cluster config)")
-	flags.StringSliceVar(&o.KubeletPreferredAddressTypes, "kubelet-preferred-address-types", o.KubeletPreferredAddressTypes, "The priority of node address types to use when determining which address to use to connect to a particular node")
-	flags.StringVar(&o.KubeletCAFile, "kubelet-certificate-authority", "", "Path to the CA to use to validate the Kubelet's serving certificates.")
-	flags.StringVar(&o.KubeletClientKeyFile, "kubelet-client-key", "", "Path to a client key file for TLS.")
-	flags.StringVar(&o.KubeletClientCertFile, "kubelet-client-certificate", "", "Path to a client cert file for TLS.")
+	flags.StringVar(&o.APIServerCAFile, "apiserver-ca-file", o.APIServerCAFile, "Path to additional CA certificates trusted when connecting to the Kubernetes API server, on top of the CA of the kubeconfig or in-cluster config. Not used for Kubelet connections. Delegated authentication and authorization use their own clients, configured with --authentication-kubeconfig and --authorization-kubeconfig.")
+	flags.StringSliceVar(&o.KubeletPreferredAddressTypes, "kubelet-preferred-address-types", o.KubeletPreferredAddressTypes, "The priority of node address types to use when determining which address to use to connect to a particular node, one of Hostname, InternalDNS, InternalIP, ExternalDNS or ExternalIP (case-insensitive)")
+	flags.StringVar(&o.AddressTypeMappingFile, "address-type-mapping-file", o.AddressTypeMappingFile, "Path to a YAML file mapping node label selectors to address type priorities. Nodes matching none of the selectors use --kubelet-preferred-address-types.")
+	flags.StringVar(&o.NodeAddressFile, "node-address-file", o.NodeAddressFile, "Path to a YAML file mapping node names to the host or IP used to scrape them, taking precedence over address types. Other nodes are resolved with the address types. The file is reloaded every --metric-resolution.")
+	flags.BoolVar(&o.PinNodeAddresses, "pin-node-addresses", o.PinNodeAddresses, "Scrape each node at the address it was first resolved to, until an update of the node changes its addresses, instead of resolving it every scrape cycle. Addresses listed in --node-address-file still take precedence. Can't be combined with --dedup-node-addresses.")
+	flags.BoolVar(&o.TryAllAddressTypes, "try-all-address-types", o.TryAllAddressTypes, "When scraping the Kubelet of a node fails with an error of --address-fallback-on, retry it in the same cycle on its next address by --kubelet-preferred-address-types, until one succeeds or the scrape of the node times out. Nodes scraped through the API server, and nodes whose address is listed in --node-address-file or pinned by --pin-node-addresses, are not retried.")
+	flags.StringSliceVar(&o.AddressFallbackOn, "address-fallback-on", o.AddressFallbackOn, "The classes of errors after which --try-all-address-types retries a node on its next address, any of: connection (connecting to the Kubelet fails), tls (the serving certificate fails verification or the TLS handshake fails, e.g. because the certificate has no SAN for the address). Other errors, e.g. timeouts of requests which connected, are never retried.")
+	flags.BoolVar(&o.DedupNodeAddresses, "dedup-node-addresses", o.DedupNodeAddresses, "When multiple nodes resolve to the same Kubelet address, fall back to the next address type in --kubelet-preferred-address-types for the colliding nodes, and skip nodes without a distinct address. Otherwise duplicates are only logged.")
+	flags.DurationVar(&o.KubeletDialTimeout, "kubelet-dial-timeout", o.KubeletDialTimeout, "The maximum time to establish a connection to a Kubelet, so unreachable Kubelets fail fast. Zero means connecting is only bounded by the request.")
+	flags.DurationVar(&o.KubeletRequestTimeout, "kubelet-request-timeout", o.KubeletRequestTimeout, "The maximum duration of each Kubelet summary request, including reading the response. Requests are always bounded by the scrape timeout. Zero means no additional bound.")
+	flags.DurationVar(&o.KubeletParseTimeout, "kubelet-parse-timeout", o.KubeletParseTimeout, "The maximum duration of parsing each Kubelet summary once received, so that pathologically large or malformed summaries can't stall the scrape cycle. Nodes whose summary takes longer are failed. Zero means no bound.")
+	flags.StringToStringVar(&o.KubeletExtraHeaders, "kubelet-extra-headers", o.KubeletExtraHeaders, "Headers set on every Kubelet summary request, e.g. X-Tenant-Id=team-a for gateways routing on headers. Values are Go templates executed against the Node object, e.g. X-Route={{.Name}} or X-Zone={{index .Labels \"topology.kubernetes.io/zone\"}}. Header values are never logged.")
+	flags.StringVar(&o.KubeletBasicAuthUser, "kubelet-basic-auth-user", o.KubeletBasicAuthUser, "User authenticating Kubelet summary requests with HTTP basic auth, e.g. for proxies in front of Kubelets. Basic auth replaces the bearer token of the Kubelet client credentials, client certificates are still used. Requires --kubelet-basic-auth-password-file.")
+	flags.StringVar(&o.KubeletBasicAuthPasswordFile, "kubelet-basic-auth-password-file", o.KubeletBasicAuthPasswordFile, "Path to a file with the basic auth password of --kubelet-basic-auth-user, read for every request. The password is never logged.")
+	flags.BoolVar(&o.KubeletHTTP2, "kubelet-http2", o.KubeletHTTP2, "Negotiate HTTP/2 with Kubelets over TLS, multiplexing requests over one connection per Kubelet. Kubelets not negotiating HTTP/2 are scraped over HTTP/1.1. Otherwise only HTTP/1.1 is used. Doesn't apply to --kubelet-scrape-via-apiserver.")
+	flags.IntVar(&o.KubeletHTTP2MaxStreams, "kubelet-http2-max-streams", o.KubeletHTTP2MaxStreams, "The maximum number of concurrent requests to each Kubelet when --kubelet-http2 is set, further requests wait for a free stream. Zero means no limit.")
+	flags.StringToStringVar(&o.ControlPlaneScrapeOverride, "control-plane-scrape-override", o.ControlPlaneScrapeOverride, "Scheme and port used to scrape Kubelets of nodes with the "+scraper.ControlPlaneRoleLabel+" label, e.g. scheme=https,port=10260. Takes precedence over --kubelet-port and --kubelet-use-node-status-port, other nodes use the defaults.")
+	flags.BoolVar(&o.UseNodeLeaseForLiveness, "use-node-lease-for-liveness", o.UseNodeLeaseForLiveness, "Skip scraping nodes whose lease in the kube-node-lease namespace wasn't renewed within --node-lease-stale-threshold. Nodes without a lease are still scraped, and so are nodes with a fresh lease, whatever their Ready condition. Requires permission to list and watch leases in kube-node-lease.")
+	flags.DurationVar(&o.NodeLeaseStaleThreshold, "node-lease-stale-threshold", o.NodeLeaseStaleThreshold, "The age of a node lease after which the node isn't scraped, when --use-node-lease-for-liveness is set.")
+	flags.StringSliceVar(&o.SkipTaintedNodes, "skip-tainted-nodes", o.SkipTaintedNodes, "Taint keys of nodes which aren't scraped, e.g. node.kubernetes.io/unschedulable to skip cordoned nodes during maintenance. Metrics of skipped nodes and their pods are reported as missing.")
+	flags.Float64Var(&o.ScrapeNodeSampleFraction, "scrape-node-sample-fraction", o.ScrapeNodeSampleFraction, "The fraction of nodes, between 0 and 1, which are scraped, e.g. to canary a new version on a subset of the cluster. Nodes are selected by a hash of their names, so the same nodes are scraped every cycle. Metrics of other nodes and their pods are absent. One scrapes all nodes.")
+	flags.StringVar(&o.VirtualKubeletSelector, "virtual-kubelet-selector", o.VirtualKubeletSelector, "Label selector of virtual-kubelet nodes, e.g. "+scraper.VirtualKubeletSelector+", which are scraped on --virtual-kubelet-port and --virtual-kubelet-summary-path instead of the Kubelet defaults. Empty means all nodes are scraped as Kubelets.")
+	flags.IntVar(&o.VirtualKubeletPort, "virtual-kubelet-port", o.VirtualKubeletPort, "The port used to scrape nodes matching --virtual-kubelet-selector.")
+	flags.StringVar(&o.VirtualKubeletSummaryPath, "virtual-kubelet-summary-path", o.VirtualKubeletSummaryPath, "The path of the summary API on nodes matching --virtual-kubelet-selector.")
+	flags.StringVar(&o.VirtualKubeletBearerTokenFile, "virtual-kubelet-bearer-token-file", o.VirtualKubeletBearerTokenFile, "Path to a bearer token authenticating to nodes matching --virtual-kubelet-selector, instead of the Kubelet client credentials.")
+	flags.IntVar(&o.SecondaryScrapePort, "secondary-scrape-port", o.SecondaryScrapePort, "The port of an exporter serving summaries on each node, e.g. a sidecar reporting series Kubelet lacks, scraped after the Kubelet on the Kubelet address with the same scheme, credentials and timeouts. Series of --secondary-scrape-resources missing from the Kubelet summary are merged into it, Kubelet series are never replaced. Failing to scrape the exporter doesn't fail the node. Zero disables the secondary scrape.")
+	flags.StringVar(&o.SecondaryScrapePath, "secondary-scrape-path", o.SecondaryScrapePath, "The path of the summary API of the exporter on --secondary-scrape-port.")
+	flags.StringSliceVar(&o.SecondaryScrapeResources, "secondary-scrape-resources", o.SecondaryScrapeResources, "Resources merged from the summaries of the exporter on --secondary-scrape-port, any of: cpu, memory.")
+	flags.StringVar(&o.KubeletServingCertSNI, "kubelet-serving-cert-sni", o.KubeletServingCertSNI, "The server name Kubelet serving certificates are verified against, one of: address (the address used to scrape the Kubelet), nodename (the name of the Node object, for certificates issued for node DNS names).")
+	flags.StringVar(&o.KubeletFollowRedirects, "kubelet-follow-redirects", o.KubeletFollowRedirects, "Which redirects of Kubelet responses are followed, one of: none, same-host (redirects to the host and port of the request, e.g. through a gateway, without downgrading HTTPS to HTTP), any. Nodes whose Kubelet sends a refused redirect are failed.")
+	flags.IntVar(&o.KubeletMaxRedirects, "kubelet-max-redirects", o.KubeletMaxRedirects, "The maximum number of redirects followed for each Kubelet request under --kubelet-follow-redirects.")
+	flags.IntSliceVar(&o.KubeletSuccessStatusCodes, "kubelet-success-status-codes", o.KubeletSuccessStatusCodes, "The 2xx response status codes accepted from Kubelets, e.g. 204 for proxies responding without content. 200 is always accepted. Responses with another accepted code and no body report no metrics for the node, without failing the scrape.")
+	flags.BoolVar(&o.AllowPartialNodeScrape, "allow-partial-node-scrape", o.AllowPartialNodeScrape, "Keep the node and the pods preceding the truncation of Kubelet summaries truncated mid-stream, e.g. by flaky connections, instead of failing the node. Dropped pods are reported as missing, and truncations are counted in the metrics_server_kubelet_partial_summaries_total metric.")
+	flags.BoolVar(&o.KubeletSniffGzip, "kubelet-sniff-gzip", o.KubeletSniffGzip, "Decompress Kubelet responses starting with the gzip magic bytes even without a gzip Content-Encoding header, e.g. from proxies dropping the header. Uncompressed responses are parsed as they are.")
+	flags.StringVar(&o.KubeletCAFile, "kubelet-certificate-authority", "", "Path to the CA to use to validate the Kubelet's serving certificates. Takes precedence over the CA file and data of the kubeconfig.")
+	flags.StringVar(&o.KubeletCADir, "kubelet-certificate-authority-dir", "", "Path to a directory of PEM files whose certificates are all trusted to validate the Kubelet's serving certificates, e.g. the old and new roots during CA rotation. Files without certificates are skipped, and changes are picked up within a minute.")
+	flags.DurationVar(&o.KubeletCARotationGrace, "kubelet-ca-rotation-grace", o.KubeletCARotationGrace, "How long the certificates replaced in --kubelet-certificate-authority-dir are still trusted after the change is picked up, so that Kubelets serving certificates of the previous CA keep being scraped while a rotation rolls out. Afterwards only the current certificates are trusted. Zero stops trusting replaced certificates right away.")
+	flags.StringVar(&o.KubeletClientKeyFile, "kubelet-client-key", "", "Path to a client key file for TLS. Takes precedence over the client key file and data of the kubeconfig.")
+	flags.StringVar(&o.KubeletClientCertFile, "kubelet-client-certificate", "", "Path to a client cert file for TLS. Takes precedence over the client certificate file and data of the kubeconfig.")
+	flags.BoolVar(&o.KubeletScrapeViaAPIServer, "kubelet-scrape-via-apiserver", o.KubeletScrapeViaAPIServer, "Scrape Kubelets through the Kubernetes API server node proxy instead of connecting to them directly. Requires permission to access nodes/proxy. Kubelet connection flags are ignored.")
 
 	flags.BoolVar(&o.ShowVersion, "version", false, "Show version")
 
@@ -96,7 +380,43 @@ func NewOptions() *Options {
 		Features:       genericoptions.NewFeatureOptions(),
 
 		MetricResolution:             60 * time.Second,
+		MaxContainersPerNode:         10000,
+		MaxPodsPerNode:               5000,
+		RequireBothCPUMemory:         true,
+		CPUReportPrecision:           cpuPrecisionNano,
+		TimestampSource:              string(scraper.TimestampSourceSeries),
+		ScrapeOrder:                  string(scraper.ScrapeOrderListed),
+		MemoryMetric:                 string(scraper.MemoryMetricWorkingSet),
+		DuplicateSeriesPolicy:        string(scraper.DuplicateSeriesFirst),
+		ScrapeResources:              []string{"cpu", "memory"},
+		AddressFallbackOn:            []string{string(scraper.AddressFallbackConnection)},
+		MemoryReport:                 string(storage.MemoryReportRaw),
+		NodeUsageSource:              string(storage.NodeUsageKubelet),
+		NodeKey:                      string(storage.NodeKeyName),
+		PodUIDChange:                 string(storage.PodUIDChangeIgnore),
+		EvictOnPodDelete:             true,
+		SandboxContainerNames:        storage.DefaultSandboxContainerNames,
+		PartialPodMetrics:            string(api.PartialPodSum),
+		PodAggregation:               string(api.PodAggregationSum),
+		ExcludeEphemeral:             true,
 		KubeletPort:                  10250,
+		KubeletServingCertSNI:        string(scraper.ServingCertSNIAddress),
+		KubeletFollowRedirects:       string(scraper.FollowRedirectsSameHost),
+		KubeletMaxRedirects:          10,
+		KubeletSuccessStatusCodes:    []int{http.StatusOK},
+		NodeLeaseStaleThreshold:      40 * time.Second,
+		ScrapeNodeSampleFraction:     1,
+		VirtualKubeletPort:           10250,
+		VirtualKubeletSummaryPath:    "/stats/summary",
+		SecondaryScrapePath:          "/stats/summary",
+		SecondaryScrapeResources:     []string{"cpu", "memory"},
+		RemoteWriteTimeout:           30 * time.Second,
+		MaxGetRequests:               100,
+		MaxListRequests:              10,
+		MaxRequestsInFlight:          400,
+		MaxMutatingRequestsInFlight:  200,
+		LeaderElectionLease:          "kube-system/metrics-server",
+		RestartGraceLease:            "kube-system/metrics-server-restart",
 		KubeletPreferredAddressTypes: make([]string, len(utils.DefaultAddressTypePriority)),
 	}
 
@@ -116,15 +436,613 @@ func (o Options) ServerConfig() (*server.Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	cpuRounding, err := parseRounding(o.CPURounding)
+	if err != nil {
+		return nil, err
+	}
+	memoryRounding, err := parseRounding(o.MemoryRounding)
+	if err != nil {
+		return nil, err
+	}
+	memoryMinDelta, err := parseRounding(o.MemoryMinDelta)
+	if err != nil {
+		return nil, err
+	}
+	containerNameSuffix, err := o.containerNameSuffix()
+	if err != nil {
+		return nil, err
+	}
+	apiserverRest, err := o.withAPIServerCA(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	kubeletRest := restConfig
+	if o.KubeletScrapeViaAPIServer {
+		// Kubelets are reached through the API server, so its CA applies
+		kubeletRest = apiserverRest
+	}
+	for _, warning := range o.kubeletTLSWarnings(kubeletRest) {
+		klog.Warning(warning)
+	}
+	kubelet := o.kubeletConfig(kubeletRest)
+	if o.AddressTypeMappingFile != "" {
+		kubelet.AddressTypeMappings, err = utils.LoadAddressTypeMappings(o.AddressTypeMappingFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load address type mapping file: %v", err)
+		}
+	}
+	if o.NodeAddressFile != "" {
+		kubelet.NodeAddresses, err = utils.LoadNodeAddresses(o.NodeAddressFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load node address file: %v", err)
+		}
+	}
+	if o.PinNodeAddresses {
+		kubelet.AddressPins = utils.NewNodeAddressPins()
+	}
+	var leaderElection *server.LeaderElectionConfig
+	if o.FollowerProxyToLeader {
+		leaderElection, err = o.leaderElectionConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var restartGrace *server.RestartGraceConfig
+	if o.RestartGracePeriod > 0 {
+		restartGrace, err = o.restartGraceConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var phaseOffset *time.Duration
+	if o.ScrapePhaseSeed != "" {
+		offset := server.PhaseOffsetFromSeed(o.ScrapePhaseSeed, o.MetricResolution)
+		phaseOffset = &offset
+	} else if o.ScrapePhaseOffset != 0 {
+		phaseOffset = &o.ScrapePhaseOffset
+	}
 	return &server.Config{
-		Apiserver:        apiserver,
-		Rest:             restConfig,
-		Kubelet:          o.kubeletConfig(restConfig),
-		MetricResolution: o.MetricResolution,
-		ScrapeTimeout:    time.Duration(float64(o.MetricResolution) * 0.90), // scrape timeout is 90% of the scrape interval
+		Apiserver:               apiserver,
+		Rest:                    apiserverRest,
+		Kubelet:                 kubelet,
+		ScrapeStrategies:        o.scrapeStrategies(kubelet),
+		MetricResolution:        o.MetricResolution,
+		ScrapeTimeout:           time.Duration(float64(o.MetricResolution) * 0.90), // scrape timeout is 90% of the scrape interval
+		ScrapeCycleDeadline:     o.ScrapeCycleDeadline,
+		ScrapeErrorLogInterval:  o.ScrapeErrorLogInterval,
+		ScrapePhaseOffset:       phaseOffset,
+		MaxConcurrentScrapes:    o.MaxConcurrentScrapes,
+		MaxContainersPerNode:    o.MaxContainersPerNode,
+		MaxPodsPerNode:          o.MaxPodsPerNode,
+		SingleResourceNodes:     !o.RequireBothCPUMemory,
+		SynthesizeNodeMetrics:   o.SynthesizeNodeMetrics,
+		MaxUsageOverAllocatable: o.MaxUsageOverAllocatable,
+		DiscardEmptyCycles:      o.DiscardEmptyCycles,
+		MinCycleNodeFraction:    o.MinCycleNodeFraction,
+		MinCycleSuccessRatio:    o.MinCycleSuccessRatio,
+		UseNodeLeaseForLiveness: o.UseNodeLeaseForLiveness,
+		NodeLeaseStaleThreshold: o.NodeLeaseStaleThreshold,
+		SkipTaints:              o.SkipTaintedNodes,
+		NodeSampleFraction:      o.ScrapeNodeSampleFraction,
+		MaxInformerStaleness:    o.MaxInformerStaleness,
+		InformerResyncPeriod:    o.InformerResyncPeriod,
+		InformerBackoffCap:      o.InformerBackoffCap,
+		CPURoundingMillis:       cpuRounding.MilliValue(),
+		MemoryRoundingBytes:     memoryRounding.Value(),
+		MemoryMinDeltaBytes:     memoryMinDelta.Value(),
+		CPUMilliPrecision:       o.CPUReportPrecision == cpuPrecisionMilli,
+		ExcludePodNamespaces:    o.ExcludePodNamespaces,
+		MaxSelectorRequirements: o.MaxSelectorRequirements,
+		APIResponseCacheTTL:     o.APIResponseCacheTTL,
+		DiscoveryCacheTTL:       o.DiscoveryCacheTTL,
+		MinHealthyNodesFraction: o.MinHealthyNodesFraction,
+		IncludePodQOS:           o.IncludePodQOS,
+		IncludePodPriority:      o.IncludePodPriority,
+		IncludeRestartCounts:    o.IncludeRestartCounts,
+		IncludeNodePodStats:     o.IncludeNodePodStats,
+		IncludeUsageDeltas:      o.IncludeUsageDeltas,
+		FlagUsageOverRequests:   o.FlagUsageOverRequests,
+		SkipEphemeralContainers: o.ExcludeEphemeral,
+		EchoPodLabels:           o.PodMetricsEchoLabels,
+		PartialPodPolicy:        api.PartialPodPolicy(o.PartialPodMetrics),
+		PodAggregation:          api.PodAggregation(o.PodAggregation),
+		SkipTerminalPhasePods:   o.SkipTerminalPhasePods,
+		VerifyPodExistence:      o.VerifyPodExistence,
+		DropOrphanedPodMetrics:  o.DropOrphanedPodMetrics,
+		SuppressCordonedNodes:   o.SuppressCordonedNodes,
+		NodeExistenceGrace:      o.NodeExistenceGrace,
+		EnableSelfCheck:         o.EnableSelfCheck,
+		PerResourceReadiness:    o.PerResourceReadiness,
+		MonitorAPIService:       o.MonitorAPIService,
+		UpdateAPIServiceCA:      o.UpdateAPIServiceCA,
+		LeaderElection:          leaderElection,
+		RestartGrace:            restartGrace,
+		RemoteWrite: remotewrite.Config{
+			URL:             o.RemoteWriteURL,
+			BearerTokenFile: o.RemoteWriteBearerTokenFile,
+			Username:        o.RemoteWriteUsername,
+			PasswordFile:    o.RemoteWritePasswordFile,
+			Timeout:         o.RemoteWriteTimeout,
+		},
+		MaxKubeletClockSkew:   o.MaxKubeletClockSkew,
+		MinSampleInterval:     o.MinSampleInterval,
+		ReorderSamples:        o.ReorderSamples,
+		TimestampSource:       scraper.TimestampSource(o.TimestampSource),
+		ScrapeOrder:           scraper.ScrapeOrder(o.ScrapeOrder),
+		MemoryMetric:          scraper.MemoryMetric(o.MemoryMetric),
+		DuplicateSeriesPolicy: scraper.DuplicateSeriesPolicy(o.DuplicateSeriesPolicy),
+		CPUStaleness:          o.CPUStaleness,
+		MemoryStaleness:       o.MemoryStaleness,
+		MemoryReport:          storage.MemoryReport(o.MemoryReport),
+		NodeUsageSource:       storage.NodeUsageSource(o.NodeUsageSource),
+		NodeKey:               storage.NodeKey(o.NodeKey),
+		PodUIDChange:          storage.PodUIDChange(o.PodUIDChange),
+		EvictOnPodDelete:      o.EvictOnPodDelete,
+		InformerBatchWindow:   o.InformerBatchWindow,
+		ContainerNameSuffix:   containerNameSuffix,
+		SandboxContainers:     o.sandboxContainers(),
+		ExcludedContainers:    o.ExcludeContainerNames,
+		AuthenticationTimeout: o.AuthenticationTimeout,
+		AuthorizationTimeout:  o.AuthorizationTimeout,
+		PathPrefix:            o.PathPrefix,
+		RequestFairness:       o.RequestFairness,
+		MaxGetRequests:        o.MaxGetRequests,
+		MaxListRequests:       o.MaxListRequests,
+		MaxListResponseBytes:  o.MaxListResponseBytes,
+		EnableDebugEndpoints:  o.EnableDebugEndpoints,
+		EnableGRPC:            o.EnableGRPC,
+		EnableSSE:             o.EnableSSE,
+		ExposeCumulativeCPU:   o.ExposeCumulativeCPU,
+		EnableRawPayloadCache: o.EnableRawPayloadCache,
+		DebugDumpScrapes:      o.DebugDumpScrapes,
+	}, nil
+}
+
+// Validate checks that the options are consistent and within allowed ranges.
+func (o Options) Validate() []error {
+	var errs []error
+	if o.KubeletPort < 1 || o.KubeletPort > 65535 {
+		errs = append(errs, fmt.Errorf("kubelet-port should be between 1 and 65535, but value %d provided", o.KubeletPort))
+	}
+	if o.InformerResyncPeriod < 0 {
+		errs = append(errs, fmt.Errorf("informer-resync-period should be a non-negative duration, but value %v provided", o.InformerResyncPeriod))
+	}
+	if o.InformerBackoffCap < 0 {
+		errs = append(errs, fmt.Errorf("informer-backoff-cap should be a non-negative duration, but value %v provided", o.InformerBackoffCap))
+	}
+	if o.MaxInformerStaleness < 0 {
+		errs = append(errs, fmt.Errorf("max-informer-staleness should be a non-negative duration, but value %v provided", o.MaxInformerStaleness))
+	}
+	for _, addrType := range o.KubeletPreferredAddressTypes {
+		if _, err := utils.ParseAddressType(addrType); err != nil {
+			errs = append(errs, fmt.Errorf("kubelet-preferred-address-types %v", err))
+		}
+	}
+	if o.AddressTypeMappingFile != "" {
+		if _, err := utils.LoadAddressTypeMappings(o.AddressTypeMappingFile); err != nil {
+			errs = append(errs, fmt.Errorf("address-type-mapping-file %q is invalid: %v", o.AddressTypeMappingFile, err))
+		}
+	}
+	if o.KubeletCADir != "" {
+		if o.KubeletCAFile != "" || o.InsecureKubeletTLS {
+			errs = append(errs, fmt.Errorf("kubelet-certificate-authority-dir can't be combined with kubelet-certificate-authority or kubelet-insecure-tls"))
+		}
+		if _, err := scraper.LoadCADir(o.KubeletCADir); err != nil {
+			errs = append(errs, fmt.Errorf("kubelet-certificate-authority-dir %q is invalid: %v", o.KubeletCADir, err))
+		}
+	}
+	if o.KubeletCARotationGrace < 0 {
+		errs = append(errs, fmt.Errorf("kubelet-ca-rotation-grace should be a non-negative duration, but value %v provided", o.KubeletCARotationGrace))
+	}
+	if o.KubeletCARotationGrace > 0 && o.KubeletCADir == "" {
+		errs = append(errs, fmt.Errorf("kubelet-ca-rotation-grace requires kubelet-certificate-authority-dir"))
+	}
+	if o.NodeAddressFile != "" {
+		if _, err := utils.LoadNodeAddresses(o.NodeAddressFile); err != nil {
+			errs = append(errs, fmt.Errorf("node-address-file %q is invalid: %v", o.NodeAddressFile, err))
+		}
+	}
+	if o.PinNodeAddresses && o.DedupNodeAddresses {
+		// fallbacks resolve copies of colliding nodes, which would get their pin
+		errs = append(errs, fmt.Errorf("pin-node-addresses can't be combined with dedup-node-addresses"))
+	}
+	if o.InformerBatchWindow < 0 || o.InformerBatchWindow > o.MetricResolution {
+		errs = append(errs, fmt.Errorf("informer-event-batch-window should be between 0 and metric-resolution (%v), but value %v provided", o.MetricResolution, o.InformerBatchWindow))
+	}
+	if o.ScrapeCycleDeadline < 0 || o.ScrapeCycleDeadline > o.MetricResolution {
+		errs = append(errs, fmt.Errorf("scrape-cycle-deadline should be between 0 and metric-resolution (%v), but value %v provided", o.MetricResolution, o.ScrapeCycleDeadline))
+	}
+	if o.ScrapeErrorLogInterval < 0 {
+		errs = append(errs, fmt.Errorf("scrape-error-log-interval should be a non-negative duration, but value %v provided", o.ScrapeErrorLogInterval))
+	}
+	if o.ScrapePhaseOffset < 0 || o.ScrapePhaseOffset >= o.MetricResolution {
+		errs = append(errs, fmt.Errorf("scrape-phase-offset should be at least 0 and less than metric-resolution (%v), but value %v provided", o.MetricResolution, o.ScrapePhaseOffset))
+	}
+	if o.ScrapePhaseSeed != "" && o.ScrapePhaseOffset != 0 {
+		errs = append(errs, fmt.Errorf("scrape-phase-seed can't be combined with scrape-phase-offset"))
+	}
+	if o.MaxConcurrentScrapes < 0 {
+		errs = append(errs, fmt.Errorf("max-concurrent-scrapes should be a non-negative integer, but value %d provided", o.MaxConcurrentScrapes))
+	}
+	if o.MaxPodsPerNode < 0 {
+		errs = append(errs, fmt.Errorf("max-pods-per-node-aggregation should be a non-negative integer, but value %d provided", o.MaxPodsPerNode))
+	}
+	if o.MaxContainersPerNode < 0 {
+		errs = append(errs, fmt.Errorf("max-containers-per-node should be a non-negative integer, but value %d provided", o.MaxContainersPerNode))
+	}
+	if o.MinCycleNodeFraction < 0 || o.MinCycleNodeFraction > 1 {
+		errs = append(errs, fmt.Errorf("min-cycle-node-fraction should be between 0 and 1, but value %v provided", o.MinCycleNodeFraction))
+	}
+	if o.MinCycleSuccessRatio < 0 || o.MinCycleSuccessRatio > 1 {
+		errs = append(errs, fmt.Errorf("min-cycle-success-ratio should be between 0 and 1, but value %v provided", o.MinCycleSuccessRatio))
+	}
+	if o.PathPrefix != "" && (!strings.HasPrefix(o.PathPrefix, "/") || strings.HasSuffix(o.PathPrefix, "/")) {
+		errs = append(errs, fmt.Errorf("path-prefix should start with a slash and not end with one, but value %q provided", o.PathPrefix))
+	}
+	if o.RequestFairness && o.MaxGetRequests < 1 {
+		errs = append(errs, fmt.Errorf("max-concurrent-get-requests should be a positive integer, but value %d provided", o.MaxGetRequests))
+	}
+	if o.MaxListResponseBytes < 0 {
+		errs = append(errs, fmt.Errorf("max-list-response-bytes should be a non-negative integer, but value %d provided", o.MaxListResponseBytes))
+	}
+	if o.RequestFairness && o.MaxListRequests < 1 {
+		errs = append(errs, fmt.Errorf("max-concurrent-list-requests should be a positive integer, but value %d provided", o.MaxListRequests))
+	}
+	if o.MaxUsageOverAllocatable < 0 {
+		errs = append(errs, fmt.Errorf("max-usage-over-allocatable should be a non-negative number, but value %v provided", o.MaxUsageOverAllocatable))
+	}
+	if o.MaxRequestsInFlight < 0 {
+		errs = append(errs, fmt.Errorf("max-requests-inflight should be a non-negative integer, but value %d provided", o.MaxRequestsInFlight))
+	}
+	if o.MaxMutatingRequestsInFlight < 0 {
+		errs = append(errs, fmt.Errorf("max-mutating-requests-inflight should be a non-negative integer, but value %d provided", o.MaxMutatingRequestsInFlight))
+	}
+	if o.NodeExistenceGrace < 0 {
+		errs = append(errs, fmt.Errorf("node-existence-grace should be a non-negative duration, but value %v provided", o.NodeExistenceGrace))
+	}
+	if o.AuthenticationTimeout < 0 {
+		errs = append(errs, fmt.Errorf("authentication-timeout should be a non-negative duration, but value %v provided", o.AuthenticationTimeout))
+	}
+	if o.AuthorizationTimeout < 0 {
+		errs = append(errs, fmt.Errorf("authorization-timeout should be a non-negative duration, but value %v provided", o.AuthorizationTimeout))
+	}
+	if o.KubeletDialTimeout < 0 {
+		errs = append(errs, fmt.Errorf("kubelet-dial-timeout should be a non-negative duration, but value %v provided", o.KubeletDialTimeout))
+	}
+	if o.KubeletRequestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("kubelet-request-timeout should be a non-negative duration, but value %v provided", o.KubeletRequestTimeout))
+	}
+	if o.KubeletParseTimeout < 0 {
+		errs = append(errs, fmt.Errorf("kubelet-parse-timeout should be a non-negative duration, but value %v provided", o.KubeletParseTimeout))
+	}
+	if o.KubeletHTTP2MaxStreams < 0 {
+		errs = append(errs, fmt.Errorf("kubelet-http2-max-streams should be a non-negative integer, but value %d provided", o.KubeletHTTP2MaxStreams))
+	}
+	if o.UseNodeLeaseForLiveness && o.NodeLeaseStaleThreshold <= 0 {
+		errs = append(errs, fmt.Errorf("node-lease-stale-threshold should be a positive duration, but value %v provided", o.NodeLeaseStaleThreshold))
+	}
+	if o.MaxSelectorRequirements < 0 {
+		errs = append(errs, fmt.Errorf("max-selector-requirements should be a non-negative integer, but value %d provided", o.MaxSelectorRequirements))
+	}
+	if o.MinSampleInterval < 0 {
+		errs = append(errs, fmt.Errorf("min-sample-interval should be a non-negative duration, but value %v provided", o.MinSampleInterval))
+	}
+	if o.MaxKubeletClockSkew < 0 {
+		errs = append(errs, fmt.Errorf("max-kubelet-clock-skew should be a non-negative duration, but value %v provided", o.MaxKubeletClockSkew))
+	}
+	if _, err := parseScrapeTargetOverride(scraper.ControlPlaneRoleLabel, o.ControlPlaneScrapeOverride); err != nil {
+		errs = append(errs, fmt.Errorf("control-plane-scrape-override %v", err))
+	}
+	if _, err := scraper.ParseRequestHeaders(o.KubeletExtraHeaders); err != nil {
+		errs = append(errs, fmt.Errorf("kubelet-extra-headers %v", err))
+	}
+	if (o.KubeletBasicAuthUser == "") != (o.KubeletBasicAuthPasswordFile == "") {
+		errs = append(errs, fmt.Errorf("kubelet-basic-auth-user and kubelet-basic-auth-password-file must be set together"))
+	}
+	if o.KubeletBasicAuthUser != "" && o.DeprecatedCompletelyInsecureKubelet {
+		errs = append(errs, fmt.Errorf("kubelet-basic-auth-user and deprecated-kubelet-completely-insecure are mutually exclusive"))
+	}
+	if o.KubeletBasicAuthUser != "" && o.KubeletScrapeViaAPIServer {
+		errs = append(errs, fmt.Errorf("kubelet-basic-auth-user and kubelet-scrape-via-apiserver are mutually exclusive"))
+	}
+	if o.VirtualKubeletSelector != "" {
+		if _, err := labels.Parse(o.VirtualKubeletSelector); err != nil {
+			errs = append(errs, fmt.Errorf("virtual-kubelet-selector %v", err))
+		}
+		if o.VirtualKubeletPort < 1 || o.VirtualKubeletPort > 65535 {
+			errs = append(errs, fmt.Errorf("virtual-kubelet-port should be between 1 and 65535, but value %d provided", o.VirtualKubeletPort))
+		}
+		if !strings.HasPrefix(o.VirtualKubeletSummaryPath, "/") {
+			errs = append(errs, fmt.Errorf("virtual-kubelet-summary-path should be an absolute path, but value %q provided", o.VirtualKubeletSummaryPath))
+		}
+	}
+	if _, err := parseRounding(o.CPURounding); err != nil {
+		errs = append(errs, fmt.Errorf("cpu-rounding %v", err))
+	}
+	if _, err := parseRounding(o.MemoryRounding); err != nil {
+		errs = append(errs, fmt.Errorf("memory-rounding %v", err))
+	}
+	if _, err := parseRounding(o.MemoryMinDelta); err != nil {
+		errs = append(errs, fmt.Errorf("memory-min-delta %v", err))
+	}
+	if o.CPUReportPrecision != cpuPrecisionMilli && o.CPUReportPrecision != cpuPrecisionNano {
+		errs = append(errs, fmt.Errorf("cpu-report-precision should be one of %q or %q, but value %q provided", cpuPrecisionMilli, cpuPrecisionNano, o.CPUReportPrecision))
+	}
+	if o.ScrapeNodeSampleFraction < 0 || o.ScrapeNodeSampleFraction > 1 {
+		errs = append(errs, fmt.Errorf("scrape-node-sample-fraction should be between 0 and 1, but value %v provided", o.ScrapeNodeSampleFraction))
+	}
+	for _, key := range o.SkipTaintedNodes {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Errorf("skip-tainted-nodes %q: %s", key, msg))
+		}
+	}
+	for _, key := range o.PodMetricsEchoLabels {
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, fmt.Errorf("podmetrics-echo-labels %q: %s", key, msg))
+		}
+	}
+	errs = append(errs, o.validateRemoteWrite()...)
+	if o.FollowerProxyToLeader {
+		if _, err := o.leaderElectionConfig(); err != nil {
+			errs = append(errs, err)
+		}
+		if o.EnableSelfCheck {
+			errs = append(errs, fmt.Errorf("enable-self-check can't be combined with follower-proxy-to-leader, since followers don't store metrics"))
+		}
+	}
+	if o.RestartGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("restart-grace-period should be a non-negative duration, but value %v provided", o.RestartGracePeriod))
+	}
+	if o.RestartGracePeriod > 0 {
+		if _, err := o.restartGraceConfig(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if o.APIResponseCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("api-response-cache-ttl should be a non-negative duration, but value %v provided", o.APIResponseCacheTTL))
+	}
+	if o.DiscoveryCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("discovery-cache-ttl should be a non-negative duration, but value %v provided", o.DiscoveryCacheTTL))
+	}
+	if o.MinHealthyNodesFraction < 0 || o.MinHealthyNodesFraction > 1 {
+		errs = append(errs, fmt.Errorf("min-healthy-nodes-fraction should be between 0 and 1, but value %v provided", o.MinHealthyNodesFraction))
+	}
+	for _, code := range o.KubeletSuccessStatusCodes {
+		if code < 200 || code > 299 {
+			errs = append(errs, fmt.Errorf("kubelet-success-status-codes should only contain 2xx status codes, but value %d provided", code))
+		}
+	}
+	switch scraper.ServingCertSNI(o.KubeletServingCertSNI) {
+	case scraper.ServingCertSNIAddress, scraper.ServingCertSNINodeName:
+	default:
+		errs = append(errs, fmt.Errorf("kubelet-serving-cert-sni should be one of %q or %q, but value %q provided", scraper.ServingCertSNIAddress, scraper.ServingCertSNINodeName, o.KubeletServingCertSNI))
+	}
+	switch scraper.FollowRedirects(o.KubeletFollowRedirects) {
+	case scraper.FollowRedirectsNone, scraper.FollowRedirectsSameHost, scraper.FollowRedirectsAny:
+	default:
+		errs = append(errs, fmt.Errorf("kubelet-follow-redirects should be one of %q, %q or %q, but value %q provided", scraper.FollowRedirectsNone, scraper.FollowRedirectsSameHost, scraper.FollowRedirectsAny, o.KubeletFollowRedirects))
+	}
+	if o.KubeletMaxRedirects < 0 {
+		errs = append(errs, fmt.Errorf("kubelet-max-redirects should be a non-negative integer, but value %d provided", o.KubeletMaxRedirects))
+	}
+	switch scraper.TimestampSource(o.TimestampSource) {
+	case scraper.TimestampSourceSeries, scraper.TimestampSourceReceive:
+	default:
+		errs = append(errs, fmt.Errorf("timestamp-source should be one of %q or %q, but value %q provided", scraper.TimestampSourceSeries, scraper.TimestampSourceReceive, o.TimestampSource))
+	}
+	switch scraper.ScrapeOrder(o.ScrapeOrder) {
+	case scraper.ScrapeOrderListed, scraper.ScrapeOrderStaleFirst:
+	default:
+		errs = append(errs, fmt.Errorf("scrape-order should be one of %q or %q, but value %q provided", scraper.ScrapeOrderListed, scraper.ScrapeOrderStaleFirst, o.ScrapeOrder))
+	}
+	switch scraper.MemoryMetric(o.MemoryMetric) {
+	case scraper.MemoryMetricWorkingSet, scraper.MemoryMetricRSS:
+	default:
+		errs = append(errs, fmt.Errorf("memory-metric should be one of %q or %q, but value %q provided", scraper.MemoryMetricWorkingSet, scraper.MemoryMetricRSS, o.MemoryMetric))
+	}
+	if _, err := scraper.ParseResources(o.ScrapeResources); err != nil {
+		errs = append(errs, fmt.Errorf("scrape-resources is invalid: %v", err))
+	}
+	if _, err := scraper.ParseAddressFallbackClasses(o.AddressFallbackOn); err != nil {
+		errs = append(errs, fmt.Errorf("address-fallback-on is invalid: %v", err))
+	}
+	if o.SecondaryScrapePort != 0 {
+		if o.SecondaryScrapePort < 1 || o.SecondaryScrapePort > 65535 {
+			errs = append(errs, fmt.Errorf("secondary-scrape-port should be between 1 and 65535, but value %d provided", o.SecondaryScrapePort))
+		}
+		if !strings.HasPrefix(o.SecondaryScrapePath, "/") {
+			errs = append(errs, fmt.Errorf("secondary-scrape-path should be an absolute path, but value %q provided", o.SecondaryScrapePath))
+		}
+		if _, err := scraper.ParseResources(o.SecondaryScrapeResources); err != nil {
+			errs = append(errs, fmt.Errorf("secondary-scrape-resources is invalid: %v", err))
+		}
+	}
+	switch scraper.DuplicateSeriesPolicy(o.DuplicateSeriesPolicy) {
+	case scraper.DuplicateSeriesFirst, scraper.DuplicateSeriesLast, scraper.DuplicateSeriesMax:
+	default:
+		errs = append(errs, fmt.Errorf("duplicate-series-policy should be one of %q, %q or %q, but value %q provided", scraper.DuplicateSeriesFirst, scraper.DuplicateSeriesLast, scraper.DuplicateSeriesMax, o.DuplicateSeriesPolicy))
+	}
+	if o.CPUStaleness < 0 {
+		errs = append(errs, fmt.Errorf("cpu-staleness-threshold should be a non-negative duration, but value %v provided", o.CPUStaleness))
+	}
+	if o.MemoryStaleness < 0 {
+		errs = append(errs, fmt.Errorf("memory-staleness-threshold should be a non-negative duration, but value %v provided", o.MemoryStaleness))
+	}
+	if _, err := o.containerNameSuffix(); err != nil {
+		errs = append(errs, err)
+	}
+	if o.ExcludeSandbox && len(o.SandboxContainerNames) == 0 {
+		errs = append(errs, fmt.Errorf("exclude-sandbox-container requires at least one sandbox-container-names"))
+	}
+	for _, name := range o.ExcludeContainerNames {
+		if err := storage.ValidateContainerNamePattern(name); err != nil {
+			errs = append(errs, fmt.Errorf("exclude-container-names should be container names or glob patterns, but value %q provided: %v", name, err))
+		}
+	}
+	switch storage.MemoryReport(o.MemoryReport) {
+	case storage.MemoryReportRaw, storage.MemoryReportSmoothed:
+	default:
+		errs = append(errs, fmt.Errorf("memory-report should be one of %q or %q, but value %q provided", storage.MemoryReportRaw, storage.MemoryReportSmoothed, o.MemoryReport))
+	}
+	switch storage.NodeUsageSource(o.NodeUsageSource) {
+	case storage.NodeUsageKubelet, storage.NodeUsageSumOfPods:
+	default:
+		errs = append(errs, fmt.Errorf("node-usage-source should be one of %q or %q, but value %q provided", storage.NodeUsageKubelet, storage.NodeUsageSumOfPods, o.NodeUsageSource))
+	}
+	switch storage.NodeKey(o.NodeKey) {
+	case storage.NodeKeyName, storage.NodeKeyProviderID:
+	default:
+		errs = append(errs, fmt.Errorf("node-key should be one of %q or %q, but value %q provided", storage.NodeKeyName, storage.NodeKeyProviderID, o.NodeKey))
+	}
+	switch storage.PodUIDChange(o.PodUIDChange) {
+	case storage.PodUIDChangeIgnore, storage.PodUIDChangeReport, storage.PodUIDChangeReset:
+	default:
+		errs = append(errs, fmt.Errorf("pod-uid-change should be one of %q, %q or %q, but value %q provided", storage.PodUIDChangeIgnore, storage.PodUIDChangeReport, storage.PodUIDChangeReset, o.PodUIDChange))
+	}
+	switch api.PartialPodPolicy(o.PartialPodMetrics) {
+	case api.PartialPodSum, api.PartialPodOmit, api.PartialPodFlag:
+	default:
+		errs = append(errs, fmt.Errorf("partial-pod-metrics should be one of %q, %q or %q, but value %q provided", api.PartialPodSum, api.PartialPodOmit, api.PartialPodFlag, o.PartialPodMetrics))
+	}
+	switch api.PodAggregation(o.PodAggregation) {
+	case api.PodAggregationSum, api.PodAggregationMax:
+	default:
+		errs = append(errs, fmt.Errorf("pod-aggregation should be one of %q or %q, but value %q provided", api.PodAggregationSum, api.PodAggregationMax, o.PodAggregation))
+	}
+	if o.EnableRawPayloadCache && !o.EnableDebugEndpoints {
+		errs = append(errs, fmt.Errorf("enable-raw-payload-cache requires enable-debug-endpoints"))
+	}
+	return errs
+}
+
+// parseRounding parses a rounding granularity, treating an empty value as no rounding.
+// containerNameSuffix compiles the container name normalization regex, nil if
+// normalization is disabled.
+func (o Options) containerNameSuffix() (*regexp.Regexp, error) {
+	if o.ContainerNameNormalize == "" {
+		return nil, nil
+	}
+	suffix, err := storage.ContainerNameSuffix(o.ContainerNameNormalize)
+	if err != nil {
+		return nil, fmt.Errorf("container-name-normalize-regex should be a regular expression, but value %q provided: %v", o.ContainerNameNormalize, err)
+	}
+	return suffix, nil
+}
+
+// sandboxContainers returns the names of the sandbox containers left out of
+// pod metrics, nil unless they are excluded.
+func (o Options) sandboxContainers() []string {
+	if !o.ExcludeSandbox {
+		return nil
+	}
+	return o.SandboxContainerNames
+}
+
+func parseRounding(value string) (resource.Quantity, error) {
+	if len(value) == 0 {
+		return resource.Quantity{}, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return q, fmt.Errorf("should be a quantity, but value %q provided: %v", value, err)
+	}
+	if q.Sign() < 0 {
+		return q, fmt.Errorf("should be a non-negative quantity, but value %q provided", value)
+	}
+	return q, nil
+}
+
+// leaderElectionConfig parses the Lease and the advertised address.
+func (o Options) leaderElectionConfig() (*server.LeaderElectionConfig, error) {
+	parts, ok := splitLease(o.LeaderElectionLease)
+	if !ok {
+		return nil, fmt.Errorf("leader-election-lease should be a namespace/name, but value %q provided", o.LeaderElectionLease)
+	}
+	if host, port, err := net.SplitHostPort(o.LeaderElectionAdvertiseAddress); err != nil || host == "" || port == "" {
+		return nil, fmt.Errorf("leader-election-advertise-address should be a host:port, but value %q provided", o.LeaderElectionAdvertiseAddress)
+	}
+	return &server.LeaderElectionConfig{
+		LeaseNamespace:   parts[0],
+		LeaseName:        parts[1],
+		AdvertiseAddress: o.LeaderElectionAdvertiseAddress,
+		CAFile:           o.FollowerProxyCAFile,
+	}, nil
+}
+
+// restartGraceConfig parses the Lease recording graceful shutdowns.
+func (o Options) restartGraceConfig() (*server.RestartGraceConfig, error) {
+	parts, ok := splitLease(o.RestartGraceLease)
+	if !ok {
+		return nil, fmt.Errorf("restart-grace-lease should be a namespace/name, but value %q provided", o.RestartGraceLease)
+	}
+	return &server.RestartGraceConfig{
+		LeaseNamespace: parts[0],
+		LeaseName:      parts[1],
+		Period:         o.RestartGracePeriod,
 	}, nil
 }
 
+// splitLease splits a namespace/name of a Lease, returning false unless both
+// are valid.
+func splitLease(lease string) ([]string, bool) {
+	parts := strings.Split(lease, "/")
+	if len(parts) != 2 || len(validation.IsDNS1123Subdomain(parts[0])) != 0 || len(validation.IsDNS1123Subdomain(parts[1])) != 0 {
+		return nil, false
+	}
+	return parts, true
+}
+
+func (o Options) validateRemoteWrite() []error {
+	if o.RemoteWriteURL == "" {
+		return nil
+	}
+	var errs []error
+	if u, err := url.Parse(o.RemoteWriteURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("remote-write-url should be an http or https URL, but value %q provided", o.RemoteWriteURL))
+	}
+	if o.RemoteWriteBearerTokenFile != "" && o.RemoteWriteUsername != "" {
+		errs = append(errs, fmt.Errorf("remote-write-bearer-token-file and remote-write-username are mutually exclusive"))
+	}
+	if (o.RemoteWriteUsername == "") != (o.RemoteWritePasswordFile == "") {
+		errs = append(errs, fmt.Errorf("remote-write-username and remote-write-password-file must be set together"))
+	}
+	if o.RemoteWriteTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("remote-write-timeout should be a positive duration, but value %v provided", o.RemoteWriteTimeout))
+	}
+	return errs
+}
+
+// parseScrapeTargetOverride parses the scheme and port overriding how nodes
+// with the label are scraped, returning nil if neither is set.
+func parseScrapeTargetOverride(label string, value map[string]string) (*scraper.ScrapeTargetOverride, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	selector, err := labels.Parse(label)
+	if err != nil {
+		return nil, err
+	}
+	override := &scraper.ScrapeTargetOverride{Selector: selector}
+	for key, v := range value {
+		switch key {
+		case "scheme":
+			if v != "http" && v != "https" {
+				return nil, fmt.Errorf("scheme should be one of %q or %q, but value %q provided", "http", "https", v)
+			}
+			override.Scheme = v
+		case "port":
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("port should be between 1 and 65535, but value %q provided", v)
+			}
+			override.Port = port
+		default:
+			return nil, fmt.Errorf("unknown key %q, must be one of scheme or port", key)
+		}
+	}
+	return override, nil
+}
+
 func (o Options) ApiserverConfig() (*genericapiserver.Config, error) {
 	if err := o.SecureServing.MaybeDefaultWithSelfSignedCerts("localhost", nil, []net.IP{net.ParseIP("127.0.0.1")}); err != nil {
 		return nil, fmt.Errorf("error creating self-signed certificates: %v", err)
@@ -142,7 +1060,16 @@ func (o Options) ApiserverConfig() (*genericapiserver.Config, error) {
 		if err := o.Authorization.ApplyTo(&serverConfig.Authorization); err != nil {
 			return nil, err
 		}
+		if o.UnauthenticatedLivez {
+			authorizer, err := withUnauthenticatedLivez(serverConfig.Authorization.Authorizer)
+			if err != nil {
+				return nil, err
+			}
+			serverConfig.Authorization.Authorizer = authorizer
+		}
 	}
+	serverConfig.MaxRequestsInFlight = o.MaxRequestsInFlight
+	serverConfig.MaxMutatingRequestsInFlight = o.MaxMutatingRequestsInFlight
 	serverConfig.Version = version.VersionInfo()
 	// enable OpenAPI schemas
 	serverConfig.OpenAPIConfig = genericapiserver.DefaultOpenAPIConfig(generatedopenapi.GetOpenAPIDefinitions, openapinamer.NewDefinitionNamer(api.Scheme))
@@ -152,6 +1079,18 @@ func (o Options) ApiserverConfig() (*genericapiserver.Config, error) {
 	return serverConfig, nil
 }
 
+// withUnauthenticatedLivez allows anyone, including anonymous users, to get
+// exactly /livez, and leaves all other requests to the given authorizer.
+// Requests without credentials are authenticated as anonymous, so this is
+// enough to open /livez to unauthenticated probes.
+func withUnauthenticatedLivez(delegate authorizer.Authorizer) (authorizer.Authorizer, error) {
+	livez, err := path.NewAuthorizer([]string{"/livez"})
+	if err != nil {
+		return nil, err
+	}
+	return union.New(livez, delegate), nil
+}
+
 func (o Options) restConfig() (*rest.Config, error) {
 	var clientConfig *rest.Config
 	var err error
@@ -169,14 +1108,90 @@ func (o Options) restConfig() (*rest.Config, error) {
 	return clientConfig, err
 }
 
+// withAPIServerCA returns a copy of the rest config trusting the CA
+// certificates of APIServerCAFile along with the CA of the config. Without a
+// CA in the config, only the additional certificates are trusted.
+func (o Options) withAPIServerCA(restConfig *rest.Config) (*rest.Config, error) {
+	if o.APIServerCAFile == "" {
+		return restConfig, nil
+	}
+	extra, err := ioutil.ReadFile(o.APIServerCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load apiserver CA file: %v", err)
+	}
+	if _, err := certutil.ParseCertsPEM(extra); err != nil {
+		return nil, fmt.Errorf("unable to load apiserver CA file %q: %v", o.APIServerCAFile, err)
+	}
+	config := rest.CopyConfig(restConfig)
+	// client-go prefers the inline data over the file, as done here
+	roots := config.TLSClientConfig.CAData
+	if len(roots) == 0 && config.TLSClientConfig.CAFile != "" {
+		roots, err = ioutil.ReadFile(config.TLSClientConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the CA of the kubeconfig: %v", err)
+		}
+	}
+	if len(roots) > 0 && roots[len(roots)-1] != '\n' {
+		roots = append(roots, '\n')
+	}
+	config.TLSClientConfig.CAData = append(append([]byte{}, roots...), extra...)
+	config.TLSClientConfig.CAFile = ""
+	return config, nil
+}
+
+// kubeletConfig configures the Kubelet client from the kubeconfig. The
+// Kubelet CA and client certificate flags take precedence over the TLS
+// settings of the kubeconfig, replacing both the file and the inline data it
+// sets. Settings without a flag are used as client-go does, preferring the
+// inline data of the kubeconfig over its file.
 func (o Options) kubeletConfig(restConfig *rest.Config) *scraper.KubeletClientConfig {
 	config := &scraper.KubeletClientConfig{
-		Scheme:              "https",
-		DefaultPort:         o.KubeletPort,
-		AddressTypePriority: o.addressResolverConfig(),
-		UseNodeStatusPort:   o.KubeletUseNodeStatusPort,
-		Client:              *rest.CopyConfig(restConfig),
+		Scheme:                "https",
+		DefaultPort:           o.KubeletPort,
+		AddressTypePriority:   o.addressResolverConfig(),
+		UseNodeStatusPort:     o.KubeletUseNodeStatusPort,
+		DedupNodeAddresses:    o.DedupNodeAddresses,
+		ServingCertSNI:        scraper.ServingCertSNI(o.KubeletServingCertSNI),
+		FollowRedirects:       scraper.FollowRedirects(o.KubeletFollowRedirects),
+		MaxRedirects:          o.KubeletMaxRedirects,
+		SuccessStatusCodes:    o.KubeletSuccessStatusCodes,
+		AllowPartialSummaries: o.AllowPartialNodeScrape,
+		SniffGzip:             o.KubeletSniffGzip,
+		TryAllAddresses:       o.TryAllAddressTypes,
+		DialTimeout:           o.KubeletDialTimeout,
+		RequestTimeout:        o.KubeletRequestTimeout,
+		ParseTimeout:          o.KubeletParseTimeout,
+		HTTP2:                 o.KubeletHTTP2,
+		MaxStreamsPerKubelet:  o.KubeletHTTP2MaxStreams,
+		Client:                *rest.CopyConfig(restConfig),
+	}
+	// invalid resources and error classes are rejected by Validate
+	if classes, err := scraper.ParseAddressFallbackClasses(o.AddressFallbackOn); err == nil {
+		config.AddressFallbackOn = classes
 	}
+	if resources, err := scraper.ParseResources(o.ScrapeResources); err == nil {
+		config.Resources = resources
+	}
+	if resources, err := scraper.ParseResources(o.SecondaryScrapeResources); err == nil && o.SecondaryScrapePort > 0 {
+		config.SecondaryPort = o.SecondaryScrapePort
+		config.SecondaryPath = o.SecondaryScrapePath
+		config.SecondaryResources = resources
+	}
+	// invalid headers are rejected by Validate
+	if headers, err := scraper.ParseRequestHeaders(o.KubeletExtraHeaders); err == nil {
+		config.ExtraHeaders = headers
+	}
+	if o.KubeletScrapeViaAPIServer {
+		// connections go through the API server, so Kubelet connection options don't apply
+		config.ScrapeViaAPIServer = true
+		return config
+	}
+	// invalid overrides are rejected by Validate
+	if override, err := parseScrapeTargetOverride(scraper.ControlPlaneRoleLabel, o.ControlPlaneScrapeOverride); err == nil && override != nil {
+		config.ScrapeTargetOverrides = []scraper.ScrapeTargetOverride{*override}
+	}
+	config.BasicAuthUser = o.KubeletBasicAuthUser
+	config.BasicAuthPasswordFile = o.KubeletBasicAuthPasswordFile
 	if o.DeprecatedCompletelyInsecureKubelet {
 		config.Scheme = "http"
 		config.Client = *rest.AnonymousClientConfig(&config.Client) // don't use auth to avoid leaking auth details to insecure endpoints
@@ -191,6 +1206,12 @@ func (o Options) kubeletConfig(restConfig *rest.Config) *scraper.KubeletClientCo
 		config.Client.TLSClientConfig.CAFile = o.KubeletCAFile
 		config.Client.TLSClientConfig.CAData = nil
 	}
+	if len(o.KubeletCADir) > 0 {
+		config.CADir = o.KubeletCADir
+		config.CARotationGrace = o.KubeletCARotationGrace
+		config.Client.TLSClientConfig.CAFile = ""
+		config.Client.TLSClientConfig.CAData = nil
+	}
 	if len(o.KubeletClientCertFile) > 0 {
 		config.Client.TLSClientConfig.CertFile = o.KubeletClientCertFile
 		config.Client.TLSClientConfig.CertData = nil
@@ -202,10 +1223,85 @@ func (o Options) kubeletConfig(restConfig *rest.Config) *scraper.KubeletClientCo
 	return config
 }
 
+// kubeletTLSWarnings describes the TLS settings of the kubeconfig which are
+// overridden by the Kubelet flags or by other settings of the kubeconfig,
+// following the precedence of kubeletConfig.
+func (o Options) kubeletTLSWarnings(restConfig *rest.Config) []string {
+	if o.KubeletScrapeViaAPIServer || o.DeprecatedCompletelyInsecureKubelet {
+		return nil
+	}
+	tls := restConfig.TLSClientConfig
+	var warnings []string
+	overridden := func(flag, setting string, data []byte, file string) {
+		if len(data) > 0 || file != "" {
+			warnings = append(warnings, fmt.Sprintf("--%s takes precedence over the %s of the kubeconfig", flag, setting))
+		}
+	}
+	preferred := func(setting string, data []byte, file string) {
+		if len(data) > 0 && file != "" {
+			warnings = append(warnings, fmt.Sprintf("the kubeconfig sets both the %s data and file %q, the data takes precedence", setting, file))
+		}
+	}
+	switch {
+	case o.KubeletCADir != "":
+		overridden("kubelet-certificate-authority-dir", "CA", tls.CAData, tls.CAFile)
+	case o.KubeletCAFile != "":
+		overridden("kubelet-certificate-authority", "CA", tls.CAData, tls.CAFile)
+	case !o.InsecureKubeletTLS:
+		preferred("CA", tls.CAData, tls.CAFile)
+	}
+	if o.KubeletClientCertFile != "" {
+		overridden("kubelet-client-certificate", "client certificate", tls.CertData, tls.CertFile)
+	} else {
+		preferred("client certificate", tls.CertData, tls.CertFile)
+	}
+	if o.KubeletClientKeyFile != "" {
+		overridden("kubelet-client-key", "client key", tls.KeyData, tls.KeyFile)
+	} else {
+		preferred("client key", tls.KeyData, tls.KeyFile)
+	}
+	if (o.KubeletClientCertFile != "") != (o.KubeletClientKeyFile != "") {
+		warnings = append(warnings, "only one of --kubelet-client-certificate and --kubelet-client-key is set, the other is taken from the kubeconfig")
+	}
+	return warnings
+}
+
+// scrapeStrategies configures the clients of nodes which aren't scraped as
+// Kubelets, based on the given Kubelet configuration.
+func (o Options) scrapeStrategies(kubelet *scraper.KubeletClientConfig) []server.ScrapeStrategyConfig {
+	if o.VirtualKubeletSelector == "" {
+		return nil
+	}
+	// invalid selectors are rejected by Validate
+	selector, err := labels.Parse(o.VirtualKubeletSelector)
+	if err != nil {
+		return nil
+	}
+	config := *kubelet
+	config.DefaultPort = o.VirtualKubeletPort
+	config.UseNodeStatusPort = false
+	config.ScrapeTargetOverrides = nil
+	config.SummaryPath = o.VirtualKubeletSummaryPath
+	if o.VirtualKubeletBearerTokenFile != "" {
+		// authenticate with the token only, so Kubelet credentials aren't sent to virtual nodes
+		config.Client = *rest.AnonymousClientConfig(&kubelet.Client)
+		config.Client.BearerTokenFile = o.VirtualKubeletBearerTokenFile
+		config.BasicAuthUser = ""
+		config.BasicAuthPasswordFile = ""
+	}
+	return []server.ScrapeStrategyConfig{{Selector: selector, Kubelet: &config}}
+}
+
+// addressResolverConfig normalizes the preferred address types, keeping values
+// which don't match any known address type as they are for Validate to reject.
 func (o Options) addressResolverConfig() []corev1.NodeAddressType {
 	addrPriority := make([]corev1.NodeAddressType, len(o.KubeletPreferredAddressTypes))
 	for i, addrType := range o.KubeletPreferredAddressTypes {
-		addrPriority[i] = corev1.NodeAddressType(addrType)
+		parsed, err := utils.ParseAddressType(addrType)
+		if err != nil {
+			parsed = corev1.NodeAddressType(addrType)
+		}
+		addrPriority[i] = parsed
 	}
 	return addrPriority
 }
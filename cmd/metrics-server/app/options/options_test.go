@@ -14,10 +14,35 @@
 package options
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapifilters "k8s.io/apiserver/pkg/endpoints/filters"
+	apirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	genericfilters "k8s.io/apiserver/pkg/server/filters"
 	"k8s.io/client-go/rest"
 
 	"sigs.k8s.io/metrics-server/pkg/scraper"
@@ -47,6 +72,11 @@ func TestKubeletConfig(t *testing.T) {
 		AddressTypePriority: []v1.NodeAddressType{"Hostname", "InternalDNS", "InternalIP", "ExternalDNS", "ExternalIP"},
 		Scheme:              "https",
 		DefaultPort:         10250,
+		ServingCertSNI:      scraper.ServingCertSNIAddress,
+		FollowRedirects:     scraper.FollowRedirectsSameHost,
+		MaxRedirects:        10,
+		SuccessStatusCodes:  []int{200},
+		AddressFallbackOn:   []scraper.AddressFallbackClass{scraper.AddressFallbackConnection},
 		Client:              *kubeconfig,
 	}
 
@@ -65,6 +95,66 @@ func TestKubeletConfig(t *testing.T) {
 				return expected
 			},
 		},
+		{
+			name: "KubeletPort overrides default port",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPort = 10260
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.DefaultPort = 10260
+				return e
+			},
+		},
+		{
+			name: "AddressFallbackOn selects the error classes retried on the next address",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.TryAllAddressTypes = true
+				o.AddressFallbackOn = []string{"tls"}
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.TryAllAddresses = true
+				e.AddressFallbackOn = []scraper.AddressFallbackClass{scraper.AddressFallbackTLS}
+				return e
+			},
+		},
+		{
+			name: "SecondaryScrapePort configures the secondary target",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.SecondaryScrapePort = 9100
+				o.SecondaryScrapePath = "/exporter/summary"
+				o.SecondaryScrapeResources = []string{"memory"}
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.SecondaryPort = 9100
+				e.SecondaryPath = "/exporter/summary"
+				e.SecondaryResources = scraper.Resources{SkipCPU: true}
+				return e
+			},
+		},
+		{
+			name: "KubeletPort is kept as fallback when using node status port",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPort = 10260
+				o.KubeletUseNodeStatusPort = true
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.DefaultPort = 10260
+				e.UseNodeStatusPort = true
+				return e
+			},
+		},
 		{
 			name: "InsecureKubeletTLS removes CA config and sets insecure",
 			optionsFunc: func() *Options {
@@ -140,12 +230,1690 @@ func TestKubeletConfig(t *testing.T) {
 				return e
 			},
 		},
+		{
+			name: "KubeletClientCertFile and KubeletClientKeyFile take precedence over inline kubeconfig data",
+			kubeconfig: &rest.Config{TLSClientConfig: rest.TLSClientConfig{
+				CertData: []byte("CertData"),
+				KeyData:  []byte("KeyData"),
+				CAData:   []byte("CAData"),
+			}},
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletClientCertFile = "OverrideCert"
+				o.KubeletClientKeyFile = "OverrideKey"
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.Client = rest.Config{TLSClientConfig: rest.TLSClientConfig{
+					CertFile: "OverrideCert",
+					KeyFile:  "OverrideKey",
+					CAData:   []byte("CAData"),
+				}}
+				return e
+			},
+		},
+		{
+			name: "KubeletCAFile takes precedence over inline kubeconfig CA data",
+			kubeconfig: &rest.Config{TLSClientConfig: rest.TLSClientConfig{
+				CAData: []byte("CAData"),
+			}},
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletCAFile = "Override"
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.Client = rest.Config{TLSClientConfig: rest.TLSClientConfig{
+					CAFile: "Override",
+				}}
+				return e
+			},
+		},
+		{
+			name: "Kubeconfig file and inline data are both kept without Kubelet flags",
+			kubeconfig: &rest.Config{TLSClientConfig: rest.TLSClientConfig{
+				CertFile: "CertFile",
+				CertData: []byte("CertData"),
+				CAFile:   "CAFile",
+				CAData:   []byte("CAData"),
+			}},
+			optionsFunc: func() *Options {
+				return NewOptions()
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.Client = rest.Config{TLSClientConfig: rest.TLSClientConfig{
+					CertFile: "CertFile",
+					CertData: []byte("CertData"),
+					CAFile:   "CAFile",
+					CAData:   []byte("CAData"),
+				}}
+				return e
+			},
+		},
+		{
+			name: "KubeletPreferredAddressTypes are normalized case-insensitively",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPreferredAddressTypes = []string{"internalip", "Internal-DNS", "EXTERNAL_IP"}
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.AddressTypePriority = []v1.NodeAddressType{"InternalIP", "InternalDNS", "ExternalIP"}
+				return e
+			},
+		},
+		{
+			name: "KubeletDialTimeout and KubeletRequestTimeout are set separately",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletDialTimeout = time.Second
+				o.KubeletRequestTimeout = 20 * time.Second
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.DialTimeout = time.Second
+				e.RequestTimeout = 20 * time.Second
+				return e
+			},
+		},
+		{
+			name: "KubeletParseTimeout is passed to the client",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletParseTimeout = time.Second
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.ParseTimeout = time.Second
+				return e
+			},
+		},
+		{
+			name: "ControlPlaneScrapeOverride applies to nodes with the control plane role label",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ControlPlaneScrapeOverride = map[string]string{"scheme": "http", "port": "10260"}
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.ScrapeTargetOverrides = []scraper.ScrapeTargetOverride{{
+					Selector: mustParseSelector(t, scraper.ControlPlaneRoleLabel),
+					Scheme:   "http",
+					Port:     10260,
+				}}
+				return e
+			},
+		},
+		{
+			name: "KubeletServingCertSNI nodename verifies certs against the node name",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletServingCertSNI = "nodename"
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.ServingCertSNI = scraper.ServingCertSNINodeName
+				return e
+			},
+		},
+		{
+			name: "KubeletCADir replaces the CA of the kubeconfig",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletCADir = "/etc/kubelet-ca"
+				o.KubeletCARotationGrace = time.Hour
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.CADir = "/etc/kubelet-ca"
+				e.CARotationGrace = time.Hour
+				e.Client.CAFile = ""
+				e.Client.CAData = nil
+				return e
+			},
+		},
+		{
+			name: "AllowPartialNodeScrape keeps the complete part of truncated summaries",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.AllowPartialNodeScrape = true
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.AllowPartialSummaries = true
+				return e
+			},
+		},
+		{
+			name: "KubeletBasicAuthUser sets basic auth credentials",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletBasicAuthUser = "metrics-server"
+				o.KubeletBasicAuthPasswordFile = "password"
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.BasicAuthUser = "metrics-server"
+				e.BasicAuthPasswordFile = "password"
+				return e
+			},
+		},
+		{
+			name: "KubeletScrapeViaAPIServer uses config from kubeconfig and ignores Kubelet connection options",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletScrapeViaAPIServer = true
+				o.InsecureKubeletTLS = true
+				o.KubeletCAFile = "Override"
+				o.ControlPlaneScrapeOverride = map[string]string{"port": "10260"}
+				return o
+			},
+			expectFunc: func() scraper.KubeletClientConfig {
+				e := expected
+				e.ScrapeViaAPIServer = true
+				return e
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			config := tc.optionsFunc().kubeletConfig(kubeconfig)
-			if diff := cmp.Diff(*config, tc.expectFunc()); diff != "" {
+			restConfig := kubeconfig
+			if tc.kubeconfig != nil {
+				restConfig = tc.kubeconfig
+			}
+			config := tc.optionsFunc().kubeletConfig(restConfig)
+			selectors := cmp.Comparer(func(a, b labels.Selector) bool { return a.String() == b.String() })
+			if diff := cmp.Diff(*config, tc.expectFunc(), selectors); diff != "" {
 				t.Errorf("Unexpected options.KubeletConfig(), diff:\n%s", diff)
 			}
 		})
 	}
 }
+
+func TestKubeletTLSWarnings(t *testing.T) {
+	inline := &rest.Config{TLSClientConfig: rest.TLSClientConfig{
+		CertData: []byte("CertData"),
+		KeyData:  []byte("KeyData"),
+		CAData:   []byte("CAData"),
+	}}
+	both := &rest.Config{TLSClientConfig: rest.TLSClientConfig{
+		CertFile: "CertFile",
+		CertData: []byte("CertData"),
+		CAFile:   "CAFile",
+		CAData:   []byte("CAData"),
+	}}
+	for _, tc := range []struct {
+		name        string
+		optionsFunc func() *Options
+		kubeconfig  *rest.Config
+		expect      []string
+	}{
+		{
+			name:        "no warnings without overlapping settings",
+			optionsFunc: NewOptions,
+			kubeconfig:  inline,
+		},
+		{
+			name: "flags overriding inline kubeconfig data",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletCAFile = "ca.crt"
+				o.KubeletClientCertFile = "client.crt"
+				o.KubeletClientKeyFile = "client.key"
+				return o
+			},
+			kubeconfig: inline,
+			expect: []string{
+				"--kubelet-certificate-authority takes precedence over the CA of the kubeconfig",
+				"--kubelet-client-certificate takes precedence over the client certificate of the kubeconfig",
+				"--kubelet-client-key takes precedence over the client key of the kubeconfig",
+			},
+		},
+		{
+			name: "client certificate flag without key flag",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletClientCertFile = "client.crt"
+				return o
+			},
+			kubeconfig: &rest.Config{},
+			expect:     []string{"only one of --kubelet-client-certificate and --kubelet-client-key is set, the other is taken from the kubeconfig"},
+		},
+		{
+			name:        "kubeconfig setting both file and data",
+			optionsFunc: NewOptions,
+			kubeconfig:  both,
+			expect: []string{
+				`the kubeconfig sets both the CA data and file "CAFile", the data takes precedence`,
+				`the kubeconfig sets both the client certificate data and file "CertFile", the data takes precedence`,
+			},
+		},
+		{
+			name: "no warnings when scraping via the API server",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletScrapeViaAPIServer = true
+				o.KubeletClientCertFile = "client.crt"
+				return o
+			},
+			kubeconfig: both,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.optionsFunc().kubeletTLSWarnings(tc.kubeconfig)
+			if diff := cmp.Diff(got, tc.expect); diff != "" {
+				t.Errorf("Unexpected options.kubeletTLSWarnings(), diff:\n%s", diff)
+			}
+		})
+	}
+}
+
+func mustParseSelector(t *testing.T, selector string) labels.Selector {
+	s, err := labels.Parse(selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestScrapeStrategies(t *testing.T) {
+	kubelet := &scraper.KubeletClientConfig{
+		Scheme:            "https",
+		DefaultPort:       10250,
+		UseNodeStatusPort: true,
+		Client: rest.Config{
+			BearerToken: "KubeletBearerToken",
+			TLSClientConfig: rest.TLSClientConfig{
+				CertFile: "CertFile",
+				KeyFile:  "KeyFile",
+				CAFile:   "CAFile",
+			},
+		},
+	}
+
+	o := NewOptions()
+	if strategies := o.scrapeStrategies(kubelet); strategies != nil {
+		t.Errorf("Expected no strategies without a virtual-kubelet selector, got %+v", strategies)
+	}
+
+	o.VirtualKubeletSelector = scraper.VirtualKubeletSelector
+	o.VirtualKubeletPort = 10255
+	o.VirtualKubeletSummaryPath = "/virtual/stats/summary"
+	o.VirtualKubeletBearerTokenFile = "VirtualBearerTokenFile"
+	strategies := o.scrapeStrategies(kubelet)
+	if len(strategies) != 1 {
+		t.Fatalf("Expected a single strategy, got %+v", strategies)
+	}
+	if got := strategies[0].Selector.String(); got != scraper.VirtualKubeletSelector {
+		t.Errorf("Unexpected selector %q", got)
+	}
+	expected := scraper.KubeletClientConfig{
+		Scheme:      "https",
+		DefaultPort: 10255,
+		SummaryPath: "/virtual/stats/summary",
+		Client: rest.Config{
+			BearerTokenFile: "VirtualBearerTokenFile",
+			TLSClientConfig: rest.TLSClientConfig{CAFile: "CAFile"},
+		},
+	}
+	if diff := cmp.Diff(*strategies[0].Kubelet, expected); diff != "" {
+		t.Errorf("Unexpected strategy Kubelet config, diff:\n%s", diff)
+	}
+	if kubelet.Client.BearerToken != "KubeletBearerToken" || kubelet.DefaultPort != 10250 {
+		t.Errorf("Expected the Kubelet config to be unchanged, got %+v", kubelet)
+	}
+}
+
+func TestWithUnauthenticatedLivez(t *testing.T) {
+	// the delegate stands in for the delegating authorizer denying anonymous users
+	var delegated []string
+	deny := authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		delegated = append(delegated, a.GetPath())
+		return authorizer.DecisionDeny, "anonymous", nil
+	})
+	authz, err := withUnauthenticatedLivez(deny)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	anonymous := &user.DefaultInfo{Name: user.Anonymous, Groups: []string{user.AllUnauthenticated}}
+
+	for _, tc := range []struct {
+		path     string
+		resource bool
+		allowed  bool
+	}{
+		{path: "/livez", allowed: true},
+		{path: "/livez/ping"},
+		{path: "/livezz"},
+		{path: "/readyz"},
+		{path: "/healthz"},
+		{path: "/metrics"},
+		{path: "/debug/metrics-server/storage"},
+		{path: "/apis/metrics.k8s.io/v1beta1/nodes", resource: true},
+		{path: "/apis/metrics.k8s.io/v1beta1/namespaces/default/pods", resource: true},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			delegated = nil
+			attributes := authorizer.AttributesRecord{User: anonymous, Verb: "get", Path: tc.path, ResourceRequest: tc.resource}
+			decision, _, err := authz.Authorize(context.Background(), attributes)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if allowed := decision == authorizer.DecisionAllow; allowed != tc.allowed {
+				t.Errorf("Expected anonymous access allowed %v, got decision %v", tc.allowed, decision)
+			}
+			if tc.allowed == (len(delegated) != 0) {
+				t.Errorf("Expected request delegated %v, got delegated paths %v", !tc.allowed, delegated)
+			}
+		})
+	}
+}
+
+func TestRequestHeaderCAReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "requestheader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(previous time.Duration) { dynamiccertificates.FileRefreshDuration = previous }(dynamiccertificates.FileRefreshDuration)
+	dynamiccertificates.FileRefreshDuration = 10 * time.Millisecond
+
+	oldProxy, oldCA := frontProxyCert(t)
+	newProxy, newCA := frontProxyCert(t)
+	caFile := filepath.Join(dir, "front-proxy-ca.crt")
+	if err := ioutil.WriteFile(caFile, oldCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	o := NewOptions()
+	o.SecureServing.Listener = listener
+	o.SecureServing.ServerCert.CertDirectory = dir
+	o.Authentication.RequestHeader.ClientCAFile = caFile
+	o.Authentication.SkipInClusterLookup = true
+	o.Authentication.RemoteKubeConfigFileOptional = true
+	o.Authorization.RemoteKubeConfigFileOptional = true
+	config, err := o.ApiserverConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// the serving setup runs the CA controllers, including the requestheader CA
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go config.SecureServing.ClientCA.(dynamiccertificates.ControllerRunner).Run(1, stopCh)
+
+	authenticated := func(cert *x509.Certificate) bool {
+		req := httptest.NewRequest("GET", "/apis/metrics.k8s.io/v1beta1/nodes", nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		req.Header.Set("X-Remote-User", "alice")
+		resp, ok, _ := config.Authentication.Authenticator.AuthenticateRequest(req)
+		return ok && resp.User.GetName() == "alice"
+	}
+	if !authenticated(oldProxy) {
+		t.Fatal("Expected the front-proxy certificate signed by the initial CA to be accepted")
+	}
+	if authenticated(newProxy) {
+		t.Fatal("Expected the front-proxy certificate signed by another CA to be rejected")
+	}
+
+	if err := ioutil.WriteFile(caFile, newCA, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return authenticated(newProxy), nil
+	})
+	if err != nil {
+		t.Fatal("Expected the front-proxy certificate signed by the rotated CA to be accepted without restart")
+	}
+	if authenticated(oldProxy) {
+		t.Error("Expected the front-proxy certificate signed by the previous CA to be rejected after rotation")
+	}
+}
+
+func TestMaxRequestsInFlight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inflight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	o := NewOptions()
+	o.SecureServing.Listener = listener
+	o.SecureServing.ServerCert.CertDirectory = dir
+	o.DisableAuthForTesting = true
+	o.MaxRequestsInFlight = 1
+	config, err := o.ApiserverConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.MaxRequestsInFlight != 1 || config.MaxMutatingRequestsInFlight != 200 {
+		t.Fatalf("Expected inflight limits 1 and 200, got %d and %d", config.MaxRequestsInFlight, config.MaxMutatingRequestsInFlight)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	// the same limit as the default handler chain applies with the config
+	handler := genericfilters.WithMaxInFlightLimit(blocking, config.MaxRequestsInFlight, config.MaxMutatingRequestsInFlight, config.LongRunningFunc)
+	handler = genericapifilters.WithRequestInfo(handler, &apirequest.RequestInfoFactory{APIPrefixes: sets.NewString("apis"), GrouplessAPIPrefixes: sets.NewString()})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/apis/metrics.k8s.io/v1beta1/nodes", nil))
+	}()
+	<-started
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/apis/metrics.k8s.io/v1beta1/pods", nil))
+	close(release)
+	<-done
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a request over the inflight limit to get %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+}
+
+// frontProxyCert returns a self-signed front-proxy client certificate, and
+// its PEM encoding to be trusted as requestheader CA.
+func frontProxyCert(t *testing.T) (*x509.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "front-proxy-client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestWithAPIServerCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apiserver-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clusterCert, clusterCA := frontProxyCert(t)
+	extraCert, extraCA := frontProxyCert(t)
+	clusterFile := filepath.Join(dir, "ca.crt")
+	extraFile := filepath.Join(dir, "extra-ca.crt")
+	invalidFile := filepath.Join(dir, "invalid.crt")
+	for file, data := range map[string][]byte{clusterFile: clusterCA, extraFile: extraCA, invalidFile: []byte("not a certificate")} {
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		restConfig *rest.Config
+	}{
+		{name: "CA file", restConfig: &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAFile: clusterFile}}},
+		{name: "inline CA data", restConfig: &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: clusterCA}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			o := NewOptions()
+			o.APIServerCAFile = extraFile
+			got, err := o.withAPIServerCA(tc.restConfig)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			tlsConfig, err := rest.TLSConfigFor(got)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for name, cert := range map[string]*x509.Certificate{"cluster": clusterCert, "extra": extraCert} {
+				if _, err := cert.Verify(x509.VerifyOptions{Roots: tlsConfig.RootCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+					t.Errorf("Expected the %s CA to be trusted, got: %v", name, err)
+				}
+			}
+			if tc.restConfig.CAFile == "" && len(tc.restConfig.CAData) != len(clusterCA) {
+				t.Error("Expected the original config to be left unchanged")
+			}
+		})
+	}
+
+	o := NewOptions()
+	unchanged := &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAFile: clusterFile}}
+	if got, err := o.withAPIServerCA(unchanged); err != nil || got != unchanged {
+		t.Errorf("Expected the config to be used as is without --apiserver-ca-file, got %+v, %v", got, err)
+	}
+	for _, file := range []string{invalidFile, filepath.Join(dir, "missing.crt")} {
+		o.APIServerCAFile = file
+		if _, err := o.withAPIServerCA(unchanged); err == nil {
+			t.Errorf("Expected an error loading %s", file)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		optionsFunc func() *Options
+		expectErrs  int
+	}{
+		{
+			name:        "Default options are valid",
+			optionsFunc: NewOptions,
+		},
+		{
+			name: "KubeletPort in range is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPort = 65535
+				return o
+			},
+		},
+		{
+			name: "KubeletPort zero is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPort = 0
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletPort above range is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPort = 65536
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "EnableRawPayloadCache requires EnableDebugEndpoints",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.EnableRawPayloadCache = true
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "EnableRawPayloadCache with EnableDebugEndpoints is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.EnableRawPayloadCache = true
+				o.EnableDebugEndpoints = true
+				return o
+			},
+		},
+		{
+			name: "KubeletPreferredAddressTypes in mixed case are valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPreferredAddressTypes = []string{"internalip", "Internal-IP", "hostname"}
+				return o
+			},
+		},
+		{
+			name: "KubeletPreferredAddressTypes unknown values are invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletPreferredAddressTypes = []string{"InternalIP", "PrivateIP", "IPv6"}
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "AddressTypeMappingFile missing is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.AddressTypeMappingFile = "/nonexistent/mapping.yaml"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "AuthenticationTimeout and AuthorizationTimeout negative are invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.AuthenticationTimeout = -time.Second
+				o.AuthorizationTimeout = -time.Second
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "KubeletCADir missing is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletCADir = "/nonexistent/kubelet-ca"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletCADir combined with KubeletCAFile is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletCADir = "/nonexistent/kubelet-ca"
+				o.KubeletCAFile = "/etc/kubelet-ca.crt"
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "KubeletCARotationGrace without KubeletCADir is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletCARotationGrace = time.Hour
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletCARotationGrace negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletCARotationGrace = -time.Hour
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapePhaseOffset within metric-resolution is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapePhaseOffset = 30 * time.Second
+				return o
+			},
+		},
+		{
+			name: "ScrapePhaseOffset equal to metric-resolution is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapePhaseOffset = o.MetricResolution
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapePhaseSeed with ScrapePhaseOffset is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapePhaseOffset = 30 * time.Second
+				o.ScrapePhaseSeed = "metrics-server-0"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MinSampleInterval negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MinSampleInterval = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "FollowerProxyToLeader with an advertise address is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.FollowerProxyToLeader = true
+				o.LeaderElectionAdvertiseAddress = "10.0.0.1:4443"
+				return o
+			},
+		},
+		{
+			name: "FollowerProxyToLeader without an advertise address is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.FollowerProxyToLeader = true
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "FollowerProxyToLeader with a lease without namespace is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.FollowerProxyToLeader = true
+				o.LeaderElectionLease = "metrics-server"
+				o.LeaderElectionAdvertiseAddress = "10.0.0.1:4443"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "FollowerProxyToLeader with self check is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.FollowerProxyToLeader = true
+				o.LeaderElectionAdvertiseAddress = "10.0.0.1:4443"
+				o.EnableSelfCheck = true
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "NodeAddressFile missing is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.NodeAddressFile = "/nonexistent/addresses.yaml"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "InformerResyncPeriod negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.InformerResyncPeriod = -time.Minute
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "InformerBatchWindow within MetricResolution is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MetricResolution = 60 * time.Second
+				o.InformerBatchWindow = time.Second
+				return o
+			},
+		},
+		{
+			name: "InformerBatchWindow above MetricResolution is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MetricResolution = 60 * time.Second
+				o.InformerBatchWindow = 90 * time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapeCycleDeadline within MetricResolution is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MetricResolution = 60 * time.Second
+				o.ScrapeCycleDeadline = 45 * time.Second
+				return o
+			},
+		},
+		{
+			name: "ScrapeCycleDeadline above MetricResolution is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MetricResolution = 60 * time.Second
+				o.ScrapeCycleDeadline = 90 * time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletDialTimeout negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletDialTimeout = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletRequestTimeout negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletRequestTimeout = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletFollowRedirects unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletFollowRedirects = "cross-host"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletMaxRedirects negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletMaxRedirects = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletParseTimeout negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletParseTimeout = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "NodeLeaseStaleThreshold zero is invalid when using node leases",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.UseNodeLeaseForLiveness = true
+				o.NodeLeaseStaleThreshold = 0
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "SkipTaintedNodes with taint keys is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.SkipTaintedNodes = []string{"node.kubernetes.io/unschedulable", "dedicated"}
+				return o
+			},
+		},
+		{
+			name: "SkipTaintedNodes with invalid keys is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.SkipTaintedNodes = []string{"node.kubernetes.io/unschedulable=true"}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletSuccessStatusCodes with 204 is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletSuccessStatusCodes = []int{200, 204}
+				return o
+			},
+		},
+		{
+			name: "KubeletSuccessStatusCodes outside 2xx are invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletSuccessStatusCodes = []int{200, 404, 503}
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "VirtualKubeletSelector with defaults is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.VirtualKubeletSelector = "type=virtual-kubelet"
+				return o
+			},
+		},
+		{
+			name: "VirtualKubeletSelector unparsable is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.VirtualKubeletSelector = "type in virtual-kubelet"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "VirtualKubeletPort and VirtualKubeletSummaryPath invalid are invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.VirtualKubeletSelector = "type=virtual-kubelet"
+				o.VirtualKubeletPort = 0
+				o.VirtualKubeletSummaryPath = "stats/summary"
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "VirtualKubeletPort invalid is ignored without a selector",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.VirtualKubeletPort = 0
+				return o
+			},
+		},
+		{
+			name: "MinCycleNodeFraction in range is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.DiscardEmptyCycles = true
+				o.MinCycleNodeFraction = 0.5
+				return o
+			},
+		},
+		{
+			name: "MinCycleNodeFraction above one is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MinCycleNodeFraction = 1.5
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MinCycleSuccessRatio in range is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MinCycleSuccessRatio = 0.9
+				return o
+			},
+		},
+		{
+			name: "MinCycleSuccessRatio negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MinCycleSuccessRatio = -0.1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletServingCertSNI unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletServingCertSNI = "hostname"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "TimestampSource receive is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.TimestampSource = "receive"
+				return o
+			},
+		},
+		{
+			name: "TimestampSource unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.TimestampSource = "kubelet"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletBasicAuthUser with a password file is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletBasicAuthUser = "metrics-server"
+				o.KubeletBasicAuthPasswordFile = "password"
+				return o
+			},
+		},
+		{
+			name: "KubeletBasicAuthUser without a password file is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletBasicAuthUser = "metrics-server"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletBasicAuthUser with an insecure Kubelet is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletBasicAuthUser = "metrics-server"
+				o.KubeletBasicAuthPasswordFile = "password"
+				o.DeprecatedCompletelyInsecureKubelet = true
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapeOrder stale-first is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeOrder = "stale-first"
+				return o
+			},
+		},
+		{
+			name: "ScrapeOrder unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeOrder = "random"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapeErrorLogInterval negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeErrorLogInterval = -time.Minute
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "NodeUsageSource sum-of-pods is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.NodeUsageSource = "sum-of-pods"
+				return o
+			},
+		},
+		{
+			name: "NodeUsageSource unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.NodeUsageSource = "pods"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "RestartGracePeriod negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.RestartGracePeriod = -time.Minute
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "RestartGraceLease is validated when RestartGracePeriod is set",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.RestartGracePeriod = time.Minute
+				o.RestartGraceLease = "metrics-server-restart"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ExcludeSandbox without sandbox container names is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ExcludeSandbox = true
+				o.SandboxContainerNames = nil
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ExcludeContainerNames with names and glob patterns is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ExcludeContainerNames = []string{"monitoring", "istio-*"}
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "ExcludeContainerNames with a malformed glob pattern is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ExcludeContainerNames = []string{"monitoring", "istio-["}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "InformerBackoffCap negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.InformerBackoffCap = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapeNodeSampleFraction in range is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeNodeSampleFraction = 0.1
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "ScrapeNodeSampleFraction above one is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeNodeSampleFraction = 1.1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "Staleness thresholds per resource are valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.CPUStaleness = 30 * time.Second
+				o.MemoryStaleness = time.Minute
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "Staleness thresholds negative are invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.CPUStaleness = -time.Second
+				o.MemoryStaleness = -time.Second
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "NodeKey provider-id is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.NodeKey = "provider-id"
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "PodUIDChange reset is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PodUIDChange = "reset"
+				return o
+			},
+		},
+		{
+			name: "PodUIDChange unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PodUIDChange = "warn"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "SecondaryScrapePort with defaults is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.SecondaryScrapePort = 9100
+				return o
+			},
+		},
+		{
+			name: "SecondaryScrapePort out of range and relative SecondaryScrapePath are invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.SecondaryScrapePort = 70000
+				o.SecondaryScrapePath = "stats"
+				o.SecondaryScrapeResources = []string{"gpu"}
+				return o
+			},
+			expectErrs: 3,
+		},
+		{
+			name: "NodeExistenceGrace negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.NodeExistenceGrace = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "NodeKey unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.NodeKey = "uid"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "PathPrefix with a leading slash is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PathPrefix = "/metrics-server"
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "PathPrefix without a leading slash is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PathPrefix = "metrics-server/"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "RequestFairness with default pools is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.RequestFairness = true
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "RequestFairness with empty pools is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.RequestFairness = true
+				o.MaxGetRequests = 0
+				o.MaxListRequests = 0
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "MaxUsageOverAllocatable negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxUsageOverAllocatable = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MaxRequestsInFlight negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxRequestsInFlight = -1
+				o.MaxMutatingRequestsInFlight = -1
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "MaxRequestsInFlight zero is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxRequestsInFlight = 0
+				return o
+			},
+		},
+		{
+			name: "PinNodeAddresses is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PinNodeAddresses = true
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "PinNodeAddresses with DedupNodeAddresses is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PinNodeAddresses = true
+				o.DedupNodeAddresses = true
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MemoryMinDelta quantity is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MemoryMinDelta = "1Mi"
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "MemoryMinDelta negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MemoryMinDelta = "-1Mi"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "AddressFallbackOn tls and connection is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.AddressFallbackOn = []string{"tls", "connection"}
+				return o
+			},
+		},
+		{
+			name: "AddressFallbackOn unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.AddressFallbackOn = []string{"tls", "timeout"}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapeResources memory only is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeResources = []string{"memory"}
+				return o
+			},
+		},
+		{
+			name: "ScrapeResources empty is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeResources = nil
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ScrapeResources unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ScrapeResources = []string{"memory", "storage"}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "DuplicateSeriesPolicy max is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.DuplicateSeriesPolicy = "max"
+				return o
+			},
+		},
+		{
+			name: "DuplicateSeriesPolicy unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.DuplicateSeriesPolicy = "sum"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MemoryMetric rss is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MemoryMetric = "rss"
+				return o
+			},
+		},
+		{
+			name: "MemoryMetric unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MemoryMetric = "usage"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ContainerNameNormalize regex is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ContainerNameNormalize = `-[0-9a-f]{5}`
+				return o
+			},
+		},
+		{
+			name: "ContainerNameNormalize invalid regex is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ContainerNameNormalize = `-[0-9a-f`
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MemoryReport smoothed is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MemoryReport = "smoothed"
+				return o
+			},
+		},
+		{
+			name: "MemoryReport unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MemoryReport = "average"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "APIResponseCacheTTL negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.APIResponseCacheTTL = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MaxListResponseBytes negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxListResponseBytes = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "DiscoveryCacheTTL negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.DiscoveryCacheTTL = -time.Second
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletExtraHeaders with templates is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletExtraHeaders = map[string]string{"X-Tenant-Id": "team-a", "X-Route": "{{.Name}}"}
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "KubeletExtraHeaders with invalid template is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletExtraHeaders = map[string]string{"X-Route": "{{.Name"}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "KubeletHTTP2MaxStreams negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.KubeletHTTP2 = true
+				o.KubeletHTTP2MaxStreams = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "PodAggregation max is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PodAggregation = "max"
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "PodAggregation unknown is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PodAggregation = "avg"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MinHealthyNodesFraction in range is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MinHealthyNodesFraction = 0.8
+				return o
+			},
+			expectErrs: 0,
+		},
+		{
+			name: "MinHealthyNodesFraction above one is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MinHealthyNodesFraction = 1.2
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ControlPlaneScrapeOverride with scheme and port is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ControlPlaneScrapeOverride = map[string]string{"scheme": "https", "port": "10260"}
+				return o
+			},
+		},
+		{
+			name: "ControlPlaneScrapeOverride with invalid port is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ControlPlaneScrapeOverride = map[string]string{"port": "70000"}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "ControlPlaneScrapeOverride with unknown key is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.ControlPlaneScrapeOverride = map[string]string{"address": "InternalIP"}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "PodMetricsEchoLabels with valid keys is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PodMetricsEchoLabels = []string{"app", "example.com/team"}
+				return o
+			},
+		},
+		{
+			name: "PodMetricsEchoLabels with invalid key is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.PodMetricsEchoLabels = []string{"app", "team=payments"}
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "RemoteWriteURL with basic auth is valid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.RemoteWriteURL = "https://prometheus.example.com/api/v1/write"
+				o.RemoteWriteUsername = "metrics-server"
+				o.RemoteWritePasswordFile = "/etc/remote-write/password"
+				return o
+			},
+		},
+		{
+			name: "RemoteWriteURL without scheme is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.RemoteWriteURL = "prometheus.example.com/api/v1/write"
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "RemoteWriteUsername with bearer token and without password is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.RemoteWriteURL = "https://prometheus.example.com/api/v1/write"
+				o.RemoteWriteBearerTokenFile = "/etc/remote-write/token"
+				o.RemoteWriteUsername = "metrics-server"
+				return o
+			},
+			expectErrs: 2,
+		},
+		{
+			name: "MaxSelectorRequirements negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxSelectorRequirements = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MaxConcurrentScrapes negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxConcurrentScrapes = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MaxPodsPerNode negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxPodsPerNode = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+		{
+			name: "MaxContainersPerNode negative is invalid",
+			optionsFunc: func() *Options {
+				o := NewOptions()
+				o.MaxContainersPerNode = -1
+				return o
+			},
+			expectErrs: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := tc.optionsFunc().Validate()
+			if len(errs) != tc.expectErrs {
+				t.Errorf("Expected %d errors, got: %v", tc.expectErrs, errs)
+			}
+		})
+	}
+}
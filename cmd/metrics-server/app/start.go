@@ -20,6 +20,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apiserver/pkg/server/healthz"
 
 	"sigs.k8s.io/metrics-server/cmd/metrics-server/app/options"
@@ -49,6 +50,9 @@ func runCommand(o *options.Options, stopCh <-chan struct{}) error {
 		fmt.Println(version.VersionInfo())
 		os.Exit(0)
 	}
+	if errs := o.Validate(); len(errs) != 0 {
+		return utilerrors.NewAggregate(errs)
+	}
 	config, err := o.ServerConfig()
 	if err != nil {
 		return err
@@ -61,7 +65,7 @@ func runCommand(o *options.Options, stopCh <-chan struct{}) error {
 		return err
 	}
 
-	err = s.AddHealthChecks(healthz.NamedCheck("livez", s.CheckLiveness), healthz.NamedCheck("readyz", s.CheckReadiness))
+	err = s.AddHealthChecks(healthz.NamedCheck("livez", s.CheckLiveness))
 	if err != nil {
 		return err
 	}
@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/go-openapi/spec v0.19.8
 	github.com/go-openapi/swag v0.19.9 // indirect
+	github.com/gogo/protobuf v1.3.1
 	github.com/google/addlicense v0.0.0-20200906110928-a0294312aa76
 	github.com/google/go-cmp v0.4.0
 	github.com/mailru/easyjson v0.7.1
@@ -12,14 +13,17 @@ require (
 	github.com/onsi/gomega v1.7.0
 	github.com/prometheus/common v0.10.0
 	github.com/spf13/cobra v1.0.0
+	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	golang.org/x/sys v0.0.0-20200625212154-ddb9806d33ae // indirect
+	google.golang.org/grpc v1.27.0
 	k8s.io/api v0.19.2
 	k8s.io/apimachinery v0.19.2
 	k8s.io/apiserver v0.19.2
 	k8s.io/client-go v0.19.2
 	k8s.io/component-base v0.19.2
-	k8s.io/klog v1.0.0
+	k8s.io/klog/v2 v2.2.0
 	k8s.io/kube-openapi v0.0.0-20200805222855-6aeccd4b50c6
 	k8s.io/kubelet v0.0.0-20200923081432-c7415d3dc5ea
 	k8s.io/metrics v0.19.2
+	sigs.k8s.io/yaml v1.2.0
 )
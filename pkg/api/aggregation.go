@@ -0,0 +1,64 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// PodAggregation decides how the usage of the containers of a pod is rolled
+// up into a single value per resource in table output, e.g. kubectl top pod.
+type PodAggregation string
+
+const (
+	// PodAggregationSum serves the total usage of the containers, which is
+	// what autoscalers act on.
+	PodAggregationSum PodAggregation = "sum"
+	// PodAggregationMax serves the usage of the busiest container, to spot
+	// a single hot container.
+	PodAggregationMax PodAggregation = "max"
+)
+
+// aggregate rolls up container usage into usage, which is cleared first.
+// Empty aggregation is the same as PodAggregationSum.
+func (a PodAggregation) aggregate(usage v1.ResourceList, containers []metrics.ContainerMetrics) {
+	for k := range usage {
+		delete(usage, k)
+	}
+	for _, container := range containers {
+		for k, v := range container.Usage {
+			u, found := usage[k]
+			switch {
+			case !found:
+				usage[k] = v.DeepCopy()
+			case a == PodAggregationMax:
+				if v.Cmp(u) > 0 {
+					usage[k] = v.DeepCopy()
+				}
+			default:
+				u.Add(v)
+				usage[k] = u
+			}
+		}
+	}
+}
+
+func (a PodAggregation) description() string {
+	if a == PodAggregationMax {
+		return "Maximum usage of the containers of the pod"
+	}
+	return "Total usage of the containers of the pod"
+}
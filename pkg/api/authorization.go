@@ -0,0 +1,116 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/component-base/metrics"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics"
+)
+
+var authorizationDenials = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "api",
+		Name:      "authorization_denials_total",
+		Help:      "Number of requests denied by the authorizer, partitioned by verb and resource. Requests outside of the metrics API are counted under the other resource, and non-resource requests under nonresource.",
+	},
+	[]string{"verb", "resource"},
+)
+
+// Label values of denied requests, kept to a fixed set so that requesters
+// can't create time series with arbitrary verbs or paths.
+var (
+	deniedVerbs     = map[string]bool{"get": true, "list": true, "watch": true}
+	deniedResources = map[string]bool{"nodes": true, "pods": true}
+)
+
+const (
+	deniedOther       = "other"
+	deniedNonResource = "nonresource"
+)
+
+// WithAuthorizationDenialMetrics counts requests which the given authorizer
+// doesn't allow. Requests are refused unless allowed, so requests without an
+// opinion count as denied.
+func WithAuthorizationDenialMetrics(delegate authorizer.Authorizer) authorizer.Authorizer {
+	return &denialCountingAuthorizer{delegate: delegate}
+}
+
+type denialCountingAuthorizer struct {
+	delegate authorizer.Authorizer
+}
+
+func (d *denialCountingAuthorizer) Authorize(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+	decision, reason, err := d.delegate.Authorize(ctx, a)
+	if decision != authorizer.DecisionAllow {
+		authorizationDenials.WithLabelValues(deniedLabels(a)).Inc()
+	}
+	return decision, reason, err
+}
+
+func deniedLabels(a authorizer.Attributes) (verb, resource string) {
+	verb = a.GetVerb()
+	if !deniedVerbs[verb] {
+		verb = deniedOther
+	}
+	switch {
+	case !a.IsResourceRequest():
+		resource = deniedNonResource
+	case a.GetAPIGroup() == metricsapi.GroupName && deniedResources[a.GetResource()]:
+		resource = a.GetResource()
+	default:
+		resource = deniedOther
+	}
+	return verb, resource
+}
+
+// AuthorizeMetrics checks that the user of the request may use verb on the
+// metrics of resource in namespace, or across namespaces if empty, with the
+// attributes the apiserver authorizes requests of the metrics API with. It's
+// needed by handlers served outside of the API, which are only authorized
+// for their path. A nil authorizer allows all requests, like the apiserver.
+func AuthorizeMetrics(ctx context.Context, authz authorizer.Authorizer, verb, resource, namespace, name string) error {
+	if authz == nil {
+		return nil
+	}
+	user, _ := genericapirequest.UserFrom(ctx)
+	decision, reason, err := authz.Authorize(ctx, authorizer.AttributesRecord{
+		User:            user,
+		Verb:            verb,
+		Namespace:       namespace,
+		APIGroup:        metricsapi.GroupName,
+		APIVersion:      "v1beta1",
+		Resource:        resource,
+		Name:            name,
+		ResourceRequest: true,
+	})
+	if decision == authorizer.DecisionAllow {
+		return nil
+	}
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+	scope := "at the cluster scope"
+	if namespace != "" {
+		scope = fmt.Sprintf("in the namespace %q", namespace)
+	}
+	return errors.NewForbidden(metricsapi.Resource(resource), name, fmt.Errorf("cannot %s resource %q %s: %s", verb, resource, scope, reason))
+}
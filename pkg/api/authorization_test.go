@@ -0,0 +1,100 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestWithAuthorizationDenialMetrics(t *testing.T) {
+	authorizationDenials.Create(nil)
+	authorizationDenials.Reset()
+
+	allowed := &user.DefaultInfo{Name: "system:kube-controller-manager"}
+	authz := WithAuthorizationDenialMetrics(authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		if a.GetUser() == allowed {
+			return authorizer.DecisionAllow, "", nil
+		}
+		if a.GetResource() == "nodes" {
+			return authorizer.DecisionDeny, "forbidden", nil
+		}
+		return authorizer.DecisionNoOpinion, "", nil
+	}))
+	denied := &user.DefaultInfo{Name: "system:anonymous"}
+	for _, attrs := range []authorizer.AttributesRecord{
+		{User: allowed, Verb: "list", APIGroup: "metrics.k8s.io", Resource: "pods", ResourceRequest: true},
+		{User: denied, Verb: "list", APIGroup: "metrics.k8s.io", Resource: "pods", ResourceRequest: true},
+		{User: denied, Verb: "get", APIGroup: "metrics.k8s.io", Resource: "pods", ResourceRequest: true},
+		{User: denied, Verb: "get", APIGroup: "metrics.k8s.io", Resource: "nodes", ResourceRequest: true},
+		{User: denied, Verb: "delete", APIGroup: "metrics.k8s.io", Resource: "nodes", ResourceRequest: true},
+		{User: denied, Verb: "get", APIGroup: "metrics.k8s.io", Resource: "random-1234", ResourceRequest: true},
+		{User: denied, Verb: "get", APIGroup: "apps", Resource: "pods", ResourceRequest: true},
+		{User: denied, Verb: "get", Path: "/metrics"},
+	} {
+		decision, _, err := authz.Authorize(context.Background(), attrs)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := attrs.User == allowed; (decision == authorizer.DecisionAllow) != want {
+			t.Errorf("Unexpected decision %v for %+v", decision, attrs)
+		}
+	}
+
+	err := testutil.CollectAndCompare(authorizationDenials, strings.NewReader(`
+	# HELP metrics_server_api_authorization_denials_total [ALPHA] Number of requests denied by the authorizer, partitioned by verb and resource. Requests outside of the metrics API are counted under the other resource, and non-resource requests under nonresource.
+	# TYPE metrics_server_api_authorization_denials_total counter
+	metrics_server_api_authorization_denials_total{resource="nodes",verb="get"} 1
+	metrics_server_api_authorization_denials_total{resource="nodes",verb="other"} 1
+	metrics_server_api_authorization_denials_total{resource="nonresource",verb="get"} 1
+	metrics_server_api_authorization_denials_total{resource="other",verb="get"} 2
+	metrics_server_api_authorization_denials_total{resource="pods",verb="get"} 1
+	metrics_server_api_authorization_denials_total{resource="pods",verb="list"} 1
+	`), "metrics_server_api_authorization_denials_total")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAuthorizeMetrics(t *testing.T) {
+	alice := &user.DefaultInfo{Name: "alice"}
+	authz := authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		if a.GetUser() == alice && a.GetResource() == "pods" && a.GetNamespace() == "default" {
+			return authorizer.DecisionAllow, "", nil
+		}
+		return authorizer.DecisionDeny, "forbidden", nil
+	})
+	ctx := genericapirequest.WithUser(context.Background(), alice)
+
+	if err := AuthorizeMetrics(ctx, authz, "list", "pods", "default", ""); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := AuthorizeMetrics(ctx, authz, "list", "pods", "", ""); !errors.IsForbidden(err) {
+		t.Errorf("Expected pods across namespaces to be forbidden, got: %v", err)
+	}
+	if err := AuthorizeMetrics(ctx, authz, "get", "nodes", "", "node1"); !errors.IsForbidden(err) {
+		t.Errorf("Expected nodes to be forbidden, got: %v", err)
+	}
+	if err := AuthorizeMetrics(context.Background(), nil, "list", "pods", "", ""); err != nil {
+		t.Errorf("Expected requests to be allowed without authorizer, got: %v", err)
+	}
+}
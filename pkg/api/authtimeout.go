@@ -0,0 +1,144 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+)
+
+// authTimeoutKey is the context key of the flag recording that authenticating
+// or authorizing a request timed out.
+type authTimeoutKey struct{}
+
+// authTimedOut records in the request context, if WithAuthTimeoutStatus
+// serves the request, that authentication or authorization timed out.
+func authTimedOut(ctx context.Context) {
+	if flag, ok := ctx.Value(authTimeoutKey{}).(*int32); ok {
+		atomic.StoreInt32(flag, 1)
+	}
+}
+
+// WithAuthenticationTimeout fails authentication of requests which the given
+// authenticator doesn't authenticate within the timeout.
+func WithAuthenticationTimeout(delegate authenticator.Request, timeout time.Duration) authenticator.Request {
+	return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		type result struct {
+			response *authenticator.Response
+			ok       bool
+			err      error
+		}
+		done := make(chan result, 1)
+		go func() {
+			response, ok, err := delegate.AuthenticateRequest(req.WithContext(ctx))
+			done <- result{response, ok, err}
+		}()
+		select {
+		case res := <-done:
+			return res.response, res.ok, res.err
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				authTimedOut(req.Context())
+			}
+			return nil, false, fmt.Errorf("authentication didn't complete within %v", timeout)
+		}
+	})
+}
+
+// WithAuthorizationTimeout fails authorization of requests which the given
+// authorizer doesn't decide on within the timeout.
+func WithAuthorizationTimeout(delegate authorizer.Authorizer, timeout time.Duration) authorizer.Authorizer {
+	return &timeoutAuthorizer{delegate: delegate, timeout: timeout}
+}
+
+type timeoutAuthorizer struct {
+	delegate authorizer.Authorizer
+	timeout  time.Duration
+}
+
+func (t *timeoutAuthorizer) Authorize(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+	timeoutCtx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	type result struct {
+		decision authorizer.Decision
+		reason   string
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		decision, reason, err := t.delegate.Authorize(timeoutCtx, a)
+		done <- result{decision, reason, err}
+	}()
+	select {
+	case res := <-done:
+		return res.decision, res.reason, res.err
+	case <-timeoutCtx.Done():
+		if timeoutCtx.Err() == context.DeadlineExceeded {
+			authTimedOut(ctx)
+		}
+		return authorizer.DecisionNoOpinion, "", fmt.Errorf("authorization didn't complete within %v", t.timeout)
+	}
+}
+
+// WithAuthTimeoutStatus makes requests whose authentication or authorization
+// timed out fail with 503 Service Unavailable, instead of the 401 or 500 the
+// authentication and authorization filters respond with. It must wrap them.
+func WithAuthTimeoutStatus(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		flag := new(int32)
+		req = req.WithContext(context.WithValue(req.Context(), authTimeoutKey{}, flag))
+		handler.ServeHTTP(&authTimeoutWriter{ResponseWriter: w, timedOut: flag}, req)
+	})
+}
+
+// authTimeoutWriter replaces the response with 503 Service Unavailable if
+// authentication or authorization timed out before it was written.
+type authTimeoutWriter struct {
+	http.ResponseWriter
+	timedOut *int32
+	replaced bool
+}
+
+func (w *authTimeoutWriter) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && atomic.LoadInt32(w.timedOut) == 1 {
+		w.replaced = true
+		w.Header().Set("Retry-After", "1")
+		http.Error(w.ResponseWriter, "authentication or authorization timed out", http.StatusServiceUnavailable)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *authTimeoutWriter) Write(data []byte) (int, error) {
+	if w.replaced {
+		// drop the body of the replaced response
+		return len(data), nil
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *authTimeoutWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		flusher.Flush()
+	}
+}
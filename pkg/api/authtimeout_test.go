@@ -0,0 +1,113 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapifilters "k8s.io/apiserver/pkg/endpoints/filters"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+func TestAuthTimeouts(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	authenticate := func(delay time.Duration) authenticator.Request {
+		return authenticator.RequestFunc(func(req *http.Request) (*authenticator.Response, bool, error) {
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+			}
+			return &authenticator.Response{User: &user.DefaultInfo{Name: "admin"}}, true, nil
+		})
+	}
+	authorize := func(delay time.Duration, decision authorizer.Decision) authorizer.Authorizer {
+		return &delayedAuthorizer{delay: delay, decision: decision}
+	}
+
+	for _, tc := range []struct {
+		name         string
+		authn        authenticator.Request
+		authz        authorizer.Authorizer
+		expectStatus int
+	}{
+		{
+			name:         "allowed within the timeouts",
+			authn:        authenticate(0),
+			authz:        authorize(0, authorizer.DecisionAllow),
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "denied within the timeouts",
+			authn:        authenticate(0),
+			authz:        authorize(0, authorizer.DecisionDeny),
+			expectStatus: http.StatusForbidden,
+		},
+		{
+			name:         "authentication exceeding the timeout",
+			authn:        authenticate(time.Minute),
+			authz:        authorize(0, authorizer.DecisionAllow),
+			expectStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:         "authorization exceeding the timeout",
+			authn:        authenticate(0),
+			authz:        authorize(time.Minute, authorizer.DecisionAllow),
+			expectStatus: http.StatusServiceUnavailable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+			handler = genericapifilters.WithAuthorization(handler, WithAuthorizationTimeout(tc.authz, timeout), Codecs)
+			handler = genericapifilters.WithAuthentication(handler, WithAuthenticationTimeout(tc.authn, timeout), genericapifilters.Unauthorized(Codecs), nil)
+			handler = genericapifilters.WithRequestInfo(handler, &genericapirequest.RequestInfoFactory{
+				APIPrefixes:          sets.NewString("api", "apis"),
+				GrouplessAPIPrefixes: sets.NewString("api"),
+			})
+			handler = WithAuthTimeoutStatus(handler)
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "/apis/metrics.k8s.io/v1beta1/nodes", nil))
+			if w.Code != tc.expectStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.expectStatus, w.Code, w.Body.String())
+			}
+			if elapsed := time.Since(start); elapsed > 10*timeout {
+				t.Errorf("Expected the request to fail fast, took %v", elapsed)
+			}
+		})
+	}
+}
+
+// delayedAuthorizer decides after a delay, unless the context is done first.
+type delayedAuthorizer struct {
+	delay    time.Duration
+	decision authorizer.Decision
+}
+
+func (a *delayedAuthorizer) Authorize(ctx context.Context, _ authorizer.Attributes) (authorizer.Decision, string, error) {
+	select {
+	case <-time.After(a.delay):
+	case <-ctx.Done():
+	}
+	return a.decision, "", nil
+}
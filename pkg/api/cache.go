@@ -0,0 +1,105 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// responseCache keeps list responses for a short time, so clients polling
+// the same list within a scrape interval don't recompute it. Responses are
+// dropped once newer metrics are stored. A nil cache caches nothing.
+type responseCache struct {
+	ttl        time.Duration
+	generation MetricsGeneration
+	now        func() time.Time
+
+	mu sync.Mutex
+	// entries are responses of the generation they were computed for
+	entries           map[string]cachedResponse
+	entriesGeneration uint64
+}
+
+type cachedResponse struct {
+	response interface{}
+	expires  time.Time
+}
+
+// newResponseCache returns a cache keeping responses for the configured TTL,
+// or nil if caching is disabled.
+func newResponseCache(config Config) *responseCache {
+	if config.ResponseCacheTTL <= 0 || config.MetricsGeneration == nil {
+		return nil
+	}
+	return &responseCache{
+		ttl:        config.ResponseCacheTTL,
+		generation: config.MetricsGeneration,
+		now:        time.Now,
+		entries:    map[string]cachedResponse{},
+	}
+}
+
+// do returns the cached response for the key, or computes it with fn and
+// caches it if it succeeds. Responses computed while new metrics were stored
+// are not served from cache.
+func (c *responseCache) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	if c == nil {
+		return fn()
+	}
+	generation := c.generation.Generation()
+	if res, found := c.get(key, generation); found {
+		return res, nil
+	}
+	res, err := fn()
+	if err != nil {
+		return res, err
+	}
+	c.set(key, generation, res)
+	return res, nil
+}
+
+func (c *responseCache) get(key string, generation uint64) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.entriesGeneration {
+		return nil, false
+	}
+	entry, found := c.entries[key]
+	if !found || !c.now().Before(entry.expires) {
+		return nil, false
+	}
+	return entry.response, true
+}
+
+func (c *responseCache) set(key string, generation uint64, res interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.entriesGeneration {
+		if generation < c.entriesGeneration {
+			// newer metrics were stored while computing
+			return
+		}
+		c.entries = map[string]cachedResponse{}
+		c.entriesGeneration = generation
+	}
+	now := c.now()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedResponse{response: res, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/fields"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+type fakeMetricsGeneration struct {
+	generation uint64
+}
+
+func (g *fakeMetricsGeneration) Generation() uint64 {
+	return g.generation
+}
+
+// countingNodeMetricsGetter counts reads of node metrics.
+type countingNodeMetricsGetter struct {
+	NodeMetricsGetter
+	reads int
+}
+
+func (g *countingNodeMetricsGetter) GetNodeMetrics(ctx context.Context, nodes ...string) ([]TimeInfo, []v1.ResourceList, error) {
+	g.reads++
+	return g.NodeMetricsGetter.GetNodeMetrics(ctx, nodes...)
+}
+
+func TestNodeList_ResponseCache(t *testing.T) {
+	r := NewTestNodeStorage(createTestNodes(), nil)
+	getter := &countingNodeMetricsGetter{NodeMetricsGetter: r.metrics}
+	r.metrics = getter
+	generation := &fakeMetricsGeneration{}
+	r.listCache = newResponseCache(Config{ResponseCacheTTL: 10 * time.Second, MetricsGeneration: generation})
+	now := time.Now()
+	r.listCache.now = func() time.Time { return now }
+
+	list := func(options *metainternalversion.ListOptions) {
+		t.Helper()
+		if _, err := r.List(genericapirequest.NewContext(), options); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	expectReads := func(step string, reads int) {
+		t.Helper()
+		if getter.reads != reads {
+			t.Errorf("%s: expected %d metrics reads, got %d", step, reads, getter.reads)
+		}
+	}
+
+	list(nil)
+	list(nil)
+	expectReads("repeated list within TTL", 1)
+
+	list(&metainternalversion.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", "node1")})
+	expectReads("list with a different selector", 2)
+
+	now = now.Add(5 * time.Second)
+	generation.generation++
+	list(nil)
+	expectReads("list after metrics were stored", 3)
+	list(nil)
+	expectReads("repeated list after metrics were stored", 3)
+
+	now = now.Add(10 * time.Second)
+	list(nil)
+	expectReads("list after TTL expired", 4)
+}
+
+func TestNodeList_ResponseCacheDisabled(t *testing.T) {
+	r := NewTestNodeStorage(createTestNodes(), nil)
+	getter := &countingNodeMetricsGetter{NodeMetricsGetter: r.metrics}
+	r.metrics = getter
+	r.listCache = newResponseCache(Config{MetricsGeneration: &fakeMetricsGeneration{}})
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.List(genericapirequest.NewContext(), nil); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if getter.reads != 2 {
+		t.Errorf("Expected every list to read metrics without a TTL, got %d reads", getter.reads)
+	}
+}
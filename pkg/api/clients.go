@@ -0,0 +1,63 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// Client classes used to label served requests. The set is kept small so the
+// number of time series stays bounded no matter who calls the API.
+const (
+	clientHPA     = "hpa"
+	clientKubectl = "kubectl"
+	clientOther   = "other"
+)
+
+// hpaUsers are the users the horizontal pod autoscaler controller runs as,
+// depending on whether kube-controller-manager uses service account credentials.
+var hpaUsers = map[string]bool{
+	"system:kube-controller-manager":                              true,
+	"system:serviceaccount:kube-system:horizontal-pod-autoscaler": true,
+}
+
+// clientClass infers the kind of client from the authenticated user and user agent.
+func clientClass(u user.Info, userAgent string) string {
+	if u != nil && hpaUsers[u.GetName()] {
+		return clientHPA
+	}
+	if strings.HasPrefix(userAgent, "kubectl/") {
+		return clientKubectl
+	}
+	return clientOther
+}
+
+// WithRequestMetrics counts requests for metrics API resources by client class.
+// It must be wrapped by the authentication filter to see the requesting user.
+func WithRequestMetrics(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := req.Context()
+		if info, ok := genericapirequest.RequestInfoFrom(ctx); ok && info.IsResourceRequest && info.APIGroup == metrics.GroupName {
+			u, _ := genericapirequest.UserFrom(ctx)
+			requestTotal.WithLabelValues(clientClass(u, req.UserAgent())).Inc()
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
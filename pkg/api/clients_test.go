@@ -0,0 +1,62 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"k8s.io/apiserver/pkg/authentication/user"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestWithRequestMetrics(t *testing.T) {
+	requestTotal.Create(nil)
+	requestTotal.Reset()
+
+	handler := WithRequestMetrics(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	serve := func(group, userName, userAgent string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("User-Agent", userAgent)
+		ctx := genericapirequest.WithRequestInfo(req.Context(), &genericapirequest.RequestInfo{
+			IsResourceRequest: true,
+			APIGroup:          group,
+			Resource:          "pods",
+		})
+		ctx = genericapirequest.WithUser(ctx, &user.DefaultInfo{Name: userName})
+		handler.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	}
+
+	serve("metrics.k8s.io", "admin", "kubectl/v1.19.2 (linux/amd64) kubernetes/f574309")
+	serve("metrics.k8s.io", "admin", "kubectl/v1.19.2 (linux/amd64) kubernetes/f574309")
+	serve("metrics.k8s.io", "system:kube-controller-manager", "kube-controller-manager/v1.19.2 (linux/amd64) kubernetes/f574309")
+	serve("metrics.k8s.io", "system:serviceaccount:kube-system:horizontal-pod-autoscaler", "kube-controller-manager/v1.19.2 (linux/amd64) kubernetes/f574309")
+	serve("metrics.k8s.io", "system:serviceaccount:monitoring:prometheus-adapter", "Go-http-client/2.0")
+	serve("apps", "admin", "kubectl/v1.19.2 (linux/amd64) kubernetes/f574309")
+
+	err := testutil.CollectAndCompare(requestTotal, strings.NewReader(`
+	# HELP metrics_server_api_requests_total [ALPHA] Number of requests served by the metrics API, partitioned by client class (hpa, kubectl or other).
+	# TYPE metrics_server_api_requests_total counter
+	metrics_server_api_requests_total{client="hpa"} 2
+	metrics_server_api_requests_total{client="kubectl"} 2
+	metrics_server_api_requests_total{client="other"} 1
+	`), "metrics_server_api_requests_total")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// dampenedExpiry is how long a served memory value is kept for an object
+// which isn't served anymore, e.g. a deleted pod.
+const dampenedExpiry = 10 * time.Minute
+
+// memoryDampener holds served memory usage until it changes by more than a
+// minimum delta, so that consumers detecting changes aren't notified of
+// trivially small fluctuations. CPU usage isn't held, and stored metrics are
+// left untouched. A nil memoryDampener serves usage as it is.
+type memoryDampener struct {
+	minDelta int64
+
+	mu        sync.Mutex
+	served    map[string]dampenedMemory
+	lastPrune time.Time
+}
+
+type dampenedMemory struct {
+	quantity resource.Quantity
+	seen     time.Time
+}
+
+// newMemoryDampener returns a dampener holding memory usage changing by at
+// most minDelta bytes, or nil if minDelta isn't positive.
+func newMemoryDampener(minDelta int64) *memoryDampener {
+	if minDelta <= 0 {
+		return nil
+	}
+	return &memoryDampener{minDelta: minDelta, served: map[string]dampenedMemory{}, lastPrune: myClock.Now()}
+}
+
+// Dampen returns usage with the memory previously served for the object under
+// key if the memory usage changed by at most the minimum delta since, and
+// records the memory served otherwise.
+func (d *memoryDampener) Dampen(key string, usage v1.ResourceList) v1.ResourceList {
+	if d == nil {
+		return usage
+	}
+	memory, found := usage[v1.ResourceMemory]
+	if !found {
+		return usage
+	}
+	now := myClock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(now)
+	previous, found := d.served[key]
+	if found && abs(memory.Value()-previous.quantity.Value()) <= d.minDelta {
+		d.served[key] = dampenedMemory{quantity: previous.quantity, seen: now}
+		held := make(v1.ResourceList, len(usage))
+		for name, quantity := range usage {
+			held[name] = quantity
+		}
+		held[v1.ResourceMemory] = previous.quantity
+		return held
+	}
+	d.served[key] = dampenedMemory{quantity: memory, seen: now}
+	return usage
+}
+
+// prune forgets the memory of objects not served within dampenedExpiry, at
+// most once per expiry. The caller must hold the lock.
+func (d *memoryDampener) prune(now time.Time) {
+	if now.Sub(d.lastPrune) < dampenedExpiry {
+		return
+	}
+	for key, memory := range d.served {
+		if now.Sub(memory.seen) >= dampenedExpiry {
+			delete(d.served, key)
+		}
+	}
+	d.lastPrune = now
+}
+
+func abs(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+func TestMemoryDampener(t *testing.T) {
+	myClock = &fakeClock{now: time.Now()}
+	d := newMemoryDampener(1024)
+
+	for _, step := range []struct {
+		name         string
+		cpu, memory  int64
+		expectCPU    int64
+		expectMemory int64
+	}{
+		{name: "First value is served", cpu: 10, memory: 10000, expectCPU: 10, expectMemory: 10000},
+		{name: "Change below the delta holds memory", cpu: 20, memory: 10500, expectCPU: 20, expectMemory: 10000},
+		{name: "Change at the delta holds memory", cpu: 30, memory: 11024, expectCPU: 30, expectMemory: 10000},
+		{name: "Change above the delta is served", cpu: 40, memory: 11025, expectCPU: 40, expectMemory: 11025},
+		{name: "Change is relative to the last served value", cpu: 50, memory: 10500, expectCPU: 50, expectMemory: 11025},
+		{name: "Drop above the delta is served", cpu: 60, memory: 9000, expectCPU: 60, expectMemory: 9000},
+	} {
+		usage := v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewMilliQuantity(step.cpu, resource.DecimalSI),
+			v1.ResourceMemory: *resource.NewQuantity(step.memory, resource.BinarySI),
+		}
+		got := d.Dampen("node/node1", usage)
+		if cpu := got[v1.ResourceCPU]; cpu.MilliValue() != step.expectCPU {
+			t.Errorf("%s: expected CPU %dm, got %s", step.name, step.expectCPU, cpu.String())
+		}
+		if memory := got[v1.ResourceMemory]; memory.Value() != step.expectMemory {
+			t.Errorf("%s: expected memory %d, got %s", step.name, step.expectMemory, memory.String())
+		}
+		if memory := usage[v1.ResourceMemory]; memory.Value() != step.memory {
+			t.Errorf("%s: expected the given usage to be left untouched, got memory %s", step.name, memory.String())
+		}
+	}
+}
+
+func TestMemoryDampener_ForgetsObjectsNotServed(t *testing.T) {
+	c := &fakeClock{now: time.Now()}
+	myClock = c
+	d := newMemoryDampener(1024)
+	usage := func(memory int64) v1.ResourceList {
+		return v1.ResourceList{v1.ResourceMemory: *resource.NewQuantity(memory, resource.BinarySI)}
+	}
+
+	d.Dampen("pod/ns1/pod1/container1", usage(10000))
+	c.now = c.now.Add(dampenedExpiry)
+	d.Dampen("pod/ns1/pod2/container1", usage(10000))
+	if _, found := d.served["pod/ns1/pod1/container1"]; found {
+		t.Error("Expected memory of a container not served within the expiry to be forgotten")
+	}
+	if got := d.Dampen("pod/ns1/pod1/container1", usage(10500)); got.Memory().Value() != 10500 {
+		t.Errorf("Expected a forgotten container to be served its latest memory, got %v", got.Memory())
+	}
+}
+
+func TestMemoryDampener_Disabled(t *testing.T) {
+	if d := newMemoryDampener(0); d != nil {
+		t.Fatalf("Expected no dampener without a delta, got %+v", d)
+	}
+	var d *memoryDampener
+	usage := v1.ResourceList{v1.ResourceMemory: *resource.NewQuantity(10000, resource.BinarySI)}
+	if got := d.Dampen("node/node1", usage); got.Memory().Value() != 10000 {
+		t.Errorf("Expected usage to be served as it is, got %v", got.Memory())
+	}
+}
+
+func TestNodeGet_MemoryMinDelta(t *testing.T) {
+	r := NewTestNodeStorage(createTestNodes(), nil)
+	r.memoryDampener = newMemoryDampener(1 << 20)
+	usage := v1.ResourceList{v1.ResourceMemory: *resource.NewQuantity(100<<20, resource.BinarySI)}
+	r.metrics = fakeNodeMetricsGetter{
+		time:      []TimeInfo{{Timestamp: myClock.Now(), Window: 1000}},
+		resources: []v1.ResourceList{usage},
+	}
+
+	get := func() int64 {
+		got, err := r.Get(genericapirequest.NewContext(), "node1", nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return got.(*metrics.NodeMetrics).Usage.Memory().Value()
+	}
+	served := get()
+	usage[v1.ResourceMemory] = *resource.NewQuantity(served+1000, resource.BinarySI)
+	if got := get(); got != served {
+		t.Errorf("Expected memory %d to be held, got %d", served, got)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// UsageDeltaAnnotation is the change of the served usage of a node or pod
+// since the previous point served for it, e.g. "cpu=-20m,memory=4Mi". Pods
+// are annotated with the change of the total usage of their containers.
+const UsageDeltaAnnotation = "metrics.k8s.io/usage-delta"
+
+// usageDeltas tracks the latest points served for each object, to annotate
+// them with the change since the point served before. Points are only known
+// once served, so a delta spans every cycle since an object was last served.
+// A nil usageDeltas doesn't annotate.
+type usageDeltas struct {
+	mu        sync.Mutex
+	served    map[string]servedUsage
+	lastPrune time.Time
+}
+
+type servedUsage struct {
+	timestamp time.Time
+	usage     v1.ResourceList
+	// delta is the change since the point served before, nil if there's none
+	delta v1.ResourceList
+	seen  time.Time
+}
+
+func newUsageDeltas(enabled bool) *usageDeltas {
+	if !enabled {
+		return nil
+	}
+	return &usageDeltas{served: map[string]servedUsage{}, lastPrune: myClock.Now()}
+}
+
+// Delta records the usage served for the object under key at a point with the
+// given timestamp, and returns the change since the previous point served for
+// it. It returns nil for the first point served, and for points older than
+// the latest one.
+func (d *usageDeltas) Delta(key string, timestamp time.Time, usage v1.ResourceList) v1.ResourceList {
+	if d == nil {
+		return nil
+	}
+	now := myClock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune(now)
+	latest, found := d.served[key]
+	switch {
+	case !found:
+		d.served[key] = servedUsage{timestamp: timestamp, usage: usage.DeepCopy(), seen: now}
+		return nil
+	case timestamp.Equal(latest.timestamp):
+		latest.seen = now
+		d.served[key] = latest
+		return latest.delta
+	case timestamp.Before(latest.timestamp):
+		return nil
+	}
+	delta := v1.ResourceList{}
+	for name, quantity := range usage {
+		previous, found := latest.usage[name]
+		if !found {
+			continue
+		}
+		change := quantity.DeepCopy()
+		change.Sub(previous)
+		delta[name] = change
+	}
+	if len(delta) == 0 {
+		delta = nil
+	}
+	d.served[key] = servedUsage{timestamp: timestamp, usage: usage.DeepCopy(), delta: delta, seen: now}
+	return delta
+}
+
+// prune forgets the usage of objects not served within dampenedExpiry, at
+// most once per expiry. The caller must hold the lock.
+func (d *usageDeltas) prune(now time.Time) {
+	if now.Sub(d.lastPrune) < dampenedExpiry {
+		return
+	}
+	for key, usage := range d.served {
+		if now.Sub(usage.seen) >= dampenedExpiry {
+			delete(d.served, key)
+		}
+	}
+	d.lastPrune = now
+}
+
+// markUsageDelta annotates the object with the change of its usage, unless
+// there's none known.
+func markUsageDelta(meta *metav1.ObjectMeta, delta v1.ResourceList) {
+	if delta == nil {
+		return
+	}
+	var parts []string
+	for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		if quantity, found := delta[name]; found {
+			parts = append(parts, fmt.Sprintf("%s=%s", name, quantity.String()))
+		}
+	}
+	if len(parts) == 0 {
+		return
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[UsageDeltaAnnotation] = strings.Join(parts, ",")
+}
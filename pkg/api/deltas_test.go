@@ -0,0 +1,132 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+func TestUsageDeltas(t *testing.T) {
+	myClock = &fakeClock{now: time.Now()}
+	d := newUsageDeltas(true)
+	start := myClock.Now()
+
+	for _, step := range []struct {
+		name        string
+		timestamp   time.Time
+		cpu, memory int64
+		expect      string
+	}{
+		{name: "First point has no delta", timestamp: start, cpu: 100, memory: 10000},
+		{name: "Point served again has no delta", timestamp: start, cpu: 100, memory: 10000},
+		{name: "Next point has the change", timestamp: start.Add(time.Minute), cpu: 80, memory: 12048, expect: "cpu=-20m,memory=2Ki"},
+		{name: "Point served again has the same change", timestamp: start.Add(time.Minute), cpu: 80, memory: 12048, expect: "cpu=-20m,memory=2Ki"},
+		{name: "Older point has no delta", timestamp: start, cpu: 100, memory: 10000},
+		{name: "Change is relative to the latest point", timestamp: start.Add(2 * time.Minute), cpu: 90, memory: 12048, expect: "cpu=10m,memory=0"},
+	} {
+		usage := v1.ResourceList{
+			v1.ResourceCPU:    *resource.NewMilliQuantity(step.cpu, resource.DecimalSI),
+			v1.ResourceMemory: *resource.NewQuantity(step.memory, resource.BinarySI),
+		}
+		node := &metrics.NodeMetrics{}
+		markUsageDelta(&node.ObjectMeta, d.Delta("node/node1", step.timestamp, usage))
+		if got := node.Annotations[UsageDeltaAnnotation]; got != step.expect {
+			t.Errorf("%s: expected delta %q, got %q", step.name, step.expect, got)
+		}
+	}
+}
+
+func TestUsageDeltas_Disabled(t *testing.T) {
+	var d *usageDeltas
+	if delta := d.Delta("node/node1", time.Now(), v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}); delta != nil {
+		t.Errorf("Expected no delta when disabled, got %v", delta)
+	}
+}
+
+func TestNodeList_UsageDeltas(t *testing.T) {
+	c := &fakeClock{now: time.Now()}
+	myClock = c
+	r := NewTestNodeStorage(createTestNodes(), nil)
+	r.usageDeltas = newUsageDeltas(true)
+	cycle := func(cpu ...string) map[string]string {
+		getter := fakeNodeMetricsGetter{}
+		for _, quantity := range cpu {
+			getter.time = append(getter.time, TimeInfo{Timestamp: c.now, Window: time.Second})
+			getter.resources = append(getter.resources, v1.ResourceList{v1.ResourceCPU: resource.MustParse(quantity)})
+		}
+		r.metrics = getter
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		deltas := map[string]string{}
+		for _, node := range got.(*metrics.NodeMetricsList).Items {
+			if delta, found := node.Annotations[UsageDeltaAnnotation]; found {
+				deltas[node.Name] = delta
+			}
+		}
+		return deltas
+	}
+
+	if got := cycle("100m", "200m", "300m"); len(got) != 0 {
+		t.Errorf("Expected no deltas for the first cycle, got %v", got)
+	}
+	c.now = c.now.Add(time.Minute)
+	got := cycle("150m", "200m", "250m")
+	expect := map[string]string{"node1": "cpu=50m", "node2": "cpu=0", "node3": "cpu=-50m"}
+	if len(got) != len(expect) {
+		t.Fatalf("Expected deltas %v, got %v", expect, got)
+	}
+	for node, delta := range expect {
+		if got[node] != delta {
+			t.Errorf("Expected node %s annotated with delta %q, got %q", node, delta, got[node])
+		}
+	}
+}
+
+func TestPodList_UsageDeltas(t *testing.T) {
+	c := &fakeClock{now: time.Now()}
+	myClock = c
+	r := NewPodTestStorage(createTestPods()[:1], nil)
+	r.usageDeltas = newUsageDeltas(true)
+	cycle := func(cpu1, cpu2 string) string {
+		r.metrics = fakePodMetricsGetter{
+			time: []TimeInfo{{Timestamp: c.now, Window: time.Second}},
+			metrics: [][]metrics.ContainerMetrics{{
+				{Name: "container1", Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu1)}},
+				{Name: "container2", Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu2)}},
+			}},
+		}
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return got.(*metrics.PodMetricsList).Items[0].Annotations[UsageDeltaAnnotation]
+	}
+
+	if got := cycle("100m", "200m"); got != "" {
+		t.Errorf("Expected no delta for the first cycle, got %q", got)
+	}
+	c.now = c.now.Add(time.Minute)
+	if got, expect := cycle("150m", "100m"), "cpu=-50m"; got != expect {
+		t.Errorf("Expected the change of the total usage of the containers %q, got %q", expect, got)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// withoutEphemeralContainers returns the container metrics of the pod,
+// leaving out the ephemeral containers in its spec, e.g. debug containers
+// added by kubectl debug, which aren't part of the pod workload.
+func withoutEphemeralContainers(pod *v1.Pod, containers []metrics.ContainerMetrics) []metrics.ContainerMetrics {
+	if len(pod.Spec.EphemeralContainers) == 0 {
+		return containers
+	}
+	ephemeral := make(map[string]bool, len(pod.Spec.EphemeralContainers))
+	for _, container := range pod.Spec.EphemeralContainers {
+		ephemeral[container.Name] = true
+	}
+	kept := make([]metrics.ContainerMetrics, 0, len(containers))
+	for _, container := range containers {
+		if !ephemeral[container.Name] {
+			kept = append(kept, container)
+		}
+	}
+	return kept
+}
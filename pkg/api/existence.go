@@ -0,0 +1,39 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// missingBeyondGrace returns whether the node is missing from the node lister
+// for longer than the existence grace, e.g. after a node is deleted while its
+// metrics, scraped at timestamp, are still stored. The node existed when it
+// was scraped, so it has been missing for at most the time since. Nodes which
+// can't be looked up aren't missing.
+func (m *nodeMetrics) missingBeyondGrace(name string, timestamp time.Time) bool {
+	if m.existenceGrace <= 0 {
+		return false
+	}
+	_, err := m.nodeLister.Get(name)
+	if !errors.IsNotFound(err) || myClock.Since(timestamp) <= m.existenceGrace {
+		return false
+	}
+	klog.V(2).Infof("skipping node %s, missing from the node lister for more than %s", name, m.existenceGrace)
+	return true
+}
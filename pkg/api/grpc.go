@@ -0,0 +1,144 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	coreinf "k8s.io/client-go/informers/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+
+	"sigs.k8s.io/metrics-server/pkg/api/rpc"
+)
+
+// NewGRPCServer returns a gRPC server serving the same metrics as the
+// metrics.k8s.io API built by Build with the same arguments. Calls are
+// authorized with authz like requests of the API.
+func NewGRPCServer(m MetricsGetter, informers coreinf.Interface, config Config, authz authorizer.Authorizer) *grpc.Server {
+	node, pod := newStorage(m, informers, config)
+	return rpc.NewServer(&grpcMetrics{node: node, pod: pod, authz: authz})
+}
+
+// grpcMetrics implements the Metrics gRPC service with the REST storage,
+// so both APIs filter, round and mark metrics alike.
+type grpcMetrics struct {
+	node *nodeMetrics
+	pod  *podMetrics
+	// authz authorizes each call for the metrics it reads, as the gRPC
+	// handler is only authorized for its path
+	authz authorizer.Authorizer
+}
+
+var _ rpc.MetricsServer = (*grpcMetrics)(nil)
+
+func (g *grpcMetrics) GetNodeMetrics(ctx context.Context, req *rpc.GetNodeMetricsRequest) (*v1beta1.NodeMetrics, error) {
+	if err := AuthorizeMetrics(ctx, g.authz, "get", "nodes", "", req.Name); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	obj, err := g.node.Get(ctx, req.Name, &metav1.GetOptions{})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	out := &v1beta1.NodeMetrics{}
+	if err := Scheme.Convert(obj, out, nil); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *grpcMetrics) GetPodMetrics(ctx context.Context, req *rpc.GetPodMetricsRequest) (*v1beta1.PodMetrics, error) {
+	if err := AuthorizeMetrics(ctx, g.authz, "get", "pods", req.Namespace, req.Name); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	obj, err := g.pod.Get(genericapirequest.WithNamespace(ctx, req.Namespace), req.Name, &metav1.GetOptions{})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	out := &v1beta1.PodMetrics{}
+	if err := Scheme.Convert(obj, out, nil); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *grpcMetrics) ListPodMetrics(ctx context.Context, req *rpc.ListPodMetricsRequest) (*v1beta1.PodMetricsList, error) {
+	if err := AuthorizeMetrics(ctx, g.authz, "list", "pods", req.Namespace, ""); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	options, err := podListOptions(req)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	obj, err := g.pod.List(genericapirequest.WithNamespace(ctx, req.Namespace), options)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	out := &v1beta1.PodMetricsList{}
+	if err := Scheme.Convert(obj, out, nil); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+// podListOptions parses the selectors of a request, validating field labels
+// like the apiserver does for REST requests.
+func podListOptions(req *rpc.ListPodMetricsRequest) (*metainternalversion.ListOptions, error) {
+	labelSelector, err := labels.Parse(req.LabelSelector)
+	if err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	fieldSelector, err := fields.ParseSelector(req.FieldSelector)
+	if err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	fieldSelector, err = fieldSelector.Transform(func(label, value string) (string, string, error) {
+		return Scheme.ConvertFieldLabel(v1beta1.SchemeGroupVersion.WithKind("PodMetrics"), label, value)
+	})
+	if err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	return &metainternalversion.ListOptions{LabelSelector: labelSelector, FieldSelector: fieldSelector}, nil
+}
+
+// grpcError converts errors returned by the REST storage to gRPC status
+// errors. Errors of calls whose context is done report why it is.
+func grpcError(ctx context.Context, err error) error {
+	switch {
+	case ctx.Err() == context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case ctx.Err() == context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.IsNotFound(err):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.IsBadRequest(err), errors.IsInvalid(err):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.IsForbidden(err):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.IsServiceUnavailable(err):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
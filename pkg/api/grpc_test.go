@@ -0,0 +1,132 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+
+	"sigs.k8s.io/metrics-server/pkg/api/rpc"
+)
+
+// newTestGRPCClient serves the Metrics service over HTTP/2 with TLS, like on
+// the secure port, and returns a client connected to it.
+func newTestGRPCClient(t *testing.T, srv rpc.MetricsServer) rpc.MetricsClient {
+	server := httptest.NewUnstartedServer(rpc.NewServer(srv))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	conn, err := grpc.Dial(server.Listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(roots, "")))
+	if err != nil {
+		t.Fatalf("Unexpected error dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return rpc.NewMetricsClient(conn)
+}
+
+func TestGRPCListPodMetrics(t *testing.T) {
+	storage := NewPodTestStorage(createTestPods(), nil)
+	client := newTestGRPCClient(t, &grpcMetrics{pod: storage})
+
+	res, err := client.ListPodMetrics(context.Background(), &rpc.ListPodMetricsRequest{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(res.Items) != 3 {
+		t.Fatalf("Expected 3 pods, got: %+v", res.Items)
+	}
+
+	obj, err := storage.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := &v1beta1.PodMetricsList{}
+	if err := Scheme.Convert(obj, expected, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i, got := range res.Items {
+		want := expected.Items[i]
+		// timestamps are serialized with second precision
+		if got.Namespace != want.Namespace || got.Name != want.Name || got.Window != want.Window || !apiequality.Semantic.DeepEqual(got.Containers, want.Containers) {
+			t.Errorf("Expected pod %d to be the same as from the REST API, got: %+v, want: %+v", i, got, want)
+		}
+	}
+}
+
+func TestGRPCListPodMetrics_InvalidFieldSelector(t *testing.T) {
+	client := newTestGRPCClient(t, &grpcMetrics{pod: NewPodTestStorage(createTestPods(), nil)})
+
+	_, err := client.ListPodMetrics(context.Background(), &rpc.ListPodMetricsRequest{FieldSelector: "status.phase=Running"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid argument error, got: %v", err)
+	}
+}
+
+func TestGRPCGetNodeMetrics(t *testing.T) {
+	client := newTestGRPCClient(t, &grpcMetrics{node: NewTestNodeStorage(createTestNodes(), nil)})
+
+	res, err := client.GetNodeMetrics(context.Background(), &rpc.GetNodeMetricsRequest{Name: "node1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Name != "node1" {
+		t.Errorf("Expected node1, got %s", res.Name)
+	}
+}
+
+func TestGRPCGetPodMetrics_NotFound(t *testing.T) {
+	client := newTestGRPCClient(t, &grpcMetrics{pod: NewPodTestStorage((*v1.Pod)(nil), errors.NewNotFound(v1.Resource("pods"), "missing"))})
+
+	_, err := client.GetPodMetrics(context.Background(), &rpc.GetPodMetricsRequest{Namespace: "other", Name: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected a not found error, got: %v", err)
+	}
+}
+
+func TestGRPCListPodMetrics_Forbidden(t *testing.T) {
+	var attrs []authorizer.Attributes
+	authz := authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+		attrs = append(attrs, a)
+		return authorizer.DecisionNoOpinion, "no RBAC policy matched", nil
+	})
+	client := newTestGRPCClient(t, &grpcMetrics{pod: NewPodTestStorage(createTestPods(), nil), authz: authz})
+
+	_, err := client.ListPodMetrics(context.Background(), &rpc.ListPodMetricsRequest{Namespace: "other"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected a permission denied error, got: %v", err)
+	}
+	if len(attrs) != 1 {
+		t.Fatalf("Expected a single authorization, got: %+v", attrs)
+	}
+	if a := attrs[0]; !a.IsResourceRequest() || a.GetVerb() != "list" || a.GetAPIGroup() != "metrics.k8s.io" || a.GetResource() != "pods" || a.GetNamespace() != "other" {
+		t.Errorf("Unexpected authorization attributes: %+v", a)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+)
+
+// healthyNodes withholds metrics while too few nodes have metrics stored, so
+// that autoscalers don't act on an unrepresentative sample, e.g. right after
+// startup or during an outage of many Kubelets.
+type healthyNodes struct {
+	nodeLister  v1listers.NodeLister
+	metrics     NodeMetricsGetter
+	minFraction float64
+}
+
+// newHealthyNodes returns nil, never withholding metrics, if minFraction is zero.
+func newHealthyNodes(nodeLister v1listers.NodeLister, metrics NodeMetricsGetter, minFraction float64) *healthyNodes {
+	if minFraction <= 0 {
+		return nil
+	}
+	return &healthyNodes{nodeLister: nodeLister, metrics: metrics, minFraction: minFraction}
+}
+
+// check returns a ServiceUnavailable error if less than minFraction of the
+// nodes in the lister have metrics stored.
+func (h *healthyNodes) check(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	nodes, err := h.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Name
+	}
+	_, usages, err := h.metrics.GetNodeMetrics(ctx, names...)
+	if err != nil {
+		return err
+	}
+	healthy := 0
+	for _, usage := range usages {
+		if usage != nil {
+			healthy++
+		}
+	}
+	if float64(healthy) < h.minFraction*float64(len(nodes)) {
+		return errors.NewServiceUnavailable(fmt.Sprintf("metrics are available for %d of %d nodes, less than the minimum healthy fraction of %v", healthy, len(nodes), h.minFraction))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// withHealthyNodes makes the first healthy of the three test nodes report metrics.
+func withHealthyNodes(healthy int, minFraction float64) *healthyNodes {
+	getter := fakeNodeMetricsGetter{
+		time:      make([]TimeInfo, 3),
+		resources: make([]v1.ResourceList, 3),
+	}
+	for i := 0; i < healthy; i++ {
+		getter.time[i] = TimeInfo{Timestamp: myClock.Now(), Window: 1000}
+		getter.resources[i] = v1.ResourceList{v1.ResourceCPU: resource.MustParse("10m")}
+	}
+	return newHealthyNodes(fakeNodeLister{resp: createTestNodes()}, getter, minFraction)
+}
+
+func TestHealthyNodes_Fraction(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		healthy     int
+		minFraction float64
+		unavailable bool
+	}{
+		{name: "disabled", healthy: 0, minFraction: 0},
+		{name: "below the fraction", healthy: 1, minFraction: 0.5, unavailable: true},
+		{name: "exactly the fraction", healthy: 2, minFraction: 2.0 / 3},
+		{name: "above the fraction", healthy: 2, minFraction: 0.5},
+		{name: "all nodes required", healthy: 2, minFraction: 1, unavailable: true},
+		{name: "all nodes healthy", healthy: 3, minFraction: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := withHealthyNodes(tc.healthy, tc.minFraction).check(genericapirequest.NewContext())
+			if tc.unavailable != errors.IsServiceUnavailable(err) {
+				t.Errorf("Expected unavailable %v, got error %v", tc.unavailable, err)
+			}
+			if !tc.unavailable && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHealthyNodes_NoNodes(t *testing.T) {
+	h := newHealthyNodes(fakeNodeLister{resp: []*v1.Node{}}, fakeNodeMetricsGetter{}, 1)
+	if err := h.check(genericapirequest.NewContext()); err != nil {
+		t.Errorf("Unexpected error without nodes: %v", err)
+	}
+}
+
+func TestNodeList_BelowMinHealthyNodes(t *testing.T) {
+	r := NewTestNodeStorage(createTestNodes(), nil)
+
+	r.healthyNodes = withHealthyNodes(1, 0.5)
+	if _, err := r.List(genericapirequest.NewContext(), nil); !errors.IsServiceUnavailable(err) {
+		t.Errorf("Expected listing below the fraction to be unavailable, got %v", err)
+	}
+	if _, err := r.Get(genericapirequest.NewContext(), "node1", nil); !errors.IsServiceUnavailable(err) {
+		t.Errorf("Expected getting below the fraction to be unavailable, got %v", err)
+	}
+
+	r.healthyNodes = withHealthyNodes(2, 0.5)
+	if _, err := r.List(genericapirequest.NewContext(), nil); err != nil {
+		t.Errorf("Unexpected error listing above the fraction: %v", err)
+	}
+}
+
+func TestPodList_BelowMinHealthyNodes(t *testing.T) {
+	r := NewPodTestStorage(createTestPods(), nil)
+
+	r.healthyNodes = withHealthyNodes(1, 0.5)
+	if _, err := r.List(genericapirequest.NewContext(), nil); !errors.IsServiceUnavailable(err) {
+		t.Errorf("Expected listing below the fraction to be unavailable, got %v", err)
+	}
+
+	r.healthyNodes = withHealthyNodes(2, 0.5)
+	if _, err := r.List(genericapirequest.NewContext(), nil); err != nil {
+		t.Errorf("Unexpected error listing above the fraction: %v", err)
+	}
+}
@@ -15,10 +15,13 @@
 package api
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apiserver/pkg/registry/rest"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	coreinf "k8s.io/client-go/informers/core/v1"
@@ -37,25 +40,139 @@ var (
 func init() {
 	install.Install(Scheme)
 	metav1.AddToGroupVersion(Scheme, schema.GroupVersion{Version: "v1"})
+	utilruntime.Must(Scheme.AddFieldLabelConversionFunc(v1beta1.SchemeGroupVersion.WithKind("PodMetrics"), podFieldLabelConversion))
+}
+
+// Config holds options controlling how the metrics.k8s.io API serves stored metrics.
+type Config struct {
+	// ListerStaleness reports how long the node and pod listers have been
+	// disconnected from the Kubernetes API server. If nil, the listers are
+	// always considered up to date.
+	ListerStaleness ListerStaleness
+	// MaxListerStaleness bounds how long metrics are served from a stale
+	// node and pod snapshot. Zero means there is no bound.
+	MaxListerStaleness time.Duration
+	// CPURoundingMillis rounds served CPU usage to a multiple of this many
+	// millicores. Zero disables rounding.
+	CPURoundingMillis int64
+	// MemoryRoundingBytes rounds served memory usage to a multiple of this
+	// many bytes. Zero disables rounding.
+	MemoryRoundingBytes int64
+	// MemoryMinDeltaBytes holds the served memory usage of each node and
+	// container until it changes by more than this many bytes. Zero serves
+	// every change.
+	MemoryMinDeltaBytes int64
+	// CPUMilliPrecision serves CPU usage in whole millicores instead of the
+	// nanocore precision reported by Kubelets.
+	CPUMilliPrecision bool
+	// ExcludedPodNamespaces lists namespaces whose pods are never served by
+	// PodMetrics. This is defense-in-depth and doesn't replace authorization.
+	ExcludedPodNamespaces []string
+	// PartialPodPolicy decides how pods with metrics missing for some of their
+	// containers are served. Empty is the same as PartialPodSum.
+	PartialPodPolicy PartialPodPolicy
+	// PodAggregation decides how container usage is rolled up per pod in
+	// table output. Empty is the same as PodAggregationSum.
+	PodAggregation PodAggregation
+	// SkipEphemeralContainers leaves the ephemeral containers in the pod
+	// spec out of PodMetrics.
+	SkipEphemeralContainers bool
+	// IncludePodQOS annotates PodMetrics with the QoS class of the pod,
+	// derived from the pod spec in the lister when serving.
+	IncludePodQOS bool
+	// IncludePodPriority annotates PodMetrics with the priority of the pod,
+	// taken from the pod spec in the lister when serving.
+	IncludePodPriority bool
+	// IncludeRestartCounts annotates PodMetrics with the restart counts of
+	// the containers, taken from the pod status in the lister when serving.
+	IncludeRestartCounts bool
+	// FlagUsageOverRequests annotates PodMetrics with the resources the pod
+	// uses more of than its requests or limits, taken from the pod spec in
+	// the lister when serving.
+	FlagUsageOverRequests bool
+	// EchoPodLabels lists pod labels copied to the labels of PodMetrics.
+	// Other pod labels are never served.
+	EchoPodLabels []string
+	// MaxSelectorRequirements limits the number of requirements of label
+	// selectors listing PodMetrics. Zero means no limit.
+	MaxSelectorRequirements int
+	// PodExistenceVerifier, if set, is used to omit pods which were deleted
+	// but are still present in the pod lister.
+	PodExistenceVerifier PodExistenceVerifier
+	// DropOrphanedPods omits pods assigned to a node missing from the node
+	// lister.
+	DropOrphanedPods bool
+	// DropCordonedNodePods omits pods assigned to a node marked unschedulable
+	// in the node lister.
+	DropCordonedNodePods bool
+	// NodeExistenceGrace is how long NodeMetrics of a node missing from the
+	// node lister are still served by name. Lists only serve listed nodes.
+	// Zero serves stored nodes whether listed or not.
+	NodeExistenceGrace time.Duration
+	// ResponseCacheTTL is how long list responses are cached, as long as no
+	// newer metrics are stored according to MetricsGeneration. Zero disables
+	// caching.
+	ResponseCacheTTL  time.Duration
+	MetricsGeneration MetricsGeneration
+	// MinHealthyNodesFraction withholds NodeMetrics and PodMetrics, failing
+	// requests as unavailable, while less than this fraction of nodes have
+	// metrics stored. Zero disables the check.
+	MinHealthyNodesFraction float64
+	// NodePodStats, if set, is used to annotate NodeMetrics with the number of
+	// pods with metrics on the node and their summed usage.
+	NodePodStats NodePodStatsGetter
+	// IncludeUsageDeltas annotates NodeMetrics and PodMetrics with the change
+	// of their usage since the previous point served.
+	IncludeUsageDeltas bool
+}
+
+func (c Config) rounding() usageRounding {
+	return usageRounding{
+		cpuMillis:         c.CPURoundingMillis,
+		memoryBytes:       c.MemoryRoundingBytes,
+		cpuMilliPrecision: c.CPUMilliPrecision,
+	}
+}
+
+// ServedVersions returns the versions of the metrics.k8s.io API group served
+// by Build.
+func ServedVersions() []string {
+	return []string{v1beta1.SchemeGroupVersion.Version}
 }
 
 // Build constructs APIGroupInfo the metrics.k8s.io API group using the given getters.
-func Build(m MetricsGetter, informers coreinf.Interface) genericapiserver.APIGroupInfo {
+func Build(m MetricsGetter, informers coreinf.Interface, config Config) genericapiserver.APIGroupInfo {
 	apiGroupInfo := genericapiserver.NewDefaultAPIGroupInfo(metrics.GroupName, Scheme, metav1.ParameterCodec, Codecs)
 
-	node := newNodeMetrics(metrics.Resource("nodemetrics"), m, informers.Nodes().Lister())
-	pod := newPodMetrics(metrics.Resource("podmetrics"), m, informers.Pods().Lister())
+	node, pod := newStorage(m, informers, config)
 	metricsServerResources := map[string]rest.Storage{
 		"nodes": node,
 		"pods":  pod,
 	}
-	apiGroupInfo.VersionedResourcesStorageMap[v1beta1.SchemeGroupVersion.Version] = metricsServerResources
+	for _, version := range ServedVersions() {
+		apiGroupInfo.VersionedResourcesStorageMap[version] = metricsServerResources
+	}
 
 	return apiGroupInfo
 }
 
+// newStorage constructs the REST storage of NodeMetrics and PodMetrics.
+func newStorage(m MetricsGetter, informers coreinf.Interface, config Config) (*nodeMetrics, *podMetrics) {
+	node := newNodeMetrics(metrics.Resource("nodemetrics"), m, informers.Nodes().Lister(), config)
+	pod := newPodMetrics(metrics.Resource("podmetrics"), m, informers.Pods().Lister(), config)
+	node.healthyNodes = newHealthyNodes(informers.Nodes().Lister(), m, config.MinHealthyNodesFraction)
+	pod.healthyNodes = node.healthyNodes
+	if config.DropOrphanedPods {
+		pod.nodeLister = informers.Nodes().Lister()
+	}
+	if config.DropCordonedNodePods {
+		pod.cordonedNodes = informers.Nodes().Lister()
+	}
+	return node, pod
+}
+
 // InstallStorage builds the metrics for the metrics.k8s.io API, and then installs it into the given API metrics-server.
-func Install(metrics MetricsGetter, informers coreinf.Interface, server *genericapiserver.GenericAPIServer) error {
-	info := Build(metrics, informers)
+func Install(metrics MetricsGetter, informers coreinf.Interface, config Config, server *genericapiserver.GenericAPIServer) error {
+	info := Build(metrics, informers, config)
 	return server.InstallAPIGroup(&info)
 }
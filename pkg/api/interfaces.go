@@ -15,10 +15,14 @@
 package api
 
 import (
+	"context"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	metrics "k8s.io/metrics/pkg/apis/metrics"
 )
 
@@ -51,7 +55,8 @@ type PodMetricsGetter interface {
 	// GetContainerMetrics gets the latest metrics for all containers in each listed pod,
 	// returning both the metrics and the associated collection timestamp.
 	// If a pod is missing, the container metrics should be nil for that pod.
-	GetContainerMetrics(pods ...apitypes.NamespacedName) ([]TimeInfo, [][]metrics.ContainerMetrics)
+	// If the context is done before all pods are read, its error is returned.
+	GetContainerMetrics(ctx context.Context, pods ...apitypes.NamespacedName) ([]TimeInfo, [][]metrics.ContainerMetrics, error)
 }
 
 // NodeMetricsGetter knows how to fetch metrics for a node.
@@ -59,5 +64,45 @@ type NodeMetricsGetter interface {
 	// GetNodeMetrics gets the latest metrics for the given nodes,
 	// returning both the metrics and the associated collection timestamp.
 	// If a node is missing, the resourcelist should be nil for that node.
-	GetNodeMetrics(nodes ...string) ([]TimeInfo, []corev1.ResourceList)
+	// If the context is done before all nodes are read, its error is returned.
+	GetNodeMetrics(ctx context.Context, nodes ...string) ([]TimeInfo, []corev1.ResourceList, error)
+}
+
+// ListerStaleness knows how long the listers backing the API have been
+// disconnected from the Kubernetes API server.
+type ListerStaleness interface {
+	// Staleness returns how long the listers have been serving their last
+	// synced snapshot, or zero if they are connected.
+	Staleness() time.Duration
+}
+
+// PodExistenceVerifier knows which pods currently exist in the Kubernetes
+// API server, independently of possibly lagging listers.
+type PodExistenceVerifier interface {
+	// ExistingPodUIDs returns the UIDs of existing pods in the namespace
+	// matching the given selectors. An empty namespace means all namespaces.
+	// The lookup is canceled when the context is done.
+	ExistingPodUIDs(ctx context.Context, namespace string, labelSelector labels.Selector, fieldSelector fields.Selector) (sets.String, error)
+}
+
+// NodePodStatsGetter knows how many pods have metrics stored for each node,
+// and how much they use altogether.
+type NodePodStatsGetter interface {
+	// GetNodePodStats gets the number of pods with metrics stored for each of
+	// the given nodes, and the summed usage of their containers. Nodes without
+	// pods get a zero count and empty usage.
+	// If the context is done before all nodes are read, its error is returned.
+	GetNodePodStats(ctx context.Context, nodes ...string) ([]NodePodStats, error)
+}
+
+// NodePodStats summarizes the pods with metrics stored for a node.
+type NodePodStats struct {
+	Pods  int
+	Usage corev1.ResourceList
+}
+
+// MetricsGeneration knows when the metrics served by the API last changed.
+type MetricsGeneration interface {
+	// Generation returns a counter incremented every time metrics are stored.
+	Generation() uint64
 }
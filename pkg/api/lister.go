@@ -0,0 +1,66 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	coreinf "k8s.io/client-go/informers/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// MetricsLister lists the NodeMetrics and PodMetrics of the metrics.k8s.io
+// API, for handlers serving them outside of it. Callers are responsible for
+// authorizing requests.
+type MetricsLister struct {
+	node *nodeMetrics
+	pod  *podMetrics
+}
+
+// NewMetricsLister returns a lister of the same metrics as the metrics.k8s.io
+// API built by Build with the same arguments.
+func NewMetricsLister(m MetricsGetter, informers coreinf.Interface, config Config) *MetricsLister {
+	node, pod := newStorage(m, informers, config)
+	return &MetricsLister{node: node, pod: pod}
+}
+
+// ListNodeMetrics returns the NodeMetrics of all nodes.
+func (l *MetricsLister) ListNodeMetrics(ctx context.Context) ([]v1beta1.NodeMetrics, error) {
+	obj, err := l.node.List(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	out := &v1beta1.NodeMetricsList{}
+	if err := Scheme.Convert(obj.(*metrics.NodeMetricsList), out, nil); err != nil {
+		return nil, err
+	}
+	return out.Items, nil
+}
+
+// ListPodMetrics returns the PodMetrics of all pods in namespace, or in all
+// namespaces if empty.
+func (l *MetricsLister) ListPodMetrics(ctx context.Context, namespace string) ([]v1beta1.PodMetrics, error) {
+	obj, err := l.pod.List(genericapirequest.WithNamespace(ctx, namespace), nil)
+	if err != nil {
+		return nil, err
+	}
+	out := &v1beta1.PodMetricsList{}
+	if err := Scheme.Convert(obj.(*metrics.PodMetricsList), out, nil); err != nil {
+		return nil, err
+	}
+	return out.Items, nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestMetricsLister(t *testing.T) {
+	pod := NewPodTestStorage(createTestPods(), nil)
+	pod.excludedNamespaces = sets.NewString("testValue")
+	lister := &MetricsLister{node: NewTestNodeStorage(createTestNodes(), nil), pod: pod}
+
+	nodes, err := lister.ListNodeMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(nodes) != 3 || nodes[0].Name != "node1" {
+		t.Errorf("Unexpected nodes: %+v", nodes)
+	}
+
+	pods, err := lister.ListPodMetrics(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(pods) != 2 || pods[0].Name != "pod1" || pods[1].Name != "pod3" {
+		t.Errorf("Expected the pods of the excluded namespace to be left out, got: %+v", pods)
+	}
+}
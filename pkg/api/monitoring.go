@@ -29,10 +29,29 @@ var (
 		},
 		[]string{},
 	)
+	requestTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "api",
+			Name:      "requests_total",
+			Help:      "Number of requests served by the metrics API, partitioned by client class (hpa, kubectl or other).",
+		},
+		[]string{"client"},
+	)
 )
 
 // RegisterAPIMetrics registers a histogram metric for the freshness of
-// exported metrics.
+// exported metrics and counters of served and denied requests.
 func RegisterAPIMetrics(registrationFunc func(metrics.Registerable) error) error {
-	return registrationFunc(metricFreshness)
+	for _, metric := range []metrics.Registerable{
+		metricFreshness,
+		requestTotal,
+		authorizationDenials,
+	} {
+		err := registrationFunc(metric)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -18,7 +18,9 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"time"
 
+	"golang.org/x/sync/singleflight"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
@@ -28,18 +30,33 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/registry/generic"
 	"k8s.io/apiserver/pkg/registry/rest"
 	v1listers "k8s.io/client-go/listers/core/v1"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 	"k8s.io/metrics/pkg/apis/metrics"
 	_ "k8s.io/metrics/pkg/apis/metrics/install"
 )
 
 type nodeMetrics struct {
-	groupResource schema.GroupResource
-	metrics       NodeMetricsGetter
-	nodeLister    v1listers.NodeLister
+	groupResource      schema.GroupResource
+	metrics            NodeMetricsGetter
+	nodeLister         v1listers.NodeLister
+	listerStaleness    ListerStaleness
+	maxListerStaleness time.Duration
+	rounding           usageRounding
+	memoryDampener     *memoryDampener
+	listGroup          singleflight.Group
+	listCache          *responseCache
+	// healthyNodes is nil unless metrics are withheld while few nodes have any
+	healthyNodes *healthyNodes
+	// existenceGrace is how long nodes missing from the lister are served, forever if zero
+	existenceGrace time.Duration
+	// podStats is nil unless NodeMetrics are annotated with the stats of their pods
+	podStats NodePodStatsGetter
+	// usageDeltas is nil unless NodeMetrics are annotated with the change of their usage
+	usageDeltas *usageDeltas
 }
 
 var _ rest.KindProvider = &nodeMetrics{}
@@ -49,11 +66,19 @@ var _ rest.Lister = &nodeMetrics{}
 var _ rest.Scoper = &nodeMetrics{}
 var _ rest.TableConvertor = &nodeMetrics{}
 
-func newNodeMetrics(groupResource schema.GroupResource, metrics NodeMetricsGetter, nodeLister v1listers.NodeLister) *nodeMetrics {
+func newNodeMetrics(groupResource schema.GroupResource, metrics NodeMetricsGetter, nodeLister v1listers.NodeLister, config Config) *nodeMetrics {
 	return &nodeMetrics{
-		groupResource: groupResource,
-		metrics:       metrics,
-		nodeLister:    nodeLister,
+		groupResource:      groupResource,
+		metrics:            metrics,
+		nodeLister:         nodeLister,
+		listerStaleness:    config.ListerStaleness,
+		maxListerStaleness: config.MaxListerStaleness,
+		rounding:           config.rounding(),
+		memoryDampener:     newMemoryDampener(config.MemoryMinDeltaBytes),
+		usageDeltas:        newUsageDeltas(config.IncludeUsageDeltas),
+		listCache:          newResponseCache(config),
+		existenceGrace:     config.NodeExistenceGrace,
+		podStats:           config.NodePodStats,
 	}
 }
 
@@ -74,31 +99,53 @@ func (m *nodeMetrics) NewList() runtime.Object {
 
 // Lister interface
 func (m *nodeMetrics) List(ctx context.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	// concurrent identical lists, e.g. from correlated HPA polling, share one
+	// computation, and repeated ones may be served from the list cache
+	key := listKey("", options)
+	res, err := m.listCache.do(key, func() (interface{}, error) {
+		return sharedList(ctx, &m.listGroup, key, func(ctx context.Context) (interface{}, error) {
+			return m.list(ctx, options)
+		})
+	})
+	if err != nil {
+		return &metrics.NodeMetricsList{}, err
+	}
+	// the apiserver sets metadata such as selfLink on returned objects, so each
+	// caller gets its own list and items, sharing only the read-only usage
+	shared := res.(*metrics.NodeMetricsList)
+	items := make([]metrics.NodeMetrics, len(shared.Items))
+	copy(items, shared.Items)
+	return &metrics.NodeMetricsList{ListMeta: shared.ListMeta, Items: items}, nil
+}
+
+func (m *nodeMetrics) list(ctx context.Context, options *metainternalversion.ListOptions) (*metrics.NodeMetricsList, error) {
+	stale, err := checkListerStaleness(m.listerStaleness, m.maxListerStaleness)
+	if err != nil {
+		klog.Error(err)
+		return &metrics.NodeMetricsList{}, err
+	}
+	if err := m.healthyNodes.check(ctx); err != nil {
+		klog.Error(err)
+		return &metrics.NodeMetricsList{}, err
+	}
+
 	labelSelector := labels.Everything()
 	if options != nil && options.LabelSelector != nil {
 		labelSelector = options.LabelSelector
 	}
-	nodes, err := m.nodeLister.List(labelSelector)
+	fieldSelector := fields.Everything()
+	if options != nil && options.FieldSelector != nil {
+		fieldSelector = options.FieldSelector
+	}
+	if err := validateNodeFieldSelector(fieldSelector); err != nil {
+		return &metrics.NodeMetricsList{}, err
+	}
+	nodes, err := m.listNodes(labelSelector, fieldSelector)
 	if err != nil {
 		errMsg := fmt.Errorf("Error while listing nodes for selector %v: %v", labelSelector, err)
 		klog.Error(errMsg)
 		return &metrics.NodeMetricsList{}, errMsg
 	}
-	if options != nil && options.FieldSelector != nil {
-		newNodes := make([]*v1.Node, 0, len(nodes))
-		fields := make(fields.Set, 2)
-		for _, node := range nodes {
-			for k := range fields {
-				delete(fields, k)
-			}
-			fieldsSet := generic.AddObjectMetaFieldsSet(fields, &node.ObjectMeta, true)
-			if !options.FieldSelector.Matches(fieldsSet) {
-				continue
-			}
-			newNodes = append(newNodes, node)
-		}
-		nodes = newNodes
-	}
 
 	names := make([]string, len(nodes))
 	for i, node := range nodes {
@@ -107,25 +154,101 @@ func (m *nodeMetrics) List(ctx context.Context, options *metainternalversion.Lis
 	// maintain the same ordering invariant as the Kube API would over nodes
 	sort.Strings(names)
 
-	metricsItems, err := m.getNodeMetrics(names...)
+	metricsItems, err := m.getNodeMetrics(ctx, names...)
 	if err != nil {
 		errMsg := fmt.Errorf("Error while fetching node metrics for selector %v: %v", labelSelector, err)
 		klog.Error(errMsg)
 		return &metrics.NodeMetricsList{}, errMsg
 	}
+	if stale {
+		for i := range metricsItems {
+			markStale(&metricsItems[i].ObjectMeta)
+		}
+	}
 
 	return &metrics.NodeMetricsList{Items: metricsItems}, nil
 }
 
+// nodeFieldSelectors are the field selector keys honored by NodeMetrics. Nodes
+// aren't namespaced, so like for nodes, only their name can be selected.
+var nodeFieldSelectors = sets.NewString("metadata.name")
+
+func validateNodeFieldSelector(selector fields.Selector) error {
+	for _, r := range selector.Requirements() {
+		if !nodeFieldSelectors.Has(r.Field) {
+			return errors.NewBadRequest(fmt.Sprintf("field label not supported: %q, supported fields are %q", r.Field, nodeFieldSelectors.List()))
+		}
+	}
+	return nil
+}
+
+// listNodes returns nodes matching both selectors. Selecting a single node by
+// name is served with a lister lookup instead of filtering a full list.
+func (m *nodeMetrics) listNodes(labelSelector labels.Selector, fieldSelector fields.Selector) ([]*v1.Node, error) {
+	var nodes []*v1.Node
+	if name, ok := fieldSelector.RequiresExactMatch("metadata.name"); ok {
+		node, err := m.nodeLister.Get(name)
+		if errors.IsNotFound(err) {
+			return []*v1.Node{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !labelSelector.Matches(labels.Set(node.Labels)) {
+			return []*v1.Node{}, nil
+		}
+		nodes = []*v1.Node{node}
+	} else {
+		var err error
+		nodes, err = m.nodeLister.List(labelSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fieldSelector.Empty() {
+		return nodes, nil
+	}
+
+	newNodes := make([]*v1.Node, 0, len(nodes))
+	fields := make(fields.Set, 1)
+	for _, node := range nodes {
+		for k := range fields {
+			delete(fields, k)
+		}
+		fieldsSet := generic.AddObjectMetaFieldsSet(fields, &node.ObjectMeta, false)
+		if !fieldSelector.Matches(fieldsSet) {
+			continue
+		}
+		newNodes = append(newNodes, node)
+	}
+	return newNodes, nil
+}
+
 func (m *nodeMetrics) Get(ctx context.Context, name string, opts *metav1.GetOptions) (runtime.Object, error) {
-	nodeMetrics, err := m.getNodeMetrics(name)
+	stale, err := checkListerStaleness(m.listerStaleness, m.maxListerStaleness)
+	if err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+	if err := m.healthyNodes.check(ctx); err != nil {
+		klog.Error(err)
+		return nil, err
+	}
+
+	nodeMetrics, err := m.getNodeMetrics(ctx, name)
 	if err == nil && len(nodeMetrics) == 0 {
 		err = fmt.Errorf("no metrics known for node %q", name)
 	}
+	if err == nil && m.missingBeyondGrace(name, nodeMetrics[0].Timestamp.Time) {
+		err = fmt.Errorf("node %q no longer exists", name)
+	}
 	if err != nil {
 		klog.Errorf("unable to fetch node metrics for node %q: %v", name, err)
 		return nil, errors.NewNotFound(m.groupResource, name)
 	}
+	if stale {
+		markStale(&nodeMetrics[0].ObjectMeta)
+	}
 
 	return &nodeMetrics[0], nil
 }
@@ -188,23 +311,39 @@ func addNodeMetricsToTable(table *metav1beta1.Table, nodes ...metrics.NodeMetric
 	}
 }
 
-func (m *nodeMetrics) getNodeMetrics(names ...string) ([]metrics.NodeMetrics, error) {
-	timestamps, usages := m.metrics.GetNodeMetrics(names...)
+func (m *nodeMetrics) getNodeMetrics(ctx context.Context, names ...string) ([]metrics.NodeMetrics, error) {
+	timestamps, usages, err := m.metrics.GetNodeMetrics(ctx, names...)
+	if err != nil {
+		return nil, err
+	}
+	var podStats []NodePodStats
+	if m.podStats != nil {
+		podStats, err = m.podStats.GetNodePodStats(ctx, names...)
+		if err != nil {
+			return nil, err
+		}
+	}
 	res := make([]metrics.NodeMetrics, 0, len(names))
 
 	for i, name := range names {
 		if usages[i] == nil {
 			continue
 		}
-		res = append(res, metrics.NodeMetrics{
+		node := metrics.NodeMetrics{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:              name,
 				CreationTimestamp: metav1.NewTime(myClock.Now()),
 			},
 			Timestamp: metav1.NewTime(timestamps[i].Timestamp),
 			Window:    metav1.Duration{Duration: timestamps[i].Window},
-			Usage:     usages[i],
-		})
+			Usage:     m.memoryDampener.Dampen("node/"+name, m.rounding.Round(usages[i])),
+		}
+		markMissingResources(&node)
+		markUsageDelta(&node.ObjectMeta, m.usageDeltas.Delta("node/"+name, timestamps[i].Timestamp, node.Usage))
+		if podStats != nil {
+			markPodStats(&node, podStats[i], m.rounding)
+		}
+		res = append(res, node)
 		metricFreshness.WithLabelValues().Observe(myClock.Since(timestamps[i].Timestamp).Seconds())
 	}
 
@@ -17,12 +17,14 @@ limitations under the License.
 package api
 
 import (
+	"context"
 	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
@@ -51,7 +53,15 @@ func (pl fakeNodeLister) List(selector labels.Selector) (ret []*v1.Node, err err
 	return res, pl.err
 }
 func (pl fakeNodeLister) Get(name string) (*v1.Node, error) {
-	return pl.resp.(*v1.Node), pl.err
+	if pl.err != nil {
+		return nil, pl.err
+	}
+	for _, node := range pl.resp.([]*v1.Node) {
+		if node.Name == name {
+			return node, nil
+		}
+	}
+	return nil, errors.NewNotFound(v1.Resource("node"), name)
 }
 
 type fakeNodeMetricsGetter struct {
@@ -61,8 +71,11 @@ type fakeNodeMetricsGetter struct {
 
 var _ NodeMetricsGetter = (*fakeNodeMetricsGetter)(nil)
 
-func (mp fakeNodeMetricsGetter) GetNodeMetrics(nodes ...string) ([]TimeInfo, []v1.ResourceList) {
-	return mp.time, mp.resources
+func (mp fakeNodeMetricsGetter) GetNodeMetrics(ctx context.Context, nodes ...string) ([]TimeInfo, []v1.ResourceList, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return mp.time, mp.resources, nil
 }
 
 func NewTestNodeStorage(resp interface{}, err error) *nodeMetrics {
@@ -139,6 +152,37 @@ func TestNodeList_NoError(t *testing.T) {
 	}
 }
 
+func TestNodeList_MissingResources(t *testing.T) {
+	r := NewTestNodeStorage(createTestNodes(), nil)
+	r.metrics = fakeNodeMetricsGetter{
+		time: []TimeInfo{
+			{Timestamp: myClock.Now(), Window: 1000},
+			{Timestamp: myClock.Now(), Window: 1000},
+			{Timestamp: myClock.Now(), Window: 1000},
+		},
+		resources: []v1.ResourceList{
+			{v1.ResourceCPU: resource.MustParse("10m"), v1.ResourceMemory: resource.MustParse("5Mi")},
+			{v1.ResourceCPU: resource.MustParse("10m")},
+			{v1.ResourceMemory: resource.MustParse("5Mi")},
+		},
+	}
+
+	got, err := r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res := got.(*metrics.NodeMetricsList)
+
+	if len(res.Items) != 3 {
+		t.Fatalf("Expected 3 nodes, got %+v", res.Items)
+	}
+	for i, want := range []string{"", "memory", "cpu"} {
+		if got := res.Items[i].Annotations[MissingResourcesAnnotation]; got != want {
+			t.Errorf("Expected node %s to have missing resources %q, got %q", res.Items[i].Name, want, got)
+		}
+	}
+}
+
 func TestNodeList_EmptyResponse(t *testing.T) {
 	// setup
 	r := NewTestNodeStorage([]*v1.Node{}, nil)
@@ -162,9 +206,7 @@ func TestNodeList_WithFieldSelectors(t *testing.T) {
 	r := NewTestNodeStorage(createTestNodes(), nil)
 
 	opts := &metainternalversion.ListOptions{
-		FieldSelector: fields.SelectorFromSet(map[string]string{
-			"metadata.namespace": "testValue",
-		}),
+		FieldSelector: fields.ParseSelectorOrDie("metadata.name!=node1,metadata.name!=node3"),
 	}
 
 	// execute
@@ -182,6 +224,81 @@ func TestNodeList_WithFieldSelectors(t *testing.T) {
 	}
 }
 
+func TestNodeList_WithNameFieldSelector(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   *metainternalversion.ListOptions
+		expect []string
+	}{
+		{
+			name: "existing node",
+			opts: &metainternalversion.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.name", "node2"),
+			},
+			expect: []string{"node2"},
+		},
+		{
+			name: "missing node",
+			opts: &metainternalversion.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.name", "node4"),
+			},
+			expect: []string{},
+		},
+		{
+			name: "node not matching label selector",
+			opts: &metainternalversion.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.name", "node2"),
+				LabelSelector: labels.SelectorFromSet(map[string]string{"labelKey": "labelValue"}),
+			},
+			expect: []string{},
+		},
+		{
+			name: "excluded node",
+			opts: &metainternalversion.ListOptions{
+				FieldSelector: fields.OneTermNotEqualSelector("metadata.name", "node2"),
+			},
+			expect: []string{"node1", "node3"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewTestNodeStorage(createTestNodes(), nil)
+
+			got, err := r.List(genericapirequest.NewContext(), tc.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			names := []string{}
+			for _, item := range got.(*metrics.NodeMetricsList).Items {
+				names = append(names, item.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expect) {
+				t.Errorf("Got unexpected nodes: %v, expected: %v", names, tc.expect)
+			}
+		})
+	}
+}
+
+func TestNodeList_UnsupportedFieldSelector(t *testing.T) {
+	for _, field := range []string{"spec.unschedulable", "metadata.namespace"} {
+		t.Run(field, func(t *testing.T) {
+			r := NewTestNodeStorage(createTestNodes(), nil)
+
+			opts := &metainternalversion.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector(field, "true"),
+			}
+
+			_, err := r.List(genericapirequest.NewContext(), opts)
+			if !errors.IsBadRequest(err) {
+				t.Fatalf("Expected bad request error, got: %v", err)
+			}
+			if !strings.Contains(err.Error(), field) {
+				t.Errorf("Expected error to name the unsupported field, got: %v", err)
+			}
+		})
+	}
+}
+
 func TestNodeList_WithLabelSelectors(t *testing.T) {
 	// setup
 	r := NewTestNodeStorage(createTestNodes(), nil)
@@ -212,9 +329,7 @@ func TestNodeList_WithLabelAndFieldSelectors(t *testing.T) {
 	r := NewTestNodeStorage(createTestNodes(), nil)
 
 	opts := &metainternalversion.ListOptions{
-		FieldSelector: fields.SelectorFromSet(map[string]string{
-			"metadata.namespace": "other",
-		}),
+		FieldSelector: fields.OneTermNotEqualSelector("metadata.name", "node1"),
 		LabelSelector: labels.SelectorFromSet(map[string]string{
 			"labelKey": "otherValue",
 		}),
@@ -281,6 +396,88 @@ func TestNodeList_Monitoring(t *testing.T) {
 	}
 }
 
+func TestNodeGet_NodeExistenceGrace(t *testing.T) {
+	c := &fakeClock{now: time.Now()}
+	myClock = c
+	nodes := createTestNodes()
+	r := NewTestNodeStorage(nodes, nil)
+	// node1 is deleted while its metrics are still stored
+	r.nodeLister = fakeNodeLister{resp: nodes[1:]}
+	get := func(name string) error {
+		_, err := r.Get(genericapirequest.NewContext(), name, nil)
+		return err
+	}
+
+	if err := get("node1"); err != nil {
+		t.Errorf("Expected missing nodes to be served without a grace, got: %v", err)
+	}
+	r.existenceGrace = time.Minute
+	if err := get("node1"); err != nil {
+		t.Errorf("Expected a missing node to be served within the grace, got: %v", err)
+	}
+
+	c.now = c.now.Add(2 * time.Minute)
+	if err := get("node1"); !errors.IsNotFound(err) {
+		t.Errorf("Expected not found error for a node missing beyond the grace, got: %v", err)
+	}
+	if err := get("node2"); err != nil {
+		t.Errorf("Expected listed nodes to be served whatever the grace, got: %v", err)
+	}
+}
+
+// fakeNodePodStatsGetter serves the stats of each node, and none for other nodes.
+type fakeNodePodStatsGetter map[string]NodePodStats
+
+var _ NodePodStatsGetter = fakeNodePodStatsGetter(nil)
+
+func (g fakeNodePodStatsGetter) GetNodePodStats(_ context.Context, nodes ...string) ([]NodePodStats, error) {
+	res := make([]NodePodStats, len(nodes))
+	for i, node := range nodes {
+		res[i] = g[node]
+	}
+	return res, nil
+}
+
+func TestNodeList_NodePodStats(t *testing.T) {
+	r := NewTestNodeStorage(createTestNodes(), nil)
+	got, err := r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, node := range got.(*metrics.NodeMetricsList).Items {
+		if _, found := node.Annotations[PodCountAnnotation]; found {
+			t.Errorf("Expected node %s without pod stats by default, got annotations %v", node.Name, node.Annotations)
+		}
+	}
+
+	r = NewTestNodeStorage(createTestNodes(), nil)
+	r.rounding = usageRounding{cpuMillis: 10}
+	r.podStats = fakeNodePodStatsGetter{
+		"node1": {Pods: 2, Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1234m"), v1.ResourceMemory: resource.MustParse("3Gi")}},
+		"node2": {Pods: 1, Usage: v1.ResourceList{v1.ResourceMemory: resource.MustParse("5Mi")}},
+	}
+	got, err = r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := map[string]map[string]string{
+		"node1": {PodCountAnnotation: "2", PodUsageAnnotation: "cpu=1230m,memory=3Gi"},
+		"node2": {PodCountAnnotation: "1", PodUsageAnnotation: "memory=5Mi"},
+		"node3": {PodCountAnnotation: "0", PodUsageAnnotation: ""},
+	}
+	items := got.(*metrics.NodeMetricsList).Items
+	if len(items) != len(expect) {
+		t.Fatalf("Expected %d nodes, got %d", len(expect), len(items))
+	}
+	for _, node := range items {
+		for annotation, value := range expect[node.Name] {
+			if got, found := node.Annotations[annotation]; !found || got != value {
+				t.Errorf("Expected node %s annotated with %s=%q, got annotations %v", node.Name, annotation, value, node.Annotations)
+			}
+		}
+	}
+}
+
 func createTestNodes() []*v1.Node {
 	node1 := &v1.Node{}
 	node1.Name = "node1"
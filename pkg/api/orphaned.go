@@ -0,0 +1,52 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+)
+
+// orphaned returns whether the pod is assigned to a node missing from the
+// node lister, e.g. a node deleted before the informer observed the deletion
+// of its pods. Pods not assigned to a node, and pods whose node can't be
+// looked up, aren't orphaned.
+func (m *podMetrics) orphaned(pod *v1.Pod) bool {
+	if m.nodeLister == nil || pod.Spec.NodeName == "" {
+		return false
+	}
+	_, err := m.nodeLister.Get(pod.Spec.NodeName)
+	if errors.IsNotFound(err) {
+		klog.V(2).Infof("skipping pod %s/%s, node %s no longer exists", pod.Namespace, pod.Name, pod.Spec.NodeName)
+		return true
+	}
+	return false
+}
+
+// onCordonedNode returns whether the pod is assigned to a node marked
+// unschedulable, e.g. a node being drained whose pods are about to be evicted.
+// Pods whose node can't be looked up aren't on a cordoned node.
+func (m *podMetrics) onCordonedNode(pod *v1.Pod) bool {
+	if m.cordonedNodes == nil || pod.Spec.NodeName == "" {
+		return false
+	}
+	node, err := m.cordonedNodes.Get(pod.Spec.NodeName)
+	if err != nil || !node.Spec.Unschedulable {
+		return false
+	}
+	klog.V(2).Infof("skipping pod %s/%s, node %s is cordoned", pod.Namespace, pod.Name, pod.Spec.NodeName)
+	return true
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+const (
+	// UsageOverRequestsAnnotation lists the resources, e.g. "cpu,memory", a
+	// pod uses more of than it requests, taken from its current spec when the
+	// metrics are served.
+	UsageOverRequestsAnnotation = "metrics.k8s.io/usage-over-requests"
+	// UsageOverLimitsAnnotation lists the resources a pod uses more of than
+	// its limits, taken from its current spec when the metrics are served.
+	UsageOverLimitsAnnotation = "metrics.k8s.io/usage-over-limits"
+)
+
+// markUsageOverRequests annotates the metrics with the resources whose usage,
+// summed over the containers, exceeds the pod requests or limits. A pod
+// requests a resource if any of its containers do, and limits it only if all
+// of them do, like the scheduler and kubelet account for them. Pods under
+// their requests and limits are left unannotated.
+func markUsageOverRequests(podMetrics *metrics.PodMetrics, pod *v1.Pod) {
+	usage := v1.ResourceList{}
+	for _, container := range podMetrics.Containers {
+		addQOSResources(usage, container.Usage)
+	}
+	requests := v1.ResourceList{}
+	limits := v1.ResourceList{}
+	limited := map[v1.ResourceName]int{}
+	for _, container := range pod.Spec.Containers {
+		addQOSResources(requests, container.Resources.Requests)
+		addQOSResources(limits, container.Resources.Limits)
+		for name := range container.Resources.Limits {
+			limited[name]++
+		}
+	}
+	for name := range limits {
+		if limited[name] != len(pod.Spec.Containers) {
+			// containers without a limit are unbounded
+			delete(limits, name)
+		}
+	}
+	for annotation, bounds := range map[string]v1.ResourceList{UsageOverRequestsAnnotation: requests, UsageOverLimitsAnnotation: limits} {
+		if over := overBounds(usage, bounds); len(over) != 0 {
+			if podMetrics.Annotations == nil {
+				podMetrics.Annotations = map[string]string{}
+			}
+			podMetrics.Annotations[annotation] = strings.Join(over, ",")
+		}
+	}
+}
+
+// overBounds returns the CPU and memory resources whose usage exceeds their
+// bound, in this order. Resources without a bound are never over it.
+func overBounds(usage, bounds v1.ResourceList) []string {
+	var over []string
+	for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		bound, found := bounds[name]
+		if !found {
+			continue
+		}
+		if used, found := usage[name]; found && used.Cmp(bound) > 0 {
+			over = append(over, string(name))
+		}
+	}
+	return over
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// PartialPodPolicy decides how pods are served when Kubelet reported metrics
+// for only some of the containers in their spec.
+type PartialPodPolicy string
+
+const (
+	// PartialPodSum serves the containers that were reported.
+	PartialPodSum PartialPodPolicy = "sum"
+	// PartialPodOmit doesn't serve the pod until all containers are reported.
+	PartialPodOmit PartialPodPolicy = "omit"
+	// PartialPodFlag serves the reported containers and sets PartialAnnotation,
+	// and MissingResourcesAnnotation for containers missing some resources.
+	PartialPodFlag PartialPodPolicy = "flag"
+)
+
+// PartialAnnotation lists the containers missing from the metrics of a pod
+// served under the PartialPodFlag policy.
+const PartialAnnotation = "metrics.k8s.io/missing-containers"
+
+// missingContainers returns names of containers in the pod spec without
+// reported metrics, in spec order.
+func missingContainers(pod *v1.Pod, containers []metrics.ContainerMetrics) []string {
+	if len(containers) >= len(pod.Spec.Containers) {
+		return nil
+	}
+	reported := sets.NewString()
+	for _, c := range containers {
+		reported.Insert(c.Name)
+	}
+	var missing []string
+	for _, c := range pod.Spec.Containers {
+		if !reported.Has(c.Name) {
+			missing = append(missing, c.Name)
+		}
+	}
+	return missing
+}
+
+func markPartial(pod *metrics.PodMetrics, missing []string) {
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[PartialAnnotation] = strings.Join(missing, ",")
+}
+
+// MissingResourcesAnnotation lists the resources missing from the metrics of
+// a node which reported only some of them, or of a pod served under the
+// PartialPodFlag policy with containers missing some of them, e.g. because
+// their series were stale.
+const MissingResourcesAnnotation = "metrics.k8s.io/missing-resources"
+
+// markMissingResources annotates the node with cpu and memory if absent from
+// its usage.
+func markMissingResources(node *metrics.NodeMetrics) {
+	annotateMissingResources(&node.ObjectMeta, node.Usage)
+}
+
+// markPodMissingResources annotates the pod with cpu and memory if absent
+// from the usage of any of its containers.
+func markPodMissingResources(pod *metrics.PodMetrics) {
+	usages := make([]v1.ResourceList, len(pod.Containers))
+	for i := range pod.Containers {
+		usages[i] = pod.Containers[i].Usage
+	}
+	annotateMissingResources(&pod.ObjectMeta, usages...)
+}
+
+func annotateMissingResources(meta *metav1.ObjectMeta, usages ...v1.ResourceList) {
+	var missing []string
+	for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		for _, usage := range usages {
+			if _, found := usage[name]; !found {
+				missing = append(missing, string(name))
+				break
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[MissingResourcesAnnotation] = strings.Join(missing, ",")
+}
@@ -18,7 +18,9 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"time"
 
+	"golang.org/x/sync/singleflight"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
@@ -29,19 +31,53 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
 	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
 	"k8s.io/apiserver/pkg/registry/generic"
 	"k8s.io/apiserver/pkg/registry/rest"
 	v1listers "k8s.io/client-go/listers/core/v1"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 	"k8s.io/metrics/pkg/apis/metrics"
 	_ "k8s.io/metrics/pkg/apis/metrics/install"
 )
 
 type podMetrics struct {
-	groupResource schema.GroupResource
-	metrics       PodMetricsGetter
-	podLister     v1listers.PodLister
+	groupResource      schema.GroupResource
+	metrics            PodMetricsGetter
+	podLister          v1listers.PodLister
+	listerStaleness    ListerStaleness
+	maxListerStaleness time.Duration
+	rounding           usageRounding
+	memoryDampener     *memoryDampener
+	excludedNamespaces sets.String
+	partialPolicy      PartialPodPolicy
+	podVerifier        PodExistenceVerifier
+	listGroup          singleflight.Group
+	listCache          *responseCache
+	// usageDeltas is nil unless served pods are annotated with the change of their usage
+	usageDeltas *usageDeltas
+	// includeQOS annotates served pods with their QoS class
+	includeQOS bool
+	// includePriority annotates served pods with their priority
+	includePriority bool
+	// includeRestarts annotates served pods with the restart counts of their containers
+	includeRestarts bool
+	// flagUsageOverRequests annotates served pods using more than their requests or limits
+	flagUsageOverRequests bool
+	// echoLabels are the pod labels copied to served pods
+	echoLabels []string
+	// maxSelectorRequirements limits the requirements of list label selectors, unlimited if zero
+	maxSelectorRequirements int
+	// skipEphemeral leaves ephemeral containers out of served pods
+	skipEphemeral bool
+	// aggregation rolls up container usage in table output
+	aggregation PodAggregation
+	// healthyNodes is nil unless metrics are withheld while few nodes have any
+	healthyNodes *healthyNodes
+	// nodeLister is nil unless pods assigned to nodes missing from it are omitted
+	nodeLister v1listers.NodeLister
+	// cordonedNodes is nil unless pods assigned to nodes marked unschedulable in it are omitted
+	cordonedNodes v1listers.NodeLister
 }
 
 var _ rest.KindProvider = &podMetrics{}
@@ -50,11 +86,28 @@ var _ rest.Getter = &podMetrics{}
 var _ rest.Lister = &podMetrics{}
 var _ rest.TableConvertor = &podMetrics{}
 
-func newPodMetrics(groupResource schema.GroupResource, metrics PodMetricsGetter, podLister v1listers.PodLister) *podMetrics {
+func newPodMetrics(groupResource schema.GroupResource, metrics PodMetricsGetter, podLister v1listers.PodLister, config Config) *podMetrics {
 	return &podMetrics{
-		groupResource: groupResource,
-		metrics:       metrics,
-		podLister:     podLister,
+		groupResource:           groupResource,
+		metrics:                 metrics,
+		podLister:               podLister,
+		listerStaleness:         config.ListerStaleness,
+		maxListerStaleness:      config.MaxListerStaleness,
+		rounding:                config.rounding(),
+		memoryDampener:          newMemoryDampener(config.MemoryMinDeltaBytes),
+		usageDeltas:             newUsageDeltas(config.IncludeUsageDeltas),
+		excludedNamespaces:      sets.NewString(config.ExcludedPodNamespaces...),
+		partialPolicy:           config.PartialPodPolicy,
+		podVerifier:             config.PodExistenceVerifier,
+		includeQOS:              config.IncludePodQOS,
+		includePriority:         config.IncludePodPriority,
+		includeRestarts:         config.IncludeRestartCounts,
+		flagUsageOverRequests:   config.FlagUsageOverRequests,
+		echoLabels:              config.EchoPodLabels,
+		maxSelectorRequirements: config.MaxSelectorRequirements,
+		skipEphemeral:           config.SkipEphemeralContainers,
+		aggregation:             config.PodAggregation,
+		listCache:               newResponseCache(config),
 	}
 }
 
@@ -75,13 +128,72 @@ func (m *podMetrics) NewList() runtime.Object {
 
 // Lister interface
 func (m *podMetrics) List(ctx context.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	if err := m.checkSelectorRequirements(options); err != nil {
+		return &metrics.PodMetricsList{}, err
+	}
+	namespace := genericapirequest.NamespaceValue(ctx)
+	// concurrent identical lists, e.g. from correlated HPA polling, share one
+	// computation, and repeated ones may be served from the list cache
+	key := listKey(namespace, options)
+	res, err := m.listCache.do(key, func() (interface{}, error) {
+		return sharedList(ctx, &m.listGroup, key, func(ctx context.Context) (interface{}, error) {
+			return m.list(ctx, namespace, options)
+		})
+	})
+	if err != nil {
+		return &metrics.PodMetricsList{}, err
+	}
+	// the apiserver sets metadata such as selfLink on returned objects, so each
+	// caller gets its own list and items, sharing only the read-only usage
+	shared := res.(*metrics.PodMetricsList)
+	items := make([]metrics.PodMetrics, len(shared.Items))
+	copy(items, shared.Items)
+	return &metrics.PodMetricsList{ListMeta: shared.ListMeta, Items: items}, nil
+}
+
+// checkSelectorRequirements rejects label selectors with more requirements
+// than allowed, as they are expensive to match against many pods.
+func (m *podMetrics) checkSelectorRequirements(options *metainternalversion.ListOptions) error {
+	if m.maxSelectorRequirements <= 0 || options == nil || options.LabelSelector == nil {
+		return nil
+	}
+	requirements, _ := options.LabelSelector.Requirements()
+	if len(requirements) > m.maxSelectorRequirements {
+		return errors.NewBadRequest(fmt.Sprintf("label selector has %d requirements, which exceeds the maximum of %d", len(requirements), m.maxSelectorRequirements))
+	}
+	return nil
+}
+
+func (m *podMetrics) list(ctx context.Context, namespace string, options *metainternalversion.ListOptions) (*metrics.PodMetricsList, error) {
+	stale, err := checkListerStaleness(m.listerStaleness, m.maxListerStaleness)
+	if err != nil {
+		klog.Error(err)
+		return &metrics.PodMetricsList{}, err
+	}
+	if err := m.healthyNodes.check(ctx); err != nil {
+		klog.Error(err)
+		return &metrics.PodMetricsList{}, err
+	}
+
 	labelSelector := labels.Everything()
 	if options != nil && options.LabelSelector != nil {
 		labelSelector = options.LabelSelector
 	}
+	fieldSelector := fields.Everything()
+	if options != nil && options.FieldSelector != nil {
+		fieldSelector = options.FieldSelector
+	}
+	podNames, fieldSelector, err := splitPodNames(namespace, fieldSelector)
+	if err != nil {
+		return &metrics.PodMetricsList{}, err
+	}
 
-	namespace := genericapirequest.NamespaceValue(ctx)
-	pods, err := m.podLister.Pods(namespace).List(labelSelector)
+	var pods []*v1.Pod
+	if podNames != nil {
+		pods, err = m.getPods(podNames, labelSelector)
+	} else {
+		pods, err = m.podLister.Pods(namespace).List(labelSelector)
+	}
 	if err != nil {
 		errMsg := fmt.Errorf("Error while listing pods for selector %v in namespace %q: %v", labelSelector, namespace, err)
 		klog.Error(errMsg)
@@ -89,7 +201,7 @@ func (m *podMetrics) List(ctx context.Context, options *metainternalversion.List
 	}
 
 	// currently the PodLister API does not support filtering using FieldSelectors, we have to filter manually
-	if options != nil && options.FieldSelector != nil {
+	if !fieldSelector.Empty() {
 		newPods := make([]*v1.Pod, 0, len(pods))
 		fields := make(fields.Set, 2)
 		for _, pod := range pods {
@@ -97,7 +209,7 @@ func (m *podMetrics) List(ctx context.Context, options *metainternalversion.List
 				delete(fields, k)
 			}
 			fieldsSet := generic.AddObjectMetaFieldsSet(fields, &pod.ObjectMeta, true)
-			if !options.FieldSelector.Matches(fieldsSet) {
+			if !fieldSelector.Matches(fieldsSet) {
 				continue
 			}
 			newPods = append(newPods, pod)
@@ -105,6 +217,25 @@ func (m *podMetrics) List(ctx context.Context, options *metainternalversion.List
 		pods = newPods
 	}
 
+	// excluded namespaces are filtered last, so they are hidden regardless of selectors
+	if m.excludedNamespaces.Len() != 0 {
+		newPods := make([]*v1.Pod, 0, len(pods))
+		for _, pod := range pods {
+			if m.excludedNamespaces.Has(pod.Namespace) {
+				continue
+			}
+			newPods = append(newPods, pod)
+		}
+		pods = newPods
+	}
+
+	pods, err = m.verifyExistence(ctx, namespace, labelSelector, fields.Everything(), pods)
+	if err != nil {
+		errMsg := fmt.Errorf("Error while verifying pods for selector %v in namespace %q exist: %v", labelSelector, namespace, err)
+		klog.Error(errMsg)
+		return &metrics.PodMetricsList{}, errMsg
+	}
+
 	// maintain the same ordering invariant as the Kube API would over pods
 	sort.Slice(pods, func(i, j int) bool {
 		if pods[i].Namespace != pods[j].Namespace {
@@ -113,19 +244,57 @@ func (m *podMetrics) List(ctx context.Context, options *metainternalversion.List
 		return pods[i].Name < pods[j].Name
 	})
 
-	metricsItems, err := m.getPodMetrics(pods...)
+	metricsItems, err := m.getPodMetrics(ctx, pods...)
 	if err != nil {
 		errMsg := fmt.Errorf("Error while fetching pod metrics for selector %v in namespace %q: %v", labelSelector, namespace, err)
 		klog.Error(errMsg)
 		return &metrics.PodMetricsList{}, errMsg
 	}
+	if stale {
+		for i := range metricsItems {
+			markStale(&metricsItems[i].ObjectMeta)
+		}
+	}
 
 	return &metrics.PodMetricsList{Items: metricsItems}, nil
 }
 
+// getPods looks up the given pods matching the label selector, skipping pods
+// which don't exist, instead of filtering a full list.
+func (m *podMetrics) getPods(names []apitypes.NamespacedName, labelSelector labels.Selector) ([]*v1.Pod, error) {
+	pods := make([]*v1.Pod, 0, len(names))
+	for _, name := range names {
+		pod, err := m.podLister.Pods(name.Namespace).Get(name.Name)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !labelSelector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
 // Getter interface
 func (m *podMetrics) Get(ctx context.Context, name string, opts *metav1.GetOptions) (runtime.Object, error) {
+	stale, err := checkListerStaleness(m.listerStaleness, m.maxListerStaleness)
+	if err != nil {
+		klog.Error(err)
+		return &metrics.PodMetrics{}, err
+	}
+	if err := m.healthyNodes.check(ctx); err != nil {
+		klog.Error(err)
+		return &metrics.PodMetrics{}, err
+	}
+
 	namespace := genericapirequest.NamespaceValue(ctx)
+	if m.excludedNamespaces.Has(namespace) {
+		return &metrics.PodMetrics{}, errors.NewNotFound(m.groupResource, fmt.Sprintf("%v/%v", namespace, name))
+	}
 
 	pod, err := m.podLister.Pods(namespace).Get(name)
 	if err != nil {
@@ -140,8 +309,17 @@ func (m *podMetrics) Get(ctx context.Context, name string, opts *metav1.GetOptio
 	if pod == nil {
 		return &metrics.PodMetrics{}, errors.NewNotFound(v1.Resource("pods"), fmt.Sprintf("%v/%v", namespace, name))
 	}
+	existing, err := m.verifyExistence(ctx, namespace, labels.Everything(), fields.OneTermEqualSelector("metadata.name", name), []*v1.Pod{pod})
+	if err != nil {
+		errMsg := fmt.Errorf("Error while verifying pod %v exists: %v", name, err)
+		klog.Error(errMsg)
+		return &metrics.PodMetrics{}, errMsg
+	}
+	if len(existing) == 0 {
+		return &metrics.PodMetrics{}, errors.NewNotFound(v1.Resource("pods"), fmt.Sprintf("%v/%v", namespace, name))
+	}
 
-	podMetrics, err := m.getPodMetrics(pod)
+	podMetrics, err := m.getPodMetrics(ctx, pod)
 	if err == nil && len(podMetrics) == 0 {
 		err = fmt.Errorf("no metrics known for pod \"%s/%s\"", pod.Namespace, pod.Name)
 	}
@@ -149,9 +327,33 @@ func (m *podMetrics) Get(ctx context.Context, name string, opts *metav1.GetOptio
 		klog.Errorf("unable to fetch pod metrics for pod %s/%s: %v", pod.Namespace, pod.Name, err)
 		return nil, errors.NewNotFound(m.groupResource, fmt.Sprintf("%v/%v", namespace, name))
 	}
+	if stale {
+		markStale(&podMetrics[0].ObjectMeta)
+	}
 	return &podMetrics[0], nil
 }
 
+// verifyExistence omits pods which no longer exist in the Kubernetes API server,
+// because the pod lister hasn't observed their deletion yet.
+func (m *podMetrics) verifyExistence(ctx context.Context, namespace string, labelSelector labels.Selector, fieldSelector fields.Selector, pods []*v1.Pod) ([]*v1.Pod, error) {
+	if m.podVerifier == nil || len(pods) == 0 {
+		return pods, nil
+	}
+	uids, err := m.podVerifier.ExistingPodUIDs(ctx, namespace, labelSelector, fieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	existing := make([]*v1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if !uids.Has(string(pod.UID)) {
+			klog.V(2).Infof("skipping deleted pod %s/%s", pod.Namespace, pod.Name)
+			continue
+		}
+		existing = append(existing, pod)
+	}
+	return existing, nil
+}
+
 func (m *podMetrics) ConvertToTable(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1beta1.Table, error) {
 	var table metav1beta1.Table
 
@@ -159,32 +361,23 @@ func (m *podMetrics) ConvertToTable(ctx context.Context, object runtime.Object,
 	case *metrics.PodMetrics:
 		table.ResourceVersion = t.ResourceVersion
 		table.SelfLink = t.SelfLink
-		addPodMetricsToTable(&table, *t)
+		addPodMetricsToTable(&table, m.aggregation, *t)
 	case *metrics.PodMetricsList:
 		table.ResourceVersion = t.ResourceVersion
 		table.SelfLink = t.SelfLink
 		table.Continue = t.Continue
-		addPodMetricsToTable(&table, t.Items...)
+		addPodMetricsToTable(&table, m.aggregation, t.Items...)
 	default:
 	}
 
 	return &table, nil
 }
 
-func addPodMetricsToTable(table *metav1beta1.Table, pods ...metrics.PodMetrics) {
+func addPodMetricsToTable(table *metav1beta1.Table, aggregation PodAggregation, pods ...metrics.PodMetrics) {
 	usage := make(v1.ResourceList, 3)
 	var names []string
 	for i, pod := range pods {
-		for k := range usage {
-			delete(usage, k)
-		}
-		for _, container := range pod.Containers {
-			for k, v := range container.Usage {
-				u := usage[k]
-				u.Add(v)
-				usage[k] = u
-			}
-		}
+		aggregation.aggregate(usage, pod.Containers)
 		if names == nil {
 			for k := range usage {
 				names = append(names, string(k))
@@ -196,9 +389,10 @@ func addPodMetricsToTable(table *metav1beta1.Table, pods ...metrics.PodMetrics)
 			}
 			for _, name := range names {
 				table.ColumnDefinitions = append(table.ColumnDefinitions, metav1beta1.TableColumnDefinition{
-					Name:   name,
-					Type:   "string",
-					Format: "quantity",
+					Name:        name,
+					Type:        "string",
+					Format:      "quantity",
+					Description: aggregation.description(),
 				})
 			}
 			table.ColumnDefinitions = append(table.ColumnDefinitions, metav1beta1.TableColumnDefinition{
@@ -221,7 +415,7 @@ func addPodMetricsToTable(table *metav1beta1.Table, pods ...metrics.PodMetrics)
 	}
 }
 
-func (m *podMetrics) getPodMetrics(pods ...*v1.Pod) ([]metrics.PodMetrics, error) {
+func (m *podMetrics) getPodMetrics(ctx context.Context, pods ...*v1.Pod) ([]metrics.PodMetrics, error) {
 	namespacedNames := make([]apitypes.NamespacedName, len(pods))
 	for i, pod := range pods {
 		namespacedNames[i] = apitypes.NamespacedName{
@@ -229,7 +423,10 @@ func (m *podMetrics) getPodMetrics(pods ...*v1.Pod) ([]metrics.PodMetrics, error
 			Namespace: pod.Namespace,
 		}
 	}
-	timestamps, containerMetrics := m.metrics.GetContainerMetrics(namespacedNames...)
+	timestamps, containerMetrics, err := m.metrics.GetContainerMetrics(ctx, namespacedNames...)
+	if err != nil {
+		return nil, err
+	}
 	res := make([]metrics.PodMetrics, 0, len(pods))
 
 	for i, pod := range pods {
@@ -237,25 +434,80 @@ func (m *podMetrics) getPodMetrics(pods ...*v1.Pod) ([]metrics.PodMetrics, error
 			// ignore pod not in Running phase
 			continue
 		}
-		if containerMetrics[i] == nil {
+		if containerMetrics[i] == nil || m.orphaned(pod) || m.onCordonedNode(pod) {
+			continue
+		}
+		if m.skipEphemeral {
+			containerMetrics[i] = withoutEphemeralContainers(pod, containerMetrics[i])
+		}
+		missing := missingContainers(pod, containerMetrics[i])
+		if len(missing) != 0 && m.partialPolicy == PartialPodOmit {
+			klog.V(2).Infof("skipping pod %s/%s, missing metrics for containers %v", pod.Namespace, pod.Name, missing)
 			continue
 		}
 
-		res = append(res, metrics.PodMetrics{
+		for j := range containerMetrics[i] {
+			usage := m.rounding.Round(containerMetrics[i][j].Usage)
+			containerMetrics[i][j].Usage = m.memoryDampener.Dampen("pod/"+pod.Namespace+"/"+pod.Name+"/"+containerMetrics[i][j].Name, usage)
+		}
+		podMetrics := metrics.PodMetrics{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:              pod.Name,
 				Namespace:         pod.Namespace,
+				Labels:            selectLabels(pod.Labels, m.echoLabels),
 				CreationTimestamp: metav1.NewTime(myClock.Now()),
 			},
 			Timestamp:  metav1.NewTime(timestamps[i].Timestamp),
 			Window:     metav1.Duration{Duration: timestamps[i].Window},
 			Containers: containerMetrics[i],
-		})
+		}
+		if len(missing) != 0 && m.partialPolicy == PartialPodFlag {
+			markPartial(&podMetrics, missing)
+		}
+		if m.partialPolicy == PartialPodFlag {
+			markPodMissingResources(&podMetrics)
+		}
+		if m.usageDeltas != nil {
+			usage := v1.ResourceList{}
+			PodAggregationSum.aggregate(usage, podMetrics.Containers)
+			markUsageDelta(&podMetrics.ObjectMeta, m.usageDeltas.Delta("pod/"+pod.Namespace+"/"+pod.Name, timestamps[i].Timestamp, usage))
+		}
+		if m.includeQOS {
+			markQOSClass(&podMetrics, pod)
+		}
+		if m.includePriority {
+			markPriority(&podMetrics, pod)
+		}
+		if m.includeRestarts {
+			markRestartCounts(&podMetrics, pod)
+		}
+		if m.flagUsageOverRequests {
+			markUsageOverRequests(&podMetrics, pod)
+		}
+		res = append(res, podMetrics)
 		metricFreshness.WithLabelValues().Observe(myClock.Since(timestamps[i].Timestamp).Seconds())
 	}
 	return res, nil
 }
 
+// selectLabels returns the labels with the given keys, or nil if there are
+// none. Label selectors are matched against all pod labels, so echoed labels
+// always agree with the selector of the list.
+func selectLabels(podLabels map[string]string, keys []string) map[string]string {
+	var selected map[string]string
+	for _, key := range keys {
+		value, found := podLabels[key]
+		if !found {
+			continue
+		}
+		if selected == nil {
+			selected = make(map[string]string, len(keys))
+		}
+		selected[key] = value
+	}
+	return selected
+}
+
 func (m *podMetrics) NamespaceScoped() bool {
 	return true
 }
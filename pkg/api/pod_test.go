@@ -17,6 +17,7 @@ limitations under the License.
 package api
 
 import (
+	"context"
 	"reflect"
 	"strings"
 	"testing"
@@ -25,15 +26,19 @@ import (
 	"k8s.io/component-base/metrics/testutil"
 
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	fields "k8s.io/apimachinery/pkg/fields"
 	labels "k8s.io/apimachinery/pkg/labels"
 
 	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	apitypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/sets"
 	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
 	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/metrics/pkg/apis/metrics"
 )
 
@@ -60,8 +65,11 @@ type fakePodMetricsGetter struct {
 
 var _ PodMetricsGetter = (*fakePodMetricsGetter)(nil)
 
-func (mp fakePodMetricsGetter) GetContainerMetrics(pods ...apitypes.NamespacedName) ([]TimeInfo, [][]metrics.ContainerMetrics) {
-	return mp.time, mp.metrics
+func (mp fakePodMetricsGetter) GetContainerMetrics(ctx context.Context, pods ...apitypes.NamespacedName) ([]TimeInfo, [][]metrics.ContainerMetrics, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return mp.time, mp.metrics, nil
 }
 
 func NewPodTestStorage(resp interface{}, err error) *podMetrics {
@@ -144,6 +152,38 @@ func TestPodList_ConvertToTable(t *testing.T) {
 	}
 }
 
+func TestPodList_ConvertToTableAggregation(t *testing.T) {
+	pod := &metrics.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1"},
+		Containers: []metrics.ContainerMetrics{
+			{Name: "container1", Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("10Mi")}},
+			{Name: "container2", Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("300m"), v1.ResourceMemory: resource.MustParse("5Mi")}},
+		},
+	}
+	for _, tc := range []struct {
+		aggregation PodAggregation
+		cpu, memory string
+	}{
+		{aggregation: "", cpu: "400m", memory: "15Mi"},
+		{aggregation: PodAggregationSum, cpu: "400m", memory: "15Mi"},
+		{aggregation: PodAggregationMax, cpu: "300m", memory: "10Mi"},
+	} {
+		r := NewPodTestStorage(createTestPods(), nil)
+		r.aggregation = tc.aggregation
+
+		res, err := r.ConvertToTable(genericapirequest.NewContext(), pod, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(res.Rows) != 1 || res.Rows[0].Cells[1] != tc.cpu || res.Rows[0].Cells[2] != tc.memory {
+			t.Errorf("Expected %q aggregation to serve cpu %s and memory %s, got %+v", tc.aggregation, tc.cpu, tc.memory, res.Rows)
+		}
+	}
+	if got := pod.Containers[0].Usage[v1.ResourceCPU]; got.String() != "100m" {
+		t.Errorf("Expected container usage to be unmodified, got %s", got.String())
+	}
+}
+
 func TestPodList_EmptyResponse(t *testing.T) {
 	// setup
 	r := NewPodTestStorage([]*v1.Pod{}, nil)
@@ -186,6 +226,530 @@ func TestPodList_WithFieldSelectors(t *testing.T) {
 	}
 }
 
+func TestPodList_ExcludedNamespaces(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		opts   *metainternalversion.ListOptions
+		expect []string
+	}{
+		{
+			name:   "no selectors",
+			expect: []string{"testValue/pod2"},
+		},
+		{
+			name: "field selector matching excluded namespace",
+			opts: &metainternalversion.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.namespace", "other"),
+			},
+			expect: []string{},
+		},
+		{
+			name: "field selector matching included namespace",
+			opts: &metainternalversion.ListOptions{
+				FieldSelector: fields.OneTermEqualSelector("metadata.name", "pod2"),
+			},
+			expect: []string{"testValue/pod2"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewPodTestStorage(createTestPods(), nil)
+			r.excludedNamespaces = sets.NewString("other", "kube-system")
+
+			got, err := r.List(genericapirequest.NewContext(), tc.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			names := []string{}
+			for _, item := range got.(*metrics.PodMetricsList).Items {
+				names = append(names, item.Namespace+"/"+item.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expect) {
+				t.Errorf("Got unexpected pods: %v, expected: %v", names, tc.expect)
+			}
+		})
+	}
+}
+
+func TestPodList_MaxSelectorRequirements(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		selector    string
+		expectError bool
+	}{
+		{
+			name:     "selector within the limit",
+			selector: "app=web,tier!=db",
+		},
+		{
+			name:        "selector exceeding the limit",
+			selector:    "app=web,tier!=db,track in (stable,canary)",
+			expectError: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewPodTestStorage(createTestPods(), nil)
+			r.maxSelectorRequirements = 2
+			selector, err := labels.Parse(tc.selector)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			_, err = r.List(genericapirequest.NewContext(), &metainternalversion.ListOptions{LabelSelector: selector})
+			if tc.expectError && !errors.IsBadRequest(err) {
+				t.Errorf("Expected bad request error, got: %v", err)
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestPodList_IncludePodQOS(t *testing.T) {
+	resources := func(cpu, memory string) v1.ResourceList {
+		list := v1.ResourceList{}
+		if cpu != "" {
+			list[v1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if memory != "" {
+			list[v1.ResourceMemory] = resource.MustParse(memory)
+		}
+		return list
+	}
+	pods := createTestPods()
+	pods[0].Spec.Containers = []v1.Container{
+		{Name: "metric1", Resources: v1.ResourceRequirements{Requests: resources("10m", "5Mi"), Limits: resources("10m", "5Mi")}},
+		{Name: "metric1-b", Resources: v1.ResourceRequirements{Requests: resources("20m", "5Mi"), Limits: resources("20m", "5Mi")}},
+	}
+	pods[1].Spec.Containers = []v1.Container{
+		{Name: "metric2", Resources: v1.ResourceRequirements{Requests: resources("10m", ""), Limits: resources("20m", "")}},
+	}
+	bestEffort := pods[2]
+	bestEffort.Spec.Containers = []v1.Container{{Name: "metric3"}}
+	r := NewPodTestStorage(pods, nil)
+	r.includeQOS = true
+
+	qosClasses := func() map[string]string {
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		classes := map[string]string{}
+		for _, item := range got.(*metrics.PodMetricsList).Items {
+			classes[item.Name] = item.Annotations[QOSClassAnnotation]
+		}
+		return classes
+	}
+	expect := map[string]string{"pod1": "Guaranteed", "pod2": "Burstable", "pod3": "BestEffort"}
+	if got := qosClasses(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected QoS classes: %v, expected: %v", got, expect)
+	}
+
+	// the class follows the current spec, not the spec at scrape time
+	bestEffort.Spec.Containers[0].Resources.Requests = resources("", "5Mi")
+	expect["pod3"] = "Burstable"
+	if got := qosClasses(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected QoS classes after spec change: %v, expected: %v", got, expect)
+	}
+}
+
+func TestPodList_IncludePodPriority(t *testing.T) {
+	pods := createTestPods()
+	high, low := int32(1000000), int32(-10)
+	pods[0].Spec.Priority = &high
+	pods[1].Spec.Priority = &low
+	unresolved := pods[2]
+	r := NewPodTestStorage(pods, nil)
+
+	priorities := func() map[string]string {
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		values := map[string]string{}
+		for _, item := range got.(*metrics.PodMetricsList).Items {
+			if value, found := item.Annotations[PriorityAnnotation]; found {
+				values[item.Name] = value
+			}
+		}
+		return values
+	}
+	if got := priorities(); len(got) != 0 {
+		t.Errorf("Expected no priorities when disabled, got: %v", got)
+	}
+
+	r.includePriority = true
+	expect := map[string]string{"pod1": "1000000", "pod2": "-10"}
+	if got := priorities(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected priorities: %v, expected: %v", got, expect)
+	}
+
+	// the priority follows the current spec, not the spec at scrape time
+	zero := int32(0)
+	unresolved.Spec.Priority = &zero
+	expect["pod3"] = "0"
+	if got := priorities(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected priorities after spec change: %v, expected: %v", got, expect)
+	}
+}
+
+func TestPodList_IncludeRestartCounts(t *testing.T) {
+	pods := createTestPods()
+	pods[0].Status.ContainerStatuses = []v1.ContainerStatus{
+		{Name: "metric1-b", RestartCount: 0},
+		{Name: "metric1", RestartCount: 3},
+		{Name: "unscraped", RestartCount: 7},
+	}
+	// pods are listed sorted, so pod2 gets the metrics of the third container
+	pods[1].Status.EphemeralContainerStatuses = []v1.ContainerStatus{{Name: "metric3", RestartCount: 1}}
+	r := NewPodTestStorage(pods, nil)
+
+	restarts := func() map[string]string {
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		values := map[string]string{}
+		for _, item := range got.(*metrics.PodMetricsList).Items {
+			if value, found := item.Annotations[RestartCountsAnnotation]; found {
+				values[item.Name] = value
+			}
+		}
+		return values
+	}
+	if got := restarts(); len(got) != 0 {
+		t.Errorf("Expected no restart counts when disabled, got: %v", got)
+	}
+
+	r.includeRestarts = true
+	expect := map[string]string{"pod1": "metric1=3,metric1-b=0", "pod2": "metric3=1"}
+	if got := restarts(); !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected restart counts: %v, expected: %v", got, expect)
+	}
+}
+
+func TestPodList_FlagUsageOverRequests(t *testing.T) {
+	resources := func(requests, limits v1.ResourceList) v1.ResourceRequirements {
+		return v1.ResourceRequirements{Requests: requests, Limits: limits}
+	}
+	pods := createTestPods()
+	// pod1 uses 10m CPU and 5Mi memory, and only one of its containers sets limits
+	pods[0].Spec.Containers = []v1.Container{
+		{Name: "metric1", Resources: resources(v1.ResourceList{v1.ResourceCPU: resource.MustParse("5m")}, v1.ResourceList{v1.ResourceCPU: resource.MustParse("5m")})},
+		{Name: "metric1-b", Resources: resources(v1.ResourceList{v1.ResourceMemory: resource.MustParse("10Mi")}, nil)},
+	}
+	// pods are listed sorted, so pod3 uses 20m CPU and 15Mi memory
+	pod3 := pods[2]
+	pod3.Spec.Containers = []v1.Container{
+		{Name: "metric2", Resources: resources(
+			v1.ResourceList{v1.ResourceCPU: resource.MustParse("50m"), v1.ResourceMemory: resource.MustParse("10Mi")},
+			v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("12Mi")},
+		)},
+	}
+	// pod2 sets no requests nor limits
+	r := NewPodTestStorage(pods, nil)
+
+	annotations := func(key string) map[string]string {
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		values := map[string]string{}
+		for _, item := range got.(*metrics.PodMetricsList).Items {
+			if value, found := item.Annotations[key]; found {
+				values[item.Name] = value
+			}
+		}
+		return values
+	}
+	if got := annotations(UsageOverRequestsAnnotation); len(got) != 0 {
+		t.Errorf("Expected no annotations when disabled, got: %v", got)
+	}
+
+	r.flagUsageOverRequests = true
+	if got, expect := annotations(UsageOverRequestsAnnotation), map[string]string{"pod1": "cpu", "pod3": "memory"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected usage over requests: %v, expected: %v", got, expect)
+	}
+	if got, expect := annotations(UsageOverLimitsAnnotation), map[string]string{"pod3": "memory"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected usage over limits: %v, expected: %v", got, expect)
+	}
+
+	// the bounds follow the current spec, not the spec at scrape time
+	pod3.Spec.Containers[0].Resources.Limits[v1.ResourceMemory] = resource.MustParse("1Gi")
+	if got := annotations(UsageOverLimitsAnnotation); len(got) != 0 {
+		t.Errorf("Expected no usage over limits after spec change, got: %v", got)
+	}
+}
+
+func TestPodList_EchoLabels(t *testing.T) {
+	pods := createTestPods()
+	pods[0].Labels = map[string]string{"app": "web", "team": "payments", "secret-hash": "abc"}
+	pods[1].Labels = map[string]string{"app": "db", "pod-template-hash": "123"}
+	r := NewPodTestStorage(pods, nil)
+	r.echoLabels = []string{"app", "team"}
+
+	got, err := r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	echoed := map[string]map[string]string{}
+	for _, item := range got.(*metrics.PodMetricsList).Items {
+		echoed[item.Name] = item.Labels
+	}
+	expect := map[string]map[string]string{
+		"pod1": {"app": "web", "team": "payments"},
+		"pod2": {"app": "db"},
+		"pod3": nil,
+	}
+	if !reflect.DeepEqual(echoed, expect) {
+		t.Errorf("Got unexpected labels: %v, expected: %v", echoed, expect)
+	}
+
+	// selectors match pod labels, so served pods agree with the selector
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, pod := range pods {
+		if err := indexer.Add(pod); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	r.podLister = listerv1.NewPodLister(indexer)
+	selector, err := labels.Parse("team=payments")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	got, err = r.List(genericapirequest.NewContext(), &metainternalversion.ListOptions{LabelSelector: selector})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	items := got.(*metrics.PodMetricsList).Items
+	if len(items) != 1 || items[0].Name != "pod1" || !selector.Matches(labels.Set(items[0].Labels)) {
+		t.Errorf("Expected only pod1 with labels matching %s, got: %+v", selector, items)
+	}
+}
+
+func TestPodGet_ExcludedNamespace(t *testing.T) {
+	pods := createTestPods()
+	r := NewPodTestStorage(pods[0], nil)
+	r.excludedNamespaces = sets.NewString("other")
+
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "other")
+	_, err := r.Get(ctx, "pod1", nil)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Expected not found error, got: %v", err)
+	}
+}
+
+func TestPodList_PartialContainerMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		policy           PartialPodPolicy
+		expectPods       []string
+		expectAnnotation map[string]string
+	}{
+		{
+			policy:     PartialPodSum,
+			expectPods: []string{"pod1", "pod3", "pod2"},
+		},
+		{
+			policy:     PartialPodOmit,
+			expectPods: []string{"pod3", "pod2"},
+		},
+		{
+			policy:           PartialPodFlag,
+			expectPods:       []string{"pod1", "pod3", "pod2"},
+			expectAnnotation: map[string]string{PartialAnnotation: "metric1-c,metric1-d", MissingResourcesAnnotation: "cpu,memory"},
+		},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			pods := createTestPods()
+			// kubelet reported metrics for only the first two containers of pod1
+			pods[0].Spec.Containers = []v1.Container{{Name: "metric1"}, {Name: "metric1-b"}, {Name: "metric1-c"}, {Name: "metric1-d"}}
+			pods[2].Spec.Containers = []v1.Container{{Name: "metric2"}}
+			r := NewPodTestStorage(pods, nil)
+			r.partialPolicy = tc.policy
+
+			got, err := r.List(genericapirequest.NewContext(), nil)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			res := got.(*metrics.PodMetricsList)
+
+			names := []string{}
+			for _, item := range res.Items {
+				names = append(names, item.Name)
+			}
+			if !reflect.DeepEqual(names, tc.expectPods) {
+				t.Fatalf("Got unexpected pods: %v, expected: %v", names, tc.expectPods)
+			}
+			if tc.policy == PartialPodOmit {
+				return
+			}
+			if len(res.Items[0].Containers) != 2 {
+				t.Errorf("Expected reported containers to be served, got: %+v", res.Items[0].Containers)
+			}
+			if !reflect.DeepEqual(res.Items[0].Annotations, tc.expectAnnotation) {
+				t.Errorf("Got unexpected annotations: %v, expected: %v", res.Items[0].Annotations, tc.expectAnnotation)
+			}
+			if res.Items[2].Annotations != nil {
+				t.Errorf("Expected no annotations on fully reported pod, got: %v", res.Items[2].Annotations)
+			}
+		})
+	}
+}
+
+func TestPodList_SkipEphemeralContainers(t *testing.T) {
+	pods := createTestPods()
+	// metric1-b is a debug container added with kubectl debug
+	pods[0].Spec.Containers = []v1.Container{{Name: "metric1"}}
+	pods[0].Spec.EphemeralContainers = []v1.EphemeralContainer{{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "metric1-b"}}}
+	r := NewPodTestStorage(pods, nil)
+
+	containers := func() []string {
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		names := []string{}
+		for _, container := range got.(*metrics.PodMetricsList).Items[0].Containers {
+			names = append(names, container.Name)
+		}
+		return names
+	}
+	if got, expect := containers(), []string{"metric1", "metric1-b"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected containers: %v, expected: %v", got, expect)
+	}
+	r.skipEphemeral = true
+	if got, expect := containers(), []string{"metric1"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected containers with ephemeral containers skipped: %v, expected: %v", got, expect)
+	}
+}
+
+type fakePodExistenceVerifier struct {
+	uids sets.String
+}
+
+func (v fakePodExistenceVerifier) ExistingPodUIDs(ctx context.Context, namespace string, labelSelector labels.Selector, fieldSelector fields.Selector) (sets.String, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return v.uids, nil
+}
+
+func TestPodList_VerifyPodExistence(t *testing.T) {
+	// pod3 was deleted, but the lagging informer still lists it
+	pods := createTestPods()
+	for _, pod := range pods {
+		pod.UID = apitypes.UID(pod.Name)
+	}
+	r := NewPodTestStorage(pods, nil)
+	r.podVerifier = fakePodExistenceVerifier{uids: sets.NewString("pod1", "pod2")}
+
+	got, err := r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res := got.(*metrics.PodMetricsList)
+
+	if len(res.Items) != 2 ||
+		res.Items[0].Name != "pod1" ||
+		res.Items[1].Name != "pod2" {
+		t.Errorf("Got unexpected object: %+v", got)
+	}
+}
+
+func TestPodList_VerifyPodExistenceCanceled(t *testing.T) {
+	pods := createTestPods()
+	r := NewPodTestStorage(pods, nil)
+	r.podVerifier = fakePodExistenceVerifier{uids: sets.NewString("pod1", "pod2")}
+
+	ctx, cancel := context.WithCancel(genericapirequest.NewContext())
+	cancel()
+	if _, err := r.list(ctx, "", nil); err == nil || !strings.Contains(err.Error(), "verifying pods") {
+		t.Fatalf("Expected the verification to be canceled with the request, got: %v", err)
+	}
+}
+
+func TestPodGet_VerifyPodExistence(t *testing.T) {
+	pod := createTestPods()[2]
+	pod.UID = "pod3"
+	r := NewPodTestStorage(pod, nil)
+	r.podVerifier = fakePodExistenceVerifier{uids: sets.NewString()}
+
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "other")
+	_, err := r.Get(ctx, "pod3", nil)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("Expected not found error, got: %v", err)
+	}
+
+	r.podVerifier = fakePodExistenceVerifier{uids: sets.NewString("pod3")}
+	if _, err := r.Get(ctx, "pod3", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestPodList_DropOrphanedPods(t *testing.T) {
+	pods := createTestPods()
+	pods[0].Spec.NodeName = "node1"
+	// the node of pod3 was deleted, pod2 isn't assigned to a node
+	pods[2].Spec.NodeName = "node-removed"
+	r := NewPodTestStorage(pods, nil)
+
+	names := func() []string {
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		names := []string{}
+		for _, item := range got.(*metrics.PodMetricsList).Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+	if got, expect := names(), []string{"pod1", "pod3", "pod2"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected pods: %v, expected: %v", got, expect)
+	}
+	r.nodeLister = fakeNodeLister{resp: createTestNodes()}
+	if got, expect := names(), []string{"pod1", "pod2"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected pods with orphaned pods dropped: %v, expected: %v", got, expect)
+	}
+}
+
+func TestPodList_DropCordonedNodePods(t *testing.T) {
+	pods := createTestPods()
+	pods[0].Spec.NodeName = "node1"
+	// pod3 is on a cordoned node
+	cordoned := pods[2]
+	cordoned.Spec.NodeName = "node2"
+	nodes := createTestNodes()
+	nodes[1].Spec.Unschedulable = true
+	r := NewPodTestStorage(pods, nil)
+
+	names := func() []string {
+		got, err := r.List(genericapirequest.NewContext(), nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		names := []string{}
+		for _, item := range got.(*metrics.PodMetricsList).Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+	if got, expect := names(), []string{"pod1", "pod3", "pod2"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected pods: %v, expected: %v", got, expect)
+	}
+	r.cordonedNodes = fakeNodeLister{resp: nodes}
+	if got, expect := names(), []string{"pod1", "pod2"}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected pods with pods on cordoned nodes dropped: %v, expected: %v", got, expect)
+	}
+	r = NewPodTestStorage(cordoned, nil)
+	r.cordonedNodes = fakeNodeLister{resp: nodes}
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), cordoned.Namespace)
+	if _, err := r.Get(ctx, cordoned.Name, nil); !errors.IsNotFound(err) {
+		t.Errorf("Expected not found for a pod on a cordoned node, got %v", err)
+	}
+}
+
 func TestPodList_PodNotRunning(t *testing.T) {
 	// setup
 	pods := createTestPods()
@@ -208,6 +772,22 @@ func TestPodList_PodNotRunning(t *testing.T) {
 		t.Errorf("Got unexpected object: %+v", got)
 	}
 }
+
+func TestPodGet_PodNotRunning(t *testing.T) {
+	pods := createTestPods()
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "other")
+
+	// the storage still has series for the pod, e.g. from containers started before others pull their images
+	r := NewPodTestStorage(pods[0], nil)
+	if _, err := r.Get(ctx, "pod1", nil); err != nil {
+		t.Fatalf("Unexpected error getting the running pod: %v", err)
+	}
+	pods[0].Status.Phase = v1.PodPending
+	if _, err := r.Get(ctx, "pod1", nil); !errors.IsNotFound(err) {
+		t.Errorf("Expected not found error for the pending pod, got: %v", err)
+	}
+}
+
 func TestPodList_Monitoring(t *testing.T) {
 	c := &fakeClock{}
 	myClock = c
@@ -0,0 +1,100 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	apitypes "k8s.io/apimachinery/pkg/types"
+)
+
+// PodNamesField is a PodMetrics field selector matching any pod of a set,
+// given as names or namespace/name pairs separated by PodNamesSeparator,
+// e.g. metadata.names=ns1/pod1|ns2/pod2. Bare names are only allowed in
+// namespaced requests, and refer to pods in the request namespace.
+const PodNamesField = "metadata.names"
+
+// PodNamesSeparator separates pods in the value of a PodNamesField selector.
+const PodNamesSeparator = "|"
+
+// podFieldLabelConversion accepts PodNamesField, in addition to the object
+// metadata fields supported for all resources.
+func podFieldLabelConversion(label, value string) (string, string, error) {
+	if label == PodNamesField {
+		return label, value, nil
+	}
+	return runtime.DefaultMetaV1FieldSelectorConversion(label, value)
+}
+
+// splitPodNames extracts the pods selected by a PodNamesField requirement,
+// returning nil if there is none, and the remaining field selector.
+func splitPodNames(namespace string, selector fields.Selector) ([]apitypes.NamespacedName, fields.Selector, error) {
+	var value string
+	found := false
+	for _, r := range selector.Requirements() {
+		if r.Field != PodNamesField {
+			continue
+		}
+		if r.Operator == selection.NotEquals {
+			return nil, nil, errors.NewBadRequest(fmt.Sprintf("field label %q only supports equality", PodNamesField))
+		}
+		if found {
+			return nil, nil, errors.NewBadRequest(fmt.Sprintf("field label %q can only be given once", PodNamesField))
+		}
+		value = r.Value
+		found = true
+	}
+	if !found {
+		return nil, selector, nil
+	}
+	rest, err := selector.Transform(func(field, value string) (string, string, error) {
+		if field == PodNamesField {
+			return "", "", nil
+		}
+		return field, value, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[apitypes.NamespacedName]bool)
+	names := []apitypes.NamespacedName{}
+	for _, entry := range strings.Split(value, PodNamesSeparator) {
+		if entry == "" {
+			continue
+		}
+		name := apitypes.NamespacedName{Namespace: namespace, Name: entry}
+		if i := strings.Index(entry, "/"); i >= 0 {
+			name = apitypes.NamespacedName{Namespace: entry[:i], Name: entry[i+1:]}
+		} else if namespace == "" {
+			return nil, nil, errors.NewBadRequest(fmt.Sprintf("field label %q requires namespace/name pairs across all namespaces, got %q", PodNamesField, entry))
+		}
+		if namespace != "" && name.Namespace != namespace {
+			// pods outside the request namespace never match
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, rest, nil
+}
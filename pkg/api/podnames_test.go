@@ -0,0 +1,172 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	listerv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/metrics/pkg/apis/metrics"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// getOnlyPodLister fails full lists, to verify that pods are looked up by name.
+type getOnlyPodLister struct {
+	listerv1.PodLister
+}
+
+func (l getOnlyPodLister) List(labels.Selector) ([]*v1.Pod, error) {
+	return nil, fmt.Errorf("unexpected full list")
+}
+
+func (l getOnlyPodLister) Pods(namespace string) listerv1.PodNamespaceLister {
+	return getOnlyPodNamespaceLister{l.PodLister.Pods(namespace)}
+}
+
+type getOnlyPodNamespaceLister struct {
+	listerv1.PodNamespaceLister
+}
+
+func (l getOnlyPodNamespaceLister) List(labels.Selector) ([]*v1.Pod, error) {
+	return nil, fmt.Errorf("unexpected full list")
+}
+
+func newPodNamesTestStorage(t *testing.T) *podMetrics {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, name := range []string{"ns1/pod-a", "ns1/pod-b", "ns1/pod-c", "ns1/pod-d", "ns1/pod-e", "ns2/pod-x", "ns2/pod-y"} {
+		namespace, name, _ := cache.SplitMetaNamespaceKey(name)
+		app := "db"
+		if name == "pod-b" || name == "pod-y" {
+			app = "web"
+		}
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": app}},
+			Status:     v1.PodStatus{Phase: v1.PodRunning},
+		}
+		if err := indexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &podMetrics{
+		podLister: getOnlyPodLister{listerv1.NewPodLister(indexer)},
+		metrics:   benchPodMetricsGetter{},
+	}
+}
+
+func listPodNames(t *testing.T, r *podMetrics, namespace string, opts *metainternalversion.ListOptions) []string {
+	got, err := r.List(genericapirequest.WithNamespace(genericapirequest.NewContext(), namespace), opts)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	names := []string{}
+	for _, item := range got.(*metrics.PodMetricsList).Items {
+		names = append(names, item.Namespace+"/"+item.Name)
+	}
+	return names
+}
+
+func TestPodList_PodNamesFieldSelector(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		namespace     string
+		fieldSelector string
+		webOnly       bool
+		expect        []string
+	}{
+		{
+			name:          "scattered subset across namespaces, skipping missing pods",
+			fieldSelector: "metadata.names=ns2/pod-y|ns1/pod-d|ns1/pod-missing|ns1/pod-b",
+			expect:        []string{"ns1/pod-b", "ns1/pod-d", "ns2/pod-y"},
+		},
+		{
+			name:          "names in the request namespace",
+			namespace:     "ns1",
+			fieldSelector: "metadata.names=pod-e|ns2/pod-x|ns1/pod-a|pod-e",
+			expect:        []string{"ns1/pod-a", "ns1/pod-e"},
+		},
+		{
+			name:          "combined with a label selector",
+			fieldSelector: "metadata.names=ns1/pod-a|ns1/pod-b|ns2/pod-y",
+			webOnly:       true,
+			expect:        []string{"ns1/pod-b", "ns2/pod-y"},
+		},
+		{
+			name:          "combined with other field selectors",
+			fieldSelector: "metadata.names=ns1/pod-a|ns2/pod-x,metadata.namespace=ns2",
+			expect:        []string{"ns2/pod-x"},
+		},
+		{
+			name:          "empty set",
+			fieldSelector: "metadata.names=",
+			expect:        []string{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &metainternalversion.ListOptions{
+				FieldSelector: fields.ParseSelectorOrDie(tc.fieldSelector),
+				LabelSelector: labels.Everything(),
+			}
+			if tc.webOnly {
+				opts.LabelSelector = labels.SelectorFromSet(labels.Set{"app": "web"})
+			}
+			got := listPodNames(t, newPodNamesTestStorage(t), tc.namespace, opts)
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("Expected pods %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestPodList_PodNamesFieldSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{
+		"metadata.names=pod-a",
+		"metadata.names!=ns1/pod-a",
+		"metadata.names=ns1/pod-a,metadata.names=ns1/pod-b",
+	} {
+		opts := &metainternalversion.ListOptions{FieldSelector: fields.ParseSelectorOrDie(selector)}
+		_, err := newPodNamesTestStorage(t).List(genericapirequest.NewContext(), opts)
+		if !errors.IsBadRequest(err) {
+			t.Errorf("Expected bad request for %q, got %v", selector, err)
+		}
+	}
+}
+
+func TestPodFieldLabelConversion(t *testing.T) {
+	gvk := v1beta1.SchemeGroupVersion.WithKind("PodMetrics")
+	for label, supported := range map[string]bool{
+		PodNamesField:        true,
+		"metadata.name":      true,
+		"metadata.namespace": true,
+		"spec.nodeName":      false,
+	} {
+		_, _, err := Scheme.ConvertFieldLabel(gvk, label, "value")
+		if supported && err != nil {
+			t.Errorf("Expected field label %q to be supported, got %v", label, err)
+		}
+		if !supported && err == nil {
+			t.Errorf("Expected field label %q to be rejected", label)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+const (
+	// PodCountAnnotation is the number of pods with metrics stored for a node.
+	PodCountAnnotation = "metrics.k8s.io/pod-count"
+	// PodUsageAnnotation is the summed usage of the containers of the pods
+	// counted in PodCountAnnotation, e.g. "cpu=1200m,memory=3Gi". Unlike the
+	// node usage, it leaves out system daemons and other processes outside of
+	// pods.
+	PodUsageAnnotation = "metrics.k8s.io/pod-usage"
+)
+
+// markPodStats annotates the node with the number and summed usage of its
+// pods, rounded like the node usage so both can be compared.
+func markPodStats(node *metrics.NodeMetrics, stats NodePodStats, rounding usageRounding) {
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[PodCountAnnotation] = strconv.Itoa(stats.Pods)
+	usage := rounding.Round(stats.Usage)
+	var parts []string
+	for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		if quantity, found := usage[name]; found {
+			parts = append(parts, fmt.Sprintf("%s=%s", name, quantity.String()))
+		}
+	}
+	node.Annotations[PodUsageAnnotation] = strings.Join(parts, ",")
+}
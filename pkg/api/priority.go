@@ -0,0 +1,38 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// PriorityAnnotation holds the priority of a pod, taken from its current spec
+// when the metrics are served.
+const PriorityAnnotation = "metrics.k8s.io/priority"
+
+// markPriority annotates the metrics with the priority of the pod. Pods
+// admitted without priority resolution have none, and are left unannotated.
+func markPriority(podMetrics *metrics.PodMetrics, pod *v1.Pod) {
+	if pod.Spec.Priority == nil {
+		return
+	}
+	if podMetrics.Annotations == nil {
+		podMetrics.Annotations = map[string]string{}
+	}
+	podMetrics.Annotations[PriorityAnnotation] = strconv.FormatInt(int64(*pod.Spec.Priority), 10)
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// protobufMetricsGetter serves the metrics of the node and pod test getters.
+type protobufMetricsGetter struct {
+	fakeNodeMetricsGetter
+	benchPodMetricsGetter
+}
+
+func TestProtobufNegotiation(t *testing.T) {
+	config := genericapiserver.NewConfig(Codecs)
+	config.LoopbackClientConfig = &rest.Config{}
+	config.ExternalAddress = "127.0.0.1:443"
+	server, err := config.Complete(nil).New("metrics-server-test", genericapiserver.NewEmptyDelegate())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	node := &v1.Node{}
+	node.Name = "node1"
+	if err := factory.Core().V1().Nodes().Informer().GetIndexer().Add(node); err != nil {
+		t.Fatal(err)
+	}
+	pod := &v1.Pod{}
+	pod.Namespace, pod.Name = "default", "pod1"
+	pod.Status.Phase = v1.PodRunning
+	if err := factory.Core().V1().Pods().Informer().GetIndexer().Add(pod); err != nil {
+		t.Fatal(err)
+	}
+	getter := protobufMetricsGetter{fakeNodeMetricsGetter: fakeNodeMetricsGetter{
+		time:      []TimeInfo{{Timestamp: myClock.Now(), Window: 1000}},
+		resources: []v1.ResourceList{{v1.ResourceCPU: resource.MustParse("10m"), v1.ResourceMemory: resource.MustParse("5Mi")}},
+	}}
+	if err := Install(getter, factory.Core().V1(), Config{}, server); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	get := func(path string) runtime.Object {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Accept", runtime.ContentTypeProtobuf)
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected %s to succeed, got %d: %s", path, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("Content-Type"); got != runtime.ContentTypeProtobuf {
+			t.Fatalf("Expected %s to be served as %s, got %s", path, runtime.ContentTypeProtobuf, got)
+		}
+		obj, err := runtime.Decode(Codecs.UniversalDeserializer(), rec.Body.Bytes())
+		if err != nil {
+			t.Fatalf("Unexpected error decoding %s: %v", path, err)
+		}
+		return obj
+	}
+
+	nodeMetrics, ok := get("/apis/metrics.k8s.io/v1beta1/nodes/node1").(*v1beta1.NodeMetrics)
+	if !ok {
+		t.Fatal("Expected NodeMetrics")
+	}
+	if nodeMetrics.Name != "node1" || !nodeMetrics.Usage.Cpu().Equal(resource.MustParse("10m")) || !nodeMetrics.Usage.Memory().Equal(resource.MustParse("5Mi")) {
+		t.Errorf("Unexpected NodeMetrics: %+v", nodeMetrics)
+	}
+	podMetrics, ok := get("/apis/metrics.k8s.io/v1beta1/namespaces/default/pods").(*v1beta1.PodMetricsList)
+	if !ok {
+		t.Fatal("Expected PodMetricsList")
+	}
+	if len(podMetrics.Items) != 1 || podMetrics.Items[0].Name != "pod1" || len(podMetrics.Items[0].Containers) != 1 {
+		t.Fatalf("Unexpected PodMetricsList: %+v", podMetrics)
+	}
+	if usage := podMetrics.Items[0].Containers[0].Usage; !usage.Memory().Equal(resource.MustParse("5Mi")) {
+		t.Errorf("Unexpected container usage: %v", usage)
+	}
+}
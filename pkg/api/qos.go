@@ -0,0 +1,81 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// QOSClassAnnotation holds the QoS class of a pod, derived from its current
+// spec when the metrics are served.
+const QOSClassAnnotation = "metrics.k8s.io/qos-class"
+
+// podQOSClass derives the QoS class of a pod from the resources of its
+// containers, like the GetPodQOS helper of Kubernetes.
+func podQOSClass(pod *v1.Pod) v1.PodQOSClass {
+	requests := v1.ResourceList{}
+	limits := v1.ResourceList{}
+	isGuaranteed := true
+	containers := append(append([]v1.Container{}, pod.Spec.Containers...), pod.Spec.InitContainers...)
+	for _, container := range containers {
+		addQOSResources(requests, container.Resources.Requests)
+		if found := addQOSResources(limits, container.Resources.Limits); found < 2 {
+			// guaranteed pods need both CPU and memory limits on every container
+			isGuaranteed = false
+		}
+	}
+	if len(requests) == 0 && len(limits) == 0 {
+		return v1.PodQOSBestEffort
+	}
+	if isGuaranteed {
+		for name, request := range requests {
+			if limit, found := limits[name]; !found || limit.Cmp(request) != 0 {
+				isGuaranteed = false
+				break
+			}
+		}
+	}
+	if isGuaranteed && len(requests) == len(limits) {
+		return v1.PodQOSGuaranteed
+	}
+	return v1.PodQOSBurstable
+}
+
+// addQOSResources sums the positive CPU and memory quantities into total,
+// returning how many of them were found.
+func addQOSResources(total, resources v1.ResourceList) int {
+	found := 0
+	for _, name := range []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory} {
+		quantity, ok := resources[name]
+		if !ok || quantity.Sign() <= 0 {
+			continue
+		}
+		found++
+		sum := quantity.DeepCopy()
+		if current, ok := total[name]; ok {
+			sum.Add(current)
+		}
+		total[name] = sum
+	}
+	return found
+}
+
+func markQOSClass(podMetrics *metrics.PodMetrics, pod *v1.Pod) {
+	if podMetrics.Annotations == nil {
+		podMetrics.Annotations = map[string]string{}
+	}
+	podMetrics.Annotations[QOSClassAnnotation] = string(podQOSClass(pod))
+}
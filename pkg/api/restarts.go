@@ -0,0 +1,53 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// RestartCountsAnnotation holds the restart counts of the containers of a pod,
+// e.g. "app=3,sidecar=0", taken from its current status when the metrics are
+// served.
+const RestartCountsAnnotation = "metrics.k8s.io/restart-counts"
+
+// markRestartCounts annotates the metrics with the restart count of each
+// container with metrics, in the order of the metrics. Containers without a
+// status are left out, and pods without any are left unannotated.
+func markRestartCounts(podMetrics *metrics.PodMetrics, pod *v1.Pod) {
+	restarts := make(map[string]int32, len(pod.Status.ContainerStatuses)+len(pod.Status.EphemeralContainerStatuses))
+	for _, statuses := range [][]v1.ContainerStatus{pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, status := range statuses {
+			restarts[status.Name] = status.RestartCount
+		}
+	}
+	counts := make([]string, 0, len(podMetrics.Containers))
+	for _, container := range podMetrics.Containers {
+		if count, found := restarts[container.Name]; found {
+			counts = append(counts, container.Name+"="+strconv.Itoa(int(count)))
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+	if podMetrics.Annotations == nil {
+		podMetrics.Annotations = map[string]string{}
+	}
+	podMetrics.Annotations[RestartCountsAnnotation] = strings.Join(counts, ",")
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// nanosPerMilli is the number of nanocores in a millicore. CPU is rounded from
+// nanocores, as MilliValue rounds up and would bias served usage upwards.
+const nanosPerMilli = 1000 * 1000
+
+// usageRounding rounds served usage to a fixed granularity, to reduce noise for
+// consumers that don't need full precision. Stored metrics are left untouched.
+type usageRounding struct {
+	// cpuMillis is the CPU granularity in millicores, zero disables rounding.
+	cpuMillis int64
+	// memoryBytes is the memory granularity in bytes, zero disables rounding.
+	memoryBytes int64
+	// cpuMilliPrecision quantizes CPU to whole millicores when cpuMillis is zero,
+	// otherwise CPU is served at the nanocore precision it was collected with.
+	cpuMilliPrecision bool
+}
+
+// Round returns a copy of usage with CPU and memory rounded to the nearest
+// multiple of their granularity.
+func (r usageRounding) Round(usage v1.ResourceList) v1.ResourceList {
+	if r.cpuMillis <= 0 && r.memoryBytes <= 0 && !r.cpuMilliPrecision {
+		return usage
+	}
+	rounded := make(v1.ResourceList, len(usage))
+	for name, quantity := range usage {
+		switch {
+		case name == v1.ResourceCPU && r.cpuMillis > 0:
+			rounded[name] = *resource.NewMilliQuantity(roundToMultiple(quantity.ScaledValue(resource.Nano), r.cpuMillis*nanosPerMilli)/nanosPerMilli, quantity.Format)
+		case name == v1.ResourceCPU && r.cpuMilliPrecision:
+			rounded[name] = *resource.NewMilliQuantity(roundToMultiple(quantity.ScaledValue(resource.Nano), nanosPerMilli)/nanosPerMilli, quantity.Format)
+		case name == v1.ResourceMemory && r.memoryBytes > 0:
+			rounded[name] = *resource.NewQuantity(roundToMultiple(quantity.Value(), r.memoryBytes), quantity.Format)
+		default:
+			rounded[name] = quantity
+		}
+	}
+	return rounded
+}
+
+// roundToMultiple rounds value to the nearest multiple of granularity, with halves rounded up.
+func roundToMultiple(value, granularity int64) int64 {
+	return (value + granularity/2) / granularity * granularity
+}
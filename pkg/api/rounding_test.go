@@ -0,0 +1,175 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+func TestUsageRounding(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		rounding     usageRounding
+		cpu, memory  resource.Quantity
+		expectCPU    string
+		expectMemory string
+	}{
+		{
+			name:         "Zero granularity leaves usage untouched",
+			cpu:          *resource.NewScaledQuantity(1234567, resource.Nano),
+			memory:       *resource.NewQuantity(1025, resource.BinarySI),
+			expectCPU:    "1234567n",
+			expectMemory: "1025",
+		},
+		{
+			name:         "CPU below half of granularity rounds down",
+			rounding:     usageRounding{cpuMillis: 10},
+			cpu:          *resource.NewMilliQuantity(14, resource.DecimalSI),
+			memory:       *resource.NewQuantity(1025, resource.BinarySI),
+			expectCPU:    "10m",
+			expectMemory: "1025",
+		},
+		{
+			name:         "CPU at half of granularity rounds up",
+			rounding:     usageRounding{cpuMillis: 10},
+			cpu:          *resource.NewMilliQuantity(15, resource.DecimalSI),
+			memory:       *resource.NewQuantity(1025, resource.BinarySI),
+			expectCPU:    "20m",
+			expectMemory: "1025",
+		},
+		{
+			name:         "CPU below half of the smallest granularity rounds to zero",
+			rounding:     usageRounding{cpuMillis: 10},
+			cpu:          *resource.NewMilliQuantity(4, resource.DecimalSI),
+			memory:       *resource.NewQuantity(1025, resource.BinarySI),
+			expectCPU:    "0",
+			expectMemory: "1025",
+		},
+		{
+			name:         "Memory below half of granularity rounds down",
+			rounding:     usageRounding{memoryBytes: 1024},
+			cpu:          *resource.NewMilliQuantity(15, resource.DecimalSI),
+			memory:       *resource.NewQuantity(1024+511, resource.BinarySI),
+			expectCPU:    "15m",
+			expectMemory: "1Ki",
+		},
+		{
+			name:         "Memory at half of granularity rounds up",
+			rounding:     usageRounding{memoryBytes: 1024},
+			cpu:          *resource.NewMilliQuantity(15, resource.DecimalSI),
+			memory:       *resource.NewQuantity(1024+512, resource.BinarySI),
+			expectCPU:    "15m",
+			expectMemory: "2Ki",
+		},
+		{
+			name:         "Milli precision quantizes CPU to millicores",
+			rounding:     usageRounding{cpuMilliPrecision: true},
+			cpu:          *resource.NewScaledQuantity(12000001, resource.Nano),
+			memory:       *resource.NewQuantity(1025, resource.BinarySI),
+			expectCPU:    "12m",
+			expectMemory: "1025",
+		},
+		{
+			name:         "Milli precision rounds half a millicore up",
+			rounding:     usageRounding{cpuMilliPrecision: true},
+			cpu:          *resource.NewScaledQuantity(12500000, resource.Nano),
+			memory:       *resource.NewQuantity(1025, resource.BinarySI),
+			expectCPU:    "13m",
+			expectMemory: "1025",
+		},
+		{
+			name:         "CPU granularity takes precedence over milli precision",
+			rounding:     usageRounding{cpuMillis: 10, cpuMilliPrecision: true},
+			cpu:          *resource.NewScaledQuantity(14000001, resource.Nano),
+			memory:       *resource.NewQuantity(1025, resource.BinarySI),
+			expectCPU:    "10m",
+			expectMemory: "1025",
+		},
+		{
+			name:         "Exact multiples are unchanged",
+			rounding:     usageRounding{cpuMillis: 5, memoryBytes: 1024},
+			cpu:          *resource.NewMilliQuantity(25, resource.DecimalSI),
+			memory:       *resource.NewQuantity(4*1024, resource.BinarySI),
+			expectCPU:    "25m",
+			expectMemory: "4Ki",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			usage := v1.ResourceList{v1.ResourceCPU: tc.cpu, v1.ResourceMemory: tc.memory}
+			got := tc.rounding.Round(usage)
+			cpu, memory := got[v1.ResourceCPU], got[v1.ResourceMemory]
+			if cpu.String() != tc.expectCPU {
+				t.Errorf("Unexpected CPU usage %q, expected %q", cpu.String(), tc.expectCPU)
+			}
+			if memory.String() != tc.expectMemory {
+				t.Errorf("Unexpected memory usage %q, expected %q", memory.String(), tc.expectMemory)
+			}
+			if original := usage[v1.ResourceCPU]; original.Cmp(tc.cpu) != 0 {
+				t.Errorf("Rounding modified the original usage")
+			}
+		})
+	}
+}
+
+func TestUsageRounding_CPUPrecisionRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		rounding  usageRounding
+		expectCPU string
+	}{
+		{name: "nano", expectCPU: "1500n"},
+		{name: "milli", rounding: usageRounding{cpuMilliPrecision: true}, expectCPU: "0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			usage := tc.rounding.Round(v1.ResourceList{v1.ResourceCPU: *resource.NewScaledQuantity(1500, resource.Nano)})
+
+			data, err := json.Marshal(usage)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var got v1.ResourceList
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			cpu := got[v1.ResourceCPU]
+			if cpu.String() != tc.expectCPU {
+				t.Errorf("Unexpected CPU usage %q after round-trip, expected %q", cpu.String(), tc.expectCPU)
+			}
+		})
+	}
+}
+
+func TestPodList_Rounding(t *testing.T) {
+	r := NewPodTestStorage(createTestPods(), nil)
+	r.rounding = usageRounding{cpuMillis: 50, memoryBytes: 10 * 1024 * 1024}
+
+	got, err := r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res := got.(*metrics.PodMetricsList)
+
+	cpu := res.Items[1].Containers[0].Usage[v1.ResourceCPU]
+	memory := res.Items[1].Containers[0].Usage[v1.ResourceMemory]
+	if cpu.String() != "0" || memory.String() != "20Mi" {
+		t.Errorf("Got unexpected usage: cpu %v, memory %v", cpu.String(), memory.String())
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+)
+
+// Codec marshals messages with gogo/protobuf, which the metrics.k8s.io types
+// are generated with. It must be used by both servers and clients.
+type Codec struct{}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("unable to marshal %T, not a protobuf message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unable to unmarshal %T, not a protobuf message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// Name is the content subtype of the codec.
+func (Codec) Name() string {
+	return "proto"
+}
+
+// String implements the legacy grpc.Codec interface, used by grpc.CustomCodec.
+func (c Codec) String() string {
+	return c.Name()
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rpc implements the gRPC service serving resource metrics,
+// described by metrics.proto.
+package rpc
+
+import (
+	"github.com/gogo/protobuf/proto"
+)
+
+// GetNodeMetricsRequest names the node to get metrics of.
+type GetNodeMetricsRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (m *GetNodeMetricsRequest) Reset()         { *m = GetNodeMetricsRequest{} }
+func (m *GetNodeMetricsRequest) String() string { return proto.CompactTextString(m) }
+func (*GetNodeMetricsRequest) ProtoMessage()    {}
+
+// GetPodMetricsRequest names the pod to get metrics of.
+type GetPodMetricsRequest struct {
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Name      string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+}
+
+func (m *GetPodMetricsRequest) Reset()         { *m = GetPodMetricsRequest{} }
+func (m *GetPodMetricsRequest) String() string { return proto.CompactTextString(m) }
+func (*GetPodMetricsRequest) ProtoMessage()    {}
+
+// ListPodMetricsRequest selects the pods to list metrics of, with selectors
+// in the same syntax as the labelSelector and fieldSelector query parameters.
+// An empty namespace lists pods in all namespaces.
+type ListPodMetricsRequest struct {
+	Namespace     string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	LabelSelector string `protobuf:"bytes,2,opt,name=labelSelector,proto3" json:"labelSelector,omitempty"`
+	FieldSelector string `protobuf:"bytes,3,opt,name=fieldSelector,proto3" json:"fieldSelector,omitempty"`
+}
+
+func (m *ListPodMetricsRequest) Reset()         { *m = ListPodMetricsRequest{} }
+func (m *ListPodMetricsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListPodMetricsRequest) ProtoMessage()    {}
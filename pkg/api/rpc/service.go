@@ -0,0 +1,136 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// ServiceName is the full name of the Metrics service. Its methods are served
+// under the /ServiceName/ path.
+const ServiceName = "metricsserver.v1beta1.Metrics"
+
+// MetricsServer is the server API of the Metrics service.
+type MetricsServer interface {
+	GetNodeMetrics(context.Context, *GetNodeMetricsRequest) (*v1beta1.NodeMetrics, error)
+	GetPodMetrics(context.Context, *GetPodMetricsRequest) (*v1beta1.PodMetrics, error)
+	ListPodMetrics(context.Context, *ListPodMetricsRequest) (*v1beta1.PodMetricsList, error)
+}
+
+// NewServer returns a gRPC server serving the Metrics service with the Codec.
+func NewServer(srv MetricsServer, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append([]grpc.ServerOption{grpc.CustomCodec(Codec{})}, opts...)...)
+	s.RegisterService(&serviceDesc, srv)
+	return s
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MetricsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetNodeMetrics", Handler: getNodeMetricsHandler},
+		{MethodName: "GetPodMetrics", Handler: getPodMetricsHandler},
+		{MethodName: "ListPodMetrics", Handler: listPodMetricsHandler},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "metrics.proto",
+}
+
+func getNodeMetricsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetNodeMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/GetNodeMetrics"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetNodeMetrics(ctx, req.(*GetNodeMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func getPodMetricsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPodMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetPodMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/GetPodMetrics"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetPodMetrics(ctx, req.(*GetPodMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func listPodMetricsHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPodMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).ListPodMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/ListPodMetrics"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).ListPodMetrics(ctx, req.(*ListPodMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MetricsClient is the client API of the Metrics service.
+type MetricsClient interface {
+	GetNodeMetrics(ctx context.Context, in *GetNodeMetricsRequest, opts ...grpc.CallOption) (*v1beta1.NodeMetrics, error)
+	GetPodMetrics(ctx context.Context, in *GetPodMetricsRequest, opts ...grpc.CallOption) (*v1beta1.PodMetrics, error)
+	ListPodMetrics(ctx context.Context, in *ListPodMetricsRequest, opts ...grpc.CallOption) (*v1beta1.PodMetricsList, error)
+}
+
+type metricsClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewMetricsClient returns a client of the Metrics service, calling it with the Codec.
+func NewMetricsClient(cc *grpc.ClientConn) MetricsClient {
+	return &metricsClient{cc: cc}
+}
+
+func (c *metricsClient) GetNodeMetrics(ctx context.Context, in *GetNodeMetricsRequest, opts ...grpc.CallOption) (*v1beta1.NodeMetrics, error) {
+	out := new(v1beta1.NodeMetrics)
+	err := c.invoke(ctx, "GetNodeMetrics", in, out, opts)
+	return out, err
+}
+
+func (c *metricsClient) GetPodMetrics(ctx context.Context, in *GetPodMetricsRequest, opts ...grpc.CallOption) (*v1beta1.PodMetrics, error) {
+	out := new(v1beta1.PodMetrics)
+	err := c.invoke(ctx, "GetPodMetrics", in, out, opts)
+	return out, err
+}
+
+func (c *metricsClient) ListPodMetrics(ctx context.Context, in *ListPodMetricsRequest, opts ...grpc.CallOption) (*v1beta1.PodMetricsList, error) {
+	out := new(v1beta1.PodMetricsList)
+	err := c.invoke(ctx, "ListPodMetrics", in, out, opts)
+	return out, err
+}
+
+func (c *metricsClient) invoke(ctx context.Context, method string, in, out interface{}, opts []grpc.CallOption) error {
+	opts = append([]grpc.CallOption{grpc.ForceCodec(Codec{})}, opts...)
+	return c.cc.Invoke(ctx, "/"+ServiceName+"/"+method, in, out, opts...)
+}
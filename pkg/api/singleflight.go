@@ -0,0 +1,69 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/singleflight"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+)
+
+// listKey identifies list requests that produce the same response, so they
+// can be collapsed into one computation. Requests differing in namespace,
+// selectors or resource version never share a key.
+func listKey(namespace string, options *metainternalversion.ListOptions) string {
+	var labelSelector, fieldSelector, resourceVersion string
+	if options != nil {
+		if options.LabelSelector != nil {
+			labelSelector = options.LabelSelector.String()
+		}
+		if options.FieldSelector != nil {
+			fieldSelector = options.FieldSelector.String()
+		}
+		resourceVersion = options.ResourceVersion
+	}
+	return fmt.Sprintf("%q %q %q %q", namespace, labelSelector, fieldSelector, resourceVersion)
+}
+
+// canceledListError is returned to all callers sharing a list computation
+// whose context, that of the first caller, was done before it completed.
+type canceledListError struct {
+	err error
+}
+
+func (e canceledListError) Error() string {
+	return fmt.Sprintf("list canceled: %v", e.err)
+}
+
+// sharedList runs fn once for concurrent callers with the same key, using the
+// context of the first caller. Callers whose own context is still active when
+// that computation is canceled run it again instead of failing.
+func sharedList(ctx context.Context, group *singleflight.Group, key string, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		res, err, _ := group.Do(key, func() (interface{}, error) {
+			res, err := fn(ctx)
+			if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+				return nil, canceledListError{err: ctxErr}
+			}
+			return res, err
+		})
+		if _, canceled := err.(canceledListError); canceled && ctx.Err() == nil {
+			continue
+		}
+		return res, err
+	}
+}
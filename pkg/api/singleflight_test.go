@@ -0,0 +1,231 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestListKey(t *testing.T) {
+	base := &metainternalversion.ListOptions{
+		LabelSelector:   labels.SelectorFromSet(labels.Set{"app": "web"}),
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", "pod1"),
+		ResourceVersion: "10",
+	}
+	key := listKey("ns1", base)
+
+	same := &metainternalversion.ListOptions{
+		LabelSelector:   labels.SelectorFromSet(labels.Set{"app": "web"}),
+		FieldSelector:   fields.OneTermEqualSelector("metadata.name", "pod1"),
+		ResourceVersion: "10",
+	}
+	if got := listKey("ns1", same); got != key {
+		t.Errorf("Expected identical requests to share key %s, got %s", key, got)
+	}
+
+	for name, tc := range map[string]struct {
+		namespace string
+		options   *metainternalversion.ListOptions
+	}{
+		"namespace":        {namespace: "ns2", options: base},
+		"all namespaces":   {namespace: "", options: base},
+		"label selector":   {namespace: "ns1", options: &metainternalversion.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{"app": "db"}), FieldSelector: base.FieldSelector, ResourceVersion: "10"}},
+		"field selector":   {namespace: "ns1", options: &metainternalversion.ListOptions{LabelSelector: base.LabelSelector, FieldSelector: fields.OneTermEqualSelector("metadata.name", "pod2"), ResourceVersion: "10"}},
+		"resource version": {namespace: "ns1", options: &metainternalversion.ListOptions{LabelSelector: base.LabelSelector, FieldSelector: base.FieldSelector, ResourceVersion: "11"}},
+		"no options":       {namespace: "ns1"},
+	} {
+		if got := listKey(tc.namespace, tc.options); got == key {
+			t.Errorf("Requests differing by %s share key %s", name, key)
+		}
+	}
+}
+
+func TestNodeList_ConcurrentSelectorsNotShared(t *testing.T) {
+	r := NewTestNodeStorage(createTestNodes(), nil)
+
+	expect := map[string][]string{
+		"node1": {"node1"},
+		"node2": {"node2"},
+		"node3": {"node3"},
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 10; i++ {
+		for name, want := range expect {
+			wg.Add(1)
+			go func(name string, want []string) {
+				defer wg.Done()
+				opts := &metainternalversion.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", name)}
+				got, err := r.List(genericapirequest.NewContext(), opts)
+				if err != nil {
+					errs <- err
+					return
+				}
+				items := got.(*metrics.NodeMetricsList).Items
+				if len(items) != len(want) || items[0].Name != want[0] {
+					errs <- fmt.Errorf("selecting %s got unexpected nodes: %+v", name, items)
+				}
+			}(name, want)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPodList_ReturnsIndependentCopies(t *testing.T) {
+	r := NewPodTestStorage(createTestPods(), nil)
+
+	first, err := r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first.(*metrics.PodMetricsList).SelfLink = "/list"
+	first.(*metrics.PodMetricsList).Items[0].SelfLink = "/item"
+
+	second, err := r.List(genericapirequest.NewContext(), nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	res := second.(*metrics.PodMetricsList)
+	if res.SelfLink != "" || res.Items[0].SelfLink != "" {
+		t.Errorf("Expected unmodified metadata, got list %q, item %q", res.SelfLink, res.Items[0].SelfLink)
+	}
+}
+
+func TestPodList_CanceledContext(t *testing.T) {
+	r := NewPodTestStorage(createTestPods(), nil)
+	ctx, cancel := context.WithCancel(genericapirequest.NewContext())
+	cancel()
+
+	if _, err := r.List(ctx, nil); err == nil {
+		t.Error("Expected an error listing with a canceled context")
+	}
+}
+
+func TestSharedList_RetriesAfterFirstCallerCanceled(t *testing.T) {
+	var group singleflight.Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		select {
+		case started <- struct{}{}:
+			<-release
+		default:
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return "ok", nil
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := sharedList(firstCtx, &group, "key", fn)
+		firstErr <- err
+	}()
+	<-started
+
+	secondRes := make(chan interface{}, 1)
+	secondErr := make(chan error, 1)
+	go func() {
+		res, err := sharedList(context.Background(), &group, "key", fn)
+		secondRes <- res
+		secondErr <- err
+	}()
+	// give the second caller time to join the first computation
+	time.Sleep(10 * time.Millisecond)
+	cancelFirst()
+	close(release)
+
+	if err := <-firstErr; err == nil {
+		t.Error("Expected the canceled caller to get an error")
+	}
+	if err := <-secondErr; err != nil {
+		t.Errorf("Expected the second caller to retry, got error: %v", err)
+	}
+	if res := <-secondRes; res != "ok" {
+		t.Errorf("Expected the second caller to get a result, got %v", res)
+	}
+}
+
+func BenchmarkPodList_ConcurrentIdentical(b *testing.B) {
+	pods := make([]*v1.Pod, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		pod := &v1.Pod{}
+		pod.Namespace = "default"
+		pod.Name = fmt.Sprintf("pod%d", i)
+		pod.Status.Phase = v1.PodRunning
+		pods = append(pods, pod)
+	}
+	r := NewPodTestStorage(pods, nil)
+	r.metrics = benchPodMetricsGetter{}
+	ctx := genericapirequest.WithNamespace(genericapirequest.NewContext(), "default")
+	opts := &metainternalversion.ListOptions{LabelSelector: labels.Everything()}
+
+	b.Run("without singleflight", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := r.list(ctx, "default", opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+	b.Run("with singleflight", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := r.List(ctx, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	})
+}
+
+// benchPodMetricsGetter returns metrics for a single container of each pod.
+type benchPodMetricsGetter struct{}
+
+func (benchPodMetricsGetter) GetContainerMetrics(_ context.Context, pods ...apitypes.NamespacedName) ([]TimeInfo, [][]metrics.ContainerMetrics, error) {
+	timestamps := make([]TimeInfo, len(pods))
+	containers := make([][]metrics.ContainerMetrics, len(pods))
+	for i := range pods {
+		timestamps[i] = TimeInfo{Timestamp: myClock.Now(), Window: 1000}
+		containers[i] = []metrics.ContainerMetrics{{
+			Name:  "container",
+			Usage: v1.ResourceList{v1.ResourceCPU: resource.MustParse("10m"), v1.ResourceMemory: resource.MustParse("5Mi")},
+		}}
+	}
+	return timestamps, containers, nil
+}
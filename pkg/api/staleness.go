@@ -0,0 +1,52 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StaleAnnotation is set on metrics served from a node and pod snapshot that
+// may be out of date, because the listers lost their connection to the
+// Kubernetes API server.
+const StaleAnnotation = "metrics.k8s.io/stale"
+
+// checkListerStaleness reports whether the listers are serving a stale snapshot,
+// and returns an error if they have been stale for longer than maxStaleness.
+// A zero maxStaleness allows serving a stale snapshot indefinitely.
+func checkListerStaleness(staleness ListerStaleness, maxStaleness time.Duration) (stale bool, err error) {
+	if staleness == nil {
+		return false, nil
+	}
+	d := staleness.Staleness()
+	if d <= 0 {
+		return false, nil
+	}
+	if maxStaleness > 0 && d > maxStaleness {
+		return true, errors.NewServiceUnavailable(fmt.Sprintf("connection to the API server was lost %s ago, which exceeds the maximum informer staleness of %s", d, maxStaleness))
+	}
+	return true, nil
+}
+
+func markStale(meta *metav1.ObjectMeta) {
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[StaleAnnotation] = "true"
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// fakeListerStaleness simulates listers disconnected from the API server for a fixed duration
+type fakeListerStaleness time.Duration
+
+var _ ListerStaleness = fakeListerStaleness(0)
+
+func (s fakeListerStaleness) Staleness() time.Duration {
+	return time.Duration(s)
+}
+
+func TestListerStaleness(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		staleness    ListerStaleness
+		maxStaleness time.Duration
+		expectStale  bool
+		expectError  bool
+	}{
+		{
+			name: "No staleness tracking serves fresh metrics",
+		},
+		{
+			name:      "Connected listers serve fresh metrics",
+			staleness: fakeListerStaleness(0),
+		},
+		{
+			name:        "Disconnected listers without a bound serve stale metrics",
+			staleness:   fakeListerStaleness(time.Hour),
+			expectStale: true,
+		},
+		{
+			name:         "Disconnected listers within the bound serve stale metrics",
+			staleness:    fakeListerStaleness(time.Minute),
+			maxStaleness: 5 * time.Minute,
+			expectStale:  true,
+		},
+		{
+			name:         "Disconnected listers beyond the bound are unavailable",
+			staleness:    fakeListerStaleness(10 * time.Minute),
+			maxStaleness: 5 * time.Minute,
+			expectError:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nodes := NewTestNodeStorage(createTestNodes(), nil)
+			nodes.listerStaleness = tc.staleness
+			nodes.maxListerStaleness = tc.maxStaleness
+			pods := NewPodTestStorage(createTestPods(), nil)
+			pods.listerStaleness = tc.staleness
+			pods.maxListerStaleness = tc.maxStaleness
+
+			nodeList, nodeErr := nodes.List(genericapirequest.NewContext(), nil)
+			podList, podErr := pods.List(genericapirequest.NewContext(), nil)
+			if tc.expectError {
+				if !errors.IsServiceUnavailable(nodeErr) || !errors.IsServiceUnavailable(podErr) {
+					t.Fatalf("Expected service unavailable errors, got %v and %v", nodeErr, podErr)
+				}
+				return
+			}
+			if nodeErr != nil || podErr != nil {
+				t.Fatalf("Unexpected errors: %v, %v", nodeErr, podErr)
+			}
+
+			for _, item := range nodeList.(*metrics.NodeMetricsList).Items {
+				if stale := item.Annotations[StaleAnnotation] == "true"; stale != tc.expectStale {
+					t.Errorf("Node %q stale annotation = %v, expected %v", item.Name, stale, tc.expectStale)
+				}
+			}
+			for _, item := range podList.(*metrics.PodMetricsList).Items {
+				if stale := item.Annotations[StaleAnnotation] == "true"; stale != tc.expectStale {
+					t.Errorf("Pod %q stale annotation = %v, expected %v", item.Name, stale, tc.expectStale)
+				}
+			}
+		})
+	}
+}
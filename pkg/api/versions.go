@@ -0,0 +1,78 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// CauseTypeServedVersion is the type of the causes listing the served
+// versions in the status of requests for an unserved version.
+const CauseTypeServedVersion metav1.CauseType = "ServedVersion"
+
+// WithUnservedVersionStatus makes requests for a version of the metrics.k8s.io
+// API group which isn't served fail with a 404 Not Found status listing the
+// served versions, instead of the plain "404 page not found" of unknown paths,
+// so that clients can fall back to a served version.
+func WithUnservedVersionStatus(handler http.Handler, versions func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		version, ok := requestedVersion(req.URL.Path)
+		if !ok {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		served := versions()
+		for _, v := range served {
+			if v == version {
+				handler.ServeHTTP(w, req)
+				return
+			}
+		}
+		responsewriters.ErrorNegotiated(unservedVersionError(version, served), Codecs, schema.GroupVersion{}, w, req)
+	})
+}
+
+// requestedVersion returns the version of the metrics.k8s.io API group
+// requested by the path, false if the path is outside of a version.
+func requestedVersion(path string) (string, bool) {
+	rest := strings.TrimPrefix(path, "/apis/"+metrics.GroupName+"/")
+	if rest == path {
+		return "", false
+	}
+	version := strings.SplitN(rest, "/", 2)[0]
+	return version, version != ""
+}
+
+func unservedVersionError(version string, served []string) *apierrors.StatusError {
+	causes := make([]metav1.StatusCause, 0, len(served))
+	for _, v := range served {
+		causes = append(causes, metav1.StatusCause{Type: CauseTypeServedVersion, Message: v, Field: "version"})
+	}
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusNotFound,
+		Reason:  metav1.StatusReasonNotFound,
+		Message: fmt.Sprintf("version %q of the %s API group is not served, served versions: %s", version, metrics.GroupName, strings.Join(served, ", ")),
+		Details: &metav1.StatusDetails{Group: metrics.GroupName, Causes: causes},
+	}}
+}
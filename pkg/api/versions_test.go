@@ -0,0 +1,57 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWithUnservedVersionStatus(t *testing.T) {
+	handler := WithUnservedVersionStatus(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), func() []string { return []string{"v1beta1"} })
+
+	for _, path := range []string{"/apis/metrics.k8s.io", "/apis/metrics.k8s.io/", "/apis/metrics.k8s.io/v1beta1", "/apis/metrics.k8s.io/v1beta1/nodes", "/healthz"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected %s to be served, got status %d: %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/apis/metrics.k8s.io/v1/nodes", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status %d requesting an unserved version, got %d", http.StatusNotFound, w.Code)
+	}
+	status := metav1.Status{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Expected a status, got %q: %v", w.Body.String(), err)
+	}
+	if status.Reason != metav1.StatusReasonNotFound || status.Message != `version "v1" of the metrics.k8s.io API group is not served, served versions: v1beta1` {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if status.Details == nil || status.Details.Group != "metrics.k8s.io" || len(status.Details.Causes) != 1 {
+		t.Fatalf("Expected details listing the served versions, got %+v", status.Details)
+	}
+	if cause := status.Details.Causes[0]; cause.Type != CauseTypeServedVersion || cause.Message != "v1beta1" {
+		t.Errorf("Expected v1beta1 listed as served, got %+v", cause)
+	}
+}
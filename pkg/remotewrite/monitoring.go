@@ -0,0 +1,45 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"k8s.io/component-base/metrics"
+)
+
+var (
+	samplesTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "remote_write",
+			Name:      "samples_total",
+			Help:      "Number of samples exported to the remote write endpoint, partitioned by result: sent, failed (after all retries) or dropped (queue full).",
+		},
+		[]string{"result"},
+	)
+)
+
+// RegisterRemoteWriteMetrics registers metrics for the samples exported to
+// the remote write endpoint.
+func RegisterRemoteWriteMetrics(registrationFunc func(metrics.Registerable) error) error {
+	for _, metric := range []metrics.Registerable{
+		samplesTotal,
+	} {
+		err := registrationFunc(metric)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
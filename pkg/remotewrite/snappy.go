@@ -0,0 +1,48 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"encoding/binary"
+)
+
+// maxSnappyLiteral is the longest literal encoded with a two byte length.
+const maxSnappyLiteral = 1 << 16
+
+// snappyEncode encodes data in the snappy block format required by the
+// remote write protocol. It only emits literals, so it doesn't compress, but
+// any snappy decoder reads it and metrics-server needs no compression library.
+func snappyEncode(data []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data)+3*(len(data)/maxSnappyLiteral+1))
+	dst = dst[:binary.PutUvarint(dst, uint64(len(data)))]
+	for len(data) > 0 {
+		literal := data
+		if len(literal) > maxSnappyLiteral {
+			literal = literal[:maxSnappyLiteral]
+		}
+		n := len(literal) - 1
+		switch {
+		case n < 60:
+			dst = append(dst, byte(n)<<2)
+		case n < 1<<8:
+			dst = append(dst, 60<<2, byte(n))
+		default:
+			dst = append(dst, 61<<2, byte(n), byte(n>>8))
+		}
+		dst = append(dst, literal...)
+		data = data[len(literal):]
+	}
+	return dst
+}
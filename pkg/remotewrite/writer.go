@@ -0,0 +1,259 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+const (
+	// maxSeriesPerRequest bounds the size of each remote write request.
+	maxSeriesPerRequest = 1000
+	// queueCapacity is the number of requests buffered while the endpoint is
+	// slow or failing, further requests are dropped.
+	queueCapacity = 100
+)
+
+// Config holds options for exporting scraped usage to a remote write endpoint.
+type Config struct {
+	// URL is the remote write endpoint.
+	URL string
+	// BearerTokenFile, if set, is read for the bearer token of every request.
+	BearerTokenFile string
+	// Username and PasswordFile, if set, authenticate requests with basic auth.
+	Username     string
+	PasswordFile string
+	// Timeout bounds each request.
+	Timeout time.Duration
+}
+
+// Writer exports batches of scraped usage to a remote write endpoint. Batches
+// are queued and sent in the background, so failures never block scraping
+// or serving.
+type Writer struct {
+	config  Config
+	client  *http.Client
+	backoff wait.Backoff
+	queue   chan *WriteRequest
+	// lastSent is the timestamp of the last sample queued for each series,
+	// nodes which weren't scraped again are stored with the same points.
+	lastSent map[string]int64
+}
+
+// NewWriter returns a writer exporting to the configured endpoint.
+func NewWriter(config Config) *Writer {
+	return &Writer{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		backoff: wait.Backoff{
+			Duration: 500 * time.Millisecond,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    5,
+			Cap:      30 * time.Second,
+		},
+		queue:    make(chan *WriteRequest, queueCapacity),
+		lastSent: map[string]int64{},
+	}
+}
+
+// Enqueue converts the batch to samples and queues them for sending. Points
+// which were already queued are skipped. It must not be called concurrently.
+func (w *Writer) Enqueue(batch *storage.MetricsBatch) {
+	series := w.newSeries(batch)
+	for len(series) > 0 {
+		n := len(series)
+		if n > maxSeriesPerRequest {
+			n = maxSeriesPerRequest
+		}
+		req := &WriteRequest{Timeseries: series[:n]}
+		series = series[n:]
+		select {
+		case w.queue <- req:
+		default:
+			klog.Warningf("remote write queue is full, dropping %d samples", len(req.Timeseries))
+			samplesTotal.WithLabelValues("dropped").Add(float64(len(req.Timeseries)))
+		}
+	}
+}
+
+// newSeries returns a series with a single sample for the CPU and memory
+// usage of every node and container with points newer than last queued.
+func (w *Writer) newSeries(batch *storage.MetricsBatch) []*TimeSeries {
+	var series []*TimeSeries
+	seen := make(map[string]int64, len(w.lastSent))
+	add := func(point storage.MetricsPoint, prefix string, labels ...*Label) {
+		timestamp := point.Timestamp.UnixNano() / int64(time.Millisecond)
+		key := seriesKey(labels)
+		seen[key] = timestamp
+		if last, found := w.lastSent[key]; found && timestamp <= last {
+			return
+		}
+		if !point.CPUMissing {
+			series = append(series, newTimeSeries(prefix+"_cpu_usage_cores", labels, float64(point.CpuUsage.ScaledValue(resource.Nano))/1e9, timestamp))
+		}
+		if !point.MemoryMissing {
+			series = append(series, newTimeSeries(prefix+"_memory_working_set_bytes", labels, float64(point.MemoryUsage.Value()), timestamp))
+		}
+	}
+	for _, node := range batch.Nodes {
+		add(node.MetricsPoint, "metrics_server_node", &Label{Name: "node", Value: node.Name})
+	}
+	for _, pod := range batch.Pods {
+		for _, container := range pod.Containers {
+			add(container.MetricsPoint, "metrics_server_container",
+				&Label{Name: "container", Value: container.Name},
+				&Label{Name: "namespace", Value: pod.Namespace},
+				&Label{Name: "pod", Value: pod.Name},
+			)
+		}
+	}
+	// series which are gone are forgotten
+	w.lastSent = seen
+	return series
+}
+
+// newTimeSeries returns a series of the metric with labels sorted by name.
+func newTimeSeries(name string, labels []*Label, value float64, timestamp int64) *TimeSeries {
+	seriesLabels := make([]*Label, 0, len(labels)+1)
+	seriesLabels = append(seriesLabels, &Label{Name: "__name__", Value: name})
+	seriesLabels = append(seriesLabels, labels...)
+	sort.Slice(seriesLabels, func(i, j int) bool { return seriesLabels[i].Name < seriesLabels[j].Name })
+	return &TimeSeries{
+		Labels:  seriesLabels,
+		Samples: []*Sample{{Value: value, Timestamp: timestamp}},
+	}
+}
+
+func seriesKey(labels []*Label) string {
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.Name + "=" + l.Value
+	}
+	return strings.Join(parts, ",")
+}
+
+// Run sends queued requests until the context is done.
+func (w *Writer) Run(ctx context.Context) {
+	for {
+		select {
+		case req := <-w.queue:
+			w.sendWithRetries(ctx, req)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendWithRetries sends the request, retrying recoverable errors with
+// exponential backoff.
+func (w *Writer) sendWithRetries(ctx context.Context, req *WriteRequest) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		klog.Errorf("unable to encode remote write request: %v", err)
+		samplesTotal.WithLabelValues("failed").Add(float64(len(req.Timeseries)))
+		return
+	}
+	body := snappyEncode(data)
+	backoff := w.backoff
+	for {
+		err = w.send(ctx, body)
+		if err == nil {
+			samplesTotal.WithLabelValues("sent").Add(float64(len(req.Timeseries)))
+			return
+		}
+		if _, recoverable := err.(recoverableError); !recoverable || backoff.Steps <= 1 {
+			break
+		}
+		klog.V(2).Infof("retrying remote write: %v", err)
+		select {
+		case <-time.After(backoff.Step()):
+		case <-ctx.Done():
+			return
+		}
+	}
+	klog.Errorf("unable to remote write %d samples: %v", len(req.Timeseries), err)
+	samplesTotal.WithLabelValues("failed").Add(float64(len(req.Timeseries)))
+}
+
+// recoverableError is an error which may not happen when retrying.
+type recoverableError struct {
+	error
+}
+
+func (w *Writer) send(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "metrics-server")
+	if err := w.authenticate(req); err != nil {
+		return err
+	}
+
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// authenticate sets the credentials of the request, reading them from their
+// files every time, so they can be rotated.
+func (w *Writer) authenticate(req *http.Request) error {
+	if w.config.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(w.config.BearerTokenFile)
+		if err != nil {
+			return fmt.Errorf("unable to read bearer token file: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	if w.config.Username != "" {
+		password, err := ioutil.ReadFile(w.config.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("unable to read password file: %v", err)
+		}
+		req.SetBasicAuth(w.config.Username, strings.TrimSpace(string(password)))
+	}
+	return nil
+}
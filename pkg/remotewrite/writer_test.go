@@ -0,0 +1,315 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+// snappyDecode decodes snappy blocks made of literals only, like those
+// written by snappyEncode.
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid length")
+	}
+	src = src[n:]
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		if tag&3 != 0 {
+			return nil, fmt.Errorf("unexpected copy element")
+		}
+		literal := int(tag >> 2)
+		src = src[1:]
+		switch literal {
+		case 60:
+			literal, src = int(src[0]), src[1:]
+		case 61:
+			literal, src = int(src[0])|int(src[1])<<8, src[2:]
+		}
+		literal++
+		if literal > len(src) {
+			return nil, fmt.Errorf("literal exceeds input")
+		}
+		dst = append(dst, src[:literal]...)
+		src = src[literal:]
+	}
+	if uint64(len(dst)) != length {
+		return nil, fmt.Errorf("decoded %d bytes, expected %d", len(dst), length)
+	}
+	return dst, nil
+}
+
+func TestSnappyEncode(t *testing.T) {
+	for _, size := range []int{0, 1, 60, 61, 256, 257, maxSnappyLiteral, maxSnappyLiteral + 1, 3*maxSnappyLiteral + 7} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		got, err := snappyDecode(snappyEncode(data))
+		if err != nil {
+			t.Fatalf("Unable to decode %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Decoded %d bytes differently", size)
+		}
+	}
+}
+
+// receiver is a stub remote write endpoint recording the requests it got.
+type receiver struct {
+	mu       sync.Mutex
+	requests []*WriteRequest
+	headers  []http.Header
+	// statuses are returned for the first requests, later requests succeed
+	statuses []int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.headers = append(r.headers, req.Header)
+	if len(r.statuses) > 0 {
+		status := r.statuses[0]
+		r.statuses = r.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	data, err := snappyDecode(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeReq := &WriteRequest{}
+	if err := proto.Unmarshal(data, writeReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, writeReq)
+}
+
+func newTestWriter(t *testing.T, r *receiver, config Config) *Writer {
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	config.URL = server.URL
+	config.Timeout = time.Second
+	w := NewWriter(config)
+	w.backoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+	return w
+}
+
+// flush sends all queued requests.
+func flush(w *Writer) {
+	for {
+		select {
+		case req := <-w.queue:
+			w.sendWithRetries(context.Background(), req)
+		default:
+			return
+		}
+	}
+}
+
+func newPoint(ts time.Time, nanoCores, bytes int64) storage.MetricsPoint {
+	return storage.MetricsPoint{
+		Timestamp:   ts,
+		CpuUsage:    *resource.NewScaledQuantity(nanoCores, -9),
+		MemoryUsage: *resource.NewQuantity(bytes, resource.BinarySI),
+	}
+}
+
+func TestWriter_EncodesBatch(t *testing.T) {
+	r := &receiver{}
+	w := newTestWriter(t, r, Config{})
+	ts := time.Unix(1600000000, 500*int64(time.Millisecond))
+
+	w.Enqueue(&storage.MetricsBatch{
+		Nodes: []storage.NodeMetricsPoint{{Name: "node1", MetricsPoint: newPoint(ts, 1500000000, 2048)}},
+		Pods: []storage.PodMetricsPoint{{Name: "pod1", Namespace: "ns1", Containers: []storage.ContainerMetricsPoint{
+			{Name: "container1", MetricsPoint: newPoint(ts, 250000, 1024)},
+		}}},
+	})
+	flush(w)
+
+	if len(r.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(r.requests))
+	}
+	header := r.headers[0]
+	if header.Get("Content-Encoding") != "snappy" || header.Get("Content-Type") != "application/x-protobuf" || header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		t.Errorf("Unexpected remote write headers: %v", header)
+	}
+	got := map[string]Sample{}
+	for _, series := range r.requests[0].Timeseries {
+		var key string
+		for i, l := range series.Labels {
+			if i > 0 && series.Labels[i-1].Name >= l.Name {
+				t.Errorf("Labels are not sorted: %v", series.Labels)
+			}
+			key += l.Name + "=" + l.Value + " "
+		}
+		got[key] = *series.Samples[0]
+	}
+	millis := ts.UnixNano() / int64(time.Millisecond)
+	expect := map[string]Sample{
+		"__name__=metrics_server_node_cpu_usage_cores node=node1 ":                                                {Value: 1.5, Timestamp: millis},
+		"__name__=metrics_server_node_memory_working_set_bytes node=node1 ":                                       {Value: 2048, Timestamp: millis},
+		"__name__=metrics_server_container_cpu_usage_cores container=container1 namespace=ns1 pod=pod1 ":          {Value: 0.00025, Timestamp: millis},
+		"__name__=metrics_server_container_memory_working_set_bytes container=container1 namespace=ns1 pod=pod1 ": {Value: 1024, Timestamp: millis},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("Got unexpected samples: %v, expected: %v", got, expect)
+	}
+}
+
+func TestWriter_BatchesSeries(t *testing.T) {
+	r := &receiver{}
+	w := newTestWriter(t, r, Config{})
+	batch := &storage.MetricsBatch{}
+	for i := 0; i < maxSeriesPerRequest; i++ {
+		batch.Nodes = append(batch.Nodes, storage.NodeMetricsPoint{Name: fmt.Sprintf("node%d", i), MetricsPoint: newPoint(time.Now(), 1, 1)})
+	}
+
+	w.Enqueue(batch)
+	flush(w)
+
+	if len(r.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(r.requests))
+	}
+	for i, req := range r.requests {
+		if len(req.Timeseries) != maxSeriesPerRequest {
+			t.Errorf("Expected request %d to have %d series, got %d", i, maxSeriesPerRequest, len(req.Timeseries))
+		}
+	}
+}
+
+func TestWriter_SkipsPointsAlreadySent(t *testing.T) {
+	r := &receiver{}
+	w := newTestWriter(t, r, Config{})
+	ts := time.Now()
+	batch := func(node2 time.Time) *storage.MetricsBatch {
+		return &storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{
+			{Name: "node1", MetricsPoint: newPoint(ts, 1, 1)},
+			{Name: "node2", MetricsPoint: newPoint(node2, 1, 1)},
+		}}
+	}
+
+	w.Enqueue(batch(ts))
+	w.Enqueue(batch(ts.Add(time.Minute)))
+	flush(w)
+
+	if len(r.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(r.requests))
+	}
+	if len(r.requests[1].Timeseries) != 2 || r.requests[1].Timeseries[0].Labels[1].Value != "node2" {
+		t.Errorf("Expected only new samples of node2 in second request, got: %v", r.requests[1])
+	}
+}
+
+func TestWriter_RetriesRecoverableErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		statuses     []int
+		expectSent   bool
+		expectTotals int
+	}{
+		{name: "server errors are retried", statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests}, expectSent: true, expectTotals: 3},
+		{name: "retries are bounded", statuses: []int{500, 500, 500, 500}, expectTotals: 3},
+		{name: "client errors aren't retried", statuses: []int{http.StatusBadRequest}, expectTotals: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &receiver{statuses: tc.statuses}
+			w := newTestWriter(t, r, Config{})
+
+			w.Enqueue(&storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: "node1", MetricsPoint: newPoint(time.Now(), 1, 1)}}})
+			flush(w)
+
+			if len(r.headers) != tc.expectTotals {
+				t.Errorf("Expected %d attempts, got %d", tc.expectTotals, len(r.headers))
+			}
+			if sent := len(r.requests) == 1; sent != tc.expectSent {
+				t.Errorf("Expected sent to be %v, got %d requests", tc.expectSent, len(r.requests))
+			}
+		})
+	}
+}
+
+func TestWriter_DropsWhenQueueIsFull(t *testing.T) {
+	r := &receiver{}
+	w := newTestWriter(t, r, Config{})
+	ts := time.Now()
+	for i := 0; i < queueCapacity+5; i++ {
+		w.Enqueue(&storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: "node1", MetricsPoint: newPoint(ts.Add(time.Duration(i)*time.Second), 1, 1)}}})
+	}
+	if len(w.queue) != queueCapacity {
+		t.Errorf("Expected %d queued requests, got %d", queueCapacity, len(w.queue))
+	}
+}
+
+func TestWriter_Authentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remotewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	passwordFile := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(passwordFile, []byte("secret-password"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		config Config
+		expect string
+	}{
+		{name: "bearer token", config: Config{BearerTokenFile: tokenFile}, expect: "Bearer secret-token"},
+		{name: "basic auth", config: Config{Username: "user", PasswordFile: passwordFile}, expect: "Basic dXNlcjpzZWNyZXQtcGFzc3dvcmQ="},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &receiver{}
+			w := newTestWriter(t, r, tc.config)
+
+			w.Enqueue(&storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: "node1", MetricsPoint: newPoint(time.Now(), 1, 1)}}})
+			flush(w)
+
+			if len(r.headers) != 1 || r.headers[0].Get("Authorization") != tc.expect {
+				t.Errorf("Expected Authorization %q, got: %v", tc.expect, r.headers)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// NodeAllocatable is the allocatable of a node the latest scrape checked
+// usage against.
+type NodeAllocatable struct {
+	Node string
+	// Allocatable holds the CPU and memory allocatable of the node, as
+	// listed at the time of the scrape.
+	Allocatable corev1.ResourceList
+	// Time is the time of the scrape.
+	Time time.Time
+}
+
+// recordAllocatable tracks the allocatable the scrape of the node checks
+// usage against.
+func (n *nodeState) recordAllocatable(node *corev1.Node, now time.Time) {
+	allocatable := corev1.ResourceList{}
+	for _, resource := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		if quantity, found := node.Status.Allocatable[resource]; found {
+			allocatable[resource] = quantity.DeepCopy()
+		}
+	}
+	n.allocatable = &NodeAllocatable{Node: node.Name, Allocatable: allocatable, Time: now}
+}
+
+// allocatables returns the tracked allocatables sorted by node name.
+func (s *nodeStates) allocatables() []NodeAllocatable {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	allocatables := make([]NodeAllocatable, 0, len(s.nodes))
+	for _, state := range s.nodes {
+		if state.allocatable != nil {
+			allocatables = append(allocatables, *state.allocatable)
+		}
+	}
+	sort.Slice(allocatables, func(i, j int) bool { return allocatables[i].Node < allocatables[j].Node })
+	return allocatables
+}
+
+// NodeAllocatables returns the allocatable the latest scrape of each node
+// checked usage against, sorted by name. It's empty unless usage is checked
+// against allocatable.
+func (c *scraper) NodeAllocatables() []NodeAllocatable {
+	return c.nodes.allocatables()
+}
@@ -0,0 +1,53 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// basicAuth authenticates summary requests with HTTP basic auth, e.g. for
+// proxies in front of Kubelets. The password is read from its file for every
+// request, so that it can be rotated, and is never logged. A nil basicAuth
+// leaves requests untouched.
+type basicAuth struct {
+	user         string
+	passwordFile string
+}
+
+func newBasicAuth(user, passwordFile string) *basicAuth {
+	if user == "" {
+		return nil
+	}
+	return &basicAuth{user: user, passwordFile: passwordFile}
+}
+
+// set sets the Authorization header of the request. It takes precedence over
+// the bearer token of the client configuration, which is only set on requests
+// without an Authorization header, while client certificates are still used.
+func (a *basicAuth) set(req *http.Request) error {
+	if a == nil {
+		return nil
+	}
+	password, err := ioutil.ReadFile(a.passwordFile)
+	if err != nil {
+		return fmt.Errorf("unable to read Kubelet basic auth password file: %v", err)
+	}
+	req.SetBasicAuth(a.user, strings.TrimSpace(string(password)))
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// caDirReloadInterval is how long the certificates read from a CA directory
+// are used before it's read again.
+const caDirReloadInterval = time.Minute
+
+// LoadCADir concatenates the PEM certificates of all files in a directory.
+// Files without certificates, hidden files and subdirectories are skipped,
+// e.g. the internal entries of mounted ConfigMaps. Directories without any
+// certificate are rejected.
+func LoadCADir(dir string) ([]byte, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bundle bytes.Buffer
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		// follow symlinks, as used by mounted ConfigMaps and Secrets
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		certs := 0
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+				klog.V(2).InfoS("Skipping invalid certificate in CA directory", "path", path, "err", err)
+				continue
+			}
+			if err := pem.Encode(&bundle, block); err != nil {
+				return nil, err
+			}
+			certs++
+		}
+		if certs == 0 {
+			klog.V(2).InfoS("Skipping file without certificates in CA directory", "path", path)
+		}
+	}
+	if bundle.Len() == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", dir)
+	}
+	return bundle.Bytes(), nil
+}
+
+// caDir holds the certificates of a CA directory, read again at most every
+// caDirReloadInterval when they are used. Failed reads keep the previous
+// certificates. If grace is positive, the certificates replaced by a reload
+// are still trusted for that long, so that Kubelets still serving certificates
+// of the previous CA keep being scraped while the rotation rolls out.
+type caDir struct {
+	path  string
+	grace time.Duration
+
+	mu            sync.Mutex
+	bundle        []byte
+	previous      []byte
+	previousUntil time.Time
+	generation    int
+	readTime      time.Time
+}
+
+func newCADir(path string, grace time.Duration) (*caDir, error) {
+	bundle, err := LoadCADir(path)
+	if err != nil {
+		return nil, err
+	}
+	return &caDir{path: path, grace: grace, bundle: bundle, readTime: myClock.Now()}, nil
+}
+
+// current returns the certificates and a generation changing with them.
+func (d *caDir) current() ([]byte, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if myClock.Since(d.readTime) >= caDirReloadInterval {
+		d.readTime = myClock.Now()
+		bundle, err := LoadCADir(d.path)
+		switch {
+		case err != nil:
+			klog.ErrorS(err, "Failed to reload Kubelet CA directory, keeping previous certificates", "path", d.path)
+		case !bytes.Equal(bundle, d.bundle):
+			klog.InfoS("Reloaded Kubelet CA directory", "path", d.path)
+			if d.grace > 0 {
+				d.previous, d.previousUntil = d.bundle, myClock.Now().Add(d.grace)
+				klog.InfoS("Trusting previous Kubelet CA certificates during rotation grace", "path", d.path, "until", d.previousUntil)
+			}
+			d.bundle = bundle
+			d.generation++
+		}
+	}
+	if d.previous != nil && !myClock.Now().Before(d.previousUntil) {
+		klog.InfoS("Kubelet CA rotation grace ended, trusting only current certificates", "path", d.path)
+		d.previous = nil
+		d.generation++
+	}
+	if d.previous != nil {
+		bundle := make([]byte, 0, len(d.bundle)+len(d.previous))
+		return append(append(bundle, d.bundle...), d.previous...), d.generation
+	}
+	return d.bundle, d.generation
+}
+
+// caReloadingTransport rebuilds its transport with the current certificates
+// of a CA directory whenever they change.
+type caReloadingTransport struct {
+	dir   *caDir
+	build func(caData []byte) (http.RoundTripper, error)
+
+	mu         sync.Mutex
+	transport  http.RoundTripper
+	generation int
+}
+
+func newCAReloadingTransport(dir *caDir, build func(caData []byte) (http.RoundTripper, error)) (*caReloadingTransport, error) {
+	bundle, generation := dir.current()
+	transport, err := build(bundle)
+	if err != nil {
+		return nil, err
+	}
+	return &caReloadingTransport{dir: dir, build: build, transport: transport, generation: generation}, nil
+}
+
+func (t *caReloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	bundle, generation := t.dir.current()
+	t.mu.Lock()
+	if generation != t.generation {
+		transport, err := t.build(bundle)
+		if err != nil {
+			t.mu.Unlock()
+			return nil, fmt.Errorf("unable to construct transport with reloaded CA certificates: %v", err)
+		}
+		t.transport, t.generation = transport, generation
+	}
+	transport := t.transport
+	t.mu.Unlock()
+	return transport.RoundTrip(req)
+}
+
+// caDirTransport returns a transport for the given config which trusts the
+// certificates of the CA directory, following their changes.
+func caDirTransport(dir *caDir, config rest.Config) (http.RoundTripper, error) {
+	transport, err := newCAReloadingTransport(dir, func(caData []byte) (http.RoundTripper, error) {
+		config := config
+		config.TLSClientConfig.CAData = caData
+		config.TLSClientConfig.CAFile = ""
+		return rest.TransportFor(&config)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
@@ -15,26 +15,33 @@
 package scraper
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mailru/easyjson"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/metrics-server/pkg/utils"
 )
 
 // KubeletInterface knows how to fetch metrics from the Kubelet
 type KubeletInterface interface {
-	// GetSummary fetches summary metrics from the given Kubelet
+	// GetSummary fetches summary metrics from the given Kubelet. The summary
+	// is nil if the Kubelet responded successfully without any metrics.
 	GetSummary(ctx context.Context, node *corev1.Node) (*Summary, error)
 }
 
@@ -45,8 +52,48 @@ type kubeletClient struct {
 	scheme            string
 	addrResolver      utils.NodeAddressResolver
 	buffers           sync.Pool
+	// apiServerURL is set when Kubelets are scraped through the API server node proxy.
+	apiServerURL *url.URL
+	// payloads, if set, keeps the latest raw response of each node.
+	payloads *PayloadCache
+	// dedupAddresses makes nodes sharing a scrape target fall back to their next address.
+	dedupAddresses bool
+	// requestTimeout, if positive, bounds each summary request.
+	requestTimeout time.Duration
+	// targetOverrides change the scheme and port of matching nodes, the first match applies.
+	targetOverrides []ScrapeTargetOverride
+	// nodeNameClients, if set, provides clients verifying serving certificates against the node name.
+	nodeNameClients *nodeNameClients
+	// successCodes are the response status codes accepted besides 200 OK.
+	successCodes map[int]bool
+	// summaryPath is the path of the summary API on Kubelets.
+	summaryPath string
+	// allowPartial keeps the complete part of truncated summaries.
+	allowPartial bool
+	// sniffGzip decompresses gzip bodies sent without Content-Encoding.
+	sniffGzip bool
+	// tryAllAddresses falls back to the next address of nodes failing to connect.
+	tryAllAddresses bool
+	// fallbackOn are the classes of errors falling back to the next address,
+	// connection errors if empty.
+	fallbackOn []AddressFallbackClass
+	// resources selects the series parsed from summaries.
+	resources Resources
+	// parseTimeout, if positive, bounds parsing each summary.
+	parseTimeout time.Duration
+	// extraHeaders are set on every summary request.
+	extraHeaders []RequestHeader
+	// basicAuth, if set, authenticates summary requests with basic auth.
+	basicAuth *basicAuth
+	// streams, if set, bounds the concurrent requests to each Kubelet.
+	streams *streamLimiter
+	// secondary, if set, is scraped on each node after its Kubelet.
+	secondary *secondaryTarget
 }
 
+// defaultSummaryPath is the path of the summary API on Kubelets.
+const defaultSummaryPath = "/stats/summary"
+
 var _ KubeletInterface = (*kubeletClient)(nil)
 
 type ErrNotFound struct {
@@ -57,61 +104,221 @@ func (err *ErrNotFound) Error() string {
 	return fmt.Sprintf("%q not found", err.endpoint)
 }
 
-func (kc *kubeletClient) makeRequestAndGetValue(client *http.Client, req *http.Request, value easyjson.Unmarshaler) error {
-	// TODO(directxman12): support validating certs by hostname
+// gzipMagic starts every gzip stream. JSON can't start with these bytes, as
+// 0x1f is a control character, so plain summaries are never mistaken for gzip.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// sniffGzip returns a reader of the full body, and whether the body starts
+// with the gzip magic bytes.
+func sniffGzip(body io.Reader) (io.Reader, bool) {
+	buffered := bufio.NewReader(body)
+	head, _ := buffered.Peek(len(gzipMagic))
+	return buffered, bytes.Equal(head, gzipMagic)
+}
+
+// errNoContent is returned for responses with an accepted status and no body.
+var errNoContent = fmt.Errorf("no content")
+
+func (kc *kubeletClient) makeRequestAndGetValue(client *http.Client, req *http.Request, nodeName string, value easyjson.Unmarshaler, payloads *PayloadCache) error {
+	// Request compression explicitly instead of relying on the transport,
+	// so that we can count bytes as received on the wire.
+	req.Header.Set("Accept-Encoding", "gzip")
 	response, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
+	wire := &countingReader{reader: response.Body}
+	defer func() {
+		responseBytes.WithLabelValues(nodeName).Add(float64(wire.count))
+	}()
+	var reader io.Reader = wire
+	compressed := response.Header.Get("Content-Encoding") == "gzip"
+	if !compressed && kc.sniffGzip {
+		reader, compressed = sniffGzip(wire)
+	}
+	if compressed {
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("failed to decompress output. Error: %v", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
 	b := kc.getBuffer()
 	defer kc.returnBuffer(b)
-	_, err = io.Copy(b, response.Body)
-	if err != nil {
-		return err
+	_, readErr := io.Copy(b, reader)
+	if readErr != nil && !kc.allowPartial {
+		return readErr
 	}
 	body := b.Bytes()
+	if readErr != nil && response.StatusCode != http.StatusOK {
+		return readErr
+	}
 	if response.StatusCode == http.StatusNotFound {
 		return &ErrNotFound{req.URL.String()}
 	} else if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("request failed - %q.", response.Status)
+		if !kc.successCodes[response.StatusCode] {
+			return fmt.Errorf("request failed - %q.", response.Status)
+		}
+		if len(body) == 0 {
+			return errNoContent
+		}
+	}
+	// cache before parsing, so that payloads failing to parse can be inspected
+	if payloads != nil {
+		payloads.Set(nodeName, body)
 	}
 
 	err = easyjson.Unmarshal(body, value)
+	if err == errParseTimeout {
+		return fmt.Errorf("failed to parse output within %v", kc.parseTimeout)
+	}
 	if err != nil {
+		// the body is cut short if reading failed, or if the Kubelet truncated it
+		if summary := summaryOf(value); summary != nil && kc.allowPartial && decodeSummaryPrefix(body, summary) {
+			return errPartialSummary
+		}
+		if readErr != nil {
+			return readErr
+		}
 		return fmt.Errorf("failed to parse output. Error: %v", err)
 	}
 	return nil
 }
 
+// summaryOf returns the summary parsed by value, or nil if value doesn't
+// parse summaries.
+func summaryOf(value easyjson.Unmarshaler) *Summary {
+	switch v := value.(type) {
+	case *Summary:
+		return v
+	case *filteredSummary:
+		return v.summary
+	}
+	return nil
+}
+
 func (kc *kubeletClient) GetSummary(ctx context.Context, node *corev1.Node) (*Summary, error) {
-	port := kc.defaultPort
-	nodeStatusPort := int(node.Status.DaemonEndpoints.KubeletEndpoint.Port)
-	if kc.useNodeStatusPort && nodeStatusPort != 0 {
-		port = nodeStatusPort
+	var summary *Summary
+	var err error
+	if kc.tryAllAddresses && kc.apiServerURL == nil {
+		summary, err = kc.getSummaryFromAnyAddress(ctx, node)
+	} else {
+		summary, err = kc.getSummary(ctx, node)
 	}
-	addr, err := kc.addrResolver.NodeAddress(node)
+	if err != nil || summary == nil || kc.secondary == nil {
+		return summary, err
+	}
+	return kc.withSecondary(ctx, node, summary), nil
+}
+
+func (kc *kubeletClient) getSummary(ctx context.Context, node *corev1.Node) (*Summary, error) {
+	url, err := kc.summaryURL(node)
 	if err != nil {
-		return nil, fmt.Errorf("unable to extract connection information for node %q: %v", node.Name, err)
+		return nil, err
 	}
-	url := url.URL{
-		Scheme:   kc.scheme,
-		Host:     net.JoinHostPort(addr, strconv.Itoa(port)),
-		Path:     "/stats/summary",
-		RawQuery: "only_cpu_and_memory=true",
+	summary := &Summary{}
+	var value easyjson.Unmarshaler = summary
+	if kc.resources != (Resources{}) || kc.parseTimeout > 0 {
+		value = &filteredSummary{summary: summary, resources: kc.resources, parseTimeout: kc.parseTimeout}
+	}
+	err = kc.fetch(ctx, node, url, value, kc.payloads)
+	if err == errNoContent {
+		return nil, nil
 	}
+	if err == errPartialSummary {
+		klog.Warningf("Summary of node %s was truncated, keeping the %d pods preceding the truncation", node.Name, len(summary.Pods))
+		partialSummaries.WithLabelValues(node.Name).Inc()
+		return summary, nil
+	}
+	return summary, err
+}
 
+// fetch requests the URL from the node and parses the response into value,
+// keeping the raw response in payloads if set. The request is bounded by the
+// request timeout and the streams of the node.
+func (kc *kubeletClient) fetch(ctx context.Context, node *corev1.Node, url *url.URL, value easyjson.Unmarshaler, payloads *PayloadCache) error {
 	req, err := http.NewRequest("GET", url.String(), nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := setRequestHeaders(req, kc.extraHeaders, node); err != nil {
+		return err
+	}
+	if err := kc.basicAuth.set(req); err != nil {
+		return err
+	}
+	if kc.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, kc.requestTimeout)
+		defer cancel()
 	}
-	summary := &Summary{}
 	client := kc.client
+	if kc.nodeNameClients != nil {
+		client, err = kc.nodeNameClients.get(node.Name)
+		if err != nil {
+			return err
+		}
+	}
 	if client == nil {
 		client = http.DefaultClient
 	}
-	err = kc.makeRequestAndGetValue(client, req.WithContext(ctx), summary)
-	return summary, err
+	release, err := kc.streams.acquire(ctx, url.Host)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return kc.makeRequestAndGetValue(client, req.WithContext(ctx), node.Name, value, payloads)
+}
+
+// summaryURL builds the URL of the summary API for the given node, either on the
+// Kubelet itself or through the API server node proxy.
+func (kc *kubeletClient) summaryURL(node *corev1.Node) (*url.URL, error) {
+	if kc.apiServerURL != nil {
+		u := *kc.apiServerURL
+		u.Path = path.Join(u.Path, "/api/v1/nodes", node.Name, "proxy", kc.summaryPath)
+		u.RawQuery = "only_cpu_and_memory=true"
+		return &u, nil
+	}
+	port := kc.defaultPort
+	nodeStatusPort := int(node.Status.DaemonEndpoints.KubeletEndpoint.Port)
+	if kc.useNodeStatusPort && nodeStatusPort != 0 {
+		port = nodeStatusPort
+	}
+	scheme, port := kc.schemeAndPort(node, kc.scheme, port)
+	addr, err := kc.addrResolver.NodeAddress(node)
+	if err != nil {
+		return nil, fmt.Errorf("unable to extract connection information for node %q: %v", node.Name, err)
+	}
+	return &url.URL{
+		Scheme:   scheme,
+		Host:     joinHostPort(addr, port),
+		Path:     kc.summaryPath,
+		RawQuery: "only_cpu_and_memory=true",
+	}, nil
+}
+
+// joinHostPort joins the address and port of a Kubelet, bracketing IPv6
+// literals. Addresses already bracketed, e.g. from a node address file, are
+// not bracketed twice.
+func joinHostPort(addr string, port int) string {
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		addr = addr[1 : len(addr)-1]
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(port))
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
 }
 
 func (kc *kubeletClient) getBuffer() *bytes.Buffer {
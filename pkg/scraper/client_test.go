@@ -0,0 +1,1342 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mailru/easyjson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/metrics/testutil"
+
+	"sigs.k8s.io/metrics-server/pkg/utils"
+)
+
+var _ = Describe("Kubelet client", func() {
+	var (
+		handler http.HandlerFunc
+		server  *httptest.Server
+		node    *corev1.Node
+	)
+	BeforeEach(func() {
+		responseBytes.Create(nil)
+		responseBytes.Reset()
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r)
+		}))
+		node = makeNode("node1", "", "127.0.0.1", true)
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newClient := func() *kubeletClient {
+		_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		portNumber, err := strconv.Atoi(port)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := KubeletClientConfig{
+			Scheme:              "http",
+			DefaultPort:         portNumber,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	It("should record the targets of the latest scrape of each node", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(summary))
+		}
+		client := newClient()
+		client.addrResolver = utils.NewPriorityNodeAddressResolver([]corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeExternalIP})
+		// nothing listens on port 1, so scraping the control plane fails
+		client.targetOverrides = []ScrapeTargetOverride{{Selector: labels.SelectorFromSet(labels.Set{ControlPlaneRoleLabel: ""}), Scheme: "https", Port: 1}}
+		controlPlane := makeNode("control-plane", "", "", true)
+		controlPlane.Labels = map[string]string{ControlPlaneRoleLabel: ""}
+		controlPlane.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeExternalIP, Address: "127.0.0.1"}}
+		lister := &fakeNodeLister{nodes: []*corev1.Node{node, controlPlane}}
+		scraper := NewScraper(client, 3*time.Second, 0)
+		port := server.Listener.Addr().(*net.TCPAddr).Port
+
+		_, err := scrapeAll(context.Background(), scraper, lister)
+		Expect(err).To(HaveOccurred())
+		targets := scraper.ScrapeTargets()
+		Expect(targets).To(HaveLen(2))
+		Expect(targets[0].Node).To(Equal("control-plane"))
+		Expect(targets[0].AddressType).To(Equal(corev1.NodeExternalIP))
+		Expect([]interface{}{targets[0].Scheme, targets[0].Host, targets[0].Port}).To(Equal([]interface{}{"https", "127.0.0.1", 1}))
+		Expect(targets[0].Error).NotTo(BeEmpty())
+		Expect(targets[1].Node).To(Equal("node1"))
+		Expect(targets[1].AddressType).To(Equal(corev1.NodeInternalIP))
+		Expect([]interface{}{targets[1].Scheme, targets[1].Host, targets[1].Port}).To(Equal([]interface{}{"http", "127.0.0.1", port}))
+		Expect(targets[1].Error).To(BeEmpty())
+
+		By("keeping listed nodes which weren't due")
+		_, err = scraper.ScrapeNodes(context.Background(), []*corev1.Node{node}, lister.nodes)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(scraper.ScrapeTargets()).To(HaveLen(2))
+
+		By("forgetting nodes which are no longer listed")
+		lister.nodes = []*corev1.Node{node}
+		_, err = scrapeAll(context.Background(), scraper, lister)
+		Expect(err).NotTo(HaveOccurred())
+		targets = scraper.ScrapeTargets()
+		Expect(targets).To(HaveLen(1))
+		Expect(targets[0].Node).To(Equal("node1"))
+	})
+	It("should count response bytes per node", func() {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(summary))
+		}
+		_, err := newClient().GetSummary(context.Background(), node)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = testutil.CollectAndCompare(responseBytes, strings.NewReader(fmt.Sprintf(`
+		# HELP metrics_server_kubelet_response_bytes_total [ALPHA] Number of bytes received in responses from Kubelet API, as transferred on the wire
+		# TYPE metrics_server_kubelet_response_bytes_total counter
+		metrics_server_kubelet_response_bytes_total{node="node1"} %d
+		`, len(summary))), "metrics_server_kubelet_response_bytes_total")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should count compressed response bytes as received on the wire", func() {
+		var compressed bytes.Buffer
+		gzipWriter := gzip.NewWriter(&compressed)
+		_, err := gzipWriter.Write([]byte(summary))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gzipWriter.Close()).To(Succeed())
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Accept-Encoding")).To(Equal("gzip"))
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed.Bytes())
+		}
+
+		result, err := newClient().GetSummary(context.Background(), node)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Node.NodeName).To(Equal("e2e-v1.17.0-control-plane"))
+
+		err = testutil.CollectAndCompare(responseBytes, strings.NewReader(fmt.Sprintf(`
+		# HELP metrics_server_kubelet_response_bytes_total [ALPHA] Number of bytes received in responses from Kubelet API, as transferred on the wire
+		# TYPE metrics_server_kubelet_response_bytes_total counter
+		metrics_server_kubelet_response_bytes_total{node="node1"} %d
+		`, compressed.Len())), "metrics_server_kubelet_response_bytes_total")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("gzip sniffing", func() {
+		var compressed []byte
+		BeforeEach(func() {
+			var buf bytes.Buffer
+			gzipWriter := gzip.NewWriter(&buf)
+			_, err := gzipWriter.Write([]byte(summary))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gzipWriter.Close()).To(Succeed())
+			compressed = buf.Bytes()
+		})
+		sniffing := func() *kubeletClient {
+			client := newClient()
+			client.sniffGzip = true
+			return client
+		}
+
+		It("should fail to parse gzip without Content-Encoding by default", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write(compressed)
+			}
+			_, err := newClient().GetSummary(context.Background(), node)
+			Expect(err).To(HaveOccurred())
+		})
+		It("should decompress gzip without Content-Encoding when sniffing", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write(compressed)
+			}
+			result, err := sniffing().GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Node.NodeName).To(Equal("e2e-v1.17.0-control-plane"))
+		})
+		It("should parse plain responses as they are when sniffing", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(summary))
+			}
+			result, err := sniffing().GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Node.NodeName).To(Equal("e2e-v1.17.0-control-plane"))
+		})
+		It("should decompress gzip with Content-Encoding once when sniffing", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", "gzip")
+				w.Write(compressed)
+			}
+			result, err := sniffing().GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Node.NodeName).To(Equal("e2e-v1.17.0-control-plane"))
+		})
+		It("should not misfire on a body shorter than the magic bytes", func() {
+			reader, compressed := sniffGzip(strings.NewReader("{"))
+			Expect(compressed).To(BeFalse())
+			data, err := ioutil.ReadAll(reader)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("{"))
+		})
+	})
+
+	Describe("address fallback", func() {
+		var unreachableNode *corev1.Node
+		newFallbackClient := func(tryAll bool) *kubeletClient {
+			port := server.Listener.Addr().(*net.TCPAddr).Port
+			client, err := KubeletClientConfig{
+				Scheme:              "http",
+				DefaultPort:         port,
+				AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeExternalIP},
+				TryAllAddresses:     tryAll,
+			}.Complete()
+			Expect(err).NotTo(HaveOccurred())
+			return client
+		}
+		BeforeEach(func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(summary))
+			}
+			// the server only listens on 127.0.0.1, so connecting to 127.0.0.2 is refused
+			unreachableNode = makeNode("node1", "", "127.0.0.2", true)
+			unreachableNode.Status.Addresses = append(unreachableNode.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "127.0.0.1"})
+		})
+
+		It("should fail nodes whose preferred address is unreachable by default", func() {
+			_, err := newFallbackClient(false).GetSummary(context.Background(), unreachableNode)
+			Expect(err).To(MatchError(ContainSubstring("dial tcp")))
+		})
+		It("should scrape nodes on the next address when the preferred one is unreachable", func() {
+			got, err := newFallbackClient(true).GetSummary(context.Background(), unreachableNode)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).NotTo(BeNil())
+			Expect(unreachableNode.Status.Addresses).To(HaveLen(2))
+		})
+		It("should fail nodes once no address is left", func() {
+			unreachableNode.Status.Addresses[1].Address = "127.0.0.3"
+			_, err := newFallbackClient(true).GetSummary(context.Background(), unreachableNode)
+			Expect(err).To(MatchError(ContainSubstring("127.0.0.3")))
+		})
+		It("should not retry requests which reached the Kubelet", func() {
+			requests := 0
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			node.Status.Addresses = append(node.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "127.0.0.1"})
+			_, err := newFallbackClient(true).GetSummary(context.Background(), node)
+			Expect(err).To(HaveOccurred())
+			Expect(requests).To(Equal(1))
+		})
+	})
+
+	Describe("extra headers", func() {
+		It("should set static and per-node headers on summary requests", func() {
+			var received http.Header
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				received = r.Header
+				w.Write([]byte(summary))
+			}
+			node.Labels = map[string]string{"topology.kubernetes.io/zone": "zone-a"}
+			client := newClient()
+			client.extraHeaders, _ = ParseRequestHeaders(map[string]string{
+				"x-tenant-id": "team-a",
+				"X-Route":     "{{.Name}}",
+				"X-Zone":      `{{index .Labels "topology.kubernetes.io/zone"}}`,
+				"X-Missing":   `{{index .Labels "missing"}}`,
+			})
+
+			_, err := client.GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(received.Get("X-Tenant-Id")).To(Equal("team-a"))
+			Expect(received.Get("X-Route")).To(Equal("node1"))
+			Expect(received.Get("X-Zone")).To(Equal("zone-a"))
+			Expect(received).To(HaveKeyWithValue("X-Missing", []string{""}))
+		})
+		It("should reject invalid headers without including their values", func() {
+			_, err := ParseRequestHeaders(map[string]string{"X Secret": "hunter2"})
+			Expect(err).To(HaveOccurred())
+			_, err = ParseRequestHeaders(map[string]string{"X-Secret": "{{hunter2"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("hunter2"))
+		})
+		It("should fail requests whose rendered header contains a line break", func() {
+			node.Labels = map[string]string{"route": "a\r\nX-Injected: 1"}
+			client := newClient()
+			client.extraHeaders, _ = ParseRequestHeaders(map[string]string{"X-Route": `{{index .Labels "route"}}`})
+
+			_, err := client.GetSummary(context.Background(), node)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("Injected"))
+		})
+	})
+
+	Describe("basic auth", func() {
+		var passwordFile string
+		BeforeEach(func() {
+			file, err := ioutil.TempFile("", "kubelet-password")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = file.WriteString("hunter2\n")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(file.Close()).To(Succeed())
+			passwordFile = file.Name()
+		})
+		AfterEach(func() {
+			os.Remove(passwordFile)
+		})
+
+		It("should authenticate summary requests with the password read from its file", func() {
+			var user, password string
+			var ok bool
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				user, password, ok = r.BasicAuth()
+				w.Write([]byte(summary))
+			}
+			client := newClient()
+			client.basicAuth = newBasicAuth("metrics-server", passwordFile)
+
+			_, err := client.GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(user).To(Equal("metrics-server"))
+			Expect(password).To(Equal("hunter2"))
+
+			By("reading the rotated password on the next request")
+			Expect(ioutil.WriteFile(passwordFile, []byte("hunter3"), 0600)).To(Succeed())
+			_, err = client.GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal("hunter3"))
+		})
+		It("should take precedence over the bearer token of the client", func() {
+			var authorization string
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+				w.Write([]byte(summary))
+			}
+			client, err := KubeletClientConfig{
+				Scheme:                "http",
+				DefaultPort:           server.Listener.Addr().(*net.TCPAddr).Port,
+				AddressTypePriority:   []corev1.NodeAddressType{corev1.NodeInternalIP},
+				Client:                rest.Config{BearerToken: "token"},
+				BasicAuthUser:         "metrics-server",
+				BasicAuthPasswordFile: passwordFile,
+			}.Complete()
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(authorization).To(HavePrefix("Basic "))
+		})
+		It("should fail requests when the password file can't be read", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(summary))
+			}
+			client := newClient()
+			client.basicAuth = newBasicAuth("metrics-server", passwordFile+".missing")
+
+			_, err := client.GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("unable to read Kubelet basic auth password file")))
+		})
+	})
+
+	Describe("success status codes", func() {
+		respond := func(status int, body string) {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+				w.Write([]byte(body))
+			}
+		}
+		clientAccepting := func(codes ...int) *kubeletClient {
+			client := newClient()
+			client.successCodes = map[int]bool{}
+			for _, code := range codes {
+				client.successCodes[code] = true
+			}
+			return client
+		}
+
+		It("should accept 200 with metrics", func() {
+			respond(http.StatusOK, summary)
+			result, err := clientAccepting(http.StatusNoContent).GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Node.NodeName).To(Equal("e2e-v1.17.0-control-plane"))
+		})
+		It("should reject 204 by default", func() {
+			respond(http.StatusNoContent, "")
+			_, err := newClient().GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("204 No Content")))
+		})
+		It("should report no metrics for a configured 204", func() {
+			respond(http.StatusNoContent, "")
+			result, err := clientAccepting(http.StatusNoContent).GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result).To(BeNil())
+		})
+		It("should parse metrics of other configured codes", func() {
+			respond(http.StatusNonAuthoritativeInfo, summary)
+			result, err := clientAccepting(http.StatusNonAuthoritativeInfo).GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Node.NodeName).To(Equal("e2e-v1.17.0-control-plane"))
+		})
+		It("should reject codes which aren't configured", func() {
+			respond(http.StatusBadGateway, "")
+			_, err := clientAccepting(http.StatusNoContent).GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("502 Bad Gateway")))
+		})
+	})
+
+	Describe("truncated summaries", func() {
+		var payload string
+		BeforeEach(func() {
+			partialSummaries.Create(nil)
+			partialSummaries.Reset()
+			now := time.Now()
+			data, err := easyjson.Marshal(&Summary{
+				Node: nodeStats(node, 100, 200, now),
+				Pods: []PodStats{
+					podStats("ns1", "pod1", containerStats("container1", 300, 400, now)),
+					podStats("ns1", "pod2", containerStats("container1", 500, 600, now)),
+					podStats("ns1", "pod3", containerStats("container1", 700, 800, now)),
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			payload = string(data)
+		})
+		truncatedBefore := func(substr string) string {
+			return payload[:strings.Index(payload, substr)+3]
+		}
+		allowingPartial := func() *kubeletClient {
+			client := newClient()
+			client.allowPartial = true
+			return client
+		}
+
+		It("should keep the pods preceding the truncation", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(truncatedBefore(`"pod3"`)))
+			}
+			result, err := allowingPartial().GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Node.NodeName).To(Equal("node1"))
+			Expect(result.Pods).To(HaveLen(2))
+			Expect(result.Pods[1].PodRef.Name).To(Equal("pod2"))
+			Expect(result.Pods[1].Containers).To(HaveLen(1))
+
+			err = testutil.CollectAndCompare(partialSummaries, strings.NewReader(`
+		# HELP metrics_server_kubelet_partial_summaries_total [ALPHA] Number of truncated Kubelet summaries of which only the pods preceding the truncation were kept
+		# TYPE metrics_server_kubelet_partial_summaries_total counter
+		metrics_server_kubelet_partial_summaries_total{node="node1"} 1
+		`), "metrics_server_kubelet_partial_summaries_total")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should keep the pods received before the connection was lost", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", strconv.Itoa(len(payload)))
+				w.Write([]byte(truncatedBefore(`"pod2"`)))
+			}
+			result, err := allowingPartial().GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Pods).To(HaveLen(1))
+			Expect(result.Pods[0].PodRef.Name).To(Equal("pod1"))
+		})
+		It("should fail truncated summaries by default", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(truncatedBefore(`"pod3"`)))
+			}
+			_, err := newClient().GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("failed to parse output")))
+		})
+		It("should fail summaries truncated within the node stats", func() {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(truncatedBefore(`"nodeName"`)))
+			}
+			_, err := allowingPartial().GetSummary(context.Background(), node)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("redirects", func() {
+		var other *httptest.Server
+		BeforeEach(func() {
+			other = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(summary))
+			}))
+		})
+		AfterEach(func() {
+			other.Close()
+		})
+		following := func(follow FollowRedirects, maxRedirects int) *kubeletClient {
+			client := newClient()
+			client.client.CheckRedirect = checkRedirect(follow, maxRedirects)
+			return client
+		}
+		redirectTo := func(target string) {
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/gateway/stats/summary" {
+					w.Write([]byte(summary))
+					return
+				}
+				http.Redirect(w, r, target, http.StatusFound)
+			}
+		}
+
+		It("should follow redirects to the same host when allowed", func() {
+			redirectTo("/gateway/stats/summary")
+			result, err := following(FollowRedirectsSameHost, 10).GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Pods).NotTo(BeEmpty())
+		})
+		It("should refuse redirects to another host when following the same host", func() {
+			redirectTo(other.URL + "/stats/summary")
+			_, err := following(FollowRedirectsSameHost, 10).GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("refusing redirect from " + server.Listener.Addr().String() + " to another host")))
+		})
+		It("should follow redirects to another host when following any", func() {
+			redirectTo(other.URL + "/stats/summary")
+			_, err := following(FollowRedirectsAny, 10).GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should refuse every redirect when following none", func() {
+			redirectTo("/gateway/stats/summary")
+			_, err := following(FollowRedirectsNone, 10).GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("refusing redirect")))
+		})
+		It("should stop after the maximum number of redirects", func() {
+			redirectTo("/stats/summary")
+			_, err := following(FollowRedirectsSameHost, 3).GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("stopped after 3 redirects")))
+		})
+	})
+
+	Describe("parse timeout", func() {
+		var payload []byte
+		BeforeEach(func() {
+			now := time.Now()
+			pods := make([]PodStats, 0, 1000)
+			for i := 0; i < 1000; i++ {
+				pods = append(pods, podStats("ns1", fmt.Sprintf("pod%d", i), containerStats("container1", 300, 400, now)))
+			}
+			var err error
+			payload, err = easyjson.Marshal(&Summary{Node: nodeStats(node, 100, 200, now), Pods: pods})
+			Expect(err).NotTo(HaveOccurred())
+			handler = func(w http.ResponseWriter, r *http.Request) {
+				w.Write(payload)
+			}
+		})
+		withParseTimeout := func(timeout time.Duration) *kubeletClient {
+			client := newClient()
+			client.parseTimeout = timeout
+			return client
+		}
+
+		It("should parse summaries within the timeout", func() {
+			result, err := withParseTimeout(time.Minute).GetSummary(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Pods).To(HaveLen(1000))
+		})
+		It("should fail the node once parsing takes longer than the timeout", func() {
+			defer func(previous clock) { myClock = previous }(myClock)
+			start := time.Now()
+			// every check of the deadline sees a second spent parsing
+			myClock = mockClock{now: start, later: start.Add(time.Second)}
+
+			_, err := withParseTimeout(100*time.Millisecond).GetSummary(context.Background(), node)
+			Expect(err).To(MatchError(ContainSubstring("failed to parse output within 100ms")))
+		})
+	})
+
+	It("should cache the latest raw payload even if it fails to parse", func() {
+		client := newClient()
+		client.payloads = NewPayloadCache()
+
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(summary))
+		}
+		_, err := client.GetSummary(context.Background(), node)
+		Expect(err).NotTo(HaveOccurred())
+		payload, found := client.payloads.Get("node1")
+		Expect(found).To(BeTrue())
+		Expect(string(payload.Body)).To(Equal(summary))
+
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("{invalid"))
+		}
+		_, err = client.GetSummary(context.Background(), node)
+		Expect(err).To(HaveOccurred())
+		payload, found = client.payloads.Get("node1")
+		Expect(found).To(BeTrue())
+		Expect(string(payload.Body)).To(Equal("{invalid"))
+	})
+	It("should keep the raw payloads of listed nodes failing to parse after the cycle", func() {
+		client := newClient()
+		client.payloads = NewPayloadCache()
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("{invalid"))
+		}
+		scraper := NewScraper(client, 3*time.Second, 0)
+
+		_, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node}, []*corev1.Node{node})
+		Expect(err).To(HaveOccurred())
+		payload, found := client.payloads.Get("node1")
+		Expect(found).To(BeTrue())
+		Expect(string(payload.Body)).To(Equal("{invalid"))
+
+		By("forgetting nodes which aren't listed anymore")
+		_, err = scraper.ScrapeNodes(context.Background(), nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, found = client.payloads.Get("node1")
+		Expect(found).To(BeFalse())
+	})
+})
+
+var _ = Describe("Kubelet client timeouts", func() {
+	newClient := func(port int, dialTimeout, requestTimeout time.Duration) *kubeletClient {
+		client, err := KubeletClientConfig{
+			Scheme:              "http",
+			DefaultPort:         port,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			DialTimeout:         dialTimeout,
+			RequestTimeout:      requestTimeout,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	It("should bound requests to Kubelets which accept connections but stall", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		defer listener.Close()
+		go func() {
+			// accept connections, but never respond
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+
+		start := time.Now()
+		_, err = newClient(listener.Addr().(*net.TCPAddr).Port, 0, 100*time.Millisecond).GetSummary(context.Background(), makeNode("node1", "", "127.0.0.1", true))
+		Expect(err).To(MatchError(ContainSubstring("context deadline exceeded")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("should bound dialing unreachable Kubelets", func() {
+		// addresses from ranges which shouldn't be routed, the first one
+		// where connecting times out is used
+		var addr string
+		for _, candidate := range []string{"10.255.255.1", "100::1"} {
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(candidate, "10250"), 50*time.Millisecond)
+			if err == nil {
+				conn.Close()
+				continue
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				addr = candidate
+				break
+			}
+		}
+		if addr == "" {
+			Skip("no unroutable address in this environment")
+		}
+
+		start := time.Now()
+		_, err := newClient(10250, 100*time.Millisecond, 5*time.Second).GetSummary(context.Background(), makeNode("node1", "", addr, true))
+		// once probed, the address may also be reported unreachable right away
+		Expect(err).To(MatchError(ContainSubstring("dial tcp")))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	})
+})
+
+var _ = Describe("Kubelet client HTTP/2", func() {
+	var (
+		server   *httptest.Server
+		node     *corev1.Node
+		mu       sync.Mutex
+		inFlight int
+		peak     int
+		protos   []int
+	)
+	BeforeEach(func() {
+		inFlight, peak, protos = 0, 0, nil
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight++
+			if inFlight > peak {
+				peak = inFlight
+			}
+			protos = append(protos, r.ProtoMajor)
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			w.Write([]byte(summary))
+		}))
+		server.EnableHTTP2 = true
+		server.StartTLS()
+		node = makeNode("node1", "", "127.0.0.1", true)
+	})
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newClient := func(http2 bool, maxStreams int) *kubeletClient {
+		_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		portNumber, err := strconv.Atoi(port)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := KubeletClientConfig{
+			Scheme:               "https",
+			DefaultPort:          portNumber,
+			AddressTypePriority:  []corev1.NodeAddressType{corev1.NodeInternalIP},
+			Client:               rest.Config{TLSClientConfig: rest.TLSClientConfig{Insecure: true}},
+			HTTP2:                http2,
+			MaxStreamsPerKubelet: maxStreams,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+	scrapeConcurrently := func(client *kubeletClient, count int) {
+		var wg sync.WaitGroup
+		errs := make(chan error, count)
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := client.GetSummary(context.Background(), node)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+
+	It("should scrape over HTTP/1.1 by default", func() {
+		scrapeConcurrently(newClient(false, 0), 1)
+		Expect(protos).To(ConsistOf(1))
+	})
+	It("should scrape over HTTP/2 when enabled", func() {
+		scrapeConcurrently(newClient(true, 0), 3)
+		Expect(protos).To(ConsistOf(2, 2, 2))
+	})
+	It("should respect the stream limit", func() {
+		scrapeConcurrently(newClient(true, 2), 6)
+		Expect(protos).To(HaveLen(6))
+		Expect(peak).To(BeNumerically("<=", 2))
+	})
+	It("should fall back to HTTP/1.1 for Kubelets without HTTP/2", func() {
+		server.Close()
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			protos = append(protos, r.ProtoMajor)
+			mu.Unlock()
+			w.Write([]byte(summary))
+		}))
+		server.StartTLS()
+
+		scrapeConcurrently(newClient(true, 2), 1)
+		Expect(protos).To(ConsistOf(1))
+	})
+})
+
+var _ = Describe("Kubelet client serving cert SNI", func() {
+	var (
+		server *httptest.Server
+		caPEM  []byte
+	)
+	startServer := func(dnsNames []string, ips []net.IP) {
+		var cert tls.Certificate
+		cert, caPEM = selfSignedCert(dnsNames, ips)
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(summary))
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		server.StartTLS()
+	}
+	AfterEach(func() {
+		server.Close()
+	})
+
+	getSummary := func(sni ServingCertSNI, node *corev1.Node) error {
+		port := server.Listener.Addr().(*net.TCPAddr).Port
+		client, err := KubeletClientConfig{
+			Client:              rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: caPEM}},
+			Scheme:              "https",
+			DefaultPort:         port,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			ServingCertSNI:      sni,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.GetSummary(context.Background(), node)
+		return err
+	}
+
+	Context("with a cert whose SAN is the node address", func() {
+		BeforeEach(func() {
+			startServer(nil, []net.IP{net.ParseIP("127.0.0.1")})
+		})
+
+		It("should verify the cert against the address by default", func() {
+			Expect(getSummary("", makeNode("node1", "", "127.0.0.1", true))).To(Succeed())
+			Expect(getSummary(ServingCertSNIAddress, makeNode("node1", "", "127.0.0.1", true))).To(Succeed())
+		})
+		It("should reject the cert when verifying against the node name", func() {
+			err := getSummary(ServingCertSNINodeName, makeNode("node1", "", "127.0.0.1", true))
+			Expect(err).To(MatchError(And(ContainSubstring("x509"), ContainSubstring("node1"))))
+		})
+	})
+	Context("with a cert whose SAN is the node name", func() {
+		BeforeEach(func() {
+			startServer([]string{"node1.somedomain"}, nil)
+		})
+
+		It("should reject the cert when verifying against the address", func() {
+			err := getSummary(ServingCertSNIAddress, makeNode("node1.somedomain", "", "127.0.0.1", true))
+			Expect(err).To(MatchError(ContainSubstring("doesn't contain any IP SANs")))
+		})
+		It("should verify the cert against the node name", func() {
+			Expect(getSummary(ServingCertSNINodeName, makeNode("node1.somedomain", "", "127.0.0.1", true))).To(Succeed())
+			By("rejecting other nodes served with the same cert")
+			err := getSummary(ServingCertSNINodeName, makeNode("node2.somedomain", "", "127.0.0.1", true))
+			Expect(err).To(MatchError(ContainSubstring("not node2.somedomain")))
+		})
+	})
+})
+
+var _ = Describe("Kubelet client address fallback by error class", func() {
+	var (
+		server   *httptest.Server
+		caPEM    []byte
+		cert     tls.Certificate
+		port     int
+		requests int
+		closers  []func()
+	)
+	BeforeEach(func() {
+		requests = 0
+		// the cert is only valid for 127.0.0.1, so it fails verification when served on 127.0.0.2
+		cert, caPEM = selfSignedCert(nil, []net.IP{net.ParseIP("127.0.0.1")})
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Write([]byte(summary))
+		}))
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+		server.StartTLS()
+		closers = []func(){server.Close}
+		port = server.Listener.Addr().(*net.TCPAddr).Port
+	})
+	AfterEach(func() {
+		for _, close := range closers {
+			close()
+		}
+	})
+
+	// listenPreferred listens on the preferred address of the node, on the
+	// port of the server.
+	listenPreferred := func() net.Listener {
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", strconv.Itoa(port)))
+		Expect(err).NotTo(HaveOccurred())
+		closers = append(closers, func() { listener.Close() })
+		return listener
+	}
+	getSummary := func(classes ...AddressFallbackClass) error {
+		node := makeNode("node1", "", "127.0.0.2", true)
+		node.Status.Addresses = append(node.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: "127.0.0.1"})
+		client, err := KubeletClientConfig{
+			Client:              rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: caPEM}},
+			Scheme:              "https",
+			DefaultPort:         port,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeExternalIP},
+			TryAllAddresses:     true,
+			AddressFallbackOn:   classes,
+			RequestTimeout:      200 * time.Millisecond,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		_, err = client.GetSummary(context.Background(), node)
+		return err
+	}
+
+	Context("with a cert invalid for the preferred address", func() {
+		BeforeEach(func() {
+			preferred := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(summary))
+			}))
+			preferred.Listener.Close()
+			preferred.Listener = listenPreferred()
+			preferred.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			preferred.StartTLS()
+			closers = append(closers, preferred.Close)
+		})
+
+		It("should not fall back on connection errors only", func() {
+			err := getSummary(AddressFallbackConnection)
+			Expect(err).To(MatchError(ContainSubstring("x509")))
+			Expect(requests).To(Equal(0))
+		})
+		It("should not fall back by default", func() {
+			Expect(getSummary()).To(MatchError(ContainSubstring("x509")))
+			Expect(requests).To(Equal(0))
+		})
+		It("should fall back on TLS errors", func() {
+			Expect(getSummary(AddressFallbackTLS)).To(Succeed())
+			Expect(requests).To(Equal(1))
+		})
+	})
+	Context("with a preferred address timing out", func() {
+		BeforeEach(func() {
+			// accepts connections, but never completes the handshake
+			listenPreferred()
+		})
+
+		It("should not fall back on TLS errors only", func() {
+			Expect(getSummary(AddressFallbackTLS)).NotTo(Succeed())
+			Expect(requests).To(Equal(0))
+		})
+	})
+	Context("with a preferred address refusing connections", func() {
+		It("should fall back on connection errors", func() {
+			Expect(getSummary(AddressFallbackConnection, AddressFallbackTLS)).To(Succeed())
+			Expect(requests).To(Equal(1))
+		})
+		It("should not fall back on TLS errors only", func() {
+			Expect(getSummary(AddressFallbackTLS)).To(MatchError(ContainSubstring("connection refused")))
+			Expect(requests).To(Equal(0))
+		})
+	})
+})
+
+var _ = Describe("Kubelet client CA directory", func() {
+	var (
+		servers []*httptest.Server
+		caPEMs  [][]byte
+		dir     string
+	)
+	BeforeEach(func() {
+		servers, caPEMs = nil, nil
+		for i := 0; i < 2; i++ {
+			cert, caPEM := selfSignedCert(nil, []net.IP{net.ParseIP("127.0.0.1")})
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(summary))
+			}))
+			server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+			server.StartTLS()
+			servers = append(servers, server)
+			caPEMs = append(caPEMs, caPEM)
+		}
+		var err error
+		dir, err = ioutil.TempDir("", "kubelet-ca")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	AfterEach(func() {
+		for _, server := range servers {
+			server.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	writeFile := func(name string, data []byte) {
+		Expect(ioutil.WriteFile(filepath.Join(dir, name), data, 0600)).To(Succeed())
+	}
+	getSummary := func(client *kubeletClient, server *httptest.Server) error {
+		node := makeNode("node1", "", "127.0.0.1", true)
+		node.Status.DaemonEndpoints.KubeletEndpoint.Port = int32(server.Listener.Addr().(*net.TCPAddr).Port)
+		_, err := client.GetSummary(context.Background(), node)
+		return err
+	}
+	newClient := func(grace time.Duration) *kubeletClient {
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			UseNodeStatusPort:   true,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			CADir:               dir,
+			CARotationGrace:     grace,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	It("should trust the certificates of all PEM files in the directory", func() {
+		writeFile("old.pem", caPEMs[0])
+		writeFile("new.crt", caPEMs[1])
+		writeFile("README", []byte("CA certificates of Kubelets"))
+		Expect(os.Mkdir(filepath.Join(dir, "..data"), 0700)).To(Succeed())
+
+		client := newClient(0)
+		Expect(getSummary(client, servers[0])).To(Succeed())
+		Expect(getSummary(client, servers[1])).To(Succeed())
+	})
+	It("should pick up certificates added to the directory", func() {
+		defer func(previous clock) { myClock = previous }(myClock)
+		start := time.Now()
+		myClock = mockClock{now: start, later: start}
+		writeFile("old.pem", caPEMs[0])
+		client := newClient(0)
+		Expect(getSummary(client, servers[1])).To(MatchError(ContainSubstring("x509")))
+
+		writeFile("new.pem", caPEMs[1])
+		myClock = mockClock{now: start.Add(2 * caDirReloadInterval), later: start.Add(2 * caDirReloadInterval)}
+		Expect(getSummary(client, servers[0])).To(Succeed())
+		Expect(getSummary(client, servers[1])).To(Succeed())
+	})
+	It("should trust replaced certificates during the rotation grace", func() {
+		defer func(previous clock) { myClock = previous }(myClock)
+		start := time.Now()
+		myClock = mockClock{now: start, later: start}
+		writeFile("ca.pem", caPEMs[0])
+		client := newClient(10 * time.Minute)
+		Expect(getSummary(client, servers[0])).To(Succeed())
+
+		By("trusting certificates of either CA within the grace")
+		writeFile("ca.pem", caPEMs[1])
+		rotated := start.Add(2 * caDirReloadInterval)
+		myClock = mockClock{now: rotated, later: rotated}
+		Expect(getSummary(client, servers[1])).To(Succeed())
+		Expect(getSummary(client, servers[0])).To(Succeed())
+		during := rotated.Add(9 * time.Minute)
+		myClock = mockClock{now: during, later: during}
+		Expect(getSummary(client, servers[0])).To(Succeed())
+
+		By("trusting only the current CA after the grace")
+		after := rotated.Add(11 * time.Minute)
+		myClock = mockClock{now: after, later: after}
+		Expect(getSummary(client, servers[0])).To(MatchError(ContainSubstring("x509")))
+		Expect(getSummary(client, servers[1])).To(Succeed())
+	})
+	It("should reject directories without certificates", func() {
+		writeFile("README", []byte("CA certificates of Kubelets"))
+		_, err := LoadCADir(dir)
+		Expect(err).To(MatchError(ContainSubstring("no certificates")))
+	})
+})
+
+// selfSignedCert returns a serving certificate with the given SANs, and its
+// PEM encoding to be trusted as CA.
+func selfSignedCert(dnsNames []string, ips []net.IP) (tls.Certificate, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubelet"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              dnsNames,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+var _ = Describe("Payload cache", func() {
+	It("should only retain payloads of the given nodes", func() {
+		cache := NewPayloadCache()
+		cache.Set("node1", []byte("1"))
+		cache.Set("node2", []byte("2"))
+
+		cache.Retain([]string{"node2", "node3"})
+
+		_, found := cache.Get("node1")
+		Expect(found).To(BeFalse())
+		payload, found := cache.Get("node2")
+		Expect(found).To(BeTrue())
+		Expect(payload.Body).To(Equal([]byte("2")))
+	})
+	It("should copy stored payloads", func() {
+		cache := NewPayloadCache()
+		body := []byte("1")
+		cache.Set("node1", body)
+		body[0] = '2'
+
+		payload, _ := cache.Get("node1")
+		Expect(payload.Body).To(Equal([]byte("1")))
+	})
+})
+
+var _ = Describe("Kubelet client URL", func() {
+	It("should connect directly to the Kubelet by default", func() {
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10250,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		url, err := client.summaryURL(makeNode("node1", "", "10.0.1.2", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("https://10.0.1.2:10250/stats/summary?only_cpu_and_memory=true"))
+	})
+	It("should build the URL for IPv4, IPv6 and hostname addresses", func() {
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10250,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeHostName},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		for addr, expected := range map[string]string{
+			"10.0.1.2":              "https://10.0.1.2:10250/stats/summary?only_cpu_and_memory=true",
+			"fd00::1":               "https://[fd00::1]:10250/stats/summary?only_cpu_and_memory=true",
+			"[fd00::1]":             "https://[fd00::1]:10250/stats/summary?only_cpu_and_memory=true",
+			"::ffff:10.0.1.2":       "https://[::ffff:10.0.1.2]:10250/stats/summary?only_cpu_and_memory=true",
+			"fe80::1%eth0":          "https://[fe80::1%25eth0]:10250/stats/summary?only_cpu_and_memory=true",
+			"node1.example.com":     "https://node1.example.com:10250/stats/summary?only_cpu_and_memory=true",
+			"node1.example.com.":    "https://node1.example.com.:10250/stats/summary?only_cpu_and_memory=true",
+			"2001:db8::8a2e:370:73": "https://[2001:db8::8a2e:370:73]:10250/stats/summary?only_cpu_and_memory=true",
+		} {
+			url, err := client.summaryURL(makeNode("node1", "", addr, true))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(url.String()).To(Equal(expected), "address %s", addr)
+		}
+
+		url, err := client.summaryURL(makeNode("node1", "node1", "", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("https://node1:10250/stats/summary?only_cpu_and_memory=true"))
+	})
+	It("should keep the port of IPv6 addresses overridden for control plane nodes", func() {
+		controlPlaneSelector, err := labels.Parse(ControlPlaneRoleLabel)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := KubeletClientConfig{
+			Scheme:                "https",
+			DefaultPort:           10250,
+			AddressTypePriority:   []corev1.NodeAddressType{corev1.NodeInternalIP},
+			ScrapeTargetOverrides: []ScrapeTargetOverride{{Selector: controlPlaneSelector, Scheme: "http", Port: 10260}},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		controlPlane := makeNode("control-plane", "", "fd00::10", true)
+		controlPlane.Labels = map[string]string{ControlPlaneRoleLabel: ""}
+		url, err := client.summaryURL(controlPlane)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("http://[fd00::10]:10260/stats/summary?only_cpu_and_memory=true"))
+		Expect(url.Hostname()).To(Equal("fd00::10"))
+		Expect(url.Port()).To(Equal("10260"))
+	})
+	It("should use the address type priority mapped to the node labels", func() {
+		mappings, err := utils.ParseAddressTypeMappings([]byte(`{"mappings": [{"selector": "pool=edge", "addressTypes": ["Hostname"]}]}`))
+		Expect(err).NotTo(HaveOccurred())
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10250,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			AddressTypeMappings: mappings,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		mapped := makeNode("node1", "node1.example.com", "10.0.1.2", true)
+		mapped.Labels = map[string]string{"pool": "edge"}
+		url, err := client.summaryURL(mapped)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.Host).To(Equal("node1.example.com:10250"))
+
+		url, err = client.summaryURL(makeNode("node2", "node2.example.com", "10.0.1.3", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.Host).To(Equal("10.0.1.3:10250"))
+	})
+	It("should use the scheme and port overridden for control plane nodes", func() {
+		controlPlaneSelector, err := labels.Parse(ControlPlaneRoleLabel)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10250,
+			UseNodeStatusPort:   true,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			ScrapeTargetOverrides: []ScrapeTargetOverride{{
+				Selector: controlPlaneSelector,
+				Scheme:   "http",
+				Port:     10260,
+			}},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		controlPlane := makeNode("control-plane", "", "10.0.1.1", true)
+		controlPlane.Labels = map[string]string{ControlPlaneRoleLabel: ""}
+		controlPlane.Status.DaemonEndpoints.KubeletEndpoint.Port = 10255
+		url, err := client.summaryURL(controlPlane)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("http://10.0.1.1:10260/stats/summary?only_cpu_and_memory=true"))
+
+		url, err = client.summaryURL(makeNode("worker", "", "10.0.1.2", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("https://10.0.1.2:10250/stats/summary?only_cpu_and_memory=true"))
+	})
+	It("should use the API server node proxy path when scraping via API server", func() {
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10250,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			ScrapeViaAPIServer:  true,
+			Client:              rest.Config{Host: "https://10.96.0.1:443"},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		url, err := client.summaryURL(makeNode("node1", "", "10.0.1.2", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("https://10.96.0.1:443/api/v1/nodes/node1/proxy/stats/summary?only_cpu_and_memory=true"))
+	})
+	It("should preserve the API server path prefix and default to https", func() {
+		client, err := KubeletClientConfig{
+			ScrapeViaAPIServer: true,
+			Client:             rest.Config{Host: "apiserver.example.com/prefix"},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		url, err := client.summaryURL(makeNode("node-no-address", "", "", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("https://apiserver.example.com/prefix/api/v1/nodes/node-no-address/proxy/stats/summary?only_cpu_and_memory=true"))
+	})
+	It("should use the addresses listed in the node address file", func() {
+		dir, err := ioutil.TempDir("", "addresses")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "addresses.yaml")
+		Expect(ioutil.WriteFile(path, []byte(`addresses: {node1: 192.168.100.1}`), 0600)).To(Succeed())
+		addresses, err := utils.LoadNodeAddresses(path)
+		Expect(err).NotTo(HaveOccurred())
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10250,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			NodeAddresses:       addresses,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		url, err := client.summaryURL(makeNode("node1", "", "10.0.1.2", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.Host).To(Equal("192.168.100.1:10250"))
+
+		url, err = client.summaryURL(makeNode("node2", "", "10.0.1.3", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.Host).To(Equal("10.0.1.3:10250"))
+	})
+	It("should use the configured summary path", func() {
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10255,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			SummaryPath:         "/virtual/stats/summary",
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+
+		url, err := client.summaryURL(makeNode("virtual-node", "", "10.0.1.9", true))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(url.String()).To(Equal("https://10.0.1.9:10255/virtual/stats/summary?only_cpu_and_memory=true"))
+	})
+})
+
+var _ = Describe("Kubelet client duplicate targets", func() {
+	var nodes []*corev1.Node
+	BeforeEach(func() {
+		nodes = []*corev1.Node{
+			makeNode("node1", "node1.example.com", "10.0.0.1", true),
+			makeNode("node2", "node2.example.com", "10.0.0.1", true),
+			makeNode("node3", "", "10.0.0.3", true),
+		}
+	})
+	newClient := func(dedup bool) *kubeletClient {
+		client, err := KubeletClientConfig{
+			Scheme:              "https",
+			DefaultPort:         10250,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeHostName},
+			DedupNodeAddresses:  dedup,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+	targets := func(client *kubeletClient, nodes []*corev1.Node) map[string]string {
+		result := map[string]string{}
+		for _, node := range nodes {
+			target, err := client.target(node)
+			Expect(err).NotTo(HaveOccurred())
+			result[node.Name] = target
+		}
+		return result
+	}
+
+	It("should flag nodes sharing a target without changing them by default", func() {
+		resolved, duplicates := newClient(false).resolveDuplicates(nodes)
+		Expect(resolved).To(Equal(nodes))
+		Expect(duplicates).To(Equal([]string{"node1", "node2"}))
+	})
+	It("should fall back to the next address type for colliding nodes", func() {
+		client := newClient(true)
+		resolved, duplicates := client.resolveDuplicates(nodes)
+		Expect(duplicates).To(BeEmpty())
+		Expect(targets(client, resolved)).To(Equal(map[string]string{
+			"node1": "node1.example.com:10250",
+			"node2": "node2.example.com:10250",
+			"node3": "10.0.0.3:10250",
+		}))
+		By("leaving the original nodes untouched")
+		Expect(client.target(nodes[0])).To(Equal("10.0.0.1:10250"))
+		Expect(client.target(nodes[1])).To(Equal("10.0.0.1:10250"))
+	})
+	It("should skip colliding nodes without a distinct fallback address", func() {
+		nodes[0] = makeNode("node1", "", "10.0.0.1", true)
+		nodes[1] = makeNode("node2", "", "10.0.0.1", true)
+		client := newClient(true)
+		resolved, duplicates := client.resolveDuplicates(nodes)
+		Expect(duplicates).To(Equal([]string{"node1", "node2"}))
+		Expect(resolved).To(Equal([]*corev1.Node{nodes[2]}))
+	})
+	It("should not consider addresses when scraping via API server", func() {
+		client, err := KubeletClientConfig{
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			ScrapeViaAPIServer:  true,
+			DedupNodeAddresses:  true,
+			Client:              rest.Config{Host: "https://10.96.0.1:443"},
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		resolved, duplicates := client.resolveDuplicates(nodes)
+		Expect(resolved).To(Equal(nodes))
+		Expect(duplicates).To(BeEmpty())
+	})
+})
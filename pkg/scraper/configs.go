@@ -17,8 +17,11 @@ package scraper
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
@@ -30,23 +33,187 @@ import (
 type KubeletClientConfig struct {
 	Client              rest.Config
 	AddressTypePriority []corev1.NodeAddressType
-	Scheme              string
-	DefaultPort         int
-	UseNodeStatusPort   bool
+	// AddressTypeMappings override AddressTypePriority for nodes matching their selectors.
+	AddressTypeMappings []utils.AddressTypeMapping
+	// NodeAddresses, if set, override address resolution for listed nodes.
+	NodeAddresses     *utils.NodeAddresses
+	Scheme            string
+	DefaultPort       int
+	UseNodeStatusPort bool
+	// ScrapeViaAPIServer connects to Kubelets through the API server node proxy,
+	// using Client as the API server client configuration.
+	ScrapeViaAPIServer bool
+	// RawPayloads, if set, receives the raw summary payload of every node scraped.
+	RawPayloads *PayloadCache
+	// DedupNodeAddresses makes nodes resolving to the same scrape target as
+	// another node fall back to their next address by priority.
+	DedupNodeAddresses bool
+	// AddressPins, if set, keep nodes resolving to the address they were first
+	// resolved to, until their addresses change. Listed NodeAddresses still
+	// take precedence.
+	AddressPins *utils.NodeAddressPins
+	// DialTimeout bounds establishing connections. Zero leaves dials bounded
+	// by the request only.
+	DialTimeout time.Duration
+	// RequestTimeout bounds each summary request, including reading the
+	// response. Zero leaves requests bounded by the scrape timeout only.
+	RequestTimeout time.Duration
+	// ParseTimeout bounds parsing each summary once it is received, failing
+	// the node when exceeded. Zero means no bound.
+	ParseTimeout time.Duration
+	// ScrapeTargetOverrides change the scheme and port used for Kubelets of
+	// matching nodes. The first matching override applies.
+	ScrapeTargetOverrides []ScrapeTargetOverride
+	// ServingCertSNI decides whether Kubelet serving certificates are verified
+	// against the scrape address or the node name, the address if empty.
+	ServingCertSNI ServingCertSNI
+	// SuccessStatusCodes are the response status codes accepted from Kubelets
+	// besides 200 OK. Responses with any of them and no body carry no metrics.
+	SuccessStatusCodes []int
+	// SummaryPath, if not empty, replaces the path of the summary API on
+	// Kubelets, e.g. for virtual-kubelet providers serving it elsewhere.
+	SummaryPath string
+	// CADir, if set, is a directory of PEM files whose certificates are
+	// trusted instead of the CA of Client, reloaded when they change.
+	CADir string
+	// CARotationGrace, if positive, keeps trusting the certificates replaced
+	// in CADir for that long after the change is picked up.
+	CARotationGrace time.Duration
+	// AllowPartialSummaries keeps the node and the pods preceding the
+	// truncation of truncated summaries, instead of failing the node.
+	AllowPartialSummaries bool
+	// SniffGzip decompresses response bodies starting with the gzip magic
+	// bytes even without a gzip Content-Encoding, e.g. from proxies dropping
+	// the header.
+	SniffGzip bool
+	// TryAllAddresses retries nodes failing with an error of AddressFallbackOn
+	// on their next address by priority, until one succeeds or the scrape
+	// times out.
+	TryAllAddresses bool
+	// AddressFallbackOn are the classes of errors after which TryAllAddresses
+	// retries nodes on their next address, connection errors if empty.
+	AddressFallbackOn []AddressFallbackClass
+	// Resources selects the series parsed from summaries, e.g. to save the
+	// cost of parsing CPU series when only memory is served.
+	Resources Resources
+	// SecondaryPort, if positive, is the port of an exporter scraped on each
+	// node after its Kubelet, whose SecondaryResources series missing from
+	// the Kubelet summary are merged into it. The exporter serves summaries
+	// on SecondaryPath, with the scheme and credentials used for Kubelets.
+	SecondaryPort      int
+	SecondaryPath      string
+	SecondaryResources Resources
+	// ExtraHeaders are set on every summary request, after rendering their
+	// values for the scraped node.
+	ExtraHeaders []RequestHeader
+	// BasicAuthUser and BasicAuthPasswordFile, if set, authenticate summary
+	// requests with basic auth, e.g. for proxies in front of Kubelets, instead
+	// of the bearer token of Client. The password file is read for every
+	// request.
+	BasicAuthUser         string
+	BasicAuthPasswordFile string
+	// HTTP2 negotiates HTTP/2 with Kubelets serving TLS, falling back to
+	// HTTP/1.1 for Kubelets which don't support it. Otherwise only HTTP/1.1
+	// is used.
+	HTTP2 bool
+	// MaxStreamsPerKubelet bounds the concurrent requests to each Kubelet
+	// when HTTP2 is set. Zero means no bound.
+	MaxStreamsPerKubelet int
+	// FollowRedirects decides which redirects of Kubelet responses are
+	// followed, at most MaxRedirects of them. Empty refuses every redirect.
+	FollowRedirects FollowRedirects
+	MaxRedirects    int
 }
 
 // Complete constructs a new kubeletCOnfig for the given configuration.
 func (config KubeletClientConfig) Complete() (*kubeletClient, error) {
-	transport, err := rest.TransportFor(&config.Client)
+	// client-go shares transports of configs with the same dial function
+	// code, so clients in one process can't have different dial timeouts
+	if config.DialTimeout > 0 {
+		config.Client.Dial = (&net.Dialer{Timeout: config.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if !config.ScrapeViaAPIServer {
+		// client-go configures HTTP/2 unless NextProtos excludes it
+		if config.HTTP2 {
+			config.Client.TLSClientConfig.NextProtos = []string{protoHTTP2, protoHTTP11}
+		} else {
+			config.Client.TLSClientConfig.NextProtos = []string{protoHTTP11}
+		}
+	}
+	var streams *streamLimiter
+	if config.HTTP2 {
+		streams = newStreamLimiter(config.MaxStreamsPerKubelet)
+	}
+	var caDirectory *caDir
+	var transport http.RoundTripper
+	var err error
+	if config.CADir != "" && !config.ScrapeViaAPIServer {
+		caDirectory, err = newCADir(config.CADir, config.CARotationGrace)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load Kubelet CA directory: %v", err)
+		}
+		transport, err = caDirTransport(caDirectory, config.Client)
+	} else {
+		transport, err = rest.TransportFor(&config.Client)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("unable to construct transport: %v", err)
 	}
 
+	redirects := checkRedirect(config.FollowRedirects, config.MaxRedirects)
 	c := &http.Client{
-		Transport: transport,
+		Transport:     transport,
+		CheckRedirect: redirects,
+	}
+	var apiServerURL *url.URL
+	if config.ScrapeViaAPIServer {
+		apiServerURL, err = parseHost(config.Client.Host)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse API server host %q: %v", config.Client.Host, err)
+		}
+	}
+	successCodes := make(map[int]bool, len(config.SuccessStatusCodes))
+	for _, code := range config.SuccessStatusCodes {
+		successCodes[code] = true
+	}
+	summaryPath := config.SummaryPath
+	if summaryPath == "" {
+		summaryPath = defaultSummaryPath
+	}
+	var nodeNames *nodeNameClients
+	if config.ServingCertSNI == ServingCertSNINodeName && !config.ScrapeViaAPIServer {
+		nodeNames = newNodeNameClients(config.Client, caDirectory, redirects)
+	}
+	addrResolver := utils.NewPriorityNodeAddressResolver(config.AddressTypePriority)
+	if len(config.AddressTypeMappings) > 0 {
+		addrResolver = utils.NewMappedNodeAddressResolver(config.AddressTypeMappings, config.AddressTypePriority)
+	}
+	if config.AddressPins != nil {
+		addrResolver = utils.NewPinnedNodeAddressResolver(config.AddressPins, addrResolver)
+	}
+	if config.NodeAddresses != nil {
+		addrResolver = utils.NewListedNodeAddressResolver(config.NodeAddresses, addrResolver)
 	}
 	return &kubeletClient{
-		addrResolver:      utils.NewPriorityNodeAddressResolver(config.AddressTypePriority),
+		apiServerURL:      apiServerURL,
+		payloads:          config.RawPayloads,
+		dedupAddresses:    config.DedupNodeAddresses,
+		requestTimeout:    config.RequestTimeout,
+		targetOverrides:   config.ScrapeTargetOverrides,
+		nodeNameClients:   nodeNames,
+		successCodes:      successCodes,
+		summaryPath:       summaryPath,
+		allowPartial:      config.AllowPartialSummaries,
+		sniffGzip:         config.SniffGzip,
+		tryAllAddresses:   config.TryAllAddresses,
+		fallbackOn:        config.AddressFallbackOn,
+		resources:         config.Resources,
+		parseTimeout:      config.ParseTimeout,
+		extraHeaders:      config.ExtraHeaders,
+		basicAuth:         newBasicAuth(config.BasicAuthUser, config.BasicAuthPasswordFile),
+		streams:           streams,
+		secondary:         secondaryTargetOf(config),
+		addrResolver:      addrResolver,
 		defaultPort:       config.DefaultPort,
 		client:            c,
 		scheme:            config.Scheme,
@@ -58,3 +225,12 @@ func (config KubeletClientConfig) Complete() (*kubeletClient, error) {
 		},
 	}, nil
 }
+
+// parseHost parses an API server host, which may be given without a scheme.
+func parseHost(host string) (*url.URL, error) {
+	u, err := url.Parse(host)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		u, err = url.Parse("https://" + host)
+	}
+	return u, err
+}
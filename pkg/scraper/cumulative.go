@@ -0,0 +1,49 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	apitypes "k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+// attachCumulativeCPU sets the cumulative CPU counter Kubelet reported for
+// each container in the batch whose CPU usage is served. Containers without
+// a counter are left without one.
+func attachCumulativeCPU(batch *storage.MetricsBatch, summary *Summary) {
+	reported := make(map[apitypes.NamespacedName]map[string]*CPUStats, len(summary.Pods))
+	for _, pod := range summary.Pods {
+		containers := make(map[string]*CPUStats, len(pod.Containers))
+		for _, container := range pod.Containers {
+			if container.CPU != nil && container.CPU.UsageCoreNanoSeconds != nil {
+				containers[container.Name] = container.CPU
+			}
+		}
+		reported[apitypes.NamespacedName{Namespace: pod.PodRef.Namespace, Name: pod.PodRef.Name}] = containers
+	}
+	for i := range batch.Pods {
+		pod := &batch.Pods[i]
+		containers := reported[apitypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}]
+		for j := range pod.Containers {
+			container := &pod.Containers[j]
+			stats, found := containers[container.Name]
+			if !found || container.CPUMissing {
+				continue
+			}
+			container.CumulativeCPU = &storage.CumulativeCPU{CoreNanoSeconds: *stats.UsageCoreNanoSeconds, Timestamp: stats.Time.Time}
+		}
+	}
+}
@@ -19,19 +19,51 @@ import (
 	"math"
 	"time"
 
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 
 	"k8s.io/apimachinery/pkg/api/resource"
 	"sigs.k8s.io/metrics-server/pkg/storage"
 )
 
-func decodeBatch(summary *Summary) *storage.MetricsBatch {
+// TimestampSource decides where the timestamps of metrics points come from.
+type TimestampSource string
+
+const (
+	// TimestampSourceSeries uses the timestamps Kubelet reports with each
+	// series. Nodes reporting no timestamp fail to be scraped.
+	TimestampSourceSeries TimestampSource = "series"
+	// TimestampSourceReceive uses the time metrics-server received the
+	// summary, whether Kubelet reports timestamps or not.
+	TimestampSourceReceive TimestampSource = "receive"
+)
+
+// MemoryMetric decides which memory series of the summary is stored as
+// memory usage.
+type MemoryMetric string
+
+const (
+	// MemoryMetricWorkingSet stores the working set, which the Kubelet
+	// compares to limits when evicting and the kernel when OOM killing.
+	MemoryMetricWorkingSet MemoryMetric = "working-set"
+	// MemoryMetricRSS stores the resident set size, leaving out page cache.
+	MemoryMetricRSS MemoryMetric = "rss"
+)
+
+// decodeBatch decodes the summary into metrics points. If receiveTime isn't
+// zero, all points get it as timestamp, instead of the reported timestamps.
+// Nodes reporting only one of CPU and memory are kept if singleResourceNodes
+// is set, with the other resource marked as missing. Memory usage is
+// decoded from the series selected by memoryMetric, the working set if empty.
+// Resources left out by resources, and resources whose series are stale
+// according to staleness, if set, are marked as missing, without dropping the
+// node or container.
+func decodeBatch(summary *Summary, receiveTime time.Time, singleResourceNodes bool, memoryMetric MemoryMetric, resources Resources, staleness *resourceStaleness) *storage.MetricsBatch {
 	res := &storage.MetricsBatch{
 		Nodes: make([]storage.NodeMetricsPoint, 1),
 		Pods:  make([]storage.PodMetricsPoint, len(summary.Pods)),
 	}
 
-	success := decodeNodeStats(&summary.Node, &res.Nodes[0])
+	success := decodeNodeStats(&summary.Node, &res.Nodes[0], receiveTime, singleResourceNodes, memoryMetric, resources, staleness)
 	if !success {
 		// if we had errors providing node metrics, discard the data point
 		// so that we don't incorrectly report metric values as zero.
@@ -40,7 +72,7 @@ func decodeBatch(summary *Summary) *storage.MetricsBatch {
 
 	num := 0
 	for _, pod := range summary.Pods {
-		success := decodePodStats(&pod, &res.Pods[num])
+		success := decodePodStats(&pod, &res.Pods[num], receiveTime, memoryMetric, resources, staleness)
 		if !success {
 			// NB: we explicitly want to discard pods with partial results, since
 			// the horizontal pod autoscaler takes special action when a pod is missing
@@ -50,14 +82,15 @@ func decodeBatch(summary *Summary) *storage.MetricsBatch {
 			// because they get completely overwritten in decodePodStats
 			continue
 		}
+		res.Pods[num].Node = summary.Node.NodeName
 		num++
 	}
 	res.Pods = res.Pods[:num]
 	return res
 }
 
-func decodeNodeStats(nodeStats *NodeStats, target *storage.NodeMetricsPoint) (success bool) {
-	timestamp, err := getScrapeTime(nodeStats.CPU, nodeStats.Memory)
+func decodeNodeStats(nodeStats *NodeStats, target *storage.NodeMetricsPoint, receiveTime time.Time, singleResource bool, memoryMetric MemoryMetric, resources Resources, staleness *resourceStaleness) (success bool) {
+	timestamp, err := pointTime(nodeStats.CPU, nodeStats.Memory, receiveTime)
 	if err != nil {
 		// if we can't get a timestamp, assume bad data in general
 		klog.V(1).Infof("Skip metric for node %q, error: %v", nodeStats.NodeName, err)
@@ -69,28 +102,44 @@ func decodeNodeStats(nodeStats *NodeStats, target *storage.NodeMetricsPoint) (su
 			Timestamp: timestamp,
 		},
 	}
-	success = true
-	if err := decodeCPU(&target.CpuUsage, nodeStats.CPU); err != nil {
+	if resources.SkipCPU {
+		target.CPUMissing = true
+	} else if err := decodeCPU(&target.CpuUsage, nodeStats.CPU); err != nil {
 		klog.V(1).Infof("Skip CPU metric for node %q, error %v", nodeStats.NodeName, err)
-		success = false
+		target.CPUMissing = true
 	}
-	if err := decodeMemory(&target.MemoryUsage, nodeStats.Memory); err != nil {
+	if resources.SkipMemory {
+		target.MemoryMissing = true
+	} else if err := decodeMemory(&target.MemoryUsage, nodeStats.Memory, memoryMetric); err != nil {
 		klog.V(1).Infof("Skip Memory metric for node %q, error %v", nodeStats.NodeName, err)
-		success = false
+		target.MemoryMissing = true
 	}
-	return success
+	var stale bool
+	if !target.CPUMissing && staleness.cpuStale(nodeStats.CPU) {
+		klog.V(1).Infof("Skip stale CPU metric for node %q sampled at %s", nodeStats.NodeName, nodeStats.CPU.Time)
+		target.CPUMissing, stale = true, true
+	}
+	if !target.MemoryMissing && staleness.memoryStale(nodeStats.Memory) {
+		klog.V(1).Infof("Skip stale Memory metric for node %q sampled at %s", nodeStats.NodeName, nodeStats.Memory.Time)
+		target.MemoryMissing, stale = true, true
+	}
+	if target.CPUMissing && target.MemoryMissing {
+		return false
+	}
+	return singleResource || stale || resources != (Resources{}) || !(target.CPUMissing || target.MemoryMissing)
 }
 
-func decodePodStats(podStats *PodStats, target *storage.PodMetricsPoint) (success bool) {
+func decodePodStats(podStats *PodStats, target *storage.PodMetricsPoint, receiveTime time.Time, memoryMetric MemoryMetric, resources Resources, staleness *resourceStaleness) (success bool) {
 	success = true
 	// completely overwrite data in the target
 	*target = storage.PodMetricsPoint{
 		Name:       podStats.PodRef.Name,
 		Namespace:  podStats.PodRef.Namespace,
+		UID:        podStats.PodRef.UID,
 		Containers: make([]storage.ContainerMetricsPoint, len(podStats.Containers)),
 	}
 	for i, container := range podStats.Containers {
-		timestamp, err := getScrapeTime(container.CPU, container.Memory)
+		timestamp, err := pointTime(container.CPU, container.Memory, receiveTime)
 		if err != nil {
 			// if we can't get a timestamp, assume bad data in general
 			klog.V(1).Infof("Skip container %q in pod %s/%s, error: %v", container.Name, target.Namespace, target.Name, err)
@@ -103,14 +152,29 @@ func decodePodStats(podStats *PodStats, target *storage.PodMetricsPoint) (succes
 				Timestamp: timestamp,
 			},
 		}
-		if err = decodeCPU(&point.CpuUsage, container.CPU); err != nil {
+		if resources.SkipCPU {
+			point.CPUMissing = true
+		} else if err = decodeCPU(&point.CpuUsage, container.CPU); err != nil {
 			klog.V(1).Infof("Skip CPU metric for container %q in pod %s/%s, error: %v", container.Name, target.Namespace, target.Name, err)
 			success = false
 		}
-		if err = decodeMemory(&point.MemoryUsage, container.Memory); err != nil {
+		if resources.SkipMemory {
+			point.MemoryMissing = true
+		} else if err = decodeMemory(&point.MemoryUsage, container.Memory, memoryMetric); err != nil {
 			klog.V(1).Infof("Skip Memory metric for container %q in pod %s/%s, error: %v", container.Name, target.Namespace, target.Name, err)
 			success = false
 		}
+		if !point.CPUMissing && staleness.cpuStale(container.CPU) {
+			klog.V(1).Infof("Skip stale CPU metric for container %q in pod %s/%s sampled at %s", container.Name, target.Namespace, target.Name, container.CPU.Time)
+			point.CPUMissing = true
+		}
+		if !point.MemoryMissing && staleness.memoryStale(container.Memory) {
+			klog.V(1).Infof("Skip stale Memory metric for container %q in pod %s/%s sampled at %s", container.Name, target.Namespace, target.Name, container.Memory.Time)
+			point.MemoryMissing = true
+		}
+		if point.CPUMissing && point.MemoryMissing {
+			success = false
+		}
 
 		target.Containers[i] = point
 	}
@@ -126,7 +190,15 @@ func decodeCPU(target *resource.Quantity, cpuStats *CPUStats) error {
 	return nil
 }
 
-func decodeMemory(target *resource.Quantity, memStats *MemoryStats) error {
+func decodeMemory(target *resource.Quantity, memStats *MemoryStats, metric MemoryMetric) error {
+	if metric == MemoryMetricRSS {
+		if memStats == nil || memStats.RSSBytes == nil {
+			return fmt.Errorf("missing rssBytes value")
+		}
+		*target = *uint64Quantity(*memStats.RSSBytes, 0)
+		target.Format = resource.BinarySI
+		return nil
+	}
 	if memStats == nil || memStats.WorkingSetBytes == nil {
 		return fmt.Errorf("missing workingSetBytes value")
 	}
@@ -137,6 +209,15 @@ func decodeMemory(target *resource.Quantity, memStats *MemoryStats) error {
 	return nil
 }
 
+// pointTime returns the receive time if it isn't zero, otherwise the reported
+// scrape time.
+func pointTime(cpu *CPUStats, memory *MemoryStats, receiveTime time.Time) (time.Time, error) {
+	if !receiveTime.IsZero() {
+		return receiveTime, nil
+	}
+	return getScrapeTime(cpu, memory)
+}
+
 func getScrapeTime(cpu *CPUStats, memory *MemoryStats) (time.Time, error) {
 	// Ensure we get the earlier timestamp so that we can tell if a given data
 	// point was tainted by pod initialization.
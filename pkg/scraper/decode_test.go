@@ -63,7 +63,7 @@ var _ = Describe("Decode", func() {
 		summary.Node.CPU.Time = metav1.Time{}
 
 		By("decoding")
-		batch := decodeBatch(summary)
+		batch := decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil)
 
 		By("verifying that the scrape time is as expected")
 		Expect(batch.Nodes[0].Timestamp).To(Equal(summary.Node.Memory.Time.Time))
@@ -80,13 +80,140 @@ var _ = Describe("Decode", func() {
 		summary.Pods[3].Containers[0].Memory.WorkingSetBytes = nil
 
 		By("decoding")
-		batch := decodeBatch(summary)
+		batch := decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil)
 
 		By("verifying that the batch has all the data, save for what was missing")
 		Expect(batch.Pods).To(HaveLen(0))
 		Expect(batch.Nodes).To(HaveLen(0))
 	})
 
+	It("should skip nodes reporting only CPU or only memory when both are required", func() {
+		By("removing memory from the node")
+		summary.Node.Memory = nil
+
+		By("decoding")
+		Expect(decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil).Nodes).To(HaveLen(0))
+
+		By("removing CPU from the node instead")
+		summary.Node.Memory = memStats(200, time.Now())
+		summary.Node.CPU = nil
+
+		By("decoding")
+		Expect(decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil).Nodes).To(HaveLen(0))
+	})
+
+	It("should keep nodes reporting only CPU or only memory when single resource nodes are allowed", func() {
+		By("removing memory from the node")
+		summary.Node.Memory = nil
+
+		By("decoding")
+		batch := decodeBatch(summary, time.Time{}, true, MemoryMetricWorkingSet, Resources{}, nil)
+
+		By("verifying that the node is kept with memory marked as missing")
+		Expect(batch.Nodes).To(HaveLen(1))
+		Expect(batch.Nodes[0].CpuUsage).To(Equal(*resource.NewScaledQuantity(100, -9)))
+		Expect(batch.Nodes[0].CPUMissing).To(BeFalse())
+		Expect(batch.Nodes[0].MemoryMissing).To(BeTrue())
+
+		By("removing CPU from the node instead")
+		summary.Node.Memory = memStats(200, time.Now())
+		summary.Node.CPU = nil
+
+		By("decoding")
+		batch = decodeBatch(summary, time.Time{}, true, MemoryMetricWorkingSet, Resources{}, nil)
+
+		By("verifying that the node is kept with CPU marked as missing")
+		Expect(batch.Nodes).To(HaveLen(1))
+		Expect(batch.Nodes[0].MemoryUsage.Value()).To(Equal(int64(200)))
+		Expect(batch.Nodes[0].CPUMissing).To(BeTrue())
+		Expect(batch.Nodes[0].MemoryMissing).To(BeFalse())
+
+		By("removing both from the node")
+		summary.Node.Memory = nil
+
+		By("decoding")
+		Expect(decodeBatch(summary, time.Time{}, true, MemoryMetricWorkingSet, Resources{}, nil).Nodes).To(HaveLen(0))
+	})
+
+	It("should keep containers with negligible usage, but not containers without usage", func() {
+		By("reporting tiny usage for one pod and no CPU usage for another")
+		summary.Pods[1].Containers[0] = containerStats("container1", 1, 1, time.Now())
+		summary.Pods[2].Containers[0].CPU.UsageNanoCores = nil
+
+		By("decoding")
+		batch := decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil)
+
+		By("verifying that the tiny usage is reported as it is")
+		Expect(batch.Pods).To(HaveLen(3))
+		Expect(batch.Pods[1].Name).To(Equal("pod2"))
+		Expect(batch.Pods[1].Containers[0].CpuUsage).To(Equal(*resource.NewScaledQuantity(1, -9)))
+		Expect(batch.Pods[1].Containers[0].MemoryUsage.Value()).To(Equal(int64(1)))
+
+		By("verifying that the pod without usage is left out")
+		for _, pod := range batch.Pods {
+			Expect(pod.Namespace + "/" + pod.Name).NotTo(Equal("ns2/pod1"))
+		}
+	})
+
+	It("should decode the series selected as memory usage", func() {
+		By("reporting a resident set size for the node and a container")
+		nodeRSS, containerRSS := uint64(150), uint64(350)
+		summary.Node.Memory.RSSBytes = &nodeRSS
+		summary.Pods[0].Containers[0].Memory.RSSBytes = &containerRSS
+
+		By("decoding the working set")
+		batch := decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil)
+		Expect(batch.Nodes[0].MemoryUsage.Value()).To(Equal(int64(200)))
+		Expect(batch.Pods[0].Containers[0].MemoryUsage.Value()).To(Equal(int64(400)))
+
+		By("decoding the resident set size")
+		batch = decodeBatch(summary, time.Time{}, false, MemoryMetricRSS, Resources{}, nil)
+		Expect(batch.Nodes[0].MemoryUsage.Value()).To(Equal(int64(150)))
+		Expect(batch.Nodes[0].MemoryUsage.Format).To(Equal(resource.BinarySI))
+
+		By("verifying that pods missing the resident set size are skipped")
+		Expect(batch.Pods).To(HaveLen(0))
+	})
+
+	It("should leave out each resource stale under its own threshold", func() {
+		now := time.Now()
+		summary.Node.CPU.Time = metav1.NewTime(now.Add(-time.Minute))
+		summary.Node.Memory.Time = metav1.NewTime(now.Add(-time.Minute))
+		summary.Pods[0].Containers[0].CPU.Time = metav1.NewTime(now.Add(-time.Minute))
+		summary.Pods[0].Containers[0].Memory.Time = metav1.NewTime(now.Add(-time.Minute))
+		staleness := &resourceStaleness{now: now, cpu: 30 * time.Second, memory: 2 * time.Minute}
+
+		By("decoding")
+		batch := decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, staleness)
+
+		By("verifying that only the stale CPU usage is missing")
+		Expect(batch.Nodes).To(HaveLen(1))
+		Expect(batch.Nodes[0].CPUMissing).To(BeTrue())
+		Expect(batch.Nodes[0].MemoryMissing).To(BeFalse())
+		Expect(batch.Pods).To(HaveLen(4))
+		Expect(batch.Pods[0].Containers[0].CPUMissing).To(BeTrue())
+		Expect(batch.Pods[0].Containers[0].MemoryMissing).To(BeFalse())
+		Expect(batch.Pods[0].Containers[1].CPUMissing).To(BeFalse())
+
+		By("swapping the thresholds")
+		staleness = &resourceStaleness{now: now, cpu: 2 * time.Minute, memory: 30 * time.Second}
+		batch = decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, staleness)
+		Expect(batch.Nodes[0].CPUMissing).To(BeFalse())
+		Expect(batch.Nodes[0].MemoryMissing).To(BeTrue())
+		Expect(batch.Pods[0].Containers[0].CPUMissing).To(BeFalse())
+		Expect(batch.Pods[0].Containers[0].MemoryMissing).To(BeTrue())
+	})
+
+	It("should drop containers with both resources stale", func() {
+		now := time.Now()
+		summary.Pods[1].Containers[0].CPU.Time = metav1.NewTime(now.Add(-time.Minute))
+		summary.Pods[1].Containers[0].Memory.Time = metav1.NewTime(now.Add(-time.Minute))
+		staleness := &resourceStaleness{now: now, cpu: 30 * time.Second, memory: 30 * time.Second}
+
+		batch := decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, staleness)
+		Expect(batch.Pods).To(HaveLen(3))
+	})
+
 	It("should handle larger-than-int64 CPU or memory values gracefully", func() {
 		By("setting some data in the summary to be above math.MaxInt64")
 		plusTen := uint64(math.MaxInt64 + 10)
@@ -100,7 +227,7 @@ var _ = Describe("Decode", func() {
 		summary.Pods[1].Containers[0].Memory.WorkingSetBytes = &minusOneHundred
 
 		By("decoding")
-		batch := decodeBatch(summary)
+		batch := decodeBatch(summary, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil)
 
 		By("verifying that the data is still present, at lower precision")
 		nodeMem := *resource.NewScaledQuantity(int64(plusTen/10), 1)
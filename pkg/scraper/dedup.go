@@ -0,0 +1,139 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+// duplicateResolver is implemented by Kubelet clients which can detect nodes
+// resolving to the same scrape target within a scrape cycle.
+type duplicateResolver interface {
+	// resolveDuplicates returns the nodes to scrape, and the names of nodes
+	// which share their scrape target with another node and couldn't be
+	// resolved to a distinct one.
+	resolveDuplicates(nodes []*corev1.Node) (resolved []*corev1.Node, duplicates []string)
+}
+
+var _ duplicateResolver = (*kubeletClient)(nil)
+
+// resolveDuplicates detects nodes sharing a scrape target, which would
+// otherwise lead to one Kubelet being scraped twice while another one is
+// missed. If address deduplication is enabled, colliding nodes fall back to
+// their next address by priority, when it is distinct from all other targets,
+// and are not scraped otherwise.
+func (kc *kubeletClient) resolveDuplicates(nodes []*corev1.Node) ([]*corev1.Node, []string) {
+	if kc.apiServerURL != nil {
+		// the API server proxies by node name, so addresses don't matter
+		return nodes, nil
+	}
+	byTarget := make(map[string][]int, len(nodes))
+	for i, node := range nodes {
+		target, err := kc.target(node)
+		if err != nil {
+			// reported when the node is scraped
+			continue
+		}
+		byTarget[target] = append(byTarget[target], i)
+	}
+
+	resolved := nodes
+	copied := false
+	var duplicates []string
+	for target, indices := range byTarget {
+		if len(indices) < 2 {
+			continue
+		}
+		for _, i := range indices {
+			node := nodes[i]
+			if kc.dedupAddresses {
+				if fallback, fallbackTarget, ok := kc.fallback(node, byTarget); ok {
+					klog.Warningf("node %s shares the scrape target %s with other nodes, falling back to %s", node.Name, target, fallbackTarget)
+					if !copied {
+						resolved = append([]*corev1.Node(nil), nodes...)
+						copied = true
+					}
+					resolved[i] = fallback
+					byTarget[fallbackTarget] = []int{i}
+					continue
+				}
+				klog.Warningf("node %s shares the scrape target %s with other nodes and has no distinct fallback address, skipping it", node.Name, target)
+			} else {
+				klog.Warningf("node %s shares the scrape target %s with other nodes, its metrics may be misattributed", node.Name, target)
+			}
+			duplicates = append(duplicates, node.Name)
+		}
+	}
+	sort.Strings(duplicates)
+	if kc.dedupAddresses && len(duplicates) > 0 {
+		skip := sets.NewString(duplicates...)
+		scraped := make([]*corev1.Node, 0, len(resolved))
+		for _, node := range resolved {
+			if !skip.Has(node.Name) {
+				scraped = append(scraped, node)
+			}
+		}
+		resolved = scraped
+	}
+	return resolved, duplicates
+}
+
+// fallback returns a copy of the node without the addresses its current
+// target was resolved from, if the resulting target is unused by other nodes.
+func (kc *kubeletClient) fallback(node *corev1.Node, byTarget map[string][]int) (*corev1.Node, string, bool) {
+	fallback, ok := kc.withoutResolvedAddress(node)
+	if !ok {
+		return nil, "", false
+	}
+	target, err := kc.target(fallback)
+	if err != nil {
+		return nil, "", false
+	}
+	if _, used := byTarget[target]; used {
+		return nil, "", false
+	}
+	return fallback, target, true
+}
+
+// withoutResolvedAddress returns a copy of the node without the addresses
+// its current address was resolved from.
+func (kc *kubeletClient) withoutResolvedAddress(node *corev1.Node) (*corev1.Node, bool) {
+	addr, err := kc.addrResolver.NodeAddress(node)
+	if err != nil {
+		return nil, false
+	}
+	fallback := node.DeepCopy()
+	addresses := fallback.Status.Addresses[:0]
+	for _, address := range fallback.Status.Addresses {
+		if address.Address != addr {
+			addresses = append(addresses, address)
+		}
+	}
+	fallback.Status.Addresses = addresses
+	return fallback, true
+}
+
+// target returns the host and port the node would be scraped on.
+func (kc *kubeletClient) target(node *corev1.Node) (string, error) {
+	u, err := kc.summaryURL(node)
+	if err != nil {
+		return "", err
+	}
+	return u.Host, nil
+}
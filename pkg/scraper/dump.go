@@ -0,0 +1,105 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+// DumpVerbosity is the log verbosity from which a BatchDumper writes cycles.
+const DumpVerbosity = 4
+
+// BatchDumper writes the metrics scraped in each cycle as lines of key=value
+// pairs, one per node and container, for debugging without a Prometheus
+// scraper. Lines are sorted by node, then namespace, pod and container:
+//
+//	scrape cycle=<start> node=<node> timestamp=<time> cpu=<nanocores> memory=<bytes>
+//	scrape cycle=<start> namespace=<ns> pod=<pod> container=<container> timestamp=<time> cpu=<nanocores> memory=<bytes>
+//
+// Times are RFC 3339 in UTC, resources missing from a node are written as -.
+type BatchDumper struct {
+	mu  sync.Mutex
+	out io.Writer
+	// enabled reports whether the log verbosity allows dumping
+	enabled func() bool
+}
+
+// NewBatchDumper returns a dumper writing to out, only while the log
+// verbosity is at least DumpVerbosity.
+func NewBatchDumper(out io.Writer) *BatchDumper {
+	return &BatchDumper{
+		out:     out,
+		enabled: func() bool { return klog.V(DumpVerbosity).Enabled() },
+	}
+}
+
+// Dump writes the batch of the cycle started at cycle.
+func (d *BatchDumper) Dump(cycle time.Time, batch *storage.MetricsBatch) {
+	if !d.enabled() {
+		return
+	}
+	prefix := "scrape cycle=" + formatDumpTime(cycle)
+
+	nodes := make([]storage.NodeMetricsPoint, len(batch.Nodes))
+	copy(nodes, batch.Nodes)
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	pods := make([]storage.PodMetricsPoint, len(batch.Pods))
+	copy(pods, batch.Pods)
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, node := range nodes {
+		fmt.Fprintf(d.out, "%s node=%s %s\n", prefix, node.Name, formatDumpPoint(node.MetricsPoint))
+	}
+	for _, pod := range pods {
+		containers := make([]storage.ContainerMetricsPoint, len(pod.Containers))
+		copy(containers, pod.Containers)
+		sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+		for _, container := range containers {
+			fmt.Fprintf(d.out, "%s namespace=%s pod=%s container=%s %s\n", prefix, pod.Namespace, pod.Name, container.Name, formatDumpPoint(container.MetricsPoint))
+		}
+	}
+}
+
+func formatDumpPoint(point storage.MetricsPoint) string {
+	cpu, memory := "-", "-"
+	if !point.CPUMissing {
+		cpu = fmt.Sprint(point.CpuUsage.ScaledValue(resource.Nano))
+	}
+	if !point.MemoryMissing {
+		memory = fmt.Sprint(point.MemoryUsage.Value())
+	}
+	return fmt.Sprintf("timestamp=%s cpu=%s memory=%s", formatDumpTime(point.Timestamp), cpu, memory)
+}
+
+func formatDumpTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
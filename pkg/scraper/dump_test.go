@@ -0,0 +1,93 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+var _ = Describe("BatchDumper", func() {
+	var (
+		out    *bytes.Buffer
+		dumper *BatchDumper
+		cycle  time.Time
+		batch  *storage.MetricsBatch
+	)
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		dumper = NewBatchDumper(out)
+		dumper.enabled = func() bool { return true }
+		cycle = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+		point := func(cpuNanos, memory int64) storage.MetricsPoint {
+			return storage.MetricsPoint{
+				Timestamp:   cycle.Add(500 * time.Millisecond),
+				CpuUsage:    *resource.NewScaledQuantity(cpuNanos, -9),
+				MemoryUsage: *resource.NewQuantity(memory, resource.BinarySI),
+			}
+		}
+		partial := point(0, 2048)
+		partial.CPUMissing = true
+		batch = &storage.MetricsBatch{
+			Nodes: []storage.NodeMetricsPoint{
+				{Name: "node2", MetricsPoint: partial},
+				{Name: "node1", MetricsPoint: point(1000, 4096)},
+			},
+			Pods: []storage.PodMetricsPoint{
+				{Name: "pod1", Namespace: "ns2", Containers: []storage.ContainerMetricsPoint{
+					{Name: "container1", MetricsPoint: point(10, 20)},
+				}},
+				{Name: "pod1", Namespace: "ns1", Containers: []storage.ContainerMetricsPoint{
+					{Name: "container2", MetricsPoint: point(30, 40)},
+					{Name: "container1", MetricsPoint: point(50, 60)},
+				}},
+			},
+		}
+	})
+
+	It("should write a sorted line per node and container", func() {
+		dumper.Dump(cycle, batch)
+
+		Expect(out.String()).To(Equal(`scrape cycle=2020-10-01T12:00:00Z node=node1 timestamp=2020-10-01T12:00:00.5Z cpu=1000 memory=4096
+scrape cycle=2020-10-01T12:00:00Z node=node2 timestamp=2020-10-01T12:00:00.5Z cpu=- memory=2048
+scrape cycle=2020-10-01T12:00:00Z namespace=ns1 pod=pod1 container=container1 timestamp=2020-10-01T12:00:00.5Z cpu=50 memory=60
+scrape cycle=2020-10-01T12:00:00Z namespace=ns1 pod=pod1 container=container2 timestamp=2020-10-01T12:00:00.5Z cpu=30 memory=40
+scrape cycle=2020-10-01T12:00:00Z namespace=ns2 pod=pod1 container=container1 timestamp=2020-10-01T12:00:00.5Z cpu=10 memory=20
+`))
+	})
+
+	It("should leave the batch unmodified", func() {
+		dumper.Dump(cycle, batch)
+
+		Expect(batch.Nodes[0].Name).To(Equal("node2"))
+		Expect(batch.Pods[0].Namespace).To(Equal("ns2"))
+		Expect(batch.Pods[1].Containers[0].Name).To(Equal("container2"))
+	})
+
+	It("should write nothing below the dump verbosity", func() {
+		dumper.enabled = func() bool { return false }
+
+		dumper.Dump(cycle, batch)
+
+		Expect(out.Len()).To(BeZero())
+	})
+})
@@ -0,0 +1,121 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+)
+
+// DuplicateSeriesPolicy decides which series of a container reported more
+// than once in a summary is kept.
+type DuplicateSeriesPolicy string
+
+const (
+	// DuplicateSeriesFirst keeps the series reported first.
+	DuplicateSeriesFirst DuplicateSeriesPolicy = "first"
+	// DuplicateSeriesLast keeps the series reported last.
+	DuplicateSeriesLast DuplicateSeriesPolicy = "last"
+	// DuplicateSeriesMax keeps the highest CPU usage and the highest memory
+	// usage among the series, which may come from different series.
+	DuplicateSeriesMax DuplicateSeriesPolicy = "max"
+)
+
+var duplicateSeries = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "kubelet",
+		Name:      "duplicate_series_total",
+		Help:      "Number of container series dropped from Kubelet summaries because the container was reported more than once",
+	},
+	[]string{"node"},
+)
+
+// dedupSeries merges pods reported more than once in the summary, and keeps
+// one series per container according to the policy, the first if empty.
+// Pods are matched by namespace and name, not UID, like the storage does.
+// Memory is compared using the series selected by memoryMetric. It returns
+// the number of container series dropped.
+func dedupSeries(summary *Summary, policy DuplicateSeriesPolicy, memoryMetric MemoryMetric) int {
+	podIndex := make(map[apitypes.NamespacedName]int, len(summary.Pods))
+	pods := summary.Pods[:0]
+	for _, pod := range summary.Pods {
+		ident := apitypes.NamespacedName{Namespace: pod.PodRef.Namespace, Name: pod.PodRef.Name}
+		if i, found := podIndex[ident]; found {
+			pods[i].Containers = append(pods[i].Containers, pod.Containers...)
+			continue
+		}
+		podIndex[ident] = len(pods)
+		pods = append(pods, pod)
+	}
+	summary.Pods = pods
+
+	dropped := 0
+	for i := range summary.Pods {
+		containers := summary.Pods[i].Containers
+		containerIndex := make(map[string]int, len(containers))
+		kept := make([]ContainerStats, 0, len(containers))
+		for _, container := range containers {
+			j, found := containerIndex[container.Name]
+			if !found {
+				containerIndex[container.Name] = len(kept)
+				kept = append(kept, container)
+				continue
+			}
+			dropped++
+			switch policy {
+			case DuplicateSeriesLast:
+				kept[j] = container
+			case DuplicateSeriesMax:
+				kept[j] = maxSeries(kept[j], container, memoryMetric)
+			}
+		}
+		summary.Pods[i].Containers = kept
+	}
+	return dropped
+}
+
+// maxSeries returns the CPU stats with the highest usage and the memory
+// stats with the highest usage of both series.
+func maxSeries(a, b ContainerStats, memoryMetric MemoryMetric) ContainerStats {
+	if cpuUsage(b.CPU) > cpuUsage(a.CPU) {
+		a.CPU = b.CPU
+	}
+	if memoryUsage(b.Memory, memoryMetric) > memoryUsage(a.Memory, memoryMetric) {
+		a.Memory = b.Memory
+	}
+	return a
+}
+
+func cpuUsage(stats *CPUStats) uint64 {
+	if stats == nil || stats.UsageNanoCores == nil {
+		return 0
+	}
+	return *stats.UsageNanoCores
+}
+
+func memoryUsage(stats *MemoryStats, metric MemoryMetric) uint64 {
+	if stats == nil {
+		return 0
+	}
+	usage := stats.WorkingSetBytes
+	if metric == MemoryMetricRSS {
+		usage = stats.RSSBytes
+	}
+	if usage == nil {
+		return 0
+	}
+	return *usage
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Duplicate series", func() {
+	var summary *Summary
+	BeforeEach(func() {
+		now := time.Now()
+		summary = &Summary{Pods: []PodStats{
+			podStats("ns1", "pod1",
+				containerStats("app", 300, 100, now),
+				containerStats("sidecar", 10, 10, now),
+				containerStats("app", 100, 400, now),
+			),
+			podStats("ns1", "pod2", containerStats("app", 50, 50, now)),
+			podStats("ns1", "pod1", containerStats("app", 200, 200, now)),
+		}}
+	})
+
+	It("should keep the first series by default", func() {
+		Expect(dedupSeries(summary, "", MemoryMetricWorkingSet)).To(Equal(2))
+		Expect(summary.Pods).To(HaveLen(2))
+		Expect(summary.Pods[0].Containers).To(HaveLen(2))
+		Expect(*summary.Pods[0].Containers[0].CPU.UsageNanoCores).To(BeEquivalentTo(300))
+		Expect(*summary.Pods[0].Containers[0].Memory.WorkingSetBytes).To(BeEquivalentTo(100))
+		Expect(summary.Pods[1].PodRef.Name).To(Equal("pod2"))
+	})
+
+	It("should keep the last series", func() {
+		Expect(dedupSeries(summary, DuplicateSeriesLast, MemoryMetricWorkingSet)).To(Equal(2))
+		Expect(*summary.Pods[0].Containers[0].CPU.UsageNanoCores).To(BeEquivalentTo(200))
+		Expect(*summary.Pods[0].Containers[0].Memory.WorkingSetBytes).To(BeEquivalentTo(200))
+	})
+
+	It("should keep the highest usage of each resource", func() {
+		Expect(dedupSeries(summary, DuplicateSeriesMax, MemoryMetricWorkingSet)).To(Equal(2))
+		Expect(*summary.Pods[0].Containers[0].CPU.UsageNanoCores).To(BeEquivalentTo(300))
+		Expect(*summary.Pods[0].Containers[0].Memory.WorkingSetBytes).To(BeEquivalentTo(400))
+	})
+
+	It("should merge pods sharing a name whatever their UID", func() {
+		summary.Pods[0].PodRef.UID = "uid1"
+		summary.Pods[2].PodRef.UID = "uid2"
+		Expect(dedupSeries(summary, "", MemoryMetricWorkingSet)).To(Equal(2))
+		Expect(summary.Pods).To(HaveLen(2))
+		Expect(summary.Pods[0].PodRef.UID).To(Equal("uid1"))
+	})
+
+	It("should leave summaries without duplicates unchanged", func() {
+		now := time.Now()
+		summary = &Summary{Pods: []PodStats{
+			podStats("ns1", "pod1", containerStats("app", 300, 100, now)),
+			podStats("ns2", "pod1", containerStats("app", 100, 400, now)),
+		}}
+		Expect(dedupSeries(summary, DuplicateSeriesMax, MemoryMetricWorkingSet)).To(Equal(0))
+		Expect(summary.Pods).To(HaveLen(2))
+		Expect(*summary.Pods[1].Containers[0].CPU.UsageNanoCores).To(BeEquivalentTo(100))
+	})
+})
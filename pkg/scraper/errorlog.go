@@ -0,0 +1,72 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// errorLog logs the scrape errors of each node, logging an error unchanged
+// since it was last logged at most once per interval, along with the number
+// of times it repeated in between.
+type errorLog struct {
+	interval time.Duration
+	// logf logs a line at error level, klog.Errorf if nil.
+	logf func(format string, args ...interface{})
+}
+
+// loggedError is the latest error logged for a node.
+type loggedError struct {
+	message string
+	logged  time.Time
+	// repeated counts the occurrences of the error since it was logged.
+	repeated int
+}
+
+// record logs the outcome of a scrape of the node unless it failed with the
+// error last logged for it less than the interval ago. A changed error is
+// logged right away, and a success forgets the error of the node.
+func (l *errorLog) record(state *nodeState, result nodeResult, now time.Time) {
+	logged := state.loggedError
+	if result.err == nil {
+		if logged != nil && logged.repeated > 0 {
+			l.log("node %s recovered after its last error repeated %d times: %s", result.node, logged.repeated, logged.message)
+		}
+		state.loggedError = nil
+		return
+	}
+	message := result.err.Error()
+	if logged != nil && logged.message == message {
+		if now.Sub(logged.logged) < l.interval {
+			logged.repeated++
+			return
+		}
+		l.log("%s (repeated %d times in the last %s)", message, logged.repeated+1, now.Sub(logged.logged).Round(time.Second))
+		logged.logged, logged.repeated = now, 0
+		return
+	}
+	l.log("%s", message)
+	state.loggedError = &loggedError{message: message, logged: now}
+}
+
+func (l *errorLog) log(format string, args ...interface{}) {
+	if l.logf != nil {
+		l.logf(format, args...)
+		return
+	}
+	klog.Errorf(format, args...)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Scrape error log", func() {
+	var (
+		lines  []string
+		log    *errorLog
+		states *nodeStates
+		now    time.Time
+	)
+	BeforeEach(func() {
+		lines = nil
+		log = &errorLog{interval: time.Minute, logf: func(format string, args ...interface{}) {
+			lines = append(lines, fmt.Sprintf(format, args...))
+		}}
+		states = &nodeStates{}
+		now = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	})
+	record := func(result nodeResult, now time.Time) {
+		states.update(result.node, func(state *nodeState) { log.record(state, result, now) })
+	}
+	failed := func(node, message string) nodeResult {
+		return nodeResult{node: node, err: fmt.Errorf("%s", message)}
+	}
+
+	It("should log a repeated identical error once within the interval", func() {
+		for i := 0; i < 4; i++ {
+			record(failed("node1", "connection refused"), now.Add(time.Duration(i)*15*time.Second))
+		}
+		Expect(lines).To(Equal([]string{"connection refused"}))
+
+		By("logging the repetitions once the interval elapsed")
+		record(failed("node1", "connection refused"), now.Add(time.Minute))
+		Expect(lines).To(Equal([]string{"connection refused", "connection refused (repeated 4 times in the last 1m0s)"}))
+	})
+
+	It("should log a changed error right away and throttle it anew", func() {
+		record(failed("node1", "connection refused"), now)
+		record(failed("node1", "timeout"), now.Add(time.Second))
+		record(failed("node1", "timeout"), now.Add(2*time.Second))
+		record(failed("node1", "connection refused"), now.Add(3*time.Second))
+		Expect(lines).To(Equal([]string{"connection refused", "timeout", "connection refused"}))
+	})
+
+	It("should throttle the errors of each node separately", func() {
+		record(failed("node1", "connection refused"), now)
+		record(failed("node2", "connection refused"), now)
+		record(failed("node1", "connection refused"), now.Add(time.Second))
+		Expect(lines).To(Equal([]string{"connection refused", "connection refused"}))
+	})
+
+	It("should forget the error of a node scraped successfully", func() {
+		record(failed("node1", "connection refused"), now)
+		record(failed("node1", "connection refused"), now.Add(time.Second))
+		record(nodeResult{node: "node1"}, now.Add(2*time.Second))
+		record(failed("node1", "connection refused"), now.Add(3*time.Second))
+		Expect(lines).To(Equal([]string{
+			"connection refused",
+			"node node1 recovered after its last error repeated 1 times: connection refused",
+			"connection refused",
+		}))
+	})
+
+	It("should forget the error of a node which isn't listed anymore", func() {
+		record(failed("node1", "connection refused"), now)
+		record(failed("node2", "connection refused"), now)
+		states.retain([]*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "node2"}}})
+		Expect(states.nodes).To(HaveLen(1))
+		Expect(states.nodes).To(HaveKey("node2"))
+	})
+})
@@ -0,0 +1,64 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"sort"
+	"time"
+)
+
+// NodeFailure describes a node whose latest scrapes failed.
+type NodeFailure struct {
+	Node string
+	// LastError is the error of the latest scrape.
+	LastError string
+	// ConsecutiveFailures counts the scrapes failed since the last success.
+	ConsecutiveFailures int
+	// Since is the time of the first of the consecutive failures.
+	Since time.Time
+}
+
+// recordFailure tracks the outcome of a node scrape, a success forgets
+// previous failures.
+func (n *nodeState) recordFailure(result nodeResult, now time.Time) {
+	if result.err == nil {
+		n.failure = nil
+		return
+	}
+	if n.failure == nil {
+		n.failure = &NodeFailure{Node: result.node, Since: now}
+	}
+	n.failure.LastError = result.err.Error()
+	n.failure.ConsecutiveFailures++
+}
+
+// failures returns the failing nodes sorted by name.
+func (s *nodeStates) failures() []NodeFailure {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failures := make([]NodeFailure, 0, len(s.nodes))
+	for _, state := range s.nodes {
+		if state.failure != nil {
+			failures = append(failures, *state.failure)
+		}
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].Node < failures[j].Node })
+	return failures
+}
+
+// NodeFailures returns the nodes whose latest scrape failed, sorted by name.
+func (c *scraper) NodeFailures() []NodeFailure {
+	return c.nodes.failures()
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// AddressFallbackClass is a class of scrape errors after which a node is
+// retried on its next address.
+type AddressFallbackClass string
+
+const (
+	// AddressFallbackConnection retries nodes failing to connect.
+	AddressFallbackConnection AddressFallbackClass = "connection"
+	// AddressFallbackTLS retries nodes whose serving certificate fails
+	// verification or which fail the TLS handshake, e.g. because the
+	// certificate has no SAN for the address.
+	AddressFallbackTLS AddressFallbackClass = "tls"
+)
+
+// ParseAddressFallbackClasses parses the names of address fallback classes.
+func ParseAddressFallbackClasses(names []string) ([]AddressFallbackClass, error) {
+	classes := make([]AddressFallbackClass, 0, len(names))
+	for _, name := range names {
+		switch class := AddressFallbackClass(name); class {
+		case AddressFallbackConnection, AddressFallbackTLS:
+			classes = append(classes, class)
+		default:
+			return nil, fmt.Errorf("unknown error class %q, should be %s or %s", name, AddressFallbackConnection, AddressFallbackTLS)
+		}
+	}
+	if len(classes) == 0 {
+		return nil, fmt.Errorf("no error classes selected")
+	}
+	return classes, nil
+}
+
+// getSummaryFromAnyAddress fetches the summary of the node from its resolved
+// address, falling back to the next address by priority as long as scraping
+// fails with an error of the fallback classes and the context isn't done.
+func (kc *kubeletClient) getSummaryFromAnyAddress(ctx context.Context, node *corev1.Node) (*Summary, error) {
+	summary, err := kc.getSummary(ctx, node)
+	for kc.fallsBackOn(err) && ctx.Err() == nil {
+		failed, resolveErr := kc.addrResolver.NodeAddress(node)
+		if resolveErr != nil {
+			break
+		}
+		next, ok := kc.withoutResolvedAddress(node)
+		if !ok {
+			break
+		}
+		addr, resolveErr := kc.addrResolver.NodeAddress(next)
+		if resolveErr != nil || addr == failed {
+			// no other address, or the address doesn't come from the node status
+			break
+		}
+		klog.V(2).InfoS("Failed to scrape Kubelet, trying next address", "node", klog.KObj(node), "address", failed, "nextAddress", addr, "err", err)
+		node = next
+		summary, err = kc.getSummary(ctx, node)
+	}
+	return summary, err
+}
+
+// fallsBackOn returns true if the error is of one of the fallback classes,
+// connection errors only if none are configured.
+func (kc *kubeletClient) fallsBackOn(err error) bool {
+	if err == nil {
+		return false
+	}
+	if len(kc.fallbackOn) == 0 {
+		return isDialError(err)
+	}
+	for _, class := range kc.fallbackOn {
+		switch class {
+		case AddressFallbackConnection:
+			if isDialError(err) {
+				return true
+			}
+		case AddressFallbackTLS:
+			if isTLSError(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTLSError returns true if the error comes from verifying the serving
+// certificate of the Kubelet or from the TLS handshake.
+func isTLSError(err error) bool {
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	return errors.As(err, &hostnameErr) || errors.As(err, &authorityErr) || errors.As(err, &invalidErr) || errors.As(err, &recordErr)
+}
+
+// isDialError returns true if the error comes from failing to connect, so
+// that no request reached the Kubelet.
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// RequestHeader is a header set on every summary request, e.g. a tenant id or
+// routing key required by a gateway in front of Kubelets.
+type RequestHeader struct {
+	Name string
+	// Value is executed against the scraped Node, so that values can use node
+	// fields, e.g. {{.Name}} or {{index .Labels "topology.kubernetes.io/zone"}}.
+	Value *template.Template
+}
+
+// ParseRequestHeaders parses header names mapped to value templates, sorted
+// by name. Errors name the header but never include its value, which may be
+// sensitive.
+func ParseRequestHeaders(headers map[string]string) ([]RequestHeader, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	res := make([]RequestHeader, 0, len(headers))
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the value of header %s as a template", name)
+		}
+		res = append(res, RequestHeader{Name: http.CanonicalHeaderKey(name), Value: tmpl})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res, nil
+}
+
+// setRequestHeaders sets the headers on a summary request for the node.
+func setRequestHeaders(req *http.Request, headers []RequestHeader, node *corev1.Node) error {
+	for _, header := range headers {
+		var value strings.Builder
+		if err := header.Value.Execute(&value, node); err != nil {
+			return fmt.Errorf("unable to render the value of header %s for node %s", header.Name, node.Name)
+		}
+		if strings.ContainsAny(value.String(), "\r\n") {
+			return fmt.Errorf("the value of header %s for node %s contains a line break", header.Name, node.Name)
+		}
+		req.Header.Set(header.Name, value.String())
+	}
+	return nil
+}
@@ -17,9 +17,15 @@ package scraper
 import (
 	"context"
 
+	corev1 "k8s.io/api/core/v1"
+
 	"sigs.k8s.io/metrics-server/pkg/storage"
 )
 
 type Scraper interface {
-	Scrape(ctx context.Context) (*storage.MetricsBatch, error)
+	// ScrapeNodes collects metrics from the given nodes, returning the metrics
+	// of each node that could be at least partially scraped, by node name.
+	// Listed are all nodes of the cluster, of which the given nodes are due,
+	// so that nodes which aren't due keep being tracked until removed.
+	ScrapeNodes(ctx context.Context, nodes, listed []*corev1.Node) (map[string]*storage.MetricsBatch, error)
 }
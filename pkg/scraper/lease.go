@@ -0,0 +1,53 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+)
+
+// nodeLeases decides node liveness from the Leases Kubelets renew in the
+// kube-node-lease namespace, which is cheaper than trying to scrape them.
+type nodeLeases struct {
+	leases coordinationlisters.LeaseNamespaceLister
+	maxAge time.Duration
+}
+
+// stale returns whether the lease of the node wasn't renewed within maxAge.
+// Nodes without a lease, e.g. with Kubelets not reporting one, are never stale.
+func (l *nodeLeases) stale(node *corev1.Node, now time.Time) bool {
+	lease, err := l.leases.Get(node.Name)
+	if err != nil || lease.Spec.RenewTime == nil {
+		return false
+	}
+	return now.Sub(lease.Spec.RenewTime.Time) > l.maxAge
+}
+
+// filter returns the nodes with live leases, and the names of the others.
+func (l *nodeLeases) filter(nodes []*corev1.Node, now time.Time) ([]*corev1.Node, []string) {
+	live := make([]*corev1.Node, 0, len(nodes))
+	var stale []string
+	for _, node := range nodes {
+		if l.stale(node, now) {
+			stale = append(stale, node.Name)
+			continue
+		}
+		live = append(live, node)
+	}
+	return live, stale
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// nodeState is what the scraper tracks of a node across cycles.
+type nodeState struct {
+	// lastSuccess is the time of the latest successful scrape, zero if none.
+	lastSuccess time.Time
+	// failure is set while the latest scrapes failed.
+	failure *NodeFailure
+	// loggedError is the latest error logged, set while the node fails.
+	loggedError *loggedError
+	// allocatable is set once usage was checked against allocatable.
+	allocatable *NodeAllocatable
+	// target is set once the node was scraped.
+	target *ScrapeTarget
+}
+
+// nodeStates tracks the state of each node.
+type nodeStates struct {
+	mu    sync.Mutex
+	nodes map[string]*nodeState
+}
+
+// update calls f with the state of the node, holding the lock.
+func (s *nodeStates) update(node string, f func(state *nodeState)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nodes == nil {
+		s.nodes = map[string]*nodeState{}
+	}
+	state, found := s.nodes[node]
+	if !found {
+		state = &nodeState{}
+		s.nodes[node] = state
+	}
+	f(state)
+}
+
+// retain forgets the nodes which aren't listed or are filtered out, so that
+// removed nodes aren't tracked forever.
+func (s *nodeStates) retain(nodes []*corev1.Node) {
+	listed := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		listed[node.Name] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.nodes {
+		if !listed[name] {
+			delete(s.nodes, name)
+		}
+	}
+}
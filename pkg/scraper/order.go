@@ -0,0 +1,62 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ScrapeOrder decides the order nodes are scraped in within a cycle.
+type ScrapeOrder string
+
+const (
+	// ScrapeOrderListed scrapes nodes in the order they're listed, each
+	// delayed by a random stagger.
+	ScrapeOrderListed ScrapeOrder = "listed"
+	// ScrapeOrderStaleFirst scrapes the nodes whose latest successful scrape
+	// is the oldest first, so that a cycle which can't scrape every node
+	// spreads staleness evenly instead of refreshing the same nodes.
+	ScrapeOrderStaleFirst ScrapeOrder = "stale-first"
+)
+
+// recordSuccess tracks the time of the latest successful scrape of the node.
+func (n *nodeState) recordSuccess(result nodeResult, now time.Time) {
+	if result.err == nil {
+		n.lastSuccess = now
+	}
+}
+
+// staleFirst sorts the nodes by the time of their latest successful scrape,
+// nodes never scraped successfully first. Nodes scraped at the same time keep
+// their order.
+func (s *nodeStates) staleFirst(nodes []*corev1.Node) []*corev1.Node {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lastSuccess := func(node *corev1.Node) time.Time {
+		if state, found := s.nodes[node.Name]; found {
+			return state.lastSuccess
+		}
+		return time.Time{}
+	}
+	sorted := make([]*corev1.Node, len(nodes))
+	copy(sorted, nodes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return lastSuccess(sorted[i]).Before(lastSuccess(sorted[j]))
+	})
+	return sorted
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+
+	"github.com/mailru/easyjson/jlexer"
+	"k8s.io/component-base/metrics"
+)
+
+var partialSummaries = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "kubelet",
+		Name:      "partial_summaries_total",
+		Help:      "Number of truncated Kubelet summaries of which only the pods preceding the truncation were kept",
+	},
+	[]string{"node"},
+)
+
+// errPartialSummary is returned for truncated summaries decoded up to the
+// truncation point.
+var errPartialSummary = fmt.Errorf("partial summary")
+
+// decodeSummaryPrefix decodes the node stats and the pods fully contained in
+// a truncated summary into out. Pods cut off by the truncation are dropped,
+// so that only series parsed completely are kept. It returns false if the
+// node stats themselves are incomplete.
+func decodeSummaryPrefix(data []byte, out *Summary) bool {
+	in := &jlexer.Lexer{Data: data}
+	var (
+		summary  Summary
+		nodeDone bool
+	)
+	in.Delim('{')
+	for in.Ok() && !in.IsDelim('}') {
+		key := in.UnsafeString()
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		switch key {
+		case "node":
+			summary.Node.UnmarshalEasyJSON(in)
+			nodeDone = in.Ok()
+		case "pods":
+			in.Delim('[')
+			for in.Ok() && !in.IsDelim(']') {
+				var pod PodStats
+				pod.UnmarshalEasyJSON(in)
+				if !in.Ok() {
+					break
+				}
+				summary.Pods = append(summary.Pods, pod)
+				in.WantComma()
+			}
+			in.Delim(']')
+		default:
+			in.SkipRecursive()
+		}
+		in.WantComma()
+	}
+	if !nodeDone {
+		return false
+	}
+	*out = summary
+	return true
+}
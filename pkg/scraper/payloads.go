@@ -0,0 +1,92 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Payload is a raw response body received from a Kubelet.
+type Payload struct {
+	Time time.Time
+	Body []byte
+}
+
+// PayloadCache keeps the latest raw summary payload scraped from each node,
+// for debugging what Kubelets actually returned.
+type PayloadCache struct {
+	mu       sync.RWMutex
+	payloads map[string]Payload
+}
+
+func NewPayloadCache() *PayloadCache {
+	return &PayloadCache{payloads: map[string]Payload{}}
+}
+
+// Set stores a copy of body as the latest payload of the node.
+func (c *PayloadCache) Set(node string, body []byte) {
+	payload := Payload{Time: myClock.Now(), Body: append([]byte(nil), body...)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payloads[node] = payload
+}
+
+// Get returns the latest payload of the node, if any.
+func (c *PayloadCache) Get(node string) (Payload, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	payload, found := c.payloads[node]
+	return payload, found
+}
+
+// Retain drops payloads of all nodes not in the given list, bounding the
+// cache to nodes which are still scraped.
+func (c *PayloadCache) Retain(nodes []string) {
+	keep := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		keep[node] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for node := range c.payloads {
+		if !keep[node] {
+			delete(c.payloads, node)
+		}
+	}
+}
+
+// payloadRetainer is implemented by Kubelet clients which cache the raw
+// payloads of nodes.
+type payloadRetainer interface {
+	retainPayloads(nodes []*corev1.Node)
+}
+
+var _ payloadRetainer = (*kubeletClient)(nil)
+
+// retainPayloads drops the cached payloads of nodes not in the given list.
+// Payloads of nodes which failed to parse are kept, so they can be inspected.
+func (kc *kubeletClient) retainPayloads(nodes []*corev1.Node) {
+	if kc.payloads == nil {
+		return
+	}
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Name
+	}
+	kc.payloads.Retain(names)
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+var implausibleUsage = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "kubelet",
+		Name:      "implausible_usage_total",
+		Help:      "Number of node and container usages excluded because they exceeded the node allocatable by more than the configured factor",
+	},
+	[]string{"node", "resource"},
+)
+
+// dropImplausibleUsage marks as missing the usage of the node and containers
+// in the batch exceeding the allocatable of the node by more than factor,
+// which is always bad data. Resources without allocatable are not checked.
+// A node left without any usage is dropped.
+func dropImplausibleUsage(batch *storage.MetricsBatch, node *corev1.Node, factor float64) {
+	cpuMax, cpuChecked := usageLimit(node, corev1.ResourceCPU, factor)
+	memoryMax, memoryChecked := usageLimit(node, corev1.ResourceMemory, factor)
+	if !cpuChecked && !memoryChecked {
+		return
+	}
+	check := func(point *storage.MetricsPoint, what string) {
+		if cpuChecked && !point.CPUMissing && float64(point.CpuUsage.MilliValue()) > cpuMax {
+			klog.Warningf("Excluding CPU usage %s of %s, more than %v times the allocatable of node %s", point.CpuUsage.String(), what, factor, node.Name)
+			implausibleUsage.WithLabelValues(node.Name, string(corev1.ResourceCPU)).Inc()
+			point.CPUMissing = true
+		}
+		if memoryChecked && !point.MemoryMissing && float64(point.MemoryUsage.Value()) > memoryMax {
+			klog.Warningf("Excluding memory usage %s of %s, more than %v times the allocatable of node %s", point.MemoryUsage.String(), what, factor, node.Name)
+			implausibleUsage.WithLabelValues(node.Name, string(corev1.ResourceMemory)).Inc()
+			point.MemoryMissing = true
+		}
+	}
+	nodes := batch.Nodes[:0]
+	for _, point := range batch.Nodes {
+		check(&point.MetricsPoint, "node "+point.Name)
+		if point.CPUMissing && point.MemoryMissing {
+			continue
+		}
+		nodes = append(nodes, point)
+	}
+	batch.Nodes = nodes
+	for i := range batch.Pods {
+		pod := &batch.Pods[i]
+		for j := range pod.Containers {
+			container := &pod.Containers[j]
+			check(&container.MetricsPoint, "container "+container.Name+" in pod "+pod.Namespace+"/"+pod.Name)
+		}
+	}
+}
+
+// usageLimit returns the allocatable of the resource multiplied by factor, in
+// millicores for CPU and bytes for memory, and whether the node has any.
+func usageLimit(node *corev1.Node, name corev1.ResourceName, factor float64) (float64, bool) {
+	allocatable, found := node.Status.Allocatable[name]
+	if !found || allocatable.IsZero() {
+		return 0, false
+	}
+	value := allocatable.Value()
+	if name == corev1.ResourceCPU {
+		value = allocatable.MilliValue()
+	}
+	return float64(value) * factor, true
+}
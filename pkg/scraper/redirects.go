@@ -0,0 +1,59 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// FollowRedirects decides which redirects of Kubelet responses are followed.
+type FollowRedirects string
+
+const (
+	// FollowRedirectsNone refuses every redirect.
+	FollowRedirectsNone FollowRedirects = "none"
+	// FollowRedirectsSameHost follows redirects to the host and port of the
+	// original request, e.g. through a gateway, unless they downgrade HTTPS
+	// to HTTP.
+	FollowRedirectsSameHost FollowRedirects = "same-host"
+	// FollowRedirectsAny follows redirects to any host.
+	FollowRedirectsAny FollowRedirects = "any"
+)
+
+// checkRedirect returns the redirect policy of Kubelet clients, following at
+// most maxRedirects redirects allowed by follow. Refused redirects fail the
+// request, so that the node is reported as failing.
+func checkRedirect(follow FollowRedirects, maxRedirects int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		original := via[0].URL
+		switch follow {
+		case FollowRedirectsAny:
+		case FollowRedirectsSameHost:
+			if req.URL.Host != original.Host {
+				return fmt.Errorf("refusing redirect from %s to another host %s", original.Host, req.URL.Host)
+			}
+			if original.Scheme == "https" && req.URL.Scheme != "https" {
+				return fmt.Errorf("refusing redirect from %s downgrading to %s", original.Scheme, req.URL.Scheme)
+			}
+		default:
+			return fmt.Errorf("refusing redirect to %s", req.URL.Host)
+		}
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return nil
+	}
+}
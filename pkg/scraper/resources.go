@@ -0,0 +1,170 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mailru/easyjson"
+	"github.com/mailru/easyjson/jlexer"
+)
+
+// Resources selects the resources parsed from summaries. The zero value
+// parses all of them.
+type Resources struct {
+	// SkipCPU and SkipMemory leave the CPU or memory series out, without
+	// failing the nodes and containers for missing them.
+	SkipCPU    bool
+	SkipMemory bool
+}
+
+// ParseResources parses a list of resource names, of which at least one must
+// be given.
+func ParseResources(names []string) (Resources, error) {
+	res := Resources{SkipCPU: true, SkipMemory: true}
+	for _, name := range names {
+		switch name {
+		case "cpu":
+			res.SkipCPU = false
+		case "memory":
+			res.SkipMemory = false
+		default:
+			return Resources{}, fmt.Errorf("unknown resource %q, should be cpu or memory", name)
+		}
+	}
+	if res.SkipCPU && res.SkipMemory {
+		return Resources{}, fmt.Errorf("no resources selected")
+	}
+	return res, nil
+}
+
+// filteredSummary parses a summary, skipping the series of the resources
+// left out without decoding them. If parseTimeout is positive, parsing is
+// aborted with errParseTimeout once it takes longer, checked between pods.
+type filteredSummary struct {
+	summary      *Summary
+	resources    Resources
+	parseTimeout time.Duration
+}
+
+// errParseTimeout is returned for summaries taking longer than the parse
+// timeout to parse.
+var errParseTimeout = fmt.Errorf("parse timeout exceeded")
+
+var _ easyjson.Unmarshaler = &filteredSummary{}
+
+// UnmarshalEasyJSON supports easyjson.Unmarshaler interface
+func (s *filteredSummary) UnmarshalEasyJSON(in *jlexer.Lexer) {
+	start := myClock.Now()
+	decodeFields(in, func(key string) {
+		switch key {
+		case "node":
+			node := &s.summary.Node
+			decodeFields(in, func(key string) {
+				switch key {
+				case "nodeName":
+					node.NodeName = in.String()
+				case "cpu", "memory":
+					s.decodeResource(in, key, &node.CPU, &node.Memory)
+				default:
+					in.SkipRecursive()
+				}
+			})
+		case "pods":
+			in.Delim('[')
+			for in.Ok() && !in.IsDelim(']') {
+				if s.parseTimeout > 0 && myClock.Since(start) > s.parseTimeout {
+					in.AddError(errParseTimeout)
+					break
+				}
+				var pod PodStats
+				s.decodePod(in, &pod)
+				s.summary.Pods = append(s.summary.Pods, pod)
+				in.WantComma()
+			}
+			in.Delim(']')
+		default:
+			in.SkipRecursive()
+		}
+	})
+	in.Consumed()
+}
+
+func (s *filteredSummary) decodePod(in *jlexer.Lexer, pod *PodStats) {
+	decodeFields(in, func(key string) {
+		switch key {
+		case "podRef":
+			pod.PodRef.UnmarshalEasyJSON(in)
+		case "containers":
+			in.Delim('[')
+			for in.Ok() && !in.IsDelim(']') {
+				var container ContainerStats
+				decodeFields(in, func(key string) {
+					switch key {
+					case "name":
+						container.Name = in.String()
+					case "cpu", "memory":
+						s.decodeResource(in, key, &container.CPU, &container.Memory)
+					default:
+						in.SkipRecursive()
+					}
+				})
+				pod.Containers = append(pod.Containers, container)
+				in.WantComma()
+			}
+			in.Delim(']')
+		default:
+			in.SkipRecursive()
+		}
+	})
+}
+
+// decodeResource decodes the cpu or memory series named by key, unless the
+// resource is left out.
+func (s *filteredSummary) decodeResource(in *jlexer.Lexer, key string, cpu **CPUStats, memory **MemoryStats) {
+	switch {
+	case key == "cpu" && !s.resources.SkipCPU:
+		*cpu = new(CPUStats)
+		(*cpu).UnmarshalEasyJSON(in)
+	case key == "memory" && !s.resources.SkipMemory:
+		*memory = new(MemoryStats)
+		(*memory).UnmarshalEasyJSON(in)
+	default:
+		in.SkipRecursive()
+	}
+}
+
+// decodeFields calls field with the key of each non-null value of the JSON
+// object at the lexer position. field must consume the value.
+func decodeFields(in *jlexer.Lexer, field func(key string)) {
+	if in.IsNull() {
+		in.Skip()
+		return
+	}
+	in.Delim('{')
+	for in.Ok() && !in.IsDelim('}') {
+		key := in.UnsafeString()
+		in.WantColon()
+		if in.IsNull() {
+			in.Skip()
+			in.WantComma()
+			continue
+		}
+		field(key)
+		in.WantComma()
+	}
+	in.Delim('}')
+}
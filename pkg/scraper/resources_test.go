@@ -0,0 +1,102 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/mailru/easyjson"
+)
+
+var _ = Describe("Scraped resources", func() {
+	var full *Summary
+	BeforeEach(func() {
+		full = &Summary{}
+		Expect(easyjson.Unmarshal([]byte(summary), full)).To(Succeed())
+	})
+
+	It("should parse all resources by default", func() {
+		got := &Summary{}
+		Expect(easyjson.Unmarshal([]byte(summary), &filteredSummary{summary: got})).To(Succeed())
+		Expect(got).To(Equal(full))
+	})
+
+	It("should leave out the series of unscraped resources", func() {
+		got := &Summary{}
+		Expect(easyjson.Unmarshal([]byte(summary), &filteredSummary{summary: got, resources: Resources{SkipCPU: true}})).To(Succeed())
+		Expect(got.Node.NodeName).To(Equal(full.Node.NodeName))
+		Expect(got.Node.CPU).To(BeNil())
+		Expect(got.Node.Memory).To(Equal(full.Node.Memory))
+		Expect(got.Pods).To(HaveLen(len(full.Pods)))
+		for i, pod := range got.Pods {
+			Expect(pod.PodRef).To(Equal(full.Pods[i].PodRef))
+			Expect(pod.Containers).To(HaveLen(len(full.Pods[i].Containers)))
+			for j, container := range pod.Containers {
+				Expect(container.Name).To(Equal(full.Pods[i].Containers[j].Name))
+				Expect(container.CPU).To(BeNil())
+				Expect(container.Memory).To(Equal(full.Pods[i].Containers[j].Memory))
+			}
+		}
+	})
+
+	It("should fail on malformed summaries", func() {
+		err := easyjson.Unmarshal([]byte(`{"node": {"nodeName": "node1", "cpu": {`), &filteredSummary{summary: &Summary{}, resources: Resources{SkipMemory: true}})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should store unscraped resources as missing without dropping points", func() {
+		got := decodeBatch(full, time.Time{}, false, MemoryMetricWorkingSet, Resources{SkipCPU: true}, nil)
+		Expect(got.Nodes).To(HaveLen(1))
+		Expect(got.Nodes[0].CPUMissing).To(BeTrue())
+		Expect(got.Nodes[0].MemoryMissing).To(BeFalse())
+		Expect(got.Pods).To(HaveLen(len(expected.Pods)))
+		for _, pod := range got.Pods {
+			for _, container := range pod.Containers {
+				Expect(container.CPUMissing).To(BeTrue())
+				Expect(container.MemoryMissing).To(BeFalse())
+			}
+		}
+	})
+
+	It("should parse resource names", func() {
+		Expect(ParseResources([]string{"cpu", "memory"})).To(Equal(Resources{}))
+		Expect(ParseResources([]string{"memory"})).To(Equal(Resources{SkipCPU: true}))
+		_, err := ParseResources(nil)
+		Expect(err).To(HaveOccurred())
+		_, err = ParseResources([]string{"storage"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func BenchmarkJSONUnmarshalResources(b *testing.B) {
+	for name, resources := range map[string]Resources{
+		"cpu and memory": {},
+		"memory only":    {SkipCPU: true},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				err := easyjson.Unmarshal([]byte(summary), &filteredSummary{summary: &Summary{}, resources: resources})
+				if err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	}
+}
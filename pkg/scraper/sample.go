@@ -0,0 +1,49 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"hash/fnv"
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// nodeSample scrapes a stable fraction of the nodes, selected by a hash of
+// their names so that the same nodes are selected every cycle.
+type nodeSample struct {
+	fraction float64
+}
+
+// sampled returns whether the node named name is in the sample.
+func (s *nodeSample) sampled(name string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return float64(h.Sum32()) < s.fraction*(math.MaxUint32+1)
+}
+
+// filter returns the sampled nodes, and the names of the others.
+func (s *nodeSample) filter(nodes []*corev1.Node) ([]*corev1.Node, []string) {
+	sampled := make([]*corev1.Node, 0, len(nodes))
+	var skipped []string
+	for _, node := range nodes {
+		if !s.sampled(node.Name) {
+			skipped = append(skipped, node.Name)
+			continue
+		}
+		sampled = append(sampled, node)
+	}
+	return sampled, skipped
+}
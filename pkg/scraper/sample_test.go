@@ -0,0 +1,59 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Node sample", func() {
+	var nodes []*corev1.Node
+	BeforeEach(func() {
+		nodes = make([]*corev1.Node, 1000)
+		for i := range nodes {
+			nodes[i] = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}}
+		}
+	})
+
+	It("should select the same nodes every time", func() {
+		sample := &nodeSample{fraction: 0.3}
+		first, firstSkipped := sample.filter(nodes)
+		second, secondSkipped := sample.filter(nodes)
+		Expect(second).To(Equal(first))
+		Expect(secondSkipped).To(Equal(firstSkipped))
+		Expect(len(first) + len(firstSkipped)).To(Equal(len(nodes)))
+	})
+
+	It("should select roughly the requested fraction of the nodes", func() {
+		for _, fraction := range []float64{0.1, 0.5, 0.9} {
+			sampled, _ := (&nodeSample{fraction: fraction}).filter(nodes)
+			Expect(float64(len(sampled))).To(BeNumerically("~", fraction*float64(len(nodes)), 50), "fraction %v", fraction)
+		}
+	})
+
+	It("should keep the nodes of a smaller fraction in a larger one", func() {
+		small, _ := (&nodeSample{fraction: 0.2}).filter(nodes)
+		large, _ := (&nodeSample{fraction: 0.6}).filter(nodes)
+		for _, node := range small {
+			Expect(large).To(ContainElement(node))
+		}
+	})
+})
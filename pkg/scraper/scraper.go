@@ -21,11 +21,11 @@ import (
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
-	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
 	"k8s.io/component-base/metrics"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/metrics-server/pkg/storage"
 )
@@ -64,15 +64,85 @@ var (
 		},
 		[]string{"node"},
 	)
+	responseBytes = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "kubelet",
+			Name:      "response_bytes_total",
+			Help:      "Number of bytes received in responses from Kubelet API, as transferred on the wire",
+		},
+		[]string{"node"},
+	)
+	duplicateTargetNodes = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "kubelet",
+			Name:      "duplicate_target_nodes",
+			Help:      "Number of nodes sharing their scrape target with another node in the last scrape cycle",
+		},
+	)
+	staleLeaseNodes = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "kubelet",
+			Name:      "stale_lease_nodes",
+			Help:      "Number of nodes skipped in the last scrape cycle because their node lease is stale",
+		},
+	)
+	taintedNodes = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "kubelet",
+			Name:      "tainted_nodes",
+			Help:      "Number of nodes skipped in the last scrape cycle because they bear a skipped taint",
+		},
+	)
+	scrapesInFlight = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "kubelet",
+			Name:      "scrapes_in_flight",
+			Help:      "Number of Kubelet scrapes currently in progress",
+		},
+	)
+	scrapeSlotWaits = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "kubelet",
+			Name:      "scrape_slot_waits_total",
+			Help:      "Number of times a node scrape had to wait for a free slot because of the concurrent scrapes limit",
+		},
+	)
+	droppedPods = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "kubelet",
+			Name:      "dropped_pods_total",
+			Help:      "Number of pods dropped from Kubelet summaries reporting more pods than the per-node limit",
+		},
+		[]string{"node"},
+	)
 )
 
-// RegisterScraperMetrics registers rate, errors, and duration metrics on
-// Kubelet API scrapes.
+// RegisterScraperMetrics registers rate, errors, duration, and response size
+// metrics on Kubelet API scrapes.
 func RegisterScraperMetrics(registrationFunc func(metrics.Registerable) error) error {
 	for _, metric := range []metrics.Registerable{
 		requestDuration,
 		requestTotal,
 		lastRequestTime,
+		responseBytes,
+		duplicateTargetNodes,
+		staleLeaseNodes,
+		taintedNodes,
+		scrapesInFlight,
+		scrapeSlotWaits,
+		droppedPods,
+		partialSummaries,
+		duplicateSeries,
+		implausibleUsage,
+		unscrapedNodes,
+		secondaryFailures,
 	} {
 		err := registrationFunc(metric)
 		if err != nil {
@@ -82,18 +152,188 @@ func RegisterScraperMetrics(registrationFunc func(metrics.Registerable) error) e
 	return nil
 }
 
-func NewScraper(nodeLister v1listers.NodeLister, client KubeletInterface, scrapeTimeout time.Duration) *scraper {
+// NewScraper returns a scraper of nodes. If maxConcurrentScrapes is positive,
+// at most that many nodes are scraped at the same time.
+func NewScraper(client KubeletInterface, scrapeTimeout time.Duration, maxConcurrentScrapes int) *scraper {
+	var slots chan struct{}
+	if maxConcurrentScrapes > 0 {
+		slots = make(chan struct{}, maxConcurrentScrapes)
+	}
 	return &scraper{
-		nodeLister:    nodeLister,
 		kubeletClient: client,
 		scrapeTimeout: scrapeTimeout,
+		slots:         slots,
 	}
 }
 
 type scraper struct {
-	nodeLister    v1listers.NodeLister
 	kubeletClient KubeletInterface
 	scrapeTimeout time.Duration
+	// slots limits the number of concurrent scrapes, unlimited if nil.
+	slots chan struct{}
+	// leases, if set, skips nodes whose lease is stale.
+	leases *nodeLeases
+	// taints, if set, skips nodes bearing any of its taint keys.
+	taints *nodeTaints
+	// sample, if set, scrapes only a stable fraction of the nodes.
+	sample *nodeSample
+	// strategies scrape matching nodes with their own client, the first match applies.
+	strategies []ScrapeStrategy
+	// timestampSource decides the timestamps of points, reported by Kubelet if empty.
+	timestampSource TimestampSource
+	// maxContainersPerNode rejects summaries with more containers, unlimited if zero.
+	maxContainersPerNode int
+	// maxPodsPerNode drops the pods of summaries past this many, unlimited if zero.
+	maxPodsPerNode int
+	// singleResourceNodes keeps nodes reporting only one of CPU and memory.
+	singleResourceNodes bool
+	// synthesizeNodes sums the containers of nodes reporting no node metrics.
+	synthesizeNodes bool
+	// cumulativeCPU attaches the cumulative CPU counters of containers to their points.
+	cumulativeCPU bool
+	// maxUsageFactor excludes usage over this multiple of the node
+	// allocatable, unchecked if zero.
+	maxUsageFactor float64
+	// memoryMetric decides the memory series stored, the working set if empty.
+	memoryMetric MemoryMetric
+	// duplicatePolicy decides the series kept of containers reported more
+	// than once, the first if empty.
+	duplicatePolicy DuplicateSeriesPolicy
+	// resources selects the resources decoded, all of them if empty.
+	resources Resources
+	// cpuStaleness and memoryStaleness mark the resource as missing when its
+	// series was sampled longer ago than this, unchecked if zero.
+	cpuStaleness    time.Duration
+	memoryStaleness time.Duration
+	// nodes tracks the failures, allocatable, target and latest successful
+	// scrape of each node.
+	nodes nodeStates
+	// staleFirst scrapes the nodes whose latest successful scrape is the
+	// oldest first.
+	staleFirst bool
+	// errorLog, if set, logs the errors of each node, throttling repeated ones.
+	errorLog *errorLog
+}
+
+// SetNodeLeases makes the scraper skip nodes whose lease in the given lister
+// wasn't renewed within maxAge. Nodes without a lease are still scraped, and
+// so are nodes with a fresh lease, whatever their Ready condition.
+func (c *scraper) SetNodeLeases(leases coordinationlisters.LeaseNamespaceLister, maxAge time.Duration) {
+	c.leases = &nodeLeases{leases: leases, maxAge: maxAge}
+}
+
+// SetScrapeStrategies makes the scraper use the client of the first matching
+// strategy for each node, and the Kubelet client for nodes matching none.
+func (c *scraper) SetScrapeStrategies(strategies []ScrapeStrategy) {
+	c.strategies = strategies
+}
+
+// SetSkipTaints makes the scraper skip nodes bearing a taint with any of the
+// given keys, so that their metrics are reported as missing.
+func (c *scraper) SetSkipTaints(keys []string) {
+	if len(keys) == 0 {
+		c.taints = nil
+		return
+	}
+	c.taints = &nodeTaints{keys: sets.NewString(keys...)}
+}
+
+// SetNodeSampleFraction makes the scraper scrape only the given fraction of
+// the nodes, selected by a hash of their names so that the same nodes are
+// scraped every cycle. Fractions outside (0, 1) scrape all nodes.
+func (c *scraper) SetNodeSampleFraction(fraction float64) {
+	if fraction <= 0 || fraction >= 1 {
+		c.sample = nil
+		return
+	}
+	c.sample = &nodeSample{fraction: fraction}
+}
+
+// SetStalenessThresholds makes the scraper leave out the CPU or memory usage
+// of nodes and containers whose series Kubelet sampled longer than the given
+// threshold before the scrape, keeping the other resource. Zero thresholds
+// aren't checked.
+func (c *scraper) SetStalenessThresholds(cpu, memory time.Duration) {
+	c.cpuStaleness = cpu
+	c.memoryStaleness = memory
+}
+
+// SetScrapeOrder decides the order nodes are scraped in. Staggering keeps
+// the order of stale first scrapes, delaying each node by its position.
+func (c *scraper) SetScrapeOrder(order ScrapeOrder) {
+	c.staleFirst = order == ScrapeOrderStaleFirst
+}
+
+// SetTimestampSource decides whether points are timestamped with the time
+// reported by Kubelet, or the time their summary was received.
+func (c *scraper) SetTimestampSource(source TimestampSource) {
+	c.timestampSource = source
+}
+
+// SetMaxContainersPerNode makes the scraper reject nodes reporting more than
+// max containers, to protect storage from malformed summaries.
+func (c *scraper) SetMaxContainersPerNode(max int) {
+	c.maxContainersPerNode = max
+}
+
+// SetMaxPodsPerNode makes the scraper keep only the first max pods of nodes
+// reporting more, so that a single over-dense node can't delay the cycle.
+func (c *scraper) SetMaxPodsPerNode(max int) {
+	c.maxPodsPerNode = max
+}
+
+// SetSingleResourceNodes decides whether nodes reporting only one of CPU and
+// memory are kept with the other resource marked as missing, or dropped.
+func (c *scraper) SetSingleResourceNodes(allow bool) {
+	c.singleResourceNodes = allow
+}
+
+// SetErrorLogInterval makes the scraper log the errors of each node itself,
+// logging an unchanged error at most once per interval with a count of its
+// repetitions. Zero leaves logging errors to the caller.
+func (c *scraper) SetErrorLogInterval(interval time.Duration) {
+	if interval <= 0 {
+		c.errorLog = nil
+		return
+	}
+	c.errorLog = &errorLog{interval: interval}
+}
+
+// SetCumulativeCPU decides whether container points carry the cumulative CPU
+// counter reported by Kubelet besides the CPU usage rate.
+func (c *scraper) SetCumulativeCPU(cumulative bool) {
+	c.cumulativeCPU = cumulative
+}
+
+// SetSynthesizeNodeMetrics decides whether nodes reporting only container
+// metrics get node metrics summing their containers.
+func (c *scraper) SetSynthesizeNodeMetrics(synthesize bool) {
+	c.synthesizeNodes = synthesize
+}
+
+// SetMaxUsageOverAllocatable excludes the usage of nodes and containers
+// exceeding the allocatable of the node by more than factor. Zero disables
+// the check.
+func (c *scraper) SetMaxUsageOverAllocatable(factor float64) {
+	c.maxUsageFactor = factor
+}
+
+// SetMemoryMetric decides whether the working set or the resident set size
+// reported by Kubelet is stored as memory usage.
+func (c *scraper) SetMemoryMetric(metric MemoryMetric) {
+	c.memoryMetric = metric
+}
+
+// SetResources decides which resources are decoded from summaries. The
+// others are stored as missing.
+func (c *scraper) SetResources(resources Resources) {
+	c.resources = resources
+}
+
+// SetDuplicateSeriesPolicy decides which series is stored for containers
+// reported more than once in a summary.
+func (c *scraper) SetDuplicateSeriesPolicy(policy DuplicateSeriesPolicy) {
+	c.duplicatePolicy = policy
 }
 
 var _ Scraper = (*scraper)(nil)
@@ -105,19 +345,36 @@ type NodeInfo struct {
 	ConnectAddress string
 }
 
-func (c *scraper) Scrape(baseCtx context.Context) (*storage.MetricsBatch, error) {
-	nodes, err := c.nodeLister.List(labels.Everything())
-	var errs []error
-	if err != nil {
-		// save the error, and continue on in case of partial results
-		errs = append(errs, err)
+// nodeResult is the outcome of scraping a single node.
+type nodeResult struct {
+	node     string
+	metrics  *storage.MetricsBatch
+	err      error
+	duration time.Duration
+	// noAddress is set for nodes which failed without an address to scrape
+	noAddress bool
+	// target is where the node was scraped, without an address if unknown
+	target ScrapeTarget
+}
+
+func (c *scraper) ScrapeNodes(baseCtx context.Context, nodes, listed []*corev1.Node) (map[string]*storage.MetricsBatch, error) {
+	eligible := c.filterNodes(listed)
+	due := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		due[node.Name] = true
 	}
+	requested := len(nodes)
+	nodes = make([]*corev1.Node, 0, len(nodes))
+	for _, node := range eligible {
+		if due[node.Name] {
+			nodes = append(nodes, node)
+		}
+	}
+	unscraped := unscrapedCount{excluded: requested - len(nodes)}
 	klog.V(1).Infof("Scraping metrics from %v nodes", len(nodes))
 
-	responseChannel := make(chan *storage.MetricsBatch, len(nodes))
-	errChannel := make(chan error, len(nodes))
-	defer close(responseChannel)
-	defer close(errChannel)
+	results := make(chan nodeResult, len(nodes))
+	defer close(results)
 
 	startTime := myClock.Now()
 
@@ -127,46 +384,181 @@ func (c *scraper) Scrape(baseCtx context.Context) (*storage.MetricsBatch, error)
 		delayMs = maxDelayMs
 	}
 
-	for _, node := range nodes {
-		go func(node *corev1.Node) {
+	if c.staleFirst {
+		nodes = c.nodes.staleFirst(nodes)
+	}
+	for i, node := range nodes {
+		go func(i int, node *corev1.Node) {
 			// Prevents network congestion.
 			sleepDuration := time.Duration(rand.Intn(delayMs)) * time.Millisecond
+			if c.staleFirst {
+				// spread the nodes over the same delay, keeping their order
+				// when they wait for a scrape slot
+				sleepDuration = time.Duration(i*delayMs/len(nodes)) * time.Millisecond
+			}
 			time.Sleep(sleepDuration)
 			// make the timeout a bit shorter to account for staggering, so we still preserve
 			// the overall timeout
 			ctx, cancelTimeout := context.WithTimeout(baseCtx, c.scrapeTimeout-sleepDuration)
 			defer cancelTimeout()
 
-			klog.V(2).Infof("Querying source: %s", node)
-			metrics, err := c.collectNode(ctx, node)
+			var metrics *storage.MetricsBatch
+			nodeStart := myClock.Now()
+			err := c.acquireSlot(ctx)
+			if err == nil {
+				klog.V(2).Infof("Querying source: %s", node)
+				metrics, err = c.collectNode(ctx, node)
+				c.releaseSlot()
+			}
+			if metrics != nil {
+				for i := range metrics.Nodes {
+					metrics.Nodes[i].ProviderID = node.Spec.ProviderID
+				}
+			}
+			var noAddress bool
 			if err != nil {
+				if checker, ok := c.clientFor(node).(addressChecker); ok {
+					noAddress = !checker.hasAddress(node)
+				}
 				err = fmt.Errorf("unable to fully scrape metrics from node %s: %v", node.Name, err)
 			}
-			responseChannel <- metrics
-			errChannel <- err
-		}(node)
+			var target ScrapeTarget
+			if describer, ok := c.clientFor(node).(targetDescriber); ok {
+				target = describer.describeTarget(node)
+			}
+			results <- nodeResult{node: node.Name, metrics: metrics, err: err, duration: myClock.Since(nodeStart), noAddress: noAddress, target: target}
+		}(i, node)
 	}
 
-	res := &storage.MetricsBatch{}
-
+	res := make(map[string]*storage.MetricsBatch, len(nodes))
+	var errs []error
+	var summary scrapeSummary
 	for range nodes {
-		err := <-errChannel
-		srcBatch := <-responseChannel
-		if err != nil {
-			errs = append(errs, err)
+		result := <-results
+		summary.add(result)
+		unscraped.add(result)
+		now := myClock.Now()
+		c.nodes.update(result.node, func(state *nodeState) {
+			state.recordFailure(result, now)
+			state.recordTarget(result, now)
+			state.recordSuccess(result, now)
+			if c.errorLog != nil {
+				c.errorLog.record(state, result, now)
+			}
+		})
+		if result.err != nil {
+			errs = append(errs, result.err)
 			// NB: partial node results are still worth saving, so
 			// don't skip storing results if we got an error
 		}
-		if srcBatch == nil {
+		if result.metrics == nil {
 			continue
 		}
+		res[result.node] = result.metrics
+	}
+	summary.log(myClock.Since(startTime))
+	unscraped.record()
+	c.nodes.retain(eligible)
+	if retainer, ok := c.kubeletClient.(payloadRetainer); ok {
+		retainer.retainPayloads(eligible)
+	}
+	return res, utilerrors.NewAggregate(errs)
+}
+
+// filterNodes returns the listed nodes which are scraped, leaving out nodes
+// excluded by configuration or sharing a scrape target with another node.
+func (c *scraper) filterNodes(nodes []*corev1.Node) []*corev1.Node {
+	if c.leases != nil {
+		var stale []string
+		nodes, stale = c.leases.filter(nodes, myClock.Now())
+		staleLeaseNodes.Set(float64(len(stale)))
+		if len(stale) > 0 {
+			klog.V(1).Infof("Skipping %d nodes with stale leases: %v", len(stale), stale)
+		}
+	}
+	if c.taints != nil {
+		var tainted []string
+		nodes, tainted = c.taints.filter(nodes)
+		taintedNodes.Set(float64(len(tainted)))
+		if len(tainted) > 0 {
+			klog.V(1).Infof("Skipping %d tainted nodes: %v", len(tainted), tainted)
+		}
+	}
+	if c.sample != nil {
+		var skipped []string
+		nodes, skipped = c.sample.filter(nodes)
+		klog.V(2).Infof("Skipping %d nodes outside the sample: %v", len(skipped), skipped)
+	}
+	if resolver, ok := c.kubeletClient.(duplicateResolver); ok {
+		var duplicates []string
+		nodes, duplicates = resolver.resolveDuplicates(nodes)
+		duplicateTargetNodes.Set(float64(len(duplicates)))
+	}
+	return nodes
+}
 
-		res.Nodes = append(res.Nodes, srcBatch.Nodes...)
-		res.Pods = append(res.Pods, srcBatch.Pods...)
+// scrapeSummary aggregates the results of the nodes scraped in one cycle.
+type scrapeSummary struct {
+	nodes, succeeded, failed, pods int
+	slowestNode                    string
+	slowestDuration                time.Duration
+}
+
+func (s *scrapeSummary) add(result nodeResult) {
+	s.nodes++
+	if result.err != nil {
+		s.failed++
+	} else {
+		s.succeeded++
+	}
+	var pods int
+	if result.metrics != nil {
+		pods = len(result.metrics.Pods)
 	}
+	s.pods += pods
+	if result.duration >= s.slowestDuration {
+		s.slowestNode, s.slowestDuration = result.node, result.duration
+	}
+	klog.V(2).InfoS("Scraped node", "node", result.node, "duration", result.duration, "pods", pods, "err", result.err)
+}
 
-	klog.V(1).Infof("ScrapeMetrics: time: %s, nodes: %v, pods: %v", myClock.Since(startTime), len(res.Nodes), len(res.Pods))
-	return res, utilerrors.NewAggregate(errs)
+// log emits the summary as a single structured line at info level.
+func (s *scrapeSummary) log(duration time.Duration) {
+	klog.InfoS("Scrape cycle finished",
+		"nodes", s.nodes,
+		"succeeded", s.succeeded,
+		"failed", s.failed,
+		"pods", s.pods,
+		"duration", duration,
+		"slowestNode", s.slowestNode,
+		"slowestNodeDuration", s.slowestDuration,
+	)
+}
+
+// acquireSlot waits until the node can be scraped without exceeding the
+// concurrent scrapes limit, or the context is done.
+func (c *scraper) acquireSlot(ctx context.Context) error {
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			scrapeSlotWaits.Inc()
+			select {
+			case c.slots <- struct{}{}:
+			case <-ctx.Done():
+				return fmt.Errorf("timed out waiting for a free scrape slot: %v", ctx.Err())
+			}
+		}
+	}
+	scrapesInFlight.Inc()
+	return nil
+}
+
+func (c *scraper) releaseSlot() {
+	scrapesInFlight.Dec()
+	if c.slots != nil {
+		<-c.slots
+	}
 }
 
 func (c *scraper) collectNode(ctx context.Context, node *corev1.Node) (*storage.MetricsBatch, error) {
@@ -175,14 +567,65 @@ func (c *scraper) collectNode(ctx context.Context, node *corev1.Node) (*storage.
 		requestDuration.WithLabelValues(node.Name).Observe(float64(myClock.Since(startTime)) / float64(time.Second))
 		lastRequestTime.WithLabelValues(node.Name).Set(float64(myClock.Now().Unix()))
 	}()
-	summary, err := c.kubeletClient.GetSummary(ctx, node)
+	summary, err := c.clientFor(node).GetSummary(ctx, node)
 
 	if err != nil {
 		requestTotal.WithLabelValues("false").Inc()
 		return nil, fmt.Errorf("unable to fetch metrics from node %s: %v", node.Name, err)
 	}
 	requestTotal.WithLabelValues("true").Inc()
-	return decodeBatch(summary), nil
+	if summary == nil {
+		klog.V(2).Infof("Node %s reported no metrics", node.Name)
+		return &storage.MetricsBatch{}, nil
+	}
+	if c.maxPodsPerNode > 0 && len(summary.Pods) > c.maxPodsPerNode {
+		dropped := len(summary.Pods) - c.maxPodsPerNode
+		klog.Warningf("Node %s reported %d pods, dropping %d past the maximum of %d", node.Name, len(summary.Pods), dropped, c.maxPodsPerNode)
+		droppedPods.WithLabelValues(node.Name).Add(float64(dropped))
+		capped := *summary
+		capped.Pods = summary.Pods[:c.maxPodsPerNode]
+		summary = &capped
+	}
+	if c.maxContainersPerNode > 0 {
+		if containers := countContainers(summary); containers > c.maxContainersPerNode {
+			return nil, fmt.Errorf("node %s reported %d containers, more than the maximum of %d", node.Name, containers, c.maxContainersPerNode)
+		}
+	}
+	if dropped := dedupSeries(summary, c.duplicatePolicy, c.memoryMetric); dropped > 0 {
+		klog.V(2).Infof("Node %s reported %d duplicate container series", node.Name, dropped)
+		duplicateSeries.WithLabelValues(node.Name).Add(float64(dropped))
+	}
+	var staleness *resourceStaleness
+	if c.cpuStaleness > 0 || c.memoryStaleness > 0 {
+		staleness = &resourceStaleness{now: myClock.Now(), cpu: c.cpuStaleness, memory: c.memoryStaleness}
+	}
+	var receiveTime time.Time
+	if c.timestampSource == TimestampSourceReceive {
+		receiveTime = myClock.Now()
+	} else if _, err := getScrapeTime(summary.Node.CPU, summary.Node.Memory); err != nil && !c.synthesizeNodes {
+		return nil, fmt.Errorf("unable to get timestamp of metrics from node %s: %v", node.Name, err)
+	}
+	batch := decodeBatch(summary, receiveTime, c.singleResourceNodes, c.memoryMetric, c.resources, staleness)
+	if c.synthesizeNodes && synthesizeNodeMetrics(batch, node.Name) {
+		klog.V(2).Infof("Node %s reported no node metrics, storing the sum of its containers", node.Name)
+	}
+	if c.maxUsageFactor > 0 {
+		now := myClock.Now()
+		c.nodes.update(node.Name, func(state *nodeState) { state.recordAllocatable(node, now) })
+		dropImplausibleUsage(batch, node, c.maxUsageFactor)
+	}
+	if c.cumulativeCPU {
+		attachCumulativeCPU(batch, summary)
+	}
+	return batch, nil
+}
+
+func countContainers(summary *Summary) int {
+	count := 0
+	for _, pod := range summary.Pods {
+		count += len(pod.Containers)
+	}
+	return count
 }
 
 type clock interface {
@@ -15,8 +15,12 @@
 package scraper
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -24,10 +28,15 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	coordinationv1 "k8s.io/api/coordination/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	coordinationlisters "k8s.io/client-go/listers/coordination/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/klog/v2"
 
 	"sigs.k8s.io/metrics-server/pkg/storage"
 )
@@ -91,9 +100,9 @@ var _ = Describe("Scraper", func() {
 
 			By("running the scraper with a context timeout of 3*seconds")
 			start := time.Now()
-			scraper := NewScraper(&nodeLister, &client, 3*time.Second)
+			scraper := NewScraper(&client, 3*time.Second, 0)
 			timeoutCtx, doneWithWork := context.WithTimeout(context.Background(), 4*time.Second)
-			dataBatch, errs := scraper.Scrape(timeoutCtx)
+			dataBatch, errs := scrapeAll(timeoutCtx, scraper, &nodeLister)
 			doneWithWork()
 			Expect(errs).NotTo(HaveOccurred())
 
@@ -115,8 +124,8 @@ var _ = Describe("Scraper", func() {
 
 			By("running the source scraper with a scrape timeout of 3 seconds")
 			start := time.Now()
-			scraper := NewScraper(&nodeLister, &client, 3*time.Second)
-			dataBatch, errs := scraper.Scrape(context.Background())
+			scraper := NewScraper(&client, 3*time.Second, 0)
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
 			Expect(errs).To(HaveOccurred())
 
 			By("ensuring that scraping took around 3 seconds")
@@ -134,9 +143,9 @@ var _ = Describe("Scraper", func() {
 
 			By("running the source scraper with a scrape timeout of 5 seconds, but a context timeout of 1 second")
 			start := time.Now()
-			scraper := NewScraper(&nodeLister, &client, 5*time.Second)
+			scraper := NewScraper(&client, 5*time.Second, 0)
 			timeoutCtx, doneWithWork := context.WithTimeout(context.Background(), 1*time.Second)
-			dataBatch, errs := scraper.Scrape(timeoutCtx)
+			dataBatch, errs := scrapeAll(timeoutCtx, scraper, &nodeLister)
 			doneWithWork()
 			Expect(errs).To(HaveOccurred())
 
@@ -162,8 +171,8 @@ var _ = Describe("Scraper", func() {
 		}
 		nodes := fakeNodeLister{nodes: []*corev1.Node{node1}}
 
-		scraper := NewScraper(&nodes, &client, 3*time.Second)
-		_, errs := scraper.Scrape(context.Background())
+		scraper := NewScraper(&client, 3*time.Second, 0)
+		_, errs := scrapeAll(context.Background(), scraper, &nodes)
 		Expect(errs).NotTo(HaveOccurred())
 
 		err := testutil.CollectAndCompare(requestDuration, strings.NewReader(`
@@ -205,23 +214,522 @@ var _ = Describe("Scraper", func() {
 		By("deleting node")
 		nodeLister.nodes[0].Status.Addresses = nil
 		delete(client.metrics, node1)
-		scraper := NewScraper(&nodeLister, &client, 5*time.Second)
+		scraper := NewScraper(&client, 5*time.Second, 0)
 
 		By("running the scraper")
-		dataBatch, errs := scraper.Scrape(context.Background())
+		dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
 		Expect(errs).To(HaveOccurred())
 
 		By("ensuring that all other node were scraped")
 		Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node4", "node-no-host", "node3"}))
 	})
-	It("should gracefully handle list errors", func() {
-		By("setting a fake error from the lister")
-		nodeLister.listErr = fmt.Errorf("something went wrong, expectedly")
-		scraper := NewScraper(&nodeLister, &client, 5*time.Second)
+	It("should track consecutive failures of failing nodes until they succeed", func() {
+		delete(client.metrics, node1)
+		scraper := NewScraper(&client, 5*time.Second, 0)
+
+		By("failing the node twice")
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1, node3}, []*corev1.Node{node1, node3})
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1, node3}, []*corev1.Node{node1, node3})
+		failures := scraper.NodeFailures()
+		Expect(failures).To(HaveLen(1))
+		Expect(failures[0].Node).To(Equal("node1"))
+		Expect(failures[0].ConsecutiveFailures).To(Equal(2))
+		Expect(failures[0].LastError).To(ContainSubstring(`Unknown node "node1"`))
+
+		By("scraping the node successfully")
+		client.metrics[node1] = &Summary{Node: nodeStats(node1, 100, 200, scrapeTime)}
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1}, []*corev1.Node{node1})
+		Expect(scraper.NodeFailures()).To(BeEmpty())
+
+		By("keeping failing nodes which weren't due")
+		delete(client.metrics, node1)
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1}, []*corev1.Node{node1, node3})
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node3}, []*corev1.Node{node1, node3})
+		Expect(scraper.NodeFailures()).To(HaveLen(1))
 
-		By("running the scraper")
-		_, err := scraper.Scrape(context.Background())
+		By("forgetting failing nodes which aren't listed anymore")
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node3}, []*corev1.Node{node3})
+		Expect(scraper.NodeFailures()).To(BeEmpty())
+	})
+	It("should order stale first scrapes by the latest successful scrape of nodes", func() {
+		defer func(previous clock) { myClock = previous }(myClock)
+		start := time.Now()
+		delete(client.metrics, node4)
+		scraper := NewScraper(&client, 5*time.Second, 0)
+		scraper.SetScrapeOrder(ScrapeOrderStaleFirst)
+
+		By("scraping node1 and node3, node4 failing")
+		myClock = mockClock{now: start, later: start}
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1, node3, node4}, []*corev1.Node{node1, node3, node4})
+
+		By("scraping node3 later, node1 failing")
+		delete(client.metrics, node1)
+		myClock = mockClock{now: start.Add(time.Minute), later: start.Add(time.Minute)}
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1, node3, node4}, []*corev1.Node{node1, node3, node4})
+
+		ordered := scraper.nodes.staleFirst([]*corev1.Node{node3, node1, node4})
+		Expect([]string{ordered[0].Name, ordered[1].Name, ordered[2].Name}).To(Equal([]string{"node4", "node1", "node3"}))
+	})
+	It("should keep the latest successful scrape of listed nodes which weren't due", func() {
+		defer func(previous clock) { myClock = previous }(myClock)
+		start := time.Now()
+		scraper := NewScraper(&client, 5*time.Second, 0)
+		scraper.SetScrapeOrder(ScrapeOrderStaleFirst)
+
+		By("scraping node1 and node3")
+		myClock = mockClock{now: start, later: start}
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1, node3}, []*corev1.Node{node1, node3})
+
+		By("scraping only node3 later, node1 listed but not due")
+		myClock = mockClock{now: start.Add(time.Minute), later: start.Add(time.Minute)}
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node3}, []*corev1.Node{node1, node3})
+		Expect(scraper.nodes.nodes["node1"].lastSuccess).To(Equal(start))
+		Expect(scraper.nodes.nodes["node3"].lastSuccess).To(Equal(start.Add(time.Minute)))
+
+		By("forgetting nodes which aren't listed anymore")
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node3}, []*corev1.Node{node3})
+		Expect(scraper.nodes.nodes).NotTo(HaveKey("node1"))
+	})
+	It("should scrape nodes reporting metrics before they are Ready", func() {
+		scraper := NewScraper(&client, 5*time.Second, 0)
+
+		Expect(node3.Status.Conditions[0].Status).To(Equal(corev1.ConditionFalse))
+		batches, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node3}, []*corev1.Node{node3})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nodeNames(batches["node3"].Nodes)).To(Equal([]string{"node3"}))
+		Expect(scraper.NodeFailures()).To(BeEmpty())
+	})
+	It("should synthesize node metrics of nodes reporting only container metrics", func() {
+		client.metrics[node4] = &Summary{Pods: []PodStats{
+			podStats("ns1", "pod3",
+				containerStats("container1", 300, 400, scrapeTime.Add(10*time.Millisecond)),
+				containerStats("container2", 500, 600, scrapeTime.Add(20*time.Millisecond))),
+		}}
+		scraper := NewScraper(&client, 5*time.Second, 0)
+
+		By("failing the node by default")
+		_, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node4}, []*corev1.Node{node4})
 		Expect(err).To(HaveOccurred())
+
+		By("summing the containers when enabled")
+		scraper.SetSynthesizeNodeMetrics(true)
+		batches, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node4}, []*corev1.Node{node4})
+		Expect(err).NotTo(HaveOccurred())
+		batch := batches["node4"]
+		Expect(batch.Nodes).To(HaveLen(1))
+		Expect(batch.Nodes[0].Name).To(Equal("node4"))
+		Expect(batch.Nodes[0].Timestamp).To(Equal(scrapeTime.Add(10*time.Millisecond + 2*time.Millisecond)))
+		Expect(batch.Nodes[0].CpuUsage.ScaledValue(-9)).To(BeEquivalentTo(800))
+		Expect(batch.Nodes[0].MemoryUsage.Value()).To(BeEquivalentTo(1000))
+		Expect(batch.Pods).To(HaveLen(1))
+		Expect(batch.Pods[0].Node).To(Equal("node4"))
+
+		By("keeping reported node metrics")
+		batches, err = scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1}, []*corev1.Node{node1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(batches["node1"].Nodes[0].MemoryUsage.Value()).To(BeEquivalentTo(200))
+	})
+	It("should exclude usage exceeding the node allocatable by more than the factor", func() {
+		node := makeNode("node-implausible", "", "10.0.1.8", true)
+		node.Status.Allocatable = corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		}
+		client.metrics[node] = &Summary{
+			Node: NodeStats{
+				NodeName: node.Name,
+				CPU:      cpuStats(1000*1e9, scrapeTime),
+				Memory:   memStats(512*1024*1024, scrapeTime),
+			},
+			Pods: []PodStats{
+				podStats("ns1", "pod1",
+					containerStats("container1", 100*1e6, 1<<50, scrapeTime),
+					containerStats("container2", 100*1e6, 100*1024*1024, scrapeTime)),
+			},
+		}
+		implausibleUsage.Create(nil)
+		implausibleUsage.Reset()
+		scraper := NewScraper(&client, 5*time.Second, 0)
+		scraper.SetMaxUsageOverAllocatable(10)
+
+		batches, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node}, []*corev1.Node{node})
+		Expect(err).NotTo(HaveOccurred())
+		batch := batches["node-implausible"]
+		Expect(batch.Nodes).To(HaveLen(1))
+		Expect(batch.Nodes[0].CPUMissing).To(BeTrue())
+		Expect(batch.Nodes[0].MemoryMissing).To(BeFalse())
+		Expect(batch.Pods[0].Containers[0].CPUMissing).To(BeFalse())
+		Expect(batch.Pods[0].Containers[0].MemoryMissing).To(BeTrue())
+		Expect(batch.Pods[0].Containers[1].MemoryMissing).To(BeFalse())
+		err = testutil.CollectAndCompare(implausibleUsage, strings.NewReader(`
+		# HELP metrics_server_kubelet_implausible_usage_total [ALPHA] Number of node and container usages excluded because they exceeded the node allocatable by more than the configured factor
+		# TYPE metrics_server_kubelet_implausible_usage_total counter
+		metrics_server_kubelet_implausible_usage_total{node="node-implausible",resource="cpu"} 1
+		metrics_server_kubelet_implausible_usage_total{node="node-implausible",resource="memory"} 1
+		`), "metrics_server_kubelet_implausible_usage_total")
+		Expect(err).NotTo(HaveOccurred())
+		allocatables := scraper.NodeAllocatables()
+		Expect(allocatables).To(HaveLen(1))
+		Expect(allocatables[0].Node).To(Equal("node-implausible"))
+		Expect(allocatables[0].Allocatable).To(Equal(node.Status.Allocatable))
+
+		By("keeping listed nodes which weren't due")
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1}, []*corev1.Node{node, node1})
+		Expect(scraper.NodeAllocatables()).To(HaveLen(2))
+
+		By("forgetting nodes which aren't listed anymore")
+		scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1}, []*corev1.Node{node1})
+		Expect(scraper.NodeAllocatables()).To(HaveLen(1))
+		Expect(scraper.NodeAllocatables()[0].Node).To(Equal("node1"))
+
+		By("keeping all usage without the check")
+		scraper.SetMaxUsageOverAllocatable(0)
+		batches, err = scraper.ScrapeNodes(context.Background(), []*corev1.Node{node}, []*corev1.Node{node})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(batches["node-implausible"].Nodes[0].CPUMissing).To(BeFalse())
+	})
+	It("should return the metrics of each given node by name", func() {
+		scraper := NewScraper(&client, 5*time.Second, 0)
+
+		batches, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1, node3}, []*corev1.Node{node1, node3})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(batches).To(HaveLen(2))
+		Expect(nodeNames(batches["node1"].Nodes)).To(Equal([]string{"node1"}))
+		Expect(podNames(batches["node1"].Pods)).To(ConsistOf([]string{"ns1/pod1", "ns1/pod2", "ns2/pod1", "ns3/pod1"}))
+		Expect(nodeNames(batches["node3"].Nodes)).To(Equal([]string{"node3"}))
+		Expect(batches["node3"].Pods).To(BeEmpty())
+	})
+	Context("when using node leases for liveness", func() {
+		var leases cache.Indexer
+
+		lease := func(node string, renewTime time.Time) *coordinationv1.Lease {
+			return &coordinationv1.Lease{
+				ObjectMeta: metav1.ObjectMeta{Name: node, Namespace: corev1.NamespaceNodeLease},
+				Spec:       coordinationv1.LeaseSpec{RenewTime: &metav1.MicroTime{Time: renewTime}},
+			}
+		}
+
+		BeforeEach(func() {
+			staleLeaseNodes.Create(nil)
+			leases = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		})
+
+		It("should skip nodes whose lease is stale", func() {
+			now := myClock.Now()
+			Expect(leases.Add(lease("node1", now))).To(Succeed())
+			Expect(leases.Add(lease("node-no-host", now.Add(-2*time.Minute)))).To(Succeed())
+			Expect(leases.Add(lease("node3", now.Add(-10*time.Second)))).To(Succeed())
+
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			scraper.SetNodeLeases(coordinationlisters.NewLeaseLister(leases).Leases(corev1.NamespaceNodeLease), 40*time.Second)
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).NotTo(HaveOccurred())
+
+			By("scraping nodes with a fresh lease, whether Ready or not, and nodes without a lease")
+			Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node1", "node3", "node4"}))
+			err := testutil.CollectAndCompare(staleLeaseNodes, strings.NewReader(`
+		# HELP metrics_server_kubelet_stale_lease_nodes [ALPHA] Number of nodes skipped in the last scrape cycle because their node lease is stale
+		# TYPE metrics_server_kubelet_stale_lease_nodes gauge
+		metrics_server_kubelet_stale_lease_nodes 1
+		`), "metrics_server_kubelet_stale_lease_nodes")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should scrape nodes again once their lease is renewed", func() {
+			Expect(leases.Add(lease("node1", myClock.Now().Add(-2*time.Minute)))).To(Succeed())
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			scraper.SetNodeLeases(coordinationlisters.NewLeaseLister(leases).Leases(corev1.NamespaceNodeLease), 40*time.Second)
+
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).NotTo(HaveOccurred())
+			Expect(nodeNames(dataBatch.Nodes)).NotTo(ContainElement("node1"))
+
+			Expect(leases.Update(lease("node1", myClock.Now()))).To(Succeed())
+			dataBatch, errs = scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).NotTo(HaveOccurred())
+			Expect(nodeNames(dataBatch.Nodes)).To(ContainElement("node1"))
+		})
+	})
+	Context("when skipping tainted nodes", func() {
+		taint := func(node *corev1.Node, taints ...corev1.Taint) *corev1.Node {
+			tainted := node.DeepCopy()
+			tainted.Spec.Taints = taints
+			client.metrics[tainted] = client.metrics[node]
+			return tainted
+		}
+
+		BeforeEach(func() {
+			taintedNodes.Create(nil)
+			unscrapedNodes.Create(nil)
+			nodeLister.nodes = []*corev1.Node{
+				taint(node1, corev1.Taint{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule}),
+				taint(node2, corev1.Taint{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}),
+				taint(node3, corev1.Taint{Key: "node.kubernetes.io/unreachable", Effect: corev1.TaintEffectNoExecute}),
+				node4,
+			}
+		})
+
+		It("should skip nodes bearing a matching taint", func() {
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			scraper.SetSkipTaints([]string{"node.kubernetes.io/unschedulable", "node.kubernetes.io/unreachable"})
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).NotTo(HaveOccurred())
+
+			By("scraping untainted nodes and nodes with other taints")
+			Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node-no-host", "node4"}))
+			Expect(podNames(dataBatch.Pods)).To(BeEmpty())
+			err := testutil.CollectAndCompare(taintedNodes, strings.NewReader(`
+		# HELP metrics_server_kubelet_tainted_nodes [ALPHA] Number of nodes skipped in the last scrape cycle because they bear a skipped taint
+		# TYPE metrics_server_kubelet_tainted_nodes gauge
+		metrics_server_kubelet_tainted_nodes 2
+		`), "metrics_server_kubelet_tainted_nodes")
+			Expect(err).NotTo(HaveOccurred())
+
+			By("reporting the tainted nodes as excluded from the cycle")
+			expectUnscrapedNodes(2, 0, 0)
+		})
+		It("should scrape all nodes without taint keys", func() {
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			scraper.SetSkipTaints(nil)
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).NotTo(HaveOccurred())
+			Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node1", "node-no-host", "node3", "node4"}))
+		})
+	})
+	Context("when nodes are not scraped", func() {
+		BeforeEach(func() {
+			unscrapedNodes.Create(nil)
+			unscrapedNodes.Reset()
+		})
+		It("should report nodes without an address or failing to be scraped", func() {
+			// a closed port, so that the node with an address is unreachable
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			_, port, err := net.SplitHostPort(listener.Addr().String())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listener.Close()).To(Succeed())
+			portNumber, err := strconv.Atoi(port)
+			Expect(err).NotTo(HaveOccurred())
+			kubeletClient, err := KubeletClientConfig{
+				Scheme:              "http",
+				DefaultPort:         portNumber,
+				AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			}.Complete()
+			Expect(err).NotTo(HaveOccurred())
+			nodeLister.nodes = []*corev1.Node{
+				makeNode("node-unreachable", "", "127.0.0.1", true),
+				makeNode("node-no-address", "node-no-address", "", true),
+			}
+
+			scraper := NewScraper(kubeletClient, 5*time.Second, 0)
+			_, err = scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(err).To(HaveOccurred())
+
+			expectUnscrapedNodes(0, 1, 1)
+		})
+		It("should report no discrepancy when all nodes are scraped", func() {
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			_, err := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(err).NotTo(HaveOccurred())
+
+			expectUnscrapedNodes(0, 0, 0)
+		})
+	})
+	Context("when scraping nodes with strategies", func() {
+		It("should scrape nodes matching a strategy with its client", func() {
+			virtualNode := makeNode("virtual-node", "", "10.0.1.9", true)
+			virtualNode.Labels = map[string]string{"type": "virtual-kubelet"}
+			virtualClient := fakeKubeletClient{
+				metrics: map[*corev1.Node]*Summary{
+					virtualNode: {Node: nodeStats(virtualNode, 100, 200, scrapeTime)},
+				},
+			}
+			selector, err := labels.Parse(VirtualKubeletSelector)
+			Expect(err).NotTo(HaveOccurred())
+			nodeLister.nodes = []*corev1.Node{node1, virtualNode}
+
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			scraper.SetScrapeStrategies([]ScrapeStrategy{{Selector: selector, Client: &virtualClient}})
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).NotTo(HaveOccurred())
+
+			By("scraping the virtual node with the strategy client, and other nodes with the Kubelet client")
+			Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node1", "virtual-node"}))
+			Expect(podNames(dataBatch.Pods)).To(ConsistOf([]string{"ns1/pod1", "ns1/pod2", "ns2/pod1", "ns3/pod1"}))
+		})
+	})
+	Context("when choosing the source of timestamps", func() {
+		var (
+			previous    clock
+			receiveTime = scrapeTime.Add(time.Second)
+		)
+
+		BeforeEach(func() {
+			previous = myClock
+			myClock = mockClock{now: receiveTime, later: receiveTime}
+			By("making node3 report no timestamps")
+			client.metrics[node3] = &Summary{Node: NodeStats{
+				NodeName: node3.Name,
+				CPU:      cpuStats(100, time.Time{}),
+				Memory:   memStats(200, time.Time{}),
+			}}
+		})
+		AfterEach(func() {
+			myClock = previous
+		})
+
+		It("should use the reported timestamps and fail nodes without them by default", func() {
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).To(HaveOccurred())
+			Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node1", "node-no-host", "node4"}))
+			for _, node := range dataBatch.Nodes {
+				Expect(node.Timestamp).NotTo(Equal(receiveTime))
+			}
+		})
+		It("should use the reported timestamps with the series source", func() {
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			scraper.SetTimestampSource(TimestampSourceSeries)
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).To(HaveOccurred())
+			Expect(nodeNames(dataBatch.Nodes)).NotTo(ContainElement("node3"))
+			for _, pod := range dataBatch.Pods {
+				for _, container := range pod.Containers {
+					Expect(container.Timestamp).To(BeTemporally("<", receiveTime))
+				}
+			}
+		})
+		It("should use the receive time with the receive source", func() {
+			scraper := NewScraper(&client, 5*time.Second, 0)
+			scraper.SetTimestampSource(TimestampSourceReceive)
+			dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+			Expect(errs).NotTo(HaveOccurred())
+			Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node1", "node-no-host", "node3", "node4"}))
+			for _, node := range dataBatch.Nodes {
+				Expect(node.Timestamp).To(Equal(receiveTime))
+			}
+			Expect(dataBatch.Pods).NotTo(BeEmpty())
+			for _, pod := range dataBatch.Pods {
+				for _, container := range pod.Containers {
+					Expect(container.Timestamp).To(Equal(receiveTime))
+				}
+			}
+		})
+	})
+	It("should count nodes reporting no metrics as scraped", func() {
+		client.metrics[node3] = nil
+		scraper := NewScraper(&client, 5*time.Second, 0)
+		batches, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node1, node3}, []*corev1.Node{node1, node3})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(batches).To(HaveKey("node3"))
+		Expect(batches["node3"].Nodes).To(BeEmpty())
+		Expect(nodeNames(batches["node1"].Nodes)).To(Equal([]string{"node1"}))
+	})
+	It("should reject nodes reporting more containers than the maximum", func() {
+		By("making node3 report 100 containers")
+		pods := make([]PodStats, 0, 50)
+		for i := 0; i < 50; i++ {
+			pods = append(pods, podStats("phantom", fmt.Sprintf("pod%d", i),
+				containerStats("container1", 100, 200, scrapeTime),
+				containerStats("container2", 100, 200, scrapeTime)))
+		}
+		client.metrics[node3] = &Summary{Node: nodeStats(node3, 100, 200, scrapeTime), Pods: pods}
+
+		scraper := NewScraper(&client, 5*time.Second, 0)
+		scraper.SetMaxContainersPerNode(99)
+		dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+		Expect(errs).To(HaveOccurred())
+		Expect(errs.Error()).To(ContainSubstring("node node3 reported 100 containers, more than the maximum of 99"))
+
+		By("dropping the node and all of its pods")
+		Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node1", "node-no-host", "node4"}))
+		Expect(podNames(dataBatch.Pods)).To(ConsistOf([]string{"ns1/pod1", "ns1/pod2", "ns2/pod1", "ns3/pod1"}))
+
+		By("accepting the node with a maximum matching its containers")
+		scraper.SetMaxContainersPerNode(100)
+		dataBatch, errs = scrapeAll(context.Background(), scraper, &nodeLister)
+		Expect(errs).NotTo(HaveOccurred())
+		Expect(dataBatch.Pods).To(HaveLen(54))
+	})
+	It("should drop the pods of nodes reporting more pods than the maximum", func() {
+		By("making node3 report 1000 pods")
+		pods := make([]PodStats, 0, 1000)
+		for i := 0; i < 1000; i++ {
+			pods = append(pods, podStats("phantom", fmt.Sprintf("pod%d", i),
+				containerStats("container1", 100, 200, scrapeTime)))
+		}
+		client.metrics[node3] = &Summary{Node: nodeStats(node3, 100, 200, scrapeTime), Pods: pods}
+		droppedPods.Create(nil)
+		droppedPods.Reset()
+
+		scraper := NewScraper(&client, 5*time.Second, 0)
+		scraper.SetMaxPodsPerNode(100)
+		batches, err := scraper.ScrapeNodes(context.Background(), []*corev1.Node{node3}, []*corev1.Node{node3})
+		Expect(err).NotTo(HaveOccurred())
+
+		By("keeping the node and the first pods up to the maximum")
+		Expect(nodeNames(batches["node3"].Nodes)).To(Equal([]string{"node3"}))
+		Expect(batches["node3"].Pods).To(HaveLen(100))
+		Expect(batches["node3"].Pods[99].Name).To(Equal("pod99"))
+		err = testutil.CollectAndCompare(droppedPods, strings.NewReader(`
+		# HELP metrics_server_kubelet_dropped_pods_total [ALPHA] Number of pods dropped from Kubelet summaries reporting more pods than the per-node limit
+		# TYPE metrics_server_kubelet_dropped_pods_total counter
+		metrics_server_kubelet_dropped_pods_total{node="node3"} 900
+		`), "metrics_server_kubelet_dropped_pods_total")
+		Expect(err).NotTo(HaveOccurred())
+
+		By("leaving the reported summary untouched")
+		Expect(client.metrics[node3].Pods).To(HaveLen(1000))
+	})
+	It("should log a structured summary of each scrape cycle", func() {
+		defer func(previous clock) { myClock = previous }(myClock)
+		myClock = &realClock{}
+		var logs bytes.Buffer
+		klog.LogToStderr(false)
+		klog.SetOutput(&logs)
+		defer func() {
+			klog.SetOutput(os.Stderr)
+			klog.LogToStderr(true)
+		}()
+
+		By("failing one node and slowing down another")
+		delete(client.metrics, node4)
+		client.delay[node1] = 100 * time.Millisecond
+
+		scraper := NewScraper(&client, 5*time.Second, 0)
+		_, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+		Expect(errs).To(HaveOccurred())
+		klog.Flush()
+
+		Expect(logs.String()).To(ContainSubstring(`"Scrape cycle finished" nodes=4 succeeded=3 failed=1 pods=4 duration=`))
+		Expect(logs.String()).To(ContainSubstring(`slowestNode="node1"`))
+	})
+	It("should count waits for a scrape slot when more nodes than slots are dispatched", func() {
+		scrapeSlotWaits.Create(nil)
+		scrapesInFlight.Create(nil)
+		scrapeSlotWaits.Reset()
+
+		By("limiting scrapes to one at a time, taking longer than the staggering delay")
+		client.defaultDelay = 100 * time.Millisecond
+		scraper := NewScraper(&client, 5*time.Second, 1)
+
+		By("running the scraper")
+		dataBatch, errs := scrapeAll(context.Background(), scraper, &nodeLister)
+		Expect(errs).NotTo(HaveOccurred())
+		Expect(nodeNames(dataBatch.Nodes)).To(ConsistOf([]string{"node1", "node-no-host", "node3", "node4"}))
+
+		By("ensuring that all but the first node waited for a slot")
+		err := testutil.CollectAndCompare(scrapeSlotWaits, strings.NewReader(`
+		# HELP metrics_server_kubelet_scrape_slot_waits_total [ALPHA] Number of times a node scrape had to wait for a free slot because of the concurrent scrapes limit
+		# TYPE metrics_server_kubelet_scrape_slot_waits_total counter
+		metrics_server_kubelet_scrape_slot_waits_total 3
+		`), "metrics_server_kubelet_scrape_slot_waits_total")
+		Expect(err).NotTo(HaveOccurred())
+		err = testutil.CollectAndCompare(scrapesInFlight, strings.NewReader(`
+		# HELP metrics_server_kubelet_scrapes_in_flight [ALPHA] Number of Kubelet scrapes currently in progress
+		# TYPE metrics_server_kubelet_scrapes_in_flight gauge
+		metrics_server_kubelet_scrapes_in_flight 0
+		`), "metrics_server_kubelet_scrapes_in_flight")
+		Expect(err).NotTo(HaveOccurred())
 	})
 })
 
@@ -249,15 +757,23 @@ func (c *fakeKubeletClient) GetSummary(ctx context.Context, node *corev1.Node) (
 	return metrics, nil
 }
 
+// scrapeAll scrapes every listed node, the way a cycle with every node due
+// does, and returns the metrics of all nodes in a single batch.
+func scrapeAll(ctx context.Context, scraper *scraper, lister *fakeNodeLister) (*storage.MetricsBatch, error) {
+	batches, err := scraper.ScrapeNodes(ctx, lister.nodes, lister.nodes)
+	res := &storage.MetricsBatch{}
+	for _, batch := range batches {
+		res.Nodes = append(res.Nodes, batch.Nodes...)
+		res.Pods = append(res.Pods, batch.Pods...)
+	}
+	return res, err
+}
+
 type fakeNodeLister struct {
-	nodes   []*corev1.Node
-	listErr error
+	nodes []*corev1.Node
 }
 
 func (l *fakeNodeLister) List(_ labels.Selector) (ret []*corev1.Node, err error) {
-	if l.listErr != nil {
-		return nil, l.listErr
-	}
 	// NB: this is ignores selector for the moment
 	return l.nodes, nil
 }
@@ -318,3 +834,14 @@ type mockClock struct {
 
 func (c mockClock) Now() time.Time                  { return c.now }
 func (c mockClock) Since(d time.Time) time.Duration { return c.later.Sub(d) }
+
+func expectUnscrapedNodes(excluded, noAddress, unreachable int) {
+	err := testutil.CollectAndCompare(unscrapedNodes, strings.NewReader(fmt.Sprintf(`
+		# HELP metrics_server_kubelet_unscraped_nodes [ALPHA] Number of nodes listed for the last scrape cycle which got no metrics from it, by reason. Nonzero values point to nodes filtered out by configuration or failing to be scraped.
+		# TYPE metrics_server_kubelet_unscraped_nodes gauge
+		metrics_server_kubelet_unscraped_nodes{reason="excluded"} %d
+		metrics_server_kubelet_unscraped_nodes{reason="no_address"} %d
+		metrics_server_kubelet_unscraped_nodes{reason="unreachable"} %d
+		`, excluded, noAddress, unreachable)), "metrics_server_kubelet_unscraped_nodes")
+	Expect(err).NotTo(HaveOccurred())
+}
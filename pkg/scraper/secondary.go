@@ -0,0 +1,159 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/mailru/easyjson"
+	corev1 "k8s.io/api/core/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/component-base/metrics"
+	"k8s.io/klog/v2"
+)
+
+var secondaryFailures = metrics.NewCounterVec(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "kubelet",
+		Name:      "secondary_scrape_failures_total",
+		Help:      "Number of failed scrapes of the secondary target of nodes. The Kubelet scrape of the node is used without the secondary series.",
+	},
+	[]string{"node"},
+)
+
+// secondaryTarget is an exporter serving summaries on each node besides the
+// Kubelet, e.g. a sidecar reporting series Kubelet lacks.
+type secondaryTarget struct {
+	port int
+	path string
+	// resources are the resources merged from the secondary summaries, all
+	// of them if empty.
+	resources Resources
+}
+
+// secondaryTargetOf returns the secondary target of the config, nil if it has
+// none.
+func secondaryTargetOf(config KubeletClientConfig) *secondaryTarget {
+	if config.SecondaryPort <= 0 {
+		return nil
+	}
+	targetPath := config.SecondaryPath
+	if targetPath == "" {
+		targetPath = defaultSummaryPath
+	}
+	return &secondaryTarget{port: config.SecondaryPort, path: targetPath, resources: config.SecondaryResources}
+}
+
+// withSecondary merges the series of the secondary target of the node into
+// the summary scraped from its Kubelet. Failing to scrape the secondary
+// target only leaves the summary as it is.
+func (kc *kubeletClient) withSecondary(ctx context.Context, node *corev1.Node, summary *Summary) *Summary {
+	secondary, err := kc.getSecondarySummary(ctx, node)
+	if err != nil {
+		klog.V(1).InfoS("Failed to scrape secondary target", "node", klog.KObj(node), "err", err)
+		secondaryFailures.WithLabelValues(node.Name).Inc()
+		return summary
+	}
+	if secondary != nil {
+		mergeSecondary(summary, secondary)
+	}
+	return summary
+}
+
+// getSecondarySummary fetches the summary of the secondary target of the
+// node, bounded by the request timeout and streams like Kubelet requests.
+func (kc *kubeletClient) getSecondarySummary(ctx context.Context, node *corev1.Node) (*Summary, error) {
+	url, err := kc.secondaryURL(node)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{}
+	var value easyjson.Unmarshaler = summary
+	if kc.secondary.resources != (Resources{}) || kc.parseTimeout > 0 {
+		value = &filteredSummary{summary: summary, resources: kc.secondary.resources, parseTimeout: kc.parseTimeout}
+	}
+	err = kc.fetch(ctx, node, url, value, nil)
+	switch err {
+	case errNoContent:
+		return nil, nil
+	case errPartialSummary:
+		return summary, nil
+	}
+	return summary, err
+}
+
+// secondaryURL builds the URL of the secondary target of the node, on the
+// address of its Kubelet or through the API server node proxy.
+func (kc *kubeletClient) secondaryURL(node *corev1.Node) (*url.URL, error) {
+	if kc.apiServerURL != nil {
+		u := *kc.apiServerURL
+		u.Path = path.Join(u.Path, "/api/v1/nodes", node.Name+":"+strconv.Itoa(kc.secondary.port), "proxy", kc.secondary.path)
+		return &u, nil
+	}
+	addr, err := kc.addrResolver.NodeAddress(node)
+	if err != nil {
+		return nil, err
+	}
+	return &url.URL{
+		Scheme: kc.scheme,
+		Host:   joinHostPort(addr, kc.secondary.port),
+		Path:   kc.secondary.path,
+	}, nil
+}
+
+// mergeSecondary adds the series of the secondary summary missing from the
+// primary one: the node CPU and memory, pods, containers and the resources
+// of containers. Series of the primary summary are never replaced.
+func mergeSecondary(primary, secondary *Summary) {
+	if primary.Node.CPU == nil {
+		primary.Node.CPU = secondary.Node.CPU
+	}
+	if primary.Node.Memory == nil {
+		primary.Node.Memory = secondary.Node.Memory
+	}
+	pods := make(map[apitypes.NamespacedName]int, len(primary.Pods))
+	for i, pod := range primary.Pods {
+		pods[apitypes.NamespacedName{Namespace: pod.PodRef.Namespace, Name: pod.PodRef.Name}] = i
+	}
+	for _, pod := range secondary.Pods {
+		i, found := pods[apitypes.NamespacedName{Namespace: pod.PodRef.Namespace, Name: pod.PodRef.Name}]
+		if !found {
+			primary.Pods = append(primary.Pods, pod)
+			continue
+		}
+		containers := make(map[string]int, len(primary.Pods[i].Containers))
+		for j, container := range primary.Pods[i].Containers {
+			containers[container.Name] = j
+		}
+		for _, container := range pod.Containers {
+			j, found := containers[container.Name]
+			if !found {
+				primary.Pods[i].Containers = append(primary.Pods[i].Containers, container)
+				continue
+			}
+			target := &primary.Pods[i].Containers[j]
+			if target.CPU == nil {
+				target.CPU = container.CPU
+			}
+			if target.Memory == nil {
+				target.Memory = container.Memory
+			}
+		}
+	}
+}
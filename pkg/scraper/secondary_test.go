@@ -0,0 +1,161 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+const primarySummary = `{
+  "node": {"nodeName": "node1", "cpu": {"time": "2020-10-14T10:00:00Z", "usageNanoCores": 1000}},
+  "pods": [
+    {"podRef": {"name": "pod1", "namespace": "ns1"}, "containers": [
+      {"name": "app", "cpu": {"time": "2020-10-14T10:00:00Z", "usageNanoCores": 100}}
+    ]}
+  ]
+}`
+
+const secondarySummary = `{
+  "node": {"nodeName": "node1",
+    "cpu": {"time": "2020-10-14T10:00:00Z", "usageNanoCores": 9999},
+    "memory": {"time": "2020-10-14T10:00:00Z", "workingSetBytes": 2000}},
+  "pods": [
+    {"podRef": {"name": "pod1", "namespace": "ns1"}, "containers": [
+      {"name": "app",
+        "cpu": {"time": "2020-10-14T10:00:00Z", "usageNanoCores": 9999},
+        "memory": {"time": "2020-10-14T10:00:00Z", "workingSetBytes": 200}},
+      {"name": "exporter", "memory": {"time": "2020-10-14T10:00:00Z", "workingSetBytes": 50}}
+    ]},
+    {"podRef": {"name": "pod2", "namespace": "ns1"}, "containers": [
+      {"name": "app",
+        "cpu": {"time": "2020-10-14T10:00:00Z", "usageNanoCores": 300},
+        "memory": {"time": "2020-10-14T10:00:00Z", "workingSetBytes": 300}}
+    ]}
+  ]
+}`
+
+var _ = Describe("Kubelet client secondary target", func() {
+	var (
+		primary, secondary *httptest.Server
+		secondaryHandler   http.HandlerFunc
+		payloads           *PayloadCache
+		node               *corev1.Node
+	)
+	port := func(server *httptest.Server) int {
+		_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+		Expect(err).NotTo(HaveOccurred())
+		portNumber, err := strconv.Atoi(port)
+		Expect(err).NotTo(HaveOccurred())
+		return portNumber
+	}
+	BeforeEach(func() {
+		secondaryFailures.Create(nil)
+		secondaryFailures.Reset()
+		primary = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(primarySummary))
+		}))
+		secondaryHandler = func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/exporter/summary"))
+			w.Write([]byte(secondarySummary))
+		}
+		secondary = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secondaryHandler(w, r)
+		}))
+		payloads = NewPayloadCache()
+		node = makeNode("node1", "", "127.0.0.1", true)
+	})
+	AfterEach(func() {
+		primary.Close()
+		secondary.Close()
+	})
+	newClient := func(resources Resources) *kubeletClient {
+		client, err := KubeletClientConfig{
+			Scheme:              "http",
+			DefaultPort:         port(primary),
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			RawPayloads:         payloads,
+			SecondaryPort:       port(secondary),
+			SecondaryPath:       "/exporter/summary",
+			SecondaryResources:  resources,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		return client
+	}
+
+	It("should merge series missing from the Kubelet summary", func() {
+		summary, err := newClient(Resources{}).GetSummary(context.Background(), node)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("keeping the series of the Kubelet")
+		Expect(*summary.Node.CPU.UsageNanoCores).To(BeEquivalentTo(1000))
+		Expect(*summary.Pods[0].Containers[0].CPU.UsageNanoCores).To(BeEquivalentTo(100))
+
+		By("adding the resources, containers and pods the Kubelet lacks")
+		Expect(*summary.Node.Memory.WorkingSetBytes).To(BeEquivalentTo(2000))
+		Expect(summary.Pods).To(HaveLen(2))
+		Expect(*summary.Pods[0].Containers[0].Memory.WorkingSetBytes).To(BeEquivalentTo(200))
+		Expect(summary.Pods[0].Containers).To(HaveLen(2))
+		Expect(summary.Pods[0].Containers[1].Name).To(Equal("exporter"))
+		Expect(summary.Pods[1].PodRef.Name).To(Equal("pod2"))
+		Expect(*summary.Pods[1].Containers[0].CPU.UsageNanoCores).To(BeEquivalentTo(300))
+
+		By("keeping the raw payload of the Kubelet only")
+		payload, found := payloads.Get("node1")
+		Expect(found).To(BeTrue())
+		Expect(string(payload.Body)).To(Equal(primarySummary))
+	})
+	It("should only merge allowlisted resources", func() {
+		summary, err := newClient(Resources{SkipCPU: true}).GetSummary(context.Background(), node)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(*summary.Node.Memory.WorkingSetBytes).To(BeEquivalentTo(2000))
+		Expect(summary.Pods[1].Containers[0].CPU).To(BeNil())
+		Expect(*summary.Pods[1].Containers[0].Memory.WorkingSetBytes).To(BeEquivalentTo(300))
+	})
+	It("should return the Kubelet summary if the secondary target fails", func() {
+		secondaryHandler = func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		summary, err := newClient(Resources{}).GetSummary(context.Background(), node)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(summary.Node.Memory).To(BeNil())
+		Expect(summary.Pods).To(HaveLen(1))
+
+		err = testutil.CollectAndCompare(secondaryFailures, strings.NewReader(`
+		# HELP metrics_server_kubelet_secondary_scrape_failures_total [ALPHA] Number of failed scrapes of the secondary target of nodes. The Kubelet scrape of the node is used without the secondary series.
+		# TYPE metrics_server_kubelet_secondary_scrape_failures_total counter
+		metrics_server_kubelet_secondary_scrape_failures_total{node="node1"} 1
+		`), "metrics_server_kubelet_secondary_scrape_failures_total")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should not scrape the secondary target if the Kubelet fails", func() {
+		primary.Close()
+		secondaryHandler = func(w http.ResponseWriter, r *http.Request) {
+			Fail("the secondary target shouldn't be scraped")
+		}
+		_, err := newClient(Resources{}).GetSummary(context.Background(), node)
+		Expect(err).To(HaveOccurred())
+	})
+})
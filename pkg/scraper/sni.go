@@ -0,0 +1,78 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"k8s.io/client-go/rest"
+)
+
+// ServingCertSNI decides the server name Kubelet serving certificates are
+// verified against.
+type ServingCertSNI string
+
+const (
+	// ServingCertSNIAddress verifies serving certificates against the address
+	// used to scrape the Kubelet, as for any HTTPS request.
+	ServingCertSNIAddress ServingCertSNI = "address"
+	// ServingCertSNINodeName verifies serving certificates against the name of
+	// the Node object, for certificates issued for the node DNS name only.
+	ServingCertSNINodeName ServingCertSNI = "nodename"
+)
+
+// nodeNameClients keeps a client per node which sends and verifies the node
+// name as TLS server name. Transports can't change the server name per
+// request, but every node needs its own connections anyway.
+type nodeNameClients struct {
+	config rest.Config
+	// caDir, if set, provides the trusted CA certificates instead of config.
+	caDir *caDir
+	// checkRedirect is the redirect policy of the clients.
+	checkRedirect func(req *http.Request, via []*http.Request) error
+
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+func newNodeNameClients(config rest.Config, caDir *caDir, checkRedirect func(req *http.Request, via []*http.Request) error) *nodeNameClients {
+	return &nodeNameClients{config: config, caDir: caDir, checkRedirect: checkRedirect, clients: map[string]*http.Client{}}
+}
+
+// get returns the client for the given node, creating it on first use.
+func (c *nodeNameClients) get(nodeName string) (*http.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if client, found := c.clients[nodeName]; found {
+		return client, nil
+	}
+	config := c.config
+	config.TLSClientConfig.ServerName = nodeName
+	var transport http.RoundTripper
+	var err error
+	if c.caDir != nil {
+		transport, err = caDirTransport(c.caDir, config)
+	} else {
+		transport, err = rest.TransportFor(&config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to construct transport for node %q: %v", nodeName, err)
+	}
+	client := &http.Client{Transport: transport, CheckRedirect: c.checkRedirect}
+	c.clients[nodeName] = client
+	return client, nil
+}
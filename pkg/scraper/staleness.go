@@ -0,0 +1,48 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"time"
+)
+
+// resourceStaleness drops the CPU or memory usage of points whose series was
+// sampled by Kubelet longer than the resource's threshold before the scrape,
+// so that each resource is served or left out on its own. Cumulative CPU
+// counters and memory gauges are sampled separately, and may lag differently.
+type resourceStaleness struct {
+	// now is the time of the scrape the thresholds are relative to.
+	now time.Time
+	// cpu and memory are the thresholds of each resource, zero disables
+	// the check.
+	cpu    time.Duration
+	memory time.Duration
+}
+
+// cpuStale returns whether the CPU series was sampled too long ago.
+func (s *resourceStaleness) cpuStale(cpu *CPUStats) bool {
+	if s == nil || s.cpu <= 0 || cpu == nil || cpu.Time.IsZero() {
+		return false
+	}
+	return s.now.Sub(cpu.Time.Time) > s.cpu
+}
+
+// memoryStale returns whether the memory series was sampled too long ago.
+func (s *resourceStaleness) memoryStale(memory *MemoryStats) bool {
+	if s == nil || s.memory <= 0 || memory == nil || memory.Time.IsZero() {
+		return false
+	}
+	return s.now.Sub(memory.Time.Time) > s.memory
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// VirtualKubeletSelector selects virtual-kubelet nodes by the type label they
+// are registered with.
+const VirtualKubeletSelector = "type=virtual-kubelet"
+
+// ScrapeStrategy scrapes nodes matching its selector with its own client
+// instead of the Kubelet client, e.g. for virtual-kubelet nodes serving
+// summaries on another endpoint or with another authentication scheme.
+type ScrapeStrategy struct {
+	Selector labels.Selector
+	Client   KubeletInterface
+}
+
+// clientFor returns the client of the first strategy matching the node, or
+// the default client if none does.
+func (c *scraper) clientFor(node *corev1.Node) KubeletInterface {
+	for _, strategy := range c.strategies {
+		if strategy.Selector.Matches(labels.Set(node.Labels)) {
+			return strategy.Client
+		}
+	}
+	return c.kubeletClient
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"context"
+	"sync"
+)
+
+const (
+	// protoHTTP2 and protoHTTP11 are the ALPN protocol names of HTTP/2 and HTTP/1.1.
+	protoHTTP2  = "h2"
+	protoHTTP11 = "http/1.1"
+)
+
+// streamLimiter bounds the number of concurrent requests to each Kubelet, so
+// that multiplexing many requests over a single HTTP/2 connection can't
+// overwhelm it.
+type streamLimiter struct {
+	max   int
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// newStreamLimiter returns nil, leaving requests unbounded, if max is zero.
+func newStreamLimiter(max int) *streamLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &streamLimiter{max: max, hosts: map[string]chan struct{}{}}
+}
+
+// acquire waits for a free stream to the host, returning release to call
+// when the request is done, or an error if ctx is done first.
+func (l *streamLimiter) acquire(ctx context.Context, host string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	streams, found := l.hosts[host]
+	if !found {
+		streams = make(chan struct{}, l.max)
+		l.hosts[host] = streams
+	}
+	l.mu.Unlock()
+
+	select {
+	case streams <- struct{}{}:
+		return func() { <-streams }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
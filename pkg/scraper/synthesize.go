@@ -0,0 +1,71 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+// synthesizeNodeMetrics adds a point for the named node summing the usage of
+// the containers in the batch, if the batch has no node point because the
+// Kubelet reported no usable node series. A resource missing from any
+// container is missing from the node, so that usage is never undercounted.
+// The point gets the earliest container timestamp. It returns whether a
+// point was added.
+func synthesizeNodeMetrics(batch *storage.MetricsBatch, nodeName string) bool {
+	if len(batch.Nodes) != 0 || len(batch.Pods) == 0 {
+		return false
+	}
+	point := storage.NodeMetricsPoint{
+		Name: nodeName,
+		MetricsPoint: storage.MetricsPoint{
+			CpuUsage:    *resource.NewScaledQuantity(0, -9),
+			MemoryUsage: *resource.NewQuantity(0, resource.BinarySI),
+		},
+	}
+	var earliest time.Time
+	for _, pod := range batch.Pods {
+		for _, container := range pod.Containers {
+			if earliest.IsZero() || container.Timestamp.Before(earliest) {
+				earliest = container.Timestamp
+			}
+			if container.CPUMissing {
+				point.CPUMissing = true
+			} else {
+				point.CpuUsage.Add(container.CpuUsage)
+			}
+			if container.MemoryMissing {
+				point.MemoryMissing = true
+			} else {
+				point.MemoryUsage.Add(container.MemoryUsage)
+			}
+		}
+	}
+	if earliest.IsZero() || (point.CPUMissing && point.MemoryMissing) {
+		return false
+	}
+	point.Timestamp = earliest
+	for i := range batch.Pods {
+		if batch.Pods[i].Node == "" {
+			batch.Pods[i].Node = nodeName
+		}
+	}
+	batch.Nodes = append(batch.Nodes, point)
+	return true
+}
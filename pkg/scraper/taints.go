@@ -0,0 +1,51 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// nodeTaints skips nodes bearing any of the given taint keys, e.g. nodes
+// cordoned for maintenance which are about to go down.
+type nodeTaints struct {
+	keys sets.String
+}
+
+// tainted returns whether the node has a taint with one of the keys,
+// whatever its value and effect.
+func (t *nodeTaints) tainted(node *corev1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if t.keys.Has(taint.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the nodes without any of the taints, and the names of the others.
+func (t *nodeTaints) filter(nodes []*corev1.Node) ([]*corev1.Node, []string) {
+	untainted := make([]*corev1.Node, 0, len(nodes))
+	var tainted []string
+	for _, node := range nodes {
+		if t.tainted(node) {
+			tainted = append(tainted, node.Name)
+			continue
+		}
+		untainted = append(untainted, node)
+	}
+	return untainted, tainted
+}
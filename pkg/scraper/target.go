@@ -0,0 +1,52 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ControlPlaneRoleLabel is the role label of control plane nodes.
+const ControlPlaneRoleLabel = "node-role.kubernetes.io/control-plane"
+
+// ScrapeTargetOverride changes how the Kubelets of nodes matching its
+// selector are scraped, e.g. for hardened control plane nodes.
+type ScrapeTargetOverride struct {
+	Selector labels.Selector
+	// Scheme, if not empty, replaces the scheme used for Kubelets.
+	Scheme string
+	// Port, if positive, replaces the Kubelet port, including the port
+	// reported in the node status.
+	Port int
+}
+
+// schemeAndPort returns the scheme and port used to scrape the node, given the
+// defaults for nodes matching no override.
+func (kc *kubeletClient) schemeAndPort(node *corev1.Node, scheme string, port int) (string, int) {
+	for _, override := range kc.targetOverrides {
+		if !override.Selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		if override.Scheme != "" {
+			scheme = override.Scheme
+		}
+		if override.Port > 0 {
+			port = override.Port
+		}
+		break
+	}
+	return scheme, port
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ScrapeTarget is where the latest scrape of a node fetched its summary from,
+// and how it went.
+type ScrapeTarget struct {
+	Node string
+	// AddressType is the type of the node address scraped, empty if the
+	// address doesn't come from the node status, e.g. when it is listed in a
+	// node address file or the node is scraped through the API server.
+	AddressType corev1.NodeAddressType
+	Host        string
+	Port        int
+	Scheme      string
+	// ViaAPIServer is set for nodes scraped through the API server node
+	// proxy, Host and Port being those of the API server.
+	ViaAPIServer bool
+	// Time is the time the scrape completed.
+	Time time.Time
+	// Error is the error of the scrape, empty if it succeeded.
+	Error string
+}
+
+// targetDescriber is implemented by Kubelet clients which can tell where they
+// scrape a node. Targets of other clients are recorded without an address.
+type targetDescriber interface {
+	describeTarget(node *corev1.Node) ScrapeTarget
+}
+
+var _ targetDescriber = (*kubeletClient)(nil)
+
+// describeTarget returns the scheme, host and port the summary of the node is
+// fetched from, none if the node has no address to be scraped on. Retries on
+// other addresses with TryAllAddresses aren't reflected.
+func (kc *kubeletClient) describeTarget(node *corev1.Node) ScrapeTarget {
+	u, err := kc.summaryURL(node)
+	if err != nil {
+		return ScrapeTarget{}
+	}
+	target := ScrapeTarget{Host: u.Hostname(), Scheme: u.Scheme, ViaAPIServer: kc.apiServerURL != nil}
+	target.Port, err = strconv.Atoi(u.Port())
+	if err != nil {
+		target.Port = 443
+		if u.Scheme == "http" {
+			target.Port = 80
+		}
+	}
+	if !target.ViaAPIServer {
+		for _, address := range node.Status.Addresses {
+			if address.Address == target.Host {
+				target.AddressType = address.Type
+				break
+			}
+		}
+	}
+	return target
+}
+
+// recordTarget tracks the target of the latest scrape of the node.
+func (n *nodeState) recordTarget(result nodeResult, now time.Time) {
+	target := result.target
+	target.Node = result.node
+	target.Time = now
+	if result.err != nil {
+		target.Error = result.err.Error()
+	}
+	n.target = &target
+}
+
+// targets returns the tracked targets sorted by node name.
+func (s *nodeStates) targets() []ScrapeTarget {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	targets := make([]ScrapeTarget, 0, len(s.nodes))
+	for _, state := range s.nodes {
+		if state.target != nil {
+			targets = append(targets, *state.target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Node < targets[j].Node })
+	return targets
+}
+
+// ScrapeTargets returns the target of the latest scrape of each listed node,
+// sorted by node name. Nodes filtered out of the cycle,
+// e.g. by taints or address deduplication, aren't returned.
+func (c *scraper) ScrapeTargets() []ScrapeTarget {
+	return c.nodes.targets()
+}
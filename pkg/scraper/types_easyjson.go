@@ -251,6 +251,8 @@ func easyjson6601e8cdDecodeSigsK8sIoMetricsServerPkgScraper2(in *jlexer.Lexer, o
 			out.Name = string(in.String())
 		case "namespace":
 			out.Namespace = string(in.String())
+		case "uid":
+			out.UID = string(in.String())
 		default:
 			in.SkipRecursive()
 		}
@@ -275,6 +277,11 @@ func easyjson6601e8cdEncodeSigsK8sIoMetricsServerPkgScraper2(out *jwriter.Writer
 		out.RawString(prefix)
 		out.String(string(in.Namespace))
 	}
+	{
+		const prefix string = ",\"uid\":"
+		out.RawString(prefix)
+		out.String(string(in.UID))
+	}
 	out.RawByte('}')
 }
 
@@ -430,6 +437,16 @@ func easyjson6601e8cdDecodeSigsK8sIoMetricsServerPkgScraper4(in *jlexer.Lexer, o
 				}
 				*out.WorkingSetBytes = uint64(in.Uint64())
 			}
+		case "rssBytes":
+			if in.IsNull() {
+				in.Skip()
+				out.RSSBytes = nil
+			} else {
+				if out.RSSBytes == nil {
+					out.RSSBytes = new(uint64)
+				}
+				*out.RSSBytes = uint64(in.Uint64())
+			}
 		default:
 			in.SkipRecursive()
 		}
@@ -454,6 +471,11 @@ func easyjson6601e8cdEncodeSigsK8sIoMetricsServerPkgScraper4(out *jwriter.Writer
 		out.RawString(prefix)
 		out.Uint64(uint64(*in.WorkingSetBytes))
 	}
+	if in.RSSBytes != nil {
+		const prefix string = ",\"rssBytes\":"
+		out.RawString(prefix)
+		out.Uint64(uint64(*in.RSSBytes))
+	}
 	out.RawByte('}')
 }
 
@@ -609,6 +631,16 @@ func easyjson6601e8cdDecodeSigsK8sIoMetricsServerPkgScraper6(in *jlexer.Lexer, o
 				}
 				*out.UsageNanoCores = uint64(in.Uint64())
 			}
+		case "usageCoreNanoSeconds":
+			if in.IsNull() {
+				in.Skip()
+				out.UsageCoreNanoSeconds = nil
+			} else {
+				if out.UsageCoreNanoSeconds == nil {
+					out.UsageCoreNanoSeconds = new(uint64)
+				}
+				*out.UsageCoreNanoSeconds = uint64(in.Uint64())
+			}
 		default:
 			in.SkipRecursive()
 		}
@@ -633,6 +665,11 @@ func easyjson6601e8cdEncodeSigsK8sIoMetricsServerPkgScraper6(out *jwriter.Writer
 		out.RawString(prefix)
 		out.Uint64(uint64(*in.UsageNanoCores))
 	}
+	if in.UsageCoreNanoSeconds != nil {
+		const prefix string = ",\"usageCoreNanoSeconds\":"
+		out.RawString(prefix)
+		out.Uint64(uint64(*in.UsageCoreNanoSeconds))
+	}
 	out.RawByte('}')
 }
 
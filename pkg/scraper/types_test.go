@@ -61,11 +61,40 @@ var _ = Describe("Types", func() {
 		Expect(err).NotTo(HaveOccurred())
 
 		By("checking decoded metrics match expected")
-		got := decodeBatch(internal)
+		got := decodeBatch(internal, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil)
 		if diff := cmp.Diff(got, expected); len(diff) != 0 {
 			Expect(err).NotTo(HaveOccurred(), "decodeBatch() diff:\n %s", diff)
 		}
 	})
+
+	It("internal summary should decode the resident set size when selected", func() {
+		By("Unmarshaling json into internal Summary")
+		internal := &Summary{}
+		err := json.Unmarshal([]byte(summary), internal)
+		Expect(err).NotTo(HaveOccurred())
+
+		By("checking decoded memory is the resident set size")
+		got := decodeBatch(internal, time.Time{}, false, MemoryMetricRSS, Resources{}, nil)
+		Expect(got.Nodes).To(HaveLen(1))
+		Expect(got.Nodes[0].MemoryUsage.Value()).To(Equal(int64(848789504)))
+		Expect(got.Pods).To(HaveLen(len(expected.Pods)))
+		Expect(got.Pods[0].Containers[0].MemoryUsage.Value()).To(Equal(int64(1277952)))
+	})
+
+	It("internal summary should attach the cumulative CPU counters of containers when exposed", func() {
+		internal := &Summary{}
+		err := json.Unmarshal([]byte(summary), internal)
+		Expect(err).NotTo(HaveOccurred())
+
+		got := decodeBatch(internal, time.Time{}, false, MemoryMetricWorkingSet, Resources{}, nil)
+		attachCumulativeCPU(got, internal)
+		Expect(got.Pods[0].Name).To(Equal("load-6cddbdb5c8-blk57"))
+		Expect(got.Pods[0].Containers[0].CumulativeCPU).To(Equal(&storage.CumulativeCPU{
+			CoreNanoSeconds: 29328792,
+			Timestamp:       time.Date(2020, 4, 16, 20, 25, 30, 0, time.UTC).Local(),
+		}))
+		Expect(got.Nodes[0].CumulativeCPU).To(BeNil())
+	})
 })
 
 func compare(stats *v1alpha1.Summary, internal *Summary) error {
@@ -123,6 +152,9 @@ func compareCPU(stats *v1alpha1.CPUStats, internal *CPUStats) error {
 	if *internal.UsageNanoCores != *stats.UsageNanoCores {
 		return fmt.Errorf(".UsageNanoCores")
 	}
+	if (internal.UsageCoreNanoSeconds == nil) != (stats.UsageCoreNanoSeconds == nil) || (internal.UsageCoreNanoSeconds != nil && *internal.UsageCoreNanoSeconds != *stats.UsageCoreNanoSeconds) {
+		return fmt.Errorf(".UsageCoreNanoSeconds")
+	}
 	return nil
 }
 
@@ -139,6 +171,9 @@ func compareMemory(stats *v1alpha1.MemoryStats, internal *MemoryStats) error {
 	if *internal.WorkingSetBytes != *stats.WorkingSetBytes {
 		return fmt.Errorf(".WorkingSetBytes")
 	}
+	if (internal.RSSBytes == nil) != (stats.RSSBytes == nil) || (internal.RSSBytes != nil && *internal.RSSBytes != *stats.RSSBytes) {
+		return fmt.Errorf(".RSSBytes")
+	}
 	return nil
 }
 
@@ -270,7 +305,8 @@ var summary = `
      },
      "memory": {
       "time": "2020-04-16T20:25:30Z",
-      "workingSetBytes": 1449984
+      "workingSetBytes": 1449984,
+      "rssBytes": 1277952
      },
      "rootfs": {
       "time": "2020-04-16T20:25:26Z",
@@ -346,6 +382,7 @@ var expected = &storage.MetricsBatch{
 		{
 			Name:      "load-6cddbdb5c8-blk57",
 			Namespace: "default",
+			Node:      "e2e-v1.17.0-control-plane",
 			Containers: []storage.ContainerMetricsPoint{
 				{
 					Name: "load",
@@ -0,0 +1,78 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scraper
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/component-base/metrics"
+)
+
+// Reasons for which nodes listed for a scrape cycle got no metrics from it.
+const (
+	// unscrapedExcluded nodes were skipped deliberately, for their lease,
+	// taints or a scrape target shared with another node.
+	unscrapedExcluded = "excluded"
+	// unscrapedNoAddress nodes have no address to be scraped on.
+	unscrapedNoAddress = "no_address"
+	// unscrapedUnreachable nodes failed to be scraped.
+	unscrapedUnreachable = "unreachable"
+)
+
+var unscrapedNodes = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Namespace: "metrics_server",
+		Subsystem: "kubelet",
+		Name:      "unscraped_nodes",
+		Help:      "Number of nodes listed for the last scrape cycle which got no metrics from it, by reason. Nonzero values point to nodes filtered out by configuration or failing to be scraped.",
+	},
+	[]string{"reason"},
+)
+
+// addressChecker is implemented by Kubelet clients which can tell whether a
+// node has an address to be scraped on.
+type addressChecker interface {
+	hasAddress(node *corev1.Node) bool
+}
+
+var _ addressChecker = (*kubeletClient)(nil)
+
+func (kc *kubeletClient) hasAddress(node *corev1.Node) bool {
+	if kc.apiServerURL != nil {
+		return true
+	}
+	_, err := kc.addrResolver.NodeAddress(node)
+	return err == nil
+}
+
+// unscrapedCount counts the nodes of a cycle without metrics, by reason.
+type unscrapedCount struct {
+	excluded, noAddress, unreachable int
+}
+
+func (u *unscrapedCount) add(result nodeResult) {
+	switch {
+	case result.metrics != nil:
+	case result.noAddress:
+		u.noAddress++
+	default:
+		u.unreachable++
+	}
+}
+
+func (u unscrapedCount) record() {
+	unscrapedNodes.WithLabelValues(unscrapedExcluded).Set(float64(u.excluded))
+	unscrapedNodes.WithLabelValues(unscrapedNoAddress).Set(float64(u.noAddress))
+	unscrapedNodes.WithLabelValues(unscrapedUnreachable).Set(float64(u.unreachable))
+}
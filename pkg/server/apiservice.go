@@ -0,0 +1,117 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/component-base/metrics"
+	"k8s.io/klog/v2"
+)
+
+// APIServiceName is the name of the APIService aggregating the metrics API.
+const APIServiceName = "v1beta1.metrics.k8s.io"
+
+var apiServiceResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+var (
+	apiServiceAvailable = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "apiservice",
+			Name:      "available",
+			Help:      "Whether the APIService of the metrics API is registered and available according to its last check.",
+		},
+	)
+	apiServiceErrors = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "apiservice",
+			Name:      "errors_total",
+			Help:      "Number of checks which found the APIService of the metrics API missing or unavailable, or failed to get it.",
+		},
+	)
+)
+
+// apiServiceCheck periodically gets the APIService of the metrics API and
+// reports whether the aggregator considers it available. Unlike liveness, it
+// catches registration problems which break clients of the metrics API.
+type apiServiceCheck struct {
+	apiServices dynamic.ResourceInterface
+	name        string
+}
+
+func newAPIServiceCheck(client dynamic.Interface, name string) *apiServiceCheck {
+	return &apiServiceCheck{
+		apiServices: client.Resource(apiServiceResource),
+		name:        name,
+	}
+}
+
+func (c *apiServiceCheck) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	c.update(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			c.update(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *apiServiceCheck) update(ctx context.Context) {
+	err := c.check(ctx)
+	if err != nil {
+		klog.Warningf("APIService check failed: %v", err)
+		apiServiceErrors.Inc()
+		apiServiceAvailable.Set(0)
+		return
+	}
+	apiServiceAvailable.Set(1)
+}
+
+// check returns an error unless the APIService exists and has a true
+// Available condition.
+func (c *apiServiceCheck) check(ctx context.Context) error {
+	apiService, err := c.apiServices.Get(ctx, c.name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get APIService %s: %v", c.name, err)
+	}
+	conditions, _, err := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+	if err != nil {
+		return fmt.Errorf("unable to read conditions of APIService %s: %v", c.name, err)
+	}
+	for _, condition := range conditions {
+		fields, ok := condition.(map[string]interface{})
+		if !ok || fields["type"] != "Available" {
+			continue
+		}
+		if fields["status"] != "True" {
+			return fmt.Errorf("APIService %s is not available: %v: %v", c.name, fields["reason"], fields["message"])
+		}
+		return nil
+	}
+	return fmt.Errorf("APIService %s has no Available condition", c.name)
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic/fake"
+	"k8s.io/component-base/metrics/testutil"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func newAPIService(status string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata":   map[string]interface{}{"name": APIServiceName},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": status, "reason": "FailedDiscoveryCheck"},
+			},
+		},
+	}}
+}
+
+var _ = Describe("APIService check", func() {
+	BeforeEach(func() {
+		apiServiceAvailable.Create(nil)
+		apiServiceErrors.Create(nil)
+		apiServiceErrors.Reset()
+	})
+
+	expectMetrics := func(available, errors int) {
+		err := testutil.CollectAndCompare(apiServiceAvailable, strings.NewReader(`
+		# HELP metrics_server_apiservice_available [ALPHA] Whether the APIService of the metrics API is registered and available according to its last check.
+		# TYPE metrics_server_apiservice_available gauge
+		metrics_server_apiservice_available `+strconv.Itoa(available)+`
+		`), "metrics_server_apiservice_available")
+		Expect(err).NotTo(HaveOccurred())
+		err = testutil.CollectAndCompare(apiServiceErrors, strings.NewReader(`
+		# HELP metrics_server_apiservice_errors_total [ALPHA] Number of checks which found the APIService of the metrics API missing or unavailable, or failed to get it.
+		# TYPE metrics_server_apiservice_errors_total counter
+		metrics_server_apiservice_errors_total `+strconv.Itoa(errors)+`
+		`), "metrics_server_apiservice_errors_total")
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	It("should report an available APIService", func() {
+		check := newAPIServiceCheck(fake.NewSimpleDynamicClient(runtime.NewScheme(), newAPIService("True")), APIServiceName)
+		check.update(context.Background())
+		expectMetrics(1, 0)
+	})
+	It("should report an APIService failing its discovery check", func() {
+		check := newAPIServiceCheck(fake.NewSimpleDynamicClient(runtime.NewScheme(), newAPIService("False")), APIServiceName)
+		check.update(context.Background())
+		check.update(context.Background())
+		expectMetrics(0, 2)
+	})
+	It("should report a missing APIService", func() {
+		check := newAPIServiceCheck(fake.NewSimpleDynamicClient(runtime.NewScheme()), APIServiceName)
+		check.update(context.Background())
+		expectMetrics(0, 1)
+	})
+	It("should report an APIService becoming available after failing", func() {
+		apiService := newAPIService("False")
+		client := fake.NewSimpleDynamicClient(runtime.NewScheme(), apiService)
+		check := newAPIServiceCheck(client, APIServiceName)
+		check.update(context.Background())
+		expectMetrics(0, 1)
+
+		Expect(unstructured.SetNestedSlice(apiService.Object, []interface{}{
+			map[string]interface{}{"type": "Available", "status": "True"},
+		}, "status", "conditions")).To(Succeed())
+		_, err := client.Resource(apiServiceResource).Update(context.Background(), apiService, metav1.UpdateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		check.update(context.Background())
+		expectMetrics(1, 1)
+	})
+})
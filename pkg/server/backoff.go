@@ -0,0 +1,94 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// informerBackoffBase is the delay of the first list retried after a failure.
+const informerBackoffBase = time.Second
+
+// listBackoff delays the list requests of the informers after consecutive
+// failures, doubling the delay from informerBackoffBase up to maxDelay, so
+// that relists against a flaky API server are spread out. The node and pod
+// informers share a client, so they share the delay. Watches aren't delayed,
+// and a successful list resets the delay.
+type listBackoff struct {
+	next     http.RoundTripper
+	maxDelay time.Duration
+	failures int
+	mu       sync.Mutex
+	// wait sleeps for the delay, or returns an error once the context is done.
+	wait func(ctx context.Context, delay time.Duration) error
+}
+
+func newListBackoff(maxDelay time.Duration) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &listBackoff{next: next, maxDelay: maxDelay, wait: waitFor}
+	}
+}
+
+func (b *listBackoff) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.URL.Query().Get("watch") == "true" {
+		return b.next.RoundTrip(req)
+	}
+	if err := b.wait(req.Context(), b.delay()); err != nil {
+		return nil, err
+	}
+	resp, err := b.next.RoundTrip(req)
+	b.mu.Lock()
+	if err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+		b.failures++
+	} else {
+		b.failures = 0
+	}
+	b.mu.Unlock()
+	return resp, err
+}
+
+// delay returns how long to wait before the next list.
+func (b *listBackoff) delay() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures == 0 {
+		return 0
+	}
+	delay := informerBackoffBase
+	for i := 1; i < b.failures && delay < b.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > b.maxDelay {
+		delay = b.maxDelay
+	}
+	return delay
+}
+
+func waitFor(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// statusRoundTripper answers each request with the next status, recording
+// the requested paths.
+type statusRoundTripper struct {
+	statuses []int
+	paths    []string
+}
+
+func (rt *statusRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.paths = append(rt.paths, req.URL.RequestURI())
+	status := rt.statuses[0]
+	rt.statuses = rt.statuses[1:]
+	if status == 0 {
+		return nil, fmt.Errorf("connection refused")
+	}
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+}
+
+var _ = Describe("Informer list backoff", func() {
+	var (
+		next    *statusRoundTripper
+		backoff *listBackoff
+		delays  []time.Duration
+	)
+	BeforeEach(func() {
+		next = &statusRoundTripper{}
+		delays = nil
+		backoff = newListBackoff(5 * time.Second)(next).(*listBackoff)
+		backoff.wait = func(_ context.Context, delay time.Duration) error {
+			delays = append(delays, delay)
+			return nil
+		}
+	})
+	list := func(times int) {
+		for i := 0; i < times; i++ {
+			req, err := http.NewRequest(http.MethodGet, "https://10.96.0.1/api/v1/nodes?limit=500", nil)
+			Expect(err).NotTo(HaveOccurred())
+			resp, err := backoff.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+	}
+
+	It("should double the delay of lists after repeated failures up to the cap", func() {
+		next.statuses = []int{500, 0, 503, 429, 500, 500}
+		list(6)
+		Expect(delays).To(Equal([]time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}))
+	})
+	It("should reset the delay after a successful list", func() {
+		next.statuses = []int{500, 500, 200, 500, 200}
+		list(5)
+		Expect(delays).To(Equal([]time.Duration{0, time.Second, 2 * time.Second, 0, time.Second}))
+	})
+	It("should not count client errors as failures", func() {
+		next.statuses = []int{403, 404}
+		list(2)
+		Expect(delays).To(Equal([]time.Duration{0, 0}))
+	})
+	It("should not delay watches", func() {
+		next.statuses = []int{500, 500, 200}
+		list(2)
+		req, err := http.NewRequest(http.MethodGet, "https://10.96.0.1/api/v1/nodes?watch=true", nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = backoff.RoundTrip(req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(delays).To(HaveLen(2))
+		Expect(next.paths).To(HaveLen(3))
+	})
+	It("should stop waiting once the request is canceled", func() {
+		backoff.wait = waitFor
+		backoff.failures = 3
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req, err := http.NewRequest(http.MethodGet, "https://10.96.0.1/api/v1/nodes", nil)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = backoff.RoundTrip(req.WithContext(ctx))
+		Expect(err).To(Equal(context.Canceled))
+		Expect(next.paths).To(BeEmpty())
+	})
+})
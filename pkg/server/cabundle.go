@@ -0,0 +1,129 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/klog/v2"
+)
+
+// caBundleUpdater periodically sets the caBundle of the APIService of the
+// metrics API to the CA of the current serving certificate, so that the
+// aggregator keeps trusting metrics-server after the certificate rotates.
+type caBundleUpdater struct {
+	apiServices dynamic.ResourceInterface
+	name        string
+	cert        dynamiccertificates.CertKeyContentProvider
+}
+
+func newCABundleUpdater(client dynamic.Interface, name string, cert dynamiccertificates.CertKeyContentProvider) *caBundleUpdater {
+	return &caBundleUpdater{
+		apiServices: client.Resource(apiServiceResource),
+		name:        name,
+		cert:        cert,
+	}
+}
+
+func (u *caBundleUpdater) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	u.tryUpdate(ctx)
+	for {
+		select {
+		case <-ticker.C:
+			u.tryUpdate(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (u *caBundleUpdater) tryUpdate(ctx context.Context) {
+	err := u.update(ctx)
+	switch {
+	case err == nil:
+	case apierrors.IsForbidden(err):
+		klog.Warningf("Not permitted to update the caBundle of APIService %s, grant update on apiservices or disable --auto-update-apiservice-cabundle: %v", u.name, err)
+	default:
+		klog.Warningf("Unable to update the caBundle of APIService %s: %v", u.name, err)
+	}
+}
+
+// update sets the caBundle of the APIService unless it already matches the
+// serving certificate, or the APIService skips TLS verification.
+func (u *caBundleUpdater) update(ctx context.Context) error {
+	certPEM, _ := u.cert.CurrentCertKeyContent()
+	ca, err := issuerCert(certPEM)
+	if err != nil {
+		return err
+	}
+	apiService, err := u.apiServices.Get(ctx, u.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	// the aggregator rejects setting caBundle together with insecureSkipTLSVerify
+	if skip, _, _ := unstructured.NestedBool(apiService.Object, "spec", "insecureSkipTLSVerify"); skip {
+		klog.V(2).Infof("APIService %s skips TLS verification, not updating its caBundle", u.name)
+		return nil
+	}
+	encoded := base64.StdEncoding.EncodeToString(ca)
+	if current, _, _ := unstructured.NestedString(apiService.Object, "spec", "caBundle"); current == encoded {
+		return nil
+	}
+	if err := unstructured.SetNestedField(apiService.Object, encoded, "spec", "caBundle"); err != nil {
+		return err
+	}
+	if _, err := u.apiServices.Update(ctx, apiService, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	klog.Infof("Updated the caBundle of APIService %s to the CA of the serving certificate", u.name)
+	return nil
+}
+
+// issuerCert returns the last certificate of a PEM encoded chain, which is
+// the certificate itself when self-signed, as PEM.
+func issuerCert(chain []byte) ([]byte, error) {
+	var last *pem.Block
+	for rest := chain; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			last = block
+		}
+	}
+	if last == nil {
+		return nil, fmt.Errorf("no certificate in serving certificate chain")
+	}
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, last); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/server/dynamiccertificates"
+	"k8s.io/client-go/dynamic/fake"
+	core "k8s.io/client-go/testing"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+var _ = Describe("APIService caBundle updater", func() {
+	var (
+		client  *fake.FakeDynamicClient
+		cert    dynamiccertificates.CertKeyContentProvider
+		ca      []byte
+		updater *caBundleUpdater
+	)
+	BeforeEach(func() {
+		certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey("metrics-server.kube-system.svc", nil, nil)
+		Expect(err).NotTo(HaveOccurred())
+		// the generated chain is the serving certificate followed by its CA
+		ca, err = issuerCert(certPEM)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(certPEM)).To(HaveSuffix(string(ca)))
+		cert, err = dynamiccertificates.NewStaticCertKeyContent("serving", certPEM, keyPEM)
+		Expect(err).NotTo(HaveOccurred())
+
+		client = fake.NewSimpleDynamicClient(runtime.NewScheme(), newAPIService("True"))
+		updater = newCABundleUpdater(client, APIServiceName, cert)
+	})
+
+	caBundle := func() string {
+		apiService, err := client.Resource(apiServiceResource).Get(context.Background(), APIServiceName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		bundle, _, _ := unstructured.NestedString(apiService.Object, "spec", "caBundle")
+		return bundle
+	}
+	updates := func() int {
+		count := 0
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "update" {
+				count++
+			}
+		}
+		return count
+	}
+
+	It("should set the caBundle to the CA of the serving certificate", func() {
+		Expect(updater.update(context.Background())).To(Succeed())
+		Expect(caBundle()).To(Equal(base64.StdEncoding.EncodeToString(ca)))
+	})
+	It("should not update a caBundle already matching", func() {
+		Expect(updater.update(context.Background())).To(Succeed())
+		Expect(updater.update(context.Background())).To(Succeed())
+		Expect(updates()).To(Equal(1))
+	})
+	It("should replace a stale caBundle", func() {
+		apiService := newAPIService("True")
+		Expect(unstructured.SetNestedField(apiService.Object, base64.StdEncoding.EncodeToString([]byte("stale")), "spec", "caBundle")).To(Succeed())
+		client = fake.NewSimpleDynamicClient(runtime.NewScheme(), apiService)
+		updater = newCABundleUpdater(client, APIServiceName, cert)
+
+		Expect(updater.update(context.Background())).To(Succeed())
+		Expect(caBundle()).To(Equal(base64.StdEncoding.EncodeToString(ca)))
+	})
+	It("should leave APIServices skipping TLS verification unchanged", func() {
+		apiService := newAPIService("True")
+		Expect(unstructured.SetNestedField(apiService.Object, true, "spec", "insecureSkipTLSVerify")).To(Succeed())
+		client = fake.NewSimpleDynamicClient(runtime.NewScheme(), apiService)
+		updater = newCABundleUpdater(client, APIServiceName, cert)
+
+		Expect(updater.update(context.Background())).To(Succeed())
+		Expect(updates()).To(BeZero())
+	})
+	It("should return permission errors without panicking", func() {
+		client.PrependReactor("update", "apiservices", func(action core.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(apiServiceResource.GroupResource(), APIServiceName, errors.New("RBAC: access denied"))
+		})
+
+		err := updater.update(context.Background())
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		updater.tryUpdate(context.Background())
+		Expect(caBundle()).To(BeEmpty())
+	})
+})
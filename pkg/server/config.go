@@ -15,26 +15,264 @@ package server
 
 import (
 	"fmt"
+	"net/http"
+	"os"
+	"regexp"
 	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
 	apimetrics "k8s.io/apiserver/pkg/endpoints/metrics"
+	apirequest "k8s.io/apiserver/pkg/endpoints/request"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/component-base/metrics"
 
 	"sigs.k8s.io/metrics-server/pkg/api"
+	"sigs.k8s.io/metrics-server/pkg/api/rpc"
+	"sigs.k8s.io/metrics-server/pkg/remotewrite"
 	"sigs.k8s.io/metrics-server/pkg/scraper"
 	"sigs.k8s.io/metrics-server/pkg/storage"
 )
 
 type Config struct {
-	Apiserver        *genericapiserver.Config
-	Rest             *rest.Config
-	Kubelet          *scraper.KubeletClientConfig
+	Apiserver *genericapiserver.Config
+	Rest      *rest.Config
+	Kubelet   *scraper.KubeletClientConfig
+	// ScrapeStrategies scrape matching nodes with their own Kubelet client
+	// configuration instead of Kubelet, the first match applies.
+	ScrapeStrategies []ScrapeStrategyConfig
 	MetricResolution time.Duration
 	ScrapeTimeout    time.Duration
+	// ScrapeCycleDeadline bounds the duration of each scrape cycle. Zero means
+	// the metric resolution.
+	ScrapeCycleDeadline time.Duration
+	// ScrapeErrorLogInterval, if set, makes the scraper log the errors of each
+	// node, an unchanged error at most once per interval, instead of logging
+	// all errors of every cycle.
+	ScrapeErrorLogInterval time.Duration
+	// ScrapePhaseOffset, if set, aligns scrape cycles to start this long past
+	// a multiple of MetricResolution since the Unix epoch, so that replicas
+	// with distinct offsets don't scrape at the same time. If nil, the first
+	// cycle starts right away.
+	ScrapePhaseOffset *time.Duration
+	// MaxConcurrentScrapes limits the number of nodes scraped at the same time.
+	// Zero means no limit.
+	MaxConcurrentScrapes int
+	// MaxContainersPerNode rejects nodes reporting more containers. Zero
+	// means no limit.
+	MaxContainersPerNode int
+	// MaxPodsPerNode drops the pods of nodes reporting more, past this many.
+	// Zero means no limit.
+	MaxPodsPerNode int
+	// SingleResourceNodes serves nodes reporting only one of CPU and memory,
+	// instead of skipping them.
+	SingleResourceNodes bool
+	// SynthesizeNodeMetrics serves nodes reporting no node metrics with the
+	// summed usage of their containers.
+	SynthesizeNodeMetrics bool
+	// MaxUsageOverAllocatable excludes usage exceeding the node allocatable
+	// by more than this factor. Zero disables the check.
+	MaxUsageOverAllocatable float64
+	// UseNodeLeaseForLiveness skips scraping nodes whose lease wasn't renewed
+	// within NodeLeaseStaleThreshold.
+	UseNodeLeaseForLiveness bool
+	NodeLeaseStaleThreshold time.Duration
+	// SkipTaints skips scraping nodes bearing a taint with any of these keys.
+	SkipTaints []string
+	// NodeSampleFraction scrapes only this fraction of the nodes, selected
+	// by a hash of their names. Zero or one scrapes all nodes.
+	NodeSampleFraction float64
+	// MaxInformerStaleness bounds how long metrics are served while the
+	// informers are disconnected from the API server. Zero means no bound.
+	MaxInformerStaleness time.Duration
+	// InformerResyncPeriod is the resync period of the node and pod
+	// informers. Zero disables periodic resync.
+	InformerResyncPeriod time.Duration
+	// InformerBackoffCap, if set, delays the lists of the node and pod
+	// informers after consecutive failures, by up to this long.
+	InformerBackoffCap time.Duration
+	// CPURoundingMillis and MemoryRoundingBytes round served usage, zero disables rounding.
+	CPURoundingMillis   int64
+	MemoryRoundingBytes int64
+	// MemoryMinDeltaBytes holds served memory usage until it changes by more
+	// than this many bytes, zero serves every change.
+	MemoryMinDeltaBytes int64
+	// CPUMilliPrecision serves CPU usage in millicores instead of nanocores.
+	CPUMilliPrecision bool
+	// ExcludePodNamespaces lists namespaces hidden from PodMetrics.
+	ExcludePodNamespaces []string
+	// SkipEphemeralContainers leaves ephemeral containers out of PodMetrics.
+	SkipEphemeralContainers bool
+	// IncludePodQOS annotates PodMetrics with the QoS class of the pod.
+	IncludePodQOS bool
+	// IncludePodPriority annotates PodMetrics with the priority of the pod.
+	IncludePodPriority bool
+	// IncludeRestartCounts annotates PodMetrics with container restart counts.
+	IncludeRestartCounts bool
+	// IncludeNodePodStats annotates NodeMetrics with the number and summed
+	// usage of the pods with metrics on the node.
+	IncludeNodePodStats bool
+	// IncludeUsageDeltas annotates NodeMetrics and PodMetrics with the change
+	// of their usage since the previous point served.
+	IncludeUsageDeltas bool
+	// FlagUsageOverRequests annotates PodMetrics using more than the pod
+	// requests or limits.
+	FlagUsageOverRequests bool
+	// EchoPodLabels lists pod labels copied to PodMetrics.
+	EchoPodLabels []string
+	// MaxSelectorRequirements limits label selectors listing PodMetrics.
+	// Zero means no limit.
+	MaxSelectorRequirements int
+	// APIResponseCacheTTL is how long list responses are cached until new
+	// metrics are stored. Zero disables caching.
+	APIResponseCacheTTL time.Duration
+	// DiscoveryCacheTTL is how long discovery documents of the metrics API
+	// are cached. Zero disables caching.
+	DiscoveryCacheTTL time.Duration
+	// MinHealthyNodesFraction withholds NodeMetrics and PodMetrics while less
+	// than this fraction of nodes have metrics stored. Zero disables it.
+	MinHealthyNodesFraction float64
+	// PartialPodPolicy decides how pods with missing container metrics are served.
+	PartialPodPolicy api.PartialPodPolicy
+	// PodAggregation decides how container usage is rolled up per pod in
+	// table output.
+	PodAggregation api.PodAggregation
+	// DiscardEmptyCycles keeps the stored metrics when a scrape cycle returns
+	// metrics for no node, or for less than MinCycleNodeFraction of the nodes.
+	DiscardEmptyCycles   bool
+	MinCycleNodeFraction float64
+	// MinCycleSuccessRatio fails readiness while scrape cycles return metrics
+	// for less than this fraction of the nodes, zero disables the check.
+	MinCycleSuccessRatio float64
+	// SkipTerminalPhasePods leaves pods the informer reports in the Succeeded
+	// or Failed phase out of storage.
+	SkipTerminalPhasePods bool
+	// VerifyPodExistence checks served pods against the Kubernetes API server,
+	// omitting pods deleted but still present in the informer.
+	VerifyPodExistence bool
+	// DropOrphanedPodMetrics omits pods assigned to a node missing from the
+	// node informer.
+	DropOrphanedPodMetrics bool
+	// SuppressCordonedNodes omits pods assigned to a node marked
+	// unschedulable in the node informer.
+	SuppressCordonedNodes bool
+	// NodeExistenceGrace is how long NodeMetrics of a node missing from the
+	// node informer are still served, zero serves them as long as stored.
+	NodeExistenceGrace time.Duration
+	// EnableSelfCheck periodically verifies that stored node metrics are fresh.
+	EnableSelfCheck bool
+	// PerResourceReadiness reports whether node and pod metrics are stored as
+	// separate readiness checks.
+	PerResourceReadiness bool
+	// MonitorAPIService periodically checks whether the APIService of the
+	// metrics API is available and reports it in metrics.
+	MonitorAPIService bool
+	// UpdateAPIServiceCA periodically sets the caBundle of the APIService
+	// of the metrics API to the CA of the serving certificate.
+	UpdateAPIServiceCA bool
+	// LeaderElection, unless nil, elects the only replica scraping Kubelets,
+	// the other replicas proxy NodeMetrics and PodMetrics requests to it.
+	LeaderElection *LeaderElectionConfig
+	// RestartGrace, unless nil, lets a replica restarted shortly after a
+	// graceful shutdown report ready before its first scrape.
+	RestartGrace *RestartGraceConfig
+	// RemoteWrite exports scraped usage to a remote write endpoint, unless
+	// its URL is empty.
+	RemoteWrite remotewrite.Config
+	// MaxKubeletClockSkew rejects metrics timestamped further than this from
+	// the metrics-server clock. Zero disables the check.
+	MaxKubeletClockSkew time.Duration
+	// MinSampleInterval is how long after the stored metrics of a node or
+	// container new metrics are stored. Zero stores all metrics.
+	MinSampleInterval time.Duration
+	// ReorderSamples keeps the stored metrics of a node or container when a
+	// later scrape returns older metrics.
+	ReorderSamples bool
+	// TimestampSource decides whether metrics are timestamped by Kubelet or on receipt.
+	TimestampSource scraper.TimestampSource
+	// ScrapeOrder decides the order nodes are scraped in within a cycle.
+	ScrapeOrder scraper.ScrapeOrder
+	// MemoryMetric decides whether the working set or resident set size is
+	// stored as memory usage.
+	MemoryMetric scraper.MemoryMetric
+	// DuplicateSeriesPolicy decides which series is stored for containers
+	// reported more than once by Kubelet.
+	DuplicateSeriesPolicy scraper.DuplicateSeriesPolicy
+	// CPUStaleness and MemoryStaleness leave out the usage of a resource
+	// sampled by Kubelet longer than this before the scrape. Zero disables
+	// the check.
+	CPUStaleness    time.Duration
+	MemoryStaleness time.Duration
+	// MemoryReport decides whether memory usage is stored as reported or smoothed.
+	MemoryReport storage.MemoryReport
+	// NodeUsageSource decides whether node usage is stored as reported by
+	// Kubelet or summed from the pods of the node.
+	NodeUsageSource storage.NodeUsageSource
+	// NodeKey decides whether stored points of nodes are matched across
+	// scrapes by name or provider ID.
+	NodeKey storage.NodeKey
+	// PodUIDChange decides whether pods recreated under the same name are
+	// reported, and whether they keep the history of the previous pod.
+	PodUIDChange storage.PodUIDChange
+	// EvictOnPodDelete evicts the stored point of a pod as soon as the pod
+	// informer reports it deleted.
+	EvictOnPodDelete bool
+	// InformerBatchWindow is how long pods deleted after a first one are
+	// collected to be evicted together, zero evicts every pod on its own.
+	InformerBatchWindow time.Duration
+	// ContainerNameSuffix, if set, is stripped from container names before
+	// they are stored.
+	ContainerNameSuffix *regexp.Regexp
+	// SandboxContainers are the names of sandbox containers left out of pod
+	// metrics, none are left out if empty.
+	SandboxContainers []string
+	// ExcludedContainers are the names and glob patterns of containers left
+	// out of pod metrics.
+	ExcludedContainers []string
+	// AuthenticationTimeout and AuthorizationTimeout bound authenticating and
+	// authorizing each request, failing it with 503 when exceeded. Zero means
+	// no bound.
+	AuthenticationTimeout time.Duration
+	AuthorizationTimeout  time.Duration
+	// PathPrefix, if set, additionally serves all routes of the secure
+	// server under this prefix.
+	PathPrefix string
+	// RequestFairness limits the concurrent gets and lists of the Metrics API
+	// to MaxGetRequests and MaxListRequests respectively.
+	RequestFairness bool
+	MaxGetRequests  int
+	MaxListRequests int
+	// MaxListResponseBytes fails lists of the Metrics API with larger
+	// responses with 413. Zero means no limit.
+	MaxListResponseBytes int
+	// EnableDebugEndpoints installs debug handlers under /debug/metrics-server/.
+	EnableDebugEndpoints bool
+	// EnableGRPC serves the Metrics gRPC service on the secure port.
+	EnableGRPC bool
+	// EnableSSE pushes the served metrics to clients subscribed to the event
+	// stream after each scrape cycle.
+	EnableSSE bool
+	// ExposeCumulativeCPU serves the cumulative CPU counters of containers
+	// reported by Kubelet on a separate endpoint.
+	ExposeCumulativeCPU bool
+	// EnableRawPayloadCache keeps the latest raw Kubelet payload of each node
+	// for the debug endpoints.
+	EnableRawPayloadCache bool
+	// DebugDumpScrapes writes the metrics of each scrape cycle to stdout at
+	// log verbosity scraper.DumpVerbosity or higher.
+	DebugDumpScrapes bool
+}
+
+// ScrapeStrategyConfig configures the client scraping nodes matching Selector.
+type ScrapeStrategyConfig struct {
+	Selector labels.Selector
+	Kubelet  *scraper.KubeletClientConfig
 }
 
 func (c Config) Complete() (*server, error) {
@@ -42,35 +280,261 @@ func (c Config) Complete() (*server, error) {
 	if err != nil {
 		return nil, err
 	}
-	kubeletClient, err := c.Kubelet.Complete()
+	kubeletConfig := *c.Kubelet
+	var payloads *scraper.PayloadCache
+	if c.EnableRawPayloadCache {
+		payloads = scraper.NewPayloadCache()
+		kubeletConfig.RawPayloads = payloads
+	}
+	kubeletClient, err := kubeletConfig.Complete()
 	if err != nil {
 		return nil, fmt.Errorf("unable to construct a client to connect to the kubelets: %v", err)
 	}
+	strategies := make([]scraper.ScrapeStrategy, 0, len(c.ScrapeStrategies))
+	for _, strategy := range c.ScrapeStrategies {
+		config := *strategy.Kubelet
+		config.RawPayloads = payloads
+		client, err := config.Complete()
+		if err != nil {
+			return nil, fmt.Errorf("unable to construct a client to connect to nodes matching %q: %v", strategy.Selector, err)
+		}
+		strategies = append(strategies, scraper.ScrapeStrategy{Selector: strategy.Selector, Client: client})
+	}
 	nodes := informer.Core().V1().Nodes()
-	scrape := scraper.NewScraper(nodes.Lister(), kubeletClient, c.ScrapeTimeout)
+	pods := informer.Core().V1().Pods()
+	staleness := newInformerStaleness()
+	for _, i := range []cache.SharedInformer{nodes.Informer(), pods.Informer()} {
+		if err := staleness.Track(i); err != nil {
+			return nil, fmt.Errorf("unable to track informer staleness: %v", err)
+		}
+	}
+	if c.Kubelet.AddressPins != nil {
+		nodes.Informer().AddEventHandler(c.Kubelet.AddressPins)
+	}
+	scrape := scraper.NewScraper(kubeletClient, c.ScrapeTimeout, c.MaxConcurrentScrapes)
+	scrape.SetTimestampSource(c.TimestampSource)
+	scrape.SetScrapeOrder(c.ScrapeOrder)
+	scrape.SetMaxContainersPerNode(c.MaxContainersPerNode)
+	scrape.SetMaxPodsPerNode(c.MaxPodsPerNode)
+	scrape.SetSingleResourceNodes(c.SingleResourceNodes)
+	scrape.SetSynthesizeNodeMetrics(c.SynthesizeNodeMetrics)
+	scrape.SetCumulativeCPU(c.ExposeCumulativeCPU)
+	scrape.SetMaxUsageOverAllocatable(c.MaxUsageOverAllocatable)
+	scrape.SetMemoryMetric(c.MemoryMetric)
+	scrape.SetDuplicateSeriesPolicy(c.DuplicateSeriesPolicy)
+	scrape.SetResources(c.Kubelet.Resources)
+	scrape.SetStalenessThresholds(c.CPUStaleness, c.MemoryStaleness)
+	scrape.SetErrorLogInterval(c.ScrapeErrorLogInterval)
+	scrape.SetSkipTaints(c.SkipTaints)
+	scrape.SetNodeSampleFraction(c.NodeSampleFraction)
+	scrape.SetScrapeStrategies(strategies)
+	synced := nodes.Informer().HasSynced
+	var leaseInformer informers.SharedInformerFactory
+	if c.UseNodeLeaseForLiveness {
+		leaseInformer, err = c.leaseInformer()
+		if err != nil {
+			return nil, err
+		}
+		leases := leaseInformer.Coordination().V1().Leases()
+		scrape.SetNodeLeases(leases.Lister().Leases(corev1.NamespaceNodeLease), c.NodeLeaseStaleThreshold)
+		nodesSynced := synced
+		synced = func() bool {
+			return nodesSynced() && leases.Informer().HasSynced()
+		}
+	}
+
+	store := storage.NewStorage()
+	store.SetMaxClockSkew(c.MaxKubeletClockSkew)
+	store.SetMinSampleInterval(c.MinSampleInterval)
+	store.SetDropOutOfOrder(c.ReorderSamples)
+	store.SetMemoryReport(c.MemoryReport)
+	store.SetContainerNameSuffix(c.ContainerNameSuffix)
+	store.SetNodeUsageSource(c.NodeUsageSource)
+	store.SetSandboxContainers(c.SandboxContainers)
+	store.SetNodeKey(c.NodeKey)
+	store.SetPodUIDChange(c.PodUIDChange)
+	store.SetExcludedContainers(c.ExcludedContainers)
+	if c.EvictOnPodDelete {
+		pods.Informer().AddEventHandler(store.PodDeleteHandler(c.InformerBatchWindow))
+	}
+	s := NewServer(
+		synced,
+		informer,
+		nil,
+		store,
+		scrape,
+		nodes.Lister(),
+		c.MetricResolution,
+	)
+	s.cycleDeadline = c.ScrapeCycleDeadline
+	s.scrapeErrorsLogged = c.ScrapeErrorLogInterval > 0
+	s.phaseOffset = c.ScrapePhaseOffset
+	s.leaseInformer = leaseInformer
+	if c.EvictOnPodDelete {
+		// metrics kept for nodes which aren't due would store deleted pods again
+		s.schedule.pods = pods.Lister()
+	}
+	if c.DiscardEmptyCycles {
+		s.cycleFilter = &cycleFilter{nodes: nodes.Lister(), minNodeFraction: c.MinCycleNodeFraction}
+	}
+	if c.MinCycleSuccessRatio > 0 {
+		s.cycleHealth = &cycleHealth{nodes: nodes.Lister(), minSuccessRatio: c.MinCycleSuccessRatio}
+	}
+	// addresses are loaded by the options, they are only reloaded here
+	s.nodeAddresses = c.Kubelet.NodeAddresses
+	if c.SkipTerminalPhasePods {
+		s.terminalPods = pods.Lister()
+	}
+	if c.RestartGrace != nil {
+		client, err := kubernetes.NewForConfig(c.Rest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to construct restart Lease client: %v", err)
+		}
+		s.restartGrace = newRestartGrace(client.CoordinationV1(), *c.RestartGrace, func() bool { return !store.Empty() })
+	}
+	s.perResourceReadiness = c.PerResourceReadiness
+	// readiness sub-checks are registered on readyz only, so they don't affect liveness
+	c.Apiserver.ReadyzChecks = append(c.Apiserver.ReadyzChecks, s.ReadyzChecks()...)
+	if c.EnableSelfCheck {
+		// allow for one missed scrape before metrics are considered stale
+		s.selfCheck = newSelfCheck(nodes.Lister(), store, 2*c.MetricResolution)
+		c.Apiserver.ReadyzChecks = append(c.Apiserver.ReadyzChecks, s.selfCheck)
+	}
+	if c.MonitorAPIService {
+		client, err := dynamic.NewForConfig(c.Rest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to construct APIService client: %v", err)
+		}
+		s.apiService = newAPIServiceCheck(client, APIServiceName)
+	}
+	if c.UpdateAPIServiceCA {
+		if c.Apiserver.SecureServing == nil || c.Apiserver.SecureServing.Cert == nil {
+			return nil, fmt.Errorf("unable to update the APIService caBundle without a serving certificate")
+		}
+		client, err := dynamic.NewForConfig(c.Rest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to construct APIService client: %v", err)
+		}
+		s.caBundle = newCABundleUpdater(client, APIServiceName, c.Apiserver.SecureServing.Cert)
+	}
+	if c.LeaderElection != nil {
+		s.leaderProxy, s.leaderElector, err = newLeaderProxy(*c.LeaderElection, c.Rest)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.RemoteWrite.URL != "" {
+		s.remoteWrite = remotewrite.NewWriter(c.RemoteWrite)
+	}
+	if c.DebugDumpScrapes {
+		s.dumper = scraper.NewBatchDumper(os.Stdout)
+	}
+
+	if c.Apiserver.Authorization.Authorizer != nil {
+		c.Apiserver.Authorization.Authorizer = api.WithAuthorizationDenialMetrics(c.Apiserver.Authorization.Authorizer)
+		if c.AuthorizationTimeout > 0 {
+			c.Apiserver.Authorization.Authorizer = api.WithAuthorizationTimeout(c.Apiserver.Authorization.Authorizer, c.AuthorizationTimeout)
+		}
+	}
+	if c.Apiserver.Authentication.Authenticator != nil && c.AuthenticationTimeout > 0 {
+		c.Apiserver.Authentication.Authenticator = api.WithAuthenticationTimeout(c.Apiserver.Authentication.Authenticator, c.AuthenticationTimeout)
+	}
+	c.Apiserver.BuildHandlerChainFunc = func(apiHandler http.Handler, config *genericapiserver.Config) http.Handler {
+		if s.leaderProxy != nil {
+			// proxied after authorization, with the credentials of this replica
+			apiHandler = s.leaderProxy.wrap(apiHandler)
+		}
+		apiHandler = api.WithUnservedVersionStatus(apiHandler, api.ServedVersions)
+		apiHandler = withDiscoveryCache(apiHandler, c.DiscoveryCacheTTL, api.ServedVersions)
+		apiHandler = withMaxListResponseBytes(apiHandler, c.MaxListResponseBytes)
+		handler := api.WithAuthTimeoutStatus(genericapiserver.DefaultBuildHandlerChain(api.WithRequestMetrics(apiHandler), config))
+		if c.RequestFairness {
+			handler = withRequestFairness(handler, config.RequestInfoResolver, c.MaxGetRequests, c.MaxListRequests)
+		}
+		return withPathPrefix(handler, c.PathPrefix)
+	}
+
+	if c.EnableSSE {
+		// the event stream stays open, so it's exempt from the request timeout
+		longRunning := c.Apiserver.LongRunningFunc
+		c.Apiserver.LongRunningFunc = func(r *http.Request, requestInfo *apirequest.RequestInfo) bool {
+			return r.URL.Path == eventsPath || (longRunning != nil && longRunning(r, requestInfo))
+		}
+	}
 
 	genericServer, err := c.Apiserver.Complete(informer).New("metrics-server", genericapiserver.NewEmptyDelegate())
 	if err != nil {
 		return nil, err
 	}
+	s.GenericAPIServer = genericServer
 
 	err = c.installMetrics(genericServer)
 	if err != nil {
 		return nil, err
 	}
+	if c.EnableDebugEndpoints {
+		debug := DebugHandlers{payloads: payloads, store: store, failures: scrape, pods: pods.Lister(), cycle: s.runProfiledCycle, targets: scrape, nodes: nodes.Lister()}
+		if c.MaxUsageOverAllocatable > 0 {
+			debug.allocatables = scrape
+		}
+		debug.Install(genericServer.Handler.NonGoRestfulMux)
+	}
 
-	store := storage.NewStorage()
-	if err := api.Install(store, informer.Core().V1(), genericServer); err != nil {
+	apiConfig := api.Config{
+		ListerStaleness:         staleness,
+		MaxListerStaleness:      c.MaxInformerStaleness,
+		CPURoundingMillis:       c.CPURoundingMillis,
+		MemoryRoundingBytes:     c.MemoryRoundingBytes,
+		MemoryMinDeltaBytes:     c.MemoryMinDeltaBytes,
+		CPUMilliPrecision:       c.CPUMilliPrecision,
+		ExcludedPodNamespaces:   c.ExcludePodNamespaces,
+		MaxSelectorRequirements: c.MaxSelectorRequirements,
+		IncludePodQOS:           c.IncludePodQOS,
+		IncludePodPriority:      c.IncludePodPriority,
+		IncludeRestartCounts:    c.IncludeRestartCounts,
+		FlagUsageOverRequests:   c.FlagUsageOverRequests,
+		SkipEphemeralContainers: c.SkipEphemeralContainers,
+		EchoPodLabels:           c.EchoPodLabels,
+		PartialPodPolicy:        c.PartialPodPolicy,
+		PodAggregation:          c.PodAggregation,
+		ResponseCacheTTL:        c.APIResponseCacheTTL,
+		MetricsGeneration:       store,
+		MinHealthyNodesFraction: c.MinHealthyNodesFraction,
+		DropOrphanedPods:        c.DropOrphanedPodMetrics,
+		DropCordonedNodePods:    c.SuppressCordonedNodes,
+		IncludeUsageDeltas:      c.IncludeUsageDeltas,
+		NodeExistenceGrace:      c.NodeExistenceGrace,
+	}
+	if c.IncludeNodePodStats {
+		apiConfig.NodePodStats = store
+	}
+	if c.VerifyPodExistence {
+		client, err := kubernetes.NewForConfig(c.Rest)
+		if err != nil {
+			return nil, fmt.Errorf("unable to construct pod verification client: %v", err)
+		}
+		apiConfig.PodExistenceVerifier = &apiPodVerifier{pods: client.CoreV1()}
+	}
+	if err := api.Install(store, informer.Core().V1(), apiConfig, genericServer); err != nil {
 		return nil, err
 	}
-	return NewServer(
-		nodes.Informer().HasSynced,
-		informer,
-		genericServer,
-		store,
-		scrape,
-		c.MetricResolution,
-	), nil
+	if c.EnableSSE {
+		s.events = newEventStream(api.NewMetricsLister(store, informer.Core().V1(), apiConfig), c.Apiserver.Authorization.Authorizer)
+		s.events.Install(genericServer.Handler.NonGoRestfulMux)
+	}
+	if c.ExposeCumulativeCPU {
+		cumulativeCPU{
+			store:              store,
+			authz:              c.Apiserver.Authorization.Authorizer,
+			excludedNamespaces: sets.NewString(c.ExcludePodNamespaces...),
+		}.Install(genericServer.Handler.NonGoRestfulMux)
+	}
+	if c.EnableGRPC {
+		// gRPC requests go through the same handler chain, which only authorizes their path,
+		// so each call is authorized again for the metrics it reads
+		genericServer.Handler.NonGoRestfulMux.HandlePrefix("/"+rpc.ServiceName+"/", api.NewGRPCServer(store, informer.Core().V1(), apiConfig, c.Apiserver.Authorization.Authorizer))
+	}
+	return s, nil
 }
 
 func (c Config) installMetrics(s *genericapiserver.GenericAPIServer) error {
@@ -93,6 +557,12 @@ func (c Config) installMetrics(s *genericapiserver.GenericAPIServer) error {
 	if err != nil {
 		return fmt.Errorf("unable to register storage metrics: %v", err)
 	}
+	err = remotewrite.RegisterRemoteWriteMetrics(registry.Register)
+	if err != nil {
+		return fmt.Errorf("unable to register remote write metrics: %v", err)
+	}
+
+	c.recordConfigInfo()
 
 	// register apiserver metrics
 	apimetrics.Register()
@@ -103,12 +573,30 @@ func (c Config) installMetrics(s *genericapiserver.GenericAPIServer) error {
 
 func (c Config) informer() (informers.SharedInformerFactory, error) {
 	// set up the informers
-	kubeClient, err := kubernetes.NewForConfig(c.Rest)
+	restConfig := c.Rest
+	if c.InformerBackoffCap > 0 {
+		restConfig = rest.CopyConfig(c.Rest)
+		restConfig.Wrap(newListBackoff(c.InformerBackoffCap))
+	}
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, fmt.Errorf("unable to construct lister client: %v", err)
 	}
 	// we should never need to resync, since we're not worried about missing events,
 	// and resync is actually for regular interval-based reconciliation these days,
-	// so set the default resync interval to 0
-	return informers.NewSharedInformerFactory(kubeClient, 0), nil
+	// so the resync period defaults to 0
+	return newInformerFactory(kubeClient, c.InformerResyncPeriod), nil
+}
+
+// newInformerFactory constructs the node and pod informer factory, replaced in tests.
+var newInformerFactory = informers.NewSharedInformerFactory
+
+// leaseInformer returns an informer factory for the node leases, which are
+// the only objects watched in their namespace.
+func (c Config) leaseInformer() (informers.SharedInformerFactory, error) {
+	kubeClient, err := kubernetes.NewForConfig(c.Rest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to construct lease lister client: %v", err)
+	}
+	return informers.NewSharedInformerFactoryWithOptions(kubeClient, 0, informers.WithNamespace(corev1.NamespaceNodeLease)), nil
 }
@@ -0,0 +1,84 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config", func() {
+	var (
+		resyncPeriods []time.Duration
+		clients       []kubernetes.Interface
+	)
+
+	BeforeEach(func() {
+		resyncPeriods = nil
+		clients = nil
+		newInformerFactory = func(client kubernetes.Interface, defaultResync time.Duration) informers.SharedInformerFactory {
+			resyncPeriods = append(resyncPeriods, defaultResync)
+			clients = append(clients, client)
+			return informers.NewSharedInformerFactory(client, defaultResync)
+		}
+	})
+	AfterEach(func() {
+		newInformerFactory = informers.NewSharedInformerFactory
+	})
+
+	It("should disable informer resync by default", func() {
+		_, err := Config{Rest: &rest.Config{Host: "https://10.96.0.1:443"}}.informer()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resyncPeriods).To(Equal([]time.Duration{0}))
+	})
+	It("should pass the informer resync period to the informer factory", func() {
+		_, err := Config{
+			Rest:                 &rest.Config{Host: "https://10.96.0.1:443"},
+			InformerResyncPeriod: 10 * time.Minute,
+		}.informer()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resyncPeriods).To(Equal([]time.Duration{10 * time.Minute}))
+	})
+	It("should delay informer lists after failures when a backoff cap is set", func() {
+		lists := 0
+		apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			lists++
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer apiserver.Close()
+		restConfig := &rest.Config{Host: apiserver.URL}
+		_, err := Config{Rest: restConfig, InformerBackoffCap: time.Minute}.informer()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restConfig.WrapTransport).To(BeNil())
+
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			_, err = clients[0].CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+			Expect(err).To(HaveOccurred())
+		}
+		Expect(time.Since(start)).To(BeNumerically(">=", informerBackoffBase))
+		Expect(lists).To(BeNumerically(">=", 2))
+	})
+})
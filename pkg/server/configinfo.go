@@ -0,0 +1,63 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	"k8s.io/component-base/metrics"
+)
+
+var configInfo = metrics.NewGaugeVec(
+	&metrics.GaugeOpts{
+		Namespace: "metrics_server",
+		Name:      "config_info",
+		Help:      "Always 1, labeled with key configuration values of metrics-server to detect configuration drift across replicas.",
+	},
+	[]string{"metric_resolution", "kubelet_insecure_tls", "kubelet_address_types_hash", "max_concurrent_scrapes"},
+)
+
+// recordConfigInfo sets configInfo to describe the configuration, replacing
+// any earlier description. Labels are limited to a few values which aren't
+// secret, with the address types hashed to keep them short.
+func (c Config) recordConfigInfo() {
+	var insecure bool
+	var addressTypes []string
+	if c.Kubelet != nil {
+		insecure = c.Kubelet.Client.TLSClientConfig.Insecure
+		for _, addressType := range c.Kubelet.AddressTypePriority {
+			addressTypes = append(addressTypes, string(addressType))
+		}
+	}
+	configInfo.Reset()
+	configInfo.WithLabelValues(
+		c.MetricResolution.String(),
+		strconv.FormatBool(insecure),
+		hashStrings(addressTypes),
+		strconv.Itoa(c.MaxConcurrentScrapes),
+	).Set(1)
+}
+
+// hashStrings returns a short hash of the ordered strings.
+func hashStrings(values []string) string {
+	h := fnv.New32a()
+	for _, value := range values {
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/metrics/testutil"
+
+	"sigs.k8s.io/metrics-server/pkg/scraper"
+)
+
+var _ = Describe("Config info", func() {
+	BeforeEach(func() {
+		configInfo.Create(nil)
+	})
+
+	It("should describe the configuration with its labels", func() {
+		c := Config{
+			MetricResolution:     15 * time.Second,
+			MaxConcurrentScrapes: 20,
+			Kubelet: &scraper.KubeletClientConfig{
+				Client:              rest.Config{TLSClientConfig: rest.TLSClientConfig{Insecure: true}},
+				AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeHostName},
+			},
+		}
+		c.recordConfigInfo()
+		err := testutil.CollectAndCompare(configInfo, strings.NewReader(`
+		# HELP metrics_server_config_info [ALPHA] Always 1, labeled with key configuration values of metrics-server to detect configuration drift across replicas.
+		# TYPE metrics_server_config_info gauge
+		metrics_server_config_info{kubelet_address_types_hash="`+hashStrings([]string{"InternalIP", "Hostname"})+`",kubelet_insecure_tls="true",max_concurrent_scrapes="20",metric_resolution="15s"} 1
+		`), "metrics_server_config_info")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should replace the description when recorded again", func() {
+		Config{MetricResolution: 15 * time.Second}.recordConfigInfo()
+		Config{MetricResolution: time.Minute}.recordConfigInfo()
+		err := testutil.CollectAndCompare(configInfo, strings.NewReader(`
+		# HELP metrics_server_config_info [ALPHA] Always 1, labeled with key configuration values of metrics-server to detect configuration drift across replicas.
+		# TYPE metrics_server_config_info gauge
+		metrics_server_config_info{kubelet_address_types_hash="`+hashStrings(nil)+`",kubelet_insecure_tls="false",max_concurrent_scrapes="0",metric_resolution="1m0s"} 1
+		`), "metrics_server_config_info")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should hash address types by order", func() {
+		Expect(hashStrings([]string{"InternalIP", "Hostname"})).NotTo(Equal(hashStrings([]string{"Hostname", "InternalIP"})))
+		Expect(hashStrings([]string{"InternalIP", "Hostname"})).To(HaveLen(8))
+	})
+})
@@ -0,0 +1,99 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/server/mux"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+)
+
+// cumulativeCPUPath is where the cumulative CPU counters of containers are
+// served. The path is authorized like every non-resource path, and clients
+// must also be allowed to list pod metrics in the requested namespace.
+const cumulativeCPUPath = "/extended/cumulative-cpu"
+
+// cumulativeContainer is a container in the cumulative CPU list.
+type cumulativeContainer struct {
+	Name                 string    `json:"name"`
+	UsageCoreNanoSeconds uint64    `json:"usageCoreNanoSeconds"`
+	Timestamp            time.Time `json:"timestamp"`
+}
+
+// cumulativePod is a pod in the cumulative CPU list.
+type cumulativePod struct {
+	Namespace  string                `json:"namespace"`
+	Name       string                `json:"name"`
+	Containers []cumulativeContainer `json:"containers"`
+}
+
+type cumulativeCPUList struct {
+	Pods []cumulativePod `json:"pods"`
+}
+
+// cumulativeCPU serves the latest cumulative CPU counter stored for each
+// container as JSON, so that clients can compute rates over windows of their
+// choice. Pods without any counter are left out. Like PodMetrics, pods are
+// served from the namespace query parameter, or from all namespaces if unset,
+// and never from excluded namespaces.
+type cumulativeCPU struct {
+	store              storageSnapshotter
+	authz              authorizer.Authorizer
+	excludedNamespaces sets.String
+}
+
+// Install adds the cumulative CPU handler
+func (c cumulativeCPU) Install(m *mux.PathRecorderMux) {
+	m.HandleFunc(cumulativeCPUPath, c.serve)
+}
+
+func (c cumulativeCPU) serve(w http.ResponseWriter, req *http.Request) {
+	namespace := req.URL.Query().Get("namespace")
+	if err := api.AuthorizeMetrics(req.Context(), c.authz, "list", "pods", namespace, ""); err != nil {
+		writeStatus(w, req, err)
+		return
+	}
+	list := cumulativeCPUList{Pods: []cumulativePod{}}
+	for _, pod := range c.store.Snapshot().Pods {
+		if (namespace != "" && pod.Namespace != namespace) || c.excludedNamespaces.Has(pod.Namespace) {
+			continue
+		}
+		item := cumulativePod{Namespace: pod.Namespace, Name: pod.Name}
+		for _, container := range pod.Containers {
+			if container.CumulativeCPU == nil {
+				continue
+			}
+			item.Containers = append(item.Containers, cumulativeContainer{
+				Name:                 container.Name,
+				UsageCoreNanoSeconds: container.CumulativeCPU.CoreNanoSeconds,
+				Timestamp:            container.CumulativeCPU.Timestamp,
+			})
+		}
+		if len(item.Containers) != 0 {
+			list.Pods = append(list.Pods, item)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		klog.Errorf("unable to write cumulative CPU counters: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/server/mux"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cumulative CPU", func() {
+	var (
+		store     storage.Storage
+		handlers  *mux.PathRecorderMux
+		timestamp = time.Date(2020, 10, 14, 12, 0, 0, 0, time.UTC)
+	)
+	BeforeEach(func() {
+		s := storage.NewStorage()
+		store = s
+		handlers = mux.NewPathRecorderMux("test")
+		cumulativeCPU{
+			store: s,
+			authz: authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+				if a.GetNamespace() == "forbidden" {
+					return authorizer.DecisionDeny, "forbidden", nil
+				}
+				return authorizer.DecisionAllow, "", nil
+			}),
+			excludedNamespaces: sets.NewString("kube-system"),
+		}.Install(handlers)
+	})
+	getPath := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	get := func() *httptest.ResponseRecorder {
+		return getPath(cumulativeCPUPath)
+	}
+	storePods := func(namespaces ...string) {
+		batch := &storage.MetricsBatch{}
+		for _, namespace := range namespaces {
+			batch.Pods = append(batch.Pods, storage.PodMetricsPoint{Namespace: namespace, Name: "pod1", Containers: []storage.ContainerMetricsPoint{
+				{Name: "container1", MetricsPoint: storage.MetricsPoint{Timestamp: timestamp, CumulativeCPU: &storage.CumulativeCPU{CoreNanoSeconds: 1, Timestamp: timestamp}}},
+			}})
+		}
+		store.Store(batch)
+	}
+
+	It("should serve the stored cumulative counters of containers", func() {
+		store.Store(&storage.MetricsBatch{Pods: []storage.PodMetricsPoint{
+			{Namespace: "ns1", Name: "pod1", Containers: []storage.ContainerMetricsPoint{
+				{Name: "container1", MetricsPoint: storage.MetricsPoint{Timestamp: timestamp, CumulativeCPU: &storage.CumulativeCPU{CoreNanoSeconds: 29328792, Timestamp: timestamp}}},
+				{Name: "container2", MetricsPoint: storage.MetricsPoint{Timestamp: timestamp}},
+			}},
+			{Namespace: "ns1", Name: "pod2", Containers: []storage.ContainerMetricsPoint{
+				{Name: "container1", MetricsPoint: storage.MetricsPoint{Timestamp: timestamp}},
+			}},
+		}})
+
+		rec := get()
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Body.String()).To(MatchJSON(`{"pods": [
+			{"namespace": "ns1", "name": "pod1", "containers": [
+				{"name": "container1", "usageCoreNanoSeconds": 29328792, "timestamp": "2020-10-14T12:00:00Z"}
+			]}
+		]}`))
+	})
+	It("should serve an empty list without stored counters", func() {
+		Expect(get().Body.String()).To(MatchJSON(`{"pods": []}`))
+	})
+	It("should leave out pods of excluded namespaces", func() {
+		storePods("ns1", "kube-system")
+
+		Expect(get().Body.String()).To(MatchJSON(`{"pods": [
+			{"namespace": "ns1", "name": "pod1", "containers": [
+				{"name": "container1", "usageCoreNanoSeconds": 1, "timestamp": "2020-10-14T12:00:00Z"}
+			]}
+		]}`))
+		Expect(getPath(cumulativeCPUPath + "?namespace=kube-system").Body.String()).To(MatchJSON(`{"pods": []}`))
+	})
+	It("should only serve pods of the requested namespace", func() {
+		storePods("ns1", "ns2")
+
+		Expect(getPath(cumulativeCPUPath + "?namespace=ns2").Body.String()).To(MatchJSON(`{"pods": [
+			{"namespace": "ns2", "name": "pod1", "containers": [
+				{"name": "container1", "usageCoreNanoSeconds": 1, "timestamp": "2020-10-14T12:00:00Z"}
+			]}
+		]}`))
+	})
+	It("should refuse clients not allowed to list pod metrics in the namespace", func() {
+		storePods("forbidden")
+
+		rec := getPath(cumulativeCPUPath + "?namespace=forbidden")
+		Expect(rec.Code).To(Equal(http.StatusForbidden))
+		Expect(rec.Body.String()).NotTo(ContainSubstring("container1"))
+	})
+})
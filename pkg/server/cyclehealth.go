@@ -0,0 +1,51 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/component-base/metrics"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+var unhealthyCycles = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "manager",
+		Name:      "unhealthy_cycles_total",
+		Help:      "Number of scrape cycles marked unhealthy because they returned metrics for less than the minimum success ratio of nodes.",
+	},
+)
+
+// cycleHealth marks scrape cycles returning metrics for less than
+// minSuccessRatio of the nodes known to the informer as unhealthy, failing
+// readiness until a cycle succeeds for enough nodes. Unlike cycleFilter, the
+// metrics of unhealthy cycles are still stored.
+type cycleHealth struct {
+	nodes           v1listers.NodeLister
+	minSuccessRatio float64
+}
+
+// healthy returns false if the batch has metrics for too few nodes. Batches
+// are healthy if the nodes can't be listed, as the ratio is unknown.
+func (h *cycleHealth) healthy(batch *storage.MetricsBatch) bool {
+	nodes, err := h.nodes.List(labels.Everything())
+	if err != nil || len(nodes) == 0 {
+		return true
+	}
+	return float64(len(batch.Nodes)) >= h.minSuccessRatio*float64(len(nodes))
+}
@@ -0,0 +1,406 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/server/mux"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/scraper"
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+// debugPathPrefix is the prefix of all debug endpoints. Like every
+// non-resource path, they are only served to authorized users.
+const debugPathPrefix = "/debug/metrics-server/"
+
+// DebugHandlers installs the debug endpoints enabled by --enable-debug-endpoints.
+type DebugHandlers struct {
+	// payloads is nil unless raw payload caching is enabled
+	payloads *scraper.PayloadCache
+	// store is nil unless the storage contents are served
+	store storageSnapshotter
+	// failures is nil unless failing nodes are served
+	failures nodeFailureLister
+	// pods is nil unless running pods missing from store are served with
+	// the failing nodes
+	pods v1listers.PodLister
+	// cycle is nil unless scrape cycles are profiled on demand, it runs one
+	// cycle and returns false if this replica doesn't scrape
+	cycle func(ctx context.Context) bool
+	// allocatables is nil unless the allocatable checked by scrapes is
+	// served, compared with the current allocatable of nodes.
+	allocatables nodeAllocatableLister
+	nodes        v1listers.NodeLister
+	// targets is nil unless the targets of the latest scrapes are served,
+	// those of nodes missing from nodes being left out.
+	targets scrapeTargetLister
+}
+
+// storageSnapshotter returns the points currently in storage.
+type storageSnapshotter interface {
+	Snapshot() *storage.MetricsBatch
+}
+
+// nodeFailureLister returns the nodes whose latest scrape failed.
+type nodeFailureLister interface {
+	NodeFailures() []scraper.NodeFailure
+}
+
+// nodeAllocatableLister returns the allocatable checked by the latest scrape of each node.
+type nodeAllocatableLister interface {
+	NodeAllocatables() []scraper.NodeAllocatable
+}
+
+// scrapeTargetLister returns the target of the latest scrape of each node.
+type scrapeTargetLister interface {
+	ScrapeTargets() []scraper.ScrapeTarget
+}
+
+// Install adds the debug handlers
+func (d DebugHandlers) Install(c *mux.PathRecorderMux) {
+	if d.payloads != nil {
+		c.HandlePrefix(debugPathPrefix+"kubelet-summary/", d.rawPayload())
+	}
+	if d.store != nil {
+		c.HandleFunc(debugPathPrefix+"storage", d.storageDump())
+	}
+	if d.failures != nil {
+		c.HandleFunc(debugPathPrefix+"failures", d.failureList())
+	}
+	if d.allocatables != nil && d.nodes != nil {
+		c.HandleFunc(debugPathPrefix+"allocatable", d.allocatableDrift())
+	}
+	if d.targets != nil && d.nodes != nil {
+		c.HandleFunc(debugPathPrefix+"targets", d.scrapeTargetList())
+	}
+	if d.cycle != nil {
+		c.HandleFunc(debugPathPrefix+"cycle-profile", d.cycleProfile())
+	}
+}
+
+// storedPoint is a node or container point in the storage dump.
+type storedPoint struct {
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	CPU       string    `json:"cpu"`
+	Memory    string    `json:"memory"`
+}
+
+// storedPod is a pod in the storage dump.
+type storedPod struct {
+	Namespace  string        `json:"namespace"`
+	Name       string        `json:"name"`
+	Containers []storedPoint `json:"containers"`
+}
+
+func newStoredPoint(name string, point storage.MetricsPoint) storedPoint {
+	return storedPoint{
+		Name:      name,
+		Timestamp: point.Timestamp,
+		CPU:       point.CpuUsage.String(),
+		Memory:    point.MemoryUsage.String(),
+	}
+}
+
+func newStoredPod(pod storage.PodMetricsPoint) storedPod {
+	item := storedPod{Namespace: pod.Namespace, Name: pod.Name, Containers: make([]storedPoint, len(pod.Containers))}
+	for i, container := range pod.Containers {
+		item.Containers[i] = newStoredPoint(container.Name, container.MetricsPoint)
+	}
+	return item
+}
+
+// storageDump serves the latest stored point of every node and pod as JSON.
+// Points are encoded one by one, so that the response is streamed instead of
+// buffered for large clusters.
+func (d DebugHandlers) storageDump() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		snapshot := d.store.Snapshot()
+		w.Header().Set("Content-Type", "application/json")
+		if err := writeStorageDump(w, snapshot); err != nil {
+			klog.Errorf("unable to write storage dump: %v", err)
+		}
+	}
+}
+
+func writeStorageDump(w io.Writer, snapshot *storage.MetricsBatch) error {
+	containers := 0
+	for _, pod := range snapshot.Pods {
+		containers += len(pod.Containers)
+	}
+	if _, err := fmt.Fprintf(w, `{"nodeCount":%d,"podCount":%d,"containerCount":%d,"nodes":[`, len(snapshot.Nodes), len(snapshot.Pods), containers); err != nil {
+		return err
+	}
+	for i, node := range snapshot.Nodes {
+		if err := writeDumpItem(w, i, newStoredPoint(node.Name, node.MetricsPoint)); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, `],"pods":[`); err != nil {
+		return err
+	}
+	for i, pod := range snapshot.Pods {
+		if err := writeDumpItem(w, i, newStoredPod(pod)); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]}\n")
+	return err
+}
+
+// writeDumpItem writes an array item, preceded by a separator unless it's the first one.
+func writeDumpItem(w io.Writer, index int, item interface{}) error {
+	if index > 0 {
+		if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// failingNode is a node in the failure list.
+type failingNode struct {
+	Name                string    `json:"name"`
+	LastError           string    `json:"lastError"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	Since               time.Time `json:"since"`
+}
+
+// missingPod is a running pod without stored metrics in the failure list.
+type missingPod struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Node      string `json:"node"`
+}
+
+type failureList struct {
+	Nodes []failingNode `json:"nodes"`
+	Pods  []missingPod  `json:"pods"`
+}
+
+// failureList serves the nodes whose latest scrape failed, and the running
+// pods without stored metrics, as JSON.
+func (d DebugHandlers) failureList() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		list := failureList{Nodes: []failingNode{}, Pods: []missingPod{}}
+		for _, failure := range d.failures.NodeFailures() {
+			list.Nodes = append(list.Nodes, failingNode{
+				Name:                failure.Node,
+				LastError:           failure.LastError,
+				ConsecutiveFailures: failure.ConsecutiveFailures,
+				Since:               failure.Since,
+			})
+		}
+		if d.pods != nil && d.store != nil {
+			pods, err := d.missingPods()
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unable to list pods: %v", err), http.StatusInternalServerError)
+				return
+			}
+			list.Pods = pods
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			klog.Errorf("unable to write failure list: %v", err)
+		}
+	}
+}
+
+// missingPods returns the running pods without stored metrics, sorted by
+// namespace and name.
+func (d DebugHandlers) missingPods() ([]missingPod, error) {
+	pods, err := d.pods.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	stored := map[apitypes.NamespacedName]bool{}
+	for _, pod := range d.store.Snapshot().Pods {
+		stored[apitypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}] = true
+	}
+	missing := []missingPod{}
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning || stored[apitypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}] {
+			continue
+		}
+		missing = append(missing, missingPod{Namespace: pod.Namespace, Name: pod.Name, Node: pod.Spec.NodeName})
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].Namespace != missing[j].Namespace {
+			return missing[i].Namespace < missing[j].Namespace
+		}
+		return missing[i].Name < missing[j].Name
+	})
+	return missing, nil
+}
+
+// nodeAllocatable is a node in the allocatable drift list.
+type nodeAllocatable struct {
+	Name string `json:"name"`
+	// Used is the allocatable checked by the latest scrape of the node.
+	Used       corev1.ResourceList `json:"used"`
+	ScrapeTime time.Time           `json:"scrapeTime"`
+	// Current is the allocatable currently listed, nil if the node is gone.
+	Current corev1.ResourceList `json:"current"`
+	Drift   bool                `json:"drift"`
+}
+
+type allocatableDrift struct {
+	Nodes []nodeAllocatable `json:"nodes"`
+}
+
+// allocatableDrift serves the allocatable checked by the latest scrape of
+// each node next to the one currently listed, flagging the nodes whose CPU or
+// memory allocatable changed since, as JSON.
+func (d DebugHandlers) allocatableDrift() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		list := allocatableDrift{Nodes: []nodeAllocatable{}}
+		for _, used := range d.allocatables.NodeAllocatables() {
+			item := nodeAllocatable{Name: used.Node, Used: used.Allocatable, ScrapeTime: used.Time, Drift: true}
+			node, err := d.nodes.Get(used.Node)
+			if err != nil && !apierrors.IsNotFound(err) {
+				http.Error(w, fmt.Sprintf("unable to get node %s: %v", used.Node, err), http.StatusInternalServerError)
+				return
+			}
+			if err == nil {
+				item.Current = corev1.ResourceList{}
+				for _, resource := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+					if quantity, found := node.Status.Allocatable[resource]; found {
+						item.Current[resource] = quantity
+					}
+				}
+				item.Drift = !equalResources(item.Used, item.Current)
+			}
+			list.Nodes = append(list.Nodes, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			klog.Errorf("unable to write allocatable drift: %v", err)
+		}
+	}
+}
+
+func equalResources(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for resource, quantity := range a {
+		other, found := b[resource]
+		if !found || quantity.Cmp(other) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// scrapeTargetsVersion is the version of the scrape target list schema,
+// changed on incompatible changes so that tooling can detect them.
+const scrapeTargetsVersion = "v1"
+
+// scrapeTarget is a node in the scrape target list.
+type scrapeTarget struct {
+	Node string `json:"node"`
+	// AddressType is empty if the address doesn't come from the node status.
+	AddressType  corev1.NodeAddressType `json:"addressType"`
+	Host         string                 `json:"host"`
+	Port         int                    `json:"port"`
+	Scheme       string                 `json:"scheme"`
+	ViaAPIServer bool                   `json:"viaAPIServer"`
+	// LastOutcome is either success or failure.
+	LastOutcome    string    `json:"lastOutcome"`
+	LastScrapeTime time.Time `json:"lastScrapeTime"`
+	LastError      string    `json:"lastError,omitempty"`
+}
+
+type scrapeTargetList struct {
+	Version string         `json:"version"`
+	Targets []scrapeTarget `json:"targets"`
+}
+
+// scrapeTargetList serves the target of the latest scrape of each node still
+// listed, with its outcome, as JSON.
+func (d DebugHandlers) scrapeTargetList() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		list := scrapeTargetList{Version: scrapeTargetsVersion, Targets: []scrapeTarget{}}
+		for _, target := range d.targets.ScrapeTargets() {
+			if _, err := d.nodes.Get(target.Node); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				http.Error(w, fmt.Sprintf("unable to get node %s: %v", target.Node, err), http.StatusInternalServerError)
+				return
+			}
+			item := scrapeTarget{
+				Node:           target.Node,
+				AddressType:    target.AddressType,
+				Host:           target.Host,
+				Port:           target.Port,
+				Scheme:         target.Scheme,
+				ViaAPIServer:   target.ViaAPIServer,
+				LastOutcome:    "success",
+				LastScrapeTime: target.Time,
+				LastError:      target.Error,
+			}
+			if target.Error != "" {
+				item.LastOutcome = "failure"
+			}
+			list.Targets = append(list.Targets, item)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			klog.Errorf("unable to write scrape target list: %v", err)
+		}
+	}
+}
+
+// rawPayload serves the latest raw summary payload scraped from the node
+// named by the last path segment.
+func (d DebugHandlers) rawPayload() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		node := strings.TrimPrefix(req.URL.Path, debugPathPrefix+"kubelet-summary/")
+		if node == "" || strings.Contains(node, "/") {
+			http.Error(w, "expected path "+debugPathPrefix+"kubelet-summary/<node>", http.StatusBadRequest)
+			return
+		}
+		payload, found := d.payloads.Get(node)
+		if !found {
+			http.Error(w, fmt.Sprintf("no payload cached for node %q", node), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Last-Modified", payload.Time.UTC().Format(http.TimeFormat))
+		w.Header().Set("X-Scrape-Time", payload.Time.UTC().Format(time.RFC3339Nano))
+		w.Write(payload.Body)
+	}
+}
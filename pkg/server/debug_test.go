@@ -0,0 +1,320 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/server/mux"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"sigs.k8s.io/metrics-server/pkg/scraper"
+	"sigs.k8s.io/metrics-server/pkg/storage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Debug handlers", func() {
+	var (
+		payloads *scraper.PayloadCache
+		handlers *mux.PathRecorderMux
+	)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+
+	BeforeEach(func() {
+		payloads = scraper.NewPayloadCache()
+		payloads.Set("node1", []byte(`{"node":{"nodeName":"node1"}}`))
+		handlers = mux.NewPathRecorderMux("test")
+	})
+
+	It("should serve the cached raw payload of a node", func() {
+		DebugHandlers{payloads: payloads}.Install(handlers)
+		rec := get("/debug/metrics-server/kubelet-summary/node1")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Body.String()).To(Equal(`{"node":{"nodeName":"node1"}}`))
+	})
+	It("should return not found for nodes without a cached payload", func() {
+		DebugHandlers{payloads: payloads}.Install(handlers)
+		Expect(get("/debug/metrics-server/kubelet-summary/node2").Code).To(Equal(http.StatusNotFound))
+	})
+	It("should reject requests without a node name", func() {
+		DebugHandlers{payloads: payloads}.Install(handlers)
+		Expect(get("/debug/metrics-server/kubelet-summary/").Code).To(Equal(http.StatusBadRequest))
+	})
+	It("should keep serving payloads which failed to parse after the cycle", func() {
+		kubelet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("{invalid"))
+		}))
+		defer kubelet.Close()
+		client, err := scraper.KubeletClientConfig{
+			Scheme:              "http",
+			DefaultPort:         kubelet.Listener.Addr().(*net.TCPAddr).Port,
+			AddressTypePriority: []corev1.NodeAddressType{corev1.NodeInternalIP},
+			RawPayloads:         payloads,
+		}.Complete()
+		Expect(err).NotTo(HaveOccurred())
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(indexer.Add(&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "127.0.0.1"}}},
+		})).To(Succeed())
+		s := NewServer(nil, nil, nil, &storageMock{}, scraper.NewScraper(client, 3*time.Second, 0), v1listers.NewNodeLister(indexer), time.Minute)
+
+		s.tick(context.Background(), time.Now())
+		DebugHandlers{payloads: payloads}.Install(handlers)
+		rec := get("/debug/metrics-server/kubelet-summary/node1")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal("{invalid"))
+	})
+	It("should not serve payloads when raw payload caching is disabled", func() {
+		DebugHandlers{}.Install(handlers)
+		Expect(get("/debug/metrics-server/kubelet-summary/node1").Code).To(Equal(http.StatusNotFound))
+	})
+	Describe("storage dump", func() {
+		var (
+			store     storageSnapshotter
+			timestamp = time.Date(2020, 10, 14, 12, 0, 0, 0, time.UTC)
+		)
+
+		point := func(cpu, memory string) storage.MetricsPoint {
+			return storage.MetricsPoint{Timestamp: timestamp, CpuUsage: resource.MustParse(cpu), MemoryUsage: resource.MustParse(memory)}
+		}
+
+		BeforeEach(func() {
+			s := storage.NewStorage()
+			s.Store(&storage.MetricsBatch{
+				Nodes: []storage.NodeMetricsPoint{
+					{Name: "node2", MetricsPoint: point("2", "2Gi")},
+					{Name: "node1", MetricsPoint: point("1500m", "1Gi")},
+				},
+				Pods: []storage.PodMetricsPoint{
+					{Name: "pod1", Namespace: "ns2", Containers: []storage.ContainerMetricsPoint{
+						{Name: "container1", MetricsPoint: point("10m", "10Mi")},
+					}},
+					{Name: "pod1", Namespace: "ns1", Containers: []storage.ContainerMetricsPoint{
+						{Name: "container1", MetricsPoint: point("100m", "100Mi")},
+						{Name: "container2", MetricsPoint: point("200m", "200Mi")},
+					}},
+				},
+			})
+			store = s
+		})
+
+		It("should serve the stored points sorted as JSON", func() {
+			DebugHandlers{store: store}.Install(handlers)
+			rec := get("/debug/metrics-server/storage")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(json.Valid(rec.Body.Bytes())).To(BeTrue())
+			Expect(rec.Body.String()).To(MatchJSON(`{
+				"nodeCount": 2, "podCount": 2, "containerCount": 3,
+				"nodes": [
+					{"name": "node1", "timestamp": "2020-10-14T12:00:00Z", "cpu": "1500m", "memory": "1Gi"},
+					{"name": "node2", "timestamp": "2020-10-14T12:00:00Z", "cpu": "2", "memory": "2Gi"}
+				],
+				"pods": [
+					{"namespace": "ns1", "name": "pod1", "containers": [
+						{"name": "container1", "timestamp": "2020-10-14T12:00:00Z", "cpu": "100m", "memory": "100Mi"},
+						{"name": "container2", "timestamp": "2020-10-14T12:00:00Z", "cpu": "200m", "memory": "200Mi"}
+					]},
+					{"namespace": "ns2", "name": "pod1", "containers": [
+						{"name": "container1", "timestamp": "2020-10-14T12:00:00Z", "cpu": "10m", "memory": "10Mi"}
+					]}
+				]
+			}`))
+		})
+		It("should serve empty storage", func() {
+			DebugHandlers{store: storage.NewStorage()}.Install(handlers)
+			rec := get("/debug/metrics-server/storage")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(`{"nodeCount": 0, "podCount": 0, "containerCount": 0, "nodes": [], "pods": []}`))
+		})
+		It("should not serve the storage unless configured", func() {
+			DebugHandlers{payloads: payloads}.Install(handlers)
+			Expect(get("/debug/metrics-server/storage").Code).To(Equal(http.StatusNotFound))
+		})
+	})
+	Describe("failure list", func() {
+		var (
+			failures fakeNodeFailures
+			pods     v1listers.PodLister
+			store    storageSnapshotter
+			since    = time.Date(2020, 10, 14, 12, 0, 0, 0, time.UTC)
+		)
+
+		pod := func(namespace, name, node string, phase corev1.PodPhase) *corev1.Pod {
+			return &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+				Spec:       corev1.PodSpec{NodeName: node},
+				Status:     corev1.PodStatus{Phase: phase},
+			}
+		}
+
+		BeforeEach(func() {
+			failures = fakeNodeFailures{{Node: "node2", LastError: "unable to fetch metrics from node node2: connection refused", ConsecutiveFailures: 3, Since: since}}
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, p := range []*corev1.Pod{
+				pod("ns1", "pod1", "node1", corev1.PodRunning),
+				pod("ns1", "pod2", "node2", corev1.PodRunning),
+				pod("ns1", "pod3", "node2", corev1.PodSucceeded),
+			} {
+				Expect(indexer.Add(p)).To(Succeed())
+			}
+			pods = v1listers.NewPodLister(indexer)
+			s := storage.NewStorage()
+			s.Store(&storage.MetricsBatch{
+				Nodes: []storage.NodeMetricsPoint{{Name: "node1", MetricsPoint: storage.MetricsPoint{Timestamp: since}}},
+				Pods: []storage.PodMetricsPoint{{Name: "pod1", Namespace: "ns1", Containers: []storage.ContainerMetricsPoint{
+					{Name: "container1", MetricsPoint: storage.MetricsPoint{Timestamp: since}},
+				}}},
+			})
+			store = s
+		})
+
+		It("should list failing nodes and running pods without metrics", func() {
+			DebugHandlers{store: store, failures: failures, pods: pods}.Install(handlers)
+			rec := get("/debug/metrics-server/failures")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(rec.Body.String()).To(MatchJSON(`{
+				"nodes": [
+					{"name": "node2", "lastError": "unable to fetch metrics from node node2: connection refused", "consecutiveFailures": 3, "since": "2020-10-14T12:00:00Z"}
+				],
+				"pods": [
+					{"namespace": "ns1", "name": "pod2", "node": "node2"}
+				]
+			}`))
+		})
+		It("should serve empty lists without failures", func() {
+			DebugHandlers{failures: fakeNodeFailures{}}.Install(handlers)
+			Expect(get("/debug/metrics-server/failures").Body.String()).To(MatchJSON(`{"nodes": [], "pods": []}`))
+		})
+		It("should not serve failures unless configured", func() {
+			DebugHandlers{payloads: payloads}.Install(handlers)
+			Expect(get("/debug/metrics-server/failures").Code).To(Equal(http.StatusNotFound))
+		})
+	})
+	Describe("allocatable drift", func() {
+		var (
+			indexer    cache.Indexer
+			scrapeTime = time.Date(2020, 10, 14, 12, 0, 0, 0, time.UTC)
+		)
+
+		node := func(name, cpu, memory string) *corev1.Node {
+			return &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse(cpu),
+					corev1.ResourceMemory: resource.MustParse(memory),
+				}},
+			}
+		}
+		used := func(node *corev1.Node) scraper.NodeAllocatable {
+			return scraper.NodeAllocatable{Node: node.Name, Allocatable: node.Status.Allocatable, Time: scrapeTime}
+		}
+
+		BeforeEach(func() {
+			indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		})
+
+		It("should flag nodes whose allocatable changed since the scrape", func() {
+			node1, node2, node3 := node("node1", "2", "4Gi"), node("node2", "4", "8Gi"), node("node3", "1", "1Gi")
+			for _, n := range []*corev1.Node{node1, node("node2", "3500m", "8Gi")} {
+				Expect(indexer.Add(n)).To(Succeed())
+			}
+			allocatables := fakeNodeAllocatables{used(node1), used(node2), used(node3)}
+			DebugHandlers{allocatables: allocatables, nodes: v1listers.NewNodeLister(indexer)}.Install(handlers)
+
+			rec := get("/debug/metrics-server/allocatable")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(rec.Body.String()).To(MatchJSON(`{"nodes": [
+				{"name": "node1", "used": {"cpu": "2", "memory": "4Gi"}, "scrapeTime": "2020-10-14T12:00:00Z", "current": {"cpu": "2", "memory": "4Gi"}, "drift": false},
+				{"name": "node2", "used": {"cpu": "4", "memory": "8Gi"}, "scrapeTime": "2020-10-14T12:00:00Z", "current": {"cpu": "3500m", "memory": "8Gi"}, "drift": true},
+				{"name": "node3", "used": {"cpu": "1", "memory": "1Gi"}, "scrapeTime": "2020-10-14T12:00:00Z", "current": null, "drift": true}
+			]}`))
+		})
+		It("should not flag equal quantities in different formats", func() {
+			Expect(indexer.Add(node("node1", "2000m", "4096Mi"))).To(Succeed())
+			DebugHandlers{allocatables: fakeNodeAllocatables{used(node("node1", "2", "4Gi"))}, nodes: v1listers.NewNodeLister(indexer)}.Install(handlers)
+
+			var list allocatableDrift
+			Expect(json.Unmarshal(get("/debug/metrics-server/allocatable").Body.Bytes(), &list)).To(Succeed())
+			Expect(list.Nodes).To(HaveLen(1))
+			Expect(list.Nodes[0].Drift).To(BeFalse())
+		})
+		It("should not serve allocatable unless configured", func() {
+			DebugHandlers{payloads: payloads}.Install(handlers)
+			Expect(get("/debug/metrics-server/allocatable").Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("scrape targets", func() {
+		scrapeTime := time.Date(2020, 10, 14, 12, 0, 0, 0, time.UTC)
+		targets := fakeScrapeTargets{
+			{Node: "node1", AddressType: corev1.NodeInternalIP, Host: "10.0.0.1", Port: 10250, Scheme: "https", Time: scrapeTime},
+			{Node: "node2", Host: "kubelet.example.com", Port: 10443, Scheme: "https", Time: scrapeTime, Error: "connection refused"},
+			{Node: "node3", AddressType: corev1.NodeInternalIP, Host: "10.0.0.3", Port: 10250, Scheme: "https", Time: scrapeTime},
+		}
+
+		It("should serve the targets of listed nodes", func() {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, name := range []string{"node1", "node2"} {
+				Expect(indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+			}
+			DebugHandlers{targets: targets, nodes: v1listers.NewNodeLister(indexer)}.Install(handlers)
+
+			rec := get("/debug/metrics-server/targets")
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			Expect(rec.Body.String()).To(MatchJSON(`{"version": "v1", "targets": [
+				{"node": "node1", "addressType": "InternalIP", "host": "10.0.0.1", "port": 10250, "scheme": "https", "viaAPIServer": false, "lastOutcome": "success", "lastScrapeTime": "2020-10-14T12:00:00Z"},
+				{"node": "node2", "addressType": "", "host": "kubelet.example.com", "port": 10443, "scheme": "https", "viaAPIServer": false, "lastOutcome": "failure", "lastScrapeTime": "2020-10-14T12:00:00Z", "lastError": "connection refused"}
+			]}`))
+		})
+		It("should not serve targets unless configured", func() {
+			DebugHandlers{payloads: payloads}.Install(handlers)
+			Expect(get("/debug/metrics-server/targets").Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
+
+type fakeNodeFailures []scraper.NodeFailure
+
+func (f fakeNodeFailures) NodeFailures() []scraper.NodeFailure { return f }
+
+type fakeScrapeTargets []scraper.ScrapeTarget
+
+func (f fakeScrapeTargets) ScrapeTargets() []scraper.ScrapeTarget { return f }
+
+type fakeNodeAllocatables []scraper.NodeAllocatable
+
+func (f fakeNodeAllocatables) NodeAllocatables() []scraper.NodeAllocatable { return f }
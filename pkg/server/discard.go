@@ -0,0 +1,56 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/component-base/metrics"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+var discardedCycles = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "manager",
+		Name:      "discarded_cycles_total",
+		Help:      "Number of scrape cycles discarded because they returned metrics for too few nodes.",
+	},
+)
+
+// cycleFilter discards scrape cycles returning metrics for no node, or for
+// less than minNodeFraction of the nodes known to the informer, so that a
+// transient cluster-wide failure doesn't replace all stored metrics.
+type cycleFilter struct {
+	nodes           v1listers.NodeLister
+	minNodeFraction float64
+}
+
+// discard returns true if the batch shouldn't be stored. Batches are kept if
+// the nodes can't be listed, as the fraction is unknown.
+func (f *cycleFilter) discard(batch *storage.MetricsBatch) bool {
+	if len(batch.Nodes) == 0 {
+		return true
+	}
+	if f.minNodeFraction <= 0 {
+		return false
+	}
+	nodes, err := f.nodes.List(labels.Everything())
+	if err != nil {
+		return false
+	}
+	return float64(len(batch.Nodes)) < f.minNodeFraction*float64(len(nodes))
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// discoveryCache serves repeated discovery requests for the metrics API from
+// the cached responses of the API handler. Entries are stamped with the
+// served versions, so that they are dropped as soon as those change, and
+// expire after the TTL otherwise.
+type discoveryCache struct {
+	handler  http.Handler
+	ttl      time.Duration
+	versions func() []string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]discoveryEntry
+}
+
+type discoveryEntry struct {
+	stamp   string
+	expires time.Time
+	header  http.Header
+	body    []byte
+}
+
+// withDiscoveryCache caches the discovery documents of the metrics API for
+// the TTL, serving them with an ETag. It is installed after authorization, so
+// cached documents are only served to authorized users. Zero disables it.
+func withDiscoveryCache(handler http.Handler, ttl time.Duration, versions func() []string) http.Handler {
+	if ttl <= 0 {
+		return handler
+	}
+	return &discoveryCache{
+		handler:  handler,
+		ttl:      ttl,
+		versions: versions,
+		now:      time.Now,
+		entries:  map[string]discoveryEntry{},
+	}
+}
+
+func (c *discoveryCache) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	versions := c.versions()
+	stamp := strings.Join(versions, ",")
+	if req.Method != http.MethodGet || !isDiscoveryPath(req.URL.Path, versions) {
+		c.handler.ServeHTTP(w, req)
+		return
+	}
+	// responses are negotiated, so each representation is cached on its own
+	key := strings.Join([]string{req.URL.Path, req.Header.Get("Accept"), req.Header.Get("Accept-Encoding")}, "\x00")
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	c.mu.Unlock()
+	if !found || entry.stamp != stamp || !c.now().Before(entry.expires) {
+		recorder := &discoveryRecorder{header: http.Header{}, status: http.StatusOK}
+		c.handler.ServeHTTP(recorder, req)
+		if recorder.status != http.StatusOK {
+			recorder.writeTo(w)
+			return
+		}
+		entry = discoveryEntry{stamp: stamp, expires: c.now().Add(c.ttl), header: recorder.header, body: recorder.body.Bytes()}
+		entry.header.Set("ETag", discoveryETag(stamp, entry.body))
+		c.mu.Lock()
+		c.entries[key] = entry
+		c.mu.Unlock()
+	}
+	for name, values := range entry.header {
+		w.Header()[name] = values
+	}
+	if req.Header.Get("If-None-Match") == entry.header.Get("ETag") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(entry.body)
+}
+
+// isDiscoveryPath returns true for the list of API groups, and the discovery
+// documents of the metrics API group and its served versions.
+func isDiscoveryPath(path string, versions []string) bool {
+	group := "/apis/" + metrics.GroupName
+	if path == "/apis" || path == group {
+		return true
+	}
+	for _, version := range versions {
+		if path == group+"/"+version {
+			return true
+		}
+	}
+	return false
+}
+
+// discoveryETag stamps the document with the versions it was served for.
+func discoveryETag(stamp string, body []byte) string {
+	h := fnv.New64a()
+	h.Write([]byte(stamp))
+	h.Write([]byte{0})
+	h.Write(body)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// discoveryRecorder keeps the response of the API handler to cache it.
+type discoveryRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *discoveryRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *discoveryRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status, r.wroteHeader = status, true
+}
+
+func (r *discoveryRecorder) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
+
+// writeTo passes the recorded response on as it is.
+func (r *discoveryRecorder) writeTo(w http.ResponseWriter) {
+	for name, values := range r.header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(r.status)
+	w.Write(r.body.Bytes())
+}
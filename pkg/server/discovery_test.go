@@ -0,0 +1,122 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Discovery cache", func() {
+	var (
+		versions []string
+		calls    int
+		now      time.Time
+		cache    *discoveryCache
+	)
+	BeforeEach(func() {
+		versions = []string{"v1beta1"}
+		calls = 0
+		now = time.Now()
+		// the handler renders discovery from the versions served at the time
+		handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			calls++
+			if req.URL.Path == "/apis/metrics.k8s.io/missing" {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"versions":%q}`, strings.Join(versions, ","))
+		})
+		cache = withDiscoveryCache(handler, time.Minute, func() []string { return versions }).(*discoveryCache)
+		cache.now = func() time.Time { return now }
+	})
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		cache.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should serve discovery of the served versions from the cache within the TTL", func() {
+		first := get("/apis/metrics.k8s.io")
+		Expect(first.Code).To(Equal(http.StatusOK))
+		Expect(first.Body.String()).To(Equal(`{"versions":"v1beta1"}`))
+		Expect(first.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		now = now.Add(30 * time.Second)
+		second := get("/apis/metrics.k8s.io")
+		Expect(second.Body.String()).To(Equal(first.Body.String()))
+		Expect(second.Header().Get("ETag")).To(Equal(first.Header().Get("ETag")))
+		Expect(calls).To(Equal(1))
+
+		By("rendering the document again once the TTL passed")
+		now = now.Add(time.Minute)
+		get("/apis/metrics.k8s.io")
+		Expect(calls).To(Equal(2))
+	})
+	It("should drop cached documents as soon as the served versions change", func() {
+		get("/apis/metrics.k8s.io")
+		stale := get("/apis/metrics.k8s.io/v1beta1")
+
+		versions = []string{"v1", "v1beta1"}
+		Expect(get("/apis/metrics.k8s.io").Body.String()).To(Equal(`{"versions":"v1,v1beta1"}`))
+		Expect(get("/apis/metrics.k8s.io/v1").Code).To(Equal(http.StatusOK))
+		Expect(get("/apis/metrics.k8s.io/v1beta1").Header().Get("ETag")).NotTo(Equal(stale.Header().Get("ETag")))
+		Expect(calls).To(Equal(5))
+	})
+	It("should cache each negotiated representation on its own", func() {
+		get("/apis/metrics.k8s.io", "Accept", "application/json")
+		get("/apis/metrics.k8s.io", "Accept", "application/vnd.kubernetes.protobuf")
+		get("/apis/metrics.k8s.io", "Accept", "application/json")
+		Expect(calls).To(Equal(2))
+	})
+	It("should answer matching ETags with 304 Not Modified", func() {
+		etag := get("/apis").Header().Get("ETag")
+		Expect(etag).NotTo(BeEmpty())
+
+		w := get("/apis", "If-None-Match", etag)
+		Expect(w.Code).To(Equal(http.StatusNotModified))
+		Expect(w.Body.Len()).To(BeZero())
+		Expect(calls).To(Equal(1))
+	})
+	It("should pass other requests and failed responses through uncached", func() {
+		get("/apis/metrics.k8s.io/v1beta1/nodes")
+		get("/apis/metrics.k8s.io/v1beta1/nodes")
+		Expect(get("/apis/metrics.k8s.io/v1").Code).To(Equal(http.StatusOK))
+		Expect(calls).To(Equal(3))
+
+		req := httptest.NewRequest(http.MethodPost, "/apis", nil)
+		cache.ServeHTTP(httptest.NewRecorder(), req)
+		cache.ServeHTTP(httptest.NewRecorder(), req)
+		Expect(calls).To(Equal(5))
+	})
+	It("should not cache with a zero TTL", func() {
+		handler := withDiscoveryCache(cache.handler, 0, func() []string { return versions })
+		Expect(handler).NotTo(BeAssignableToTypeOf(&discoveryCache{}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/apis", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/apis", nil))
+		Expect(calls).To(Equal(2))
+	})
+})
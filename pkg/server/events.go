@@ -0,0 +1,263 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/server/mux"
+	"k8s.io/klog/v2"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+)
+
+// eventsPath is where the stream of served metrics is pushed. The path is
+// authorized like every non-resource path, and clients must also be allowed
+// to list the metrics of all nodes and pods.
+const eventsPath = "/events/metrics"
+
+// metricsEvent is the data of an event, the NodeMetrics and PodMetrics which
+// changed since the previous event, and the nodes and pods no longer served.
+// Full events list all served nodes and pods instead, and are sent first and
+// to clients which fell behind.
+type metricsEvent struct {
+	Full         bool                  `json:"full"`
+	Nodes        []v1beta1.NodeMetrics `json:"nodes"`
+	Pods         []v1beta1.PodMetrics  `json:"pods"`
+	RemovedNodes []string              `json:"removedNodes,omitempty"`
+	RemovedPods  []string              `json:"removedPods,omitempty"`
+}
+
+// metricsLister lists the metrics served by the metrics.k8s.io API.
+type metricsLister interface {
+	ListNodeMetrics(ctx context.Context) ([]v1beta1.NodeMetrics, error)
+	ListPodMetrics(ctx context.Context, namespace string) ([]v1beta1.PodMetrics, error)
+}
+
+// eventStream pushes the metrics served after each scrape cycle to subscribed
+// clients as Server-Sent Events, filtered and rounded like the metrics.k8s.io
+// API serves them. Publishing never blocks on clients: a client which hasn't
+// read the previous event is sent a single full event instead.
+type eventStream struct {
+	metrics metricsLister
+	authz   authorizer.Authorizer
+
+	// publishing serializes publish calls, so events are built in order
+	publishing sync.Mutex
+
+	mu sync.Mutex
+	// subscribers maps the events of each client to whether it was sent a
+	// full event yet
+	subscribers map[chan []byte]bool
+	// previous is the full event of the latest event, nil before the first
+	// one and while there are no subscribers
+	previous *metricsEvent
+	// full is the encoded previous event
+	full []byte
+}
+
+func newEventStream(metrics metricsLister, authz authorizer.Authorizer) *eventStream {
+	return &eventStream{metrics: metrics, authz: authz, subscribers: map[chan []byte]bool{}}
+}
+
+// Install adds the event stream handler
+func (e *eventStream) Install(c *mux.PathRecorderMux) {
+	c.Handle(eventsPath, e)
+}
+
+// publish sends the changes of the served metrics since the previous event to
+// all subscribers, and a full event to those which weren't sent one yet.
+// Nothing is listed nor encoded while there are no subscribers.
+func (e *eventStream) publish(ctx context.Context) {
+	e.publishing.Lock()
+	defer e.publishing.Unlock()
+
+	e.mu.Lock()
+	subscribed := len(e.subscribers) != 0
+	if !subscribed {
+		e.previous, e.full = nil, nil
+	}
+	e.mu.Unlock()
+	if !subscribed {
+		return
+	}
+
+	latest, err := e.list(ctx)
+	if err != nil {
+		klog.Errorf("unable to list metrics for event: %v", err)
+		return
+	}
+	changed, err := encodeEvent(changedMetrics(e.previous, latest))
+	if err != nil {
+		klog.Errorf("unable to encode metrics event: %v", err)
+		return
+	}
+	full, err := encodeEvent(*latest)
+	if err != nil {
+		klog.Errorf("unable to encode metrics event: %v", err)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.previous, e.full = latest, full
+	for events, synced := range e.subscribers {
+		data := changed
+		if !synced {
+			data = full
+			e.subscribers[events] = true
+		}
+		select {
+		case events <- data:
+		default:
+			// the client is behind, replace its pending event by the full set
+			select {
+			case <-events:
+			default:
+			}
+			events <- full
+		}
+	}
+}
+
+// list returns a full event of the metrics currently served.
+func (e *eventStream) list(ctx context.Context) (*metricsEvent, error) {
+	nodes, err := e.metrics.ListNodeMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := e.metrics.ListPodMetrics(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	event := &metricsEvent{Full: true, Nodes: nodes, Pods: pods}
+	if event.Nodes == nil {
+		event.Nodes = []v1beta1.NodeMetrics{}
+	}
+	if event.Pods == nil {
+		event.Pods = []v1beta1.PodMetrics{}
+	}
+	return event, nil
+}
+
+// subscribe registers a client, sending it the latest full event if any. It
+// returns whether the full event was sent.
+func (e *eventStream) subscribe() (chan []byte, bool) {
+	events := make(chan []byte, 1)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	synced := e.full != nil
+	if synced {
+		events <- e.full
+	}
+	e.subscribers[events] = synced
+	return events, synced
+}
+
+func (e *eventStream) unsubscribe(events chan []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.subscribers, events)
+}
+
+func (e *eventStream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, resource := range []string{"nodes", "pods"} {
+		if err := api.AuthorizeMetrics(req.Context(), e.authz, "list", resource, "", ""); err != nil {
+			writeStatus(w, req, err)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	events, synced := e.subscribe()
+	defer e.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	if !synced {
+		// nothing was published since the previous client left
+		e.publish(req.Context())
+	}
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case data := <-events:
+			if _, err := fmt.Fprintf(w, "event: metrics\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func encodeEvent(event metricsEvent) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeDumpItem(&buf, 0, event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// changedMetrics returns the nodes and pods of latest which are new or served
+// differently than in previous, and those of previous missing from latest.
+func changedMetrics(previous, latest *metricsEvent) metricsEvent {
+	if previous == nil {
+		return *latest
+	}
+	event := metricsEvent{Nodes: []v1beta1.NodeMetrics{}, Pods: []v1beta1.PodMetrics{}}
+	previousNodes := make(map[string]*v1beta1.NodeMetrics, len(previous.Nodes))
+	for i := range previous.Nodes {
+		previousNodes[previous.Nodes[i].Name] = &previous.Nodes[i]
+	}
+	for _, node := range latest.Nodes {
+		if served, found := previousNodes[node.Name]; !found || !apiequality.Semantic.DeepEqual(*served, node) {
+			event.Nodes = append(event.Nodes, node)
+		}
+		delete(previousNodes, node.Name)
+	}
+	for name := range previousNodes {
+		event.RemovedNodes = append(event.RemovedNodes, name)
+	}
+
+	previousPods := make(map[apitypes.NamespacedName]*v1beta1.PodMetrics, len(previous.Pods))
+	for i := range previous.Pods {
+		previousPods[apitypes.NamespacedName{Namespace: previous.Pods[i].Namespace, Name: previous.Pods[i].Name}] = &previous.Pods[i]
+	}
+	for _, pod := range latest.Pods {
+		ident := apitypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+		if served, found := previousPods[ident]; !found || !apiequality.Semantic.DeepEqual(*served, pod) {
+			event.Pods = append(event.Pods, pod)
+		}
+		delete(previousPods, ident)
+	}
+	for ident := range previousPods {
+		event.RemovedPods = append(event.RemovedPods, ident.String())
+	}
+	return event
+}
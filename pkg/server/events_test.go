@@ -0,0 +1,185 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/server/mux"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeMetricsLister serves the given metrics, counting lists.
+type fakeMetricsLister struct {
+	nodes []v1beta1.NodeMetrics
+	pods  []v1beta1.PodMetrics
+	lists int
+}
+
+func (l *fakeMetricsLister) ListNodeMetrics(ctx context.Context) ([]v1beta1.NodeMetrics, error) {
+	l.lists++
+	return l.nodes, nil
+}
+
+func (l *fakeMetricsLister) ListPodMetrics(ctx context.Context, namespace string) ([]v1beta1.PodMetrics, error) {
+	return l.pods, nil
+}
+
+var _ = Describe("Event stream", func() {
+	var (
+		lister *fakeMetricsLister
+		events *eventStream
+		now    time.Time
+	)
+	serve := func(ts time.Time, nodes ...string) {
+		lister.nodes = nil
+		lister.pods = []v1beta1.PodMetrics{{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "ns1"},
+			Timestamp:  metav1.NewTime(now),
+			Containers: []v1beta1.ContainerMetrics{{Name: "container1", Usage: map[v1.ResourceName]resource.Quantity{v1.ResourceCPU: resource.MustParse("10m")}}},
+		}}
+		for _, node := range nodes {
+			lister.nodes = append(lister.nodes, v1beta1.NodeMetrics{
+				ObjectMeta: metav1.ObjectMeta{Name: node},
+				Timestamp:  metav1.NewTime(ts),
+				Usage:      map[v1.ResourceName]resource.Quantity{v1.ResourceCPU: resource.MustParse("1")},
+			})
+		}
+	}
+	cycle := func(ts time.Time, nodes ...string) {
+		serve(ts, nodes...)
+		events.publish(context.Background())
+	}
+	decode := func(data []byte) metricsEvent {
+		var event metricsEvent
+		Expect(json.Unmarshal(data, &event)).To(Succeed())
+		return event
+	}
+	BeforeEach(func() {
+		now = time.Now().Truncate(time.Second)
+		lister = &fakeMetricsLister{}
+		events = newEventStream(lister, nil)
+	})
+
+	It("should push the changed metrics of each cycle to subscribers", func() {
+		handlers := mux.NewPathRecorderMux("test")
+		events.Install(handlers)
+		server := httptest.NewServer(handlers)
+		defer server.Close()
+		serve(now, "node1", "node2")
+
+		By("subscribing")
+		resp, err := http.Get(server.URL + eventsPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+		reader := bufio.NewReader(resp.Body)
+		next := func() metricsEvent {
+			line, err := reader.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			Expect(line).To(Equal("event: metrics\n"))
+			line, err = reader.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			Expect(line).To(HavePrefix("data: "))
+			blank, err := reader.ReadString('\n')
+			Expect(err).NotTo(HaveOccurred())
+			Expect(blank).To(Equal("\n"))
+			return decode([]byte(strings.TrimSuffix(strings.TrimPrefix(line, "data: "), "\n")))
+		}
+
+		By("receiving all served metrics first")
+		event := next()
+		Expect(event.Full).To(BeTrue())
+		Expect(event.Nodes).To(HaveLen(2))
+		Expect(event.Pods).To(HaveLen(1))
+		Expect(event.Pods[0].Containers[0].Usage.Cpu().String()).To(Equal("10m"))
+
+		By("receiving the changes after a cycle")
+		cycle(now.Add(time.Minute), "node1")
+		event = next()
+		Expect(event.Full).To(BeFalse())
+		Expect(event.Nodes).To(HaveLen(1))
+		Expect(event.Nodes[0].Name).To(Equal("node1"))
+		Expect(event.Pods).To(BeEmpty())
+		Expect(event.RemovedNodes).To(Equal([]string{"node2"}))
+	})
+
+	It("should refuse clients not allowed to list the metrics of all pods", func() {
+		events.authz = authorizer.AuthorizerFunc(func(a authorizer.Attributes) (authorizer.Decision, string, error) {
+			if a.GetResource() == "nodes" {
+				return authorizer.DecisionAllow, "", nil
+			}
+			return authorizer.DecisionNoOpinion, "", nil
+		})
+		handlers := mux.NewPathRecorderMux("test")
+		events.Install(handlers)
+		server := httptest.NewServer(handlers)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + eventsPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+		Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+		var status metav1.Status
+		Expect(json.NewDecoder(resp.Body).Decode(&status)).To(Succeed())
+		Expect(status.Reason).To(Equal(metav1.StatusReasonForbidden))
+		Expect(events.subscribers).To(BeEmpty())
+	})
+
+	It("should coalesce events of a slow subscriber into a full event without blocking", func() {
+		subscriber, _ := events.subscribe()
+		cycle(now, "node1")
+		cycle(now.Add(time.Minute), "node1", "node2")
+		cycle(now.Add(2*time.Minute), "node2")
+
+		Expect(subscriber).To(HaveLen(1))
+		event := decode(<-subscriber)
+		Expect(event.Full).To(BeTrue())
+		Expect(event.Nodes).To(HaveLen(1))
+		Expect(event.Nodes[0].Name).To(Equal("node2"))
+
+		By("receiving changes again once caught up")
+		cycle(now.Add(3*time.Minute), "node2")
+		event = decode(<-subscriber)
+		Expect(event.Full).To(BeFalse())
+		Expect(event.Nodes).To(HaveLen(1))
+	})
+
+	It("should stop pushing to unsubscribed clients", func() {
+		subscriber, _ := events.subscribe()
+		events.unsubscribe(subscriber)
+		cycle(now, "node1")
+		Expect(subscriber).To(BeEmpty())
+	})
+
+	It("should not list metrics without subscribers", func() {
+		cycle(now, "node1")
+		Expect(lister.lists).To(BeZero())
+		Expect(events.full).To(BeNil())
+	})
+})
@@ -0,0 +1,88 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"time"
+
+	apirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+// fairnessQueueTimeout bounds how long a request waits for a free slot of
+// its pool before failing with 429 Too Many Requests.
+const fairnessQueueTimeout = 5 * time.Second
+
+// requestPools limits the concurrent gets and lists of the Metrics API
+// separately, so that expensive lists of all pods can't take the capacity
+// needed by gets of single objects, e.g. from the HPA.
+type requestPools struct {
+	handler  http.Handler
+	resolver apirequest.RequestInfoResolver
+	gets     chan struct{}
+	lists    chan struct{}
+	timeout  time.Duration
+}
+
+// withRequestFairness serves at most maxGets gets and maxLists lists (and
+// watches) of the Metrics API concurrently, queueing others until a slot of
+// their pool is free. Other requests aren't limited. The pools apply before
+// the generic in-flight limit, so that waiting lists don't hold its slots.
+func withRequestFairness(handler http.Handler, resolver apirequest.RequestInfoResolver, maxGets, maxLists int) http.Handler {
+	return &requestPools{
+		handler:  handler,
+		resolver: resolver,
+		gets:     make(chan struct{}, maxGets),
+		lists:    make(chan struct{}, maxLists),
+		timeout:  fairnessQueueTimeout,
+	}
+}
+
+func (p *requestPools) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	pool := p.pool(req)
+	if pool == nil {
+		p.handler.ServeHTTP(w, req)
+		return
+	}
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+	select {
+	case pool <- struct{}{}:
+	case <-timer.C:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many requests, please try again later.", http.StatusTooManyRequests)
+		return
+	case <-req.Context().Done():
+		return
+	}
+	defer func() { <-pool }()
+	p.handler.ServeHTTP(w, req)
+}
+
+// pool returns the pool limiting the request, or nil if it isn't limited.
+func (p *requestPools) pool(req *http.Request) chan struct{} {
+	info, err := p.resolver.NewRequestInfo(req)
+	if err != nil || !info.IsResourceRequest || info.APIGroup != metrics.GroupName {
+		return nil
+	}
+	switch info.Verb {
+	case "get":
+		return p.gets
+	case "list", "watch":
+		return p.lists
+	}
+	return nil
+}
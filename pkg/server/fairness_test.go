@@ -0,0 +1,86 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	apirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+var _ = Describe("Request fairness", func() {
+	var (
+		started chan string
+		release chan struct{}
+		pools   *requestPools
+	)
+	BeforeEach(func() {
+		started = make(chan string, 10)
+		release = make(chan struct{})
+		blocking := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			started <- req.URL.Path
+			if req.URL.Path == "/apis/metrics.k8s.io/v1beta1/pods" {
+				<-release
+			}
+		})
+		resolver := &apirequest.RequestInfoFactory{
+			APIPrefixes:          sets.NewString("api", "apis"),
+			GrouplessAPIPrefixes: sets.NewString("api"),
+		}
+		pools = withRequestFairness(blocking, resolver, 2, 2).(*requestPools)
+		pools.timeout = 50 * time.Millisecond
+	})
+	AfterEach(func() {
+		close(release)
+	})
+	serve := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		pools.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+	saturateLists := func() {
+		for i := 0; i < 2; i++ {
+			go serve("/apis/metrics.k8s.io/v1beta1/pods")
+			Eventually(started).Should(Receive(Equal("/apis/metrics.k8s.io/v1beta1/pods")))
+		}
+	}
+
+	It("should serve gets promptly while lists are saturated", func() {
+		saturateLists()
+
+		done := make(chan int, 1)
+		go func() { done <- serve("/apis/metrics.k8s.io/v1beta1/namespaces/default/pods/pod1").Code }()
+		Eventually(done, time.Second).Should(Receive(Equal(http.StatusOK)))
+	})
+	It("should reject lists waiting longer than the timeout for a slot", func() {
+		saturateLists()
+
+		rec := serve("/apis/metrics.k8s.io/v1beta1/pods")
+		Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(rec.Header().Get("Retry-After")).To(Equal("1"))
+	})
+	It("should not limit requests outside the Metrics API", func() {
+		saturateLists()
+
+		Expect(serve("/api/v1/pods").Code).To(Equal(http.StatusOK))
+		Expect(serve("/healthz").Code).To(Equal(http.StatusOK))
+	})
+})
@@ -0,0 +1,154 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+	"k8s.io/metrics/pkg/apis/metrics"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// LeaderElectionConfig configures electing the replica scraping Kubelets.
+type LeaderElectionConfig struct {
+	// LeaseNamespace and LeaseName locate the Lease recording the leader.
+	LeaseNamespace string
+	LeaseName      string
+	// AdvertiseAddress is the host:port other replicas reach this replica at.
+	// It identifies the replica in the Lease.
+	AdvertiseAddress string
+	// CAFile verifies the serving certificate of the leader, the CA of the
+	// Kubernetes API server is used if empty.
+	CAFile string
+}
+
+// leaderElection reports the current leader, as observed by this replica.
+type leaderElection interface {
+	IsLeader() bool
+	GetLeader() string
+}
+
+// leaderProxy forwards metric reads to the leader while this replica isn't
+// leading, so that all replicas serve the metrics of the leader storage.
+type leaderProxy struct {
+	election  leaderElection
+	transport http.RoundTripper
+}
+
+func newLeaderProxy(config LeaderElectionConfig, restConfig *rest.Config) (*leaderProxy, *leaderelection.LeaderElector, error) {
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to construct leader election client: %v", err)
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: config.LeaseNamespace, Name: config.LeaseName},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: config.AdvertiseAddress},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				klog.Infof("Started leading, scraping metrics")
+			},
+			OnStoppedLeading: func() {
+				klog.Infof("Stopped leading, proxying metric reads to the leader")
+			},
+			OnNewLeader: func(identity string) {
+				klog.Infof("New leader elected: %s", identity)
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to construct leader elector: %v", err)
+	}
+	// followers authenticate to the leader with their own credentials
+	proxyConfig := rest.CopyConfig(restConfig)
+	proxyConfig.TLSClientConfig.ServerName = ""
+	if config.CAFile != "" {
+		proxyConfig.TLSClientConfig.CAFile = config.CAFile
+		proxyConfig.TLSClientConfig.CAData = nil
+	}
+	transport, err := rest.TransportFor(proxyConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to construct leader proxy transport: %v", err)
+	}
+	return &leaderProxy{election: elector, transport: transport}, elector, nil
+}
+
+// runLeaderElection campaigns for leadership until the context is done,
+// standing again whenever leadership is lost.
+func runLeaderElection(ctx context.Context, elector *leaderelection.LeaderElector) {
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+}
+
+// following returns true if another replica may be leading.
+func (p *leaderProxy) following() bool {
+	return !p.election.IsLeader()
+}
+
+// wrap proxies NodeMetrics and PodMetrics requests to the leader while this
+// replica isn't leading. Requests are authorized before being proxied.
+func (p *leaderProxy) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, ok := genericapirequest.RequestInfoFrom(req.Context())
+		if !ok || !info.IsResourceRequest || info.APIGroup != metrics.GroupName || !p.following() {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		leader := p.election.GetLeader()
+		if leader == "" {
+			unavailable(w, "no leader elected")
+			return
+		}
+		proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "https", Host: leader})
+		proxy.Transport = p.transport
+		proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+			klog.Errorf("unable to proxy %s to leader %s: %v", req.URL.Path, leader, err)
+			unavailable(w, "leader unavailable")
+		}
+		proxy.ServeHTTP(w, req)
+	})
+}
+
+// unavailable fails a request to be retried once a leader is reachable.
+func unavailable(w http.ResponseWriter, msg string) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(retryPeriod.Seconds())))
+	http.Error(w, msg, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Leader proxy", func() {
+	var (
+		leader   *httptest.Server
+		election *fakeLeaderElection
+		proxy    *leaderProxy
+		local    http.Handler
+	)
+
+	request := func(info *genericapirequest.RequestInfo, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req = req.WithContext(genericapirequest.WithRequestInfo(req.Context(), info))
+		rec := httptest.NewRecorder()
+		proxy.wrap(local).ServeHTTP(rec, req)
+		return rec
+	}
+	podMetrics := &genericapirequest.RequestInfo{IsResourceRequest: true, APIGroup: "metrics.k8s.io", Resource: "pods"}
+
+	BeforeEach(func() {
+		leader = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("leader " + req.URL.RequestURI()))
+		}))
+		election = &fakeLeaderElection{leader: leader.Listener.Addr().String()}
+		proxy = &leaderProxy{election: election, transport: leader.Client().Transport}
+		local = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("local"))
+		})
+	})
+	AfterEach(func() {
+		leader.Close()
+	})
+
+	It("should proxy metric requests to the leader while following", func() {
+		rec := request(podMetrics, "/apis/metrics.k8s.io/v1beta1/namespaces/ns1/pods?labelSelector=app%3Dweb")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal("leader /apis/metrics.k8s.io/v1beta1/namespaces/ns1/pods?labelSelector=app%3Dweb"))
+	})
+	It("should serve metric requests locally while leading", func() {
+		election.leading = true
+		Expect(request(podMetrics, "/apis/metrics.k8s.io/v1beta1/namespaces/ns1/pods").Body.String()).To(Equal("local"))
+	})
+	It("should serve other requests locally", func() {
+		Expect(request(&genericapirequest.RequestInfo{Path: "/healthz"}, "/healthz").Body.String()).To(Equal("local"))
+		Expect(request(&genericapirequest.RequestInfo{Path: "/apis/metrics.k8s.io/v1beta1"}, "/apis/metrics.k8s.io/v1beta1").Body.String()).To(Equal("local"))
+	})
+	It("should return 503 while no leader is elected", func() {
+		election.leader = ""
+		rec := request(podMetrics, "/apis/metrics.k8s.io/v1beta1/pods")
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Header().Get("Retry-After")).NotTo(BeEmpty())
+	})
+	It("should return 503 if the leader is unreachable", func() {
+		leader.Close()
+		rec := request(podMetrics, "/apis/metrics.k8s.io/v1beta1/pods")
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		body, _ := ioutil.ReadAll(rec.Body)
+		Expect(string(body)).To(ContainSubstring("leader unavailable"))
+	})
+})
+
+type fakeLeaderElection struct {
+	leading bool
+	leader  string
+}
+
+func (e *fakeLeaderElection) IsLeader() bool    { return e.leading }
+func (e *fakeLeaderElection) GetLeader() string { return e.leader }
@@ -0,0 +1,56 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"hash/fnv"
+	"time"
+
+	"k8s.io/component-base/metrics"
+)
+
+var cycleStartOffset = metrics.NewGauge(
+	&metrics.GaugeOpts{
+		Namespace: "metrics_server",
+		Subsystem: "manager",
+		Name:      "cycle_start_offset_seconds",
+		Help:      "Offset of the start of the last scrape cycle within the metric resolution, counted from the Unix epoch. Replicas with equal offsets scrape Kubelets at the same time.",
+	},
+)
+
+// PhaseOffsetFromSeed derives a deterministic phase offset within the
+// resolution from a seed, e.g. the pod name, so that replicas with distinct
+// seeds are likely to scrape at distinct times.
+func PhaseOffsetFromSeed(seed string, resolution time.Duration) time.Duration {
+	h := fnv.New64a()
+	h.Write([]byte(seed))
+	return time.Duration(h.Sum64() % uint64(resolution))
+}
+
+// phaseDelay returns how long after now the next cycle starts, so that
+// cycles start offset past a multiple of the resolution since the Unix epoch.
+func phaseDelay(now time.Time, resolution, offset time.Duration) time.Duration {
+	delay := offset - offsetInResolution(now, resolution)
+	if delay < 0 {
+		delay += resolution
+	}
+	return delay
+}
+
+// offsetInResolution returns how far past a multiple of the resolution since
+// the Unix epoch t is.
+func offsetInResolution(t time.Time, resolution time.Duration) time.Duration {
+	return time.Duration(t.UnixNano() % int64(resolution))
+}
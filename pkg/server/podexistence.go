@@ -0,0 +1,51 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+)
+
+// apiPodVerifier lists pods directly from the Kubernetes API server. Lists
+// without a resource version are served from etcd, so they observe deletions
+// which the informer may not have received yet.
+type apiPodVerifier struct {
+	pods corev1client.PodsGetter
+}
+
+var _ api.PodExistenceVerifier = (*apiPodVerifier)(nil)
+
+func (v *apiPodVerifier) ExistingPodUIDs(ctx context.Context, namespace string, labelSelector labels.Selector, fieldSelector fields.Selector) (sets.String, error) {
+	list, err := v.pods.Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector.String(),
+		FieldSelector: fieldSelector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	uids := sets.NewString()
+	for _, pod := range list.Items {
+		uids.Insert(string(pod.UID))
+	}
+	return uids, nil
+}
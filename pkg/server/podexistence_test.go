@@ -0,0 +1,57 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API pod verifier", func() {
+	var verifier *apiPodVerifier
+
+	BeforeEach(func() {
+		client := fake.NewSimpleClientset(
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "pod1", UID: "uid1", Labels: map[string]string{"app": "web"}}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "pod2", UID: "uid2"}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "pod1", UID: "uid3"}},
+		)
+		verifier = &apiPodVerifier{pods: client.CoreV1()}
+	})
+
+	It("should return UIDs of existing pods in the namespace", func() {
+		uids, err := verifier.ExistingPodUIDs(context.Background(), "ns1", labels.Everything(), fields.Everything())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uids.List()).To(Equal([]string{"uid1", "uid2"}))
+	})
+	It("should return UIDs across all namespaces", func() {
+		uids, err := verifier.ExistingPodUIDs(context.Background(), "", labels.Everything(), fields.Everything())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uids.List()).To(Equal([]string{"uid1", "uid2", "uid3"}))
+	})
+	It("should apply the label selector", func() {
+		uids, err := verifier.ExistingPodUIDs(context.Background(), "ns1", labels.SelectorFromSet(labels.Set{"app": "web"}), fields.Everything())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(uids.List()).To(Equal([]string{"uid1"}))
+	})
+})
@@ -0,0 +1,48 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// withPathPrefix serves requests under the prefix as if it was absent, e.g.
+// for reverse proxies routing a subpath to metrics-server without rewriting
+// it. Requests outside the prefix are served as they are, since the
+// aggregator always requests the fixed /apis/metrics.k8s.io paths. The
+// prefix is stripped before authentication and authorization, so RBAC rules
+// for non-resource URLs apply to the paths without it.
+func withPathPrefix(handler http.Handler, prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.Path
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		stripped := req.Clone(req.Context())
+		stripped.URL.Path = strings.TrimPrefix(path, prefix)
+		if stripped.URL.Path == "" {
+			stripped.URL.Path = "/"
+		}
+		stripped.URL.RawPath = ""
+		stripped.RequestURI = stripped.URL.RequestURI()
+		handler.ServeHTTP(w, stripped)
+	})
+}
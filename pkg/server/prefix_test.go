@@ -0,0 +1,64 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Path prefix", func() {
+	var (
+		paths   []string
+		mux     *http.ServeMux
+		handler http.Handler
+	)
+	BeforeEach(func() {
+		paths = nil
+		mux = http.NewServeMux()
+		mux.HandleFunc("/apis/metrics.k8s.io/v1beta1/nodes", func(w http.ResponseWriter, req *http.Request) {
+			paths = append(paths, req.URL.RequestURI())
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+			paths = append(paths, req.URL.RequestURI())
+		})
+		handler = withPathPrefix(mux, "/metrics-server")
+	})
+	serve := func(target string) {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	It("should serve routes under the prefix without it", func() {
+		serve("/metrics-server/apis/metrics.k8s.io/v1beta1/nodes?labelSelector=a%3Db")
+		Expect(paths).To(Equal([]string{"/apis/metrics.k8s.io/v1beta1/nodes?labelSelector=a%3Db"}))
+	})
+	It("should serve the prefix itself as the root", func() {
+		serve("/metrics-server")
+		Expect(paths).To(Equal([]string{"/"}))
+	})
+	It("should still serve routes without the prefix for the aggregator", func() {
+		serve("/apis/metrics.k8s.io/v1beta1/nodes")
+		serve("/metrics-serverless/healthz")
+		Expect(paths).To(Equal([]string{"/apis/metrics.k8s.io/v1beta1/nodes", "/metrics-serverless/healthz"}))
+	})
+	It("should serve requests as they are without a prefix configured", func() {
+		handler = withPathPrefix(mux, "")
+		serve("/metrics-server/healthz")
+		Expect(paths).To(Equal([]string{"/metrics-server/healthz"}))
+	})
+})
@@ -0,0 +1,178 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// cycleProfileRate is the memory profiling rate, in bytes allocated per
+// sample, while a scrape cycle is profiled. It's finer than the default
+// rate, so that a single cycle gets enough samples.
+const cycleProfileRate = 4096
+
+// cycleProfiles serializes profiled cycles, which change the global
+// memory profiling rate.
+var cycleProfiles sync.Mutex
+
+// allocRecord is the memory sampled for a stack between two profiles.
+type allocRecord struct {
+	stack                    []uintptr
+	allocObjects, allocBytes int64
+	inUseObjects, inUseBytes int64
+}
+
+// cycleProfile serves the allocations sampled while running one scrape
+// cycle, in the legacy text format of heap profiles read by pprof, e.g.
+// "go tool pprof metrics-server profile.txt". Symbols are included as
+// comments for reading the profile without the binary.
+func (d DebugHandlers) cycleProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		records, ran := captureAllocs(func() bool { return d.cycle(req.Context()) })
+		if !ran {
+			http.Error(w, "scrape cycles are run by the leader, not by this replica", http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="cycle-heap.txt"`)
+		if err := writeAllocProfile(w, records, cycleProfileRate); err != nil {
+			klog.Errorf("unable to write cycle profile: %v", err)
+		}
+	}
+}
+
+// captureAllocs returns the allocations sampled while running fn, at
+// cycleProfileRate. Allocations of other goroutines meanwhile are included.
+func captureAllocs(fn func() bool) ([]allocRecord, bool) {
+	cycleProfiles.Lock()
+	defer cycleProfiles.Unlock()
+
+	before := memProfile()
+	previousRate := runtime.MemProfileRate
+	runtime.MemProfileRate = cycleProfileRate
+	ran := fn()
+	after := memProfile()
+	runtime.MemProfileRate = previousRate
+	if !ran {
+		return nil, false
+	}
+
+	previous := byStack(before)
+	records := make([]allocRecord, 0, len(after))
+	for key, record := range byStack(after) {
+		if base, found := previous[key]; found {
+			record.allocObjects -= base.allocObjects
+			record.allocBytes -= base.allocBytes
+			record.inUseObjects -= base.inUseObjects
+			record.inUseBytes -= base.inUseBytes
+		}
+		if record.allocObjects > 0 {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].allocBytes > records[j].allocBytes })
+	return records, true
+}
+
+// byStack sums the records by stack, the runtime keeps separate records for
+// each allocation size.
+func byStack(profile []runtime.MemProfileRecord) map[string]*allocRecord {
+	records := make(map[string]*allocRecord, len(profile))
+	for i := range profile {
+		stack := profile[i].Stack()
+		for len(stack) > 0 && stack[len(stack)-1] == 0 {
+			stack = stack[:len(stack)-1]
+		}
+		key := fmt.Sprint(stack)
+		record, found := records[key]
+		if !found {
+			record = &allocRecord{stack: stack}
+			records[key] = record
+		}
+		record.allocObjects += profile[i].AllocObjects
+		record.allocBytes += profile[i].AllocBytes
+		record.inUseObjects += profile[i].InUseObjects()
+		record.inUseBytes += profile[i].InUseBytes()
+	}
+	return records
+}
+
+// memProfile returns the current memory profile. It's only updated by
+// garbage collections, and lags up to two of them behind allocations.
+func memProfile() []runtime.MemProfileRecord {
+	runtime.GC()
+	runtime.GC()
+	n, _ := runtime.MemProfile(nil, true)
+	for {
+		// leave room for stacks sampled meanwhile
+		records := make([]runtime.MemProfileRecord, n+50)
+		var ok bool
+		n, ok = runtime.MemProfile(records, true)
+		if ok {
+			return records[:n]
+		}
+	}
+}
+
+// writeAllocProfile writes the records in the format of runtime/pprof heap
+// profiles with debug=1.
+func writeAllocProfile(w io.Writer, records []allocRecord, rate int) error {
+	var total allocRecord
+	for _, record := range records {
+		total.allocObjects += record.allocObjects
+		total.allocBytes += record.allocBytes
+		total.inUseObjects += record.inUseObjects
+		total.inUseBytes += record.inUseBytes
+	}
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, "heap profile: %d: %d [%d: %d] @ heap/%d\n", total.inUseObjects, total.inUseBytes, total.allocObjects, total.allocBytes, 2*rate)
+	for _, record := range records {
+		fmt.Fprintf(b, "%d: %d [%d: %d] @", record.inUseObjects, record.inUseBytes, record.allocObjects, record.allocBytes)
+		for _, pc := range record.stack {
+			fmt.Fprintf(b, " %#x", pc)
+		}
+		fmt.Fprintln(b)
+		frames := runtime.CallersFrames(record.stack)
+		for {
+			frame, more := frames.Next()
+			fmt.Fprintf(b, "#\t%#x\t%s+%#x\t%s:%d\n", frame.PC, frame.Function, frame.PC-frame.Entry, frame.File, frame.Line)
+			if !more {
+				break
+			}
+		}
+		fmt.Fprintln(b)
+	}
+	return b.Flush()
+}
+
+// runProfiledCycle runs one scrape cycle, returning false if this replica
+// doesn't scrape because it isn't leading.
+func (s *server) runProfiledCycle(ctx context.Context) bool {
+	if s.following() {
+		return false
+	}
+	s.tick(ctx, time.Now())
+	return true
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+
+	"k8s.io/apiserver/pkg/server/mux"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// profiledSink keeps the allocations of profiled cycles reachable.
+var profiledSink [][]byte
+
+func allocateInProfiledCycle() {
+	for i := 0; i < 1000; i++ {
+		profiledSink = append(profiledSink, make([]byte, 1024))
+	}
+}
+
+var _ = Describe("Cycle profile", func() {
+	var (
+		handlers *mux.PathRecorderMux
+		cycles   int
+	)
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handlers.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/metrics-server/cycle-profile", nil))
+		return rec
+	}
+	BeforeEach(func() {
+		handlers = mux.NewPathRecorderMux("test")
+		cycles = 0
+		profiledSink = nil
+	})
+
+	It("should serve a heap profile of the allocations of one cycle", func() {
+		DebugHandlers{cycle: func(context.Context) bool {
+			cycles++
+			allocateInProfiledCycle()
+			return true
+		}}.Install(handlers)
+
+		rec := get()
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(cycles).To(Equal(1))
+		lines := strings.Split(rec.Body.String(), "\n")
+		Expect(lines[0]).To(MatchRegexp(`^heap profile: -?\d+: -?\d+ \[[1-9]\d*: [1-9]\d*\] @ heap/8192$`))
+		record := regexp.MustCompile(`^-?\d+: -?\d+ \[(\d+): (\d+)\] @( 0x[0-9a-f]+)+$`)
+		for _, line := range lines[1:] {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				Expect(line).To(MatchRegexp(record.String()))
+			}
+		}
+		Expect(rec.Body.String()).To(ContainSubstring("server.allocateInProfiledCycle"))
+	})
+	It("should reject profiling on replicas which don't scrape", func() {
+		DebugHandlers{cycle: func(context.Context) bool { return false }}.Install(handlers)
+
+		Expect(get().Code).To(Equal(http.StatusConflict))
+	})
+	It("should not serve profiles when not enabled", func() {
+		DebugHandlers{}.Install(handlers)
+
+		Expect(get().Code).To(Equal(http.StatusNotFound))
+	})
+})
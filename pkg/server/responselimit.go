@@ -0,0 +1,97 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/component-base/metrics"
+	metricsapi "k8s.io/metrics/pkg/apis/metrics"
+)
+
+var oversizedLists = metrics.NewCounter(
+	&metrics.CounterOpts{
+		Namespace: "metrics_server",
+		Subsystem: "api",
+		Name:      "oversized_list_responses_total",
+		Help:      "Number of Metrics API lists failed with 413 Request Entity Too Large for exceeding the maximum list response size.",
+	},
+)
+
+// withMaxListResponseBytes fails lists of the Metrics API whose response
+// exceeds maxBytes with a 413 Request Entity Too Large Status, directing
+// clients to narrow the list, instead of sending unbounded data. Responses are counted as
+// written by the API handler, after compression. Zero disables the limit.
+func withMaxListResponseBytes(handler http.Handler, maxBytes int) http.Handler {
+	if maxBytes <= 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, ok := apirequest.RequestInfoFrom(req.Context())
+		if !ok || !info.IsResourceRequest || info.APIGroup != metricsapi.GroupName || info.Verb != "list" {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		limited := &limitedResponse{header: http.Header{}, status: http.StatusOK, maxBytes: maxBytes}
+		handler.ServeHTTP(limited, req)
+		if limited.exceeded {
+			oversizedLists.Inc()
+			writeStatus(w, req, apierrors.NewRequestEntityTooLargeError(fmt.Sprintf("the list of %s exceeds the maximum response size of %d bytes and the metrics API doesn't paginate lists, please list a single namespace or select fewer objects with label or field selectors", info.Resource, maxBytes)))
+			return
+		}
+		for name, values := range limited.header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(limited.status)
+		w.Write(limited.body.Bytes())
+	})
+}
+
+// limitedResponse buffers a response and its headers as long as it fits in
+// maxBytes, and discards the body once it doesn't.
+type limitedResponse struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	maxBytes    int
+	body        bytes.Buffer
+	exceeded    bool
+}
+
+func (r *limitedResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *limitedResponse) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status, r.wroteHeader = status, true
+}
+
+func (r *limitedResponse) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if r.exceeded || r.body.Len()+len(data) > r.maxBytes {
+		r.exceeded = true
+		r.body.Reset()
+		// report success so that the handler finishes without logging errors
+		return len(data), nil
+	}
+	return r.body.Write(data)
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+var _ = Describe("Maximum list response size", func() {
+	var body string
+	BeforeEach(func() {
+		oversizedLists.Create(nil)
+		oversizedLists.Reset()
+	})
+	handler := func(maxBytes int) http.Handler {
+		return withMaxListResponseBytes(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			// lists are written in several chunks
+			for _, chunk := range strings.SplitAfter(body, ",") {
+				w.Write([]byte(chunk))
+			}
+		}), maxBytes)
+	}
+	serve := func(h http.Handler, verb string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/apis/metrics.k8s.io/v1beta1/pods", nil)
+		req = req.WithContext(apirequest.WithRequestInfo(req.Context(), &apirequest.RequestInfo{
+			IsResourceRequest: true,
+			APIGroup:          "metrics.k8s.io",
+			Resource:          "pods",
+			Verb:              verb,
+		}))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	It("should serve lists within the limit as they are", func() {
+		body = `{"items":[{"name":"pod1"},{"name":"pod2"}]}`
+		w := serve(handler(len(body)), "list")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal(body))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+	})
+	It("should fail oversized lists with 413 directing clients to narrow them", func() {
+		body = `{"items":[{"name":"pod1"},{"name":"pod2"},{"name":"pod3"}]}`
+		w := serve(handler(32), "list")
+		Expect(w.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(w.Body.String()).NotTo(ContainSubstring("pod1"))
+		var status metav1.Status
+		Expect(json.Unmarshal(w.Body.Bytes(), &status)).To(Succeed())
+		Expect(status.Reason).To(Equal(metav1.StatusReasonRequestEntityTooLarge))
+		Expect(status.Message).To(ContainSubstring("exceeds the maximum response size of 32 bytes"))
+		Expect(status.Message).To(ContainSubstring("doesn't paginate"))
+		Expect(status.Message).To(ContainSubstring("selectors"))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		err := testutil.CollectAndCompare(oversizedLists, strings.NewReader(`
+		# HELP metrics_server_api_oversized_list_responses_total [ALPHA] Number of Metrics API lists failed with 413 Request Entity Too Large for exceeding the maximum list response size.
+		# TYPE metrics_server_api_oversized_list_responses_total counter
+		metrics_server_api_oversized_list_responses_total 1
+		`), "metrics_server_api_oversized_list_responses_total")
+		Expect(err).NotTo(HaveOccurred())
+	})
+	It("should not limit gets", func() {
+		body = `{"items":[{"name":"pod1"},{"name":"pod2"},{"name":"pod3"}]}`
+		Expect(serve(handler(32), "get").Body.String()).To(Equal(body))
+	})
+	It("should not limit lists without a maximum", func() {
+		body = `{"items":[{"name":"pod1"},{"name":"pod2"},{"name":"pod3"}]}`
+		Expect(serve(handler(0), "list").Body.String()).To(Equal(body))
+	})
+})
@@ -0,0 +1,153 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/server/healthz"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/klog/v2"
+)
+
+// restartLeaseTimeout bounds reading and writing the restart Lease, so that a
+// slow API server doesn't hold up startup or shutdown.
+const restartLeaseTimeout = 5 * time.Second
+
+// RestartGraceConfig configures the grace period after a restart during which
+// metrics-server reports ready before it scraped any metrics.
+type RestartGraceConfig struct {
+	// LeaseNamespace and LeaseName locate the Lease recording the latest
+	// graceful shutdown.
+	LeaseNamespace string
+	LeaseName      string
+	// Period is how long after a graceful shutdown a starting replica
+	// reports ready without stored metrics.
+	Period time.Duration
+}
+
+// restartGrace records graceful shutdowns in a Lease, and lets a replica
+// starting within the grace period of the latest shutdown report ready
+// before its first scrape. With a single replica this keeps the Service
+// endpoint, and so the APIService, available across restarts, at the cost
+// of serving no metrics until the first scrape completes.
+type restartGrace struct {
+	leases     coordinationv1client.LeaseInterface
+	name       string
+	period     time.Duration
+	identity   string
+	hasMetrics func() bool
+	now        func() time.Time
+
+	mu sync.RWMutex
+	// until is the end of the grace period, zero if there is none
+	until time.Time
+}
+
+func newRestartGrace(client coordinationv1client.LeasesGetter, config RestartGraceConfig, hasMetrics func() bool) *restartGrace {
+	identity, _ := os.Hostname()
+	return &restartGrace{
+		leases:     client.Leases(config.LeaseNamespace),
+		name:       config.LeaseName,
+		period:     config.Period,
+		identity:   identity,
+		hasMetrics: hasMetrics,
+		now:        time.Now,
+	}
+}
+
+// begin starts the grace period if the latest graceful shutdown recorded in
+// the Lease happened less than the period ago.
+func (g *restartGrace) begin(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, restartLeaseTimeout)
+	defer cancel()
+	lease, err := g.leases.Get(ctx, g.name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return
+	case err != nil:
+		klog.Warningf("Unable to read restart Lease %s, starting without grace period: %v", g.name, err)
+		return
+	}
+	if lease.Spec.RenewTime == nil {
+		return
+	}
+	until := lease.Spec.RenewTime.Add(g.period)
+	if !g.now().Before(until) {
+		return
+	}
+	klog.Infof("Restarted %s after a graceful shutdown, reporting ready until %s or the first scrape", g.now().Sub(lease.Spec.RenewTime.Time).Round(time.Second), until)
+	g.mu.Lock()
+	g.until = until
+	g.mu.Unlock()
+}
+
+// announce records a graceful shutdown in the Lease, creating it if needed.
+func (g *restartGrace) announce(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, restartLeaseTimeout)
+	defer cancel()
+	now := metav1.NewMicroTime(g.now())
+	seconds := int32(g.period / time.Second)
+	lease, err := g.leases.Get(ctx, g.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: g.name}}
+		lease.Spec = coordinationv1.LeaseSpec{HolderIdentity: &g.identity, LeaseDurationSeconds: &seconds, RenewTime: &now}
+		_, err = g.leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &g.identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = g.leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// graceful returns true while within the grace period and no metrics are
+// stored yet. Once metrics are stored the grace period ends for good.
+func (g *restartGrace) graceful() bool {
+	g.mu.RLock()
+	until := g.until
+	g.mu.RUnlock()
+	if until.IsZero() {
+		return false
+	}
+	if g.hasMetrics() || !g.now().Before(until) {
+		g.mu.Lock()
+		g.until = time.Time{}
+		g.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// wrap passes the check while within the grace period.
+func (g *restartGrace) wrap(check healthz.HealthChecker) healthz.HealthChecker {
+	return healthz.NamedCheck(check.Name(), func(req *http.Request) error {
+		if g.graceful() {
+			return nil
+		}
+		return check.Check(req)
+	})
+}
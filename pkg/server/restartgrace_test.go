@@ -0,0 +1,96 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/client-go/kubernetes/fake"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Restart grace", func() {
+	var (
+		now        time.Time
+		hasMetrics bool
+		client     *fake.Clientset
+		newGrace   func() *restartGrace
+		notReady   healthz.HealthChecker
+	)
+	BeforeEach(func() {
+		now = time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+		hasMetrics = false
+		client = fake.NewSimpleClientset()
+		newGrace = func() *restartGrace {
+			grace := newRestartGrace(client.CoordinationV1(), RestartGraceConfig{LeaseNamespace: "kube-system", LeaseName: "metrics-server-restart", Period: time.Minute}, func() bool { return hasMetrics })
+			grace.now = func() time.Time { return now }
+			return grace
+		}
+		notReady = healthz.NamedCheck("storage-nonempty", func(*http.Request) error { return fmt.Errorf("no node metrics stored") })
+	})
+	restart := func(after time.Duration) *restartGrace {
+		Expect(newGrace().announce(context.Background())).To(Succeed())
+		now = now.Add(after)
+		grace := newGrace()
+		grace.begin(context.Background())
+		return grace
+	}
+
+	It("should record graceful shutdowns in the Lease", func() {
+		grace := newGrace()
+		Expect(grace.announce(context.Background())).To(Succeed())
+		now = now.Add(time.Hour)
+		Expect(grace.announce(context.Background())).To(Succeed())
+
+		lease, err := client.CoordinationV1().Leases("kube-system").Get(context.Background(), "metrics-server-restart", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lease.Spec.RenewTime.Time).To(BeTemporally("==", now))
+		Expect(*lease.Spec.LeaseDurationSeconds).To(Equal(int32(60)))
+	})
+	It("should pass checks when restarted within the grace period", func() {
+		grace := restart(30 * time.Second)
+		check := grace.wrap(notReady)
+		Expect(check.Name()).To(Equal("storage-nonempty"))
+		Expect(check.Check(nil)).To(Succeed())
+	})
+	It("should end the grace period once the period elapsed", func() {
+		grace := restart(30 * time.Second)
+		now = now.Add(30 * time.Second)
+		Expect(grace.wrap(notReady).Check(nil)).NotTo(Succeed())
+	})
+	It("should end the grace period for good once metrics are stored", func() {
+		grace := restart(30 * time.Second)
+		hasMetrics = true
+		Expect(grace.graceful()).To(BeFalse())
+		hasMetrics = false
+		Expect(grace.wrap(notReady).Check(nil)).NotTo(Succeed())
+	})
+	It("should not grant a grace period when restarted after the period", func() {
+		grace := restart(2 * time.Minute)
+		Expect(grace.wrap(notReady).Check(nil)).NotTo(Succeed())
+	})
+	It("should not grant a grace period without a recorded shutdown", func() {
+		grace := newGrace()
+		grace.begin(context.Background())
+		Expect(grace.wrap(notReady).Check(nil)).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,155 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+// ScrapeIntervalLabel is the node label overriding the metric resolution for
+// that node, e.g. metrics-server/scrape-interval=15s.
+const ScrapeIntervalLabel = "metrics-server/scrape-interval"
+
+// minScrapeInterval is the shortest scrape interval a node label can set, so
+// that a mislabeled node can't get its Kubelet hammered.
+const minScrapeInterval = time.Second
+
+// scrapeSchedule tracks when each node is due to be scraped next, and keeps
+// the latest metrics of each node in between its scrapes.
+type scrapeSchedule struct {
+	defaultInterval time.Duration
+
+	nextScrape map[string]time.Time
+	batches    map[string]*storage.MetricsBatch
+	// pods is nil unless deleted pods are evicted from storage, otherwise
+	// deleted pods are dropped from the kept metrics so that they aren't
+	// stored again until their node is scraped
+	pods v1listers.PodLister
+}
+
+func newScrapeSchedule(defaultInterval time.Duration) *scrapeSchedule {
+	return &scrapeSchedule{
+		defaultInterval: defaultInterval,
+		nextScrape:      map[string]time.Time{},
+		batches:         map[string]*storage.MetricsBatch{},
+	}
+}
+
+// interval returns the scrape interval of the node, which is the default
+// unless overridden with a valid ScrapeIntervalLabel.
+func (s *scrapeSchedule) interval(node *corev1.Node) time.Duration {
+	value, found := node.Labels[ScrapeIntervalLabel]
+	if !found {
+		return s.defaultInterval
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < minScrapeInterval {
+		klog.Warningf("ignoring invalid %s label %q on node %s, must be a duration of at least %s", ScrapeIntervalLabel, value, node.Name, minScrapeInterval)
+		return s.defaultInterval
+	}
+	return interval
+}
+
+// due returns the nodes to scrape at the given time, scheduling their next
+// scrape, and the shortest interval among them. Nodes which no longer exist
+// are forgotten.
+func (s *scrapeSchedule) due(nodes []*corev1.Node, now time.Time) ([]*corev1.Node, time.Duration) {
+	existing := make(map[string]bool, len(nodes))
+	var due []*corev1.Node
+	shortest := s.defaultInterval
+	for _, node := range nodes {
+		existing[node.Name] = true
+		if next, found := s.nextScrape[node.Name]; found && now.Before(next) {
+			continue
+		}
+		interval := s.interval(node)
+		if interval < shortest {
+			shortest = interval
+		}
+		s.nextScrape[node.Name] = now.Add(interval)
+		due = append(due, node)
+	}
+	for name := range s.nextScrape {
+		if !existing[name] {
+			delete(s.nextScrape, name)
+			delete(s.batches, name)
+		}
+	}
+	return due, shortest
+}
+
+// next returns when the next node is due, but no later than maxWait from now,
+// so that new nodes are picked up.
+func (s *scrapeSchedule) next(now time.Time, maxWait time.Duration) time.Time {
+	next := now.Add(maxWait)
+	for _, t := range s.nextScrape {
+		if t.Before(next) {
+			next = t
+		}
+	}
+	return next
+}
+
+// merge records the metrics of the scraped nodes, and returns the latest
+// metrics of all known nodes. Scraped nodes without metrics are dropped, the
+// same way as when scraping all nodes at once.
+func (s *scrapeSchedule) merge(scraped []*corev1.Node, batches map[string]*storage.MetricsBatch) *storage.MetricsBatch {
+	due := make(map[string]bool, len(scraped))
+	for _, node := range scraped {
+		due[node.Name] = true
+		if batch, found := batches[node.Name]; found {
+			s.batches[node.Name] = batch
+		} else {
+			delete(s.batches, node.Name)
+		}
+	}
+	if s.pods != nil {
+		for name, batch := range s.batches {
+			if !due[name] {
+				s.batches[name] = withoutDeletedPods(batch, s.pods)
+			}
+		}
+	}
+	res := &storage.MetricsBatch{}
+	for _, batch := range s.batches {
+		res.Nodes = append(res.Nodes, batch.Nodes...)
+		res.Pods = append(res.Pods, batch.Pods...)
+	}
+	return res
+}
+
+// withoutDeletedPods returns the batch without pods which the informer no
+// longer knows, or knows under a different UID.
+func withoutDeletedPods(batch *storage.MetricsBatch, pods v1listers.PodLister) *storage.MetricsBatch {
+	res := &storage.MetricsBatch{
+		Nodes: batch.Nodes,
+		Pods:  make([]storage.PodMetricsPoint, 0, len(batch.Pods)),
+	}
+	for _, point := range batch.Pods {
+		pod, err := pods.Pods(point.Namespace).Get(point.Name)
+		if apierrors.IsNotFound(err) || (err == nil && point.UID != "" && string(pod.UID) != point.UID) {
+			continue
+		}
+		res.Pods = append(res.Pods, point)
+	}
+	return res
+}
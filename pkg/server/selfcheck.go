@@ -0,0 +1,127 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/server/healthz"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+)
+
+// selfCheck periodically reads node metrics back from storage, the same way
+// they are read when serving the API, and fails if none of them are fresh.
+// It catches storage and serving bugs which don't surface as scrape errors.
+type selfCheck struct {
+	nodes   v1listers.NodeLister
+	metrics api.NodeMetricsGetter
+	// maxAge is the oldest a node metrics timestamp can be to be considered fresh.
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu      sync.RWMutex
+	lastErr error
+}
+
+var _ healthz.HealthChecker = (*selfCheck)(nil)
+
+func newSelfCheck(nodes v1listers.NodeLister, metrics api.NodeMetricsGetter, maxAge time.Duration) *selfCheck {
+	return &selfCheck{
+		nodes:   nodes,
+		metrics: metrics,
+		maxAge:  maxAge,
+		now:     time.Now,
+	}
+}
+
+func (c *selfCheck) Name() string {
+	return "selfcheck"
+}
+
+// Check returns the result of the last self check. It passes until the first
+// check has run, the same way readiness passes before the first scrape.
+func (c *selfCheck) Check(_ *http.Request) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lastErr
+}
+
+func (c *selfCheck) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.update(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *selfCheck) update(ctx context.Context) {
+	err := c.check(ctx)
+	if err != nil {
+		klog.Warningf("self check failed: %v", err)
+	}
+	c.mu.Lock()
+	c.lastErr = err
+	c.mu.Unlock()
+}
+
+// check verifies that at least one known node has metrics newer than maxAge.
+func (c *selfCheck) check(ctx context.Context) error {
+	nodes, err := c.nodes.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("unable to list nodes: %v", err)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	names := make([]string, len(nodes))
+	for i, node := range nodes {
+		names[i] = node.Name
+	}
+
+	timestamps, usages, err := c.metrics.GetNodeMetrics(ctx, names...)
+	if err != nil {
+		return fmt.Errorf("unable to read node metrics: %v", err)
+	}
+	var newest time.Time
+	for i := range names {
+		if usages[i] == nil {
+			continue
+		}
+		if timestamps[i].Timestamp.After(newest) {
+			newest = timestamps[i].Timestamp
+		}
+	}
+	if newest.IsZero() {
+		return fmt.Errorf("no metrics stored for any of %d nodes", len(names))
+	}
+	if age := c.now().Sub(newest); age > c.maxAge {
+		return fmt.Errorf("newest node metrics are %s old, more than %s", age, c.maxAge)
+	}
+	return nil
+}
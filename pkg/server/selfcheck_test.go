@@ -0,0 +1,108 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Self check", func() {
+	var (
+		now     time.Time
+		indexer cache.Indexer
+		getter  *nodeMetricsGetterMock
+		check   *selfCheck
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})).To(Succeed())
+		Expect(indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})).To(Succeed())
+		getter = &nodeMetricsGetterMock{timestamps: map[string]time.Time{
+			"node1": now.Add(-time.Minute),
+		}}
+		check = newSelfCheck(v1listers.NewNodeLister(indexer), getter, 2*time.Minute)
+		check.now = func() time.Time { return now }
+	})
+
+	It("should pass before the first check", func() {
+		Expect(check.Check(nil)).To(Succeed())
+	})
+	It("should pass if a node has fresh metrics", func() {
+		check.update(context.Background())
+		Expect(check.Check(nil)).To(Succeed())
+	})
+	It("should fail if node metrics are stale", func() {
+		now = now.Add(2 * time.Minute)
+		check.update(context.Background())
+		Expect(check.Check(nil)).NotTo(Succeed())
+	})
+	It("should use the freshest node metrics", func() {
+		now = now.Add(2 * time.Minute)
+		getter.timestamps["node2"] = now
+		check.update(context.Background())
+		Expect(check.Check(nil)).To(Succeed())
+	})
+	It("should fail if no node metrics are stored", func() {
+		getter.timestamps = map[string]time.Time{}
+		check.update(context.Background())
+		Expect(check.Check(nil)).NotTo(Succeed())
+	})
+	It("should pass if there are no nodes", func() {
+		Expect(indexer.Replace(nil, "")).To(Succeed())
+		check.update(context.Background())
+		Expect(check.Check(nil)).To(Succeed())
+	})
+	It("should recover once metrics are fresh again", func() {
+		now = now.Add(2 * time.Minute)
+		check.update(context.Background())
+		Expect(check.Check(nil)).NotTo(Succeed())
+		getter.timestamps["node1"] = now
+		check.update(context.Background())
+		Expect(check.Check(nil)).To(Succeed())
+	})
+})
+
+type nodeMetricsGetterMock struct {
+	timestamps map[string]time.Time
+}
+
+var _ api.NodeMetricsGetter = (*nodeMetricsGetterMock)(nil)
+
+func (g *nodeMetricsGetterMock) GetNodeMetrics(_ context.Context, nodes ...string) ([]api.TimeInfo, []corev1.ResourceList, error) {
+	timestamps := make([]api.TimeInfo, len(nodes))
+	usages := make([]corev1.ResourceList, len(nodes))
+	for i, node := range nodes {
+		ts, ok := g.timestamps[node]
+		if !ok {
+			continue
+		}
+		timestamps[i] = api.TimeInfo{Timestamp: ts}
+		usages[i] = corev1.ResourceList{}
+	}
+	return timestamps, usages, nil
+}
@@ -21,12 +21,17 @@ import (
 	"sync"
 	"time"
 
+	"k8s.io/apimachinery/pkg/labels"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/healthz"
 	"k8s.io/client-go/informers"
+	v1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/component-base/metrics"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 
+	"sigs.k8s.io/metrics-server/pkg/remotewrite"
 	"sigs.k8s.io/metrics-server/pkg/scraper"
 	"sigs.k8s.io/metrics-server/pkg/storage"
 	"sigs.k8s.io/metrics-server/pkg/utils"
@@ -37,10 +42,20 @@ var (
 	// (acts as a no-op by default), but we can't just register it in the constructor,
 	// since it could be called multiple times during setup.
 	tickDuration *metrics.Histogram = metrics.NewHistogram(&metrics.HistogramOpts{})
+
+	lastFullCycle = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "manager",
+			Name:      "last_full_cycle_timestamp_seconds",
+			Help:      "Start time of the last scrape cycle in which every node due was scraped successfully, in seconds since the Unix epoch. Nodes excluded from scraping, e.g. by taints or sampling, are not due.",
+		},
+	)
 )
 
 // RegisterServerMetrics creates and registers a histogram metric for
-// scrape duration.
+// scrape duration, and metrics for the APIService health, scraped usage and
+// configuration.
 func RegisterServerMetrics(registrationFunc func(metrics.Registerable) error, resolution time.Duration) error {
 	tickDuration = metrics.NewHistogram(
 		&metrics.HistogramOpts{
@@ -51,19 +66,39 @@ func RegisterServerMetrics(registrationFunc func(metrics.Registerable) error, re
 			Buckets:   utils.BucketsForScrapeDuration(resolution),
 		},
 	)
-	return registrationFunc(tickDuration)
+	for _, metric := range []metrics.Registerable{
+		tickDuration,
+		apiServiceAvailable,
+		apiServiceErrors,
+		scrapedCPUUsage,
+		scrapedMemoryUsage,
+		discardedCycles,
+		unhealthyCycles,
+		lastFullCycle,
+		cycleStartOffset,
+		oversizedLists,
+		configInfo,
+	} {
+		err := registrationFunc(metric)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func NewServer(
 	sync cache.InformerSynced, informer informers.SharedInformerFactory,
 	apiserver *genericapiserver.GenericAPIServer, storage storage.Storage,
-	scraper scraper.Scraper, resolution time.Duration) *server {
+	scraper scraper.Scraper, nodes v1listers.NodeLister, resolution time.Duration) *server {
 	return &server{
 		sync:             sync,
 		informer:         informer,
 		GenericAPIServer: apiserver,
 		storage:          storage,
 		scraper:          scraper,
+		nodes:            nodes,
+		schedule:         newScrapeSchedule(resolution),
 		resolution:       resolution,
 		tickLastStart:    time.Now(),
 		tickLastOK:       true,
@@ -76,11 +111,56 @@ type server struct {
 
 	sync     cache.InformerSynced
 	informer informers.SharedInformerFactory
+	// leaseInformer, if set, watches node leases in their own namespace.
+	leaseInformer informers.SharedInformerFactory
 
 	storage    storage.Storage
 	scraper    scraper.Scraper
 	resolution time.Duration
+	// cycleDeadline bounds the duration of a scrape cycle, the resolution if zero
+	cycleDeadline time.Duration
+	// scrapeErrorsLogged is set if the scraper logs its errors itself
+	scrapeErrorsLogged bool
+	// phaseOffset, if set, aligns cycles to start this long past a multiple
+	// of the resolution, otherwise the first cycle starts right away
+	phaseOffset *time.Duration
+	// nodes are scraped on their own schedule, every resolution unless
+	// overridden with ScrapeIntervalLabel
+	nodes    v1listers.NodeLister
+	schedule *scrapeSchedule
+	// cycleFilter is nil unless cycles with too few nodes are discarded
+	cycleFilter *cycleFilter
+	// cycleHealth is nil unless cycles with too few nodes fail readiness
+	cycleHealth *cycleHealth
+	// terminalPods is nil unless pods in a terminal phase are left out of storage
+	terminalPods v1listers.PodLister
+	// selfCheck is nil unless self checking is enabled
+	selfCheck *selfCheck
+	// apiService is nil unless APIService monitoring is enabled
+	apiService *apiServiceCheck
+	// caBundle is nil unless the APIService caBundle is kept up to date
+	caBundle *caBundleUpdater
+	// remoteWrite is nil unless scraped usage is exported with remote write
+	remoteWrite *remotewrite.Writer
+	// nodeAddresses is nil unless node addresses are read from a file
+	nodeAddresses *utils.NodeAddresses
+	// leaderElector and leaderProxy are nil unless only the elected leader
+	// scrapes, the other replicas proxying metric reads to it
+	leaderElector *leaderelection.LeaderElector
+	leaderProxy   *leaderProxy
+	// dumper is nil unless scrape cycles are dumped for debugging
+	dumper *scraper.BatchDumper
+	// restartGrace is nil unless restarts get a grace period to report ready
+	restartGrace *restartGrace
+	// perResourceReadiness reports stored node and pod metrics as separate
+	// readiness checks instead of storage-nonempty
+	perResourceReadiness bool
+	// events is nil unless stored metrics are pushed to subscribed clients
+	events *eventStream
 
+	// cycleMux serializes the cycles of the scrape loop and those run on
+	// demand for profiling
+	cycleMux sync.Mutex
 	// tickStatusMux protects tick fields
 	tickStatusMux sync.RWMutex
 	// tickLastStart is equal to start time of last unfinished tick
@@ -92,6 +172,12 @@ type server struct {
 // RunUntil starts background scraping goroutine and runs apiserver serving metrics.
 func (s *server) RunUntil(stopCh <-chan struct{}) error {
 	s.informer.Start(stopCh)
+	if s.leaseInformer != nil {
+		s.leaseInformer.Start(stopCh)
+	}
+	if s.restartGrace != nil {
+		s.restartGrace.begin(context.Background())
+	}
 	shutdown := cache.WaitForCacheSync(stopCh, s.sync)
 	if !shutdown {
 		return nil
@@ -99,54 +185,175 @@ func (s *server) RunUntil(stopCh <-chan struct{}) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go s.runScrape(ctx)
-	return s.GenericAPIServer.PrepareRun().Run(stopCh)
+	if s.selfCheck != nil {
+		go s.selfCheck.run(ctx, s.resolution)
+	}
+	if s.apiService != nil {
+		go s.apiService.run(ctx, s.resolution)
+	}
+	if s.caBundle != nil {
+		go s.caBundle.run(ctx, s.resolution)
+	}
+	if s.remoteWrite != nil {
+		go s.remoteWrite.Run(ctx)
+	}
+	if s.nodeAddresses != nil {
+		go s.nodeAddresses.Run(ctx, s.resolution)
+	}
+	if s.leaderElector != nil {
+		go runLeaderElection(ctx, s.leaderElector)
+	}
+	err := s.GenericAPIServer.PrepareRun().Run(stopCh)
+	if s.restartGrace != nil {
+		if err := s.restartGrace.announce(context.Background()); err != nil {
+			klog.Warningf("Unable to record graceful shutdown in restart Lease: %v", err)
+		}
+	}
+	return err
 }
 
+// runScrape wakes up whenever a node is due to be scraped, and at least
+// every resolution so that liveness reflects the loop running.
 func (s *server) runScrape(ctx context.Context) {
-	ticker := time.NewTicker(s.resolution)
-	defer ticker.Stop()
-	s.tick(ctx, time.Now())
+	if !s.waitForPhase(ctx) {
+		return
+	}
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	for {
 		select {
-		case startTime := <-ticker.C:
+		case startTime := <-timer.C:
 			s.tick(ctx, startTime)
+			timer.Reset(time.Until(s.schedule.next(time.Now(), s.resolution)))
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// waitForPhase delays the first cycle until the phase offset, returning
+// false if the context is done first.
+func (s *server) waitForPhase(ctx context.Context) bool {
+	if s.phaseOffset == nil {
+		return true
+	}
+	// waiting is at most a resolution, so liveness counts from its start
+	s.tickStatusMux.Lock()
+	s.tickLastStart = time.Now()
+	s.tickStatusMux.Unlock()
+	timer := time.NewTimer(phaseDelay(time.Now(), s.resolution, *s.phaseOffset))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (s *server) tick(ctx context.Context, startTime time.Time) {
+	s.cycleMux.Lock()
+	defer s.cycleMux.Unlock()
 	s.tickStatusMux.Lock()
 	s.tickLastStart = startTime
 	s.tickStatusMux.Unlock()
+	cycleStartOffset.Set(offsetInResolution(startTime, s.resolution).Seconds())
 
+	if s.following() {
+		klog.V(6).Infof("Not leading, skipping cycle")
+		return
+	}
 	tickOK := true
-	ctx, cancelTimeout := context.WithTimeout(ctx, s.resolution)
+	deadline := s.resolution
+	if s.cycleDeadline > 0 {
+		deadline = s.cycleDeadline
+	}
+	// nodes not scraped by the deadline are canceled and reported as failed
+	ctx, cancelTimeout := context.WithTimeout(ctx, deadline)
 	defer cancelTimeout()
 
 	klog.V(6).Infof("Beginning cycle, scraping metrics...")
-	data, scrapeErr := s.scraper.Scrape(ctx)
-	if scrapeErr != nil {
-		klog.Errorf("unable to fully scrape metrics: %v", scrapeErr)
+	data, scraped, scrapeErr := s.scrape(ctx, startTime)
+	if !scraped {
+		klog.V(6).Infof("...No nodes due, cycle complete")
+		return
+	}
+	if scrapeErr == nil {
+		lastFullCycle.Set(float64(startTime.Unix()))
+	} else {
+		if !s.scrapeErrorsLogged {
+			klog.Errorf("unable to fully scrape metrics: %v", scrapeErr)
+		}
 		if len(data.Nodes) == 0 {
 			tickOK = false
 		}
 	}
 
+	if s.cycleFilter != nil && s.cycleFilter.discard(data) {
+		// keep serving the previous metrics, which become stale rather than missing
+		klog.Warningf("discarding scrape cycle with metrics for %d nodes", len(data.Nodes))
+		discardedCycles.Inc()
+		tickDuration.Observe(float64(time.Since(startTime)) / float64(time.Second))
+		s.tickStatusMux.Lock()
+		s.tickLastOK = false
+		s.tickStatusMux.Unlock()
+		return
+	}
+	healthy := tickOK
+	if s.cycleHealth != nil && !s.cycleHealth.healthy(data) {
+		klog.Warningf("scrape cycle returned metrics for only %d nodes, marking it unhealthy", len(data.Nodes))
+		unhealthyCycles.Inc()
+		healthy = false
+	}
+	if s.terminalPods != nil {
+		data = withoutTerminalPods(data, s.terminalPods)
+	}
+	if s.dumper != nil {
+		s.dumper.Dump(startTime, data)
+	}
 	klog.V(6).Infof("...Storing metrics...")
 	s.storage.Store(data)
+	if s.events != nil {
+		s.events.publish(ctx)
+	}
+	if tickOK {
+		recordUsage(data)
+	}
+	if s.remoteWrite != nil && tickOK {
+		s.remoteWrite.Enqueue(data)
+	}
 
 	collectTime := time.Since(startTime)
 	tickDuration.Observe(float64(collectTime) / float64(time.Second))
 	klog.V(6).Infof("...Cycle complete")
 
 	s.tickStatusMux.Lock()
-	s.tickLastOK = tickOK
+	s.tickLastOK = healthy
 	s.tickStatusMux.Unlock()
 }
 
+// scrape collects metrics of the nodes due at the given time, merged with the
+// latest metrics of the other nodes. It returns false if no node was due.
+func (s *server) scrape(ctx context.Context, now time.Time) (*storage.MetricsBatch, bool, error) {
+	nodes, err := s.nodes.List(labels.Everything())
+	if err != nil {
+		if s.scrapeErrorsLogged {
+			klog.Errorf("unable to list nodes: %v", err)
+		}
+		return &storage.MetricsBatch{}, true, err
+	}
+	due, shortest := s.schedule.due(nodes, now)
+	if len(due) == 0 {
+		return nil, false, nil
+	}
+	// a slow node must not hold the cycle past the next scrape of the others
+	ctx, cancel := context.WithTimeout(ctx, shortest)
+	defer cancel()
+	batches, err := s.scraper.ScrapeNodes(ctx, due, nodes)
+	return s.schedule.merge(due, batches), true, err
+}
+
 // Check if MS is alive by looking at last tick time.
 // If its deadlock or panic, tick wouldn't be happening on the tick interval
 func (s *server) CheckLiveness(_ *http.Request) error {
@@ -162,8 +369,45 @@ func (s *server) CheckLiveness(_ *http.Request) error {
 	return nil
 }
 
-// Check if MS is ready by checking if last tick was ok
-func (s *server) CheckReadiness(_ *http.Request) error {
+// ReadyzChecks returns the named checks that make up readiness. Each check is
+// reported separately on /readyz?verbose and can be excluded with /readyz?exclude.
+// Checks of scraped metrics pass during the grace period after a restart.
+func (s *server) ReadyzChecks() []healthz.HealthChecker {
+	scrapeChecks := []healthz.HealthChecker{healthz.NamedCheck("last-scrape-fresh", s.CheckScrapeFresh)}
+	if s.perResourceReadiness {
+		scrapeChecks = append(scrapeChecks,
+			healthz.NamedCheck("node-metrics-ready", s.CheckStorageNonEmpty),
+			healthz.NamedCheck("pod-metrics-ready", s.CheckPodMetricsStored),
+		)
+	} else {
+		scrapeChecks = append(scrapeChecks, healthz.NamedCheck("storage-nonempty", s.CheckStorageNonEmpty))
+	}
+	if s.restartGrace != nil {
+		for i, check := range scrapeChecks {
+			scrapeChecks[i] = s.restartGrace.wrap(check)
+		}
+	}
+	return append([]healthz.HealthChecker{healthz.NamedCheck("informer-synced", s.CheckInformerSynced)}, scrapeChecks...)
+}
+
+// Check if informers used to list nodes and pods have synced
+func (s *server) CheckInformerSynced(_ *http.Request) error {
+	if !s.sync() {
+		return fmt.Errorf("informers not synced")
+	}
+	return nil
+}
+
+// following returns true if another replica scrapes and serves metrics.
+func (s *server) following() bool {
+	return s.leaderProxy != nil && s.leaderProxy.following()
+}
+
+// Check if last tick was ok
+func (s *server) CheckScrapeFresh(_ *http.Request) error {
+	if s.following() {
+		return nil
+	}
 	s.tickStatusMux.RLock()
 	tickLastOK := s.tickLastOK
 	s.tickStatusMux.RUnlock()
@@ -173,3 +417,25 @@ func (s *server) CheckReadiness(_ *http.Request) error {
 	}
 	return nil
 }
+
+// Check if storage holds metrics for at least one node
+func (s *server) CheckStorageNonEmpty(_ *http.Request) error {
+	if s.following() {
+		return nil
+	}
+	if s.storage.Empty() {
+		return fmt.Errorf("no node metrics stored")
+	}
+	return nil
+}
+
+// Check if storage holds metrics for at least one pod
+func (s *server) CheckPodMetricsStored(_ *http.Request) error {
+	if s.following() {
+		return nil
+	}
+	if s.storage.PodsEmpty() {
+		return fmt.Errorf("no pod metrics stored")
+	}
+	return nil
+}
@@ -17,12 +17,21 @@ package server
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apiserver/pkg/server/healthz"
+	v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/component-base/metrics/testutil"
 	"k8s.io/metrics/pkg/apis/metrics"
 
 	"sigs.k8s.io/metrics-server/pkg/api"
@@ -63,7 +72,9 @@ var _ = Describe("Server", func() {
 			},
 		}
 		store = &storageMock{}
-		server = NewServer(nil, nil, nil, store, scraper, resolution)
+		nodes := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+		Expect(nodes.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})).To(Succeed())
+		server = NewServer(nil, nil, nil, store, scraper, v1listers.NewNodeLister(nodes), resolution)
 	})
 
 	It("liveness should pass before first scrape tick finishes", func() {
@@ -82,52 +93,544 @@ var _ = Describe("Server", func() {
 		server.tick(context.Background(), time.Now().Add(-2*resolution))
 		Expect(server.CheckLiveness(nil)).NotTo(Succeed())
 	})
-	It("readiness should fail before first tick finishes", func() {
-		Expect(server.CheckReadiness(nil)).To(Succeed())
+	It("last scrape check should pass before first tick finishes", func() {
+		Expect(server.CheckScrapeFresh(nil)).To(Succeed())
 	})
-	It("readiness should pass if scrape succeeds", func() {
+	It("last scrape check should pass if scrape succeeds", func() {
 		server.tick(context.Background(), time.Now())
-		Expect(server.CheckReadiness(nil)).To(Succeed())
+		Expect(server.CheckScrapeFresh(nil)).To(Succeed())
 	})
-	It("readiness should pass if scrape returns empty result", func() {
+	It("last scrape check should pass if scrape returns empty result", func() {
 		scraper.result.Nodes = []storage.NodeMetricsPoint{}
 		server.tick(context.Background(), time.Now())
-		Expect(server.CheckReadiness(nil)).To(Succeed())
+		Expect(server.CheckScrapeFresh(nil)).To(Succeed())
 	})
-	It("readiness should pass if scrape fails but returns at least one result", func() {
+	It("last scrape check should pass if scrape fails but returns at least one result", func() {
 		scraper.err = fmt.Errorf("failed to scrape")
 		server.tick(context.Background(), time.Now())
-		Expect(server.CheckReadiness(nil)).To(Succeed())
+		Expect(server.CheckScrapeFresh(nil)).To(Succeed())
 	})
-	It("readiness should fail if scrape fails without results", func() {
+	It("last scrape check should fail if scrape fails without results", func() {
 		scraper.err = fmt.Errorf("failed to scrape")
 		scraper.result.Nodes = []storage.NodeMetricsPoint{}
 		server.tick(context.Background(), time.Now())
-		Expect(server.CheckReadiness(nil)).NotTo(Succeed())
+		Expect(server.CheckScrapeFresh(nil)).NotTo(Succeed())
+	})
+	It("scrape cycle should stop at the deadline", func() {
+		scraper.delay = 5 * time.Second
+		server.cycleDeadline = 100 * time.Millisecond
+		start := time.Now()
+		server.tick(context.Background(), start)
+		Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+		By("recording the cycle as failed")
+		Expect(server.CheckScrapeFresh(nil)).NotTo(Succeed())
+	})
+	It("should fail the cycle when nodes can't be listed", func() {
+		server.nodes = failingNodeLister{}
+		server.tick(context.Background(), time.Now())
+		Expect(scraper.scrapes).To(BeEmpty())
+		Expect(server.CheckScrapeFresh(nil)).NotTo(Succeed())
+	})
+	Context("when exporting scraped usage", func() {
+		BeforeEach(func() {
+			scrapedCPUUsage.Create(nil)
+			scrapedMemoryUsage.Create(nil)
+			scrapedCPUUsage.Reset()
+			scrapedMemoryUsage.Reset()
+			scraper.result = &storage.MetricsBatch{
+				Nodes: []storage.NodeMetricsPoint{
+					{Name: "node1", MetricsPoint: storage.MetricsPoint{CpuUsage: resource.MustParse("1500m"), MemoryUsage: resource.MustParse("2Gi")}},
+					{Name: "node2", MetricsPoint: storage.MetricsPoint{CpuUsage: resource.MustParse("500m"), MemoryUsage: resource.MustParse("1Gi")}},
+				},
+				Pods: []storage.PodMetricsPoint{
+					{Name: "pod1", Namespace: "ns1", Containers: []storage.ContainerMetricsPoint{
+						{Name: "container1", MetricsPoint: storage.MetricsPoint{CpuUsage: resource.MustParse("250m"), MemoryUsage: resource.MustParse("512Mi")}},
+						{Name: "container2", MetricsPoint: storage.MetricsPoint{CpuUsage: resource.MustParse("1m"), MemoryUsage: resource.MustParse("1Mi")}},
+					}},
+					{Name: "pod2", Namespace: "ns2", Containers: []storage.ContainerMetricsPoint{
+						{Name: "container1", MetricsPoint: storage.MetricsPoint{CpuUsage: resource.MustParse("100n"), MemoryUsage: resource.MustParse("1Ki")}},
+					}},
+				},
+			}
+		})
+
+		It("should export the totals of the last scrape cycle", func() {
+			server.tick(context.Background(), time.Now())
+			err := testutil.CollectAndCompare(scrapedCPUUsage, strings.NewReader(`
+		# HELP metrics_server_scraped_cpu_usage_cores [ALPHA] Total CPU usage of all nodes or all pods in the last successful scrape cycle, in cores.
+		# TYPE metrics_server_scraped_cpu_usage_cores gauge
+		metrics_server_scraped_cpu_usage_cores{kind="node"} 2
+		metrics_server_scraped_cpu_usage_cores{kind="pod"} 0.2510001
+		`), "metrics_server_scraped_cpu_usage_cores")
+			Expect(err).NotTo(HaveOccurred())
+			err = testutil.CollectAndCompare(scrapedMemoryUsage, strings.NewReader(`
+		# HELP metrics_server_scraped_memory_usage_bytes [ALPHA] Total memory working set of all nodes or all pods in the last successful scrape cycle, in bytes.
+		# TYPE metrics_server_scraped_memory_usage_bytes gauge
+		metrics_server_scraped_memory_usage_bytes{kind="node"} 3.221225472e+09
+		metrics_server_scraped_memory_usage_bytes{kind="pod"} 5.37920512e+08
+		`), "metrics_server_scraped_memory_usage_bytes")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should keep the totals of the last successful cycle when scraping fails", func() {
+			start := time.Now()
+			server.tick(context.Background(), start)
+			scraper.result = &storage.MetricsBatch{}
+			scraper.err = fmt.Errorf("failed to scrape")
+			server.tick(context.Background(), start.Add(resolution))
+			err := testutil.CollectAndCompare(scrapedCPUUsage, strings.NewReader(`
+		# HELP metrics_server_scraped_cpu_usage_cores [ALPHA] Total CPU usage of all nodes or all pods in the last successful scrape cycle, in cores.
+		# TYPE metrics_server_scraped_cpu_usage_cores gauge
+		metrics_server_scraped_cpu_usage_cores{kind="node"} 2
+		metrics_server_scraped_cpu_usage_cores{kind="pod"} 0.2510001
+		`), "metrics_server_scraped_cpu_usage_cores")
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+	Context("with a scrape phase offset", func() {
+		epoch := time.Unix(0, 0)
+
+		It("should delay cycles until the offset past a multiple of the resolution", func() {
+			now := epoch.Add(100*time.Minute + 10*time.Second)
+			Expect(phaseDelay(now, time.Minute, 30*time.Second)).To(Equal(20 * time.Second))
+			Expect(phaseDelay(now, time.Minute, 10*time.Second)).To(Equal(time.Duration(0)))
+			Expect(phaseDelay(now, time.Minute, 5*time.Second)).To(Equal(55 * time.Second))
+		})
+		It("should derive deterministic offsets within the resolution from seeds", func() {
+			first := PhaseOffsetFromSeed("metrics-server-6d94bc8694-2xkrn", time.Minute)
+			Expect(PhaseOffsetFromSeed("metrics-server-6d94bc8694-2xkrn", time.Minute)).To(Equal(first))
+			Expect(PhaseOffsetFromSeed("metrics-server-6d94bc8694-q7vzl", time.Minute)).NotTo(Equal(first))
+			Expect(first).To(BeNumerically(">=", 0))
+			Expect(first).To(BeNumerically("<", time.Minute))
+		})
+		It("should start the first cycle at the configured offset", func() {
+			cycleStartOffset.Create(nil)
+			cycleStartOffset.Set(0)
+			server.resolution = time.Second
+			offset := 300 * time.Millisecond
+			server.phaseOffset = &offset
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go server.runScrape(ctx)
+			Eventually(func() (float64, error) {
+				return testutil.GetGaugeMetricValue(cycleStartOffset)
+			}, 2*time.Second, 10*time.Millisecond).Should(BeNumerically("~", offset.Seconds(), 0.05))
+		})
+	})
+	Context("when following the leader", func() {
+		var election *fakeLeaderElection
+
+		BeforeEach(func() {
+			election = &fakeLeaderElection{}
+			server.leaderProxy = &leaderProxy{election: election}
+		})
+
+		It("should not scrape while following", func() {
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored).To(BeNil())
+			Expect(server.CheckLiveness(nil)).To(Succeed())
+		})
+		It("should be ready without stored metrics while following", func() {
+			store.empty = true
+			Expect(server.CheckStorageNonEmpty(nil)).To(Succeed())
+			Expect(server.CheckScrapeFresh(nil)).To(Succeed())
+		})
+		It("should scrape once leading", func() {
+			election.leading = true
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored).NotTo(BeNil())
+		})
+	})
+	Context("when discarding empty cycles", func() {
+		var previous *storage.MetricsBatch
+
+		BeforeEach(func() {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, name := range []string{"node1", "node2", "node3", "node4"} {
+				Expect(indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+			}
+			server.cycleFilter = &cycleFilter{nodes: v1listers.NewNodeLister(indexer), minNodeFraction: 0.5}
+			discardedCycles.Create(nil)
+			discardedCycles.Reset()
+			previous = &storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: "node1"}, {Name: "node2"}, {Name: "node3"}}}
+			store.stored = previous
+		})
+
+		It("should keep the stored metrics when the cycle returns no node", func() {
+			scraper.result = &storage.MetricsBatch{}
+			scraper.err = fmt.Errorf("failed to scrape")
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored).To(BeIdenticalTo(previous))
+			Expect(server.CheckScrapeFresh(nil)).NotTo(Succeed())
+			err := testutil.CollectAndCompare(discardedCycles, strings.NewReader(`
+		# HELP metrics_server_manager_discarded_cycles_total [ALPHA] Number of scrape cycles discarded because they returned metrics for too few nodes.
+		# TYPE metrics_server_manager_discarded_cycles_total counter
+		metrics_server_manager_discarded_cycles_total 1
+		`), "metrics_server_manager_discarded_cycles_total")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should keep the stored metrics when the cycle returns less than the minimum fraction of nodes", func() {
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored).To(BeIdenticalTo(previous))
+		})
+		It("should store a partial cycle returning at least the minimum fraction of nodes", func() {
+			partial := &storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: "node1"}, {Name: "node2"}}}
+			scraper.result = partial
+			scraper.err = fmt.Errorf("failed to scrape node3")
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored).To(Equal(partial))
+			Expect(server.CheckScrapeFresh(nil)).To(Succeed())
+		})
+		It("should store empty cycles by default", func() {
+			server.cycleFilter = nil
+			scraper.result = &storage.MetricsBatch{}
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored.Nodes).To(BeEmpty())
+		})
+	})
+	It("should update the last full cycle time only if every node is scraped", func() {
+		lastFullCycle.Create(nil)
+		lastFullCycle.Set(0)
+		start := time.Now()
+
+		By("leaving it unset after a partially failed cycle")
+		scraper.err = fmt.Errorf("failed to scrape node2")
+		server.tick(context.Background(), start)
+		Expect(testutil.GetGaugeMetricValue(lastFullCycle)).To(BeZero())
+
+		By("setting it to the start of a fully successful cycle")
+		scraper.err = nil
+		server.tick(context.Background(), start.Add(resolution))
+		Expect(testutil.GetGaugeMetricValue(lastFullCycle)).To(BeEquivalentTo(start.Add(resolution).Unix()))
+
+		By("keeping it after a later failed cycle")
+		scraper.err = fmt.Errorf("failed to scrape node2")
+		server.tick(context.Background(), start.Add(2*resolution))
+		Expect(testutil.GetGaugeMetricValue(lastFullCycle)).To(BeEquivalentTo(start.Add(resolution).Unix()))
+	})
+	Context("with a minimum cycle success ratio", func() {
+		BeforeEach(func() {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, name := range []string{"node1", "node2", "node3", "node4"} {
+				Expect(indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})).To(Succeed())
+			}
+			server.cycleHealth = &cycleHealth{nodes: v1listers.NewNodeLister(indexer), minSuccessRatio: 0.5}
+			unhealthyCycles.Create(nil)
+			unhealthyCycles.Reset()
+		})
+
+		It("should mark a cycle below the ratio unhealthy but still store it", func() {
+			scraper.err = fmt.Errorf("failed to scrape node2, node3, node4")
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored).To(Equal(scraper.result))
+			Expect(server.CheckScrapeFresh(nil)).NotTo(Succeed())
+			err := testutil.CollectAndCompare(unhealthyCycles, strings.NewReader(`
+		# HELP metrics_server_manager_unhealthy_cycles_total [ALPHA] Number of scrape cycles marked unhealthy because they returned metrics for less than the minimum success ratio of nodes.
+		# TYPE metrics_server_manager_unhealthy_cycles_total counter
+		metrics_server_manager_unhealthy_cycles_total 1
+		`), "metrics_server_manager_unhealthy_cycles_total")
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("should keep a cycle reaching the ratio healthy", func() {
+			scraper.result = &storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: "node1"}, {Name: "node2"}, {Name: "node3"}}}
+			scraper.err = fmt.Errorf("failed to scrape node4")
+			server.tick(context.Background(), time.Now())
+			Expect(server.CheckScrapeFresh(nil)).To(Succeed())
+		})
+		It("should recover once a cycle reaches the ratio", func() {
+			start := time.Now()
+			server.tick(context.Background(), start)
+			Expect(server.CheckScrapeFresh(nil)).NotTo(Succeed())
+			scraper.result = &storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: "node1"}, {Name: "node2"}}}
+			server.tick(context.Background(), start.Add(resolution))
+			Expect(server.CheckScrapeFresh(nil)).To(Succeed())
+		})
+	})
+	Context("when skipping pods in a terminal phase", func() {
+		BeforeEach(func() {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			for name, phase := range map[string]corev1.PodPhase{
+				"pending":   corev1.PodPending,
+				"running":   corev1.PodRunning,
+				"succeeded": corev1.PodSucceeded,
+				"failed":    corev1.PodFailed,
+				"unknown":   corev1.PodUnknown,
+			} {
+				Expect(indexer.Add(&corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"},
+					Status:     corev1.PodStatus{Phase: phase},
+				})).To(Succeed())
+			}
+			scraper.result.Pods = []storage.PodMetricsPoint{
+				{Name: "pending", Namespace: "ns1"},
+				{Name: "running", Namespace: "ns1"},
+				{Name: "succeeded", Namespace: "ns1"},
+				{Name: "failed", Namespace: "ns1"},
+				{Name: "unknown", Namespace: "ns1"},
+				{Name: "not-synced-yet", Namespace: "ns1"},
+				{Name: "succeeded", Namespace: "ns2"},
+			}
+			server.terminalPods = v1listers.NewPodLister(indexer)
+		})
+
+		It("should only store pods which aren't Succeeded or Failed according to the informer", func() {
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored.Nodes).To(HaveLen(1))
+			Expect(store.stored.Pods).To(ConsistOf(
+				storage.PodMetricsPoint{Name: "pending", Namespace: "ns1"},
+				storage.PodMetricsPoint{Name: "running", Namespace: "ns1"},
+				storage.PodMetricsPoint{Name: "unknown", Namespace: "ns1"},
+				storage.PodMetricsPoint{Name: "not-synced-yet", Namespace: "ns1"},
+				storage.PodMetricsPoint{Name: "succeeded", Namespace: "ns2"},
+			))
+			By("leaving the scraped batch unchanged")
+			Expect(scraper.result.Pods).To(HaveLen(7))
+		})
+		It("should store all pods by default", func() {
+			server.terminalPods = nil
+			server.tick(context.Background(), time.Now())
+			Expect(store.stored.Pods).To(HaveLen(7))
+		})
+	})
+	Context("with nodes labeled with a scrape interval", func() {
+		var indexer cache.Indexer
+
+		BeforeEach(func() {
+			indexer = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			Expect(indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "default"}})).To(Succeed())
+			Expect(indexer.Add(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   "fast",
+				Labels: map[string]string{ScrapeIntervalLabel: "15s"},
+			}})).To(Succeed())
+			server.nodes = v1listers.NewNodeLister(indexer)
+			scraper.result = nil
+		})
+
+		It("should scrape a node with a shorter interval more frequently", func() {
+			start := time.Now()
+			for elapsed := time.Duration(0); elapsed < 2*resolution; elapsed += 5 * time.Second {
+				server.tick(context.Background(), start.Add(elapsed))
+			}
+			Expect(scraper.scrapes).To(Equal(map[string]int{"default": 2, "fast": 8}))
+		})
+		It("should keep storing metrics of nodes which were not due", func() {
+			start := time.Now()
+			server.tick(context.Background(), start)
+			server.tick(context.Background(), start.Add(15*time.Second))
+			Expect(scraper.scrapes).To(Equal(map[string]int{"default": 1, "fast": 2}))
+			Expect(store.stored.Nodes).To(ConsistOf(
+				storage.NodeMetricsPoint{Name: "default"},
+				storage.NodeMetricsPoint{Name: "fast"},
+			))
+		})
+		It("should forget nodes which were deleted", func() {
+			start := time.Now()
+			server.tick(context.Background(), start)
+			Expect(indexer.Delete(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "default"}})).To(Succeed())
+			server.tick(context.Background(), start.Add(15*time.Second))
+			Expect(store.stored.Nodes).To(ConsistOf(storage.NodeMetricsPoint{Name: "fast"}))
+		})
+		It("should not store pods deleted since their node was scraped again", func() {
+			pods := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			deleted := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "deleted", UID: "uid1"}}
+			recreated := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "recreated", UID: "uid2"}}
+			running := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "running", UID: "uid3"}}
+			for _, pod := range []*corev1.Pod{deleted, recreated, running} {
+				Expect(pods.Add(pod)).To(Succeed())
+			}
+			server.schedule.pods = v1listers.NewPodLister(pods)
+			scraper.pods = map[string][]storage.PodMetricsPoint{"default": {
+				{Namespace: "ns", Name: "deleted", UID: "uid1"},
+				{Namespace: "ns", Name: "recreated", UID: "uid2"},
+				{Namespace: "ns", Name: "running", UID: "uid3"},
+			}}
+			start := time.Now()
+			server.tick(context.Background(), start)
+			Expect(store.stored.Pods).To(HaveLen(3))
+
+			By("deleting pods before the next scrape of their node")
+			Expect(pods.Delete(deleted)).To(Succeed())
+			Expect(pods.Update(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "recreated", UID: "uid4"}})).To(Succeed())
+			server.tick(context.Background(), start.Add(15*time.Second))
+			Expect(scraper.scrapes).To(Equal(map[string]int{"default": 1, "fast": 2}))
+			Expect(store.stored.Pods).To(ConsistOf(storage.PodMetricsPoint{Namespace: "ns", Name: "running", UID: "uid3"}))
+		})
+		It("should not let due nodes delay the next scrape of the shortest interval", func() {
+			start := time.Now()
+			server.tick(context.Background(), start)
+			Expect(scraper.deadline).To(BeTemporally("~", time.Now().Add(15*time.Second), time.Second))
+		})
+		It("should use the default interval for invalid labels", func() {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   "invalid",
+				Labels: map[string]string{ScrapeIntervalLabel: "fast"},
+			}}
+			Expect(server.schedule.interval(node)).To(Equal(resolution))
+			node.Labels[ScrapeIntervalLabel] = "1ms"
+			Expect(server.schedule.interval(node)).To(Equal(resolution))
+		})
+	})
+	It("informer sync check should follow informer sync state", func() {
+		server.sync = func() bool { return false }
+		Expect(server.CheckInformerSynced(nil)).NotTo(Succeed())
+		server.sync = func() bool { return true }
+		Expect(server.CheckInformerSynced(nil)).To(Succeed())
+	})
+	It("storage check should fail while storage is empty", func() {
+		store.empty = true
+		Expect(server.CheckStorageNonEmpty(nil)).NotTo(Succeed())
+		store.empty = false
+		Expect(server.CheckStorageNonEmpty(nil)).To(Succeed())
+	})
+
+	Describe("readyz", func() {
+		var mux *http.ServeMux
+
+		readyz := func() (int, string) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz?verbose", nil))
+			return rec.Code, rec.Body.String()
+		}
+
+		BeforeEach(func() {
+			server.sync = func() bool { return true }
+			server.tick(context.Background(), time.Now())
+			mux = http.NewServeMux()
+			healthz.InstallReadyzHandler(mux, server.ReadyzChecks()...)
+		})
+
+		It("should pass when all checks pass", func() {
+			code, body := readyz()
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(ContainSubstring("[+]informer-synced ok"))
+			Expect(body).To(ContainSubstring("[+]last-scrape-fresh ok"))
+			Expect(body).To(ContainSubstring("[+]storage-nonempty ok"))
+		})
+		It("should report informers not being synced", func() {
+			server.sync = func() bool { return false }
+			code, body := readyz()
+			Expect(code).To(Equal(http.StatusInternalServerError))
+			Expect(body).To(ContainSubstring("[-]informer-synced failed"))
+			Expect(body).To(ContainSubstring("[+]last-scrape-fresh ok"))
+			Expect(body).To(ContainSubstring("[+]storage-nonempty ok"))
+		})
+		It("should report failing scrapes", func() {
+			scraper.err = fmt.Errorf("failed to scrape")
+			scraper.result.Nodes = []storage.NodeMetricsPoint{}
+			server.tick(context.Background(), time.Now().Add(resolution))
+			code, body := readyz()
+			Expect(code).To(Equal(http.StatusInternalServerError))
+			Expect(body).To(ContainSubstring("[+]informer-synced ok"))
+			Expect(body).To(ContainSubstring("[-]last-scrape-fresh failed"))
+			Expect(body).To(ContainSubstring("[+]storage-nonempty ok"))
+		})
+		It("should report empty storage", func() {
+			store.empty = true
+			code, body := readyz()
+			Expect(code).To(Equal(http.StatusInternalServerError))
+			Expect(body).To(ContainSubstring("[+]informer-synced ok"))
+			Expect(body).To(ContainSubstring("[+]last-scrape-fresh ok"))
+			Expect(body).To(ContainSubstring("[-]storage-nonempty failed"))
+		})
+		It("should report node and pod metrics separately when enabled", func() {
+			server.perResourceReadiness = true
+			store.podsEmpty = true
+			mux = http.NewServeMux()
+			healthz.InstallReadyzHandler(mux, server.ReadyzChecks()...)
+			code, body := readyz()
+			Expect(code).To(Equal(http.StatusInternalServerError))
+			Expect(body).To(ContainSubstring("[+]node-metrics-ready ok"))
+			Expect(body).To(ContainSubstring("[-]pod-metrics-ready failed"))
+			Expect(body).NotTo(ContainSubstring("storage-nonempty"))
+
+			By("passing once pod metrics are stored too")
+			store.podsEmpty = false
+			code, body = readyz()
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(ContainSubstring("[+]pod-metrics-ready ok"))
+		})
 	})
 })
 
 type scraperMock struct {
+	// result, if set, is the batch ScrapeNodes returns for every node
 	result *storage.MetricsBatch
 	err    error
+	// delay makes scrapes take this long, unless their context is done first
+	delay time.Duration
+	// scrapes counts the scrapes of each node by ScrapeNodes
+	scrapes map[string]int
+	// deadline is the context deadline of the last ScrapeNodes
+	deadline time.Time
+	// pods are the pods ScrapeNodes returns for each node
+	pods map[string][]storage.PodMetricsPoint
 }
 
 var _ scraper.Scraper = (*scraperMock)(nil)
 
-func (s *scraperMock) Scrape(ctx context.Context) (*storage.MetricsBatch, error) {
-	return s.result, s.err
+func (s *scraperMock) ScrapeNodes(ctx context.Context, nodes, _ []*corev1.Node) (map[string]*storage.MetricsBatch, error) {
+	s.deadline, _ = ctx.Deadline()
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return map[string]*storage.MetricsBatch{}, ctx.Err()
+	}
+	res := make(map[string]*storage.MetricsBatch, len(nodes))
+	for _, node := range nodes {
+		if s.scrapes == nil {
+			s.scrapes = map[string]int{}
+		}
+		s.scrapes[node.Name]++
+		if s.result != nil {
+			res[node.Name] = s.result
+			continue
+		}
+		res[node.Name] = &storage.MetricsBatch{Nodes: []storage.NodeMetricsPoint{{Name: node.Name}}, Pods: s.pods[node.Name]}
+	}
+	return res, s.err
 }
 
-type storageMock struct{}
+// failingNodeLister fails to list nodes.
+type failingNodeLister struct {
+	v1listers.NodeLister
+}
+
+func (failingNodeLister) List(_ labels.Selector) ([]*corev1.Node, error) {
+	return nil, fmt.Errorf("something went wrong, expectedly")
+}
+
+type storageMock struct {
+	empty     bool
+	podsEmpty bool
+	stored    *storage.MetricsBatch
+}
 
 var _ storage.Storage = (*storageMock)(nil)
 
-func (s *storageMock) Store(batch *storage.MetricsBatch) {}
+func (s *storageMock) Store(batch *storage.MetricsBatch) {
+	s.stored = batch
+}
 
-func (s *storageMock) GetContainerMetrics(pods ...apitypes.NamespacedName) ([]api.TimeInfo, [][]metrics.ContainerMetrics) {
-	return nil, nil
+func (s *storageMock) Empty() bool {
+	return s.empty
+}
+
+func (s *storageMock) PodsEmpty() bool {
+	return s.podsEmpty
 }
 
-func (s *storageMock) GetNodeMetrics(nodes ...string) ([]api.TimeInfo, []corev1.ResourceList) {
+func (s *storageMock) Generation() uint64 {
+	return 0
+}
+
+func (s *storageMock) GetContainerMetrics(_ context.Context, pods ...apitypes.NamespacedName) ([]api.TimeInfo, [][]metrics.ContainerMetrics, error) {
+	return nil, nil, nil
+}
+
+func (s *storageMock) GetNodePodStats(_ context.Context, nodes ...string) ([]api.NodePodStats, error) {
 	return nil, nil
 }
+
+func (s *storageMock) GetNodeMetrics(_ context.Context, nodes ...string) ([]api.TimeInfo, []corev1.ResourceList, error) {
+	return nil, nil, nil
+}
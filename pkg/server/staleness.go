@@ -0,0 +1,103 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+)
+
+// syncedInformer is the part of cache.SharedInformer needed to detect
+// that an informer has synced again after losing its connection.
+type syncedInformer interface {
+	LastSyncResourceVersion() string
+}
+
+// disconnect records when an informer lost its connection to the API server,
+// and the resource version it had synced at that time.
+type disconnect struct {
+	since           time.Time
+	resourceVersion string
+}
+
+// informerStaleness tracks how long informers have been unable to list and
+// watch the Kubernetes API server. Informers keep their last synced snapshot
+// while disconnected, so listers continue to work on possibly stale data.
+type informerStaleness struct {
+	mu           sync.Mutex
+	now          func() time.Time
+	disconnected map[syncedInformer]disconnect
+}
+
+var _ api.ListerStaleness = (*informerStaleness)(nil)
+
+func newInformerStaleness() *informerStaleness {
+	return &informerStaleness{
+		now:          time.Now,
+		disconnected: map[syncedInformer]disconnect{},
+	}
+}
+
+// Track registers a watch error handler on the given informer. It must be
+// called before the informer is started.
+func (s *informerStaleness) Track(informer cache.SharedInformer) error {
+	return informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		s.watchError(informer, err)
+	})
+}
+
+func (s *informerStaleness) watchError(informer syncedInformer, err error) {
+	if err == io.EOF || apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+		// watches are closed or expire during normal operation
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.disconnected[informer]; found {
+		return
+	}
+	klog.Warningf("Lost connection to the API server, serving metrics using the last synced node and pod snapshot: %v", err)
+	s.disconnected[informer] = disconnect{
+		since:           s.now(),
+		resourceVersion: informer.LastSyncResourceVersion(),
+	}
+}
+
+// Staleness returns how long the longest disconnected informer has been out
+// of sync, or zero if all informers have synced since their last failure.
+func (s *informerStaleness) Staleness() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var staleness time.Duration
+	for informer, d := range s.disconnected {
+		if informer.LastSyncResourceVersion() != d.resourceVersion {
+			klog.Infof("Reconnected to the API server after %s", s.now().Sub(d.since))
+			delete(s.disconnected, informer)
+			continue
+		}
+		if since := s.now().Sub(d.since); since > staleness {
+			staleness = since
+		}
+	}
+	return staleness
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Informer staleness", func() {
+	var (
+		now       time.Time
+		staleness *informerStaleness
+		informer  *fakeInformer
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		staleness = newInformerStaleness()
+		staleness.now = func() time.Time { return now }
+		informer = &fakeInformer{resourceVersion: "1"}
+	})
+
+	It("should not be stale while connected", func() {
+		Expect(staleness.Staleness()).To(BeZero())
+	})
+	It("should ignore watches closed during normal operation", func() {
+		staleness.watchError(informer, io.EOF)
+		now = now.Add(time.Minute)
+		Expect(staleness.Staleness()).To(BeZero())
+	})
+	It("should report time since the informer was disconnected", func() {
+		staleness.watchError(informer, fmt.Errorf("connection refused"))
+		now = now.Add(time.Minute)
+		staleness.watchError(informer, fmt.Errorf("connection refused"))
+		now = now.Add(time.Minute)
+		Expect(staleness.Staleness()).To(Equal(2 * time.Minute))
+	})
+	It("should not be stale after the informer synced again", func() {
+		staleness.watchError(informer, fmt.Errorf("connection refused"))
+		now = now.Add(time.Minute)
+		informer.resourceVersion = "2"
+		Expect(staleness.Staleness()).To(BeZero())
+	})
+})
+
+type fakeInformer struct {
+	resourceVersion string
+}
+
+func (i *fakeInformer) LastSyncResourceVersion() string {
+	return i.resourceVersion
+}
@@ -0,0 +1,30 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+)
+
+// writeStatus responds to a request with err as a metav1.Status, like the
+// apiserver responds to failed API requests.
+func writeStatus(w http.ResponseWriter, req *http.Request, err error) {
+	responsewriters.ErrorNegotiated(err, api.Codecs, schema.GroupVersion{Version: "v1"}, w, req)
+}
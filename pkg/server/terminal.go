@@ -0,0 +1,40 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	v1listers "k8s.io/client-go/listers/core/v1"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+// withoutTerminalPods returns the batch without pods which the informer
+// reports in the Succeeded or Failed phase, as Kubelet may still report
+// residual series of them. Pods unknown to the informer are kept.
+func withoutTerminalPods(batch *storage.MetricsBatch, pods v1listers.PodLister) *storage.MetricsBatch {
+	res := &storage.MetricsBatch{
+		Nodes: batch.Nodes,
+		Pods:  make([]storage.PodMetricsPoint, 0, len(batch.Pods)),
+	}
+	for _, point := range batch.Pods {
+		pod, err := pods.Pods(point.Namespace).Get(point.Name)
+		if err == nil && (pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed) {
+			continue
+		}
+		res.Pods = append(res.Pods, point)
+	}
+	return res
+}
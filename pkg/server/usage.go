@@ -0,0 +1,75 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/component-base/metrics"
+
+	"sigs.k8s.io/metrics-server/pkg/storage"
+)
+
+var (
+	scrapedCPUUsage = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "scraped",
+			Name:      "cpu_usage_cores",
+			Help:      "Total CPU usage of all nodes or all pods in the last successful scrape cycle, in cores.",
+		},
+		[]string{"kind"},
+	)
+	scrapedMemoryUsage = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Namespace: "metrics_server",
+			Subsystem: "scraped",
+			Name:      "memory_usage_bytes",
+			Help:      "Total memory working set of all nodes or all pods in the last successful scrape cycle, in bytes.",
+		},
+		[]string{"kind"},
+	)
+)
+
+// usageTotals sums the usage of nodes and of pods in a batch.
+type usageTotals struct {
+	nodeCPU, nodeMemory resource.Quantity
+	podCPU, podMemory   resource.Quantity
+}
+
+func sumUsage(batch *storage.MetricsBatch) usageTotals {
+	var totals usageTotals
+	for _, node := range batch.Nodes {
+		totals.nodeCPU.Add(node.CpuUsage)
+		totals.nodeMemory.Add(node.MemoryUsage)
+	}
+	for _, pod := range batch.Pods {
+		for _, container := range pod.Containers {
+			totals.podCPU.Add(container.CpuUsage)
+			totals.podMemory.Add(container.MemoryUsage)
+		}
+	}
+	return totals
+}
+
+// recordUsage sets the usage gauges to the totals of the batch. Totals are
+// computed before any gauge is set, so that all of them change together at
+// the end of the scrape cycle.
+func recordUsage(batch *storage.MetricsBatch) {
+	totals := sumUsage(batch)
+	scrapedCPUUsage.WithLabelValues("node").Set(float64(totals.nodeCPU.ScaledValue(resource.Nano)) / 1e9)
+	scrapedCPUUsage.WithLabelValues("pod").Set(float64(totals.podCPU.ScaledValue(resource.Nano)) / 1e9)
+	scrapedMemoryUsage.WithLabelValues("node").Set(float64(totals.nodeMemory.Value()))
+	scrapedMemoryUsage.WithLabelValues("pod").Set(float64(totals.podMemory.Value()))
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	apitypes "k8s.io/apimachinery/pkg/types"
+)
+
+// countPodChanges counts the pods of latest missing from previous as added,
+// those sampled again since previous as updated, and those of previous
+// missing from latest as expired.
+func countPodChanges(previous, latest map[apitypes.NamespacedName]PodMetricsPoint) {
+	var added, updated, expired int
+	for ident, pod := range latest {
+		previousPod, found := previous[ident]
+		switch {
+		case !found:
+			added++
+		case podSampledAgain(previousPod, pod):
+			updated++
+		}
+	}
+	for ident := range previous {
+		if _, found := latest[ident]; !found {
+			expired++
+		}
+	}
+	podChanges.WithLabelValues("added").Add(float64(added))
+	podChanges.WithLabelValues("updated").Add(float64(updated))
+	podChanges.WithLabelValues("expired").Add(float64(expired))
+}
+
+// podSampledAgain returns true if the containers of the pod changed or any of
+// them has a different timestamp than before.
+func podSampledAgain(previous, latest PodMetricsPoint) bool {
+	if len(previous.Containers) != len(latest.Containers) {
+		return true
+	}
+	points := make(map[string]MetricsPoint, len(previous.Containers))
+	for _, container := range previous.Containers {
+		points[container.Name] = container.MetricsPoint
+	}
+	for _, container := range latest.Containers {
+		point, found := points[container.Name]
+		if !found || !point.Timestamp.Equal(container.Timestamp) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"path"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// containerMatcher matches container names against exact names and glob
+// patterns, as understood by path.Match.
+type containerMatcher struct {
+	names    sets.String
+	patterns []string
+}
+
+// newContainerMatcher returns a matcher for the names, treating names with
+// glob metacharacters as patterns, nil if there are none. Container names are
+// DNS labels, so they never contain metacharacters themselves.
+func newContainerMatcher(names []string) *containerMatcher {
+	if len(names) == 0 {
+		return nil
+	}
+	m := &containerMatcher{names: sets.NewString()}
+	for _, name := range names {
+		if isContainerNamePattern(name) {
+			m.patterns = append(m.patterns, name)
+		} else {
+			m.names.Insert(name)
+		}
+	}
+	return m
+}
+
+// matches returns true if the name matches any name or pattern, false for a
+// nil matcher.
+func (m *containerMatcher) matches(name string) bool {
+	if m == nil {
+		return false
+	}
+	if m.names.Has(name) {
+		return true
+	}
+	for _, pattern := range m.patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func isContainerNamePattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
+// ValidateContainerNamePattern returns an error if the glob pattern is
+// malformed. Exact names are always valid.
+func ValidateContainerNamePattern(pattern string) error {
+	_, err := path.Match(pattern, "")
+	return err
+}
+
+// withoutExcluded returns the containers whose names aren't excluded, leaving
+// the given containers as they are.
+func (p *storage) withoutExcluded(containers []ContainerMetricsPoint) []ContainerMetricsPoint {
+	if p.excludedContainers == nil {
+		return containers
+	}
+	res := make([]ContainerMetricsPoint, 0, len(containers))
+	for _, container := range containers {
+		if p.excludedContainers.matches(container.Name) {
+			continue
+		}
+		res = append(res, container)
+	}
+	return res
+}
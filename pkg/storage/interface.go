@@ -18,5 +18,11 @@ import "sigs.k8s.io/metrics-server/pkg/api"
 
 type Storage interface {
 	api.MetricsGetter
+	api.MetricsGeneration
+	api.NodePodStatsGetter
 	Store(batch *MetricsBatch)
+	// Empty returns true if no node metrics are currently stored.
+	Empty() bool
+	// PodsEmpty returns true if no pod metrics are currently stored.
+	PodsEmpty() bool
 }
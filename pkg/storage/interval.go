@@ -0,0 +1,77 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	apitypes "k8s.io/apimachinery/pkg/types"
+)
+
+// tooClose returns true if the latest point follows the previous one by less
+// than the minimum sample interval, so that a rate computed over the short
+// window between them would be unstable.
+func (p *storage) tooClose(previous, latest MetricsPoint) bool {
+	if p.minSampleInterval <= 0 || !latest.Timestamp.After(previous.Timestamp) {
+		return false
+	}
+	return latest.Timestamp.Sub(previous.Timestamp) < p.minSampleInterval
+}
+
+// outOfOrder returns true if the latest point is older than the previous one,
+// e.g. when overlapping scrapes of several replicas return in a different
+// order than Kubelet sampled them, and such points are dropped.
+func (p *storage) outOfOrder(previous, latest MetricsPoint) bool {
+	return p.dropOutOfOrder && latest.Timestamp.Before(previous.Timestamp)
+}
+
+// keepPrevious returns true if the previous point stays stored instead of
+// the latest one.
+func (p *storage) keepPrevious(previous, latest MetricsPoint) bool {
+	return p.tooClose(previous, latest) || p.outOfOrder(previous, latest)
+}
+
+// keepSpacedNodes replaces nodes sampled too close to their stored point, or
+// before it, by the stored point. The caller must hold the lock.
+func (p *storage) keepSpacedNodes(nodes map[string]NodeMetricsPoint) {
+	for name, node := range nodes {
+		if previous, found := p.previousNode(name, node); found && p.keepPrevious(previous.MetricsPoint, node.MetricsPoint) {
+			nodes[name] = previous
+		}
+	}
+}
+
+// keepSpacedPods replaces containers sampled too close to their stored point,
+// or before it, by the stored point. The caller must hold the lock.
+func (p *storage) keepSpacedPods(pods map[apitypes.NamespacedName]PodMetricsPoint) {
+	for ident, pod := range pods {
+		previous, found := p.previousPod(ident, pod)
+		if !found {
+			continue
+		}
+		previousContainers := make(map[string]MetricsPoint, len(previous.Containers))
+		for _, container := range previous.Containers {
+			previousContainers[container.Name] = container.MetricsPoint
+		}
+		// replace points in a copy, leaving the containers of the batch as they are
+		containers := make([]ContainerMetricsPoint, len(pod.Containers))
+		for i, container := range pod.Containers {
+			if previousPoint, found := previousContainers[container.Name]; found && p.keepPrevious(previousPoint, container.MetricsPoint) {
+				container.MetricsPoint = previousPoint
+			}
+			containers[i] = container
+		}
+		pod.Containers = containers
+		pods[ident] = pod
+	}
+}
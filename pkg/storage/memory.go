@@ -0,0 +1,94 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"math"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	apitypes "k8s.io/apimachinery/pkg/types"
+)
+
+// MemoryReport decides how the memory working set reported by Kubelet is
+// stored.
+type MemoryReport string
+
+const (
+	// MemoryReportRaw stores the working set as reported, so a drop, e.g.
+	// caused by page cache reclaim, is served right away.
+	MemoryReportRaw MemoryReport = "raw"
+	// MemoryReportSmoothed stores a moving average of the working set, so
+	// sharp drops and spikes are spread over a few scrapes.
+	MemoryReportSmoothed MemoryReport = "smoothed"
+)
+
+// memorySmoothingWeight is the weight of the latest working set in the moving
+// average stored under MemoryReportSmoothed.
+const memorySmoothingWeight = 0.5
+
+// smoothMemory returns the latest point with its memory usage averaged with
+// the previously stored one. Points which aren't newer than the previous one,
+// like those of nodes which weren't scraped this cycle, keep the stored usage.
+func smoothMemory(previous, latest MetricsPoint) MetricsPoint {
+	if latest.MemoryMissing || previous.MemoryMissing {
+		// there is nothing to average with
+		return latest
+	}
+	if !latest.Timestamp.After(previous.Timestamp) {
+		latest.MemoryUsage = previous.MemoryUsage
+		return latest
+	}
+	average := memorySmoothingWeight*float64(latest.MemoryUsage.Value()) + (1-memorySmoothingWeight)*float64(previous.MemoryUsage.Value())
+	latest.MemoryUsage = *resource.NewQuantity(int64(math.Round(average)), resource.BinarySI)
+	return latest
+}
+
+// smoothNodes smooths the memory usage of nodes which are already stored.
+// The caller must hold the lock.
+func (p *storage) smoothNodes(nodes map[string]NodeMetricsPoint) {
+	for name, node := range nodes {
+		previous, found := p.previousNode(name, node)
+		if !found {
+			continue
+		}
+		node.MetricsPoint = smoothMemory(previous.MetricsPoint, node.MetricsPoint)
+		nodes[name] = node
+	}
+}
+
+// smoothPods smooths the memory usage of containers which are already stored.
+// The caller must hold the lock.
+func (p *storage) smoothPods(pods map[apitypes.NamespacedName]PodMetricsPoint) {
+	for ident, pod := range pods {
+		previous, found := p.previousPod(ident, pod)
+		if !found {
+			continue
+		}
+		previousContainers := make(map[string]MetricsPoint, len(previous.Containers))
+		for _, container := range previous.Containers {
+			previousContainers[container.Name] = container.MetricsPoint
+		}
+		// copy the containers, the batch may be stored again
+		containers := make([]ContainerMetricsPoint, len(pod.Containers))
+		for i, container := range pod.Containers {
+			if previousPoint, found := previousContainers[container.Name]; found {
+				container.MetricsPoint = smoothMemory(previousPoint, container.MetricsPoint)
+			}
+			containers[i] = container
+		}
+		pod.Containers = containers
+		pods[ident] = pod
+	}
+}
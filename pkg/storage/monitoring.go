@@ -28,10 +28,48 @@ var (
 		},
 		[]string{"type"},
 	)
+	pointsRejected = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "storage",
+			Name:      "points_rejected_total",
+			Help:      "Number of metrics points rejected because their timestamp was too far from the metrics-server clock.",
+		},
+		[]string{"type"},
+	)
+	podChanges = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "storage",
+			Name:      "pod_changes_total",
+			Help:      "Number of pods added to, updated in and expired from the storage by scrape cycles, and evicted from it when deleted.",
+		},
+		[]string{"change"},
+	)
+	podUIDChanges = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace: "metrics_server",
+			Subsystem: "storage",
+			Name:      "pod_uid_changes_total",
+			Help:      "Number of pods stored under the name of a previously stored pod with a different UID.",
+		},
+	)
 )
 
-// RegisterStorageMetrics registers a gauge metric for the number of metrics
-// points stored.
+// RegisterStorageMetrics registers metrics for the number of metrics
+// points stored and rejected, the pods changed by each cycle and the pods
+// recreated under the same name.
 func RegisterStorageMetrics(registrationFunc func(metrics.Registerable) error) error {
-	return registrationFunc(pointsStored)
+	for _, metric := range []metrics.Registerable{
+		pointsStored,
+		pointsRejected,
+		podChanges,
+		podUIDChanges,
+	} {
+		err := registrationFunc(metric)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,56 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+// NodeKey decides which identity of nodes their stored points are matched by
+// across scrapes, e.g. when smoothing memory usage or keeping samples spaced.
+// Points are always served by node name.
+type NodeKey string
+
+const (
+	// NodeKeyName matches points of nodes with the same name.
+	NodeKeyName NodeKey = "name"
+	// NodeKeyProviderID matches points of nodes with the same provider ID,
+	// so that a node recreated under a new name keeps its history. Nodes
+	// without a provider ID are matched by name.
+	NodeKeyProviderID NodeKey = "provider-id"
+)
+
+// providerIDIndex maps the provider IDs of the nodes to their names.
+func providerIDIndex(nodes map[string]NodeMetricsPoint) map[string]string {
+	index := make(map[string]string, len(nodes))
+	for name, node := range nodes {
+		if node.ProviderID != "" {
+			index[node.ProviderID] = name
+		}
+	}
+	return index
+}
+
+// previousNode returns the stored point matching the node by the node key,
+// renamed after the node. The caller must hold the lock.
+func (p *storage) previousNode(name string, node NodeMetricsPoint) (NodeMetricsPoint, bool) {
+	if p.nodeKey == NodeKeyProviderID && node.ProviderID != "" {
+		storedName, found := p.nodeProviderIDs[node.ProviderID]
+		if !found {
+			return NodeMetricsPoint{}, false
+		}
+		previous := p.nodes[storedName]
+		previous.Name = name
+		return previous, true
+	}
+	previous, found := p.nodes[name]
+	return previous, found
+}
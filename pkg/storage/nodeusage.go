@@ -0,0 +1,107 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	apitypes "k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/metrics-server/pkg/api"
+)
+
+// NodeUsageSource decides where the usage stored for nodes comes from.
+type NodeUsageSource string
+
+const (
+	// NodeUsageKubelet stores the node usage reported by Kubelet, which
+	// includes system daemons and other processes outside of pods.
+	NodeUsageKubelet NodeUsageSource = "kubelet"
+	// NodeUsageSumOfPods stores the sum of the usage of the containers of
+	// the pods reported by the node, leaving out usage outside of pods.
+	NodeUsageSumOfPods NodeUsageSource = "sum-of-pods"
+)
+
+// sumPodsIntoNodes replaces the usage of each node by the summed usage of the
+// containers of its pods, keeping the node timestamp. Nodes without pods get
+// zero usage, and nodes which reported no node metrics at all stay missing.
+func sumPodsIntoNodes(nodes map[string]NodeMetricsPoint, pods map[apitypes.NamespacedName]PodMetricsPoint) {
+	cpu := make(map[string]*resource.Quantity, len(nodes))
+	memory := make(map[string]*resource.Quantity, len(nodes))
+	for name := range nodes {
+		cpu[name] = resource.NewScaledQuantity(0, -9)
+		memory[name] = resource.NewQuantity(0, resource.BinarySI)
+	}
+	for _, pod := range pods {
+		if _, found := nodes[pod.Node]; !found {
+			continue
+		}
+		for _, container := range pod.Containers {
+			cpu[pod.Node].Add(container.CpuUsage)
+			memory[pod.Node].Add(container.MemoryUsage)
+		}
+	}
+	for name, node := range nodes {
+		node.CpuUsage = *cpu[name]
+		node.MemoryUsage = *memory[name]
+		node.CPUMissing, node.MemoryMissing = false, false
+		nodes[name] = node
+	}
+}
+
+// GetNodePodStats counts the stored pods of each of the given nodes and sums
+// the usage of their containers, in a single pass over the stored pods.
+func (p *storage) GetNodePodStats(ctx context.Context, nodes ...string) ([]api.NodePodStats, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	res := make([]api.NodePodStats, len(nodes))
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node] = i
+		res[i].Usage = corev1.ResourceList{}
+	}
+	for _, pod := range p.pods {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		i, found := index[pod.Node]
+		if !found {
+			continue
+		}
+		res[i].Pods++
+		for _, container := range pod.Containers {
+			if !container.CPUMissing {
+				addUsage(res[i].Usage, corev1.ResourceCPU, container.CpuUsage)
+			}
+			if !container.MemoryMissing {
+				addUsage(res[i].Usage, corev1.ResourceMemory, container.MemoryUsage)
+			}
+		}
+	}
+	return res, nil
+}
+
+func addUsage(usage corev1.ResourceList, name corev1.ResourceName, quantity resource.Quantity) {
+	sum, found := usage[name]
+	if !found {
+		usage[name] = quantity.DeepCopy()
+		return
+	}
+	sum.Add(quantity)
+	usage[name] = sum
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"regexp"
+
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// normalizeContainerNames strips the suffix matched by containerNameSuffix from
+// the names of containers, so that containers whose names vary across
+// restarts keep the same key. Names are kept as they are where stripping would
+// make containers of the pod share a name, or leave the name empty.
+func (p *storage) normalizeContainerNames(pod apitypes.NamespacedName, containers []ContainerMetricsPoint) []ContainerMetricsPoint {
+	if p.containerNameSuffix == nil {
+		return containers
+	}
+	normalized := make([]string, len(containers))
+	counts := make(map[string]int, len(containers))
+	for i, container := range containers {
+		normalized[i] = stripSuffix(p.containerNameSuffix, container.Name)
+		counts[normalized[i]]++
+	}
+	// copy the containers, the batch may be stored again
+	res := make([]ContainerMetricsPoint, len(containers))
+	for i, container := range containers {
+		name := normalized[i]
+		if name == "" || counts[name] > 1 {
+			if name != container.Name {
+				klog.V(2).Infof("not normalizing name of container %s in pod %s, it would collide with another container", container.Name, pod)
+			}
+			name = container.Name
+		}
+		container.Name = name
+		res[i] = container
+	}
+	return res
+}
+
+// stripSuffix removes the match of suffix from the name, the suffix being
+// anchored at the end of names.
+func stripSuffix(suffix *regexp.Regexp, name string) string {
+	if loc := suffix.FindStringIndex(name); loc != nil {
+		return name[:loc[0]]
+	}
+	return name
+}
+
+// ContainerNameSuffix compiles the regular expression matching suffixes of
+// container names to strip, anchored at the end of names.
+func ContainerNameSuffix(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?:" + expr + ")$")
+}
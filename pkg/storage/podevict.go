@@ -0,0 +1,111 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// PodDeleteHandler returns an event handler for the pod informer that evicts
+// the stored point of a pod as soon as the pod is deleted, instead of when
+// the next scrape cycle no longer reports it. With a positive batch window,
+// pods deleted within the window after the first delete are evicted together
+// when it ends, so a burst of deletes copies the stored pods once.
+func (p *storage) PodDeleteHandler(batchWindow time.Duration) cache.ResourceEventHandler {
+	h := &podDeleteHandler{store: p, window: batchWindow, afterFunc: afterFunc}
+	return cache.ResourceEventHandlerFuncs{DeleteFunc: h.onDelete}
+}
+
+// afterFunc calls f in its own goroutine after d.
+func afterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
+type podDeleteHandler struct {
+	store  *storage
+	window time.Duration
+	// afterFunc schedules the eviction of a batch.
+	afterFunc func(time.Duration, func())
+
+	mu sync.Mutex
+	// pending are the UIDs of the deleted pods waiting for the batch window to
+	// end, nil if no batch is scheduled.
+	pending map[apitypes.NamespacedName]string
+}
+
+func (h *podDeleteHandler) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	ident := apitypes.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	if h.window <= 0 {
+		h.store.evictPods(map[apitypes.NamespacedName]string{ident: string(pod.UID)})
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pending == nil {
+		h.pending = map[apitypes.NamespacedName]string{}
+		h.afterFunc(h.window, h.flush)
+	}
+	h.pending[ident] = string(pod.UID)
+}
+
+// flush evicts the pods deleted since the batch was scheduled.
+func (h *podDeleteHandler) flush() {
+	h.mu.Lock()
+	pending := h.pending
+	h.pending = nil
+	h.mu.Unlock()
+	h.store.evictPods(pending)
+}
+
+// evictPods removes the stored points of the pods, keyed to the UIDs of the
+// deleted pods, unless they belong to pods recreated under the same name.
+// Stored maps are shared with snapshots, so the pods are copied instead of
+// modified.
+func (p *storage) evictPods(deleted map[apitypes.NamespacedName]string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	evicted := make(map[apitypes.NamespacedName]bool, len(deleted))
+	for ident, uid := range deleted {
+		if previous, found := p.pods[ident]; found && !uidChanged(previous, PodMetricsPoint{UID: uid}) {
+			evicted[ident] = true
+		}
+	}
+	if len(evicted) == 0 {
+		return
+	}
+	pods := make(map[apitypes.NamespacedName]PodMetricsPoint, len(p.pods)-len(evicted))
+	for ident, pod := range p.pods {
+		if !evicted[ident] {
+			pods[ident] = pod
+		}
+	}
+	p.pods = pods
+	p.generation++
+	podChanges.WithLabelValues("evicted").Add(float64(len(evicted)))
+	klog.V(2).Infof("evicted metrics of %d deleted pods", len(evicted))
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// PodUIDChange decides what happens when a pod is reported under the name of
+// a stored pod with a different UID, e.g. a static pod or a pod of a
+// StatefulSet recreated under the same name. Points of pods are always
+// stored and served by namespace and name, so the recreated pod replaces the
+// stored one.
+type PodUIDChange string
+
+const (
+	// PodUIDChangeIgnore matches the recreated pod with the stored one, so it
+	// inherits its history, e.g. when smoothing memory usage or keeping
+	// samples spaced.
+	PodUIDChangeIgnore PodUIDChange = "ignore"
+	// PodUIDChangeReport matches the recreated pod with the stored one, and
+	// logs and counts the change.
+	PodUIDChangeReport PodUIDChange = "report"
+	// PodUIDChangeReset logs and counts the change, and stores the recreated
+	// pod without the history of the previous one, so the samples of both
+	// generations are never mixed.
+	PodUIDChangeReset PodUIDChange = "reset"
+)
+
+// uidChanged returns true if both points report a UID and they differ. Pods
+// reported without a UID are assumed unchanged.
+func uidChanged(previous, latest PodMetricsPoint) bool {
+	return previous.UID != "" && latest.UID != "" && previous.UID != latest.UID
+}
+
+// reportUIDChanges logs and counts the pods whose UID changed since they were
+// stored. The caller must hold the lock.
+func (p *storage) reportUIDChanges(pods map[apitypes.NamespacedName]PodMetricsPoint) {
+	for ident, pod := range pods {
+		if previous, found := p.pods[ident]; found && uidChanged(previous, pod) {
+			klog.V(1).Infof("pod %s was recreated, UID changed from %s to %s", ident, previous.UID, pod.UID)
+			podUIDChanges.Inc()
+		}
+	}
+}
+
+// previousPod returns the stored point of the pod, unless the pod was
+// recreated and its history is reset. The caller must hold the lock.
+func (p *storage) previousPod(ident apitypes.NamespacedName, pod PodMetricsPoint) (PodMetricsPoint, bool) {
+	previous, found := p.pods[ident]
+	if found && p.podUIDChange == PodUIDChangeReset && uidChanged(previous, pod) {
+		return PodMetricsPoint{}, false
+	}
+	return previous, found
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// DefaultSandboxContainerNames are the names runtimes commonly report the
+// sandbox container of pods under.
+var DefaultSandboxContainerNames = []string{"POD", "pause"}
+
+// withoutSandboxes returns the containers whose names aren't sandbox container
+// names, leaving the given containers as they are.
+func (p *storage) withoutSandboxes(containers []ContainerMetricsPoint) []ContainerMetricsPoint {
+	if p.sandboxContainers.Len() == 0 {
+		return containers
+	}
+	res := make([]ContainerMetricsPoint, 0, len(containers))
+	for _, container := range containers {
+		if p.sandboxContainers.Has(container.Name) {
+			continue
+		}
+		res = append(res, container)
+	}
+	return res
+}
+
+// sandboxContainerSet returns the set of the names, nil if there are none.
+func sandboxContainerSet(names []string) sets.String {
+	if len(names) == 0 {
+		return nil
+	}
+	return sets.NewString(names...)
+}
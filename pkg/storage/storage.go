@@ -15,12 +15,16 @@
 package storage
 
 import (
+	"context"
+	"regexp"
+	"sort"
 	"sync"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	apitypes "k8s.io/apimachinery/pkg/types"
-	"k8s.io/klog"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
 	"k8s.io/metrics/pkg/apis/metrics"
 
 	"sigs.k8s.io/metrics-server/pkg/api"
@@ -36,19 +40,162 @@ var kubernetesCadvisorWindow = 30 * time.Second
 type storage struct {
 	mu    sync.RWMutex
 	nodes map[string]NodeMetricsPoint
-	pods  map[apitypes.NamespacedName]PodMetricsPoint
+	// pods are keyed by namespace and name, not UID, so a pod recreated under
+	// the same name replaces the stored one.
+	pods map[apitypes.NamespacedName]PodMetricsPoint
+	// nodeProviderIDs maps the provider IDs of the stored nodes to their names.
+	nodeProviderIDs map[string]string
+	// generation is incremented every time a batch is stored
+	generation uint64
+
+	// maxClockSkew is how far point timestamps may be from now before the
+	// point is rejected, zero disables the check.
+	maxClockSkew time.Duration
+	// minSampleInterval is how long after the stored point of an entity a new
+	// point is stored, zero stores every point.
+	minSampleInterval time.Duration
+	// dropOutOfOrder keeps the stored point of an entity when a new point is
+	// older than it.
+	dropOutOfOrder bool
+	// memoryReport decides whether memory usage is stored as reported or
+	// smoothed.
+	memoryReport MemoryReport
+	// containerNameSuffix, if set, is stripped from container names before
+	// they are stored.
+	containerNameSuffix *regexp.Regexp
+	// nodeUsage decides whether node usage is stored as reported or summed
+	// from the pods of the node.
+	nodeUsage NodeUsageSource
+	// sandboxContainers are the names of sandbox containers left out of pods.
+	sandboxContainers sets.String
+	// nodeKey decides whether stored points of nodes are matched by name or
+	// provider ID.
+	nodeKey NodeKey
+	// podUIDChange decides whether pods recreated under the same name are
+	// reported, and whether they keep the history of the previous pod.
+	podUIDChange PodUIDChange
+	// excludedContainers matches the names of containers left out of pods,
+	// nil if none are.
+	excludedContainers *containerMatcher
+	now                func() time.Time
 }
 
 var _ Storage = (*storage)(nil)
 
 func NewStorage() *storage {
-	return &storage{}
+	return &storage{now: time.Now}
+}
+
+// SetMaxClockSkew makes the storage reject points whose timestamp is further
+// than max from now, usually because the Kubelet clock is off. Zero disables
+// the check.
+func (p *storage) SetMaxClockSkew(max time.Duration) {
+	p.maxClockSkew = max
+}
+
+// SetMinSampleInterval makes the storage keep the stored point of an entity
+// until a new point is at least interval newer. Zero stores every point.
+func (p *storage) SetMinSampleInterval(interval time.Duration) {
+	p.minSampleInterval = interval
+}
+
+// SetDropOutOfOrder decides whether the stored point of an entity is kept
+// when a new point is older than it.
+func (p *storage) SetDropOutOfOrder(drop bool) {
+	p.dropOutOfOrder = drop
+}
+
+// SetMemoryReport decides whether memory usage is stored as reported or
+// smoothed.
+func (p *storage) SetMemoryReport(report MemoryReport) {
+	p.memoryReport = report
+}
+
+// SetContainerNameSuffix makes the storage strip the suffix from container
+// names before they are stored. Nil keeps names as reported.
+func (p *storage) SetContainerNameSuffix(suffix *regexp.Regexp) {
+	p.containerNameSuffix = suffix
+}
+
+// SetNodeUsageSource decides whether node usage is stored as reported or
+// summed from the pods of the node.
+func (p *storage) SetNodeUsageSource(source NodeUsageSource) {
+	p.nodeUsage = source
+}
+
+// SetSandboxContainers makes the storage leave sandbox containers with the
+// given names out of pods.
+func (p *storage) SetSandboxContainers(names []string) {
+	p.sandboxContainers = sandboxContainerSet(names)
+}
+
+// SetNodeKey decides whether stored points of nodes are matched by name or
+// provider ID.
+func (p *storage) SetNodeKey(key NodeKey) {
+	p.nodeKey = key
+}
+
+// SetPodUIDChange decides whether pods recreated under the same name are
+// reported, and whether they keep the history of the previous pod.
+func (p *storage) SetPodUIDChange(change PodUIDChange) {
+	p.podUIDChange = change
+}
+
+// SetExcludedContainers makes the storage leave containers matching the
+// given names or patterns out of pods.
+func (p *storage) SetExcludedContainers(names []string) {
+	p.excludedContainers = newContainerMatcher(names)
+}
+
+// skewed returns true if the timestamp is implausibly far from the
+// metrics-server clock, usually because the Kubelet clock is off.
+func (p *storage) skewed(timestamp time.Time) bool {
+	if p.maxClockSkew <= 0 {
+		return false
+	}
+	skew := timestamp.Sub(p.now())
+	return skew > p.maxClockSkew || skew < -p.maxClockSkew
+}
+
+// Generation returns the number of batches stored so far.
+func (p *storage) Generation() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.generation
+}
+
+// Snapshot returns the currently stored points, nodes sorted by name and pods
+// by namespace and name. Stored points are replaced, never modified, so they
+// are shared with the snapshot.
+func (p *storage) Snapshot() *MetricsBatch {
+	p.mu.RLock()
+	nodes, pods := p.nodes, p.pods
+	p.mu.RUnlock()
+
+	res := &MetricsBatch{
+		Nodes: make([]NodeMetricsPoint, 0, len(nodes)),
+		Pods:  make([]PodMetricsPoint, 0, len(pods)),
+	}
+	for _, node := range nodes {
+		res.Nodes = append(res.Nodes, node)
+	}
+	for _, pod := range pods {
+		res.Pods = append(res.Pods, pod)
+	}
+	sort.Slice(res.Nodes, func(i, j int) bool { return res.Nodes[i].Name < res.Nodes[j].Name })
+	sort.Slice(res.Pods, func(i, j int) bool {
+		if res.Pods[i].Namespace != res.Pods[j].Namespace {
+			return res.Pods[i].Namespace < res.Pods[j].Namespace
+		}
+		return res.Pods[i].Name < res.Pods[j].Name
+	})
+	return res
 }
 
 // TODO(directxman12): figure out what the right value is for "window" --
 // we don't get the actual window from cAdvisor, so we could just
 // plumb down metric resolution, but that wouldn't be actually correct.
-func (p *storage) GetNodeMetrics(nodes ...string) ([]api.TimeInfo, []corev1.ResourceList) {
+func (p *storage) GetNodeMetrics(ctx context.Context, nodes ...string) ([]api.TimeInfo, []corev1.ResourceList, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -56,6 +203,9 @@ func (p *storage) GetNodeMetrics(nodes ...string) ([]api.TimeInfo, []corev1.Reso
 	resMetrics := make([]corev1.ResourceList, len(nodes))
 
 	for i, node := range nodes {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 		metricPoint, present := p.nodes[node]
 		if !present {
 			continue
@@ -65,16 +215,19 @@ func (p *storage) GetNodeMetrics(nodes ...string) ([]api.TimeInfo, []corev1.Reso
 			Timestamp: metricPoint.Timestamp,
 			Window:    kubernetesCadvisorWindow,
 		}
-		resMetrics[i] = corev1.ResourceList{
-			corev1.ResourceName(corev1.ResourceCPU):    metricPoint.CpuUsage,
-			corev1.ResourceName(corev1.ResourceMemory): metricPoint.MemoryUsage,
+		resMetrics[i] = corev1.ResourceList{}
+		if !metricPoint.CPUMissing {
+			resMetrics[i][corev1.ResourceCPU] = metricPoint.CpuUsage
+		}
+		if !metricPoint.MemoryMissing {
+			resMetrics[i][corev1.ResourceMemory] = metricPoint.MemoryUsage
 		}
 	}
 
-	return timestamps, resMetrics
+	return timestamps, resMetrics, nil
 }
 
-func (p *storage) GetContainerMetrics(pods ...apitypes.NamespacedName) ([]api.TimeInfo, [][]metrics.ContainerMetrics) {
+func (p *storage) GetContainerMetrics(ctx context.Context, pods ...apitypes.NamespacedName) ([]api.TimeInfo, [][]metrics.ContainerMetrics, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
@@ -82,6 +235,9 @@ func (p *storage) GetContainerMetrics(pods ...apitypes.NamespacedName) ([]api.Ti
 	resMetrics := make([][]metrics.ContainerMetrics, len(pods))
 
 	for i, pod := range pods {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
 		metricPoint, present := p.pods[pod]
 		if !present {
 			continue
@@ -91,11 +247,14 @@ func (p *storage) GetContainerMetrics(pods ...apitypes.NamespacedName) ([]api.Ti
 		var earliestTS *time.Time
 		for i, contPoint := range metricPoint.Containers {
 			contMetrics[i] = metrics.ContainerMetrics{
-				Name: contPoint.Name,
-				Usage: corev1.ResourceList{
-					corev1.ResourceName(corev1.ResourceCPU):    contPoint.CpuUsage,
-					corev1.ResourceName(corev1.ResourceMemory): contPoint.MemoryUsage,
-				},
+				Name:  contPoint.Name,
+				Usage: corev1.ResourceList{},
+			}
+			if !contPoint.CPUMissing {
+				contMetrics[i].Usage[corev1.ResourceCPU] = contPoint.CpuUsage
+			}
+			if !contPoint.MemoryMissing {
+				contMetrics[i].Usage[corev1.ResourceMemory] = contPoint.MemoryUsage
 			}
 			if earliestTS == nil || earliestTS.After(contPoint.Timestamp) {
 				ts := contPoint.Timestamp // copy to avoid loop iteration variable issues
@@ -112,7 +271,7 @@ func (p *storage) GetContainerMetrics(pods ...apitypes.NamespacedName) ([]api.Ti
 		}
 		resMetrics[i] = contMetrics
 	}
-	return timestamps, resMetrics
+	return timestamps, resMetrics, nil
 }
 
 func (p *storage) Store(batch *MetricsBatch) {
@@ -123,6 +282,11 @@ func (p *storage) Store(batch *MetricsBatch) {
 			klog.Errorf("duplicate node %s received", nodePoint.Name)
 			continue
 		}
+		if p.skewed(nodePoint.Timestamp) {
+			klog.Warningf("rejecting metrics of node %s, timestamp %s is more than %s away from now", nodePoint.Name, nodePoint.Timestamp, p.maxClockSkew)
+			pointsRejected.WithLabelValues("node").Inc()
+			continue
+		}
 		nodeCount += 1
 		newNodes[nodePoint.Name] = nodePoint
 	}
@@ -134,6 +298,12 @@ func (p *storage) Store(batch *MetricsBatch) {
 			klog.Errorf("duplicate pod %s received", podIdent)
 			continue
 		}
+		containers := p.rejectSkewed(podIdent, podPoint.Containers)
+		if len(containers) == 0 && len(podPoint.Containers) != 0 {
+			// all containers were rejected
+			continue
+		}
+		podPoint.Containers = p.withoutExcluded(p.normalizeContainerNames(podIdent, p.withoutSandboxes(containers)))
 		containerCount += len(podPoint.Containers)
 		newPods[podIdent] = podPoint
 	}
@@ -141,8 +311,56 @@ func (p *storage) Store(batch *MetricsBatch) {
 	pointsStored.WithLabelValues("node").Set(float64(nodeCount))
 	pointsStored.WithLabelValues("container").Set(float64(containerCount))
 	p.mu.Lock()
+	if p.podUIDChange == PodUIDChangeReport || p.podUIDChange == PodUIDChangeReset {
+		p.reportUIDChanges(newPods)
+	}
+	if p.minSampleInterval > 0 || p.dropOutOfOrder {
+		p.keepSpacedNodes(newNodes)
+		p.keepSpacedPods(newPods)
+	}
+	if p.nodeUsage == NodeUsageSumOfPods {
+		sumPodsIntoNodes(newNodes, newPods)
+	}
+	if p.memoryReport == MemoryReportSmoothed {
+		p.smoothNodes(newNodes)
+		p.smoothPods(newPods)
+	}
+	countPodChanges(p.pods, newPods)
 	p.nodes = newNodes
 	p.pods = newPods
+	if p.nodeKey == NodeKeyProviderID {
+		p.nodeProviderIDs = providerIDIndex(newNodes)
+	}
+	p.generation++
 	p.mu.Unlock()
 
 }
+
+// rejectSkewed returns the container points of the pod with plausible timestamps.
+func (p *storage) rejectSkewed(pod apitypes.NamespacedName, containers []ContainerMetricsPoint) []ContainerMetricsPoint {
+	if p.maxClockSkew <= 0 {
+		return containers
+	}
+	valid := make([]ContainerMetricsPoint, 0, len(containers))
+	for _, container := range containers {
+		if p.skewed(container.Timestamp) {
+			klog.Warningf("rejecting metrics of container %s in pod %s, timestamp %s is more than %s away from now", container.Name, pod, container.Timestamp, p.maxClockSkew)
+			pointsRejected.WithLabelValues("container").Inc()
+			continue
+		}
+		valid = append(valid, container)
+	}
+	return valid
+}
+
+func (p *storage) Empty() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.nodes) == 0
+}
+
+func (p *storage) PodsEmpty() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.pods) == 0
+}
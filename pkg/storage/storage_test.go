@@ -15,6 +15,8 @@
 package storage
 
 import (
+	"context"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -23,7 +25,9 @@ import (
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/component-base/metrics/testutil"
 	metrics "k8s.io/metrics/pkg/apis/metrics"
 
@@ -84,14 +88,14 @@ var _ = Describe("In-memory storage", func() {
 
 		By("making sure that the storage contains all nodes received")
 		for _, node := range batch.Nodes {
-			ts, metrics := storage.GetNodeMetrics(node.Name)
+			ts, metrics, _ := storage.GetNodeMetrics(context.Background(), node.Name)
 			Expect(ts).To(HaveLen(1))
 			Expect(metrics).To(HaveLen(1))
 		}
 
 		By("making sure that the storage contains all pods received")
 		for _, pod := range batch.Pods {
-			ts, metrics := storage.GetContainerMetrics(apitypes.NamespacedName{
+			ts, metrics, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{
 				Name:      pod.Name,
 				Namespace: pod.Namespace,
 			})
@@ -100,6 +104,32 @@ var _ = Describe("In-memory storage", func() {
 		}
 	})
 
+	It("should report being empty until node metrics are stored", func() {
+		Expect(storage.Empty()).To(BeTrue())
+
+		By("storing the batch")
+		storage.Store(batch)
+		Expect(storage.Empty()).To(BeFalse())
+
+		By("storing a batch without nodes")
+		storage.Store(&MetricsBatch{Pods: batch.Pods})
+		Expect(storage.Empty()).To(BeTrue())
+	})
+
+	It("should report pods being empty independently of nodes", func() {
+		Expect(storage.PodsEmpty()).To(BeTrue())
+
+		By("storing a batch without pods")
+		storage.Store(&MetricsBatch{Nodes: batch.Nodes})
+		Expect(storage.Empty()).To(BeFalse())
+		Expect(storage.PodsEmpty()).To(BeTrue())
+
+		By("storing a batch without nodes")
+		storage.Store(&MetricsBatch{Pods: batch.Pods})
+		Expect(storage.Empty()).To(BeTrue())
+		Expect(storage.PodsEmpty()).To(BeFalse())
+	})
+
 	It("should not error out if duplicate nodes were received, with a partial store", func() {
 		By("adding a duplicate node to the batch")
 		batch.Nodes = append(batch.Nodes, batch.Nodes[0])
@@ -109,14 +139,14 @@ var _ = Describe("In-memory storage", func() {
 
 		By("making sure none of the data is in the storage")
 		for _, node := range batch.Nodes {
-			_, res := storage.GetNodeMetrics(node.Name)
+			_, res, _ := storage.GetNodeMetrics(context.Background(), node.Name)
 			Expect(res).To(ConsistOf(corev1.ResourceList{
 				corev1.ResourceName(corev1.ResourceCPU):    node.CpuUsage,
 				corev1.ResourceName(corev1.ResourceMemory): node.MemoryUsage,
 			}))
 		}
 		for _, pod := range batch.Pods {
-			_, res := storage.GetContainerMetrics(apitypes.NamespacedName{
+			_, res, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{
 				Name:      pod.Name,
 				Namespace: pod.Namespace,
 			})
@@ -133,14 +163,14 @@ var _ = Describe("In-memory storage", func() {
 
 		By("making sure none of the data is in the storage")
 		for _, node := range batch.Nodes {
-			_, res := storage.GetNodeMetrics(node.Name)
+			_, res, _ := storage.GetNodeMetrics(context.Background(), node.Name)
 			Expect(res).To(ConsistOf(corev1.ResourceList{
 				corev1.ResourceName(corev1.ResourceCPU):    node.CpuUsage,
 				corev1.ResourceName(corev1.ResourceMemory): node.MemoryUsage,
 			}))
 		}
 		for _, pod := range batch.Pods {
-			_, res := storage.GetContainerMetrics(apitypes.NamespacedName{
+			_, res, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{
 				Name:      pod.Name,
 				Namespace: pod.Namespace,
 			})
@@ -157,14 +187,14 @@ var _ = Describe("In-memory storage", func() {
 
 		By("ensuring the storage previous cache value for nodes remains")
 		for _, node := range batch.Nodes {
-			ts, metrics := storage.GetNodeMetrics(node.Name)
+			ts, metrics, _ := storage.GetNodeMetrics(context.Background(), node.Name)
 			Expect(ts).To(HaveLen(1))
 			Expect(metrics).To(HaveLen(1))
 		}
 
 		By("ensuring the storage previous cache value for pods remains")
 		for _, pod := range batch.Pods {
-			ts, metrics := storage.GetContainerMetrics(apitypes.NamespacedName{
+			ts, metrics, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{
 				Name:      pod.Name,
 				Namespace: pod.Namespace,
 			})
@@ -179,7 +209,7 @@ var _ = Describe("In-memory storage", func() {
 		storage.Store(batch)
 
 		By("fetching the pod")
-		ts, containerMetrics := storage.GetContainerMetrics(apitypes.NamespacedName{
+		ts, containerMetrics, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{
 			Name:      "pod1",
 			Namespace: "ns1",
 		})
@@ -215,7 +245,7 @@ var _ = Describe("In-memory storage", func() {
 		storage.Store(batch)
 
 		By("fetching the a present pod and a missing pod")
-		ts, containerMetrics := storage.GetContainerMetrics(apitypes.NamespacedName{
+		ts, containerMetrics, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{
 			Name:      "pod1",
 			Namespace: "ns1",
 		}, apitypes.NamespacedName{
@@ -256,7 +286,7 @@ var _ = Describe("In-memory storage", func() {
 		storage.Store(batch)
 
 		By("fetching the nodes")
-		ts, nodeMetrics := storage.GetNodeMetrics("node1", "node2")
+		ts, nodeMetrics, _ := storage.GetNodeMetrics(context.Background(), "node1", "node2")
 
 		By("verifying that the timestamp is the smallest time amongst all containers")
 		Expect(ts).To(Equal([]api.TimeInfo{{Timestamp: now.Add(100 * time.Millisecond), Window: defaultWindow}, {Timestamp: now.Add(200 * time.Millisecond), Window: defaultWindow}}))
@@ -276,12 +306,46 @@ var _ = Describe("In-memory storage", func() {
 		))
 	})
 
+	It("should omit resources missing from nodes", func() {
+		By("storing nodes reporting only one of the resources")
+		batch.Nodes[0].MemoryMissing = true
+		batch.Nodes[1].CPUMissing = true
+		storage.Store(batch)
+
+		By("fetching the nodes")
+		_, nodeMetrics, _ := storage.GetNodeMetrics(context.Background(), "node1", "node2")
+
+		By("verifying that only the reported resources are returned")
+		Expect(nodeMetrics).To(Equal(
+			[]corev1.ResourceList{
+				{corev1.ResourceCPU: *resource.NewMilliQuantity(110, resource.DecimalSI)},
+				{corev1.ResourceMemory: *resource.NewMilliQuantity(220, resource.BinarySI)},
+			},
+		))
+	})
+
+	It("should omit resources missing from containers", func() {
+		By("storing containers with one of the resources missing")
+		batch.Pods[0].Containers[0].CPUMissing = true
+		batch.Pods[0].Containers[1].MemoryMissing = true
+		storage.Store(batch)
+
+		By("fetching the pod")
+		_, res, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"})
+
+		By("verifying that only the other resource is returned")
+		Expect(res).To(Equal([][]metrics.ContainerMetrics{{
+			{Name: "container1", Usage: corev1.ResourceList{corev1.ResourceMemory: *resource.NewMilliQuantity(420, resource.BinarySI)}},
+			{Name: "container2", Usage: corev1.ResourceList{corev1.ResourceCPU: *resource.NewMilliQuantity(510, resource.DecimalSI)}},
+		}}))
+	})
+
 	It("should return nil metrics for missing nodes", func() {
 		By("storing and checking for an error")
 		storage.Store(batch)
 
 		By("fetching the nodes, plus a missing node")
-		ts, nodeMetrics := storage.GetNodeMetrics("node1", "node2", "node42")
+		ts, nodeMetrics, _ := storage.GetNodeMetrics(context.Background(), "node1", "node2", "node42")
 
 		By("verifying that the timestamp is the smallest time amongst all containers")
 		Expect(ts).To(Equal([]api.TimeInfo{{Timestamp: now.Add(100 * time.Millisecond), Window: defaultWindow}, {Timestamp: now.Add(200 * time.Millisecond), Window: defaultWindow}, {}}))
@@ -316,4 +380,719 @@ var _ = Describe("In-memory storage", func() {
 		`), "metrics_server_storage_points")
 		Expect(err).NotTo(HaveOccurred())
 	})
+
+	It("should increment the generation every time a batch is stored", func() {
+		Expect(storage.Generation()).To(Equal(uint64(0)))
+		storage.Store(batch)
+		storage.Store(batch)
+		Expect(storage.Generation()).To(Equal(uint64(2)))
+	})
+
+	It("should count the pods added, updated and expired by each cycle", func() {
+		podChanges.Create(nil)
+		podChanges.Reset()
+		pod := func(name string, ts time.Time) PodMetricsPoint {
+			return PodMetricsPoint{Name: name, Namespace: "ns1", Containers: []ContainerMetricsPoint{
+				{Name: "container1", MetricsPoint: MetricsPoint{Timestamp: ts}},
+			}}
+		}
+
+		storage.Store(&MetricsBatch{Pods: []PodMetricsPoint{pod("pod1", now), pod("pod2", now), pod("pod3", now)}})
+		By("sampling pod1 again, keeping pod2, expiring pod3 and adding pod4")
+		later := now.Add(time.Minute)
+		storage.Store(&MetricsBatch{Pods: []PodMetricsPoint{pod("pod1", later), pod("pod2", now), pod("pod4", later)}})
+
+		err := testutil.CollectAndCompare(podChanges, strings.NewReader(`
+		# HELP metrics_server_storage_pod_changes_total [ALPHA] Number of pods added to, updated in and expired from the storage by scrape cycles, and evicted from it when deleted.
+		# TYPE metrics_server_storage_pod_changes_total counter
+		metrics_server_storage_pod_changes_total{change="added"} 4
+		metrics_server_storage_pod_changes_total{change="updated"} 1
+		metrics_server_storage_pod_changes_total{change="expired"} 1
+		`), "metrics_server_storage_pod_changes_total")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("with a maximum clock skew", func() {
+		BeforeEach(func() {
+			pointsRejected.Create(nil)
+			pointsRejected.Reset()
+			storage = NewStorage()
+			storage.SetMaxClockSkew(time.Minute)
+			storage.now = func() time.Time { return now }
+		})
+
+		It("should reject future-dated and past-dated points", func() {
+			batch.Nodes[0].Timestamp = now.Add(time.Hour)
+			batch.Nodes[1].Timestamp = now.Add(-time.Hour)
+			batch.Pods[0].Containers[0].Timestamp = now.Add(2 * time.Minute)
+			batch.Pods[1].Containers[0].Timestamp = now.Add(-2 * time.Minute)
+
+			storage.Store(batch)
+
+			By("making sure only nodes with plausible timestamps are stored")
+			_, nodeMetrics, _ := storage.GetNodeMetrics(context.Background(), "node1", "node2", "node3")
+			Expect(nodeMetrics[0]).To(BeNil())
+			Expect(nodeMetrics[1]).To(BeNil())
+			Expect(nodeMetrics[2]).NotTo(BeNil())
+
+			By("making sure only containers with plausible timestamps are stored")
+			ts, containerMetrics, _ := storage.GetContainerMetrics(context.Background(),
+				apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"},
+				apitypes.NamespacedName{Name: "pod2", Namespace: "ns1"},
+				apitypes.NamespacedName{Name: "pod1", Namespace: "ns2"},
+			)
+			Expect(containerMetrics[0]).To(HaveLen(1))
+			Expect(containerMetrics[0][0].Name).To(Equal("container2"))
+			Expect(ts[0].Timestamp).To(Equal(now.Add(500 * time.Millisecond)))
+			Expect(containerMetrics[1]).To(BeNil())
+			Expect(containerMetrics[2]).To(HaveLen(2))
+
+			err := testutil.CollectAndCompare(pointsRejected, strings.NewReader(`
+			# HELP metrics_server_storage_points_rejected_total [ALPHA] Number of metrics points rejected because their timestamp was too far from the metrics-server clock.
+			# TYPE metrics_server_storage_points_rejected_total counter
+			metrics_server_storage_points_rejected_total{type="node"} 2
+			metrics_server_storage_points_rejected_total{type="container"} 2
+			`), "metrics_server_storage_points_rejected_total")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should store points again once timestamps are plausible", func() {
+			batch.Nodes[0].Timestamp = now.Add(time.Hour)
+			storage.Store(batch)
+			_, nodeMetrics, _ := storage.GetNodeMetrics(context.Background(), "node1")
+			Expect(nodeMetrics[0]).To(BeNil())
+
+			batch.Nodes[0].Timestamp = now
+			storage.Store(batch)
+			_, nodeMetrics, _ = storage.GetNodeMetrics(context.Background(), "node1")
+			Expect(nodeMetrics[0]).NotTo(BeNil())
+		})
+	})
+
+	Context("with memory usage dropping sharply", func() {
+		memoryPoint := func(ts time.Time, memory int64) MetricsPoint {
+			return MetricsPoint{Timestamp: ts, MemoryUsage: *resource.NewQuantity(memory, resource.BinarySI)}
+		}
+		dropBatch := func(ts time.Time, memory int64) *MetricsBatch {
+			return &MetricsBatch{
+				Nodes: []NodeMetricsPoint{{Name: "node1", MetricsPoint: memoryPoint(ts, memory)}},
+				Pods: []PodMetricsPoint{{Name: "pod1", Namespace: "ns1", Containers: []ContainerMetricsPoint{
+					{Name: "container1", MetricsPoint: memoryPoint(ts, memory)},
+				}}},
+			}
+		}
+		storedMemory := func() (int64, int64) {
+			_, nodeMetrics, _ := storage.GetNodeMetrics(context.Background(), "node1")
+			_, containerMetrics, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"})
+			node := nodeMetrics[0][corev1.ResourceMemory]
+			container := containerMetrics[0][0].Usage[corev1.ResourceMemory]
+			return node.Value(), container.Value()
+		}
+
+		It("should store the drop as reported with raw memory report", func() {
+			storage.Store(dropBatch(now, 1000))
+			storage.Store(dropBatch(now.Add(time.Minute), 200))
+
+			node, container := storedMemory()
+			Expect(node).To(Equal(int64(200)))
+			Expect(container).To(Equal(int64(200)))
+		})
+		It("should spread the drop over a few scrapes with smoothed memory report", func() {
+			storage = NewStorage()
+			storage.SetMemoryReport(MemoryReportSmoothed)
+
+			By("storing the first scrape as reported")
+			storage.Store(dropBatch(now, 1000))
+			node, container := storedMemory()
+			Expect(node).To(Equal(int64(1000)))
+			Expect(container).To(Equal(int64(1000)))
+
+			By("averaging each later scrape with the stored usage")
+			for i, expected := range []int64{600, 400, 300} {
+				storage.Store(dropBatch(now.Add(time.Duration(i+1)*time.Minute), 200))
+				node, container = storedMemory()
+				Expect(node).To(Equal(expected))
+				Expect(container).To(Equal(expected))
+			}
+
+			By("keeping the stored usage when points weren't scraped again")
+			storage.Store(dropBatch(now.Add(3*time.Minute), 200))
+			node, container = storedMemory()
+			Expect(node).To(Equal(int64(300)))
+			Expect(container).To(Equal(int64(300)))
+		})
+	})
+
+	Context("with a node key", func() {
+		nodeBatch := func(ts time.Time, name string, memory int64) *MetricsBatch {
+			return &MetricsBatch{Nodes: []NodeMetricsPoint{
+				{Name: name, ProviderID: "aws:///us-east-1a/i-0123", MetricsPoint: MetricsPoint{
+					Timestamp:   ts,
+					CpuUsage:    *resource.NewMilliQuantity(100, resource.DecimalSI),
+					MemoryUsage: *resource.NewQuantity(memory, resource.BinarySI),
+				}},
+			}}
+		}
+		storedMemory := func(name string) int64 {
+			_, nodeMetrics, err := storage.GetNodeMetrics(context.Background(), name)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodeMetrics[0]).NotTo(BeNil())
+			return nodeMetrics[0].Memory().Value()
+		}
+
+		It("should start a new history for a node recreated under a new name by default", func() {
+			storage = NewStorage()
+			storage.SetMemoryReport(MemoryReportSmoothed)
+			storage.Store(nodeBatch(now, "node1", 1000))
+			storage.Store(nodeBatch(now.Add(time.Minute), "node1-recreated", 200))
+			Expect(storedMemory("node1-recreated")).To(Equal(int64(200)))
+		})
+		It("should keep the history of a node recreated under a new name with the same provider ID", func() {
+			storage = NewStorage()
+			storage.SetMemoryReport(MemoryReportSmoothed)
+			storage.SetNodeKey(NodeKeyProviderID)
+			storage.Store(nodeBatch(now, "node1", 1000))
+			storage.Store(nodeBatch(now.Add(time.Minute), "node1-recreated", 200))
+
+			By("serving the smoothed usage by the new name only")
+			Expect(storedMemory("node1-recreated")).To(Equal(int64(600)))
+			_, nodeMetrics, err := storage.GetNodeMetrics(context.Background(), "node1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(nodeMetrics[0]).To(BeNil())
+		})
+		It("should start a new history for a node with a new provider ID under the same name", func() {
+			storage = NewStorage()
+			storage.SetMemoryReport(MemoryReportSmoothed)
+			storage.SetNodeKey(NodeKeyProviderID)
+			storage.Store(nodeBatch(now, "node1", 1000))
+			batch := nodeBatch(now.Add(time.Minute), "node1", 200)
+			batch.Nodes[0].ProviderID = "aws:///us-east-1a/i-4567"
+			storage.Store(batch)
+			Expect(storedMemory("node1")).To(Equal(int64(200)))
+		})
+		It("should keep the stored point of a renamed node sampled too soon under its new name", func() {
+			storage = NewStorage()
+			storage.SetMinSampleInterval(10 * time.Second)
+			storage.SetNodeKey(NodeKeyProviderID)
+			storage.Store(nodeBatch(now, "node1", 1000))
+			storage.Store(nodeBatch(now.Add(time.Second), "node1-recreated", 200))
+			Expect(storedMemory("node1-recreated")).To(Equal(int64(1000)))
+		})
+	})
+
+	Context("with a pod recreated under the same name", func() {
+		podBatch := func(ts time.Time, uid string, memory int64) *MetricsBatch {
+			return &MetricsBatch{Pods: []PodMetricsPoint{{Name: "pod1", Namespace: "ns1", UID: uid, Containers: []ContainerMetricsPoint{
+				{Name: "app", MetricsPoint: MetricsPoint{
+					Timestamp:   ts,
+					CpuUsage:    *resource.NewMilliQuantity(100, resource.DecimalSI),
+					MemoryUsage: *resource.NewQuantity(memory, resource.BinarySI),
+				}},
+			}}}}
+		}
+		storedMemory := func() int64 {
+			_, containerMetrics, err := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containerMetrics[0]).To(HaveLen(1))
+			return containerMetrics[0][0].Usage.Memory().Value()
+		}
+		BeforeEach(func() {
+			podUIDChanges.Create(nil)
+			podUIDChanges.Reset()
+		})
+		uidChanges := func(count int) error {
+			return testutil.CollectAndCompare(podUIDChanges, strings.NewReader(fmt.Sprintf(`
+		# HELP metrics_server_storage_pod_uid_changes_total [ALPHA] Number of pods stored under the name of a previously stored pod with a different UID.
+		# TYPE metrics_server_storage_pod_uid_changes_total counter
+		metrics_server_storage_pod_uid_changes_total %d
+		`, count)), "metrics_server_storage_pod_uid_changes_total")
+		}
+
+		It("should keep the history of the previous pod by default", func() {
+			storage = NewStorage()
+			storage.SetMemoryReport(MemoryReportSmoothed)
+			storage.Store(podBatch(now, "uid1", 1000))
+			storage.Store(podBatch(now.Add(time.Minute), "uid2", 200))
+			Expect(storedMemory()).To(Equal(int64(600)))
+			Expect(uidChanges(0)).To(Succeed())
+		})
+		It("should report the UID change and keep the history", func() {
+			storage = NewStorage()
+			storage.SetMemoryReport(MemoryReportSmoothed)
+			storage.SetPodUIDChange(PodUIDChangeReport)
+			storage.Store(podBatch(now, "uid1", 1000))
+			storage.Store(podBatch(now.Add(time.Minute), "uid2", 200))
+			Expect(storedMemory()).To(Equal(int64(600)))
+			Expect(uidChanges(1)).To(Succeed())
+		})
+		It("should report the UID change and reset the history", func() {
+			storage = NewStorage()
+			storage.SetMinSampleInterval(10 * time.Second)
+			storage.SetMemoryReport(MemoryReportSmoothed)
+			storage.SetPodUIDChange(PodUIDChangeReset)
+			storage.Store(podBatch(now, "uid1", 1000))
+
+			By("storing the recreated pod as reported, even sampled too soon")
+			storage.Store(podBatch(now.Add(time.Second), "uid2", 200))
+			Expect(storedMemory()).To(Equal(int64(200)))
+			Expect(uidChanges(1)).To(Succeed())
+
+			By("smoothing later points of the same pod")
+			storage.Store(podBatch(now.Add(time.Minute), "uid2", 1000))
+			Expect(storedMemory()).To(Equal(int64(600)))
+			Expect(uidChanges(1)).To(Succeed())
+		})
+		It("should keep the history of pods reported without a UID", func() {
+			storage = NewStorage()
+			storage.SetMemoryReport(MemoryReportSmoothed)
+			storage.SetPodUIDChange(PodUIDChangeReset)
+			storage.Store(podBatch(now, "uid1", 1000))
+			storage.Store(podBatch(now.Add(time.Minute), "", 200))
+			Expect(storedMemory()).To(Equal(int64(600)))
+			Expect(uidChanges(0)).To(Succeed())
+		})
+	})
+
+	Context("with pods deleted", func() {
+		podBatch := func() *MetricsBatch {
+			return &MetricsBatch{Pods: []PodMetricsPoint{
+				{Name: "pod1", Namespace: "ns1", UID: "uid1", Containers: []ContainerMetricsPoint{{Name: "app", MetricsPoint: newMilliPoint(now, 100, 200)}}},
+				{Name: "pod2", Namespace: "ns1", UID: "uid2", Containers: []ContainerMetricsPoint{{Name: "app", MetricsPoint: newMilliPoint(now, 100, 200)}}},
+			}}
+		}
+		deletedPod := func(name, uid string) *corev1.Pod {
+			return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1", UID: apitypes.UID(uid)}}
+		}
+		stored := func() []bool {
+			_, containerMetrics, err := storage.GetContainerMetrics(context.Background(),
+				apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"},
+				apitypes.NamespacedName{Name: "pod2", Namespace: "ns1"},
+			)
+			Expect(err).NotTo(HaveOccurred())
+			return []bool{containerMetrics[0] != nil, containerMetrics[1] != nil}
+		}
+		BeforeEach(func() {
+			storage = NewStorage()
+			storage.Store(podBatch())
+		})
+
+		It("should evict a deleted pod without waiting for the next scrape", func() {
+			generation := storage.Generation()
+			snapshot := storage.Snapshot()
+			storage.PodDeleteHandler(0).OnDelete(deletedPod("pod1", "uid1"))
+			Expect(stored()).To(Equal([]bool{false, true}))
+			Expect(storage.Generation()).To(Equal(generation + 1))
+
+			By("leaving earlier snapshots unchanged")
+			Expect(snapshot.Pods).To(HaveLen(2))
+		})
+		It("should evict a pod deleted while the informer was disconnected", func() {
+			storage.PodDeleteHandler(0).OnDelete(cache.DeletedFinalStateUnknown{Key: "ns1/pod2", Obj: deletedPod("pod2", "uid2")})
+			Expect(stored()).To(Equal([]bool{true, false}))
+		})
+		It("should keep a pod recreated under the name of the deleted one", func() {
+			generation := storage.Generation()
+			storage.PodDeleteHandler(0).OnDelete(deletedPod("pod1", "uid0"))
+			Expect(stored()).To(Equal([]bool{true, true}))
+			Expect(storage.Generation()).To(Equal(generation))
+		})
+		It("should evict a burst of deleted pods together when the batch window ends", func() {
+			var scheduled []func()
+			handler := &podDeleteHandler{store: storage, window: time.Second, afterFunc: func(d time.Duration, f func()) {
+				Expect(d).To(Equal(time.Second))
+				scheduled = append(scheduled, f)
+			}}
+			generation := storage.Generation()
+			for i := 0; i < 10; i++ {
+				handler.onDelete(deletedPod("pod1", "uid1"))
+				handler.onDelete(deletedPod("pod2", "uid2"))
+			}
+			Expect(scheduled).To(HaveLen(1))
+			Expect(stored()).To(Equal([]bool{true, true}))
+
+			scheduled[0]()
+			Expect(stored()).To(Equal([]bool{false, false}))
+			Expect(storage.Generation()).To(Equal(generation + 1))
+
+			By("scheduling a new batch for later deletes")
+			handler.onDelete(deletedPod("pod1", "uid1"))
+			Expect(scheduled).To(HaveLen(2))
+		})
+	})
+
+	Context("with sandbox containers", func() {
+		sandboxBatch := func() *MetricsBatch {
+			return &MetricsBatch{Pods: []PodMetricsPoint{{Name: "pod1", Namespace: "ns1", Containers: []ContainerMetricsPoint{
+				{Name: "app", MetricsPoint: newMilliPoint(now, 100, 200)},
+				{Name: "POD", MetricsPoint: newMilliPoint(now, 1, 10)},
+				{Name: "pause", MetricsPoint: newMilliPoint(now, 1, 10)},
+			}}}}
+		}
+		storedContainers := func() []string {
+			_, containerMetrics, err := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"})
+			Expect(err).NotTo(HaveOccurred())
+			names := make([]string, 0, len(containerMetrics[0]))
+			for _, container := range containerMetrics[0] {
+				names = append(names, container.Name)
+			}
+			return names
+		}
+
+		It("should keep sandbox containers by default", func() {
+			storage.Store(sandboxBatch())
+			Expect(storedContainers()).To(ConsistOf("app", "POD", "pause"))
+		})
+		It("should leave out containers with sandbox names when enabled", func() {
+			storage = NewStorage()
+			storage.SetSandboxContainers(DefaultSandboxContainerNames)
+			batch := sandboxBatch()
+			storage.Store(batch)
+			Expect(storedContainers()).To(Equal([]string{"app"}))
+			Expect(batch.Pods[0].Containers).To(HaveLen(3))
+		})
+		It("should match the configured names only", func() {
+			storage = NewStorage()
+			storage.SetSandboxContainers([]string{"pause"})
+			storage.Store(sandboxBatch())
+			Expect(storedContainers()).To(ConsistOf("app", "POD"))
+		})
+	})
+
+	Context("with excluded containers", func() {
+		sidecarBatch := func() *MetricsBatch {
+			return &MetricsBatch{
+				Nodes: []NodeMetricsPoint{{Name: "node1", MetricsPoint: newMilliPoint(now, 1000, 4000)}},
+				Pods: []PodMetricsPoint{
+					{Name: "pod1", Namespace: "ns1", Node: "node1", Containers: []ContainerMetricsPoint{
+						{Name: "app", MetricsPoint: newMilliPoint(now, 100, 200)},
+						{Name: "monitoring", MetricsPoint: newMilliPoint(now, 20, 50)},
+						{Name: "istio-proxy", MetricsPoint: newMilliPoint(now, 10, 30)},
+					}},
+					{Name: "pod2", Namespace: "ns2", Node: "node1", Containers: []ContainerMetricsPoint{
+						{Name: "db", MetricsPoint: newMilliPoint(now, 300, 400)},
+						{Name: "monitoring", MetricsPoint: newMilliPoint(now, 20, 50)},
+					}},
+				},
+			}
+		}
+		storedContainers := func(pod apitypes.NamespacedName) []string {
+			_, containerMetrics, err := storage.GetContainerMetrics(context.Background(), pod)
+			Expect(err).NotTo(HaveOccurred())
+			names := make([]string, 0, len(containerMetrics[0]))
+			for _, container := range containerMetrics[0] {
+				names = append(names, container.Name)
+			}
+			return names
+		}
+		pod1 := apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"}
+		pod2 := apitypes.NamespacedName{Name: "pod2", Namespace: "ns2"}
+
+		It("should leave out containers with exactly matching names from every pod", func() {
+			storage = NewStorage()
+			storage.SetNodeUsageSource(NodeUsageSumOfPods)
+			storage.SetExcludedContainers([]string{"monitoring", "istio"})
+			storage.Store(sidecarBatch())
+
+			Expect(storedContainers(pod1)).To(ConsistOf("app", "istio-proxy"))
+			Expect(storedContainers(pod2)).To(ConsistOf("db"))
+
+			By("leaving them out of the pod totals")
+			_, nodeMetrics, err := storage.GetNodeMetrics(context.Background(), "node1")
+			Expect(err).NotTo(HaveOccurred())
+			cpu := nodeMetrics[0][corev1.ResourceCPU]
+			Expect(cpu.MilliValue()).To(Equal(int64(410)))
+		})
+		It("should leave out containers matching glob patterns", func() {
+			storage = NewStorage()
+			storage.SetExcludedContainers([]string{"istio-*", "mon?toring"})
+			storage.Store(sidecarBatch())
+
+			Expect(storedContainers(pod1)).To(ConsistOf("app"))
+			Expect(storedContainers(pod2)).To(ConsistOf("db"))
+		})
+	})
+
+	Context("with a node usage source", func() {
+		usageBatch := func() *MetricsBatch {
+			return &MetricsBatch{
+				Nodes: []NodeMetricsPoint{
+					{Name: "node1", MetricsPoint: newMilliPoint(now, 1000, 4000)},
+					{Name: "node2", MetricsPoint: newMilliPoint(now, 500, 600)},
+					{Name: "node3", MetricsPoint: newMilliPoint(now, 100, 100)},
+				},
+				Pods: []PodMetricsPoint{
+					{Name: "pod1", Namespace: "ns1", Node: "node1", Containers: []ContainerMetricsPoint{
+						{Name: "container1", MetricsPoint: newMilliPoint(now, 100, 200)},
+						{Name: "container2", MetricsPoint: newMilliPoint(now, 300, 400)},
+					}},
+					{Name: "pod2", Namespace: "ns1", Node: "node1", Containers: []ContainerMetricsPoint{
+						{Name: "container1", MetricsPoint: newMilliPoint(now, 50, 100)},
+					}},
+					{Name: "pod3", Namespace: "ns2", Node: "node2", Containers: []ContainerMetricsPoint{
+						{Name: "container1", MetricsPoint: newMilliPoint(now, 200, 300)},
+					}},
+				},
+			}
+		}
+		storedUsage := func(node string) (int64, int64) {
+			_, nodeMetrics, err := storage.GetNodeMetrics(context.Background(), node)
+			Expect(err).NotTo(HaveOccurred())
+			cpu := nodeMetrics[0][corev1.ResourceCPU]
+			memory := nodeMetrics[0][corev1.ResourceMemory]
+			return cpu.MilliValue(), memory.MilliValue()
+		}
+
+		It("should store the node usage reported by Kubelet", func() {
+			storage.Store(usageBatch())
+
+			cpu, memory := storedUsage("node1")
+			Expect(cpu).To(Equal(int64(1000)))
+			Expect(memory).To(Equal(int64(4000)))
+		})
+		It("should store the summed usage of the pods of each node", func() {
+			storage = NewStorage()
+			storage.SetNodeUsageSource(NodeUsageSumOfPods)
+			storage.Store(usageBatch())
+
+			By("summing the containers of all pods of the node")
+			cpu, memory := storedUsage("node1")
+			Expect(cpu).To(Equal(int64(450)))
+			Expect(memory).To(Equal(int64(700)))
+
+			By("leaving out the pods of other nodes")
+			cpu, memory = storedUsage("node2")
+			Expect(cpu).To(Equal(int64(200)))
+			Expect(memory).To(Equal(int64(300)))
+
+			By("serving zero usage for nodes without pods")
+			cpu, memory = storedUsage("node3")
+			Expect(cpu).To(Equal(int64(0)))
+			Expect(memory).To(Equal(int64(0)))
+
+			By("keeping the pod metrics as reported")
+			_, containerMetrics, err := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(containerMetrics[0]).To(HaveLen(2))
+		})
+		It("should count and sum the stored pods of each node", func() {
+			storage.Store(usageBatch())
+
+			stats, err := storage.GetNodePodStats(context.Background(), "node1", "node2", "node3", "node4")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stats).To(HaveLen(4))
+
+			By("summing the containers of all pods of the node")
+			Expect(stats[0].Pods).To(Equal(2))
+			cpu, memory := stats[0].Usage[corev1.ResourceCPU], stats[0].Usage[corev1.ResourceMemory]
+			Expect(cpu.MilliValue()).To(Equal(int64(450)))
+			Expect(memory.MilliValue()).To(Equal(int64(700)))
+
+			By("leaving out the pods of other nodes")
+			Expect(stats[1].Pods).To(Equal(1))
+			cpu, memory = stats[1].Usage[corev1.ResourceCPU], stats[1].Usage[corev1.ResourceMemory]
+			Expect(cpu.MilliValue()).To(Equal(int64(200)))
+			Expect(memory.MilliValue()).To(Equal(int64(300)))
+
+			By("serving no pods for nodes without pods or metrics")
+			Expect(stats[2].Pods).To(Equal(0))
+			Expect(stats[2].Usage).To(BeEmpty())
+			Expect(stats[3].Pods).To(Equal(0))
+
+			By("keeping the node usage as reported")
+			cpuMilli, _ := storedUsage("node1")
+			Expect(cpuMilli).To(Equal(int64(1000)))
+		})
+	})
+
+	Context("with a minimum sample interval", func() {
+		sampleBatch := func(ts time.Time, cpu int64) *MetricsBatch {
+			return &MetricsBatch{
+				Nodes: []NodeMetricsPoint{{Name: "node1", MetricsPoint: newMilliPoint(ts, cpu, 200)}},
+				Pods: []PodMetricsPoint{{Name: "pod1", Namespace: "ns1", Containers: []ContainerMetricsPoint{
+					{Name: "container1", MetricsPoint: newMilliPoint(ts, cpu, 200)},
+				}}},
+			}
+		}
+		stored := func() (time.Time, int64, time.Time, int64) {
+			nodeTimes, nodeMetrics, _ := storage.GetNodeMetrics(context.Background(), "node1")
+			podTimes, containerMetrics, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"})
+			node := nodeMetrics[0][corev1.ResourceCPU]
+			container := containerMetrics[0][0].Usage[corev1.ResourceCPU]
+			return nodeTimes[0].Timestamp, node.MilliValue(), podTimes[0].Timestamp, container.MilliValue()
+		}
+		BeforeEach(func() {
+			storage = NewStorage()
+			storage.SetMinSampleInterval(10 * time.Second)
+		})
+
+		It("should keep the stored sample when a new one follows it too closely", func() {
+			storage.Store(sampleBatch(now, 100))
+			storage.Store(sampleBatch(now.Add(time.Second), 900))
+
+			nodeTime, node, podTime, container := stored()
+			Expect(nodeTime).To(Equal(now))
+			Expect(node).To(Equal(int64(100)))
+			Expect(podTime).To(Equal(now))
+			Expect(container).To(Equal(int64(100)))
+		})
+		It("should store a new sample far enough from the stored one", func() {
+			storage.Store(sampleBatch(now, 100))
+			storage.Store(sampleBatch(now.Add(time.Second), 900))
+			storage.Store(sampleBatch(now.Add(15*time.Second), 300))
+
+			nodeTime, node, podTime, container := stored()
+			Expect(nodeTime).To(Equal(now.Add(15 * time.Second)))
+			Expect(node).To(Equal(int64(300)))
+			Expect(podTime).To(Equal(now.Add(15 * time.Second)))
+			Expect(container).To(Equal(int64(300)))
+		})
+		It("should store new entities regardless of the interval", func() {
+			storage.Store(&MetricsBatch{Nodes: []NodeMetricsPoint{{Name: "node2", MetricsPoint: newMilliPoint(now, 100, 200)}}})
+			storage.Store(sampleBatch(now.Add(time.Second), 900))
+
+			_, node, _, container := stored()
+			Expect(node).To(Equal(int64(900)))
+			Expect(container).To(Equal(int64(900)))
+		})
+		It("should space samples and serve windows regardless of the metrics-server clock", func() {
+			storage.now = func() time.Time { return now }
+			storage.Store(sampleBatch(now, 100))
+			// the host clock steps back an hour between receiving both samples
+			storage.now = func() time.Time { return now.Add(-time.Hour) }
+			storage.Store(sampleBatch(now.Add(15*time.Second), 300))
+
+			nodeTime, node, _, _ := stored()
+			Expect(nodeTime).To(Equal(now.Add(15 * time.Second)))
+			Expect(node).To(Equal(int64(300)))
+			nodeTimes, _, _ := storage.GetNodeMetrics(context.Background(), "node1")
+			Expect(nodeTimes[0].Window).To(Equal(kubernetesCadvisorWindow))
+		})
+		It("should store a sample older than the stored one by default", func() {
+			storage = NewStorage()
+			storage.Store(sampleBatch(now, 100))
+			storage.Store(sampleBatch(now.Add(-15*time.Second), 900))
+
+			nodeTime, node, _, container := stored()
+			Expect(nodeTime).To(Equal(now.Add(-15 * time.Second)))
+			Expect(node).To(Equal(int64(900)))
+			Expect(container).To(Equal(int64(900)))
+		})
+		It("should drop samples older than the stored one when reordering", func() {
+			storage = NewStorage()
+			storage.SetDropOutOfOrder(true)
+			storage.Store(sampleBatch(now, 100))
+			storage.Store(sampleBatch(now.Add(-15*time.Second), 900))
+
+			By("keeping the newer stored sample")
+			nodeTime, node, podTime, container := stored()
+			Expect(nodeTime).To(Equal(now))
+			Expect(node).To(Equal(int64(100)))
+			Expect(podTime).To(Equal(now))
+			Expect(container).To(Equal(int64(100)))
+
+			By("storing the next sample in order")
+			storage.Store(sampleBatch(now.Add(time.Second), 300))
+			nodeTime, node, podTime, container = stored()
+			Expect(nodeTime).To(Equal(now.Add(time.Second)))
+			Expect(node).To(Equal(int64(300)))
+			Expect(podTime).To(Equal(now.Add(time.Second)))
+			Expect(container).To(Equal(int64(300)))
+		})
+	})
+
+	Context("with container name normalization", func() {
+		containerNames := func(pod string, names ...string) []string {
+			storage.Store(&MetricsBatch{Pods: []PodMetricsPoint{{Name: pod, Namespace: "ns1", Containers: func() []ContainerMetricsPoint {
+				containers := make([]ContainerMetricsPoint, len(names))
+				for i, name := range names {
+					containers[i] = ContainerMetricsPoint{Name: name, MetricsPoint: newMilliPoint(now, 100, 200)}
+				}
+				return containers
+			}()}}})
+			_, containerMetrics, _ := storage.GetContainerMetrics(context.Background(), apitypes.NamespacedName{Name: pod, Namespace: "ns1"})
+			stored := make([]string, len(containerMetrics[0]))
+			for i, container := range containerMetrics[0] {
+				stored[i] = container.Name
+			}
+			return stored
+		}
+
+		BeforeEach(func() {
+			suffix, err := ContainerNameSuffix(`-[0-9a-f]{5}`)
+			Expect(err).NotTo(HaveOccurred())
+			storage = NewStorage()
+			storage.SetContainerNameSuffix(suffix)
+		})
+
+		It("should strip the matched suffix", func() {
+			Expect(containerNames("pod1", "app-1a2b3", "sidecar-ffff0")).To(Equal([]string{"app", "sidecar"}))
+		})
+		It("should keep the same name across restarts", func() {
+			Expect(containerNames("pod1", "app-1a2b3")).To(Equal([]string{"app"}))
+			Expect(containerNames("pod1", "app-4c5d6")).To(Equal([]string{"app"}))
+		})
+		It("should keep names without the suffix", func() {
+			Expect(containerNames("pod1", "app", "sidecar-x", "proxy-1a2b3c")).To(Equal([]string{"app", "sidecar-x", "proxy-1a2b3c"}))
+		})
+		It("should only strip suffixes at the end of names", func() {
+			Expect(containerNames("pod1", "app-1a2b3-worker")).To(Equal([]string{"app-1a2b3-worker"}))
+		})
+		It("should not merge distinct containers", func() {
+			By("keeping names which would collide with another container")
+			Expect(containerNames("pod1", "app-1a2b3", "app-4c5d6", "sidecar-ffff0")).To(Equal([]string{"app-1a2b3", "app-4c5d6", "sidecar"}))
+			Expect(containerNames("pod2", "app", "app-1a2b3")).To(Equal([]string{"app", "app-1a2b3"}))
+			By("keeping names which would be empty")
+			Expect(containerNames("pod3", "-1a2b3")).To(Equal([]string{"-1a2b3"}))
+		})
+		It("should leave the stored batch unchanged", func() {
+			batch := &MetricsBatch{Pods: []PodMetricsPoint{{Name: "pod1", Namespace: "ns1", Containers: []ContainerMetricsPoint{
+				{Name: "app-1a2b3", MetricsPoint: newMilliPoint(now, 100, 200)},
+			}}}}
+			storage.Store(batch)
+			Expect(batch.Pods[0].Containers[0].Name).To(Equal("app-1a2b3"))
+		})
+		It("should keep names as they are when disabled", func() {
+			storage = NewStorage()
+			Expect(containerNames("pod1", "app-1a2b3")).To(Equal([]string{"app-1a2b3"}))
+		})
+	})
+
+	Context("with a context canceled while reading", func() {
+		It("should stop reading node metrics early", func() {
+			storage.Store(batch)
+			ctx := &cancelingContext{Context: context.Background(), checksLeft: 2}
+
+			_, _, err := storage.GetNodeMetrics(ctx, "node1", "node2", "node3", "node4", "node5")
+			Expect(err).To(Equal(context.Canceled))
+			Expect(ctx.checks).To(Equal(3))
+		})
+		It("should stop reading container metrics early", func() {
+			storage.Store(batch)
+			ctx := &cancelingContext{Context: context.Background(), checksLeft: 1}
+
+			_, _, err := storage.GetContainerMetrics(ctx,
+				apitypes.NamespacedName{Name: "pod1", Namespace: "ns1"},
+				apitypes.NamespacedName{Name: "pod2", Namespace: "ns1"},
+				apitypes.NamespacedName{Name: "pod1", Namespace: "ns2"},
+			)
+			Expect(err).To(Equal(context.Canceled))
+			Expect(ctx.checks).To(Equal(2))
+		})
+	})
 })
+
+// cancelingContext is canceled after its error was checked checksLeft times,
+// so that cancellation happens deterministically in the middle of a read.
+type cancelingContext struct {
+	context.Context
+	checksLeft int
+	checks     int
+}
+
+func (c *cancelingContext) Err() error {
+	c.checks++
+	if c.checks > c.checksLeft {
+		return context.Canceled
+	}
+	return nil
+}
@@ -29,6 +29,8 @@ type MetricsBatch struct {
 // NodeMetricsPoint contains the metrics for some node at some point in time.
 type NodeMetricsPoint struct {
 	Name string
+	// ProviderID is the provider ID of the node object, if any.
+	ProviderID string
 	MetricsPoint
 }
 
@@ -36,6 +38,10 @@ type NodeMetricsPoint struct {
 type PodMetricsPoint struct {
 	Name      string
 	Namespace string
+	// UID is the UID of the pod reported by Kubelet, empty if unknown.
+	UID string
+	// Node is the name of the node whose Kubelet reported the pod.
+	Node string
 
 	Containers []ContainerMetricsPoint
 }
@@ -53,4 +59,19 @@ type MetricsPoint struct {
 	CpuUsage resource.Quantity
 	// MemoryUsage is the working set size, in bytes.
 	MemoryUsage resource.Quantity
+	// CPUMissing and MemoryMissing are set for nodes which reported only one
+	// of the resources, the usage of the missing one is zero and not served.
+	CPUMissing    bool
+	MemoryMissing bool
+	// CumulativeCPU is nil unless cumulative CPU counters are exposed.
+	CumulativeCPU *CumulativeCPU
+}
+
+// CumulativeCPU is a cumulative CPU usage counter reported by Kubelet.
+type CumulativeCPU struct {
+	// CoreNanoSeconds is the CPU time used since the container was created,
+	// summed over all cores.
+	CoreNanoSeconds uint64
+	// Timestamp is the time the counter was sampled.
+	Timestamp time.Time
 }
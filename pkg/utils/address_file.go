@@ -0,0 +1,152 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// nodeAddressFile is the format of the node address file, e.g.
+//
+//	addresses:
+//	  node1: 10.10.0.1
+//	  node2: node2.mgmt.example.com
+type nodeAddressFile struct {
+	Addresses map[string]string `json:"addresses"`
+}
+
+// ParseNodeAddresses parses and validates node addresses by node name in YAML
+// or JSON format. Addresses are IPs or DNS names, without a port.
+func ParseNodeAddresses(data []byte) (map[string]string, error) {
+	var file nodeAddressFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	for node, address := range file.Addresses {
+		if errs := validation.IsDNS1123Subdomain(node); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid node name %q: %s", node, strings.Join(errs, ", "))
+		}
+		if net.ParseIP(address) != nil {
+			continue
+		}
+		if errs := validation.IsDNS1123Subdomain(address); len(errs) > 0 {
+			return nil, fmt.Errorf("node %s: address %q is neither an IP nor a DNS name", node, address)
+		}
+	}
+	return file.Addresses, nil
+}
+
+// NodeAddresses holds the node addresses of a node address file, and reloads
+// them when the file changes.
+type NodeAddresses struct {
+	path string
+
+	mu        sync.RWMutex
+	data      []byte
+	addresses map[string]string
+}
+
+// LoadNodeAddresses reads and validates a node address file.
+func LoadNodeAddresses(path string) (*NodeAddresses, error) {
+	a := &NodeAddresses{path: path}
+	if err := a.reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Address returns the address listed for the given node, if any.
+func (a *NodeAddresses) Address(node string) (string, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	address, ok := a.addresses[node]
+	return address, ok
+}
+
+// Run reloads the file every interval until the context is done. Files which
+// can't be read or are invalid are logged, and the previous addresses are kept.
+func (a *NodeAddresses) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.reload(); err != nil {
+				klog.ErrorS(err, "Failed to reload node address file, keeping previous addresses", "path", a.path)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *NodeAddresses) reload() error {
+	data, err := ioutil.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	a.mu.RLock()
+	unchanged := a.addresses != nil && bytes.Equal(data, a.data)
+	a.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	addresses, err := ParseNodeAddresses(data)
+	if err != nil {
+		return err
+	}
+	if addresses == nil {
+		addresses = map[string]string{}
+	}
+	a.mu.Lock()
+	a.data = data
+	a.addresses = addresses
+	a.mu.Unlock()
+	return nil
+}
+
+// listedNodeAddrResolver resolves addresses of listed nodes from their
+// entry, falling back to a default resolver for other nodes.
+type listedNodeAddrResolver struct {
+	addresses *NodeAddresses
+	fallback  NodeAddressResolver
+}
+
+func (r *listedNodeAddrResolver) NodeAddress(node *corev1.Node) (string, error) {
+	if address, ok := r.addresses.Address(node.Name); ok {
+		return address, nil
+	}
+	return r.fallback.NodeAddress(node)
+}
+
+// NewListedNodeAddressResolver creates a new NodeAddressResolver that resolves
+// addresses of nodes listed in addresses from their entry, taking precedence
+// over address types, and other nodes with the fallback resolver.
+func NewListedNodeAddressResolver(addresses *NodeAddresses, fallback NodeAddressResolver) NodeAddressResolver {
+	return &listedNodeAddrResolver{addresses: addresses, fallback: fallback}
+}
@@ -0,0 +1,112 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Node address file", func() {
+	var (
+		dir  string
+		path string
+	)
+	makeNode := func(name string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		}
+	}
+	write := func(data string) {
+		Expect(ioutil.WriteFile(path, []byte(data), 0600)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "addresses")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "addresses.yaml")
+	})
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("should resolve listed nodes from the file and other nodes normally", func() {
+		write(`
+addresses:
+  node1: 192.168.100.1
+  node2: node2.mgmt.example.com
+`)
+		addresses, err := LoadNodeAddresses(path)
+		Expect(err).NotTo(HaveOccurred())
+		resolver := NewListedNodeAddressResolver(addresses, NewPriorityNodeAddressResolver(DefaultAddressTypePriority))
+
+		for node, address := range map[string]string{
+			"node1": "192.168.100.1",
+			"node2": "node2.mgmt.example.com",
+			"node3": "10.0.0.1",
+		} {
+			got, err := resolver.NodeAddress(makeNode(node))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got).To(Equal(address), "node %s", node)
+		}
+	})
+	It("should apply updates of the file and keep the previous addresses on invalid updates", func() {
+		write(`addresses: {node1: 192.168.100.1}`)
+		addresses, err := LoadNodeAddresses(path)
+		Expect(err).NotTo(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go addresses.Run(ctx, 10*time.Millisecond)
+
+		write(`addresses: {node1: 192.168.100.2}`)
+		Eventually(func() string {
+			address, _ := addresses.Address("node1")
+			return address
+		}).Should(Equal("192.168.100.2"))
+
+		write(`addresses: {node1: "192.168.100.3:10250"}`)
+		Consistently(func() string {
+			address, _ := addresses.Address("node1")
+			return address
+		}, 100*time.Millisecond).Should(Equal("192.168.100.2"))
+	})
+	It("should reject invalid files", func() {
+		for _, data := range []string{
+			`addresses: {node1: "192.168.100.1:10250"}`,
+			`addresses: {node1: "https://node1"}`,
+			`addresses: {Node_1: 192.168.100.1}`,
+			`addresses: [node1]`,
+			`nodes: {node1: 192.168.100.1}`,
+		} {
+			_, err := ParseNodeAddresses([]byte(data))
+			Expect(err).To(HaveOccurred(), data)
+		}
+		_, err := LoadNodeAddresses(filepath.Join(dir, "missing.yaml"))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,117 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// AddressTypeMapping selects the address type priority used for nodes
+// matching a label selector.
+type AddressTypeMapping struct {
+	Selector            labels.Selector
+	AddressTypePriority []corev1.NodeAddressType
+}
+
+// addressTypeMappingFile is the format of the address type mapping file, e.g.
+//
+//	mappings:
+//	- selector: cloud.example.com/pool=bare-metal
+//	  addressTypes: [InternalIP, Hostname]
+type addressTypeMappingFile struct {
+	Mappings []struct {
+		Selector     string   `json:"selector"`
+		AddressTypes []string `json:"addressTypes"`
+	} `json:"mappings"`
+}
+
+// LoadAddressTypeMappings reads and validates an address type mapping file.
+func LoadAddressTypeMappings(path string) ([]AddressTypeMapping, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseAddressTypeMappings(data)
+}
+
+// ParseAddressTypeMappings parses and validates address type mappings in YAML
+// or JSON format.
+func ParseAddressTypeMappings(data []byte) ([]AddressTypeMapping, error) {
+	var file addressTypeMappingFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	mappings := make([]AddressTypeMapping, 0, len(file.Mappings))
+	for i, m := range file.Mappings {
+		if m.Selector == "" {
+			return nil, fmt.Errorf("mapping %d: selector must not be empty", i)
+		}
+		selector, err := labels.Parse(m.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("mapping %d: invalid selector %q: %v", i, m.Selector, err)
+		}
+		if len(m.AddressTypes) == 0 {
+			return nil, fmt.Errorf("mapping %d: addressTypes must not be empty", i)
+		}
+		priority := make([]corev1.NodeAddressType, len(m.AddressTypes))
+		for j, addrType := range m.AddressTypes {
+			priority[j], err = ParseAddressType(addrType)
+			if err != nil {
+				return nil, fmt.Errorf("mapping %d: %v", i, err)
+			}
+		}
+		mappings = append(mappings, AddressTypeMapping{Selector: selector, AddressTypePriority: priority})
+	}
+	return mappings, nil
+}
+
+// mappedNodeAddrResolver resolves node addresses with the priority of the
+// first mapping matching the node labels, falling back to a default resolver.
+type mappedNodeAddrResolver struct {
+	selectors []labels.Selector
+	resolvers []NodeAddressResolver
+	fallback  NodeAddressResolver
+}
+
+func (r *mappedNodeAddrResolver) NodeAddress(node *corev1.Node) (string, error) {
+	nodeLabels := labels.Set(node.Labels)
+	for i, selector := range r.selectors {
+		if selector.Matches(nodeLabels) {
+			return r.resolvers[i].NodeAddress(node)
+		}
+	}
+	return r.fallback.NodeAddress(node)
+}
+
+// NewMappedNodeAddressResolver creates a new NodeAddressResolver that resolves
+// addresses using the priority of the first mapping matching the node labels,
+// or typePriority for nodes not matching any mapping.
+func NewMappedNodeAddressResolver(mappings []AddressTypeMapping, typePriority []corev1.NodeAddressType) NodeAddressResolver {
+	r := &mappedNodeAddrResolver{
+		selectors: make([]labels.Selector, len(mappings)),
+		resolvers: make([]NodeAddressResolver, len(mappings)),
+		fallback:  NewPriorityNodeAddressResolver(typePriority),
+	}
+	for i, m := range mappings {
+		r.selectors[i] = m.Selector
+		r.resolvers[i] = NewPriorityNodeAddressResolver(m.AddressTypePriority)
+	}
+	return r
+}
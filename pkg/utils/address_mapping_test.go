@@ -0,0 +1,90 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Address type mappings", func() {
+	makeNode := func(name string, nodeLabels map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{
+					{Type: corev1.NodeHostName, Address: name + ".example.com"},
+					{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+					{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+				},
+			},
+		}
+	}
+
+	It("should resolve addresses per node using the first matching mapping", func() {
+		mappings, err := ParseAddressTypeMappings([]byte(`
+mappings:
+- selector: pool=bare-metal
+  addressTypes: [InternalIP]
+- selector: pool in (edge, bare-metal)
+  addressTypes: [ExternalIP, InternalIP]
+`))
+		Expect(err).NotTo(HaveOccurred())
+		resolver := NewMappedNodeAddressResolver(mappings, DefaultAddressTypePriority)
+
+		for _, tc := range []struct {
+			node    *corev1.Node
+			address string
+		}{
+			{node: makeNode("bare-metal", map[string]string{"pool": "bare-metal"}), address: "10.0.0.1"},
+			{node: makeNode("edge", map[string]string{"pool": "edge"}), address: "203.0.113.1"},
+			{node: makeNode("other-pool", map[string]string{"pool": "other"}), address: "other-pool.example.com"},
+			{node: makeNode("unlabeled", nil), address: "unlabeled.example.com"},
+		} {
+			address, err := resolver.NodeAddress(tc.node)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(address).To(Equal(tc.address), "node %s", tc.node.Name)
+		}
+	})
+	It("should not fall back to the global priority for mapped nodes", func() {
+		mappings, err := ParseAddressTypeMappings([]byte(`
+mappings:
+- selector: pool=edge
+  addressTypes: [ExternalDNS]
+`))
+		Expect(err).NotTo(HaveOccurred())
+		resolver := NewMappedNodeAddressResolver(mappings, DefaultAddressTypePriority)
+
+		_, err = resolver.NodeAddress(makeNode("edge", map[string]string{"pool": "edge"}))
+		Expect(err).To(HaveOccurred())
+	})
+	It("should reject invalid mappings", func() {
+		for _, data := range []string{
+			`mappings: [{selector: "pool=a=b", addressTypes: [InternalIP]}]`,
+			`mappings: [{selector: "pool in (", addressTypes: [InternalIP]}]`,
+			`mappings: [{selector: "", addressTypes: [InternalIP]}]`,
+			`mappings: [{selector: "pool=edge", addressTypes: []}]`,
+			`mappings: [{selector: "pool=edge", addressTypes: [PrivateIP]}]`,
+			`mappings: [{selector: "pool=edge", addressTypes: [InternalIP], priority: 1}]`,
+			`mappings: {selector: "pool=edge"}`,
+		} {
+			_, err := ParseAddressTypeMappings([]byte(data))
+			Expect(err).To(HaveOccurred(), data)
+		}
+	})
+})
@@ -0,0 +1,113 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NodeAddressPins holds the address each node was first resolved to, until
+// the addresses of the node change. It handles events of the node informer
+// to forget the pins of updated and deleted nodes.
+type NodeAddressPins struct {
+	mu        sync.RWMutex
+	addresses map[string]string
+}
+
+var _ cache.ResourceEventHandler = (*NodeAddressPins)(nil)
+
+// NewNodeAddressPins creates empty node address pins.
+func NewNodeAddressPins() *NodeAddressPins {
+	return &NodeAddressPins{addresses: map[string]string{}}
+}
+
+// Address returns the address pinned for the given node, if any.
+func (p *NodeAddressPins) Address(node string) (string, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	address, ok := p.addresses[node]
+	return address, ok
+}
+
+func (p *NodeAddressPins) pin(node, address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addresses[node] = address
+}
+
+func (p *NodeAddressPins) forget(node string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.addresses, node)
+}
+
+// OnAdd does nothing, nodes are pinned when they are first resolved.
+func (p *NodeAddressPins) OnAdd(obj interface{}) {}
+
+// OnUpdate forgets the pin of the node if its addresses changed. Resyncs and
+// status updates keeping the addresses leave the pin in place.
+func (p *NodeAddressPins) OnUpdate(oldObj, newObj interface{}) {
+	oldNode, ok := oldObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	newNode, ok := newObj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	if !apiequality.Semantic.DeepEqual(oldNode.Status.Addresses, newNode.Status.Addresses) {
+		p.forget(newNode.Name)
+	}
+}
+
+// OnDelete forgets the pin of the node.
+func (p *NodeAddressPins) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if node, ok := obj.(*corev1.Node); ok {
+		p.forget(node.Name)
+	}
+}
+
+// pinnedNodeAddrResolver resolves nodes to their pinned address, pinning
+// the address resolved by a default resolver for nodes without one.
+type pinnedNodeAddrResolver struct {
+	pins     *NodeAddressPins
+	resolver NodeAddressResolver
+}
+
+func (r *pinnedNodeAddrResolver) NodeAddress(node *corev1.Node) (string, error) {
+	if address, ok := r.pins.Address(node.Name); ok {
+		return address, nil
+	}
+	address, err := r.resolver.NodeAddress(node)
+	if err != nil {
+		return "", err
+	}
+	r.pins.pin(node.Name, address)
+	return address, nil
+}
+
+// NewPinnedNodeAddressResolver creates a new NodeAddressResolver that resolves
+// each node once with the given resolver, and then to the same address until
+// pins forgets it.
+func NewPinnedNodeAddressResolver(pins *NodeAddressPins, resolver NodeAddressResolver) NodeAddressResolver {
+	return &pinnedNodeAddrResolver{pins: pins, resolver: resolver}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 The Kubernetes Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var _ = Describe("Node address pins", func() {
+	var (
+		pins     *NodeAddressPins
+		resolver NodeAddressResolver
+	)
+	makeNode := func(addresses ...string) *corev1.Node {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+		for _, address := range addresses {
+			node.Status.Addresses = append(node.Status.Addresses, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: address})
+		}
+		return node
+	}
+	resolve := func(node *corev1.Node) string {
+		address, err := resolver.NodeAddress(node)
+		Expect(err).NotTo(HaveOccurred())
+		return address
+	}
+
+	BeforeEach(func() {
+		pins = NewNodeAddressPins()
+		resolver = NewPinnedNodeAddressResolver(pins, NewPriorityNodeAddressResolver(DefaultAddressTypePriority))
+	})
+
+	It("should keep resolving nodes to their first address", func() {
+		Expect(resolve(makeNode("10.0.0.1", "10.0.0.2"))).To(Equal("10.0.0.1"))
+		Expect(resolve(makeNode("10.0.0.2", "10.0.0.1"))).To(Equal("10.0.0.1"))
+	})
+	It("should refresh the pin when an update changes the addresses", func() {
+		old := makeNode("10.0.0.1")
+		Expect(resolve(old)).To(Equal("10.0.0.1"))
+
+		updated := makeNode("10.0.0.2")
+		pins.OnUpdate(old, updated)
+		Expect(resolve(updated)).To(Equal("10.0.0.2"))
+	})
+	It("should keep the pin on updates keeping the addresses", func() {
+		old := makeNode("10.0.0.1", "10.0.0.2")
+		Expect(resolve(old)).To(Equal("10.0.0.1"))
+
+		resynced := old.DeepCopy()
+		resynced.ResourceVersion = "2"
+		pins.OnUpdate(old, resynced)
+		_, pinned := pins.Address("node1")
+		Expect(pinned).To(BeTrue())
+	})
+	It("should forget the pin of deleted nodes", func() {
+		node := makeNode("10.0.0.1")
+		resolve(node)
+		pins.OnDelete(cache.DeletedFinalStateUnknown{Key: "node1", Obj: node})
+
+		_, pinned := pins.Address("node1")
+		Expect(pinned).To(BeFalse())
+	})
+	It("should not pin nodes failing to resolve", func() {
+		_, err := resolver.NodeAddress(makeNode())
+		Expect(err).To(HaveOccurred())
+
+		_, pinned := pins.Address("node1")
+		Expect(pinned).To(BeFalse())
+	})
+})
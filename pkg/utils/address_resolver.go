@@ -16,6 +16,7 @@ package utils
 
 import (
 	"fmt"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 )
@@ -39,6 +40,27 @@ var (
 	}
 )
 
+// ParseAddressType returns the node address type matching the given value.
+// Matching ignores case, dashes and underscores, so "internalip" and
+// "Internal-IP" both parse as InternalIP.
+func ParseAddressType(value string) (corev1.NodeAddressType, error) {
+	normalized := normalizeAddressType(value)
+	for _, addrType := range DefaultAddressTypePriority {
+		if normalizeAddressType(string(addrType)) == normalized {
+			return addrType, nil
+		}
+	}
+	valid := make([]string, len(DefaultAddressTypePriority))
+	for i, addrType := range DefaultAddressTypePriority {
+		valid[i] = string(addrType)
+	}
+	return "", fmt.Errorf("unknown address type %q, must be one of %s", value, strings.Join(valid, ", "))
+}
+
+func normalizeAddressType(value string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(strings.TrimSpace(value)))
+}
+
 // NodeAddressResolver knows how to find the preferred connection
 // address for a given node.
 type NodeAddressResolver interface {